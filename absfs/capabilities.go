@@ -0,0 +1,128 @@
+package absfs
+
+import (
+	"os"
+	"strings"
+)
+
+// Linker is a FileSystem that supports hard links.
+type Linker interface {
+	// Link creates newname as a hard link to the oldname file. If there is
+	// an error, it will be of type *LinkError.
+	Link(oldname, newname string) error
+}
+
+// Symlinker is a FileSystem that supports symbolic links.
+type Symlinker interface {
+	Symlink(oldname, newname string) error
+	Readlink(name string) (string, error)
+	Lstat(name string) (os.FileInfo, error)
+}
+
+// Truncater is a FileSystem that can change the size of files by name.
+type Truncater interface {
+	Truncate(name string, size int64) error
+}
+
+// Syncer is a FileSystem that can commit all of its files to stable
+// storage at once.
+type Syncer interface {
+	Sync() error
+}
+
+// Xattrer is a FileSystem that supports extended attributes.
+type Xattrer interface {
+	// Getxattr returns the value of the extended attribute attr of the
+	// named file.
+	Getxattr(name, attr string) ([]byte, error)
+
+	// Setxattr sets the extended attribute attr of the named file.
+	Setxattr(name, attr string, value []byte) error
+
+	// Listxattr returns the names of the extended attributes of the named
+	// file.
+	Listxattr(name string) ([]string, error)
+
+	// Removexattr removes the extended attribute attr of the named file.
+	Removexattr(name, attr string) error
+}
+
+// Tagger is a FileSystem that supports user-defined tags on files.
+type Tagger interface {
+	// Tag sets the tag key of the named file to value.
+	Tag(name, key, value string) error
+
+	// Tags returns the tags of the named file.
+	Tags(name string) (map[string]string, error)
+}
+
+// ReadDirPlusser is a FileSystem that can list a directory together with
+// the information Lstat would return for each entry, without a round trip
+// per entry.
+type ReadDirPlusser interface {
+	// ReadDirPlus returns the entries of the directory dirname, sorted by
+	// name, as Lstat would describe them.
+	ReadDirPlus(dirname string) ([]os.FileInfo, error)
+}
+
+// Capability is a set of optional features of a FileSystem.
+type Capability uint
+
+const (
+	CapLink Capability = 1 << iota
+	CapSymlink
+	CapTruncate
+	CapSync
+	CapXattr
+)
+
+var capNames = []string{"link", "symlink", "truncate", "sync", "xattr"}
+
+// Has reports whether c includes all of the capabilities in other.
+func (c Capability) Has(other Capability) bool {
+	return c&other == other
+}
+
+func (c Capability) String() string {
+	var out []string
+	for i, name := range capNames {
+		if c&(1<<uint(i)) != 0 {
+			out = append(out, name)
+		}
+	}
+	return strings.Join(out, "|")
+}
+
+// CapabilityReporter is implemented by FileSystems that know which of their
+// optional methods work, such as wrappers that implement every method but
+// whose support depends on the FileSystem they wrap.
+type CapabilityReporter interface {
+	Capabilities() Capability
+}
+
+// Capabilities returns the optional features fs supports. If fs is a
+// CapabilityReporter its answer is returned, otherwise the capabilities are
+// detected from the interfaces fs implements.
+func Capabilities(fs interface{}) Capability {
+	if r, ok := fs.(CapabilityReporter); ok {
+		return r.Capabilities()
+	}
+
+	var c Capability
+	if _, ok := fs.(Linker); ok {
+		c |= CapLink
+	}
+	if _, ok := fs.(Symlinker); ok {
+		c |= CapSymlink
+	}
+	if _, ok := fs.(Truncater); ok {
+		c |= CapTruncate
+	}
+	if _, ok := fs.(Syncer); ok {
+		c |= CapSync
+	}
+	if _, ok := fs.(Xattrer); ok {
+		c |= CapXattr
+	}
+	return c
+}
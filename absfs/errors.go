@@ -0,0 +1,42 @@
+package absfs
+
+import (
+	"os"
+	"syscall"
+)
+
+// Errors returned by the FileSystems of this module, wrapped in an
+// *os.PathError or *os.LinkError. They read the same on every platform.
+// errors.Is matches each one with the syscall.Errno of the same meaning,
+// and with the os error that Errno matches on Unix, like os.ErrExist for
+// ErrNotEmpty.
+//
+// Files that don't exist or already exist, and operations that aren't
+// permitted, are reported with syscall.ENOENT, syscall.EEXIST and
+// syscall.EPERM, so os.IsNotExist and friends keep working.
+var (
+	ErrNotDir        error = &Error{"not a directory", syscall.ENOTDIR, nil}
+	ErrIsDir         error = &Error{"is a directory", syscall.EISDIR, nil}
+	ErrNotEmpty      error = &Error{"directory not empty", syscall.ENOTEMPTY, os.ErrExist}
+	ErrReadOnly      error = &Error{"read-only file", syscall.EROFS, nil}
+	ErrQuotaExceeded error = &Error{"quota exceeded", syscall.EDQUOT, nil}
+	ErrLocked        error = &Error{"file is locked", syscall.EWOULDBLOCK, nil}
+	ErrBadFile       error = &Error{"bad file descriptor", syscall.EBADF, nil}
+	ErrInvalid       error = &Error{"invalid argument", syscall.EINVAL, nil}
+)
+
+// An Error is one of the errors above.
+type Error struct {
+	msg   string
+	errno syscall.Errno
+	os    error
+}
+
+func (e *Error) Error() string {
+	return e.msg
+}
+
+// Is reports whether target is the syscall.Errno or os error e matches.
+func (e *Error) Is(target error) bool {
+	return target == e.errno || e.os != nil && target == e.os
+}
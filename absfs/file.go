@@ -2,6 +2,7 @@ package absfs
 
 import (
 	"syscall"
+	"time"
 
 	"os"
 )
@@ -86,6 +87,73 @@ type File interface {
 	Readdirnames(n int) (names []string, err error)
 }
 
+// DeadlineFile is a File that supports deadlines like os.File. Files on
+// which no operation can block return os.ErrNoDeadline from the setters.
+type DeadlineFile interface {
+	File
+
+	// SetDeadline sets the read and write deadlines of the File.
+	SetDeadline(t time.Time) error
+
+	// SetReadDeadline sets the deadline for future Read calls and any
+	// currently-blocked Read call. A zero value for t means Read will not
+	// time out.
+	SetReadDeadline(t time.Time) error
+
+	// SetWriteDeadline sets the deadline for future Write calls and any
+	// currently-blocked Write call. A zero value for t means Write will not
+	// time out.
+	SetWriteDeadline(t time.Time) error
+}
+
+// LockFile is a File that supports advisory locks. Locks are held on the
+// whole file, only exclude other users of the lock API, and are released
+// when the File is closed.
+type LockFile interface {
+	File
+
+	// Lock places an exclusive lock on the File, blocking until it is
+	// available.
+	Lock() error
+
+	// RLock places a shared lock on the File, blocking until it is
+	// available.
+	RLock() error
+
+	// TryLock tries to place an exclusive lock on the File without
+	// blocking, and reports whether it succeeded.
+	TryLock() (bool, error)
+
+	// TryRLock tries to place a shared lock on the File without blocking,
+	// and reports whether it succeeded.
+	TryRLock() (bool, error)
+
+	// Unlock removes the lock held on the File.
+	Unlock() error
+}
+
+// AttrFile is a File whose mode and owner can be changed through the open
+// handle, like os.File.
+type AttrFile interface {
+	File
+
+	// Chmod changes the mode of the File.
+	Chmod(mode os.FileMode) error
+
+	// Chown changes the numeric uid and gid of the File.
+	Chown(uid, gid int) error
+}
+
+// ChtimesFile is a File whose access and modification times can be
+// changed through the open handle, like futimes, so they can be set before
+// the File is closed.
+type ChtimesFile interface {
+	File
+
+	// Chtimes changes the access and modification times of the File.
+	Chtimes(atime, mtime time.Time) error
+}
+
 // InvalidFile is a no-op implementation of File that can be returned from any
 // file open methods when an error occurs. InvalidFile mimics the behavior of
 // file handles returnd by the `os` package when there is an error.
@@ -0,0 +1,167 @@
+package absfs
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+)
+
+// IOFS is an fs.FS that reads the files under a directory of a
+// FileSystem. Names are slash-separated paths relative to that directory,
+// as io/fs requires, so names can't refer to files outside of it. Symbolic
+// links are followed as the FileSystem follows them, and may lead outside
+// of it.
+type IOFS struct {
+	fs   FileSystem
+	root string
+}
+
+// NewIOFS returns an IOFS that reads the files under the directory root
+// of fsys.
+func NewIOFS(fsys FileSystem, root string) *IOFS {
+	return &IOFS{fs: fsys, root: root}
+}
+
+// path returns the path of the file name in the FileSystem.
+func (f *IOFS) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	if name == "." {
+		return f.root, nil
+	}
+
+	sep := string(f.fs.Separator())
+	if sep != "/" {
+		name = strings.ReplaceAll(name, "/", sep)
+	}
+	if strings.HasSuffix(f.root, sep) {
+		return f.root + name, nil
+	}
+	return f.root + sep + name, nil
+}
+
+// pathError replaces the path of the *fs.PathError err with name, so
+// errors refer to names as they were passed.
+func pathError(err error, name string) error {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return &fs.PathError{Op: pe.Op, Path: name, Err: pe.Err}
+	}
+	return err
+}
+
+// Open opens the named file. Directories implement fs.ReadDirFile.
+func (f *IOFS) Open(name string) (fs.File, error) {
+	p, err := f.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	file, err := f.fs.Open(p)
+	if err != nil {
+		return nil, pathError(err, name)
+	}
+
+	return &ioFile{File: file}, nil
+}
+
+// Stat returns a FileInfo describing the named file.
+func (f *IOFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := f.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.fs.Stat(p)
+	if err != nil {
+		return nil, pathError(err, name)
+	}
+
+	return info, nil
+}
+
+// ReadFile reads the named file and returns its contents.
+func (f *IOFS) ReadFile(name string) ([]byte, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	b, err := io.ReadAll(file)
+	if err != nil {
+		return nil, pathError(err, name)
+	}
+
+	return b, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by
+// name.
+func (f *IOFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	dir, ok := file.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not implemented")}
+	}
+	entries, err := dir.ReadDir(-1)
+	if err != nil {
+		return nil, pathError(err, name)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	return entries, nil
+}
+
+// Sub returns an IOFS of the files under the directory dir, like fs.Sub.
+// The returned IOFS can't open files outside of dir.
+func (f *IOFS) Sub(dir string) (fs.FS, error) {
+	p, err := f.path("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.fs.Stat(p)
+	if err != nil {
+		return nil, pathError(err, dir)
+	}
+	if !info.IsDir() {
+		return nil, &fs.PathError{Op: "sub", Path: dir, Err: ErrNotDir}
+	}
+
+	return &IOFS{fs: f.fs, root: p}, nil
+}
+
+// ioFile is a File that implements fs.ReadDirFile.
+type ioFile struct {
+	File
+}
+
+// ReadAt reads like File.ReadAt, but returns io.EOF when fewer than len(b)
+// bytes are read, as io.ReaderAt requires.
+func (f *ioFile) ReadAt(b []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(b, off)
+	if err == nil && n < len(b) {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func (f *ioFile) ReadDir(n int) ([]fs.DirEntry, error) {
+	infos, err := f.Readdir(n)
+	entries := make([]fs.DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = fs.FileInfoToDirEntry(info)
+	}
+	if n <= 0 && err == io.EOF {
+		err = nil
+	}
+
+	return entries, err
+}
@@ -0,0 +1,644 @@
+package absfs
+
+import (
+	"os"
+	"time"
+)
+
+// Op identifies the FileSystem or File operation being intercepted. The
+// values match the Op strings used in *os.PathError where one exists.
+type Op string
+
+const (
+	OpOpen      Op = "open"
+	OpMkdir     Op = "mkdir"
+	OpMkdirAll  Op = "mkdirall"
+	OpRemove    Op = "remove"
+	OpRemoveAll Op = "removeall"
+	OpRename    Op = "rename"
+	OpStat      Op = "stat"
+	OpLstat     Op = "lstat"
+	OpChmod     Op = "chmod"
+	OpChtimes   Op = "chtimes"
+	OpChown     Op = "chown"
+	OpLchown    Op = "lchown"
+	OpChdir     Op = "chdir"
+	OpTruncate  Op = "truncate"
+	OpReadlink  Op = "readlink"
+	OpSymlink   Op = "symlink"
+	OpLink      Op = "link"
+
+	// Extended attribute operations.
+	OpGetxattr    Op = "getxattr"
+	OpSetxattr    Op = "setxattr"
+	OpListxattr   Op = "listxattr"
+	OpRemovexattr Op = "removexattr"
+
+	// File handle operations.
+	OpRead         Op = "read"
+	OpWrite        Op = "write"
+	OpSeek         Op = "seek"
+	OpSync         Op = "sync"
+	OpClose        Op = "close"
+	OpFstat        Op = "fstat"
+	OpFtruncate    Op = "ftruncate"
+	OpReaddir      Op = "readdir"
+	OpReaddirnames Op = "readdirnames"
+	OpFchmod       Op = "fchmod"
+	OpFchown       Op = "fchown"
+	OpFchtimes     Op = "fchtimes"
+	OpLock         Op = "lock"
+	OpRLock        Op = "rlock"
+	OpUnlock       Op = "unlock"
+)
+
+// Call describes a single intercepted operation. Middleware may inspect it
+// in Before and After; the fields that are meaningful depend on Op.
+type Call struct {
+	Op Op
+
+	// Path is the name the operation was invoked with. For handle
+	// operations it is the name of the File.
+	Path string
+
+	// NewPath is the destination of a Rename or the link name of a Symlink
+	// or Link.
+	NewPath string
+
+	// Attr is the name of the extended attribute of an extended attribute
+	// operation.
+	Attr string
+
+	Flag  int
+	Perm  os.FileMode
+	Uid   int
+	Gid   int
+	Atime time.Time
+	Mtime time.Time
+
+	// Size is the truncate size, the number of bytes requested by a read
+	// or write, or the size of the value set by Setxattr.
+	Size int64
+
+	// Offset is the explicit offset of ReadAt/WriteAt or Seek, and -1 for
+	// reads and writes at the current offset.
+	Offset int64
+	Whence int
+
+	// File is the handle a file operation is performed on. It is nil for
+	// FileSystem operations.
+	File File
+
+	// N is the number of bytes transferred by a read or write. It is set
+	// before After is called.
+	N int64
+
+	// Start is the time the operation was started.
+	Start time.Time
+}
+
+// Middleware intercepts operations on a FileSystem created with Chain.
+//
+// Before is called before the operation is performed. Returning a non-nil
+// error vetoes the operation, and the error is returned to the caller.
+//
+// After is called once the operation completed (or was vetoed by a
+// middleware further down the chain) with the resulting error. The error
+// returned by After replaces it, so middleware may translate or suppress
+// errors.
+type Middleware interface {
+	Before(c *Call) error
+	After(c *Call, err error) error
+}
+
+// Hooks adapts a pair of functions to the Middleware interface. Either
+// function may be nil.
+type Hooks struct {
+	BeforeFunc func(c *Call) error
+	AfterFunc  func(c *Call, err error) error
+}
+
+func (h Hooks) Before(c *Call) error {
+	if h.BeforeFunc == nil {
+		return nil
+	}
+	return h.BeforeFunc(c)
+}
+
+func (h Hooks) After(c *Call, err error) error {
+	if h.AfterFunc == nil {
+		return err
+	}
+	return h.AfterFunc(c, err)
+}
+
+// Chain returns a FileSystem that runs every operation on fs, and on the
+// Files it returns, through mw. Before hooks run in the order the middleware
+// is given and After hooks in the reverse order, so the first middleware is
+// the outermost layer.
+func Chain(fs FileSystem, mw ...Middleware) FileSystem {
+	if len(mw) == 0 {
+		return fs
+	}
+	return &chainFS{fs: fs, mw: mw}
+}
+
+type chainFS struct {
+	fs FileSystem
+	mw []Middleware
+}
+
+func (c *chainFS) do(call *Call, fn func() error) error {
+	call.Start = time.Now()
+
+	i := 0
+	var err error
+	for ; i < len(c.mw); i++ {
+		if err = c.mw[i].Before(call); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = fn()
+	}
+	for i--; i >= 0; i-- {
+		err = c.mw[i].After(call, err)
+	}
+
+	return err
+}
+
+func (c *chainFS) wrap(name string, f File) File {
+	return &chainFile{c: c, f: f, name: name}
+}
+
+func (c *chainFS) openFile(call *Call, open func() (File, error)) (File, error) {
+	var f File
+	err := c.do(call, func() error {
+		var err error
+		f, err = open()
+		return err
+	})
+	if err != nil {
+		if f != nil {
+			if _, ok := f.(*InvalidFile); !ok {
+				// a middleware rejected a successful open
+				f.Close()
+				f = &InvalidFile{Path: call.Path}
+			}
+		}
+		return f, err
+	}
+
+	return c.wrap(call.Path, f), nil
+}
+
+func (c *chainFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	call := &Call{Op: OpOpen, Path: name, Flag: flag, Perm: perm, Offset: -1}
+	return c.openFile(call, func() (File, error) {
+		return c.fs.OpenFile(name, flag, perm)
+	})
+}
+
+func (c *chainFS) Open(name string) (File, error) {
+	call := &Call{Op: OpOpen, Path: name, Flag: O_RDONLY, Offset: -1}
+	return c.openFile(call, func() (File, error) {
+		return c.fs.Open(name)
+	})
+}
+
+func (c *chainFS) Create(name string) (File, error) {
+	call := &Call{Op: OpOpen, Path: name, Flag: O_RDWR | O_CREATE | O_TRUNC, Perm: 0666, Offset: -1}
+	return c.openFile(call, func() (File, error) {
+		return c.fs.Create(name)
+	})
+}
+
+func (c *chainFS) Mkdir(name string, perm os.FileMode) error {
+	return c.do(&Call{Op: OpMkdir, Path: name, Perm: perm, Offset: -1}, func() error {
+		return c.fs.Mkdir(name, perm)
+	})
+}
+
+func (c *chainFS) MkdirAll(name string, perm os.FileMode) error {
+	return c.do(&Call{Op: OpMkdirAll, Path: name, Perm: perm, Offset: -1}, func() error {
+		return c.fs.MkdirAll(name, perm)
+	})
+}
+
+func (c *chainFS) Remove(name string) error {
+	return c.do(&Call{Op: OpRemove, Path: name, Offset: -1}, func() error {
+		return c.fs.Remove(name)
+	})
+}
+
+func (c *chainFS) RemoveAll(name string) error {
+	return c.do(&Call{Op: OpRemoveAll, Path: name, Offset: -1}, func() error {
+		return c.fs.RemoveAll(name)
+	})
+}
+
+func (c *chainFS) Rename(oldpath, newpath string) error {
+	return c.do(&Call{Op: OpRename, Path: oldpath, NewPath: newpath, Offset: -1}, func() error {
+		return c.fs.Rename(oldpath, newpath)
+	})
+}
+
+func (c *chainFS) Stat(name string) (os.FileInfo, error) {
+	var info os.FileInfo
+	err := c.do(&Call{Op: OpStat, Path: name, Offset: -1}, func() error {
+		var err error
+		info, err = c.fs.Stat(name)
+		return err
+	})
+	return info, err
+}
+
+func (c *chainFS) Lstat(name string) (os.FileInfo, error) {
+	var info os.FileInfo
+	err := c.do(&Call{Op: OpLstat, Path: name, Offset: -1}, func() error {
+		var err error
+		info, err = c.fs.Lstat(name)
+		return err
+	})
+	return info, err
+}
+
+func (c *chainFS) Chmod(name string, mode os.FileMode) error {
+	return c.do(&Call{Op: OpChmod, Path: name, Perm: mode, Offset: -1}, func() error {
+		return c.fs.Chmod(name, mode)
+	})
+}
+
+func (c *chainFS) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return c.do(&Call{Op: OpChtimes, Path: name, Atime: atime, Mtime: mtime, Offset: -1}, func() error {
+		return c.fs.Chtimes(name, atime, mtime)
+	})
+}
+
+func (c *chainFS) Chown(name string, uid, gid int) error {
+	return c.do(&Call{Op: OpChown, Path: name, Uid: uid, Gid: gid, Offset: -1}, func() error {
+		return c.fs.Chown(name, uid, gid)
+	})
+}
+
+func (c *chainFS) Lchown(name string, uid, gid int) error {
+	return c.do(&Call{Op: OpLchown, Path: name, Uid: uid, Gid: gid, Offset: -1}, func() error {
+		return c.fs.Lchown(name, uid, gid)
+	})
+}
+
+func (c *chainFS) Separator() uint8 {
+	return c.fs.Separator()
+}
+
+func (c *chainFS) ListSeparator() uint8 {
+	return c.fs.ListSeparator()
+}
+
+func (c *chainFS) Chdir(dir string) error {
+	return c.do(&Call{Op: OpChdir, Path: dir, Offset: -1}, func() error {
+		return c.fs.Chdir(dir)
+	})
+}
+
+func (c *chainFS) Getwd() (string, error) {
+	return c.fs.Getwd()
+}
+
+func (c *chainFS) TempDir() string {
+	return c.fs.TempDir()
+}
+
+func (c *chainFS) Truncate(name string, size int64) error {
+	return c.do(&Call{Op: OpTruncate, Path: name, Size: size, Offset: -1}, func() error {
+		return c.fs.Truncate(name, size)
+	})
+}
+
+func (c *chainFS) Readlink(name string) (string, error) {
+	var target string
+	err := c.do(&Call{Op: OpReadlink, Path: name, Offset: -1}, func() error {
+		var err error
+		target, err = c.fs.Readlink(name)
+		return err
+	})
+	return target, err
+}
+
+func (c *chainFS) Symlink(oldname, newname string) error {
+	return c.do(&Call{Op: OpSymlink, Path: oldname, NewPath: newname, Offset: -1}, func() error {
+		return c.fs.Symlink(oldname, newname)
+	})
+}
+
+// Link creates a hard link if the chained FileSystem is a Linker.
+func (c *chainFS) Link(oldname, newname string) error {
+	return c.do(&Call{Op: OpLink, Path: oldname, NewPath: newname, Offset: -1}, func() error {
+		l, ok := c.fs.(Linker)
+		if !ok {
+			return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: ErrNotImplemented}
+		}
+		return l.Link(oldname, newname)
+	})
+}
+
+// Sync commits every file of the chained FileSystem to stable storage if
+// it's a Syncer.
+func (c *chainFS) Sync() error {
+	return c.do(&Call{Op: OpSync, Offset: -1}, func() error {
+		s, ok := c.fs.(Syncer)
+		if !ok {
+			return &os.PathError{Op: "sync", Err: ErrNotImplemented}
+		}
+		return s.Sync()
+	})
+}
+
+// Getxattr returns the value of the extended attribute attr of the named
+// file if the chained FileSystem is an Xattrer.
+func (c *chainFS) Getxattr(name, attr string) ([]byte, error) {
+	var value []byte
+	err := c.do(&Call{Op: OpGetxattr, Path: name, Attr: attr, Offset: -1}, func() error {
+		x, ok := c.fs.(Xattrer)
+		if !ok {
+			return &os.PathError{Op: "getxattr", Path: name, Err: ErrNotImplemented}
+		}
+		var err error
+		value, err = x.Getxattr(name, attr)
+		return err
+	})
+	return value, err
+}
+
+// Setxattr sets the extended attribute attr of the named file if the
+// chained FileSystem is an Xattrer.
+func (c *chainFS) Setxattr(name, attr string, value []byte) error {
+	return c.do(&Call{Op: OpSetxattr, Path: name, Attr: attr, Size: int64(len(value)), Offset: -1}, func() error {
+		x, ok := c.fs.(Xattrer)
+		if !ok {
+			return &os.PathError{Op: "setxattr", Path: name, Err: ErrNotImplemented}
+		}
+		return x.Setxattr(name, attr, value)
+	})
+}
+
+// Listxattr returns the names of the extended attributes of the named file
+// if the chained FileSystem is an Xattrer.
+func (c *chainFS) Listxattr(name string) ([]string, error) {
+	var attrs []string
+	err := c.do(&Call{Op: OpListxattr, Path: name, Offset: -1}, func() error {
+		x, ok := c.fs.(Xattrer)
+		if !ok {
+			return &os.PathError{Op: "listxattr", Path: name, Err: ErrNotImplemented}
+		}
+		var err error
+		attrs, err = x.Listxattr(name)
+		return err
+	})
+	return attrs, err
+}
+
+// Removexattr removes the extended attribute attr of the named file if the
+// chained FileSystem is an Xattrer.
+func (c *chainFS) Removexattr(name, attr string) error {
+	return c.do(&Call{Op: OpRemovexattr, Path: name, Attr: attr, Offset: -1}, func() error {
+		x, ok := c.fs.(Xattrer)
+		if !ok {
+			return &os.PathError{Op: "removexattr", Path: name, Err: ErrNotImplemented}
+		}
+		return x.Removexattr(name, attr)
+	})
+}
+
+// Capabilities returns the capabilities of the chained FileSystem.
+func (c *chainFS) Capabilities() Capability {
+	return Capabilities(c.fs)
+}
+
+type chainFile struct {
+	c    *chainFS
+	f    File
+	name string
+}
+
+func (f *chainFile) call(op Op) *Call {
+	return &Call{Op: op, Path: f.name, File: f.f, Offset: -1}
+}
+
+func (f *chainFile) Name() string {
+	return f.f.Name()
+}
+
+func (f *chainFile) Read(p []byte) (n int, err error) {
+	call := f.call(OpRead)
+	call.Size = int64(len(p))
+	err = f.c.do(call, func() error {
+		n, err = f.f.Read(p)
+		call.N = int64(n)
+		return err
+	})
+	return n, err
+}
+
+func (f *chainFile) ReadAt(b []byte, off int64) (n int, err error) {
+	call := f.call(OpRead)
+	call.Size = int64(len(b))
+	call.Offset = off
+	err = f.c.do(call, func() error {
+		n, err = f.f.ReadAt(b, off)
+		call.N = int64(n)
+		return err
+	})
+	return n, err
+}
+
+func (f *chainFile) Write(p []byte) (n int, err error) {
+	call := f.call(OpWrite)
+	call.Size = int64(len(p))
+	err = f.c.do(call, func() error {
+		n, err = f.f.Write(p)
+		call.N = int64(n)
+		return err
+	})
+	return n, err
+}
+
+func (f *chainFile) WriteAt(b []byte, off int64) (n int, err error) {
+	call := f.call(OpWrite)
+	call.Size = int64(len(b))
+	call.Offset = off
+	err = f.c.do(call, func() error {
+		n, err = f.f.WriteAt(b, off)
+		call.N = int64(n)
+		return err
+	})
+	return n, err
+}
+
+func (f *chainFile) WriteString(s string) (n int, err error) {
+	call := f.call(OpWrite)
+	call.Size = int64(len(s))
+	err = f.c.do(call, func() error {
+		n, err = f.f.WriteString(s)
+		call.N = int64(n)
+		return err
+	})
+	return n, err
+}
+
+func (f *chainFile) Close() error {
+	return f.c.do(f.call(OpClose), f.f.Close)
+}
+
+func (f *chainFile) Sync() error {
+	return f.c.do(f.call(OpSync), f.f.Sync)
+}
+
+func (f *chainFile) Stat() (info os.FileInfo, err error) {
+	err = f.c.do(f.call(OpFstat), func() error {
+		info, err = f.f.Stat()
+		return err
+	})
+	return info, err
+}
+
+func (f *chainFile) Readdir(n int) (infos []os.FileInfo, err error) {
+	err = f.c.do(f.call(OpReaddir), func() error {
+		infos, err = f.f.Readdir(n)
+		return err
+	})
+	return infos, err
+}
+
+func (f *chainFile) Readdirnames(n int) (names []string, err error) {
+	err = f.c.do(f.call(OpReaddirnames), func() error {
+		names, err = f.f.Readdirnames(n)
+		return err
+	})
+	return names, err
+}
+
+func (f *chainFile) Seek(offset int64, whence int) (ret int64, err error) {
+	call := f.call(OpSeek)
+	call.Offset = offset
+	call.Whence = whence
+	err = f.c.do(call, func() error {
+		ret, err = f.f.Seek(offset, whence)
+		return err
+	})
+	return ret, err
+}
+
+func (f *chainFile) Truncate(size int64) error {
+	call := f.call(OpFtruncate)
+	call.Size = size
+	return f.c.do(call, func() error {
+		return f.f.Truncate(size)
+	})
+}
+
+// Chmod changes the mode of the File if the chained File is an AttrFile.
+func (f *chainFile) Chmod(mode os.FileMode) error {
+	call := f.call(OpFchmod)
+	call.Perm = mode
+	return f.c.do(call, func() error {
+		af, ok := f.f.(AttrFile)
+		if !ok {
+			return &os.PathError{Op: "chmod", Path: f.name, Err: ErrNotImplemented}
+		}
+		return af.Chmod(mode)
+	})
+}
+
+// Chown changes the owner of the File if the chained File is an AttrFile.
+func (f *chainFile) Chown(uid, gid int) error {
+	call := f.call(OpFchown)
+	call.Uid = uid
+	call.Gid = gid
+	return f.c.do(call, func() error {
+		af, ok := f.f.(AttrFile)
+		if !ok {
+			return &os.PathError{Op: "chown", Path: f.name, Err: ErrNotImplemented}
+		}
+		return af.Chown(uid, gid)
+	})
+}
+
+// Chtimes changes the times of the File if the chained File is a
+// ChtimesFile.
+func (f *chainFile) Chtimes(atime, mtime time.Time) error {
+	call := f.call(OpFchtimes)
+	call.Atime = atime
+	call.Mtime = mtime
+	return f.c.do(call, func() error {
+		cf, ok := f.f.(ChtimesFile)
+		if !ok {
+			return &os.PathError{Op: "chtimes", Path: f.name, Err: ErrNotImplemented}
+		}
+		return cf.Chtimes(atime, mtime)
+	})
+}
+
+// lock runs a lock operation of the chained File if it's a LockFile.
+func (f *chainFile) lock(op Op, fn func(LockFile) error) error {
+	return f.c.do(f.call(op), func() error {
+		lf, ok := f.f.(LockFile)
+		if !ok {
+			return &os.PathError{Op: string(op), Path: f.name, Err: ErrNotImplemented}
+		}
+		return fn(lf)
+	})
+}
+
+func (f *chainFile) Lock() error {
+	return f.lock(OpLock, LockFile.Lock)
+}
+
+func (f *chainFile) RLock() error {
+	return f.lock(OpRLock, LockFile.RLock)
+}
+
+func (f *chainFile) TryLock() (ok bool, err error) {
+	err = f.lock(OpLock, func(lf LockFile) error {
+		ok, err = lf.TryLock()
+		return err
+	})
+	return ok, err
+}
+
+func (f *chainFile) TryRLock() (ok bool, err error) {
+	err = f.lock(OpRLock, func(lf LockFile) error {
+		ok, err = lf.TryRLock()
+		return err
+	})
+	return ok, err
+}
+
+func (f *chainFile) Unlock() error {
+	return f.lock(OpUnlock, LockFile.Unlock)
+}
+
+// SetDeadline sets the deadlines of the chained File if it's a
+// DeadlineFile. Deadlines aren't operations, so middleware doesn't see
+// them.
+func (f *chainFile) SetDeadline(t time.Time) error {
+	if df, ok := f.f.(DeadlineFile); ok {
+		return df.SetDeadline(t)
+	}
+	return os.ErrNoDeadline
+}
+
+func (f *chainFile) SetReadDeadline(t time.Time) error {
+	if df, ok := f.f.(DeadlineFile); ok {
+		return df.SetReadDeadline(t)
+	}
+	return os.ErrNoDeadline
+}
+
+func (f *chainFile) SetWriteDeadline(t time.Time) error {
+	if df, ok := f.f.(DeadlineFile); ok {
+		return df.SetWriteDeadline(t)
+	}
+	return os.ErrNoDeadline
+}
@@ -0,0 +1,257 @@
+package absfs_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+type recorder struct {
+	name string
+	log  *[]string
+}
+
+func (r recorder) Before(c *absfs.Call) error {
+	*r.log = append(*r.log, r.name+" before "+string(c.Op))
+	return nil
+}
+
+func (r recorder) After(c *absfs.Call, err error) error {
+	*r.log = append(*r.log, r.name+" after "+string(c.Op))
+	return err
+}
+
+func TestChainOrder(t *testing.T) {
+	var log []string
+	fs := absfs.Chain(vfs.NewFS(), recorder{"a", &log}, recorder{"b", &log})
+
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"a before mkdir", "b before mkdir", "b after mkdir", "a after mkdir"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Fatalf("wrong hook order: %q, expected %q", log, expected)
+	}
+}
+
+func TestChainVeto(t *testing.T) {
+	var log []string
+	denied := errors.New("denied")
+	veto := absfs.Hooks{
+		BeforeFunc: func(c *absfs.Call) error {
+			if c.Op == absfs.OpRemove {
+				return &os.PathError{Op: string(c.Op), Path: c.Path, Err: denied}
+			}
+			return nil
+		},
+	}
+	fs := absfs.Chain(vfs.NewFS(), recorder{"a", &log}, veto, recorder{"c", &log})
+
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	log = log[:0]
+
+	err := fs.Remove("/dir")
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != denied {
+		t.Fatalf("expected veto error, got %v", err)
+	}
+	expected := []string{"a before remove", "a after remove"}
+	if !reflect.DeepEqual(log, expected) {
+		t.Fatalf("wrong hook order: %q, expected %q", log, expected)
+	}
+	if _, err := fs.Stat("/dir"); err != nil {
+		t.Fatalf("vetoed remove was performed: %s", err)
+	}
+}
+
+func TestChainFile(t *testing.T) {
+	var written int64
+	count := absfs.Hooks{
+		AfterFunc: func(c *absfs.Call, err error) error {
+			if c.Op == absfs.OpWrite {
+				written += c.N
+			}
+			return err
+		},
+	}
+	fs := absfs.Chain(vfs.NewFS(), count)
+
+	f, err := fs.Create("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString(", world"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if written != 12 {
+		t.Errorf("wrong byte count: %d, expected %d", written, 12)
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	want := absfs.CapLink | absfs.CapSymlink | absfs.CapTruncate | absfs.CapXattr
+	if caps := absfs.Capabilities(vfs.NewFS()); caps != want {
+		t.Errorf("vfs capabilities: got %v, want %v", caps, want)
+	}
+
+	var log []string
+	fs := absfs.Chain(vfs.NewFS(), recorder{"a", &log})
+	if caps := absfs.Capabilities(fs); caps != want {
+		t.Errorf("chained capabilities: got %v, want %v", caps, want)
+	}
+	if !absfs.Capabilities(fs).Has(absfs.CapLink | absfs.CapSymlink) {
+		t.Error("Has reported missing capabilities")
+	}
+	if absfs.Capabilities(fs).Has(absfs.CapSync) {
+		t.Error("Has reported unsupported capability")
+	}
+	if err := fs.(absfs.Syncer).Sync(); !errors.Is(err, absfs.ErrNotImplemented) {
+		t.Errorf("syncing: got %v, want %v", err, absfs.ErrNotImplemented)
+	}
+}
+
+func TestChainXattr(t *testing.T) {
+	var log []string
+	var attrs []string
+	named := absfs.Hooks{
+		BeforeFunc: func(c *absfs.Call) error {
+			attrs = append(attrs, c.Attr)
+			return nil
+		},
+	}
+	fs := absfs.Chain(vfs.NewFS(), recorder{"a", &log}, named)
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	log, attrs = log[:0], attrs[:0]
+
+	x, ok := fs.(absfs.Xattrer)
+	if !ok {
+		t.Fatal("chained FileSystem isn't an Xattrer")
+	}
+	if err := x.Setxattr("/dir", "user.a", []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := x.Getxattr("/dir", "user.a"); err != nil || string(value) != "b" {
+		t.Errorf("getting the attribute: %q, %v", value, err)
+	}
+	if names, err := x.Listxattr("/dir"); err != nil || !reflect.DeepEqual(names, []string{"user.a"}) {
+		t.Errorf("listing the attributes: %q, %v", names, err)
+	}
+	if err := x.Removexattr("/dir", "user.a"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"a before setxattr", "a after setxattr", "a before getxattr", "a after getxattr",
+		"a before listxattr", "a after listxattr", "a before removexattr", "a after removexattr",
+	}
+	if !reflect.DeepEqual(log, expected) {
+		t.Errorf("wrong hooks: %q, expected %q", log, expected)
+	}
+	if expected := []string{"user.a", "user.a", "", "user.a"}; !reflect.DeepEqual(attrs, expected) {
+		t.Errorf("wrong attribute names: %q, expected %q", attrs, expected)
+	}
+
+	// FileSystems without extended attributes don't gain them by being
+	// chained
+	fs = absfs.Chain(struct{ absfs.FileSystem }{vfs.NewFS()}, named)
+	if absfs.Capabilities(fs).Has(absfs.CapXattr) {
+		t.Error("Has reported unsupported extended attributes")
+	}
+	if _, err := fs.(absfs.Xattrer).Getxattr("/", "user.a"); !errors.Is(err, absfs.ErrNotImplemented) {
+		t.Errorf("getting an attribute: got %v, want %v", err, absfs.ErrNotImplemented)
+	}
+}
+
+func TestChainFileAttrs(t *testing.T) {
+	var log []string
+	fs := absfs.Chain(vfs.NewFS(), recorder{"a", &log})
+
+	f, err := fs.Create("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	af, ok := f.(absfs.AttrFile)
+	if !ok {
+		t.Fatal("chained File isn't an AttrFile")
+	}
+	if err := af.Chmod(0600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(1000, 0)
+	if err := f.(absfs.ChtimesFile).Chtimes(mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	info, err := fs.Stat("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 || !info.ModTime().Equal(mtime) {
+		t.Errorf("got mode %v and mtime %v, want %v and %v", info.Mode().Perm(), info.ModTime(), os.FileMode(0600), mtime)
+	}
+	if want := []string{"a before fchmod", "a after fchmod", "a before fchtimes", "a after fchtimes"}; !reflect.DeepEqual(log[2:6], want) {
+		t.Errorf("wrong hooks: %q, expected %q", log[2:6], want)
+	}
+
+	if err := f.(absfs.LockFile).Lock(); !errors.Is(err, absfs.ErrNotImplemented) {
+		t.Errorf("locking a vfs File: got %v, want %v", err, absfs.ErrNotImplemented)
+	}
+	if err := f.(absfs.DeadlineFile).SetDeadline(time.Now()); !errors.Is(err, os.ErrNoDeadline) {
+		t.Errorf("setting a deadline: got %v, want %v", err, os.ErrNoDeadline)
+	}
+}
+
+func TestIOFS(t *testing.T) {
+	vfs := vfs.NewFS()
+	if err := vfs.MkdirAll("/root/sub/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/root/a", "/root/sub/b", "/root/sub/dir/c", "/outside"} {
+		f, err := vfs.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(name))
+		f.Close()
+	}
+
+	fsys := absfs.NewIOFS(vfs, "/root")
+	if err := fstest.TestFS(fsys, "a", "sub/b", "sub/dir/c"); err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := fs.Sub(fsys, "sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(sub, "b", "dir/c"); err != nil {
+		t.Fatal(err)
+	}
+	b, err := fs.ReadFile(sub, "dir/c")
+	if err != nil || string(b) != "/root/sub/dir/c" {
+		t.Fatalf("ReadFile: got %q, %v", b, err)
+	}
+	for _, name := range []string{"../a", "/outside", "../../outside"} {
+		if _, err := sub.Open(name); !errors.Is(err, fs.ErrInvalid) {
+			t.Errorf("Open %q: expected fs.ErrInvalid, got %v", name, err)
+		}
+	}
+	if _, err := fs.Sub(fsys, "a"); err == nil {
+		t.Error("Sub of a file succeeded")
+	}
+}
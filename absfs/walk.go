@@ -0,0 +1,22 @@
+package absfs
+
+import "errors"
+
+// ErrSymlinkCycle is returned when following symbolic links leads back to
+// where it started.
+var ErrSymlinkCycle = errors.New("too many levels of symbolic links")
+
+// SymlinkPolicy decides how walking a FileSystem treats symbolic links.
+type SymlinkPolicy int
+
+const (
+	// ReportSymlinks passes symbolic links to the walk function without
+	// following them, like filepath.Walk.
+	ReportSymlinks SymlinkPolicy = iota
+
+	// FollowSymlinks walks the files symbolic links point to in place of
+	// the links. Links to directories that are already being walked are
+	// reported with ErrSymlinkCycle instead of being followed, and links
+	// that point nowhere are reported as they are.
+	FollowSymlinks
+)
@@ -0,0 +1,39 @@
+package pandorasbox
+
+import (
+	"io"
+
+	"filippo.io/age"
+)
+
+// ExportAge writes the contents of the VFS to w as a tar archive encrypted
+// to recipients in the age format. Any of the recipients' identities,
+// including SSH keys, can decrypt it with the age tool.
+func (b *Box) ExportAge(w io.Writer, recipients ...age.Recipient) error {
+	return b.ExportAgePaths(w, []string{VFSPrefix}, recipients...)
+}
+
+// ExportAgePaths is like ExportAge, but only exports the files and
+// directories under paths, which may be VFS or OS paths.
+func (b *Box) ExportAgePaths(w io.Writer, paths []string, recipients ...age.Recipient) error {
+	aw, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return err
+	}
+	if err := b.writeTar(aw, paths); err != nil {
+		return err
+	}
+
+	return aw.Close()
+}
+
+// ImportAge decrypts an archive written by ExportAge with one of identities,
+// and extracts it into dir.
+func (b *Box) ImportAge(r io.Reader, dir string, identities ...age.Identity) error {
+	ar, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return err
+	}
+
+	return b.readTar(ar, dir)
+}
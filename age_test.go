@@ -0,0 +1,51 @@
+package pandorasbox
+
+import (
+	"bytes"
+	"testing"
+
+	"filippo.io/age"
+)
+
+func TestAgeRoundTrip(t *testing.T) {
+	b := NewBox()
+	if err := b.MkdirAll("vfs://secrets", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteFile("vfs://secrets/a", []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := b.ExportAge(&buf, id.Recipient()); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("hunter2")) {
+		t.Fatal("export holds the plaintext")
+	}
+	archive := buf.Bytes()
+
+	other, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewBox().ImportAge(bytes.NewReader(archive), "vfs://restored", other); err == nil {
+		t.Error("imported with the wrong identity")
+	}
+
+	restored := NewBox()
+	if err := restored.ImportAge(bytes.NewReader(archive), "vfs://restored", id); err != nil {
+		t.Fatal(err)
+	}
+	data, err := restored.ReadFile("vfs://restored/secrets/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hunter2" {
+		t.Errorf("restored %q, want %q", data, "hunter2")
+	}
+}
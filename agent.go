@@ -0,0 +1,323 @@
+package pandorasbox
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/subtle"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/awnumar/memguard"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// commentTag is the tag the comment of a key added to an Agent is kept in.
+const commentTag = "ssh-comment"
+
+var (
+	// ErrAgentLocked is returned by a locked Agent for requests that need
+	// its keys.
+	ErrAgentLocked = errors.New("agent is locked")
+
+	// ErrNoSuchKey is returned by an Agent for public keys it has no
+	// private key of.
+	ErrNoSuchKey = errors.New("no such key")
+)
+
+// Agent is an ssh-agent whose identities are the keys of a Keystore, so
+// OpenSSH clients can use keys that are only ever kept sealed in a Box.
+// Keys added with ssh-add are stored in the Keystore, named after their
+// fingerprints, and removed from it by ssh-add -d and -D. Certificates and
+// keys that must be confirmed aren't supported.
+type Agent struct {
+	ks *Keystore
+
+	mtx    sync.Mutex
+	locked *memguard.Enclave
+}
+
+var _ agent.ExtendedAgent = (*Agent)(nil)
+
+// Agent returns an ssh-agent whose identities are the keys kept in the
+// directory dir, like Keystore.
+func (b *Box) Agent(dir string) *Agent {
+	return &Agent{ks: b.Keystore(dir)}
+}
+
+// Serve accepts connections on l and serves the ssh-agent protocol on each,
+// until l is closed. It's usually given a Unix socket whose path is then
+// set as SSH_AUTH_SOCK.
+func (a *Agent) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			defer conn.Close()
+			agent.ServeAgent(a, conn)
+		}()
+	}
+}
+
+// identity is a key of the Keystore of an Agent.
+type identity struct {
+	name    string
+	comment string
+	signer  ssh.Signer
+}
+
+// identities returns the keys of the Keystore.
+func (a *Agent) identities() ([]identity, error) {
+	names, err := a.ks.List()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]identity, 0, len(names))
+	for _, name := range names {
+		signer, err := a.ks.SSHSigner(name)
+		if err != nil {
+			return nil, err
+		}
+		comment := name
+		p, _ := a.ks.path("list", name)
+		if tags, err := a.ks.b.Tags(p); err == nil && tags[commentTag] != "" {
+			comment = tags[commentTag]
+		}
+		ids = append(ids, identity{name: name, comment: comment, signer: signer})
+	}
+
+	return ids, nil
+}
+
+// find returns the key of the Keystore whose public key is key.
+func (a *Agent) find(key ssh.PublicKey) (identity, error) {
+	ids, err := a.identities()
+	if err != nil {
+		return identity{}, err
+	}
+	want := key.Marshal()
+	for _, id := range ids {
+		if bytes.Equal(id.signer.PublicKey().Marshal(), want) {
+			return id, nil
+		}
+	}
+
+	return identity{}, ErrNoSuchKey
+}
+
+func (a *Agent) isLocked() bool {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	return a.locked != nil
+}
+
+// List returns the public keys of the keys stored, or none if the agent is
+// locked.
+func (a *Agent) List() ([]*agent.Key, error) {
+	if a.isLocked() {
+		return nil, nil
+	}
+	ids, err := a.identities()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*agent.Key, len(ids))
+	for i, id := range ids {
+		pub := id.signer.PublicKey()
+		keys[i] = &agent.Key{
+			Format:  pub.Type(),
+			Blob:    pub.Marshal(),
+			Comment: id.comment,
+		}
+	}
+
+	return keys, nil
+}
+
+func (a *Agent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	return a.SignWithFlags(key, data, 0)
+}
+
+// SignWithFlags signs data with the private key of key. RSA keys sign with
+// SHA-256 or SHA-512 if flags ask for them.
+func (a *Agent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	if a.isLocked() {
+		return nil, ErrAgentLocked
+	}
+	id, err := a.find(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if algSigner, ok := id.signer.(ssh.AlgorithmSigner); ok && key.Type() == ssh.KeyAlgoRSA {
+		switch {
+		case flags&agent.SignatureFlagRsaSha256 != 0:
+			return algSigner.SignWithAlgorithm(nil, data, ssh.KeyAlgoRSASHA256)
+		case flags&agent.SignatureFlagRsaSha512 != 0:
+			return algSigner.SignWithAlgorithm(nil, data, ssh.KeyAlgoRSASHA512)
+		}
+	}
+
+	return id.signer.Sign(nil, data)
+}
+
+// Add stores key.PrivateKey in the Keystore, named after its fingerprint.
+// A positive key.LifetimeSecs removes it from the Keystore once it's over.
+func (a *Agent) Add(key agent.AddedKey) error {
+	if a.isLocked() {
+		return ErrAgentLocked
+	}
+	if key.Certificate != nil || key.ConfirmBeforeUse {
+		return syscall.ENOTSUP
+	}
+	priv := key.PrivateKey
+	if k, ok := priv.(*ed25519.PrivateKey); ok {
+		priv = *k
+	}
+	signer, ok := priv.(crypto.Signer)
+	if !ok {
+		return ErrNoPrivateKey
+	}
+	pub, err := ssh.NewPublicKey(signer.Public())
+	if err != nil {
+		return err
+	}
+	// fingerprints are base64, which may hold slashes
+	name := strings.ReplaceAll(ssh.FingerprintSHA256(pub), "/", "_")
+
+	p, err := a.ks.path("add", name)
+	if err != nil {
+		return err
+	}
+	a.ks.b.cancelExpiry(p)
+	if _, err := a.ks.Import(name, priv); err != nil {
+		return err
+	}
+	if key.Comment != "" {
+		if err := a.ks.b.Tag(p, commentTag, key.Comment); err != nil && !errors.Is(err, syscall.ENOTSUP) {
+			return err
+		}
+	}
+	if key.LifetimeSecs > 0 {
+		a.ks.b.expire(p, time.Duration(key.LifetimeSecs)*time.Second)
+	}
+
+	return nil
+}
+
+// Remove deletes the private key of key from the Keystore.
+func (a *Agent) Remove(key ssh.PublicKey) error {
+	if a.isLocked() {
+		return ErrAgentLocked
+	}
+	id, err := a.find(key)
+	if err != nil {
+		return err
+	}
+
+	return a.remove(id.name)
+}
+
+// RemoveAll deletes every key from the Keystore.
+func (a *Agent) RemoveAll() error {
+	if a.isLocked() {
+		return ErrAgentLocked
+	}
+	names, err := a.ks.List()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := a.remove(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (a *Agent) remove(name string) error {
+	p, err := a.ks.path("remove", name)
+	if err != nil {
+		return err
+	}
+	a.ks.b.cancelExpiry(p)
+
+	return a.ks.Delete(name)
+}
+
+// Lock locks the agent until Unlock is called with passphrase. A locked
+// agent lists no keys, and refuses to sign or change them.
+func (a *Agent) Lock(passphrase []byte) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.locked != nil {
+		return ErrAgentLocked
+	}
+	if len(passphrase) == 0 {
+		// Enclaves can't be empty
+		passphrase = []byte{0}
+	}
+	a.locked = memguard.NewEnclave(append([]byte(nil), passphrase...))
+
+	return nil
+}
+
+func (a *Agent) Unlock(passphrase []byte) error {
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	if a.locked == nil {
+		return errors.New("agent is not locked")
+	}
+	want, err := a.locked.Open()
+	if err != nil {
+		return err
+	}
+	defer want.Destroy()
+	if len(passphrase) == 0 {
+		passphrase = []byte{0}
+	}
+	if subtle.ConstantTimeCompare(want.Bytes(), passphrase) != 1 {
+		return errors.New("incorrect passphrase")
+	}
+	a.locked = nil
+
+	return nil
+}
+
+// Signers returns ssh.Signers for the keys stored.
+func (a *Agent) Signers() ([]ssh.Signer, error) {
+	if a.isLocked() {
+		return nil, ErrAgentLocked
+	}
+	ids, err := a.identities()
+	if err != nil {
+		return nil, err
+	}
+
+	signers := make([]ssh.Signer, len(ids))
+	for i, id := range ids {
+		signers[i] = id.signer
+	}
+
+	return signers, nil
+}
+
+func (a *Agent) Extension(extensionType string, contents []byte) ([]byte, error) {
+	return nil, agent.ErrExtensionUnsupported
+}
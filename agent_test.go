@@ -0,0 +1,159 @@
+package pandorasbox
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentClient returns a client of a served over a pipe.
+func agentClient(t *testing.T, a *Agent) agent.ExtendedAgent {
+	t.Helper()
+	c, s := net.Pipe()
+	t.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+	go agent.ServeAgent(a, s)
+
+	return agent.NewClient(c)
+}
+
+func TestAgent(t *testing.T) {
+	b := NewBox()
+	client := agentClient(t, b.Agent("vfs://agent"))
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Add(agent.AddedKey{PrivateKey: priv, Comment: "me@host"}); err != nil {
+		t.Fatal(err)
+	}
+	names, err := b.Keystore("vfs://agent").List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 {
+		t.Fatalf("the Keystore holds %v, want 1 key", names)
+	}
+
+	keys, err := client.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].Comment != "me@host" || string(keys[0].Blob) != string(sshPub.Marshal()) {
+		t.Fatalf("List = %v, want the added key", keys)
+	}
+	data := []byte("session")
+	sig, err := client.Sign(sshPub, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sshPub.Verify(data, sig); err != nil {
+		t.Errorf("the agent's signature doesn't verify: %v", err)
+	}
+
+	otherPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherSSHPub, err := ssh.NewPublicKey(otherPub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Sign(otherSSHPub, data); err == nil {
+		t.Error("signing with a key the agent doesn't have succeeded")
+	}
+
+	if err := client.Remove(sshPub); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := client.List(); err != nil || len(keys) != 0 {
+		t.Errorf("List after Remove = %v, %v, want no keys", keys, err)
+	}
+}
+
+func TestAgentLock(t *testing.T) {
+	client := agentClient(t, NewBox().Agent("vfs://agent"))
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Add(agent.AddedKey{PrivateKey: priv}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Lock([]byte("pass")); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := client.List(); err != nil || len(keys) != 0 {
+		t.Errorf("List of a locked agent = %v, %v, want no keys", keys, err)
+	}
+	if _, err := client.Sign(signer.PublicKey(), []byte("data")); err == nil {
+		t.Error("a locked agent signed")
+	}
+	if err := client.RemoveAll(); err == nil {
+		t.Error("a locked agent removed its keys")
+	}
+	if err := client.Unlock([]byte("wrong")); err == nil {
+		t.Error("unlocking with the wrong passphrase succeeded")
+	}
+	if err := client.Unlock([]byte("pass")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Sign(signer.PublicKey(), []byte("data")); err != nil {
+		t.Errorf("signing after unlocking: %v", err)
+	}
+
+	if err := client.RemoveAll(); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := client.List(); err != nil || len(keys) != 0 {
+		t.Errorf("List after RemoveAll = %v, %v, want no keys", keys, err)
+	}
+}
+
+func TestAgentLifetime(t *testing.T) {
+	client := agentClient(t, NewBox().Agent("vfs://agent"))
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Add(agent.AddedKey{PrivateKey: priv, LifetimeSecs: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if keys, err := client.List(); err != nil || len(keys) != 1 {
+		t.Fatalf("List = %v, %v, want the added key", keys, err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		keys, err := client.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("key wasn't removed after its lifetime")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,139 @@
+package pandorasbox
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/archive"
+	"github.com/capnspacehook/pandorasbox/ioutil"
+)
+
+// ArchiveFormat is the format of an archive read by Import.
+type ArchiveFormat int
+
+const (
+	// FormatAuto detects the format from the start of the archive.
+	FormatAuto ArchiveFormat = iota
+	FormatTar
+	FormatTarGz
+	FormatZip
+)
+
+// fileSystem returns the FileSystem name is stored on, and name as that
+// FileSystem knows it.
+func (b *Box) fileSystem(name string) (absfs.FileSystem, string, error) {
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return nil, "", err
+	} else if ok {
+		return fs, vfsName, nil
+	}
+
+	return b.osfs, name, nil
+}
+
+// writeTar writes a tar archive of the files and directories under paths to
+// w. Entries are named relative to the parent of the path they were found
+// under, so exporting vfs://secrets produces entries starting with
+// secrets/.
+func (b *Box) writeTar(w io.Writer, paths []string) error {
+	tw := tar.NewWriter(w)
+	for _, root := range paths {
+		fs, name, err := b.fileSystem(root)
+		if err != nil {
+			tw.Close()
+			return &os.PathError{Op: "export", Path: root, Err: err}
+		}
+		if err := archive.Add(tw, fs, name); err != nil {
+			tw.Close()
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// readTar extracts the files and directories of the tar archive read from r
+// into dir. Archives are usually written from a VFS, so extracting one onto
+// a case-insensitive host filesystem fails with ErrCaseConflict rather than
+// overwriting files whose names only differ in case.
+func (b *Box) readTar(r io.Reader, dir string) error {
+	fs, vfsDir, ok, err := b.resolveVFS(dir)
+	if err != nil {
+		return &os.PathError{Op: "import", Path: dir, Err: err}
+	}
+	if ok {
+		return archive.Extract(r, fs, vfsDir)
+	}
+
+	return archive.Extract(r, b.hostFS(dir), dir)
+}
+
+// Import extracts the archive read from r in format into dir, which may be
+// a VFS or a host path. Tar archives, compressed or not, are extracted as
+// they are read, so archives received over the network are never staged
+// anywhere. Zip archives can only be read once they are complete, so they
+// are staged in a temporary file in the default VFS, sealed like any other
+// file, which is wiped once the archive is extracted.
+func (b *Box) Import(r io.Reader, dir string, format ArchiveFormat) error {
+	if format == FormatAuto {
+		br := bufio.NewReader(r)
+		magic, _ := br.Peek(4)
+		switch {
+		case bytes.HasPrefix(magic, []byte{0x1f, 0x8b}):
+			format = FormatTarGz
+		case bytes.HasPrefix(magic, []byte("PK\x03\x04")), bytes.HasPrefix(magic, []byte("PK\x05\x06")):
+			format = FormatZip
+		default:
+			format = FormatTar
+		}
+		r = br
+	}
+
+	switch format {
+	case FormatTarGz:
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		if err := b.readTar(zr, dir); err != nil {
+			return err
+		}
+		return zr.Close()
+	case FormatZip:
+		return b.readZip(r, dir)
+	}
+
+	return b.readTar(r, dir)
+}
+
+// readZip extracts the zip archive read from r into dir, staging it in the
+// default VFS.
+func (b *Box) readZip(r io.Reader, dir string) error {
+	f, err := ioutil.TempFile(b.vfs, "", "import")
+	if err != nil {
+		return err
+	}
+	defer b.vfs.RemoveAllContext(context.Background(), f.Name(), nil)
+	defer f.Close()
+
+	size, err := io.Copy(f, r)
+	if err != nil {
+		return err
+	}
+	fs, vfsDir, ok, err := b.resolveVFS(dir)
+	if err != nil {
+		return &os.PathError{Op: "import", Path: dir, Err: err}
+	}
+	if ok {
+		return archive.ExtractZip(f, size, fs, vfsDir)
+	}
+
+	return archive.ExtractZip(f, size, b.hostFS(dir), dir)
+}
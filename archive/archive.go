@@ -0,0 +1,334 @@
+// Package archive reads and writes tar archives of the files on an
+// absfs.FileSystem, and reads zip archives.
+package archive
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/inode"
+	"github.com/capnspacehook/pandorasbox/ioutil"
+)
+
+// xattrPrefix prefixes the PAX records holding extended attributes, as
+// written by GNU tar and bsdtar.
+const xattrPrefix = "SCHILY.xattr."
+
+// tagPrefix prefixes the PAX records holding the tags of files on
+// filesystems that are absfs.Taggers.
+const tagPrefix = "PANDORASBOX.tag."
+
+// accessTimer is a FileInfo that knows when its file was last accessed.
+type accessTimer interface {
+	AccessTime() time.Time
+}
+
+// ErrBadPath is returned by Extract for entries that would be extracted
+// outside of the destination directory.
+var ErrBadPath = errors.New("archive entry escapes destination directory")
+
+// Write writes a tar archive of the files and directories under paths on
+// fs to w.
+func Write(w io.Writer, fs absfs.FileSystem, paths ...string) error {
+	tw := tar.NewWriter(w)
+	for _, root := range paths {
+		if err := Add(tw, fs, root); err != nil {
+			tw.Close()
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// Add adds the files and directories under root on fs to tw. Entries are
+// named relative to the parent of root, so adding /etc/ssl produces entries
+// starting with ssl/. Regular files and directories are added along with
+// their extended attributes. Symbolic links are added as links if fs is an
+// absfs.Symlinker, and files linked more than once under root are added
+// once and as hard links after that, if their FileInfos are backed by
+// inodes like those of the VFS.
+func Add(tw *tar.Writer, fs absfs.FileSystem, root string) error {
+	sep := string(fs.Separator())
+	root = strings.TrimRight(root, sep)
+	if root == "" {
+		root = sep
+	}
+	prefix := toSlash(fs, root)
+	if prefix != "/" {
+		prefix = path.Dir(prefix)
+	}
+
+	links := make(map[*inode.Inode]string)
+	return ioutil.Walk(fs, root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		symlinker, canSymlink := fs.(absfs.Symlinker)
+		// links to directories in a VFS have both ModeDir and ModeSymlink set
+		isLink := info.Mode()&os.ModeSymlink != 0
+		if isLink && !canSymlink || !isLink && !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		name := strings.TrimPrefix(toSlash(fs, p), prefix)
+		name = strings.TrimLeft(name, "/")
+		if name == "" {
+			return nil
+		}
+
+		if isLink {
+			target, err := symlinker.Readlink(p)
+			if err != nil {
+				return err
+			}
+			return addLink(tw, info, name, tar.TypeSymlink, target)
+		}
+		if node, ok := info.Sys().(*inode.Inode); ok && info.Mode().IsRegular() && node.Nlink > 1 {
+			if first, ok := links[node]; ok {
+				return addLink(tw, info, name, tar.TypeLink, first)
+			}
+			links[node] = name
+		}
+
+		return addFile(tw, fs, p, name, info)
+	})
+}
+
+// addLink adds a symbolic or hard link named name to tw.
+func addLink(tw *tar.Writer, info os.FileInfo, name string, typ byte, target string) error {
+	hdr := &tar.Header{
+		Typeflag: typ,
+		Name:     name,
+		Linkname: target,
+		Mode:     int64(info.Mode().Perm()),
+		ModTime:  info.ModTime(),
+		Format:   tar.FormatPAX,
+	}
+
+	return tw.WriteHeader(hdr)
+}
+
+func addFile(tw *tar.Writer, fs absfs.FileSystem, p, name string, info os.FileInfo) error {
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	// PAX headers keep times to the nanosecond
+	hdr.Format = tar.FormatPAX
+	if a, ok := info.(accessTimer); ok {
+		hdr.AccessTime = a.AccessTime()
+	}
+	attrs, err := ioutil.ReadXattrs(fs, p)
+	if err != nil {
+		return err
+	}
+	for attr, value := range attrs {
+		if hdr.PAXRecords == nil {
+			hdr.PAXRecords = make(map[string]string)
+		}
+		hdr.PAXRecords[xattrPrefix+attr] = string(value)
+	}
+	if t, ok := fs.(absfs.Tagger); ok {
+		tags, err := t.Tags(p)
+		if err != nil {
+			return err
+		}
+		for key, value := range tags {
+			if hdr.PAXRecords == nil {
+				hdr.PAXRecords = make(map[string]string)
+			}
+			hdr.PAXRecords[tagPrefix+key] = value
+		}
+	}
+	if info.IsDir() {
+		hdr.Name += "/"
+		return tw.WriteHeader(hdr)
+	}
+
+	f, err := fs.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err = io.CopyN(tw, f, hdr.Size)
+
+	return err
+}
+
+// Extract extracts the regular files and directories of the tar archive
+// read from r into dir on fs, along with their extended attributes and
+// tags if fs supports them. The times of files are restored through their
+// open handles if they are absfs.ChtimesFiles. Hard links are extracted if
+// fs is an absfs.Linker, and symbolic links if it's an absfs.Symlinker;
+// symbolic links are created last, so no entry is extracted through one.
+// Entries with absolute names or names containing .. elements that leave
+// dir are rejected with ErrBadPath.
+func Extract(r io.Reader, fs absfs.FileSystem, dir string) error {
+	tr := tar.NewReader(r)
+
+	// symbolic links and their targets, in order
+	var symlinks [][2]string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name, ok := cleanName(hdr.Name)
+		if !ok {
+			return &os.PathError{Op: "extract", Path: hdr.Name, Err: ErrBadPath}
+		}
+		if name == "." {
+			continue
+		}
+		target := join(fs, dir, name)
+		mode := hdr.FileInfo().Mode()
+
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			if _, ok := fs.(absfs.Symlinker); ok {
+				symlinks = append(symlinks, [2]string{hdr.Linkname, target})
+			}
+			continue
+		case tar.TypeLink:
+			linker, ok := fs.(absfs.Linker)
+			if !ok {
+				continue
+			}
+			old, ok := cleanName(hdr.Linkname)
+			if !ok {
+				return &os.PathError{Op: "extract", Path: hdr.Linkname, Err: ErrBadPath}
+			}
+			fs.Remove(target)
+			if err := linker.Link(join(fs, dir, old), target); err != nil {
+				return err
+			}
+			continue
+		case tar.TypeDir:
+			if err := fs.MkdirAll(target, mode.Perm()); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if i := strings.LastIndexByte(target, fs.Separator()); i > 0 {
+				if err := fs.MkdirAll(target[:i], 0755); err != nil {
+					return err
+				}
+			}
+			if err := extractFile(tr, fs, target, mode.Perm(), hdr); err != nil {
+				return err
+			}
+		default:
+			continue
+		}
+
+		if err := ioutil.WriteXattrs(fs, target, xattrs(hdr)); err != nil {
+			return err
+		}
+		if t, ok := fs.(absfs.Tagger); ok {
+			for key, value := range hdr.PAXRecords {
+				if !strings.HasPrefix(key, tagPrefix) {
+					continue
+				}
+				if err := t.Tag(target, strings.TrimPrefix(key, tagPrefix), value); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	for _, link := range symlinks {
+		if i := strings.LastIndexByte(link[1], fs.Separator()); i > 0 {
+			if err := fs.MkdirAll(link[1][:i], 0755); err != nil {
+				return err
+			}
+		}
+		if err := fs.(absfs.Symlinker).Symlink(link[0], link[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cleanName returns the cleaned name of an archive entry, and whether it
+// stays within the directory the archive is extracted into.
+func cleanName(name string) (string, bool) {
+	name = path.Clean(name)
+	if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+		return name, false
+	}
+
+	return name, true
+}
+
+// join returns the path of the archive entry name extracted into dir.
+func join(fs absfs.FileSystem, dir, name string) string {
+	return strings.TrimRight(dir, string(fs.Separator())) + string(fs.Separator()) + fromSlash(fs, name)
+}
+
+// xattrs returns the extended attributes stored in hdr.
+func xattrs(hdr *tar.Header) map[string][]byte {
+	var attrs map[string][]byte
+	for key, value := range hdr.PAXRecords {
+		if !strings.HasPrefix(key, xattrPrefix) {
+			continue
+		}
+		if attrs == nil {
+			attrs = make(map[string][]byte)
+		}
+		attrs[strings.TrimPrefix(key, xattrPrefix)] = []byte(value)
+	}
+
+	return attrs
+}
+
+// extractFile writes the contents of the file in r to name, and sets its
+// times to those in hdr before closing it if the File supports that.
+func extractFile(r io.Reader, fs absfs.FileSystem, name string, perm os.FileMode, hdr *tar.Header) error {
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cf, ok := f.(absfs.ChtimesFile); ok && err == nil {
+		atime := hdr.AccessTime
+		if atime.IsZero() {
+			atime = hdr.ModTime
+		}
+		err = cf.Chtimes(atime, hdr.ModTime)
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+
+	return err
+}
+
+func toSlash(fs absfs.FileSystem, p string) string {
+	if fs.Separator() == '/' {
+		return p
+	}
+	return strings.ReplaceAll(p, string(fs.Separator()), "/")
+}
+
+func fromSlash(fs absfs.FileSystem, p string) string {
+	if fs.Separator() == '/' {
+		return p
+	}
+	return strings.ReplaceAll(p, "/", string(fs.Separator()))
+}
@@ -0,0 +1,194 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+func TestRoundTrip(t *testing.T) {
+	src := vfs.NewFS()
+	if err := src.MkdirAll("/secrets/db", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(src, "/secrets/db/pass", []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Setxattr("/secrets/db/pass", "user.owner", []byte("dba")); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Tag("/secrets/db/pass", "env", "prod"); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2021, 6, 1, 12, 0, 0, 123456789, time.UTC)
+	atime := mtime.Add(time.Hour)
+	if err := src.Chtimes("/secrets/db/pass", atime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, src, "/secrets"); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := vfs.NewFS()
+	if err := Extract(&buf, dst, "/"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(dst, "/secrets/db/pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hunter2" {
+		t.Errorf("wrong contents: %q", data)
+	}
+	owner, err := dst.Getxattr("/secrets/db/pass", "user.owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(owner) != "dba" {
+		t.Errorf("wrong extended attribute: %q", owner)
+	}
+	if got := dst.FindByTag("env", "prod"); len(got) != 1 || got[0] != "/secrets/db/pass" {
+		t.Errorf("tags not restored: %v", got)
+	}
+	info, err := dst.Stat("/secrets/db/pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("wrong mtime: %v, want %v", info.ModTime(), mtime)
+	}
+	if got := info.(*vfs.FileInfo).AccessTime(); !got.Equal(atime) {
+		t.Errorf("wrong atime: %v, want %v", got, atime)
+	}
+}
+
+func TestExtractBadPath(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "../escape", Mode: 0600, Typeflag: tar.TypeReg})
+	tw.Close()
+
+	err := Extract(&buf, vfs.NewFS(), "/dir")
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != ErrBadPath {
+		t.Fatalf("expected ErrBadPath, got %v", err)
+	}
+}
+
+func TestRoundTripLinks(t *testing.T) {
+	src := vfs.NewFS()
+	if err := src.MkdirAll("/keys", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(src, "/keys/id", []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Link("/keys/id", "/keys/id.bak"); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Symlink("id", "/keys/current"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, src, "/keys"); err != nil {
+		t.Fatal(err)
+	}
+	dst := vfs.NewFS()
+	if err := Extract(&buf, dst, "/"); err != nil {
+		t.Fatal(err)
+	}
+
+	if target, err := dst.Readlink("/keys/current"); err != nil || target != "id" {
+		t.Errorf("symbolic link: %q, %v", target, err)
+	}
+	if data, err := ioutil.ReadFile(dst, "/keys/current"); err != nil || string(data) != "secret" {
+		t.Errorf("reading through the link: %q, %v", data, err)
+	}
+	id, err := dst.Stat("/keys/id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bak, err := dst.Stat("/keys/id.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !vfs.SameFile(id.(*vfs.FileInfo), bak.(*vfs.FileInfo)) {
+		t.Error("hard link was extracted as a copy")
+	}
+
+	// hard links must stay within the destination
+	buf.Reset()
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Typeflag: tar.TypeLink, Name: "link", Linkname: "../../etc/passwd"})
+	tw.Close()
+	if err := Extract(&buf, vfs.NewFS(), "/dst"); !errors.Is(err, ErrBadPath) {
+		t.Errorf("expected ErrBadPath, got %v", err)
+	}
+}
+
+func TestExtractZip(t *testing.T) {
+	modified := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	if _, err := zw.Create("secrets/"); err != nil {
+		t.Fatal(err)
+	}
+	hdr := &zip.FileHeader{Name: "secrets/pass", Method: zip.Deflate, Modified: modified}
+	hdr.SetMode(0600)
+	w, err := zw.CreateHeader(hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("hunter2"))
+	hdr = &zip.FileHeader{Name: "secrets/link"}
+	hdr.SetMode(os.ModeSymlink | 0777)
+	w, err = zw.CreateHeader(hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("pass"))
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := vfs.NewFS()
+	if err := ExtractZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()), dst, "/"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(dst, "/secrets/pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hunter2" {
+		t.Errorf("wrong contents: %q", data)
+	}
+	info, err := dst.Stat("/secrets/pass")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 || !info.ModTime().Equal(modified) {
+		t.Errorf("wrong mode or time: %v %v", info.Mode(), info.ModTime())
+	}
+	if target, err := dst.Readlink("/secrets/link"); err != nil || target != "pass" {
+		t.Errorf("wrong link: %q %v", target, err)
+	}
+
+	buf.Reset()
+	zw = zip.NewWriter(&buf)
+	zw.Create("../escape")
+	zw.Close()
+	err = ExtractZip(bytes.NewReader(buf.Bytes()), int64(buf.Len()), vfs.NewFS(), "/")
+	if !errors.Is(err, ErrBadPath) {
+		t.Errorf("expected ErrBadPath, got %v", err)
+	}
+}
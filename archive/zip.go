@@ -0,0 +1,109 @@
+package archive
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// ExtractZip extracts the regular files and directories of the zip
+// archive of size bytes read from r into dir on fs, like Extract does for
+// tar archives. Symbolic links are extracted last if fs is an
+// absfs.Symlinker. Entries with absolute names or names containing ..
+// elements that leave dir are rejected with ErrBadPath.
+func ExtractZip(r io.ReaderAt, size int64, fs absfs.FileSystem, dir string) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return err
+	}
+
+	// symbolic links and their targets, in order
+	var symlinks [][2]string
+	for _, zf := range zr.File {
+		name, ok := cleanName(zf.Name)
+		if !ok {
+			return &os.PathError{Op: "extract", Path: zf.Name, Err: ErrBadPath}
+		}
+		if name == "." {
+			continue
+		}
+		target := join(fs, dir, name)
+		mode := zf.Mode()
+
+		switch {
+		case mode&os.ModeSymlink != 0:
+			if _, ok := fs.(absfs.Symlinker); !ok {
+				continue
+			}
+			linkname, err := readZipFile(zf)
+			if err != nil {
+				return err
+			}
+			symlinks = append(symlinks, [2]string{string(linkname), target})
+		case mode.IsDir():
+			if err := fs.MkdirAll(target, mode.Perm()); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			if i := strings.LastIndexByte(target, fs.Separator()); i > 0 {
+				if err := fs.MkdirAll(target[:i], 0755); err != nil {
+					return err
+				}
+			}
+			if err := extractZipFile(zf, fs, target, mode.Perm()); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, link := range symlinks {
+		if i := strings.LastIndexByte(link[1], fs.Separator()); i > 0 {
+			if err := fs.MkdirAll(link[1][:i], 0755); err != nil {
+				return err
+			}
+		}
+		if err := fs.(absfs.Symlinker).Symlink(link[0], link[1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readZipFile returns the contents of zf.
+func readZipFile(zf *zip.File) ([]byte, error) {
+	rc, err := zf.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// extractZipFile writes the contents of zf to name, and sets its times to
+// the time it was modified before closing it if the File supports that.
+func extractZipFile(zf *zip.File, fs absfs.FileSystem, name string, perm os.FileMode) error {
+	rc, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, rc)
+	if cf, ok := f.(absfs.ChtimesFile); ok && err == nil {
+		err = cf.Chtimes(zf.Modified, zf.Modified)
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+
+	return err
+}
@@ -0,0 +1,74 @@
+package pandorasbox
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ChmodAll changes the permission bits of root and every file and
+// directory under it, keeping their types. Symbolic links aren't followed
+// or changed.
+func (b *Box) ChmodAll(root string, mode os.FileMode) error {
+	fs, vfsRoot, ok, err := b.resolveVFS(root)
+	if err != nil {
+		return &os.PathError{Op: "chmod", Path: root, Err: err}
+	}
+	if ok {
+		return fs.ChmodAll(vfsRoot, mode)
+	}
+
+	return walkHost(root, func(p string, info os.FileInfo) error {
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		return os.Chmod(p, mode)
+	})
+}
+
+// ChownAll changes the owner and group of root and everything under it.
+// Symbolic links themselves are changed.
+func (b *Box) ChownAll(root string, uid, gid int) error {
+	fs, vfsRoot, ok, err := b.resolveVFS(root)
+	if err != nil {
+		return &os.PathError{Op: "chown", Path: root, Err: err}
+	}
+	if ok {
+		return fs.ChownAll(vfsRoot, uid, gid)
+	}
+
+	return walkHost(root, func(p string, info os.FileInfo) error {
+		return os.Lchown(p, uid, gid)
+	})
+}
+
+// ChtimesAll changes the access and modification times of root and
+// everything under it. Symbolic links on the host's filesystem are left
+// as they are, as their times can't be set portably.
+func (b *Box) ChtimesAll(root string, atime, mtime time.Time) error {
+	fs, vfsRoot, ok, err := b.resolveVFS(root)
+	if err != nil {
+		return &os.PathError{Op: "chtimes", Path: root, Err: err}
+	}
+	if ok {
+		return fs.ChtimesAll(vfsRoot, atime, mtime)
+	}
+
+	return walkHost(root, func(p string, info os.FileInfo) error {
+		if info.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+		return os.Chtimes(p, atime, mtime)
+	})
+}
+
+// walkHost calls fn for root and everything under it on the host's
+// filesystem, stopping at the first error.
+func walkHost(root string, fn func(p string, info os.FileInfo) error) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return fn(p, info)
+	})
+}
@@ -0,0 +1,204 @@
+package pandorasbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/awnumar/memguard"
+	"github.com/capnspacehook/pandorasbox/osfs"
+)
+
+const (
+	backupPrefix = "pandorasbox-"
+	backupSuffix = ".pbx"
+
+	// backupTime is the layout of the time a backup was taken in its name,
+	// which sorts in the order backups were taken.
+	backupTime = "20060102T150405.000000000Z"
+)
+
+// BackupOptions configure the backups RunBackups takes.
+type BackupOptions struct {
+	// Dir is the directory on the host's filesystem backups are written
+	// to. It's created if it doesn't exist.
+	Dir string
+
+	// Key is the key backups are encrypted under. Backups are images
+	// written by vfs.FileSystem.Save, and are restored with vfs.Load.
+	Key *memguard.Enclave
+
+	// KeyProvider provides the key backups are encrypted under if Key is
+	// nil. It's asked for the key before every backup.
+	KeyProvider KeyProvider
+
+	// Interval is how often a backup is taken. It defaults to an hour.
+	Interval time.Duration
+
+	// Retention decides which backups are kept once a backup is taken.
+	Retention Retention
+
+	// Logger receives errors taking or pruning backups at
+	// slog.LevelError. It defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Retention is a grandfather-father-son retention policy. The newest
+// backup taken in each of the last Hourly hours, Daily days, Weekly weeks
+// and Monthly months that have backups is kept, and so is the newest
+// backup of all. A backup can be kept for more than one period. Periods
+// are in UTC, and weeks are ISO weeks.
+type Retention struct {
+	Hourly  int
+	Daily   int
+	Weekly  int
+	Monthly int
+}
+
+// Backup writes an image of the default VFS encrypted under key to a new
+// file in the directory dir on the host's filesystem, and returns its
+// name. The file is named after the time the backup was taken, and is
+// written atomically, so a backup is never left half written.
+func (b *Box) Backup(dir string, key *memguard.Enclave) (string, error) {
+	var buf bytes.Buffer
+	if err := b.vfs.Save(&buf, key); err != nil {
+		return "", err
+	}
+
+	name := filepath.Join(dir, backupPrefix+time.Now().UTC().Format(backupTime)+backupSuffix)
+	if err := osfs.WriteFileAtomic(name, buf.Bytes(), 0600); err != nil {
+		return "", err
+	}
+
+	return name, nil
+}
+
+// RunBackups takes a backup of the default VFS with Backup every
+// opts.Interval, starting right away, until ctx is done. After every
+// backup, the backups in opts.Dir not kept by opts.Retention are removed
+// with PruneBackups. Errors are logged, and don't stop RunBackups.
+//
+// RunBackups returns the context's error once ctx is done, or the error
+// that kept it from starting.
+func (b *Box) RunBackups(ctx context.Context, opts *BackupOptions) error {
+	if opts == nil || opts.Dir == "" || opts.Key == nil && opts.KeyProvider == nil {
+		return errors.New("backups need a directory and a key")
+	}
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	log := opts.Logger
+	if log == nil {
+		log = slog.Default()
+	}
+	if err := os.MkdirAll(opts.Dir, 0700); err != nil {
+		return err
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		key := opts.Key
+		var err error
+		if key == nil {
+			key, err = opts.KeyProvider.Key(ctx)
+		}
+		if err == nil {
+			_, err = b.Backup(opts.Dir, key)
+		}
+		if err != nil {
+			log.Error("backup failed", "dir", opts.Dir, "err", err)
+		} else if _, err := PruneBackups(opts.Dir, opts.Retention); err != nil {
+			log.Error("pruning backups failed", "dir", opts.Dir, "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// PruneBackups removes the backups written by Backup to the directory dir
+// that keep doesn't keep, and returns their names. Other files in dir are
+// left alone.
+func PruneBackups(dir string, keep Retention) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type backup struct {
+		name  string
+		taken time.Time
+	}
+	var backups []backup
+	for _, e := range entries {
+		name := e.Name()
+		if !e.Type().IsRegular() || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, backupSuffix) {
+			continue
+		}
+		taken, err := time.Parse(backupTime, strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), backupSuffix))
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{filepath.Join(dir, name), taken})
+	}
+	// newest first
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].taken.After(backups[j].taken)
+	})
+
+	kept := make([]bool, len(backups))
+	if len(backups) != 0 {
+		kept[0] = true
+	}
+	periods := []struct {
+		n      int
+		period func(t time.Time) string
+	}{
+		{keep.Hourly, func(t time.Time) string { return t.Format("2006010215") }},
+		{keep.Daily, func(t time.Time) string { return t.Format("20060102") }},
+		{keep.Weekly, func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%dW%02d", year, week)
+		}},
+		{keep.Monthly, func(t time.Time) string { return t.Format("200601") }},
+	}
+	for _, p := range periods {
+		var last string
+		seen := 0
+		for i, bk := range backups {
+			if seen == p.n {
+				break
+			}
+			if period := p.period(bk.taken); period != last {
+				last = period
+				seen++
+				kept[i] = true
+			}
+		}
+	}
+
+	var removed []string
+	for i, bk := range backups {
+		if kept[i] {
+			continue
+		}
+		if err := os.Remove(bk.name); err != nil {
+			return removed, err
+		}
+		removed = append(removed, bk.name)
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,93 @@
+package pandorasbox
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/seal"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+func TestBackup(t *testing.T) {
+	b := NewBox()
+	if err := b.WriteFile("vfs://secret", []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	key := seal.NewKey()
+	name, err := b.Backup(t.TempDir(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("hunter2")) {
+		t.Fatal("backup holds the plaintext")
+	}
+
+	if _, err := vfs.Load(bytes.NewReader(data), seal.NewKey()); err == nil {
+		t.Error("loaded a backup under the wrong key")
+	}
+	fs, err := vfs.Load(bytes.NewReader(data), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := ioutil.ReadFile(fs, "/secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(restored) != "hunter2" {
+		t.Errorf("restored %q, want %q", restored, "hunter2")
+	}
+}
+
+func TestPruneBackups(t *testing.T) {
+	dir := t.TempDir()
+	taken := []time.Time{
+		time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC),
+		time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC),
+		time.Date(2024, 1, 2, 9, 0, 0, 0, time.UTC),
+	}
+	var names []string
+	for _, ts := range taken {
+		name := filepath.Join(dir, backupPrefix+ts.Format(backupTime)+backupSuffix)
+		if err := os.WriteFile(name, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	other := filepath.Join(dir, "notes.txt")
+	if err := os.WriteFile(other, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := PruneBackups(dir, Retention{Hourly: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(removed)
+	// the newest backups of 2024-01-02 09h and 2024-01-01 11h are kept
+	if want := names[:2]; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed %v, want %v", removed, want)
+	}
+	if _, err := os.Stat(other); err != nil {
+		t.Errorf("removed a file that isn't a backup: %v", err)
+	}
+
+	removed, err = PruneBackups(dir, Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the newest backup of all is always kept
+	if want := names[2:3]; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed %v, want %v", removed, want)
+	}
+}
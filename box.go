@@ -5,10 +5,14 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/awnumar/memguard"
 	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/internal/errno"
 	"github.com/capnspacehook/pandorasbox/ioutil"
 	"github.com/capnspacehook/pandorasbox/osfs"
 	"github.com/capnspacehook/pandorasbox/vfs"
@@ -17,94 +21,238 @@ import (
 type Box struct {
 	osfs *osfs.FileSystem
 	vfs  *vfs.FileSystem
+
+	mtx      sync.RWMutex
+	resolver Resolver
+	schemes  *Schemes
+	expiry   map[expiryKey]*expiry
 }
 
 func NewBox() *Box {
 	box := new(Box)
 	box.osfs = osfs.NewFS()
 	box.vfs = vfs.NewFS()
+	box.schemes = NewSchemes(box.vfs)
+	box.resolver = box.schemes
 
 	return box
 }
 
 func (b *Box) Abs(path string) (string, error) {
-	if vfsPath, ok := ConvertVFSPath(path); ok {
-		absPath, err := b.vfs.Abs(vfsPath)
+	fs, vfsPath, ok, err := b.resolveVFS(path)
+	if err != nil {
+		return "", &os.PathError{Op: "abs", Path: path, Err: err}
+	}
+	if ok {
+		absPath, err := fs.Abs(vfsPath)
 		if err != nil {
 			return "", err
 		}
-		return MakeVFSPath(absPath), nil
+		// keep the part of path that selected the VFS
+		rel := strings.TrimPrefix(vfsPath, "/")
+		if !strings.HasSuffix(path, rel) {
+			return MakeVFSPath(absPath), nil
+		}
+		return path[:len(path)-len(rel)] + strings.TrimPrefix(absPath, "/"), nil
 	}
 
 	return b.osfs.Abs(path)
 }
 
 func (b *Box) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
-	if vfsName, ok := ConvertVFSPath(name); ok {
-		return b.vfs.OpenFile(vfsName, flag, perm)
+	b.reapExpired()
+	name, opts, err := ParsePathOptions(name)
+	if err != nil {
+		return &absfs.InvalidFile{Path: name}, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if opts.HasMode {
+		perm = opts.Mode
+	}
+
+	target, err := b.followLinks(name)
+	if err != nil {
+		return &absfs.InvalidFile{Path: name}, err
+	}
+	var f absfs.File
+	fs, vfsName, ok, err := b.resolveVFS(target)
+	if err != nil {
+		return &absfs.InvalidFile{Path: name}, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if ok {
+		f, err = fs.OpenFile(vfsName, flag, perm)
+	} else {
+		f, err = b.osfs.OpenFile(target, flag, perm)
+	}
+	if err != nil {
+		return f, errno.Map(err)
+	}
+	if err := b.applyOptions(name, fs, vfsName, flag, opts); err != nil {
+		f.Close()
+		return &absfs.InvalidFile{Path: name}, err
 	}
 
-	return b.osfs.OpenFile(name, flag, perm)
+	return f, nil
 }
 
 func (b *Box) Mkdir(name string, perm os.FileMode) error {
-	if vfsName, ok := ConvertVFSPath(name); ok {
-		return b.vfs.Mkdir(vfsName, perm)
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if ok {
+		return fs.Mkdir(vfsName, perm)
 	}
 
-	return b.osfs.Mkdir(name, perm)
+	return errno.Map(b.osfs.Mkdir(name, perm))
 }
 
 func (b *Box) Remove(name string) error {
-	if vfsName, ok := ConvertVFSPath(name); ok {
-		return b.vfs.Remove(vfsName)
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	if ok {
+		if err := fs.Remove(vfsName); err != nil {
+			return err
+		}
+		b.cancelVFSExpiry(fs, vfsName)
+		return nil
 	}
 
-	return b.osfs.Remove(name)
+	return errno.Map(b.osfs.Remove(name))
 }
 
+// Rename renames oldpath to newpath. A file on the host's filesystem can be
+// moved into a VFS, in which case it is copied and the original is removed
+// with SecureRemove.
 func (b *Box) Rename(oldpath, newpath string) error {
-	vfsOldPath, oldPathVFS := ConvertVFSPath(oldpath)
-	vfsNewPath, newPathVFS := ConvertVFSPath(newpath)
+	oldFS, vfsOldPath, oldPathVFS, err := b.resolveVFS(oldpath)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
+	newFS, vfsNewPath, newPathVFS, err := b.resolveVFS(newpath)
+	if err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err}
+	}
 	if oldPathVFS && newPathVFS {
-		return b.vfs.Rename(vfsOldPath, vfsNewPath)
-	} else if (oldPathVFS && !newPathVFS) || (!oldPathVFS && newPathVFS) {
-		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errors.New("oldpath and newpath must both either be a VFS path, or normal path")}
+		if oldFS != newFS {
+			return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errCrossBoxOp}
+		}
+		if err := oldFS.Rename(vfsOldPath, vfsNewPath); err != nil {
+			return err
+		}
+		b.moveExpiry(oldFS, vfsOldPath, vfsNewPath)
+		return nil
+	} else if newPathVFS {
+		// moving a file into the VFS must not leave the plaintext behind
+		if err := b.moveIntoVFS("rename", oldpath, newFS, vfsNewPath, newpath); err != nil {
+			return err
+		}
+		b.cancelVFSExpiry(newFS, vfsNewPath)
+		return nil
+	} else if oldPathVFS {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errors.New("VFS files can't be moved to the host's filesystem")}
 	}
 
-	return b.osfs.Rename(oldpath, newpath)
+	return errno.Map(b.osfs.Rename(oldpath, newpath))
 }
 
 func (b *Box) Stat(name string) (os.FileInfo, error) {
-	if vfsName, ok := ConvertVFSPath(name); ok {
-		return b.vfs.Stat(vfsName)
+	b.reapExpired()
+	name, err := b.followLinks(name)
+	if err != nil {
+		return nil, err
+	}
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	if ok {
+		return fs.Stat(vfsName)
+	}
+
+	info, err := b.osfs.Stat(name)
+	return info, errno.Map(err)
+}
+
+// StatMany is like calling Stat for each of paths, with the results in the
+// same order. The paths in each VFS are resolved together while it's locked
+// once; see vfs.FileSystem.StatMany.
+func (b *Box) StatMany(paths []string) []vfs.StatResult {
+	results := make([]vfs.StatResult, len(paths))
+
+	type batch struct {
+		names   []string
+		indexes []int
+	}
+	batches := make(map[*vfs.FileSystem]*batch)
+	for i, name := range paths {
+		name, err := b.followLinks(name)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		fs, vfsName, ok, err := b.resolveVFS(name)
+		if err != nil {
+			results[i].Err = &os.PathError{Op: "stat", Path: name, Err: err}
+			continue
+		}
+		if !ok {
+			info, err := b.osfs.Stat(name)
+			results[i] = vfs.StatResult{Info: info, Err: errno.Map(err)}
+			continue
+		}
+		bt := batches[fs]
+		if bt == nil {
+			bt = new(batch)
+			batches[fs] = bt
+		}
+		bt.names = append(bt.names, vfsName)
+		bt.indexes = append(bt.indexes, i)
+	}
+	for fs, bt := range batches {
+		for j, result := range fs.StatMany(bt.names) {
+			results[bt.indexes[j]] = result
+		}
 	}
 
-	return b.osfs.Stat(name)
+	return results
 }
 
 func (b *Box) Chmod(name string, mode os.FileMode) error {
-	if vfsName, ok := ConvertVFSPath(name); ok {
-		return b.vfs.Chmod(vfsName, mode)
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return &os.PathError{Op: "chmod", Path: name, Err: err}
+	}
+	if ok {
+		return fs.Chmod(vfsName, mode)
 	}
 
-	return b.osfs.Chmod(name, mode)
+	return errno.Map(b.osfs.Chmod(name, mode))
 }
 
 func (b *Box) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	if vfsName, ok := ConvertVFSPath(name); ok {
-		return b.vfs.Chtimes(vfsName, atime, mtime)
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	if ok {
+		return fs.Chtimes(vfsName, atime, mtime)
 	}
 
-	return b.osfs.Chtimes(name, atime, mtime)
+	return errno.Map(b.osfs.Chtimes(name, atime, mtime))
 }
 
 func (b *Box) Chown(name string, uid, gid int) error {
-	if vfsName, ok := ConvertVFSPath(name); ok {
-		return b.vfs.Chown(vfsName, uid, gid)
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return &os.PathError{Op: "chown", Path: name, Err: err}
+	}
+	if ok {
+		return fs.Chown(vfsName, uid, gid)
 	}
 
-	return b.osfs.Chown(name, uid, gid)
+	return errno.Map(b.osfs.Chown(name, uid, gid))
 }
 
 func (b *Box) Separator(vfsMode bool) uint8 {
@@ -136,7 +284,7 @@ func (b *Box) Chdir(dir string, vfsMode bool) error {
 		return b.vfs.Chdir(dir)
 	}
 
-	return b.osfs.Chdir(dir)
+	return errno.Map(b.osfs.Chdir(dir))
 }
 
 func (b *Box) Getwd(vfsMode bool) (string, error) {
@@ -156,90 +304,188 @@ func (b *Box) GetTempDir(vfsMode bool) string {
 }
 
 func (b *Box) Open(name string) (absfs.File, error) {
-	if vfsName, ok := ConvertVFSPath(name); ok {
-		return b.vfs.Open(vfsName)
-	}
-
-	return b.osfs.Open(name)
+	return b.OpenFile(name, os.O_RDONLY, 0)
 }
 
 func (b *Box) Create(name string) (absfs.File, error) {
-	if vfsName, ok := ConvertVFSPath(name); ok {
-		return b.vfs.Create(vfsName)
+	// match the permissions Create of the VFS and os use
+	perm := os.FileMode(0666)
+	if _, _, ok, _ := b.resolveVFS(name); ok {
+		perm = 0644
 	}
 
-	return b.osfs.Create(name)
+	return b.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_TRUNC, perm)
 }
 
 func (b *Box) MkdirAll(name string, perm os.FileMode) error {
-	if vfsName, ok := ConvertVFSPath(name); ok {
-		return b.vfs.MkdirAll(vfsName, perm)
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	if ok {
+		return fs.MkdirAll(vfsName, perm)
 	}
 
-	return b.osfs.MkdirAll(name, perm)
+	return errno.Map(b.osfs.MkdirAll(name, perm))
 }
 
 func (b *Box) RemoveAll(path string) error {
-	if vfsPath, ok := ConvertVFSPath(path); ok {
-		return b.vfs.RemoveAll(vfsPath)
+	fs, vfsPath, ok, err := b.resolveVFS(path)
+	if err != nil {
+		return &os.PathError{Op: "removeall", Path: path, Err: err}
+	}
+	if ok {
+		if err := fs.RemoveAll(vfsPath); err != nil {
+			return err
+		}
+		b.cancelVFSExpiry(fs, vfsPath)
+		return nil
 	}
 
-	return b.osfs.RemoveAll(path)
+	return errno.Map(b.osfs.RemoveAll(path))
 }
 
 func (b *Box) Truncate(name string, size int64) error {
-	if vfsName, ok := ConvertVFSPath(name); ok {
-		return b.vfs.Truncate(vfsName, size)
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return &os.PathError{Op: "truncate", Path: name, Err: err}
+	}
+	if ok {
+		return fs.Truncate(vfsName, size)
 	}
 
-	return b.osfs.Truncate(name, size)
+	return errno.Map(b.osfs.Truncate(name, size))
 }
 
 func (b *Box) Lstat(name string) (os.FileInfo, error) {
-	if vfsName, ok := ConvertVFSPath(name); ok {
-		return b.vfs.Lstat(vfsName)
+	b.reapExpired()
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	if ok {
+		return fs.Lstat(vfsName)
 	}
 
-	return b.osfs.Lstat(name)
+	info, err := b.osfs.Lstat(name)
+	return info, errno.Map(err)
 }
 
 func (b *Box) Lchown(name string, uid, gid int) error {
-	if vfsName, ok := ConvertVFSPath(name); ok {
-		return b.vfs.Lchown(vfsName, uid, gid)
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return &os.PathError{Op: "lchown", Path: name, Err: err}
+	}
+	if ok {
+		return fs.Lchown(vfsName, uid, gid)
 	}
 
-	return b.osfs.Lchown(name, uid, gid)
+	return errno.Map(b.osfs.Lchown(name, uid, gid))
 }
 
 func (b *Box) Readlink(name string) (string, error) {
-	if vfsName, ok := ConvertVFSPath(name); ok {
-		return b.vfs.Readlink(vfsName)
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	if ok {
+		target, err := fs.Readlink(vfsName)
+		return strings.TrimPrefix(target, hostLinkPrefix), err
 	}
 
-	return b.osfs.Readlink(name)
+	target, err := b.osfs.Readlink(name)
+	return target, errno.Map(err)
 }
 
+// Symlink creates newname as a symbolic link to oldname. Links in a VFS
+// can point at files in another VFS or on the host's filesystem, and are
+// followed by Stat and Open, but links on the host's filesystem can't
+// point into a VFS.
 func (b *Box) Symlink(oldname, newname string) error {
-	vfsOldName, oldNameVFS := ConvertVFSPath(oldname)
-	vfsNewName, newNameVFS := ConvertVFSPath(newname)
+	oldFS, vfsOldName, oldNameVFS, err := b.resolveVFS(oldname)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	newFS, vfsNewName, newNameVFS, err := b.resolveVFS(newname)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	switch {
+	case oldNameVFS && newNameVFS && oldFS == newFS:
+		return newFS.Symlink(vfsOldName, vfsNewName)
+	case oldNameVFS && newNameVFS:
+		return newFS.Symlink(oldname, vfsNewName)
+	case newNameVFS:
+		abs, err := b.osfs.Abs(oldname)
+		if err != nil {
+			return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+		}
+		return newFS.Symlink(hostLinkPrefix+abs, vfsNewName)
+	case oldNameVFS:
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: errors.New("symbolic links on the host's filesystem can't point into a VFS")}
+	}
+
+	return errno.Map(b.osfs.Symlink(oldname, newname))
+}
+
+func (b *Box) Link(oldname, newname string) error {
+	oldFS, vfsOldName, oldNameVFS, err := b.resolveVFS(oldname)
+	if err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	newFS, vfsNewName, newNameVFS, err := b.resolveVFS(newname)
+	if err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
 	if oldNameVFS && newNameVFS {
-		return b.vfs.Rename(vfsOldName, vfsNewName)
-	} else if (oldNameVFS && !newNameVFS) || (!oldNameVFS && newNameVFS) {
-		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: errors.New("oldname and newname must both either be a VFS path, or normal path")}
+		if oldFS != newFS {
+			return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: errCrossBoxOp}
+		}
+		return oldFS.Link(vfsOldName, vfsNewName)
+	} else if oldNameVFS || newNameVFS {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: errors.New("oldname and newname must both either be a VFS path, or normal path")}
 	}
 
-	return b.osfs.Symlink(oldname, newname)
+	return errno.Map(b.osfs.Link(oldname, newname))
 }
 
+// Walk walks the file tree rooted at root like filepath.Walk. Files are
+// walked in lexical order on both the host's filesystem and VFSs, and
+// symbolic links are treated according to the Box's SymlinkPolicy.
 func (b *Box) Walk(root string, walkFn filepath.WalkFunc) error {
-	if vfsPath, ok := ConvertVFSPath(root); ok {
-		root = vfsPath
-		return b.vfs.Walk(root, walkFn)
+	fs, vfsPath, ok, err := b.resolveVFS(root)
+	if err != nil {
+		return &os.PathError{Op: "walk", Path: root, Err: err}
+	}
+	if ok {
+		return ioutil.WalkSymlinks(fs, vfsPath, b.osfs.Symlinks, walkFn)
 	}
 
 	return b.osfs.Walk(root, walkFn)
 }
 
+// WalkParallel walks the file tree rooted at root like Walk, but walks
+// subdirectories concurrently using at most workers goroutines, calling fn
+// concurrently. Symbolic links are not followed. See ioutil.WalkParallel.
+func (b *Box) WalkParallel(root string, workers int, fn filepath.WalkFunc) error {
+	fs, vfsPath, ok, err := b.resolveVFS(root)
+	if err != nil {
+		return &os.PathError{Op: "walk", Path: root, Err: err}
+	}
+	if ok {
+		return ioutil.WalkParallel(fs, vfsPath, workers, fn)
+	}
+
+	return ioutil.WalkParallel(b.osfs, root, workers, fn)
+}
+
+// SetSymlinkPolicy sets whether Walk follows symbolic links. By default
+// they are reported without being followed. It should be called before the
+// Box is used.
+func (b *Box) SetSymlinkPolicy(policy absfs.SymlinkPolicy) {
+	b.osfs.Symlinks = policy
+}
+
 // io/ioutil methods
 
 func (b *Box) ReadAll(r io.Reader) ([]byte, error) {
@@ -247,40 +493,281 @@ func (b *Box) ReadAll(r io.Reader) ([]byte, error) {
 }
 
 func (b *Box) ReadFile(filename string) ([]byte, error) {
-	if vfsFilename, ok := ConvertVFSPath(filename); ok {
-		return ioutil.ReadFile(b.vfs, vfsFilename)
+	f, err := b.Open(filename)
+	if err != nil {
+		return nil, err
 	}
+	defer f.Close()
 
-	return ioutil.ReadFile(b.osfs, filename)
+	return ioutil.ReadAll(f)
+}
+
+// NewSectionReader opens the file name for reading the n bytes starting at
+// off. Files in a VFS are only decrypted as far as the section needs; see
+// vfs.FileSystem.NewSectionReader.
+func (b *Box) NewSectionReader(name string, off, n int64) (io.ReadSeekCloser, error) {
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if ok {
+		return fs.NewSectionReader(vfsName, off, n)
+	}
+
+	f, err := b.osfs.Open(name)
+	if err != nil {
+		return nil, errno.Map(err)
+	}
+	return &osSectionReader{SectionReader: io.NewSectionReader(f, off, n), f: f}, nil
+}
+
+type osSectionReader struct {
+	*io.SectionReader
+	f absfs.File
+}
+
+func (r *osSectionReader) Close() error {
+	return r.f.Close()
 }
 
 func (b *Box) WriteFile(filename string, data []byte, perm os.FileMode) error {
-	if vfsFilename, ok := ConvertVFSPath(filename); ok {
-		return ioutil.WriteFile(b.vfs, vfsFilename, data, perm)
+	f, err := b.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	n, err := f.Write(data)
+	if err == nil && n < len(data) {
+		err = io.ErrShortWrite
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+
+	return err
+}
+
+// WriteFileAtomic is like WriteFile, but files on the host's filesystem are
+// written with osfs.WriteFileAtomic, so they are never left half written.
+// Files under directories encrypted at rest are written encrypted.
+func (b *Box) WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	_, _, ok, err := b.resolveVFS(filename)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+	if ok {
+		return b.WriteFile(filename, data, perm)
+	}
+
+	return errno.Map(b.osfs.WriteFileAtomic(filename, data, perm))
+}
+
+// SetDurable sets whether changes to directories on the host's filesystem
+// are synced to disk before the calls making them return. See
+// osfs.FileSystem.Durable. It should be called before the Box is used.
+func (b *Box) SetDurable(durable bool) {
+	b.osfs.Durable = durable
+}
+
+// SetAtRestEncryption encrypts the contents of files on the host's
+// filesystem under dirs with per-file keys wrapped under key, the master
+// key. See osfs.FileSystem.SetAtRest.
+func (b *Box) SetAtRestEncryption(key *memguard.Enclave, dirs ...string) error {
+	return b.osfs.SetAtRest(key, dirs...)
+}
+
+// SetMemoryBudget limits how much sealed file data the default VFS keeps in
+// memory, spilling the least recently used files to an encrypted cache in
+// dir when it's exceeded. See vfs.FileSystem.SetMemoryBudget.
+func (b *Box) SetMemoryBudget(budget int64, dir string) error {
+	return b.vfs.SetMemoryBudget(budget, dir)
+}
+
+// SetTiering stores files in the default VFS that are larger than threshold
+// bytes block-encrypted in dir instead of in memory. See
+// vfs.FileSystem.SetTiering.
+func (b *Box) SetTiering(threshold int64, dir string) error {
+	return b.vfs.SetTiering(threshold, dir)
+}
+
+// SetPolicy sets the policy that allows or denies operations on the
+// default VFS performed through views returned by VFSAs. See
+// vfs.FileSystem.SetPolicy.
+func (b *Box) SetPolicy(fn vfs.Policy) {
+	b.vfs.SetPolicy(fn)
+}
+
+// SetMemoryPressure spills cold files of the default VFS to disk when the
+// process uses more memory than p allows. See
+// vfs.FileSystem.SetMemoryPressure.
+func (b *Box) SetMemoryPressure(p *vfs.MemoryPressure) error {
+	return b.vfs.SetMemoryPressure(p)
+}
+
+// SetWriteThrough persists every change to the default VFS, still
+// encrypted, to a directory on the host's filesystem shortly after it's
+// made. See vfs.FileSystem.SetWriteThrough.
+func (b *Box) SetWriteThrough(wt *vfs.WriteThrough) error {
+	return b.vfs.SetWriteThrough(wt)
+}
+
+// SetDedup stores each distinct block of blockSize bytes of tiered files in
+// the default VFS once. See vfs.FileSystem.SetDedup.
+func (b *Box) SetDedup(blockSize int) error {
+	return b.vfs.SetDedup(blockSize)
+}
+
+// Stats returns how the contents of the default VFS are stored, including
+// the space deduplication saved. See vfs.FileSystem.Stats.
+func (b *Box) Stats() vfs.Stats {
+	return b.vfs.Stats()
+}
+
+// Pin keeps the named VFS file in memory, so it's never spilled or tiered.
+func (b *Box) Pin(name string) error {
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return &os.PathError{Op: "pin", Path: name, Err: err}
+	}
+	if ok {
+		return fs.Pin(vfsName)
 	}
 
-	return ioutil.WriteFile(b.osfs, filename, data, perm)
+	return &os.PathError{Op: "pin", Path: name, Err: syscall.ENOTSUP}
+}
+
+// Unpin allows the named VFS file to be spilled or tiered again.
+func (b *Box) Unpin(name string) error {
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return &os.PathError{Op: "unpin", Path: name, Err: err}
+	}
+	if ok {
+		return fs.Unpin(vfsName)
+	}
+
+	return &os.PathError{Op: "unpin", Path: name, Err: syscall.ENOTSUP}
+}
+
+// Clone creates dst as a copy of the VFS file src that shares its contents
+// until either is written. Both must be in the same VFS. See
+// vfs.FileSystem.Clone.
+func (b *Box) Clone(src, dst string) error {
+	srcFS, srcName, srcVFS, err := b.resolveVFS(src)
+	if err != nil {
+		return &os.LinkError{Op: "clone", Old: src, New: dst, Err: err}
+	}
+	dstFS, dstName, dstVFS, err := b.resolveVFS(dst)
+	if err != nil {
+		return &os.LinkError{Op: "clone", Old: src, New: dst, Err: err}
+	}
+	if !srcVFS || !dstVFS {
+		return &os.LinkError{Op: "clone", Old: src, New: dst, Err: syscall.ENOTSUP}
+	}
+	if srcFS != dstFS {
+		return &os.LinkError{Op: "clone", Old: src, New: dst, Err: errCrossBoxOp}
+	}
+
+	return srcFS.Clone(srcName, dstName)
+}
+
+// CopyAll copies the VFS tree rooted at src to dst in the same VFS, cloning
+// the contents of files instead of decrypting them. See
+// vfs.FileSystem.CopyAll.
+func (b *Box) CopyAll(src, dst string) error {
+	srcFS, srcName, srcVFS, err := b.resolveVFS(src)
+	if err != nil {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: err}
+	}
+	dstFS, dstName, dstVFS, err := b.resolveVFS(dst)
+	if err != nil {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: err}
+	}
+	if !srcVFS || !dstVFS {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: syscall.ENOTSUP}
+	}
+	if srcFS != dstFS {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: errCrossBoxOp}
+	}
+
+	return srcFS.CopyAll(srcName, dstName)
 }
 
 func (b *Box) ReadDir(dirname string) ([]os.FileInfo, error) {
-	if vfsDirname, ok := ConvertVFSPath(dirname); ok {
-		return ioutil.ReadDir(b.vfs, vfsDirname)
+	b.reapExpired()
+	fs, vfsDirname, ok, err := b.resolveVFS(dirname)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: dirname, Err: err}
+	}
+	if ok {
+		return ioutil.ReadDir(fs, vfsDirname)
+	}
+
+	return ioutil.ReadDir(b.osfs, dirname)
+}
+
+// ReadDirMatch is like ReadDir, but only returns the entries whose names
+// match pattern. VFS directories are filtered without copying the entries
+// that don't match.
+func (b *Box) ReadDirMatch(dirname, pattern string) ([]os.FileInfo, error) {
+	fs, vfsDirname, ok, err := b.resolveVFS(dirname)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: dirname, Err: err}
+	}
+	if ok {
+		return fs.ReadDirMatch(vfsDirname, pattern)
+	}
+
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: dirname, Err: err}
+	}
+	infos, err := ioutil.ReadDir(b.osfs, dirname)
+	if err != nil {
+		return nil, err
+	}
+	matches := infos[:0]
+	for _, info := range infos {
+		if ok, _ := filepath.Match(pattern, info.Name()); ok {
+			matches = append(matches, info)
+		}
+	}
+
+	return matches, nil
+}
+
+// ReadDirPlus is like ReadDir, but lists VFS directories in one pass while
+// they're locked, describing each entry as Lstat would. See
+// vfs.FileSystem.ReadDirPlus.
+func (b *Box) ReadDirPlus(dirname string) ([]os.FileInfo, error) {
+	fs, vfsDirname, ok, err := b.resolveVFS(dirname)
+	if err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: dirname, Err: err}
+	}
+	if ok {
+		return fs.ReadDirPlus(vfsDirname)
 	}
 
 	return ioutil.ReadDir(b.osfs, dirname)
 }
 
 func (b *Box) TempFile(dir, prefix string) (absfs.File, error) {
-	if vfsDir, ok := ConvertVFSPath(dir); ok {
-		return ioutil.TempFile(b.vfs, vfsDir, prefix)
+	fs, vfsDir, ok, err := b.resolveVFS(dir)
+	if err != nil {
+		return &absfs.InvalidFile{Path: dir}, &os.PathError{Op: "tempfile", Path: dir, Err: err}
+	}
+	if ok {
+		return ioutil.TempFile(fs, vfsDir, prefix)
 	}
 
 	return ioutil.TempFile(b.osfs, dir, prefix)
 }
 
 func (b *Box) TempDir(dir, prefix string) (string, error) {
-	if vfsDir, ok := ConvertVFSPath(dir); ok {
-		return ioutil.TempDir(b.vfs, vfsDir, prefix)
+	fs, vfsDir, ok, err := b.resolveVFS(dir)
+	if err != nil {
+		return "", &os.PathError{Op: "tempdir", Path: dir, Err: err}
+	}
+	if ok {
+		return ioutil.TempDir(fs, vfsDir, prefix)
 	}
 
 	return ioutil.TempDir(b.osfs, dir, prefix)
@@ -0,0 +1,81 @@
+package pandorasbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/osfs"
+)
+
+// ErrCaseConflict is returned when files from a VFS, which tells apart
+// names that only differ in case, would overwrite each other on a host
+// filesystem that doesn't.
+var ErrCaseConflict = errors.New("name only differs in case from another file")
+
+// hostFS returns the host's filesystem for writing files from a VFS into
+// dir. If the filesystem holding dir is case-insensitive, the returned
+// FileSystem fails with ErrCaseConflict instead of letting files whose
+// names only differ in case overwrite each other.
+func (b *Box) hostFS(dir string) absfs.FileSystem {
+	// if dir doesn't exist yet, probe the nearest directory that does
+	probe := dir
+	for {
+		if info, err := b.osfs.Stat(probe); err == nil && info.IsDir() {
+			break
+		}
+		parent := filepath.Dir(probe)
+		if parent == probe {
+			return b.osfs
+		}
+		probe = parent
+	}
+	if sensitive, err := osfs.CaseSensitive(probe); err != nil || sensitive {
+		return b.osfs
+	}
+
+	return &foldFS{FileSystem: b.osfs, names: make(map[string]string)}
+}
+
+// foldFS is a case-insensitive host filesystem that remembers the names
+// created through it, and rejects creating one that only differs in case
+// from an earlier one.
+type foldFS struct {
+	*osfs.FileSystem
+	names map[string]string
+}
+
+func (fs *foldFS) claim(name string) error {
+	folded := strings.ToLower(name)
+	if prev, ok := fs.names[folded]; ok && prev != name {
+		return &os.PathError{Op: "create", Path: name, Err: ErrCaseConflict}
+	}
+	fs.names[folded] = name
+
+	return nil
+}
+
+func (fs *foldFS) MkdirAll(name string, perm os.FileMode) error {
+	if err := fs.claim(name); err != nil {
+		return err
+	}
+	return fs.FileSystem.MkdirAll(name, perm)
+}
+
+func (fs *foldFS) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	if flag&os.O_CREATE != 0 {
+		if err := fs.claim(name); err != nil {
+			return nil, err
+		}
+	}
+	return fs.FileSystem.OpenFile(name, flag, perm)
+}
+
+func (fs *foldFS) Rename(oldpath, newpath string) error {
+	if err := fs.claim(newpath); err != nil {
+		return err
+	}
+	return fs.FileSystem.Rename(oldpath, newpath)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/capnspacehook/pandorasbox"
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/archive"
+	"github.com/capnspacehook/pandorasbox/ioutil"
+)
+
+var errUsage = errors.New("wrong arguments")
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func cmdLs(s *store, args []string) error {
+	if len(args) == 0 {
+		args = []string{pandorasbox.VFSPrefix}
+	}
+
+	for _, name := range args {
+		fs, p := s.resolve(name)
+		info, err := fs.Stat(p)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			printInfo(os.Stdout, info)
+			continue
+		}
+
+		infos, err := ioutil.ReadDir(fs, p)
+		if err != nil {
+			return err
+		}
+		if len(args) > 1 {
+			fmt.Printf("%s:\n", name)
+		}
+		for _, info := range infos {
+			printInfo(os.Stdout, info)
+		}
+	}
+
+	return nil
+}
+
+func printInfo(w io.Writer, info os.FileInfo) {
+	name := info.Name()
+	if info.IsDir() {
+		name += "/"
+	}
+	fmt.Fprintf(w, "%s %10d %s %s\n", info.Mode(), info.Size(), info.ModTime().Format("Jan _2 15:04"), name)
+}
+
+func cmdCat(s *store, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	for _, name := range args {
+		fs, p := s.resolve(name)
+		f, err := fs.Open(p)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(os.Stdout, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func cmdCp(s *store, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+
+	srcFS, src := s.resolve(args[0])
+	dstFS, dst := s.resolve(args[1])
+
+	return copyFile(srcFS, src, dstFS, dst)
+}
+
+// copyFile copies the file src on srcFS to dst on dstFS, or into dst if
+// it's a directory.
+func copyFile(srcFS absfs.FileSystem, src string, dstFS absfs.FileSystem, dst string) error {
+	info, err := srcFS.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", src)
+	}
+	if dstInfo, err := dstFS.Stat(dst); err == nil && dstInfo.IsDir() {
+		dst = filepath.Join(dst, info.Name())
+	}
+
+	in, err := srcFS.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := dstFS.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err1 := out.Close(); err == nil {
+		err = err1
+	}
+
+	return err
+}
+
+func cmdMkdir(s *store, args []string) error {
+	flags := flag.NewFlagSet("mkdir", flag.ContinueOnError)
+	parents := flags.Bool("p", false, "create parent directories as needed")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errUsage
+	}
+
+	for _, name := range flags.Args() {
+		fs, p := s.resolve(name)
+		var err error
+		if *parents {
+			err = fs.MkdirAll(p, 0700)
+		} else {
+			err = fs.Mkdir(p, 0700)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func cmdRm(s *store, args []string) error {
+	flags := flag.NewFlagSet("rm", flag.ContinueOnError)
+	recursive := flags.Bool("r", false, "remove directories and their contents")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errUsage
+	}
+
+	for _, name := range flags.Args() {
+		fs, p := s.resolve(name)
+		if p == s.dataDir() {
+			return errors.New("refusing to remove the root of the box")
+		}
+
+		var err error
+		if *recursive {
+			err = fs.RemoveAll(p)
+		} else {
+			err = fs.Remove(p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func cmdImport(s *store, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+
+	r := io.Reader(os.Stdin)
+	if args[0] != "-" {
+		f, err := openHost(s, args[0], os.O_RDONLY, 0)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	dir := pandorasbox.VFSPrefix
+	if len(args) == 2 {
+		dir = args[1]
+	}
+	fs, p := s.resolve(dir)
+
+	return archive.Extract(r, fs, p)
+}
+
+func cmdExport(s *store, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+
+	var paths []string
+	for _, name := range args[1:] {
+		_, p := s.resolve(name)
+		if !pandorasbox.IsVFSPath(name) {
+			return fmt.Errorf("%s is not a box path", name)
+		}
+		paths = append(paths, p)
+	}
+	if len(paths) == 0 {
+		paths = []string{s.dataDir()}
+	}
+	// the root of the box is exported as its contents, not as a directory
+	// named after the data directory
+	for i := 0; i < len(paths); i++ {
+		if paths[i] != s.dataDir() {
+			continue
+		}
+		names, err := ioutil.ReadDir(s.box, s.dataDir())
+		if err != nil {
+			return err
+		}
+		paths = append(paths[:i], paths[i+1:]...)
+		for _, info := range names {
+			paths = append(paths, filepath.Join(s.dataDir(), info.Name()))
+		}
+		i--
+	}
+
+	if args[0] == "-" {
+		return archive.Write(os.Stdout, s.box, paths...)
+	}
+
+	f, err := openHost(s, args[0], os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	err = archive.Write(f, s.box, paths...)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+
+	return err
+}
+
+// openHost opens an archive on the host's filesystem with direct I/O where
+// it is supported, as archives hold the box's files in plaintext and
+// shouldn't linger in the page cache.
+func openHost(s *store, name string, flag int, perm os.FileMode) (absfs.File, error) {
+	if f, err := s.os.OpenDirect(name, flag, perm); err == nil {
+		return f, nil
+	}
+
+	return s.os.OpenFile(name, flag, perm)
+}
+
+func cmdRekey(s *store, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+
+	return s.rekey()
+}
@@ -0,0 +1,108 @@
+// Command pandorasbox manages persisted boxes: directories of files that
+// are encrypted at rest, unlocked with a passphrase.
+//
+// Paths starting with vfs:// name files in the box, any other paths name
+// files on the OS, so files can be copied in and out of a box with cp.
+// The passphrase is read from the PANDORASBOX_PASSPHRASE environment
+// variable, or prompted for on the terminal.
+//
+// Usage:
+//
+//	pandorasbox [-box dir] command [arguments]
+//
+// The commands are:
+//
+//	init                      create a new box
+//	ls [path...]              list directories
+//	cat path...               print files
+//	cp src dst                copy a file
+//	mkdir [-p] path...        create directories
+//	rm [-r] path...           remove files or directories
+//	import archive [dir]      extract a tar archive into the box
+//	export archive [path...]  write box paths to a tar archive
+//	rekey                     rotate the box's master key
+//	shell [-remote addr]      start an interactive shell on the box, or on
+//	                          the box served by a running program
+//
+// An archive named - is read from stdin or written to stdout. A shell
+// attached with -remote sends the session token in PANDORASBOX_TOKEN, if
+// set, with every call.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+type command struct {
+	run   func(s *store, args []string) error
+	usage string
+}
+
+var commands = map[string]command{
+	"ls":     {cmdLs, "ls [path...]"},
+	"cat":    {cmdCat, "cat path..."},
+	"cp":     {cmdCp, "cp src dst"},
+	"mkdir":  {cmdMkdir, "mkdir [-p] path..."},
+	"rm":     {cmdRm, "rm [-r] path..."},
+	"import": {cmdImport, "import archive [dir]"},
+	"export": {cmdExport, "export archive [path...]"},
+	"rekey":  {cmdRekey, "rekey"},
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: pandorasbox [-box dir] command [arguments]")
+	fmt.Fprintln(os.Stderr, "\ncommands:\n  init\n  shell [-remote addr]")
+	for _, name := range commandNames() {
+		fmt.Fprintln(os.Stderr, " ", commands[name].usage)
+	}
+	fmt.Fprintln(os.Stderr, "\nflags:")
+	flag.PrintDefaults()
+}
+
+func main() {
+	dir := flag.String("box", defaultBoxDir(), "directory of the box")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	if err := run(*dir, flag.Arg(0), flag.Args()[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "pandorasbox:", err)
+		os.Exit(1)
+	}
+}
+
+func defaultBoxDir() string {
+	if dir := os.Getenv("PANDORASBOX_DIR"); dir != "" {
+		return dir
+	}
+
+	return ".pandorasbox"
+}
+
+func run(dir, name string, args []string) error {
+	switch name {
+	case "init":
+		_, err := initStore(dir)
+		return err
+	case "shell":
+		// a remote shell doesn't need the box on disk
+		return cmdShell(dir, args)
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		usage()
+		return fmt.Errorf("unknown command %q", name)
+	}
+	s, err := openStore(dir)
+	if err != nil {
+		return err
+	}
+
+	return cmd.run(s, args)
+}
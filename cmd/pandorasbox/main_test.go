@@ -0,0 +1,68 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/capnspacehook/pandorasbox/ioutil"
+)
+
+func TestStore(t *testing.T) {
+	t.Setenv("PANDORASBOX_PASSPHRASE", "correct horse battery staple")
+	dir := t.TempDir()
+	box := filepath.Join(dir, "box")
+	src := filepath.Join(dir, "secret.txt")
+	if err := os.WriteFile(src, []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := run(box, "init", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(box, "mkdir", []string{"-p", "vfs://db/prod"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(box, "cp", []string{src, "vfs://db/prod"}); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "box.tar")
+	if err := run(box, "export", []string{archive}); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(box, "rekey", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := run(box, "import", []string{archive, "vfs://restored"}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := openStore(box)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"vfs://db/prod/secret.txt", "vfs://restored/db/prod/secret.txt"} {
+		fs, p := s.resolve(name)
+		data, err := ioutil.ReadFile(fs, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "hunter2" {
+			t.Errorf("wrong contents of %s: %q", name, data)
+		}
+	}
+
+	// contents are encrypted at rest
+	raw, err := os.ReadFile(filepath.Join(box, dataDirName, "db", "prod", "secret.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(raw) == "hunter2" {
+		t.Error("file stored in plaintext")
+	}
+
+	t.Setenv("PANDORASBOX_PASSPHRASE", "wrong")
+	if _, err := openStore(box); err == nil {
+		t.Error("opened box with wrong passphrase")
+	}
+}
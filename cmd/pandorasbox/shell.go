@@ -0,0 +1,436 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/term"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/osfs"
+	"github.com/capnspacehook/pandorasbox/remote"
+	"github.com/capnspacehook/pandorasbox/session"
+)
+
+const shellHelp = `commands:
+  cd [dir]               change the current box directory
+  pwd                    print the current box directory
+  ls [path...]           list box directories
+  cat path...            print box files
+  put local [path]       copy a local file into the box
+  get path [local]       copy a box file to the local directory
+  mkdir [-p] path...     create box directories
+  rm [-r] path...        remove box files or directories
+  help                   print this help
+  exit                   leave the shell
+
+Arguments containing spaces can be quoted with '' or "", or the spaces
+escaped with \.
+`
+
+// lineReader reads the lines a shell runs.
+type lineReader interface {
+	readLine(prompt string) (string, error)
+}
+
+// terminalReader reads lines from a terminal with line editing and
+// history.
+type terminalReader struct {
+	t *term.Terminal
+}
+
+func (r terminalReader) readLine(prompt string) (string, error) {
+	r.t.SetPrompt(prompt)
+	return r.t.ReadLine()
+}
+
+// scanReader reads lines from anything else, like a pipe.
+type scanReader struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+func (r scanReader) readLine(prompt string) (string, error) {
+	fmt.Fprint(r.out, prompt)
+	if !r.in.Scan() {
+		fmt.Fprintln(r.out)
+		if err := r.in.Err(); err != nil {
+			return "", err
+		}
+		return "", io.EOF
+	}
+
+	return r.in.Text(), nil
+}
+
+// shell runs an interactive session on a box. Paths given to commands are
+// box paths relative to the current box directory, except for the local
+// paths of put and get.
+type shell struct {
+	// box is the FileSystem the box is on, and root the directory the
+	// box is stored in on it, or "" if box is the box itself
+	box  absfs.FileSystem
+	root string
+	host absfs.FileSystem
+	cwd  string
+
+	in  lineReader
+	out io.Writer
+}
+
+func cmdShell(dir string, args []string) error {
+	flags := flag.NewFlagSet("shell", flag.ContinueOnError)
+	addr := flags.String("remote", "", "gRPC `address` of a running box to attach to, like unix:///run/app.sock")
+	insecureTransport := flags.Bool("insecure", false, "connect to -remote without TLS, which unix sockets never use")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return errUsage
+	}
+
+	sh := &shell{cwd: "/", host: osfs.NewFS()}
+	if *addr != "" {
+		c, err := dialRemote(*addr, *insecureTransport || strings.HasPrefix(*addr, "unix:"))
+		if err != nil {
+			return err
+		}
+		sh.box = c
+	} else {
+		s, err := openStore(dir)
+		if err != nil {
+			return err
+		}
+		sh.box, sh.root, sh.host = s.box, s.dataDir(), s.os
+	}
+
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return err
+		}
+		defer term.Restore(fd, state)
+
+		t := term.NewTerminal(struct {
+			io.Reader
+			io.Writer
+		}{os.Stdin, os.Stdout}, "")
+		sh.in, sh.out = terminalReader{t}, t
+	} else {
+		sh.in, sh.out = scanReader{bufio.NewScanner(os.Stdin), os.Stdout}, os.Stdout
+	}
+
+	return sh.run()
+}
+
+// dialRemote connects to the remote.Server at addr. The session token in
+// PANDORASBOX_TOKEN, if any, is sent with every call.
+func dialRemote(addr string, insecureTransport bool) (*remote.Client, error) {
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(credentials.NewTLS(nil))}
+	if insecureTransport {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	if token := os.Getenv("PANDORASBOX_TOKEN"); token != "" {
+		creds := session.Credentials(token)
+		if insecureTransport {
+			creds = session.InsecureCredentials(token)
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(creds))
+	}
+
+	conn, err := grpc.Dial(addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	c, err := remote.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (sh *shell) run() error {
+	for {
+		line, err := sh.in.readLine(sh.cwd + "> ")
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		args, err := splitArgs(line)
+		if err != nil {
+			fmt.Fprintln(sh.out, "error:", err)
+			continue
+		}
+		if len(args) == 0 {
+			continue
+		}
+		if args[0] == "exit" || args[0] == "quit" {
+			return nil
+		}
+		if err := sh.exec(args[0], args[1:]); err != nil {
+			fmt.Fprintln(sh.out, "error:", err)
+		}
+	}
+}
+
+// splitArgs splits line into arguments separated by spaces, like a shell
+// does. Single quotes keep everything up to the next one as is, and double
+// quotes everything but backslash escapes.
+func splitArgs(line string) ([]string, error) {
+	var (
+		args  []string
+		arg   strings.Builder
+		inArg bool
+		quote rune
+	)
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case r == '\\' && quote != '\'':
+			if i++; i == len(runes) {
+				return nil, errors.New("trailing backslash")
+			}
+			arg.WriteRune(runes[i])
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote", quote)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
+
+// abs returns name as an absolute box path, relative to the current
+// directory.
+func (sh *shell) abs(name string) string {
+	if !path.IsAbs(name) {
+		name = path.Join(sh.cwd, name)
+	}
+
+	return path.Clean(name)
+}
+
+// boxPath returns the path on sh.box of the box path name.
+func (sh *shell) boxPath(name string) string {
+	name = sh.abs(name)
+	if sh.root == "" {
+		return name
+	}
+
+	return filepath.Join(sh.root, filepath.FromSlash(name))
+}
+
+// parse parses the flags of a command, and returns its other arguments.
+func parse(flags *flag.FlagSet, args []string) ([]string, error) {
+	flags.SetOutput(io.Discard)
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	return flags.Args(), nil
+}
+
+func (sh *shell) exec(name string, args []string) error {
+	switch name {
+	case "help":
+		fmt.Fprint(sh.out, shellHelp)
+	case "pwd":
+		fmt.Fprintln(sh.out, sh.cwd)
+	case "cd":
+		return sh.cd(args)
+	case "ls":
+		return sh.ls(args)
+	case "cat":
+		return sh.cat(args)
+	case "mkdir":
+		return sh.mkdir(args)
+	case "rm":
+		return sh.rm(args)
+	case "put":
+		if len(args) < 1 || len(args) > 2 {
+			return errUsage
+		}
+		dst := "."
+		if len(args) == 2 {
+			dst = args[1]
+		}
+		return copyFile(sh.host, args[0], sh.box, sh.boxPath(dst))
+	case "get":
+		if len(args) < 1 || len(args) > 2 {
+			return errUsage
+		}
+		dst := "."
+		if len(args) == 2 {
+			dst = args[1]
+		}
+		return copyFile(sh.box, sh.boxPath(args[0]), sh.host, dst)
+	default:
+		return fmt.Errorf("unknown command %q, try help", name)
+	}
+
+	return nil
+}
+
+func (sh *shell) cd(args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	dir := "/"
+	if len(args) == 1 {
+		dir = args[0]
+	}
+
+	info, err := sh.box.Stat(sh.boxPath(dir))
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	sh.cwd = sh.abs(dir)
+
+	return nil
+}
+
+func (sh *shell) ls(args []string) error {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+
+	for _, name := range args {
+		p := sh.boxPath(name)
+		info, err := sh.box.Stat(p)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			printInfo(sh.out, info)
+			continue
+		}
+
+		infos, err := ioutil.ReadDir(sh.box, p)
+		if err != nil {
+			return err
+		}
+		if len(args) > 1 {
+			fmt.Fprintf(sh.out, "%s:\n", name)
+		}
+		for _, info := range infos {
+			printInfo(sh.out, info)
+		}
+	}
+
+	return nil
+}
+
+func (sh *shell) cat(args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+
+	for _, name := range args {
+		f, err := sh.box.Open(sh.boxPath(name))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(sh.out, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (sh *shell) mkdir(args []string) error {
+	flags := flag.NewFlagSet("mkdir", flag.ContinueOnError)
+	parents := flags.Bool("p", false, "create parent directories as needed")
+	names, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return errUsage
+	}
+
+	for _, name := range names {
+		if *parents {
+			err = sh.box.MkdirAll(sh.boxPath(name), 0700)
+		} else {
+			err = sh.box.Mkdir(sh.boxPath(name), 0700)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (sh *shell) rm(args []string) error {
+	flags := flag.NewFlagSet("rm", flag.ContinueOnError)
+	recursive := flags.Bool("r", false, "remove directories and their contents")
+	names, err := parse(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return errUsage
+	}
+
+	for _, name := range names {
+		if sh.abs(name) == "/" {
+			return errors.New("refusing to remove the root of the box")
+		}
+		if *recursive {
+			err = sh.box.RemoveAll(sh.boxPath(name))
+		} else {
+			err = sh.box.Remove(sh.boxPath(name))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/osfs"
+	"github.com/capnspacehook/pandorasbox/remote"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		args []string
+	}{
+		{"", nil},
+		{"  ls  -l\t/a ", []string{"ls", "-l", "/a"}},
+		{`cat "my file"`, []string{"cat", "my file"}},
+		{`cat 'my "file"'`, []string{"cat", `my "file"`}},
+		{`cat my\ file`, []string{"cat", "my file"}},
+		{`cat "a \"b\""`, []string{"cat", `a "b"`}},
+		{`cat 'a\b'`, []string{"cat", `a\b`}},
+		{`cat ""`, []string{"cat", ""}},
+		{`cat a"b c"d`, []string{"cat", "ab cd"}},
+	}
+	for _, tt := range tests {
+		args, err := splitArgs(tt.line)
+		if err != nil {
+			t.Errorf("splitArgs(%q): %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("splitArgs(%q) = %q, want %q", tt.line, args, tt.args)
+		}
+	}
+
+	for _, line := range []string{`cat "a`, `cat 'a`, `cat a\`} {
+		if _, err := splitArgs(line); err == nil {
+			t.Errorf("splitArgs(%q): expected error", line)
+		}
+	}
+}
+
+// newTestShell returns a shell on a new persisted box, reading the lines
+// of input.
+func newTestShell(t *testing.T, input string) (*shell, *strings.Builder) {
+	t.Helper()
+	t.Setenv("PANDORASBOX_PASSPHRASE", "correct horse battery staple")
+	dir := filepath.Join(t.TempDir(), "box")
+	s, err := initStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := new(strings.Builder)
+	sh := &shell{
+		box:  s.box,
+		root: s.dataDir(),
+		host: s.os,
+		cwd:  "/",
+		in:   scanReader{bufio.NewScanner(strings.NewReader(input)), out},
+		out:  out,
+	}
+
+	return sh, out
+}
+
+func TestShellExec(t *testing.T) {
+	sh, out := newTestShell(t, "")
+	local := filepath.Join(t.TempDir(), "my secret.txt")
+	if err := os.WriteFile(local, []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		name string
+		args []string
+	}{
+		{"mkdir", []string{"-p", "db/prod"}},
+		{"cd", []string{"db"}},
+		{"put", []string{local, "prod"}},
+		{"cd", []string{"/db/prod"}},
+	}
+	for _, step := range steps {
+		if err := sh.exec(step.name, step.args); err != nil {
+			t.Fatalf("%s %q: %v", step.name, step.args, err)
+		}
+	}
+
+	out.Reset()
+	if err := sh.exec("pwd", nil); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "/db/prod\n" {
+		t.Errorf("wrong pwd output: %q", out)
+	}
+
+	out.Reset()
+	if err := sh.exec("cat", []string{"my secret.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "hunter2" {
+		t.Errorf("wrong cat output: %q", out)
+	}
+
+	out.Reset()
+	if err := sh.exec("ls", nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(out.String(), " my secret.txt\n") {
+		t.Errorf("wrong ls output: %q", out)
+	}
+
+	got := filepath.Join(t.TempDir(), "got.txt")
+	if err := sh.exec("get", []string{"my secret.txt", got}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hunter2" {
+		t.Errorf("wrong contents of %s: %q", got, data)
+	}
+
+	if err := sh.exec("cd", []string{"my secret.txt"}); err == nil {
+		t.Error("cd to a file: expected error")
+	}
+	if err := sh.exec("rm", []string{"/"}); err == nil {
+		t.Error("rm /: expected error")
+	}
+	if err := sh.exec("rm", []string{"-r", "/db"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(sh.root, "db")); !os.IsNotExist(err) {
+		t.Errorf("expected /db to be removed, got %v", err)
+	}
+	if err := sh.exec("frobnicate", nil); err == nil {
+		t.Error("unknown command: expected error")
+	}
+}
+
+func TestShellRun(t *testing.T) {
+	input := `mkdir "a dir"
+cd 'a dir'
+cat missing
+cat "unterminated
+pwd
+exit
+pwd
+`
+	sh, out := newTestShell(t, input)
+	if err := sh.run(); err != nil {
+		t.Fatal(err)
+	}
+
+	// errors are printed and the shell carries on, but nothing runs after
+	// exit
+	got := out.String()
+	for _, want := range []string{
+		"/> /> /a dir> error: ",
+		"missing",
+		"/a dir> error: unterminated \" quote\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("output doesn't contain %q:\n%s", want, got)
+		}
+	}
+	if want := "/a dir> /a dir\n/a dir> "; !strings.HasSuffix(got, want) {
+		t.Errorf("output doesn't end with %q:\n%s", want, got)
+	}
+
+	// the input ending ends the shell too
+	sh, _ = newTestShell(t, "pwd\n")
+	if err := sh.run(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShellRemote(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "box.sock")
+	lis, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := vfs.NewFS()
+	gs := grpc.NewServer()
+	srv := remote.NewServer(fs)
+	srv.Register(gs)
+	go gs.Serve(lis)
+	t.Cleanup(func() {
+		gs.Stop()
+		srv.Close()
+	})
+
+	c, err := dialRemote("unix://"+sock, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(strings.Builder)
+	sh := &shell{box: c, host: osfs.NewFS(), cwd: "/", out: out}
+
+	local := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(local, []byte("s3cr3t"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := sh.exec("mkdir", []string{"/run"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sh.exec("put", []string{local, "/run"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the shell works on the served box itself
+	data, err := ioutil.ReadFile(fs, "/run/token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "s3cr3t" {
+		t.Errorf("wrong contents of /run/token: %q", data)
+	}
+	if err := sh.exec("cat", []string{"/run/token"}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "s3cr3t" {
+		t.Errorf("wrong cat output: %q", out)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/awnumar/fastrand"
+	"github.com/awnumar/memguard"
+	"golang.org/x/term"
+
+	"github.com/capnspacehook/pandorasbox"
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/encfs"
+	"github.com/capnspacehook/pandorasbox/osfs"
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+const (
+	keyFileName = ".pandorasbox"
+	dataDirName = "data"
+
+	keyFileVersion = 1
+)
+
+var errNoBox = errors.New("not a box, run init first")
+
+// keyFile holds the master key of a persisted box, sealed under a key
+// derived from the box's passphrase.
+type keyFile struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	N       int    `json:"n"`
+	R       int    `json:"r"`
+	P       int    `json:"p"`
+	Key     []byte `json:"key"`
+
+	// Next is the new master key while keys are being rotated.
+	Next []byte `json:"next,omitempty"`
+}
+
+// store is a persisted box: a directory of files encrypted with encfs,
+// next to the key file holding their master key.
+type store struct {
+	dir string
+	kf  *keyFile
+	kek *memguard.Enclave
+	key *memguard.Enclave
+
+	os  *osfs.FileSystem
+	box *encfs.FileSystem
+}
+
+func passphrase(prompt string) ([]byte, error) {
+	if p := os.Getenv("PANDORASBOX_PASSPHRASE"); p != "" {
+		return []byte(p), nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, errors.New("no passphrase: set PANDORASBOX_PASSPHRASE or run from a terminal")
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	p, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+
+	return p, err
+}
+
+func (kf *keyFile) deriveKey(pass []byte) (*memguard.Enclave, error) {
+	pk := &pandorasbox.PassphraseKey{
+		Passphrase: func() ([]byte, error) { return pass, nil },
+		Salt:       kf.Salt,
+		N:          kf.N,
+		R:          kf.R,
+		P:          kf.P,
+	}
+
+	return pk.Key(context.Background())
+}
+
+func (kf *keyFile) unwrap(wrapped []byte, kek *memguard.Enclave) (*memguard.Enclave, error) {
+	key, err := pandorasbox.KEKUnwrapper(pandorasbox.StaticKey(kek)).Unwrap(context.Background(), wrapped)
+	if err == pandorasbox.ErrWrongKey {
+		return nil, errors.New("wrong passphrase")
+	}
+
+	return key, err
+}
+
+func wrap(key, kek *memguard.Enclave) ([]byte, error) {
+	return pandorasbox.WrapKey(key, kek)
+}
+
+func (s *store) saveKeyFile() error {
+	data, err := json.MarshalIndent(s.kf, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	// the key file must never be left half written
+	return osfs.WriteFileAtomic(filepath.Join(s.dir, keyFileName), data, 0600)
+}
+
+// initStore creates a new persisted box in dir.
+func initStore(dir string) (*store, error) {
+	if _, err := os.Stat(filepath.Join(dir, keyFileName)); err == nil {
+		return nil, fmt.Errorf("%s is already a box", dir)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, dataDirName), 0700); err != nil {
+		return nil, err
+	}
+
+	pass, err := passphrase("New passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	kf := &keyFile{
+		Version: keyFileVersion,
+		Salt:    fastrand.Bytes(16),
+		N:       1 << 15,
+		R:       8,
+		P:       1,
+	}
+	kek, err := kf.deriveKey(pass)
+	if err != nil {
+		return nil, err
+	}
+	key := seal.NewKey()
+	if kf.Key, err = wrap(key, kek); err != nil {
+		return nil, err
+	}
+
+	s := newStore(dir, kf, kek, key)
+	return s, s.saveKeyFile()
+}
+
+// openStore opens the persisted box in dir.
+func openStore(dir string) (*store, error) {
+	data, err := os.ReadFile(filepath.Join(dir, keyFileName))
+	if os.IsNotExist(err) {
+		return nil, errNoBox
+	}
+	if err != nil {
+		return nil, err
+	}
+	kf := new(keyFile)
+	if err := json.Unmarshal(data, kf); err != nil {
+		return nil, fmt.Errorf("reading key file: %v", err)
+	}
+	if kf.Version != keyFileVersion {
+		return nil, fmt.Errorf("unsupported key file version %d", kf.Version)
+	}
+
+	pass, err := passphrase("Passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	kek, err := kf.deriveKey(pass)
+	if err != nil {
+		return nil, err
+	}
+	key, err := kf.unwrap(kf.Key, kek)
+	if err != nil {
+		return nil, err
+	}
+	if kf.Next != nil {
+		fmt.Fprintln(os.Stderr, "warning: key rotation was interrupted, run rekey to finish it")
+	}
+
+	return newStore(dir, kf, kek, key), nil
+}
+
+func newStore(dir string, kf *keyFile, kek, key *memguard.Enclave) *store {
+	s := &store{dir: dir, kf: kf, kek: kek, key: key, os: osfs.NewFS()}
+	s.box = encfs.Wrap(s.os, key)
+
+	return s
+}
+
+func (s *store) dataDir() string {
+	return filepath.Join(s.dir, dataDirName)
+}
+
+// resolve returns the FileSystem name is stored on and its path there.
+// vfs:// paths name files in the box, all others files on the OS.
+func (s *store) resolve(name string) (absfs.FileSystem, string) {
+	if boxName, ok := pandorasbox.ConvertVFSPath(name); ok {
+		return s.box, filepath.Join(s.dataDir(), filepath.FromSlash(path.Clean("/"+boxName)))
+	}
+
+	return s.os, name
+}
+
+// rekey rotates the master key of the box, re-wrapping the key of every
+// file under the new one.
+func (s *store) rekey() error {
+	var (
+		next *memguard.Enclave
+		err  error
+	)
+	if s.kf.Next != nil {
+		next, err = s.kf.unwrap(s.kf.Next, s.kek)
+	} else {
+		next = seal.NewKey()
+		s.kf.Next, err = wrap(next, s.kek)
+		if err == nil {
+			err = s.saveKeyFile()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	err = s.os.Walk(s.dataDir(), func(p string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		return s.box.Rekey(p, next)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.kf.Key, s.kf.Next = s.kf.Next, nil
+	s.key = next
+	s.box = encfs.Wrap(s.os, next)
+
+	return s.saveKeyFile()
+}
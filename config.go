@@ -0,0 +1,419 @@
+package pandorasbox
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/awnumar/memguard"
+	"github.com/capnspacehook/pandorasbox/seal"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigFormat is the format of a config file read by Config.Load.
+type ConfigFormat int
+
+const (
+	// ConfigAuto detects the format from the extension of the file, or
+	// from its contents.
+	ConfigAuto ConfigFormat = iota
+	ConfigDotenv
+	ConfigYAML
+	ConfigJSON
+)
+
+var (
+	// ErrBadConfig is returned for config files that can't be parsed.
+	ErrBadConfig = errors.New("invalid config file")
+
+	// ErrConfigCycle is returned for config values that reference
+	// themselves, directly or not.
+	ErrConfigCycle = errors.New("config value references itself")
+)
+
+// Config is a set of config values, each kept as a sealed file named after
+// its key in a directory of a Box, so values never need to be held in
+// plaintext environment variables. Keys of nested YAML and JSON values are
+// the keys leading to them joined by dots, like db.password, with array
+// elements keyed by their index.
+type Config struct {
+	b   *Box
+	dir string
+}
+
+// Config returns the config values kept in the directory dir, which is
+// usually a VFS path.
+func (b *Box) Config(dir string) *Config {
+	return &Config{b: b, dir: dir}
+}
+
+// LoadFile loads the config file name, which may be a VFS or a host path,
+// like Load. ConfigAuto detects the format from the extension of name.
+func (c *Config) LoadFile(name string, format ConfigFormat) ([]string, error) {
+	if format == ConfigAuto {
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".env":
+			format = ConfigDotenv
+		case ".yaml", ".yml":
+			format = ConfigYAML
+		case ".json":
+			format = ConfigJSON
+		}
+	}
+	if format == ConfigAuto && strings.HasPrefix(filepath.Base(name), ".env") {
+		format = ConfigDotenv
+	}
+
+	data, err := c.b.ReadFile(name)
+	defer seal.Wipe(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.load(data, format)
+}
+
+// Load parses the config file read from r in format, and stores each value
+// in it as a file named after its key, replacing the value already stored
+// under the same key. It returns the keys stored, sorted. ConfigAuto
+// detects JSON objects and dotenv files, and parses anything else as YAML.
+// The plaintext read is wiped once it's stored.
+func (c *Config) Load(r io.Reader, format ConfigFormat) ([]string, error) {
+	data, err := io.ReadAll(r)
+	defer seal.Wipe(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.load(data, format)
+}
+
+func (c *Config) load(data []byte, format ConfigFormat) ([]string, error) {
+	if format == ConfigAuto {
+		format = detectConfig(data)
+	}
+
+	values := make(map[string][]byte)
+	defer func() {
+		for _, v := range values {
+			seal.Wipe(v)
+		}
+	}()
+	var err error
+	switch format {
+	case ConfigDotenv:
+		err = parseDotenv(data, values)
+	case ConfigJSON:
+		var v interface{}
+		d := json.NewDecoder(bytes.NewReader(data))
+		d.UseNumber()
+		if err = d.Decode(&v); err == nil {
+			err = flattenConfig("", v, values)
+		}
+	case ConfigYAML:
+		var v interface{}
+		if err = yaml.Unmarshal(data, &v); err == nil {
+			err = flattenConfig("", v, values)
+		}
+	default:
+		err = errors.New("unknown format")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadConfig, err)
+	}
+
+	if err := c.b.MkdirAll(c.dir, 0700); err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(values))
+	for key, value := range values {
+		if err := c.b.WriteFile(c.path(key), value, 0600); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// detectConfig guesses the format of data.
+func detectConfig(data []byte) ConfigFormat {
+	trimmed := bytes.TrimSpace(data)
+	if bytes.HasPrefix(trimmed, []byte("{")) {
+		return ConfigJSON
+	}
+	s := bufio.NewScanner(bytes.NewReader(trimmed))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		// only the first line can tell, as quoted values may span lines
+		key, _, ok := strings.Cut(strings.TrimPrefix(line, "export "), "=")
+		if ok && validConfigKey(strings.TrimSpace(key)) {
+			return ConfigDotenv
+		}
+		break
+	}
+
+	return ConfigYAML
+}
+
+// validConfigKey reports whether key can name a config value.
+func validConfigKey(key string) bool {
+	return key != "" && key != "." && key != ".." && !strings.ContainsAny(key, "/\\?{}$='\" \t")
+}
+
+// parseDotenv parses the KEY=value lines of a dotenv file into values.
+// Lines may start with export, and # starts comments. Values in single
+// quotes are taken literally, and values in double quotes may span lines
+// and hold the escapes \n, \t, \" and \\.
+func parseDotenv(data []byte, values map[string][]byte) error {
+	for line := 1; len(data) != 0; line++ {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			end = len(data)
+		}
+		l := bytes.TrimSpace(data[:end])
+		if len(l) == 0 || l[0] == '#' {
+			data = data[min(end+1, len(data)):]
+			continue
+		}
+		l = bytes.TrimPrefix(l, []byte("export "))
+		eq := bytes.IndexByte(l, '=')
+		if eq < 0 {
+			return fmt.Errorf("line %d: missing =", line)
+		}
+		key := string(bytes.TrimSpace(l[:eq]))
+		if !validConfigKey(key) {
+			return fmt.Errorf("line %d: invalid key %q", line, key)
+		}
+		raw := bytes.TrimLeft(l[eq+1:], " \t")
+
+		var value []byte
+		switch {
+		case len(raw) != 0 && raw[0] == '\'':
+			quote := bytes.IndexByte(raw[1:], '\'')
+			if quote < 0 {
+				return fmt.Errorf("line %d: unterminated quote", line)
+			}
+			// escaped so Get takes them literally too
+			value = bytes.ReplaceAll(raw[1:quote+1], []byte("$"), []byte("$$"))
+		case len(raw) != 0 && raw[0] == '"':
+			// keys can't hold quotes, so this is the opening one, and the
+			// value may continue on the following lines
+			q := bytes.IndexByte(data, '"') + 1
+			var n int
+			var err error
+			value, n, err = unquoteDotenv(data[q:])
+			if err != nil {
+				return fmt.Errorf("line %d: %v", line, err)
+			}
+			line += bytes.Count(data[q:q+n], []byte("\n"))
+			if end = bytes.IndexByte(data[q+n:], '\n'); end < 0 {
+				end = len(data)
+			} else {
+				end += q + n
+			}
+		default:
+			if i := bytes.Index(raw, []byte(" #")); i >= 0 {
+				raw = raw[:i]
+			}
+			value = append([]byte(nil), bytes.TrimSpace(raw)...)
+		}
+		if old, ok := values[key]; ok {
+			seal.Wipe(old)
+		}
+		values[key] = value
+		data = data[min(end+1, len(data)):]
+	}
+
+	return nil
+}
+
+// unquoteDotenv returns the value of the double quoted string s starts
+// with, after its opening quote, and how many bytes of s it took up,
+// including the closing quote.
+func unquoteDotenv(s []byte) ([]byte, int, error) {
+	var value []byte
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return value, i + 1, nil
+		case '\\':
+			if i+1 == len(s) {
+				break
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				value = append(value, '\n')
+			case 't':
+				value = append(value, '\t')
+			default:
+				value = append(value, s[i])
+			}
+		default:
+			value = append(value, s[i])
+		}
+	}
+	seal.Wipe(value)
+
+	return nil, 0, errors.New("unterminated quote")
+}
+
+// flattenConfig adds the scalar values in v to values, keyed by the keys
+// leading to them from key.
+func flattenConfig(key string, v interface{}, values map[string][]byte) error {
+	join := func(k string) string {
+		if key == "" {
+			return k
+		}
+		return key + "." + k
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			if err := flattenConfig(join(k), child, values); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[interface{}]interface{}:
+		// YAML maps with keys that aren't all strings
+		for k, child := range v {
+			if err := flattenConfig(join(fmt.Sprint(k)), child, values); err != nil {
+				return err
+			}
+		}
+		return nil
+	case []interface{}:
+		for i, child := range v {
+			if err := flattenConfig(join(strconv.Itoa(i)), child, values); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if !validConfigKey(key) {
+		return fmt.Errorf("invalid key %q", key)
+	}
+	switch v := v.(type) {
+	case nil:
+		values[key] = nil
+	case string:
+		values[key] = []byte(v)
+	default:
+		values[key] = []byte(fmt.Sprint(v))
+	}
+
+	return nil
+}
+
+// path returns the path of the file the value of key is kept in.
+func (c *Config) path(key string) string {
+	return strings.TrimSuffix(c.dir, "/") + "/" + key
+}
+
+// Keys returns the keys of the values stored, sorted.
+func (c *Config) Keys() ([]string, error) {
+	infos, err := c.b.ReadDir(c.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			keys = append(keys, info.Name())
+		}
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
+
+// Get returns the value of key, or nil if it's empty, with every ${KEY}
+// reference in it replaced by the value of KEY, resolved the same way. $$
+// stands for a single $. It fails with an error wrapping os.ErrNotExist if
+// key or a key it references doesn't exist, and with ErrConfigCycle if a
+// value references itself.
+func (c *Config) Get(key string) (*memguard.Enclave, error) {
+	value, err := c.resolve(key, make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+	if len(value) == 0 {
+		return nil, nil
+	}
+
+	return memguard.NewBufferFromBytes(value).Seal(), nil
+}
+
+// resolve returns the value of key with its references resolved. resolving
+// holds the keys whose references are being resolved.
+func (c *Config) resolve(key string, resolving map[string]bool) ([]byte, error) {
+	if !validConfigKey(key) {
+		return nil, &os.PathError{Op: "get", Path: key, Err: ErrBadConfig}
+	}
+	if resolving[key] {
+		return nil, &os.PathError{Op: "get", Path: key, Err: ErrConfigCycle}
+	}
+	raw, err := c.b.ReadFile(c.path(key))
+	defer seal.Wipe(raw)
+	if err != nil {
+		var pathErr *os.PathError
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+		return nil, &os.PathError{Op: "get", Path: key, Err: err}
+	}
+	if !bytes.Contains(raw, []byte("$")) {
+		return append([]byte(nil), raw...), nil
+	}
+
+	resolving[key] = true
+	defer delete(resolving, key)
+	value := make([]byte, 0, len(raw))
+	for i := 0; i < len(raw); i++ {
+		switch {
+		case raw[i] != '$' || i+1 == len(raw):
+			value = append(value, raw[i])
+		case raw[i+1] == '$':
+			value = append(value, '$')
+			i++
+		case raw[i+1] == '{':
+			end := bytes.IndexByte(raw[i+2:], '}')
+			if end < 0 {
+				value = append(value, raw[i])
+				continue
+			}
+			ref, err := c.resolve(string(raw[i+2:i+2+end]), resolving)
+			if err != nil {
+				seal.Wipe(value)
+				return nil, err
+			}
+			value = append(value, ref...)
+			seal.Wipe(ref)
+			i += end + 2
+		default:
+			value = append(value, raw[i])
+		}
+	}
+
+	return value, nil
+}
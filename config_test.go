@@ -0,0 +1,146 @@
+package pandorasbox
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func configValue(t *testing.T, c *Config, key string) string {
+	t.Helper()
+	v, err := c.Get(key)
+	if err != nil {
+		t.Fatalf("Get(%q): %v", key, err)
+	}
+	if v == nil {
+		return ""
+	}
+
+	return string(openKey(t, v))
+}
+
+func TestConfigLoad(t *testing.T) {
+	tests := []struct {
+		name   string
+		format ConfigFormat
+		data   string
+		want   map[string]string
+	}{
+		{
+			name:   "dotenv",
+			format: ConfigAuto,
+			data: `# database
+export DB_USER=admin
+DB_PASS='p@$$ #word'
+DB_HOST=localhost # comment
+MOTD="hello
+\"world\"\t!"
+EMPTY=
+`,
+			want: map[string]string{
+				"DB_USER": "admin",
+				"DB_PASS": "p@$$ #word",
+				"DB_HOST": "localhost",
+				"MOTD":    "hello\n\"world\"\t!",
+				"EMPTY":   "",
+			},
+		},
+		{
+			name:   "yaml",
+			format: ConfigAuto,
+			data: `db:
+  user: admin
+  port: 5432
+hosts:
+  - a
+  - b
+`,
+			want: map[string]string{
+				"db.user": "admin",
+				"db.port": "5432",
+				"hosts.0": "a",
+				"hosts.1": "b",
+			},
+		},
+		{
+			name:   "json",
+			format: ConfigAuto,
+			data:   `{"db": {"user": "admin", "port": 5432}, "debug": true}`,
+			want: map[string]string{
+				"db.user": "admin",
+				"db.port": "5432",
+				"debug":   "true",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewBox().Config("vfs://config")
+			keys, err := c.Load(strings.NewReader(tt.data), tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stored, err := c.Keys()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(keys, stored) {
+				t.Errorf("Load returned %v, but Keys returned %v", keys, stored)
+			}
+			if len(keys) != len(tt.want) {
+				t.Errorf("Load stored %v, want %d keys", keys, len(tt.want))
+			}
+			for key, want := range tt.want {
+				if got := configValue(t, c, key); got != want {
+					t.Errorf("Get(%q) = %q, want %q", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConfigLoadErrors(t *testing.T) {
+	for _, data := range []string{
+		"KEY",
+		`KEY="unterminated`,
+		"{not json",
+	} {
+		c := NewBox().Config("vfs://config")
+		if _, err := c.Load(strings.NewReader(data), ConfigAuto); !errors.Is(err, ErrBadConfig) {
+			t.Errorf("loading %q: got %v, want %v", data, err, ErrBadConfig)
+		}
+	}
+	c := NewBox().Config("vfs://config")
+	if _, err := c.Load(strings.NewReader("KEY"), ConfigDotenv); !errors.Is(err, ErrBadConfig) {
+		t.Errorf("loading a line without =: got %v, want %v", err, ErrBadConfig)
+	}
+}
+
+func TestConfigReferences(t *testing.T) {
+	c := NewBox().Config("vfs://config")
+	_, err := c.Load(strings.NewReader(`USER=admin
+HOST=db
+URL="postgres://${USER}@${HOST}/$${literal}"
+LOOP=${LOOP2}
+LOOP2=${LOOP}
+MISSING=${NOPE}
+`), ConfigDotenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := configValue(t, c, "URL"), "postgres://admin@db/${literal}"; got != want {
+		t.Errorf("Get(URL) = %q, want %q", got, want)
+	}
+	if _, err := c.Get("LOOP"); !errors.Is(err, ErrConfigCycle) {
+		t.Errorf("getting a cyclic value: got %v, want %v", err, ErrConfigCycle)
+	}
+	if _, err := c.Get("MISSING"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("getting a value referencing a missing key: got %v, want %v", err, os.ErrNotExist)
+	}
+	if _, err := c.Get("../secret"); !errors.Is(err, ErrBadConfig) {
+		t.Errorf("getting an invalid key: got %v, want %v", err, ErrBadConfig)
+	}
+}
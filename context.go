@@ -0,0 +1,90 @@
+package pandorasbox
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/ioutil"
+)
+
+// OpenContext is like Open, but fails with the context's error if ctx is
+// already done.
+func (b *Box) OpenContext(ctx context.Context, name string) (absfs.File, error) {
+	return b.OpenFileContext(ctx, name, os.O_RDONLY, 0)
+}
+
+// OpenFileContext is like OpenFile, but fails with the context's error if
+// ctx is already done.
+func (b *Box) OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (absfs.File, error) {
+	if err := ctx.Err(); err != nil {
+		return &absfs.InvalidFile{Path: name}, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return b.OpenFile(name, flag, perm)
+}
+
+// ReadFileContext is like ReadFile, but stops reading and returns the
+// context's error once ctx is done.
+func (b *Box) ReadFileContext(ctx context.Context, filename string) ([]byte, error) {
+	f, err := b.OpenContext(ctx, filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ioutil.ReadAllContext(ctx, f)
+}
+
+// WalkContext is like Walk, but stops walking and returns the context's
+// error once ctx is done.
+func (b *Box) WalkContext(ctx context.Context, root string, walkFn filepath.WalkFunc) error {
+	return b.Walk(root, ioutil.WalkFuncContext(ctx, walkFn))
+}
+
+// RemoveAllContext is like RemoveAll, but removes entries one at a time,
+// deepest first, calling progress with the number of entries removed so
+// far after each one, and stops once ctx is done. VFS files have their
+// contents wiped from memory as they are removed, see
+// vfs.FileSystem.RemoveAllContext, and files on the host's filesystem are
+// removed with SecureRemove.
+func (b *Box) RemoveAllContext(ctx context.Context, path string, progress func(removed int)) error {
+	fs, vfsPath, ok, err := b.resolveVFS(path)
+	if err != nil {
+		return &os.PathError{Op: "removeall", Path: path, Err: err}
+	}
+	if ok {
+		if err := fs.RemoveAllContext(ctx, vfsPath, progress); err != nil {
+			return err
+		}
+		b.cancelVFSExpiry(fs, vfsPath)
+		return nil
+	}
+
+	var paths []string
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, p)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// children are walked after their parents
+	for i := len(paths) - 1; i >= 0; i-- {
+		if err := ctx.Err(); err != nil {
+			return &os.PathError{Op: "remove", Path: path, Err: err}
+		}
+		if err := b.osfs.SecureRemove(paths[i]); err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(len(paths) - i)
+		}
+	}
+
+	return nil
+}
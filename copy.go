@@ -0,0 +1,125 @@
+package pandorasbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/ioutil"
+)
+
+// Copy copies the regular file src to dst. Files on the host's filesystem
+// are copied with osfs.FileSystem.CopyFile, which makes reflinks where
+// possible and decrypts or encrypts files copied out of or into
+// directories encrypted at rest.
+// Files can also be copied from the host's filesystem into a VFS and
+// between VFSs, but not out of a VFS.
+func (b *Box) Copy(src, dst string) error {
+	srcFS, srcName, srcVFS, err := b.resolveVFS(src)
+	if err != nil {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: err}
+	}
+	dstFS, dstName, dstVFS, err := b.resolveVFS(dst)
+	if err != nil {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: err}
+	}
+	switch {
+	case srcVFS && dstVFS:
+		return ioutil.CopyFile(dstFS, dstName, srcFS, srcName)
+	case dstVFS:
+		return ioutil.CopyFile(dstFS, dstName, b.osfs, src)
+	case srcVFS:
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: errors.New("VFS files can't be copied to the host's filesystem")}
+	}
+
+	return b.osfs.CopyFile(src, dst)
+}
+
+// CopyWithProgress is like Copy, but calls fn after every chunk copied
+// with the number of bytes copied so far and the size of src. Files on the
+// host's filesystem are always copied byte by byte, so progress can be
+// reported.
+func (b *Box) CopyWithProgress(src, dst string, fn func(copied, total int64)) error {
+	return b.CopyWithProgressContext(context.Background(), src, dst, fn)
+}
+
+// CopyWithProgressContext is like CopyWithProgress, but stops copying and
+// returns the context's error once ctx is done.
+func (b *Box) CopyWithProgressContext(ctx context.Context, src, dst string, fn func(copied, total int64)) error {
+	srcFS, srcName, srcVFS, err := b.resolveVFS(src)
+	if err != nil {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: err}
+	}
+	dstFS, dstName, dstVFS, err := b.resolveVFS(dst)
+	if err != nil {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: err}
+	}
+	switch {
+	case srcVFS && dstVFS:
+		return ioutil.CopyFileProgress(ctx, dstFS, dstName, srcFS, srcName, fn)
+	case dstVFS:
+		return ioutil.CopyFileProgress(ctx, dstFS, dstName, b.osfs, src, fn)
+	case srcVFS:
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: errors.New("VFS files can't be copied to the host's filesystem")}
+	}
+
+	return ioutil.CopyFileProgress(ctx, b.osfs, dst, b.osfs, src, fn)
+}
+
+// CopyFS copies the file system src into the directory dstDir of dst,
+// like os.CopyFS. Directories are created as needed with mode 0777, and
+// files are created with mode 0666 plus the execute bits of the source
+// file, before umask. Existing files aren't overwritten; CopyFS returns an
+// error wrapping fs.ErrExist instead. Files that aren't regular files or
+// directories return an error wrapping os.ErrInvalid.
+func CopyFS(dst absfs.FileSystem, dstDir string, src fs.FS) error {
+	return fs.WalkDir(src, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		newPath := dstDir
+		if p != "." {
+			newPath = path.Join(dstDir, p)
+		}
+		if sep := dst.Separator(); sep != '/' {
+			newPath = strings.ReplaceAll(newPath, "/", string(sep))
+		}
+
+		switch d.Type() {
+		case fs.ModeDir:
+			return dst.MkdirAll(newPath, 0777)
+		case 0:
+			return copyFSFile(dst, newPath, src, p)
+		default:
+			return &os.PathError{Op: "CopyFS", Path: p, Err: os.ErrInvalid}
+		}
+	})
+}
+
+func copyFSFile(dst absfs.FileSystem, newPath string, src fs.FS, p string) error {
+	r, err := src.Open(p)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	info, err := r.Stat()
+	if err != nil {
+		return err
+	}
+
+	w, err := dst.OpenFile(newPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666|info.Mode()&0777)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return &os.PathError{Op: "Copy", Path: newPath, Err: err}
+	}
+
+	return w.Close()
+}
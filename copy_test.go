@@ -0,0 +1,88 @@
+package pandorasbox
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+var copyFSTestFiles = fstest.MapFS{
+	"hello.txt":         {Data: []byte("hello, world\n"), Mode: 0644},
+	"dir/nested.txt":    {Data: []byte("nested\n"), Mode: 0600},
+	"dir/sub/empty.txt": {Data: nil, Mode: 0644},
+	"bin/run":           {Data: []byte("#!/bin/sh\n"), Mode: 0755},
+	"emptydir":          {Mode: fs.ModeDir | 0755},
+}
+
+func TestCopyFS(t *testing.T) {
+	// the VFS doesn't mask permissions, so the modes CopyFS asks for show
+	dst := vfs.NewFS(vfs.WithUmask(0777))
+	if err := CopyFS(dst, "/copy", copyFSTestFiles); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fstest.TestFS(absfs.NewIOFS(dst, "/copy"), "hello.txt", "dir/nested.txt", "dir/sub/empty.txt", "bin/run"); err != nil {
+		t.Fatal(err)
+	}
+	for name, f := range copyFSTestFiles {
+		p := "/copy/" + name
+		info, err := dst.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Mode.IsDir() {
+			if !info.IsDir() {
+				t.Errorf("%s isn't a directory", p)
+			}
+			continue
+		}
+		data, err := ioutil.ReadFile(dst, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != string(f.Data) {
+			t.Errorf("%s holds %q, want %q", p, data, f.Data)
+		}
+		if want := 0666 | f.Mode&0111; info.Mode().Perm() != want {
+			t.Errorf("%s has mode %v, want %v", p, info.Mode().Perm(), want)
+		}
+	}
+
+	// existing files aren't overwritten
+	if err := ioutil.WriteFile(dst, "/copy/hello.txt", []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := CopyFS(dst, "/copy", copyFSTestFiles); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("copying over existing files: error %v, want %v", err, fs.ErrExist)
+	}
+	data, err := ioutil.ReadFile(dst, "/copy/hello.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "changed" {
+		t.Errorf("existing file overwritten with %q", data)
+	}
+}
+
+func TestCopyFSIrregular(t *testing.T) {
+	for _, mode := range []fs.FileMode{fs.ModeSymlink, fs.ModeNamedPipe, fs.ModeDevice} {
+		src := fstest.MapFS{
+			"file":      {Data: []byte("data")},
+			"irregular": {Data: []byte("file"), Mode: mode},
+		}
+		dst := vfs.NewFS()
+		err := CopyFS(dst, "/copy", src)
+		if !errors.Is(err, os.ErrInvalid) {
+			t.Errorf("copying a %v entry: error %v, want %v", mode, err, os.ErrInvalid)
+		}
+		if _, err := dst.Stat("/copy/irregular"); err == nil {
+			t.Errorf("a %v entry was copied", mode)
+		}
+	}
+}
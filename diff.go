@@ -0,0 +1,239 @@
+package pandorasbox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/internal/errno"
+	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+// DiffOptions control how Diff compares files.
+type DiffOptions struct {
+	// Hash compares the contents of regular files of the same size by
+	// their SHA-256 hashes, instead of by modification time.
+	Hash bool
+}
+
+// Changes are the differences between two trees found by Diff. Paths are
+// relative to the roots of the trees, use forward slashes, and are sorted.
+type Changes struct {
+	// Added are the paths only in the tree Diff compared to.
+	Added []string
+
+	// Removed are the paths only in the tree Diff compared from.
+	Removed []string
+
+	// Changed are the paths in both trees that differ in type,
+	// permissions or contents, or for symbolic links, in their targets.
+	Changed []string
+}
+
+// Empty reports whether c holds no differences.
+func (c *Changes) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Changed) == 0
+}
+
+// Diff compares the tree rooted at the directory from with the one rooted at
+// the directory to, either of which may be a VFS or a host path. Every path
+// under a directory that was added or removed is listed. Regular files
+// differ if their sizes differ, or otherwise if their modification times
+// do, or their contents if opts.Hash is set. Directories only differ in
+// type and permissions, as their modification times change with their entries.
+// opts may be nil. Symbolic links aren't followed.
+func (b *Box) Diff(from, to string, opts *DiffOptions) (*Changes, error) {
+	if opts == nil {
+		opts = new(DiffOptions)
+	}
+	old, err := b.diffTree(from)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := b.diffTree(to)
+	if err != nil {
+		return nil, err
+	}
+
+	return diff(old, cur, opts)
+}
+
+// diff returns the differences between the trees old and cur.
+func diff(old, cur *diffTree, opts *DiffOptions) (*Changes, error) {
+	c := new(Changes)
+	for rel, o := range old.files {
+		n, ok := cur.files[rel]
+		if !ok {
+			c.Removed = append(c.Removed, rel)
+			continue
+		}
+		changed, err := differ(old, cur, rel, o, n, opts)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			c.Changed = append(c.Changed, rel)
+		}
+	}
+	for rel := range cur.files {
+		if _, ok := old.files[rel]; !ok {
+			c.Added = append(c.Added, rel)
+		}
+	}
+	sort.Strings(c.Added)
+	sort.Strings(c.Removed)
+	sort.Strings(c.Changed)
+
+	return c, nil
+}
+
+// diffTree is a tree being compared by Diff.
+type diffTree struct {
+	fs   absfs.FileSystem
+	root string
+	host bool
+
+	// name is root as given to the Box
+	name  string
+	files map[string]os.FileInfo
+}
+
+// diffTree lists the tree rooted at the directory root.
+func (b *Box) diffTree(root string) (*diffTree, error) {
+	t, err := b.newDiffTree(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.list(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// newDiffTree returns the tree rooted at root, without listing it.
+func (b *Box) newDiffTree(root string) (*diffTree, error) {
+	fs, name, ok, err := b.resolveVFS(root)
+	if err != nil {
+		return nil, &os.PathError{Op: "diff", Path: root, Err: err}
+	}
+	if ok {
+		return &diffTree{fs: fs, root: name, name: root}, nil
+	}
+
+	return &diffTree{fs: b.osfs, root: root, host: true, name: root}, nil
+}
+
+// list fills t.files with the files under the root of t.
+func (t *diffTree) list() error {
+	info, err := t.fs.Lstat(t.root)
+	if err != nil {
+		return t.mapErr(err)
+	}
+	if !isDir(info) {
+		return &os.PathError{Op: "diff", Path: t.root, Err: absfs.ErrNotDir}
+	}
+
+	t.files = make(map[string]os.FileInfo)
+	err = ioutil.Walk(t.fs, t.root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == t.root {
+			return nil
+		}
+		rel, err := filepath.Rel(t.root, p)
+		if err != nil {
+			return err
+		}
+		t.files[filepath.ToSlash(rel)] = info
+		return nil
+	})
+
+	return t.mapErr(err)
+}
+
+// path returns the path of the file rel in t.
+func (t *diffTree) path(rel string) string {
+	if t.host {
+		return filepath.Join(t.root, filepath.FromSlash(rel))
+	}
+	return vfs.Join(t.root, rel)
+}
+
+func (t *diffTree) mapErr(err error) error {
+	if t.host {
+		return errno.Map(err)
+	}
+	return err
+}
+
+// fileType returns the type bits of info, with links to directories in a
+// VFS reported as plain links.
+func fileType(info os.FileInfo) os.FileMode {
+	if info.Mode()&os.ModeSymlink != 0 {
+		return os.ModeSymlink
+	}
+	return info.Mode().Type()
+}
+
+// differ reports whether the file rel differs between old and cur.
+func differ(old, cur *diffTree, rel string, o, n os.FileInfo, opts *DiffOptions) (bool, error) {
+	if fileType(o) != fileType(n) {
+		return true, nil
+	}
+
+	// the permissions of symbolic links are meaningless
+	switch {
+	case fileType(o) != os.ModeSymlink && o.Mode().Perm() != n.Mode().Perm():
+		return true, nil
+	case fileType(o) == os.ModeSymlink:
+		oldTarget, err := old.fs.Readlink(old.path(rel))
+		if err != nil {
+			return false, old.mapErr(err)
+		}
+		curTarget, err := cur.fs.Readlink(cur.path(rel))
+		if err != nil {
+			return false, cur.mapErr(err)
+		}
+		return oldTarget != curTarget, nil
+	case !o.Mode().IsRegular():
+		return false, nil
+	case o.Size() != n.Size():
+		return true, nil
+	case !opts.Hash:
+		return !o.ModTime().Equal(n.ModTime()), nil
+	}
+
+	oldSum, err := old.hash(rel)
+	if err != nil {
+		return false, err
+	}
+	curSum, err := cur.hash(rel)
+	if err != nil {
+		return false, err
+	}
+
+	return !bytes.Equal(oldSum, curSum), nil
+}
+
+// hash returns the SHA-256 hash of the contents of the file rel in t.
+func (t *diffTree) hash(rel string) ([]byte, error) {
+	f, err := t.fs.Open(t.path(rel))
+	if err != nil {
+		return nil, t.mapErr(err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, t.mapErr(err)
+	}
+
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,73 @@
+package pandorasbox
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiff(t *testing.T) {
+	b := NewBox()
+	host := t.TempDir()
+	mtime := time.Unix(1000, 0)
+	if err := b.MkdirAll("vfs://tree/sub", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(host, "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, data string) {
+		if err := b.WriteFile("vfs://tree/"+name, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Chtimes("vfs://tree/"+name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeHost := func(name, data string) {
+		p := filepath.Join(host, filepath.FromSlash(name))
+		if err := os.WriteFile(p, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("same", "same")
+	writeHost("same", "same")
+	// the same size and time, but different contents
+	write("sub/edited", "aaaa")
+	writeHost("sub/edited", "bbbb")
+	write("grown", "a")
+	writeHost("grown", "ab")
+	write("removed", "r")
+	writeHost("added", "a")
+
+	c, err := b.Diff("vfs://tree", host, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Changes{Added: []string{"added"}, Removed: []string{"removed"}, Changed: []string{"grown"}}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("Diff = %+v, want %+v", c, want)
+	}
+
+	c, err = b.Diff("vfs://tree", host, &DiffOptions{Hash: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want.Changed = []string{"grown", "sub/edited"}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("Diff with hashes = %+v, want %+v", c, want)
+	}
+
+	c, err = b.Diff("vfs://tree", "vfs://tree", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Empty() {
+		t.Errorf("a tree differs from itself: %+v", c)
+	}
+}
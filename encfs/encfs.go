@@ -0,0 +1,249 @@
+// Package encfs transparently encrypts the contents of files stored on any
+// absfs.FileSystem.
+//
+// Every file is encrypted under its own random key, which is stored at the
+// start of the file encrypted under the master key given to Wrap, followed
+// by the contents sealed in chunks of ChunkSize bytes. File names,
+// directory structure and metadata are left untouched on the backend.
+package encfs
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/awnumar/memguard"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+// HeaderSize is the size of the wrapped file key stored at the start of
+// every file that has been written to.
+const HeaderSize = seal.KeySize + seal.Overhead
+
+type FileSystem struct {
+	backend absfs.FileSystem
+	key     *memguard.Enclave
+
+	// contents of the files that are open, by absolute path
+	mtx  sync.Mutex
+	open map[string]*contents
+}
+
+// Wrap returns a FileSystem that stores encrypted files on backend, with
+// file keys sealed under key. key must be seal.KeySize bytes long.
+func Wrap(backend absfs.FileSystem, key *memguard.Enclave) *FileSystem {
+	return &FileSystem{backend: backend, key: key, open: make(map[string]*contents)}
+}
+
+// Backend returns the FileSystem encrypted files are stored on.
+func (fs *FileSystem) Backend() absfs.FileSystem {
+	return fs.backend
+}
+
+func (fs *FileSystem) Separator() uint8 {
+	return fs.backend.Separator()
+}
+
+func (fs *FileSystem) ListSeparator() uint8 {
+	return fs.backend.ListSeparator()
+}
+
+func (fs *FileSystem) Chdir(dir string) error {
+	return fs.backend.Chdir(dir)
+}
+
+func (fs *FileSystem) Getwd() (string, error) {
+	return fs.backend.Getwd()
+}
+
+func (fs *FileSystem) TempDir() string {
+	return fs.backend.TempDir()
+}
+
+func (fs *FileSystem) Open(name string) (absfs.File, error) {
+	return fs.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (fs *FileSystem) Create(name string) (absfs.File, error) {
+	return fs.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
+}
+
+func (fs *FileSystem) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	// chunks are read before they're resealed, so the backend file always
+	// has to be readable, and appending is handled by File
+	backendFlag := flag &^ (absfs.O_ACCESS | os.O_APPEND)
+	if flag&absfs.O_ACCESS != os.O_RDONLY {
+		backendFlag |= os.O_RDWR
+	}
+
+	bf, err := fs.backend.OpenFile(name, backendFlag, perm)
+	if err != nil {
+		return bf, err
+	}
+
+	path := fs.abs(name)
+	c, err := fs.acquire(path, bf)
+	if err != nil {
+		bf.Close()
+		return &absfs.InvalidFile{Path: name}, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	f := &File{fs: fs, f: bf, name: name, path: path, flags: flag, c: c}
+	if flag&os.O_APPEND != 0 {
+		f.offset = c.size
+	}
+
+	return f, nil
+}
+
+// abs returns name as a clean absolute path, which the open Files of the
+// same file share their contents under.
+func (fs *FileSystem) abs(name string) string {
+	if !filepath.IsAbs(name) {
+		if wd, err := fs.backend.Getwd(); err == nil {
+			name = filepath.Join(wd, name)
+		}
+	}
+
+	return filepath.Clean(name)
+}
+
+func (fs *FileSystem) Mkdir(name string, perm os.FileMode) error {
+	return fs.backend.Mkdir(name, perm)
+}
+
+func (fs *FileSystem) MkdirAll(name string, perm os.FileMode) error {
+	return fs.backend.MkdirAll(name, perm)
+}
+
+func (fs *FileSystem) Remove(name string) error {
+	return fs.backend.Remove(name)
+}
+
+func (fs *FileSystem) RemoveAll(name string) error {
+	return fs.backend.RemoveAll(name)
+}
+
+func (fs *FileSystem) Rename(oldpath, newpath string) error {
+	return fs.backend.Rename(oldpath, newpath)
+}
+
+func (fs *FileSystem) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.backend.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return wrapInfo(info), nil
+}
+
+func (fs *FileSystem) Lstat(name string) (os.FileInfo, error) {
+	info, err := fs.backend.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return wrapInfo(info), nil
+}
+
+func (fs *FileSystem) Chmod(name string, mode os.FileMode) error {
+	return fs.backend.Chmod(name, mode)
+}
+
+func (fs *FileSystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return fs.backend.Chtimes(name, atime, mtime)
+}
+
+func (fs *FileSystem) Chown(name string, uid, gid int) error {
+	return fs.backend.Chown(name, uid, gid)
+}
+
+func (fs *FileSystem) Lchown(name string, uid, gid int) error {
+	return fs.backend.Lchown(name, uid, gid)
+}
+
+func (fs *FileSystem) Truncate(name string, size int64) error {
+	f, err := fs.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	err = f.Truncate(size)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+
+	return err
+}
+
+func (fs *FileSystem) Readlink(name string) (string, error) {
+	return fs.backend.Readlink(name)
+}
+
+func (fs *FileSystem) Symlink(oldname, newname string) error {
+	return fs.backend.Symlink(oldname, newname)
+}
+
+// Link creates a hard link on the backend, if it supports them. Both names
+// share the same encrypted contents.
+func (fs *FileSystem) Link(oldname, newname string) error {
+	l, ok := fs.backend.(absfs.Linker)
+	if !ok {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: absfs.ErrNotImplemented}
+	}
+	return l.Link(oldname, newname)
+}
+
+// Capabilities returns the capabilities of the backend, except for
+// extended attributes, which would be stored unencrypted.
+func (fs *FileSystem) Capabilities() absfs.Capability {
+	return absfs.Capabilities(fs.backend) &^ absfs.CapXattr
+}
+
+// fileInfo reports the size of the plaintext stored in an encrypted file.
+type fileInfo struct {
+	os.FileInfo
+}
+
+func wrapInfo(info os.FileInfo) os.FileInfo {
+	if !info.Mode().IsRegular() {
+		return info
+	}
+
+	return &fileInfo{info}
+}
+
+func (i *fileInfo) Size() int64 {
+	return plaintextSize(i.FileInfo.Size())
+}
+
+// plaintextSize returns the size of the contents of a file that is size
+// bytes long on the backend.
+func plaintextSize(size int64) int64 {
+	size -= int64(HeaderSize)
+	if size <= 0 {
+		return 0
+	}
+	n := size / sealedChunkSize * ChunkSize
+	if rem := size % sealedChunkSize; rem > seal.ChunkOverhead {
+		n += rem - seal.ChunkOverhead
+	}
+
+	return n
+}
+
+// validSize reports whether an encrypted file can be size bytes long on the
+// backend.
+func validSize(size int64) bool {
+	if size == 0 {
+		return true
+	}
+	size -= int64(HeaderSize)
+	if size < 0 {
+		return false
+	}
+	rem := size % sealedChunkSize
+
+	return rem == 0 || rem > seal.ChunkOverhead
+}
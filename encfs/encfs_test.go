@@ -0,0 +1,252 @@
+package encfs_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/encfs"
+	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/osfs"
+	"github.com/capnspacehook/pandorasbox/seal"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+const secret = "The quick brown fox jumped over the lazy dog."
+
+func testBackend(t *testing.T, backend absfs.FileSystem, dir string) {
+	fs := encfs.Wrap(backend, seal.NewKey())
+	name := filepath.Join(dir, "secret.txt")
+
+	if err := ioutil.WriteFile(fs, name, []byte(secret), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := ioutil.ReadFile(backend, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("fox")) {
+		t.Fatal("plaintext stored on backend")
+	}
+	if want := encfs.HeaderSize + len(secret) + seal.ChunkOverhead; len(raw) != want {
+		t.Errorf("wrong backend size: %d, expected %d", len(raw), want)
+	}
+
+	info, err := fs.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(secret)) {
+		t.Errorf("wrong size: %d, expected %d", info.Size(), len(secret))
+	}
+
+	data, err := ioutil.ReadFile(fs, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != secret {
+		t.Fatalf("wrong contents: %q, expected %q", data, secret)
+	}
+
+	f, err := fs.OpenFile(name, os.O_RDWR|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte(" Twice.")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 5)
+	if _, err := f.ReadAt(buf, 4); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "quick" {
+		t.Errorf("wrong ReadAt contents: %q", buf)
+	}
+	if err := f.Truncate(9); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := f.Stat(); info.Size() != 9 {
+		t.Errorf("wrong size of open file: %d, expected %d", info.Size(), 9)
+	}
+	f.Close()
+
+	data, err = ioutil.ReadFile(fs, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != secret[:9] {
+		t.Fatalf("wrong contents: %q, expected %q", data, secret[:9])
+	}
+
+	// files can't be read with a different master key
+	other := encfs.Wrap(backend, seal.NewKey())
+	if _, err := other.Open(name); err == nil {
+		t.Fatal("opened file with wrong key")
+	}
+}
+
+func TestVFSBackend(t *testing.T) {
+	testBackend(t, vfs.NewFS(), "/")
+}
+
+func TestOSBackend(t *testing.T) {
+	dir, err := ioutil.TempDir(osfs.NewFS(), "", "encfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testBackend(t, osfs.NewFS(), dir)
+}
+
+func TestEmptyFile(t *testing.T) {
+	fs := encfs.Wrap(vfs.NewFS(), seal.NewKey())
+
+	f, err := fs.Create("/empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	f, err = fs.Open("/empty")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestRekey(t *testing.T) {
+	backend := vfs.NewFS()
+	fs := encfs.Wrap(backend, seal.NewKey())
+	if err := ioutil.WriteFile(fs, "/secret", []byte(secret), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	key := seal.NewKey()
+	for i := 0; i < 2; i++ {
+		if err := fs.Rekey("/secret", key); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(encfs.Wrap(backend, key), "/secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != secret {
+		t.Fatalf("wrong contents after rekey: %q", data)
+	}
+}
+
+func TestSharedHandles(t *testing.T) {
+	fs := encfs.Wrap(vfs.NewFS(), seal.NewKey())
+
+	a, err := fs.OpenFile("/shared", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := fs.OpenFile("/shared", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i, f := range []absfs.File{a, b} {
+		wg.Add(1)
+		go func(i int, f absfs.File) {
+			defer wg.Done()
+			data := bytes.Repeat([]byte{'a' + byte(i)}, encfs.ChunkSize)
+			if _, err := f.WriteAt(data, int64(i)*encfs.ChunkSize); err != nil {
+				t.Error(err)
+			}
+		}(i, f)
+	}
+	wg.Wait()
+
+	// each handle sees the writes of the other
+	buf := make([]byte, 1)
+	if _, err := a.ReadAt(buf, encfs.ChunkSize); err != nil || buf[0] != 'b' {
+		t.Errorf("reading b's write through a: %q, %v", buf, err)
+	}
+	if _, err := b.ReadAt(buf, 0); err != nil || buf[0] != 'a' {
+		t.Errorf("reading a's write through b: %q, %v", buf, err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Write([]byte("x")); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(fs, "/shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := append(bytes.Repeat([]byte("a"), encfs.ChunkSize), bytes.Repeat([]byte("b"), encfs.ChunkSize)...)
+	want[0] = 'x'
+	if !bytes.Equal(data, want) {
+		t.Errorf("closing the handles lost writes: read %d bytes", len(data))
+	}
+}
+
+func TestStreamedWrite(t *testing.T) {
+	backend := osfs.NewFS()
+	dir, err := ioutil.TempDir(backend, "", "encfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fs := encfs.Wrap(backend, seal.NewKey())
+	name := filepath.Join(dir, "big")
+
+	data := make([]byte, 8<<20+123)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// hide WriterTo, so the data is copied in 32 KiB writes
+	if _, err := io.CopyBuffer(f, struct{ io.Reader }{bytes.NewReader(data)}, make([]byte, 32<<10)); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(fs, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("streamed file read back differently")
+	}
+	if info, err := fs.Stat(name); err != nil || info.Size() != int64(len(data)) {
+		t.Errorf("stat of streamed file: %v, %v", info, err)
+	}
+
+	// cutting the file at a chunk boundary is detected
+	info, err := backend.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.Truncate(name, info.Size()-int64(len(data)%encfs.ChunkSize)-seal.ChunkOverhead); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadFile(fs, name); !errors.Is(err, encfs.ErrCorrupt) {
+		t.Errorf("reading a file cut short: got %v, want %v", err, encfs.ErrCorrupt)
+	}
+}
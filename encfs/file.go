@@ -0,0 +1,505 @@
+package encfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/awnumar/memguard"
+	"github.com/awnumar/memguard/core"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+// ErrCorrupt is returned for files that weren't encrypted under the master
+// key, or were changed on the backend.
+var ErrCorrupt = errors.New("encrypted file is corrupt")
+
+// ChunkSize is the size of the chunks of plaintext files are sealed in.
+// Every chunk is sealed on its own, so reads and writes only open and
+// reseal the chunks they touch.
+const ChunkSize = 64 << 10
+
+// sealedChunkSize is the size of a full chunk on the backend.
+const sealedChunkSize = ChunkSize + seal.ChunkOverhead
+
+// chunkAD returns the additional data chunk index of a file is sealed with.
+// Chunks can't be moved between files as every file has its own key, and
+// the generation marks the last chunk, so files cut short at a chunk
+// boundary fail to read like any other corrupt chunk.
+func chunkAD(index int64, last bool) []byte {
+	var gen uint64
+	if last {
+		gen = 1
+	}
+
+	return seal.ChunkAD(0, gen, uint64(index))
+}
+
+// chunkOffset returns the offset of chunk index on the backend.
+func chunkOffset(index int64) int64 {
+	return int64(HeaderSize) + index*sealedChunkSize
+}
+
+// contents is the state shared by the open Files of an encrypted file, so
+// writes through one are seen by the others.
+type contents struct {
+	mtx  sync.RWMutex
+	refs int
+
+	// key is the file key, or nil if the file has no header yet
+	key  *memguard.Enclave
+	size int64
+}
+
+// File is an open encrypted file. Writes are sealed and written to the
+// backend as they happen, one chunk at a time, and the Files open on the
+// same path share their key and size.
+type File struct {
+	mtx sync.Mutex
+
+	fs    *FileSystem
+	f     absfs.File
+	name  string
+	path  string
+	flags int
+
+	c      *contents
+	offset int64
+	closed bool
+}
+
+// readKey unwraps the file key from the header of the backend file bf of
+// the given size.
+func (fs *FileSystem) readKey(bf absfs.File, size int64) (*memguard.Enclave, error) {
+	if !validSize(size) {
+		return nil, ErrCorrupt
+	}
+	header := make([]byte, HeaderSize)
+	if _, err := bf.ReadAt(header, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	key := memguard.NewBuffer(seal.KeySize)
+	if err := seal.Decrypt(header, fs.key, key.Bytes()); err != nil {
+		key.Destroy()
+		if errors.Is(err, core.ErrDecryptionFailed) {
+			err = ErrCorrupt
+		}
+		return nil, err
+	}
+
+	return key.Seal(), nil
+}
+
+// acquire returns the shared contents of the file path, opened on the
+// backend as bf.
+func (fs *FileSystem) acquire(path string, bf absfs.File) (*contents, error) {
+	info, err := bf.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return &contents{}, nil
+	}
+
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	c := fs.open[path]
+	if c == nil {
+		c = &contents{}
+	}
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	// an empty backend file was created or truncated since the contents
+	// were loaded, and starts over with a new key
+	if c.refs == 0 || info.Size() == 0 {
+		c.key, c.size = nil, 0
+		if info.Size() != 0 {
+			if c.key, err = fs.readKey(bf, info.Size()); err != nil {
+				return nil, err
+			}
+			c.size = plaintextSize(info.Size())
+		}
+	}
+	c.refs++
+	fs.open[path] = c
+
+	return c, nil
+}
+
+// release drops a reference to the shared contents of path.
+func (fs *FileSystem) release(path string, c *contents) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	c.refs--
+	if c.refs <= 0 && fs.open[path] == c {
+		delete(fs.open, path)
+	}
+}
+
+// readChunk returns the plaintext of chunk index. The caller must hold
+// f.c.mtx and wipe the plaintext when done with it.
+func (f *File) readChunk(index int64) ([]byte, error) {
+	start := index * ChunkSize
+	n := min(int64(ChunkSize), f.c.size-start)
+	sealed := make([]byte, n+seal.ChunkOverhead)
+	m, err := f.f.ReadAt(sealed, chunkOffset(index))
+	if m < len(sealed) {
+		if err == nil || err == io.EOF {
+			err = ErrCorrupt
+		}
+		return nil, err
+	}
+
+	plaintext, err := seal.OpenChunk(nil, sealed, f.c.key, chunkAD(index, start+n >= f.c.size))
+	if errors.Is(err, seal.ErrChunk) {
+		err = ErrCorrupt
+	}
+
+	return plaintext, err
+}
+
+// writeHeader gives the file a new key, and writes it to the backend
+// wrapped under the master key. The caller must hold f.c.mtx.
+func (f *File) writeHeader() error {
+	key := seal.NewKey()
+	k, err := key.Open()
+	if err != nil {
+		return err
+	}
+	header, err := seal.Encrypt(k.Bytes(), f.fs.key)
+	k.Destroy()
+	if err != nil {
+		return err
+	}
+	if _, err := f.f.WriteAt(header, 0); err != nil {
+		return err
+	}
+	f.c.key = key
+
+	return nil
+}
+
+// update writes b at off and resizes the file to size, which must not be
+// smaller than its current size, resealing only the chunks that change.
+// The caller must hold f.c.mtx.
+func (f *File) update(b []byte, off, size int64) error {
+	c := f.c
+	if c.key == nil {
+		if err := f.writeHeader(); err != nil {
+			return err
+		}
+	}
+
+	end := off + int64(len(b))
+	from, to := min(off, c.size), end
+	if size > c.size {
+		to = size
+		// the last chunk stops being the last one
+		if c.size > 0 {
+			from = min(from, (c.size-1)/ChunkSize*ChunkSize)
+		}
+	}
+	for index := from / ChunkSize; index*ChunkSize < to; index++ {
+		start := index * ChunkSize
+		data := make([]byte, min(int64(ChunkSize), size-start))
+		if start < c.size {
+			chunk, err := f.readChunk(index)
+			if err != nil {
+				return err
+			}
+			copy(data, chunk)
+			seal.Wipe(chunk)
+		}
+		if off < start+int64(len(data)) && end > start {
+			copy(data[max(off-start, 0):], b[max(start-off, 0):])
+		}
+
+		sealed, err := seal.SealChunk(data, c.key, chunkAD(index, start+int64(len(data)) >= size))
+		seal.Wipe(data)
+		if err != nil {
+			return err
+		}
+		if _, err := f.f.WriteAt(sealed, chunkOffset(index)); err != nil {
+			return err
+		}
+	}
+	c.size = size
+
+	return nil
+}
+
+// shrink cuts the file down to size, which must be smaller than its
+// current size. The caller must hold f.c.mtx.
+func (f *File) shrink(size int64) error {
+	c := f.c
+	if size == 0 {
+		if err := f.f.Truncate(int64(HeaderSize)); err != nil {
+			return err
+		}
+		c.size = 0
+		return nil
+	}
+
+	index := (size - 1) / ChunkSize
+	chunk, err := f.readChunk(index)
+	if err != nil {
+		return err
+	}
+	sealed, err := seal.SealChunk(chunk[:size-index*ChunkSize], c.key, chunkAD(index, true))
+	seal.Wipe(chunk)
+	if err != nil {
+		return err
+	}
+	if _, err := f.f.WriteAt(sealed, chunkOffset(index)); err != nil {
+		return err
+	}
+	if err := f.f.Truncate(chunkOffset(index) + int64(len(sealed))); err != nil {
+		return err
+	}
+	c.size = size
+
+	return nil
+}
+
+func (f *File) Name() string {
+	return f.name
+}
+
+// Backend returns the file on the backend the encrypted file is stored in.
+func (f *File) Backend() absfs.File {
+	return f.f
+}
+
+func (f *File) readAt(b []byte, off int64) (int, error) {
+	if f.closed {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrClosed}
+	}
+	if f.flags&absfs.O_ACCESS == os.O_WRONLY {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EBADF}
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	f.c.mtx.RLock()
+	defer f.c.mtx.RUnlock()
+
+	if off >= f.c.size {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(b) && off < f.c.size {
+		index := off / ChunkSize
+		chunk, err := f.readChunk(index)
+		if err != nil {
+			return n, &os.PathError{Op: "read", Path: f.name, Err: err}
+		}
+		m := copy(b[n:], chunk[off-index*ChunkSize:])
+		seal.Wipe(chunk)
+		n += m
+		off += int64(m)
+	}
+
+	return n, nil
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	n, err := f.readAt(p, f.offset)
+	f.offset += int64(n)
+
+	return n, err
+}
+
+func (f *File) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	n, err := f.readAt(b, off)
+	if err == nil && n < len(b) {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// writeAt writes b at off, or at the end of the file if appending. The
+// caller must hold f.mtx.
+func (f *File) writeAt(b []byte, off int64, appending bool) (int, error) {
+	if f.closed {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrClosed}
+	}
+	if f.flags&absfs.O_ACCESS == os.O_RDONLY {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EBADF}
+	}
+
+	f.c.mtx.Lock()
+	defer f.c.mtx.Unlock()
+
+	if appending {
+		off = f.c.size
+	}
+	if err := f.update(b, off, max(f.c.size, off+int64(len(b)))); err != nil {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: err}
+	}
+	f.offset = off
+
+	return len(b), nil
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	n, err := f.writeAt(p, f.offset, f.flags&os.O_APPEND != 0)
+	f.offset += int64(n)
+
+	return n, err
+}
+
+func (f *File) WriteAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "writeat", Path: f.name, Err: errors.New("negative offset")}
+	}
+	if f.flags&os.O_APPEND != 0 {
+		return 0, errors.New("encfs: invalid use of WriteAt on file opened with O_APPEND")
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	offset := f.offset
+	n, err := f.writeAt(b, off, false)
+	f.offset = offset
+
+	return n, err
+}
+
+func (f *File) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		f.c.mtx.RLock()
+		offset += f.c.size
+		f.c.mtx.RUnlock()
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.offset = offset
+
+	return offset, nil
+}
+
+func (f *File) Truncate(size int64) error {
+	if size < 0 {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EINVAL}
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.closed {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: os.ErrClosed}
+	}
+	if f.flags&absfs.O_ACCESS == os.O_RDONLY {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EBADF}
+	}
+
+	f.c.mtx.Lock()
+	defer f.c.mtx.Unlock()
+
+	var err error
+	switch {
+	case size < f.c.size:
+		err = f.shrink(size)
+	case size > f.c.size:
+		err = f.update(nil, f.c.size, size)
+	}
+	if err != nil {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: err}
+	}
+
+	return nil
+}
+
+func (f *File) Stat() (os.FileInfo, error) {
+	info, err := f.f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return info, nil
+	}
+
+	f.c.mtx.RLock()
+	defer f.c.mtx.RUnlock()
+
+	return &openInfo{FileInfo: info, size: f.c.size}, nil
+}
+
+func (f *File) Sync() error {
+	return f.f.Sync()
+}
+
+func (f *File) Close() error {
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if f.closed {
+		return &os.PathError{Op: "close", Path: f.name, Err: os.ErrClosed}
+	}
+	f.closed = true
+	f.fs.release(f.path, f.c)
+
+	return f.f.Close()
+}
+
+func (f *File) Readdir(n int) ([]os.FileInfo, error) {
+	infos, err := f.f.Readdir(n)
+	for i := range infos {
+		infos[i] = wrapInfo(infos[i])
+	}
+
+	return infos, err
+}
+
+func (f *File) Readdirnames(n int) ([]string, error) {
+	return f.f.Readdirnames(n)
+}
+
+// openInfo reports the size of an open file, which other open Files may
+// be changing.
+type openInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (i *openInfo) Size() int64 {
+	return i.size
+}
@@ -0,0 +1,57 @@
+package encfs
+
+import (
+	"io"
+	"os"
+
+	"github.com/awnumar/memguard"
+
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+// Rekey re-wraps the key of the file name under key, so that it can be
+// read by a FileSystem using key as its master key. The file's contents are
+// not re-encrypted. Files whose keys are already wrapped under key are left
+// untouched, so an interrupted rotation can safely be run again.
+func (fs *FileSystem) Rekey(name string, key *memguard.Enclave) error {
+	f, err := fs.backend.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() || info.Size() == 0 {
+		return nil
+	}
+	if !validSize(info.Size()) {
+		return &os.PathError{Op: "rekey", Path: name, Err: ErrCorrupt}
+	}
+
+	header := make([]byte, HeaderSize)
+	if _, err := f.ReadAt(header, 0); err != nil && err != io.EOF {
+		return err
+	}
+
+	fileKey := memguard.NewBuffer(seal.KeySize)
+	defer fileKey.Destroy()
+	if seal.Decrypt(header, key, fileKey.Bytes()) == nil {
+		return nil
+	}
+	if err := seal.Decrypt(header, fs.key, fileKey.Bytes()); err != nil {
+		return &os.PathError{Op: "rekey", Path: name, Err: err}
+	}
+
+	header, err = seal.Encrypt(fileKey.Bytes(), key)
+	if err != nil {
+		return &os.PathError{Op: "rekey", Path: name, Err: err}
+	}
+	if _, err := f.WriteAt(header, 0); err != nil {
+		return err
+	}
+
+	return f.Sync()
+}
@@ -0,0 +1,44 @@
+package pandorasbox
+
+import (
+	"io"
+
+	"github.com/awnumar/memguard"
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+// ExportEncryptedTar writes the contents of the VFS to w as a tar archive
+// sealed under key as a seal stream. The archive is sealed in chunks as it
+// is written, so no more than one chunk of it is held in memory however
+// large the VFS is, and w can be a connection to remote storage.
+func (b *Box) ExportEncryptedTar(w io.Writer, key *memguard.Enclave) error {
+	return b.ExportEncryptedTarPaths(w, []string{VFSPrefix}, key)
+}
+
+// ExportEncryptedTarPaths is like ExportEncryptedTar, but only exports the
+// files and directories under paths, which may be VFS or OS paths.
+func (b *Box) ExportEncryptedTarPaths(w io.Writer, paths []string, key *memguard.Enclave) error {
+	sw, err := seal.NewWriter(w, key)
+	if err != nil {
+		return err
+	}
+	if err := b.writeTar(sw, paths); err != nil {
+		sw.Close()
+		return err
+	}
+
+	return sw.Close()
+}
+
+// ImportEncryptedTar opens an archive written by ExportEncryptedTar with
+// key, and extracts it into dir as it is read. It fails with
+// seal.ErrStream if the archive was tampered with or cut off, in which
+// case the entries before the damage have already been extracted.
+func (b *Box) ImportEncryptedTar(r io.Reader, dir string, key *memguard.Enclave) error {
+	sr, err := seal.NewReader(r, key)
+	if err != nil {
+		return err
+	}
+
+	return b.readTar(sr, dir)
+}
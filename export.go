@@ -0,0 +1,167 @@
+package pandorasbox
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/inode"
+	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/osfs"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+// Export writes the VFS file or directory tree at vfsPath to osPath on the
+// host's filesystem, decrypting it. Permissions, modification times and
+// extended attributes are preserved, and so is ownership if the process
+// is privileged enough to change it. Only regular files and directories
+// are exported.
+func (b *Box) Export(vfsPath, osPath string) error {
+	return b.export(vfsPath, osPath, false)
+}
+
+// ExportAtomic is like Export, but writes each file to a temporary file
+// next to it and renames it into place once it is complete, so no file is
+// ever left half written.
+func (b *Box) ExportAtomic(vfsPath, osPath string) error {
+	return b.export(vfsPath, osPath, true)
+}
+
+func (b *Box) export(vfsPath, osPath string, atomic bool) error {
+	vfsys, vfsRoot, ok, err := b.resolveVFS(vfsPath)
+	if err != nil {
+		return &os.LinkError{Op: "export", Old: vfsPath, New: osPath, Err: err}
+	}
+	if !ok {
+		return &os.LinkError{Op: "export", Old: vfsPath, New: osPath, Err: errors.New("vfsPath must be a VFS path")}
+	}
+	if _, _, ok, err := b.resolveVFS(osPath); ok || err != nil {
+		return &os.LinkError{Op: "export", Old: vfsPath, New: osPath, Err: errors.New("osPath must be a path on the host's filesystem")}
+	}
+
+	hostFS := b.hostFS(filepath.Dir(osPath))
+	// directories are written to as their contents are exported, so their
+	// times are set once everything in them is
+	var dirs []string
+	var dirInfos []os.FileInfo
+	err = ioutil.Walk(vfsys, vfsRoot, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(osPath, filepath.FromSlash(strings.TrimPrefix(p, vfsRoot)))
+
+		switch {
+		case info.IsDir():
+			if err := hostFS.MkdirAll(target, 0700); err != nil {
+				return err
+			}
+			if err := setMetadata(hostFS, target, vfsys, p, info, false); err != nil {
+				return err
+			}
+			dirs, dirInfos = append(dirs, target), append(dirInfos, info)
+		case info.Mode().IsRegular():
+			return exportFile(hostFS, target, vfsys, p, info, atomic)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := hostFS.Chtimes(dirs[i], atime(dirInfos[i]), dirInfos[i].ModTime()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// exportFile writes the VFS file name to target on hostFS.
+func exportFile(hostFS absfs.FileSystem, target string, vfsys *vfs.FileSystem, name string, info os.FileInfo, atomic bool) error {
+	in, err := vfsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	dst := target
+	if atomic {
+		random := make([]byte, 8)
+		if _, err := rand.Read(random); err != nil {
+			return err
+		}
+		dst = filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+"."+hex.EncodeToString(random))
+	}
+	flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if atomic {
+		flag |= os.O_EXCL
+	}
+	out, err := hostFS.OpenFile(dst, flag, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err == nil && atomic {
+		err = out.Sync()
+	}
+	if err1 := out.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = setMetadata(hostFS, dst, vfsys, name, info, true)
+	}
+	if err == nil && atomic {
+		if err = hostFS.Rename(dst, target); err == nil {
+			err = osfs.SyncDir(filepath.Dir(target))
+		}
+	}
+	if err != nil && atomic {
+		hostFS.Remove(dst)
+	}
+
+	return err
+}
+
+// setMetadata copies the permissions, ownership and extended attributes of
+// the VFS file name to target, and its times if withTimes is true.
+func setMetadata(hostFS absfs.FileSystem, target string, vfsys *vfs.FileSystem, name string, info os.FileInfo, withTimes bool) error {
+	if err := hostFS.Chmod(target, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if node, ok := info.Sys().(*inode.Inode); ok && privileged() {
+		if err := hostFS.Chown(target, int(node.Uid), int(node.Gid)); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.CopyXattrs(hostFS, target, vfsys, name); err != nil {
+		return err
+	}
+	if withTimes {
+		return hostFS.Chtimes(target, atime(info), info.ModTime())
+	}
+
+	return nil
+}
+
+// atime returns the access time of the VFS file described by info.
+func atime(info os.FileInfo) time.Time {
+	if node, ok := info.Sys().(*inode.Inode); ok && !node.Atime.IsZero() {
+		return node.Atime
+	}
+	return info.ModTime()
+}
+
+// privileged reports whether the process can give files away to other
+// users.
+func privileged() bool {
+	return runtime.GOOS != "windows" && os.Geteuid() == 0
+}
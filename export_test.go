@@ -0,0 +1,65 @@
+package pandorasbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExport(t *testing.T) {
+	b := NewBox()
+	if err := b.MkdirAll("vfs://tree/sub", 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteFile("vfs://tree/sub/a", []byte("hunter2"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := b.Chtimes("vfs://tree/sub/a", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, atomic := range []bool{false, true} {
+		dir := filepath.Join(t.TempDir(), "out")
+		var err error
+		if atomic {
+			err = b.ExportAtomic("vfs://tree", dir)
+		} else {
+			err = b.Export("vfs://tree", dir)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		name := filepath.Join(dir, "sub", "a")
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != "hunter2" {
+			t.Errorf("exported %q, want %q", data, "hunter2")
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0640 || !info.ModTime().Equal(mtime) {
+			t.Errorf("exported with mode %v and mtime %v, want %v and %v", info.Mode().Perm(), info.ModTime(), os.FileMode(0640), mtime)
+		}
+		if info, err := os.Stat(filepath.Join(dir, "sub")); err != nil || info.Mode().Perm() != 0750 {
+			t.Errorf("exported directory: %v, %v", info, err)
+		}
+		entries, err := os.ReadDir(filepath.Join(dir, "sub"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("temporary files left behind: %v", entries)
+		}
+	}
+
+	if err := b.Export(filepath.Join(t.TempDir(), "host"), t.TempDir()); err == nil {
+		t.Error("exported from the host's filesystem")
+	}
+}
@@ -8,7 +8,7 @@ import (
 )
 
 func IsAbs(path string) bool {
-	if _, ok := ConvertVFSPath(path); ok {
+	if _, _, ok := splitScheme(path); ok {
 		return vfs.IsAbs(path)
 	}
 
@@ -16,37 +16,37 @@ func IsAbs(path string) bool {
 }
 
 func Clean(path string) string {
-	if vfsPath, ok := ConvertVFSPath(path); ok {
+	if scheme, vfsPath, ok := splitScheme(path); ok {
 		path = vfsPath
-		return MakeVFSPath(vfs.Clean(path))
+		return makeSchemePath(scheme, vfs.Clean(path))
 	}
 
 	return filepath.Clean(path)
 }
 
 func ToSlash(path string) string {
-	if vfsPath, ok := ConvertVFSPath(path); ok {
+	if scheme, vfsPath, ok := splitScheme(path); ok {
 		path = vfsPath
-		return MakeVFSPath(filepath.ToSlash(path))
+		return makeSchemePath(scheme, filepath.ToSlash(path))
 	}
 
 	return filepath.ToSlash(path)
 }
 
 func FromSlash(path string) string {
-	if vfsPath, ok := ConvertVFSPath(path); ok {
+	if scheme, vfsPath, ok := splitScheme(path); ok {
 		path = vfsPath
-		return MakeVFSPath(filepath.FromSlash(path))
+		return makeSchemePath(scheme, filepath.FromSlash(path))
 	}
 
 	return filepath.FromSlash(path)
 }
 
 func Split(path string) (string, string) {
-	if vfsPath, ok := ConvertVFSPath(path); ok {
+	if scheme, vfsPath, ok := splitScheme(path); ok {
 		path = vfsPath
 		dir, file := vfs.Split(path)
-		dir = MakeVFSPath(dir)
+		dir = makeSchemePath(scheme, dir)
 		return dir, file
 	}
 
@@ -54,13 +54,13 @@ func Split(path string) (string, string) {
 }
 
 func Join(elem ...string) string {
-	if vfsPath, ok := ConvertVFSPath(elem[0]); ok {
+	if scheme, vfsPath, ok := splitScheme(elem[0]); ok {
 		elem[0] = vfsPath
-		return MakeVFSPath(vfs.Join(elem...))
+		return makeSchemePath(scheme, vfs.Join(elem...))
 	}
 
 	for i := range elem[1:] {
-		if vfsPath, ok := ConvertVFSPath(elem[i+1]); ok {
+		if _, vfsPath, ok := splitScheme(elem[i+1]); ok {
 			elem[i+1] = vfsPath
 		}
 	}
@@ -69,35 +69,35 @@ func Join(elem ...string) string {
 }
 
 func Ext(path string) string {
-	if vfsPath, ok := ConvertVFSPath(path); ok {
+	if scheme, vfsPath, ok := splitScheme(path); ok {
 		path = vfsPath
-		return MakeVFSPath(vfs.Ext(path))
+		return makeSchemePath(scheme, vfs.Ext(path))
 	}
 
 	return filepath.Ext(path)
 }
 
 func Base(path string) string {
-	if vfsPath, ok := ConvertVFSPath(path); ok {
+	if scheme, vfsPath, ok := splitScheme(path); ok {
 		path = vfsPath
-		return MakeVFSPath(vfs.Base(path))
+		return makeSchemePath(scheme, vfs.Base(path))
 	}
 
 	return filepath.Base(path)
 }
 
 func Dir(path string) string {
-	if vfsPath, ok := ConvertVFSPath(path); ok {
+	if scheme, vfsPath, ok := splitScheme(path); ok {
 		path = vfsPath
-		return MakeVFSPath(vfs.Dir(path))
+		return makeSchemePath(scheme, vfs.Dir(path))
 	}
 
 	return filepath.Dir(path)
 }
 
 func Rel(basepath, targpath string) (string, error) {
-	vfsBasepath, basepathVfs := ConvertVFSPath(basepath)
-	vfsTargpath, targpathVfs := ConvertVFSPath(targpath)
+	_, vfsBasepath, basepathVfs := splitScheme(basepath)
+	_, vfsTargpath, targpathVfs := splitScheme(targpath)
 
 	if (basepathVfs && !targpathVfs) || (!basepathVfs && targpathVfs) {
 		return "", errors.New("basepath and targpath must both be a VFS path")
@@ -0,0 +1,38 @@
+package pandorasbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/capnspacehook/pandorasbox/ioutil"
+)
+
+// Glob returns the names of all files matching pattern, or nil if there is
+// no matching file, like filepath.Glob. Patterns under vfs:// or another
+// VFS scheme are matched in that VFS, and the names returned keep the
+// prefix of the pattern, so they can be passed straight to Open.
+func (b *Box) Glob(pattern string) ([]string, error) {
+	fs, vfsPattern, ok, err := b.resolveVFS(pattern)
+	if err != nil {
+		return nil, &os.PathError{Op: "glob", Path: pattern, Err: err}
+	}
+	if !ok {
+		return filepath.Glob(pattern)
+	}
+
+	matches, err := ioutil.Glob(fs, vfsPattern)
+	if err != nil {
+		return nil, err
+	}
+	// the part of pattern that selects the VFS, such as vfs://name/
+	prefix := VFSPrefix
+	if rel := strings.TrimPrefix(vfsPattern, "/"); strings.HasSuffix(pattern, rel) {
+		prefix = pattern[:len(pattern)-len(rel)]
+	}
+	for i, match := range matches {
+		matches[i] = prefix + strings.TrimPrefix(match, "/")
+	}
+
+	return matches, nil
+}
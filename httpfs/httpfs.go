@@ -0,0 +1,273 @@
+// Package httpfs serves an absfs.FileSystem over HTTP, so that services not
+// written in Go can use a box as a lightweight secrets endpoint.
+//
+// GET of a file returns its contents, and GET of a directory returns its
+// entries as a JSON array. PUT writes the request body to a file, creating
+// it if needed, or creates a directory if the path ends in a slash. DELETE
+// removes a file or empty directory, or a whole tree with ?recursive=true.
+// Errors are reported as a JSON object with an "error" field.
+//
+// Large files can be transferred in chunks that survive flaky connections.
+// Downloads resume with Range requests. PUT with ?offset=N writes the body
+// at offset N of the file without truncating it, and responds with the
+// size of the file in the Upload-Offset header; an upload resumes from the
+// size HEAD reports. A chunk is rejected with 409 Conflict if N is past the
+// end of the file, and with 400 Bad Request if it has an X-Content-SHA256
+// header holding the hex SHA-256 hash of the body that doesn't match it.
+// Chunks are at most MaxChunkSize bytes.
+package httpfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// MaxChunkSize is the largest chunk of a resumable upload.
+const MaxChunkSize = 8 << 20
+
+// Middleware wraps a Handler, usually to authenticate requests.
+type Middleware func(http.Handler) http.Handler
+
+// Handler serves a FileSystem over HTTP.
+type Handler struct {
+	fs absfs.FileSystem
+
+	// Perm is the mode of files and directories created by PUT, unless
+	// the request has a mode query parameter.
+	Perm os.FileMode
+}
+
+// Entry describes a directory entry in a JSON directory listing.
+type Entry struct {
+	Name    string      `json:"name"`
+	Size    int64       `json:"size"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"mod_time"`
+	IsDir   bool        `json:"is_dir"`
+}
+
+// NewHandler returns a Handler serving fs, wrapped by middleware in order,
+// so the first middleware sees requests first.
+func NewHandler(fs absfs.FileSystem, middleware ...Middleware) http.Handler {
+	var h http.Handler = &Handler{fs: fs, Perm: 0600}
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := path.Clean("/" + r.URL.Path)
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.get(w, r, name)
+	case http.MethodPut:
+		h.put(w, r, name)
+	case http.MethodDelete:
+		h.delete(w, r, name)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := h.fs.Open(name)
+	if err != nil {
+		writeFSError(w, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		writeFSError(w, err)
+		return
+	}
+	if !info.IsDir() {
+		http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+		return
+	}
+
+	infos, err := f.Readdir(-1)
+	if err != nil && err != io.EOF {
+		writeFSError(w, err)
+		return
+	}
+	entries := make([]Entry, 0, len(infos))
+	for _, info := range infos {
+		entries = append(entries, Entry{
+			Name:    info.Name(),
+			Size:    info.Size(),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime(),
+			IsDir:   info.IsDir(),
+		})
+	}
+	writeJSON(w, http.StatusOK, entries)
+}
+
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, name string) {
+	perm := h.Perm
+	if mode := r.URL.Query().Get("mode"); mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.New("invalid mode"))
+			return
+		}
+		perm = os.FileMode(m).Perm()
+	}
+
+	if strings.HasSuffix(r.URL.Path, "/") {
+		if err := h.fs.MkdirAll(name, perm|0700); err != nil {
+			writeFSError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	if offset := r.URL.Query().Get("offset"); offset != "" {
+		h.putChunk(w, r, name, perm, offset)
+		return
+	}
+
+	status := http.StatusNoContent
+	if _, err := h.fs.Stat(name); os.IsNotExist(err) {
+		status = http.StatusCreated
+	}
+
+	f, err := h.fs.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		writeFSError(w, err)
+		return
+	}
+	_, err = io.Copy(f, r.Body)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		writeFSError(w, err)
+		return
+	}
+	w.WriteHeader(status)
+}
+
+// putChunk writes a chunk of a resumable upload at offset.
+func (h *Handler) putChunk(w http.ResponseWriter, r *http.Request, name string, perm os.FileMode, offset string) {
+	off, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil || off < 0 {
+		writeError(w, http.StatusBadRequest, errors.New("invalid offset"))
+		return
+	}
+	var size int64
+	if info, err := h.fs.Stat(name); err == nil {
+		size = info.Size()
+	} else if !os.IsNotExist(err) {
+		writeFSError(w, err)
+		return
+	}
+	if off > size {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(size, 10))
+		writeError(w, http.StatusConflict, errors.New("offset past end of file"))
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, MaxChunkSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if len(data) > MaxChunkSize {
+		writeError(w, http.StatusRequestEntityTooLarge, errors.New("chunk too large"))
+		return
+	}
+	if want := r.Header.Get("X-Content-SHA256"); want != "" {
+		got := sha256.Sum256(data)
+		if !strings.EqualFold(want, hex.EncodeToString(got[:])) {
+			writeError(w, http.StatusBadRequest, errors.New("checksum mismatch"))
+			return
+		}
+	}
+
+	f, err := h.fs.OpenFile(name, os.O_WRONLY|os.O_CREATE, perm)
+	if err != nil {
+		writeFSError(w, err)
+		return
+	}
+	n, err := f.WriteAt(data, off)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		writeFSError(w, err)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(max(size, off+int64(n)), 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request, name string) {
+	if _, err := h.fs.Lstat(name); err != nil {
+		writeFSError(w, err)
+		return
+	}
+
+	var err error
+	if recursive, _ := strconv.ParseBool(r.URL.Query().Get("recursive")); recursive {
+		err = h.fs.RemoveAll(name)
+	} else {
+		err = h.fs.Remove(name)
+	}
+	if err != nil {
+		writeFSError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeFSError reports an error returned by the FileSystem. Only the
+// underlying error is sent, so paths in *os.PathErrors aren't echoed back
+// to clients.
+func writeFSError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case os.IsNotExist(err):
+		status = http.StatusNotFound
+	case os.IsExist(err):
+		status = http.StatusConflict
+	case os.IsPermission(err):
+		status = http.StatusForbidden
+	}
+
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	}
+	writeError(w, status, err)
+}
@@ -0,0 +1,132 @@
+package httpfs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+func do(t *testing.T, srv *httptest.Server, method, path, body string) (*http.Response, string) {
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp, string(data)
+}
+
+func TestHandler(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(vfs.NewFS(), BearerToken("secret")))
+	defer srv.Close()
+
+	if resp, _ := do(t, srv, http.MethodPut, "/db/", ""); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("mkdir: unexpected status %s", resp.Status)
+	}
+	if resp, _ := do(t, srv, http.MethodPut, "/db/password", "hunter2"); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("put: unexpected status %s", resp.Status)
+	}
+	if resp, _ := do(t, srv, http.MethodPut, "/db/password", "hunter3"); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("put: unexpected status %s", resp.Status)
+	}
+
+	resp, body := do(t, srv, http.MethodGet, "/db/password", "")
+	if resp.StatusCode != http.StatusOK || body != "hunter3" {
+		t.Fatalf("get: unexpected response %s %q", resp.Status, body)
+	}
+
+	resp, body = do(t, srv, http.MethodGet, "/db", "")
+	var entries []Entry
+	if err := json.Unmarshal([]byte(body), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name != "password" || entries[0].Size != 7 {
+		t.Errorf("wrong listing: %+v", entries)
+	}
+
+	if resp, _ := do(t, srv, http.MethodDelete, "/db?recursive=true", ""); resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("delete: unexpected status %s", resp.Status)
+	}
+	if resp, _ := do(t, srv, http.MethodGet, "/db/password", ""); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("get after delete: unexpected status %s", resp.Status)
+	}
+
+	resp, err := http.Get(srv.URL + "/db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unauthenticated request: unexpected status %s", resp.Status)
+	}
+}
+
+func TestResumableUpload(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(vfs.NewFS()))
+	defer srv.Close()
+
+	put := func(offset int, body, hash string) *http.Response {
+		req, err := http.NewRequest(http.MethodPut, srv.URL+"/file?offset="+strconv.Itoa(offset), strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hash != "" {
+			req.Header.Set("X-Content-SHA256", hash)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+	hash := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+
+	if resp := put(0, "hello ", hash("hello ")); resp.StatusCode != http.StatusNoContent || resp.Header.Get("Upload-Offset") != "6" {
+		t.Fatalf("first chunk: unexpected response %s %q", resp.Status, resp.Header.Get("Upload-Offset"))
+	}
+	if resp := put(6, "world", hash("word")); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad checksum: unexpected status %s", resp.Status)
+	}
+	if resp := put(20, "world", ""); resp.StatusCode != http.StatusConflict || resp.Header.Get("Upload-Offset") != "6" {
+		t.Errorf("gap: unexpected response %s %q", resp.Status, resp.Header.Get("Upload-Offset"))
+	}
+	if resp := put(6, "world", hash("world")); resp.StatusCode != http.StatusNoContent {
+		t.Errorf("second chunk: unexpected status %s", resp.Status)
+	}
+
+	if resp, body := do(t, srv, http.MethodGet, "/file", ""); body != "hello world" {
+		t.Errorf("get: unexpected response %s %q", resp.Status, body)
+	}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/file", nil)
+	req.Header.Set("Range", "bytes=6-")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "world" {
+		t.Errorf("ranged get: unexpected response %s %q", resp.Status, body)
+	}
+}
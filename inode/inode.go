@@ -1,16 +1,18 @@
 package inode
 
 import (
-	"errors"
 	"fmt"
 	"os"
 	filepath "path"
 	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
 	"unsafe"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
 )
 
 // An Inode represents the basic metadata of a file.
@@ -29,6 +31,19 @@ type Inode struct {
 	Gid   uint32
 
 	Dir Directory
+
+	opts *Options
+}
+
+// Options are settings shared by the Inodes of a filesystem.
+type Options struct {
+	// Clock returns the time Inodes are stamped with when they are
+	// created, accessed or modified. It defaults to time.Now.
+	Clock func() time.Time
+
+	// FoldCase makes names in directories compare case insensitively.
+	// Entries keep the case they were linked with.
+	FoldCase bool
 }
 
 type DirEntry struct {
@@ -52,15 +67,20 @@ func (d Directory) Less(i, j int) bool { return d[i].Name < d[j].Name }
 type Ino uint64
 
 func (n *Ino) New(mode os.FileMode) *Inode {
-	atomic.AddUint64((*uint64)(unsafe.Pointer(n)), 1)
-	now := time.Now()
-	return &Inode{
-		Ino:   uint64(*n),
-		Atime: now,
-		Mtime: now,
-		Ctime: now,
-		Mode:  mode,
+	return n.NewWithOptions(mode, nil)
+}
+
+// NewWithOptions is New, but the Inode uses opts, which may be nil.
+func (n *Ino) NewWithOptions(mode os.FileMode, opts *Options) *Inode {
+	node := &Inode{
+		Ino:  atomic.AddUint64((*uint64)(unsafe.Pointer(n)), 1),
+		Mode: mode,
+		opts: opts,
 	}
+	now := node.now()
+	node.Atime, node.Mtime, node.Ctime = now, now, now
+
+	return node
 }
 
 func (n *Ino) SubIno() {
@@ -68,7 +88,12 @@ func (n *Ino) SubIno() {
 }
 
 func (n *Ino) NewDir(mode os.FileMode) *Inode {
-	dir := n.New(mode)
+	return n.NewDirWithOptions(mode, nil)
+}
+
+// NewDirWithOptions is NewDir, but the Inode uses opts, which may be nil.
+func (n *Ino) NewDirWithOptions(mode os.FileMode, opts *Options) *Inode {
+	dir := n.NewWithOptions(mode, opts)
 	var err error
 	dir.Mode = os.ModeDir | mode
 	err = dir.Link(".", dir)
@@ -87,7 +112,7 @@ func (n *Ino) NewDir(mode os.FileMode) *Inode {
 func (n *Inode) Link(name string, child *Inode) error {
 	// Return an error if a regular file is used as a link target
 	if !n.IsDir() {
-		return errors.New("not a directory")
+		return absfs.ErrNotDir
 	}
 
 	n.Lock()
@@ -97,7 +122,7 @@ func (n *Inode) Link(name string, child *Inode) error {
 
 	entry := &DirEntry{name, child}
 
-	if x < len(n.Dir) && n.Dir[x].Name == name {
+	if n.at(x, name) {
 		n.linkswapi(x, entry)
 		return nil
 	}
@@ -109,7 +134,7 @@ func (n *Inode) Link(name string, child *Inode) error {
 func (n *Inode) Unlink(name string) error {
 	// It is an error to unlink an Inode that is not a directory
 	if !n.IsDir() {
-		return errors.New("not a directory")
+		return absfs.ErrNotDir
 	}
 
 	n.Lock()
@@ -117,7 +142,7 @@ func (n *Inode) Unlink(name string) error {
 
 	x := n.find(name)
 
-	if x == n.Dir.Len() || n.Dir[x].Name != name {
+	if !n.at(x, name) {
 		return syscall.ENOENT // os.ErrNotExist
 	}
 
@@ -165,6 +190,16 @@ func (n *Inode) Rename(oldpath, newpath string) error {
 
 	var rename string
 	tnode, err := n.Resolve(newpath)
+	if err == nil && tnode == snode {
+		tdir, newname := filepath.Split(newpath)
+		if filepath.Clean(tdir) == dir && p.key(newname) == p.key(name) {
+			// only the case of the name changes, or nothing does
+			p.Lock()
+			p.Dir[p.find(name)].Name = newname
+			p.Unlock()
+			return nil
+		}
+	}
 	if err == nil && tnode.IsDir() {
 		return syscall.EEXIST
 	}
@@ -215,7 +250,7 @@ func (n *Inode) Resolve(path string) (*Inode, error) {
 		return nn, err
 	}
 	x := n.find(name)
-	if x < len(n.Dir) && n.Dir[x].Name == name {
+	if n.at(x, name) {
 		nn := n.Dir[x].Inode
 		if len(trim) == 0 {
 			return nn, nil
@@ -226,11 +261,11 @@ func (n *Inode) Resolve(path string) (*Inode, error) {
 }
 
 func (n *Inode) accessed() {
-	n.Atime = time.Now()
+	n.Atime = n.now()
 }
 
 func (n *Inode) modified() {
-	now := time.Now()
+	now := n.now()
 	n.Atime = now
 	n.Mtime = now
 }
@@ -272,7 +307,41 @@ func (n *Inode) linki(i int, entry *DirEntry) {
 }
 
 func (n *Inode) find(name string) int {
+	key := n.key(name)
 	return sort.Search(len(n.Dir), func(i int) bool {
-		return n.Dir[i].Name >= name
+		return n.key(n.Dir[i].Name) >= key
+	})
+}
+
+// at reports whether the entry at x, as returned by find, is named name.
+func (n *Inode) at(x int, name string) bool {
+	return x < len(n.Dir) && n.key(n.Dir[x].Name) == n.key(name)
+}
+
+// key returns what name is compared as in directories.
+func (n *Inode) key(name string) string {
+	if n.opts != nil && n.opts.FoldCase {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+func (n *Inode) now() time.Time {
+	if n.opts != nil && n.opts.Clock != nil {
+		return n.opts.Clock()
+	}
+	return time.Now()
+}
+
+// Adopt makes node use opts, and sorts its entries by how opts compares
+// names. It's meant for Inodes that weren't created by an Ino, like ones
+// restored from a copy.
+func (opts *Options) Adopt(node *Inode) {
+	node.Lock()
+	defer node.Unlock()
+
+	node.opts = opts
+	sort.SliceStable(node.Dir, func(i, j int) bool {
+		return node.key(node.Dir[i].Name) < node.key(node.Dir[j].Name)
 	})
 }
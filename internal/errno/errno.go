@@ -0,0 +1,49 @@
+// Package errno maps the errors returned by the host's filesystem to the
+// portable errors the VFS returns, so errors from both can be compared the
+// same way on every platform.
+package errno
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// portable holds the portable error of every syscall.Errno that has one,
+// platform specific errnos are added by the errno_*.go files.
+var portable = map[syscall.Errno]error{
+	syscall.EISDIR:    absfs.ErrIsDir,
+	syscall.ENOTEMPTY: absfs.ErrNotEmpty,
+	syscall.EROFS:     absfs.ErrReadOnly,
+	syscall.EDQUOT:    absfs.ErrQuotaExceeded,
+	syscall.EBADF:     absfs.ErrBadFile,
+	syscall.EINVAL:    absfs.ErrInvalid,
+}
+
+// Map returns err with the syscall.Errno it wraps replaced by the portable
+// error of the same meaning. The *os.PathError, *os.LinkError or
+// *os.SyscallError wrapping it is kept. Other errors are returned as they
+// are.
+func Map(err error) error {
+	switch e := err.(type) {
+	case *os.PathError:
+		if mapped := Map(e.Err); mapped != e.Err {
+			return &os.PathError{Op: e.Op, Path: e.Path, Err: mapped}
+		}
+	case *os.LinkError:
+		if mapped := Map(e.Err); mapped != e.Err {
+			return &os.LinkError{Op: e.Op, Old: e.Old, New: e.New, Err: mapped}
+		}
+	case *os.SyscallError:
+		if mapped := Map(e.Err); mapped != e.Err {
+			return &os.SyscallError{Syscall: e.Syscall, Err: mapped}
+		}
+	case syscall.Errno:
+		if mapped, ok := portable[e]; ok {
+			return mapped
+		}
+	}
+
+	return err
+}
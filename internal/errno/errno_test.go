@@ -0,0 +1,55 @@
+package errno
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+func TestMap(t *testing.T) {
+	err := Map(&os.PathError{Op: "remove", Path: "/dir", Err: syscall.ENOTEMPTY})
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != absfs.ErrNotEmpty || perr.Op != "remove" || perr.Path != "/dir" {
+		t.Errorf("mapped to %#v", err)
+	}
+	err = Map(&os.LinkError{Op: "rename", Old: "/a", New: "/b", Err: syscall.EISDIR})
+	if lerr, ok := err.(*os.LinkError); !ok || lerr.Err != absfs.ErrIsDir || lerr.Old != "/a" || lerr.New != "/b" {
+		t.Errorf("mapped to %#v", err)
+	}
+
+	for _, err := range []error{nil, os.ErrClosed, &os.PathError{Op: "open", Path: "/f", Err: syscall.ENOENT}} {
+		if mapped := Map(err); mapped != err {
+			t.Errorf("%v mapped to %v", err, mapped)
+		}
+	}
+}
+
+func TestMapMatchesVFS(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "full", "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	fs := vfs.NewFS()
+	if err := fs.MkdirAll("/full/sub", 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	host := Map(os.Remove(filepath.Join(dir, "full")))
+	virtual := Map(fs.Remove("/full"))
+	if !errors.Is(host, absfs.ErrNotEmpty) || !errors.Is(virtual, absfs.ErrNotEmpty) {
+		t.Errorf("removing non-empty directories: %v, %v", host, virtual)
+	}
+	if host.(*os.PathError).Err != virtual.(*os.PathError).Err {
+		t.Errorf("errors differ: %v, %v", host, virtual)
+	}
+
+	_, host = os.Stat(filepath.Join(dir, "missing", "file"))
+	_, virtual = fs.Stat("/missing/file")
+	if !errors.Is(Map(host), os.ErrNotExist) || !errors.Is(Map(virtual), os.ErrNotExist) {
+		t.Errorf("stat of missing files: %v, %v", host, virtual)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package errno
+
+import (
+	"syscall"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+func init() {
+	// on Windows ENOTDIR is an alias of ERROR_PATH_NOT_FOUND
+	portable[syscall.ENOTDIR] = absfs.ErrNotDir
+}
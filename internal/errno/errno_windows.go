@@ -0,0 +1,31 @@
+//go:build windows
+
+package errno
+
+import (
+	"syscall"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+func init() {
+	for errno, err := range map[syscall.Errno]error{
+		windows.ERROR_PATH_NOT_FOUND:    syscall.ENOENT,
+		windows.ERROR_ALREADY_EXISTS:    syscall.EEXIST,
+		windows.ERROR_FILE_EXISTS:       syscall.EEXIST,
+		windows.ERROR_ACCESS_DENIED:     syscall.EACCES,
+		windows.ERROR_DIRECTORY:         absfs.ErrNotDir,
+		windows.ERROR_DIR_NOT_EMPTY:     absfs.ErrNotEmpty,
+		windows.ERROR_WRITE_PROTECT:     absfs.ErrReadOnly,
+		windows.ERROR_LOCK_VIOLATION:    absfs.ErrLocked,
+		windows.ERROR_SHARING_VIOLATION: absfs.ErrLocked,
+		windows.ERROR_INVALID_HANDLE:    absfs.ErrBadFile,
+		windows.ERROR_INVALID_PARAMETER: absfs.ErrInvalid,
+		windows.ERROR_DISK_FULL:         syscall.ENOSPC,
+		windows.ERROR_HANDLE_DISK_FULL:  syscall.ENOSPC,
+	} {
+		portable[errno] = err
+	}
+}
@@ -0,0 +1,31 @@
+package pandorasbox
+
+import (
+	"io/fs"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// FS returns an fs.FS of the files under the directory root, on the host's
+// filesystem or in a VFS, for use with io/fs and the libraries that accept
+// an fs.FS. fs.Sub can be used on it to get views confined to a
+// subdirectory.
+func (b *Box) FS(root string) fs.FS {
+	if vfsys, vfsRoot, ok, err := b.resolveVFS(root); err != nil {
+		return invalidFS{&fs.PathError{Op: "open", Path: root, Err: err}}
+	} else if ok {
+		return absfs.NewIOFS(vfsys, vfsRoot)
+	}
+
+	return absfs.NewIOFS(b.osfs, root)
+}
+
+// invalidFS is the fs.FS of a root no FileSystem holds, whose files all
+// fail to open with err.
+type invalidFS struct {
+	err error
+}
+
+func (f invalidFS) Open(name string) (fs.File, error) {
+	return nil, f.err
+}
@@ -0,0 +1,68 @@
+package ioutil
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// ctxReader stops reading once its context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// ReadAllContext is like ReadAll, but stops reading and returns the
+// context's error once ctx is done.
+func ReadAllContext(ctx context.Context, r io.Reader) ([]byte, error) {
+	return readAll(&ctxReader{ctx, r}, bytes.MinRead)
+}
+
+// ReadFileContext is like ReadFile, but stops reading and returns the
+// context's error once ctx is done.
+func ReadFileContext(ctx context.Context, fs absfs.FileSystem, filename string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+	f, err := fs.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var n int64
+	if fi, err := f.Stat(); err == nil {
+		if size := fi.Size(); size < 1e9 {
+			n = size
+		}
+	}
+	return readAll(&ctxReader{ctx, f}, n+bytes.MinRead)
+}
+
+// WalkContext is like Walk, but stops walking and returns the context's
+// error once ctx is done.
+func WalkContext(ctx context.Context, fs absfs.FileSystem, root string, walkFn filepath.WalkFunc) error {
+	return Walk(fs, root, WalkFuncContext(ctx, walkFn))
+}
+
+// WalkFuncContext returns a filepath.WalkFunc that calls walkFn until ctx
+// is done, and then returns the context's error, stopping the walk.
+func WalkFuncContext(ctx context.Context, walkFn filepath.WalkFunc) filepath.WalkFunc {
+	return func(path string, info os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return walkFn(path, info, err)
+	}
+}
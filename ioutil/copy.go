@@ -0,0 +1,102 @@
+package ioutil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/inode"
+)
+
+var (
+	errNotRegular = errors.New("not a regular file")
+	errSameFile   = errors.New("source and destination are the same file")
+)
+
+// CopyFile copies the regular file src on srcFS to dst on dstFS, along
+// with its extended attributes where both support them. dst is created
+// with the permissions of src if it doesn't exist.
+func CopyFile(dstFS absfs.FileSystem, dst string, srcFS absfs.FileSystem, src string) error {
+	return copyFile(dstFS, dst, srcFS, src, nil)
+}
+
+// CopyFileProgress is like CopyFile, but calls fn after every chunk copied
+// with the number of bytes copied so far and the size of src. Copying
+// stops with the context's error once ctx is done, leaving dst partially
+// written.
+func CopyFileProgress(ctx context.Context, dstFS absfs.FileSystem, dst string, srcFS absfs.FileSystem, src string, fn func(copied, total int64)) error {
+	return copyFile(dstFS, dst, srcFS, src, func(r io.Reader, size int64) io.Reader {
+		return &progressReader{ctx: ctx, r: r, total: size, fn: fn}
+	})
+}
+
+// copyFile copies src to dst, reading src through the reader returned by
+// wrap if it isn't nil.
+func copyFile(dstFS absfs.FileSystem, dst string, srcFS absfs.FileSystem, src string, wrap func(r io.Reader, size int64) io.Reader) error {
+	info, err := srcFS.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return &os.PathError{Op: "copy", Path: src, Err: errNotRegular}
+	}
+	// truncating dst would destroy src
+	if dstInfo, err := dstFS.Stat(dst); err == nil && dstFS == srcFS && sameFile(info, dstInfo) {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: errSameFile}
+	}
+
+	in, err := srcFS.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := dstFS.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	r := io.Reader(in)
+	if wrap != nil {
+		r = wrap(in, info.Size())
+	}
+	_, err = io.Copy(out, r)
+	if err1 := out.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+
+	return CopyXattrs(dstFS, dst, srcFS, src)
+}
+
+// progressReader reports the number of bytes read from r to fn, and stops
+// reading once ctx is done.
+type progressReader struct {
+	ctx    context.Context
+	r      io.Reader
+	copied int64
+	total  int64
+	fn     func(copied, total int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.copied += int64(n)
+		r.fn(r.copied, r.total)
+	}
+	return n, err
+}
+
+func sameFile(fi1, fi2 os.FileInfo) bool {
+	if n1, ok := fi1.Sys().(*inode.Inode); ok {
+		n2, ok := fi2.Sys().(*inode.Inode)
+		return ok && n1 == n2
+	}
+	return os.SameFile(fi1, fi2)
+}
@@ -0,0 +1,113 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ioutil
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// Glob returns the names of all files on fs matching pattern, or nil if
+// there is no matching file, like filepath.Glob. Patterns on filesystems
+// that separate paths with / are matched with path.Match, and otherwise
+// with filepath.Match. Volume names aren't treated specially.
+func Glob(fs absfs.FileSystem, pattern string) (matches []string, err error) {
+	// check pattern is well-formed
+	if _, err := match(fs, pattern, ""); err != nil {
+		return nil, err
+	}
+	if !hasMeta(pattern) {
+		if _, err = fs.Lstat(pattern); err != nil {
+			return nil, nil
+		}
+		return []string{pattern}, nil
+	}
+
+	dir, file := split(fs, pattern)
+	dir = cleanGlobPath(fs, dir)
+	if !hasMeta(dir) {
+		return globDir(fs, dir, file, nil)
+	}
+
+	// prevent infinite recursion
+	if dir == pattern {
+		return nil, filepath.ErrBadPattern
+	}
+
+	var m []string
+	m, err = Glob(fs, dir)
+	if err != nil {
+		return
+	}
+	for _, d := range m {
+		matches, err = globDir(fs, d, file, matches)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// globDir searches for files matching pattern in the directory dir and
+// appends them to matches. Errors opening or reading the directory are
+// ignored, like filepath.Glob does.
+func globDir(fs absfs.FileSystem, dir, pattern string, matches []string) ([]string, error) {
+	info, err := fs.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return matches, nil
+	}
+	f, err := fs.Open(dir)
+	if err != nil {
+		return matches, nil
+	}
+	names, _ := f.Readdirnames(-1)
+	f.Close()
+	sort.Strings(names)
+
+	for _, n := range names {
+		matched, err := match(fs, pattern, n)
+		if err != nil {
+			return matches, err
+		}
+		if matched {
+			matches = append(matches, join(fs, dir, n))
+		}
+	}
+	return matches, nil
+}
+
+// cleanGlobPath prepares dir for globbing.
+func cleanGlobPath(fs absfs.FileSystem, dir string) string {
+	switch dir {
+	case "":
+		return "."
+	case string(fs.Separator()):
+		return dir
+	default:
+		return dir[:len(dir)-1] // chop off trailing separator
+	}
+}
+
+func match(fs absfs.FileSystem, pattern, name string) (bool, error) {
+	if fs.Separator() == '/' {
+		return path.Match(pattern, name)
+	}
+	return filepath.Match(pattern, name)
+}
+
+func split(fs absfs.FileSystem, p string) (dir, file string) {
+	i := strings.LastIndexByte(p, fs.Separator())
+	return p[:i+1], p[i+1:]
+}
+
+// hasMeta reports whether p contains any of the magic characters
+// recognized by Match.
+func hasMeta(p string) bool {
+	return strings.ContainsAny(p, `*?[\`)
+}
@@ -5,9 +5,15 @@
 package ioutil
 
 import (
+	"context"
+	"errors"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/capnspacehook/pandorasbox/absfs"
@@ -136,3 +142,213 @@ func TestReadDir(t *testing.T) {
 		t.Fatalf("ReadDir %s: ioutil directory not found", dirname)
 	}
 }
+
+func TestContext(t *testing.T) {
+	fs := setup(t)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	if _, err := ReadFileContext(ctx, fs, "ioutil_test.go"); err != nil {
+		t.Fatalf("ReadFileContext: %v", err)
+	}
+
+	var walked int
+	err := WalkContext(ctx, fs, "/", func(path string, info os.FileInfo, err error) error {
+		walked++
+		cancel()
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("WalkContext: expected context.Canceled, got %v", err)
+	}
+	if walked != 1 {
+		t.Errorf("WalkContext: walked %d files after cancel", walked)
+	}
+
+	if _, err := ReadFileContext(ctx, fs, "ioutil_test.go"); !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadFileContext: expected context.Canceled, got %v", err)
+	}
+}
+
+func TestWalkSymlinks(t *testing.T) {
+	fs := vfs.NewFS()
+	if err := fs.Mkdir("/d", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, "/d/f", []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("/d", "/d/loop"); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(fs, "/missing", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("/missing", "/dangling"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/missing"); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err := WalkSymlinks(fs, "/", absfs.FollowSymlinks, func(path string, info os.FileInfo, err error) error {
+		switch {
+		case errors.Is(err, absfs.ErrSymlinkCycle):
+			path += " cycle"
+		case err != nil:
+			return err
+		case info.Mode()&os.ModeSymlink != 0:
+			path += " link"
+		}
+		got = append(got, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/", "/d", "/d/f", "/d/loop cycle", "/dangling link"}
+	if len(got) != len(want) {
+		t.Fatalf("walked %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("walked %v, want %v", got, want)
+		}
+	}
+}
+
+func TestWalkParallel(t *testing.T) {
+	fs := vfs.NewFS()
+	var want []string
+	for _, d := range []string{"/a", "/b", "/c"} {
+		if err := fs.Mkdir(d, 0700); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, d)
+		for _, sub := range []string{"/x", "/y"} {
+			if err := fs.Mkdir(d+sub, 0700); err != nil {
+				t.Fatal(err)
+			}
+			if err := WriteFile(fs, d+sub+"/f", nil, 0600); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, d+sub)
+			if d+sub != "/c/y" {
+				want = append(want, d+sub+"/f")
+			}
+		}
+	}
+	want = append(want, "/")
+
+	var (
+		mtx sync.Mutex
+		got []string
+	)
+	errA, errC := errors.New("a"), errors.New("c")
+	err := WalkParallel(fs, "/", 4, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		mtx.Lock()
+		got = append(got, path)
+		mtx.Unlock()
+		switch path {
+		case "/c/y":
+			return errC
+		case "/a/x/f":
+			return errA
+		}
+		return nil
+	})
+	sort.Strings(got)
+	sort.Strings(want)
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("walked %v, want %v", got, want)
+	}
+	if err == nil || err.Error() != "a\nc" {
+		t.Errorf("expected errors a and c in order, got %v", err)
+	}
+
+	walked := 0
+	err = WalkParallel(fs, "/", 1, func(path string, info os.FileInfo, err error) error {
+		walked++
+		if path == "/a" {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	if err != nil || walked != 2 {
+		t.Errorf("SkipAll: walked %d files, got %v", walked, err)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	fs := vfs.NewFS()
+	for _, dir := range []string{"/a", "/b", "/b/c"} {
+		if err := fs.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"/a/x.key", "/a/y.txt", "/b/z.key", "/b/c/w.key"} {
+		if err := WriteFile(fs, name, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"/*/*.key", []string{"/a/x.key", "/b/z.key"}},
+		{"/a/*", []string{"/a/x.key", "/a/y.txt"}},
+		{"/b/c/w.key", []string{"/b/c/w.key"}},
+		{"/b/[cd]/*", []string{"/b/c/w.key"}},
+		{"/none/*", nil},
+	}
+	for _, tt := range tests {
+		got, err := Glob(fs, tt.pattern)
+		if err != nil {
+			t.Errorf("Glob(%q): %v", tt.pattern, err)
+			continue
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("Glob(%q) = %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+
+	if _, err := Glob(fs, "/a/["); err != path.ErrBadPattern {
+		t.Errorf("expected ErrBadPattern, got %v", err)
+	}
+}
+
+func TestCopyFileProgress(t *testing.T) {
+	fs := vfs.NewFS()
+	data := make([]byte, 100000)
+	if err := WriteFile(fs, "/src", data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var last, calls int64
+	err := CopyFileProgress(context.Background(), fs, "/dst", fs, "/src", func(copied, total int64) {
+		if total != int64(len(data)) || copied < last {
+			t.Errorf("progress %d/%d after %d", copied, total, last)
+		}
+		last = copied
+		calls++
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last != int64(len(data)) || calls == 0 {
+		t.Errorf("copied %d bytes in %d calls", last, calls)
+	}
+	checkSize(t, fs, "/dst", int64(len(data)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = CopyFileProgress(ctx, fs, "/dst2", fs, "/src", func(copied, total int64) {
+		cancel()
+	})
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
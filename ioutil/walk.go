@@ -0,0 +1,179 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package ioutil
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// Walk walks the file tree rooted at root on the absfs.FileSystem fs,
+// calling walkFn for each file or directory in the tree, including root,
+// like filepath.Walk. The files are walked in lexical order, and symbolic
+// links are not followed.
+func Walk(fs absfs.FileSystem, root string, walkFn filepath.WalkFunc) error {
+	info, err := fs.Lstat(root)
+	if err != nil {
+		err = walkFn(root, nil, err)
+	} else {
+		err = walk(fs, root, info, walkFn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// WalkSymlinks is like Walk, but treats symbolic links according to
+// policy.
+func WalkSymlinks(fs absfs.FileSystem, root string, policy absfs.SymlinkPolicy, walkFn filepath.WalkFunc) error {
+	if policy != absfs.FollowSymlinks {
+		return Walk(fs, root, walkFn)
+	}
+
+	info, err := fs.Stat(root)
+	if err != nil {
+		err = walkFn(root, nil, err)
+	} else {
+		err = walkFollow(fs, root, info, nil, walkFn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// walkFollow is walk, but follows symbolic links. parents holds the
+// directories being walked, to detect cycles.
+func walkFollow(fs absfs.FileSystem, path string, info os.FileInfo, parents []os.FileInfo, walkFn filepath.WalkFunc) error {
+	if !info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
+		return walkFn(path, info, nil)
+	}
+	for _, parent := range parents {
+		if sameFile(parent, info) {
+			return walkFn(path, info, &os.PathError{Op: "walk", Path: path, Err: absfs.ErrSymlinkCycle})
+		}
+	}
+
+	names, err := readDirNames(fs, path)
+	err1 := walkFn(path, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+
+	parents = append(parents, info)
+	for _, name := range names {
+		filename := join(fs, path, name)
+		fileInfo, err := fs.Stat(filename)
+		if err != nil {
+			// report links that point nowhere as they are
+			if linkInfo, lerr := fs.Lstat(filename); lerr == nil && linkInfo.Mode()&os.ModeSymlink != 0 {
+				fileInfo, err = linkInfo, nil
+			}
+		}
+		if err != nil {
+			if err := walkFn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+		} else {
+			err = walkFollow(fs, filename, fileInfo, parents, walkFn)
+			if err != nil {
+				if !fileInfo.IsDir() || err != filepath.SkipDir {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func walk(fs absfs.FileSystem, path string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	// links to directories in a VFS have both ModeDir and ModeSymlink set
+	if !info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
+		return walkFn(path, info, nil)
+	}
+
+	if lister, ok := fs.(absfs.ReadDirPlusser); ok {
+		return walkPlus(fs, lister, path, info, walkFn)
+	}
+
+	names, err := readDirNames(fs, path)
+	err1 := walkFn(path, info, err)
+	// If err != nil, walk can't walk into this directory.
+	// err1 != nil means walkFn want walk to skip this directory or stop walking.
+	// Therefore, if one of err and err1 isn't nil, walk will return.
+	if err != nil || err1 != nil {
+		// The caller's behavior is controlled by the return value, which is decided
+		// by walkFn. walkFn may ignore err and return nil.
+		// If walkFn returns SkipDir, it will be handled by the caller.
+		// So walk should return whatever walkFn returns.
+		return err1
+	}
+
+	for _, name := range names {
+		filename := join(fs, path, name)
+		fileInfo, err := fs.Lstat(filename)
+		if err != nil {
+			if err := walkFn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+		} else {
+			err = walk(fs, filename, fileInfo, walkFn)
+			if err != nil {
+				if !fileInfo.IsDir() || err != filepath.SkipDir {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// walkPlus is walk for FileSystems that list directories together with the
+// information of their entries, so entries don't need to be stat'd.
+func walkPlus(fs absfs.FileSystem, lister absfs.ReadDirPlusser, path string, info os.FileInfo, walkFn filepath.WalkFunc) error {
+	infos, err := lister.ReadDirPlus(path)
+	err1 := walkFn(path, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+
+	for _, fileInfo := range infos {
+		filename := join(fs, path, fileInfo.Name())
+		err = walk(fs, filename, fileInfo, walkFn)
+		if err != nil {
+			if !fileInfo.IsDir() || err != filepath.SkipDir {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readDirNames reads the directory named by dirname and returns
+// a sorted list of directory entries.
+func readDirNames(fs absfs.FileSystem, dirname string) ([]string, error) {
+	f, err := fs.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func join(fs absfs.FileSystem, dir, name string) string {
+	if len(dir) > 0 && dir[len(dir)-1] == fs.Separator() {
+		return dir + name
+	}
+	return dir + string(fs.Separator()) + name
+}
@@ -0,0 +1,145 @@
+package ioutil
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// WalkParallel walks the file tree rooted at root like Walk, but walks
+// subdirectories concurrently using at most workers goroutines, or
+// GOMAXPROCS goroutines if workers isn't positive. fn may be called
+// concurrently, and the files in different directories aren't walked in
+// any particular order. Symbolic links are not followed.
+//
+// An error returned by fn doesn't stop the walk: directories whose walk
+// fails aren't walked into, but the rest of the tree is. Returning
+// filepath.SkipDir skips a directory, and filepath.SkipAll stops the walk.
+// The errors are returned sorted by the path they were returned for, so
+// the result doesn't depend on the order files were walked in.
+func WalkParallel(fs absfs.FileSystem, root string, workers int, fn filepath.WalkFunc) error {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	w := &parallelWalker{
+		fs:  fs,
+		fn:  fn,
+		sem: make(chan struct{}, workers-1),
+	}
+
+	info, err := fs.Lstat(root)
+	if err != nil {
+		w.call(root, nil, err)
+	} else {
+		w.walk(root, info)
+	}
+	w.wg.Wait()
+
+	return w.err()
+}
+
+type parallelWalker struct {
+	fs  absfs.FileSystem
+	fn  filepath.WalkFunc
+	sem chan struct{}
+	wg  sync.WaitGroup
+
+	stop atomic.Bool
+	mtx  sync.Mutex
+	errs []pathError
+}
+
+type pathError struct {
+	path string
+	err  error
+}
+
+// call calls fn, recording the error it returns. It reports whether the
+// walk should continue into path.
+func (w *parallelWalker) call(path string, info os.FileInfo, err error) bool {
+	if w.stop.Load() {
+		return false
+	}
+	err = w.fn(path, info, err)
+	switch err {
+	case nil:
+		return true
+	case filepath.SkipDir:
+	case filepath.SkipAll:
+		w.stop.Store(true)
+	default:
+		w.mtx.Lock()
+		w.errs = append(w.errs, pathError{path: path, err: err})
+		w.mtx.Unlock()
+	}
+	return false
+}
+
+func (w *parallelWalker) walk(path string, info os.FileInfo) {
+	// links to directories in a VFS have both ModeDir and ModeSymlink set
+	if !info.IsDir() || info.Mode()&os.ModeSymlink != 0 {
+		w.call(path, info, nil)
+		return
+	}
+
+	names, err := readDirNames(w.fs, path)
+	if !w.call(path, info, err) || err != nil {
+		return
+	}
+
+	for _, name := range names {
+		if w.stop.Load() {
+			return
+		}
+		filename := join(w.fs, path, name)
+		fileInfo, err := w.fs.Lstat(filename)
+		if err != nil {
+			w.call(filename, fileInfo, err)
+			continue
+		}
+		if !fileInfo.IsDir() || fileInfo.Mode()&os.ModeSymlink != 0 {
+			w.call(filename, fileInfo, nil)
+			continue
+		}
+
+		// walk the subdirectory in another goroutine if a worker is free,
+		// otherwise in this one
+		select {
+		case w.sem <- struct{}{}:
+			w.wg.Add(1)
+			go func() {
+				defer func() {
+					<-w.sem
+					w.wg.Done()
+				}()
+				w.walk(filename, fileInfo)
+			}()
+		default:
+			w.walk(filename, fileInfo)
+		}
+	}
+}
+
+// err returns the recorded errors sorted by path, joined if there is more
+// than one.
+func (w *parallelWalker) err() error {
+	switch len(w.errs) {
+	case 0:
+		return nil
+	case 1:
+		return w.errs[0].err
+	}
+
+	sort.SliceStable(w.errs, func(i, j int) bool { return w.errs[i].path < w.errs[j].path })
+	errs := make([]error, len(w.errs))
+	for i := range w.errs {
+		errs[i] = w.errs[i].err
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,68 @@
+package ioutil
+
+import (
+	"errors"
+	"syscall"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// ReadXattrs returns the extended attributes of the named file on fs. If
+// fs or the file's filesystem doesn't support extended attributes, it
+// returns no attributes and no error.
+func ReadXattrs(fs absfs.FileSystem, name string) (map[string][]byte, error) {
+	x, ok := fs.(absfs.Xattrer)
+	if !ok || !absfs.Capabilities(fs).Has(absfs.CapXattr) {
+		return nil, nil
+	}
+
+	names, err := x.Listxattr(name)
+	if errors.Is(err, syscall.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	attrs := make(map[string][]byte, len(names))
+	for _, attr := range names {
+		value, err := x.Getxattr(name, attr)
+		if err != nil {
+			return nil, err
+		}
+		attrs[attr] = value
+	}
+
+	return attrs, nil
+}
+
+// WriteXattrs sets the extended attributes attrs on the named file on fs.
+// Like ReadXattrs, it does nothing if extended attributes aren't supported.
+func WriteXattrs(fs absfs.FileSystem, name string, attrs map[string][]byte) error {
+	x, ok := fs.(absfs.Xattrer)
+	if len(attrs) == 0 || !ok || !absfs.Capabilities(fs).Has(absfs.CapXattr) {
+		return nil
+	}
+
+	for attr, value := range attrs {
+		err := x.Setxattr(name, attr, value)
+		if errors.Is(err, syscall.ENOTSUP) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CopyXattrs copies the extended attributes of srcName on src to dstName on
+// dst, where both support them.
+func CopyXattrs(dst absfs.FileSystem, dstName string, src absfs.FileSystem, srcName string) error {
+	attrs, err := ReadXattrs(src, srcName)
+	if err != nil {
+		return err
+	}
+
+	return WriteXattrs(dst, dstName, attrs)
+}
@@ -0,0 +1,134 @@
+package pandorasbox
+
+import (
+	"context"
+	"errors"
+
+	"github.com/awnumar/memguard"
+	"github.com/capnspacehook/pandorasbox/seal"
+	"golang.org/x/crypto/scrypt"
+)
+
+// ErrWrongKey is returned by KeyProviders whose key couldn't be unwrapped,
+// usually because the passphrase or key encryption key is wrong.
+var ErrWrongKey = errors.New("key can't be unwrapped with the given key")
+
+// A KeyProvider acquires a master key, like the key backups are encrypted
+// under, from wherever it's kept. Key may be called more than once, and
+// every call returns the same key.
+type KeyProvider interface {
+	Key(ctx context.Context) (*memguard.Enclave, error)
+}
+
+// KeyProviderFunc is a function that is a KeyProvider.
+type KeyProviderFunc func(ctx context.Context) (*memguard.Enclave, error)
+
+func (f KeyProviderFunc) Key(ctx context.Context) (*memguard.Enclave, error) {
+	return f(ctx)
+}
+
+// StaticKey returns a KeyProvider that always returns key.
+func StaticKey(key *memguard.Enclave) KeyProvider {
+	return KeyProviderFunc(func(context.Context) (*memguard.Enclave, error) {
+		return key, nil
+	})
+}
+
+// PassphraseKey derives a key from a passphrase with scrypt.
+type PassphraseKey struct {
+	// Passphrase returns the passphrase, which is wiped once the key is
+	// derived.
+	Passphrase func() ([]byte, error)
+
+	// Salt must be random, and kept with what the key encrypts.
+	Salt []byte
+
+	// N, R and P are the scrypt parameters. They default to 1<<15, 8 and
+	// 1.
+	N, R, P int
+}
+
+func (pk *PassphraseKey) Key(ctx context.Context) (*memguard.Enclave, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	pass, err := pk.Passphrase()
+	if err != nil {
+		return nil, err
+	}
+	defer seal.Wipe(pass)
+
+	n, r, p := pk.N, pk.R, pk.P
+	if n == 0 {
+		n = 1 << 15
+	}
+	if r == 0 {
+		r = 8
+	}
+	if p == 0 {
+		p = 1
+	}
+	key, err := scrypt.Key(pass, pk.Salt, n, r, p, seal.KeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	return memguard.NewBufferFromBytes(key).Seal(), nil
+}
+
+// An Unwrapper decrypts a key that was encrypted, or wrapped, by a key
+// kept elsewhere, like in a remote KMS, a TPM or an HSM.
+type Unwrapper interface {
+	Unwrap(ctx context.Context, wrapped []byte) (*memguard.Enclave, error)
+}
+
+// UnwrapperFunc is a function that is an Unwrapper.
+type UnwrapperFunc func(ctx context.Context, wrapped []byte) (*memguard.Enclave, error)
+
+func (f UnwrapperFunc) Unwrap(ctx context.Context, wrapped []byte) (*memguard.Enclave, error) {
+	return f(ctx, wrapped)
+}
+
+// WrappedKey is a key kept wrapped by Unwrapper, which unwraps it when
+// it's needed, so the master key is only ever stored encrypted.
+type WrappedKey struct {
+	Wrapped   []byte
+	Unwrapper Unwrapper
+}
+
+func (wk *WrappedKey) Key(ctx context.Context) (*memguard.Enclave, error) {
+	return wk.Unwrapper.Unwrap(ctx, wk.Wrapped)
+}
+
+// KEKUnwrapper returns an Unwrapper for keys wrapped with WrapKey under the
+// key encryption key kek provides, like a key derived from a passphrase.
+// Keys that can't be unwrapped fail with ErrWrongKey.
+func KEKUnwrapper(kek KeyProvider) Unwrapper {
+	return UnwrapperFunc(func(ctx context.Context, wrapped []byte) (*memguard.Enclave, error) {
+		k, err := kek.Key(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(wrapped) < seal.Overhead {
+			return nil, ErrWrongKey
+		}
+		key := memguard.NewBuffer(int(seal.Size(wrapped)))
+		if err := seal.Decrypt(wrapped, k, key.Bytes()); err != nil {
+			key.Destroy()
+			return nil, ErrWrongKey
+		}
+
+		return key.Seal(), nil
+	})
+}
+
+// WrapKey encrypts key under kek, for KEKUnwrapper to unwrap.
+func WrapKey(key, kek *memguard.Enclave) ([]byte, error) {
+	k, err := key.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer k.Destroy()
+
+	return seal.Encrypt(k.Bytes(), kek)
+}
@@ -0,0 +1,112 @@
+package pandorasbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/awnumar/memguard"
+)
+
+func testPassphraseKey(pass string) *PassphraseKey {
+	return &PassphraseKey{
+		Passphrase: func() ([]byte, error) {
+			return []byte(pass), nil
+		},
+		Salt: []byte("0123456789abcdef"),
+		N:    1 << 10,
+	}
+}
+
+func openKey(t *testing.T, key *memguard.Enclave) []byte {
+	t.Helper()
+	b, err := key.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Destroy()
+
+	return append([]byte(nil), b.Bytes()...)
+}
+
+func TestPassphraseKey(t *testing.T) {
+	ctx := context.Background()
+
+	var pass []byte
+	pk := testPassphraseKey("")
+	pk.Passphrase = func() ([]byte, error) {
+		pass = []byte("correct horse")
+		return pass, nil
+	}
+	k1, err := pk.Key(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(pass, make([]byte, len(pass))) {
+		t.Error("passphrase wasn't wiped")
+	}
+	k2, err := testPassphraseKey("correct horse").Key(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(openKey(t, k1), openKey(t, k2)) {
+		t.Error("the same passphrase derived different keys")
+	}
+	k3, err := testPassphraseKey("battery staple").Key(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(openKey(t, k1), openKey(t, k3)) {
+		t.Error("different passphrases derived the same key")
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := pk.Key(cancelled); !errors.Is(err, context.Canceled) {
+		t.Errorf("deriving with a cancelled context: got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestWrappedKey(t *testing.T) {
+	ctx := context.Background()
+	master := memguard.NewBufferRandom(32).Seal()
+	kek, err := testPassphraseKey("correct horse").Key(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrapped, err := WrapKey(master, kek)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(wrapped, openKey(t, master)) {
+		t.Fatal("the wrapped key contains the key")
+	}
+
+	wk := &WrappedKey{Wrapped: wrapped, Unwrapper: KEKUnwrapper(StaticKey(kek))}
+	got, err := wk.Key(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(openKey(t, got), openKey(t, master)) {
+		t.Error("the unwrapped key differs from the wrapped one")
+	}
+
+	wk.Unwrapper = KEKUnwrapper(testPassphraseKey("battery staple"))
+	if _, err := wk.Key(ctx); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("unwrapping with the wrong passphrase: got %v, want %v", err, ErrWrongKey)
+	}
+
+	wk.Unwrapper = KEKUnwrapper(StaticKey(kek))
+	tampered := append([]byte(nil), wrapped...)
+	tampered[len(tampered)-1] ^= 1
+	wk.Wrapped = tampered
+	if _, err := wk.Key(ctx); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("unwrapping a tampered key: got %v, want %v", err, ErrWrongKey)
+	}
+	wk.Wrapped = wrapped[:4]
+	if _, err := wk.Key(ctx); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("unwrapping a truncated key: got %v, want %v", err, ErrWrongKey)
+	}
+}
@@ -0,0 +1,49 @@
+package pandorasbox
+
+import (
+	"context"
+
+	"github.com/awnumar/memguard"
+	"golang.org/x/sys/unix"
+)
+
+// KeyringKey is a key kept in the user keyring of the Linux kernel as a key
+// of type user, so it never has to be written to disk. It can be added
+// with StoreKeyringKey, or with keyctl padd user <Description> @u.
+type KeyringKey struct {
+	Description string
+}
+
+func (kk *KeyringKey) Key(ctx context.Context) (*memguard.Enclave, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	id, err := unix.KeyctlSearch(unix.KEY_SPEC_USER_KEYRING, "user", kk.Description, 0)
+	if err != nil {
+		return nil, err
+	}
+	// user keys hold at most 32767 bytes
+	buf := memguard.NewBuffer(32767)
+	n, err := unix.KeyctlBuffer(unix.KEYCTL_READ, id, buf.Bytes(), 0)
+	if err != nil {
+		buf.Destroy()
+		return nil, err
+	}
+	key := memguard.NewBufferFromBytes(buf.Bytes()[:n])
+	buf.Destroy()
+
+	return key.Seal(), nil
+}
+
+// StoreKeyringKey adds key to the user keyring of the Linux kernel under
+// description, replacing the key already stored there.
+func StoreKeyringKey(description string, key *memguard.Enclave) error {
+	k, err := key.Open()
+	if err != nil {
+		return err
+	}
+	defer k.Destroy()
+
+	_, err = unix.AddKey("user", description, k.Bytes(), unix.KEY_SPEC_USER_KEYRING)
+	return err
+}
@@ -0,0 +1,26 @@
+//go:build !linux
+
+package pandorasbox
+
+import (
+	"context"
+
+	"github.com/awnumar/memguard"
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// KeyringKey is a key kept in the user keyring of the Linux kernel. It's
+// only supported on Linux.
+type KeyringKey struct {
+	Description string
+}
+
+func (kk *KeyringKey) Key(ctx context.Context) (*memguard.Enclave, error) {
+	return nil, absfs.ErrNotImplemented
+}
+
+// StoreKeyringKey adds key to the user keyring of the Linux kernel. It's
+// only supported on Linux.
+func StoreKeyringKey(description string, key *memguard.Enclave) error {
+	return absfs.ErrNotImplemented
+}
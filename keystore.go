@@ -0,0 +1,226 @@
+package pandorasbox
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/awnumar/memguard"
+	"github.com/capnspacehook/pandorasbox/seal"
+	"golang.org/x/crypto/ssh"
+)
+
+// KeyType is the algorithm and size of a key generated by a Keystore.
+type KeyType int
+
+const (
+	KeyEd25519 KeyType = iota
+	KeyECDSAP256
+	KeyECDSAP384
+	KeyRSA2048
+	KeyRSA4096
+)
+
+// ErrBadKeyName is returned for key names that are empty, contain a slash
+// or a question mark, or are . or ..
+var ErrBadKeyName = errors.New("invalid key name")
+
+// Keystore is a store of private keys, kept as PEM encoded PKCS #8 files
+// named after the keys in a directory of a Box. Keys are handed out as
+// crypto.Signers that keep them sealed in Enclaves, and only open them for
+// as long as it takes to sign.
+type Keystore struct {
+	b   *Box
+	dir string
+}
+
+// Keystore returns the keys kept in the directory dir, which is usually a
+// VFS path.
+func (b *Box) Keystore(dir string) *Keystore {
+	return &Keystore{b: b, dir: dir}
+}
+
+// path returns the Box path of the key name.
+func (ks *Keystore) path(op, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/?") {
+		return "", &os.PathError{Op: op, Path: name, Err: ErrBadKeyName}
+	}
+
+	return strings.TrimSuffix(ks.dir, "/") + "/" + name, nil
+}
+
+// Generate generates a key of type typ and stores it as name, replacing the
+// key already stored under name.
+func (ks *Keystore) Generate(name string, typ KeyType) (crypto.Signer, error) {
+	if _, err := ks.path("generate", name); err != nil {
+		return nil, err
+	}
+
+	var key crypto.Signer
+	var err error
+	switch typ {
+	case KeyEd25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case KeyECDSAP256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyECDSAP384:
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyRSA2048:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case KeyRSA4096:
+		key, err = rsa.GenerateKey(rand.Reader, 4096)
+	default:
+		err = os.ErrInvalid
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "generate", Path: name, Err: err}
+	}
+	defer wipePrivateKey(key)
+
+	return ks.put("generate", name, key)
+}
+
+// Import stores key, which must be an RSA, ECDSA or Ed25519 private key, as
+// name, replacing the key already stored under name. key is left as it is,
+// so callers should drop it once it's imported.
+func (ks *Keystore) Import(name string, key crypto.PrivateKey) (crypto.Signer, error) {
+	return ks.put("import", name, key)
+}
+
+// ImportPEM stores the first PEM encoded PKCS #1, PKCS #8 or SEC 1 private
+// key in data as name, replacing the key already stored under name.
+func (ks *Keystore) ImportPEM(name string, data []byte) (crypto.Signer, error) {
+	key, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, &os.PathError{Op: "import", Path: name, Err: err}
+	}
+	defer wipePrivateKey(key)
+
+	return ks.put("import", name, key)
+}
+
+func (ks *Keystore) put(op, name string, key crypto.PrivateKey) (crypto.Signer, error) {
+	p, err := ks.path(op, name)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, &os.PathError{Op: op, Path: name, Err: ErrNoPrivateKey}
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: err}
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	defer seal.Wipe(data)
+	sealed := &sealedKey{
+		der: memguard.NewBufferFromBytes(der).Seal(),
+		pub: signer.Public(),
+	}
+
+	if err := ks.b.MkdirAll(ks.dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := ks.b.WriteFile(p, data, 0600); err != nil {
+		return nil, err
+	}
+
+	return sealed, nil
+}
+
+// Signer returns the key name as a crypto.Signer, which is also a
+// crypto.Decrypter for RSA keys. The key is kept sealed, and only opened
+// for as long as it takes to sign or decrypt.
+func (ks *Keystore) Signer(name string) (crypto.Signer, error) {
+	p, err := ks.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ks.b.ReadFile(p)
+	defer seal.Wipe(data)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePrivateKey(data)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	defer wipePrivateKey(key)
+
+	return sealPrivateKey(key.(crypto.Signer))
+}
+
+// SSHSigner returns the key name as an ssh.Signer, which keeps it sealed
+// like Signer.
+func (ks *Keystore) SSHSigner(name string) (ssh.Signer, error) {
+	signer, err := ks.Signer(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.NewSignerFromSigner(signer)
+}
+
+// PublicKey returns the public key of the key name.
+func (ks *Keystore) PublicKey(name string) (crypto.PublicKey, error) {
+	signer, err := ks.Signer(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return signer.Public(), nil
+}
+
+// Delete removes the key name.
+func (ks *Keystore) Delete(name string) error {
+	p, err := ks.path("delete", name)
+	if err != nil {
+		return err
+	}
+
+	return ks.b.Remove(p)
+}
+
+// List returns the names of the keys stored, sorted.
+func (ks *Keystore) List() ([]string, error) {
+	infos, err := ks.b.ReadDir(ks.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// sealPrivateKey returns key sealed in a sealedKey. key is left as it is.
+func sealPrivateKey(key crypto.Signer) (*sealedKey, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sealedKey{
+		der: memguard.NewBufferFromBytes(der).Seal(),
+		pub: key.Public(),
+	}, nil
+}
@@ -0,0 +1,162 @@
+package pandorasbox
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestKeystore(t *testing.T) {
+	ks := NewBox().Keystore("vfs://keys")
+
+	edKey, err := ks.Generate("ed", KeyEd25519)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := edKey.(ed25519.PrivateKey); ok {
+		t.Error("the generated key isn't sealed")
+	}
+	ecKey, err := ks.Generate("ec", KeyECDSAP256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := ks.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"ec", "ed"}) {
+		t.Errorf("List = %v, want [ec ed]", names)
+	}
+
+	msg := []byte("message")
+	signer, err := ks.Signer("ed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(signer.Public(), edKey.Public()) {
+		t.Error("the stored key differs from the generated one")
+	}
+	sig, err := signer.Sign(rand.Reader, msg, crypto.Hash(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(edKey.Public().(ed25519.PublicKey), msg, sig) {
+		t.Error("the Ed25519 signature doesn't verify")
+	}
+
+	pub, err := ks.PublicKey("ec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pub.(*ecdsa.PublicKey).Equal(ecKey.Public()) {
+		t.Error("PublicKey differs from the generated key")
+	}
+	sshSigner, err := ks.SSHSigner("ec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshSig, err := sshSigner.Sign(rand.Reader, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sshSigner.PublicKey().Verify(msg, sshSig); err != nil {
+		t.Errorf("the SSH signature doesn't verify: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sshSigner.PublicKey().Marshal(), sshPub.Marshal()) {
+		t.Error("the SSH public key differs from the stored key")
+	}
+
+	if err := ks.Delete("ed"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Signer("ed"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("opening a deleted key: got %v, want %v", err, os.ErrNotExist)
+	}
+	if _, err := ks.Generate("a/b", KeyEd25519); !errors.Is(err, ErrBadKeyName) {
+		t.Errorf("generating a key with a slash in its name: got %v, want %v", err, ErrBadKeyName)
+	}
+	if _, err := ks.Generate("x", KeyType(-1)); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("generating an unknown key type: got %v, want %v", err, os.ErrInvalid)
+	}
+}
+
+func TestKeystoreImport(t *testing.T) {
+	ks := NewBox().Keystore("vfs://keys")
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if _, err := ks.ImportPEM("rsa", data); err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ks.Signer("rsa")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256([]byte("message"))
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], sig); err != nil {
+		t.Errorf("the RSA signature doesn't verify: %v", err)
+	}
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, &key.PublicKey, []byte("secret"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := signer.(crypto.Decrypter).Decrypt(rand.Reader, ciphertext, &rsa.OAEPOptions{Hash: crypto.SHA256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "secret" {
+		t.Errorf("Decrypt = %q, want %q", plaintext, "secret")
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := append(ed25519.PrivateKey(nil), edKey...)
+	if _, err := ks.Import("ed", edKey); err != nil {
+		t.Fatal(err)
+	}
+	if !edKey.Equal(orig) {
+		t.Error("Import changed the imported key")
+	}
+	edSigner, err := ks.Signer("ed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := edSigner.(crypto.Decrypter); !ok {
+		t.Fatal("sealed keys should be Decrypters")
+	}
+	if _, err := edSigner.(crypto.Decrypter).Decrypt(rand.Reader, ciphertext, nil); err == nil {
+		t.Error("decrypting with an Ed25519 key succeeded")
+	}
+
+	if _, err := ks.ImportPEM("bad", []byte("not a key")); !errors.Is(err, ErrNoPrivateKey) {
+		t.Errorf("importing garbage: got %v, want %v", err, ErrNoPrivateKey)
+	}
+	if _, err := ks.Import("bad", "not a key"); !errors.Is(err, ErrNoPrivateKey) {
+		t.Errorf("importing a non-key: got %v, want %v", err, ErrNoPrivateKey)
+	}
+}
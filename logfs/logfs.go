@@ -0,0 +1,171 @@
+// Package logfs provides an absfs middleware that logs every operation
+// performed on a FileSystem with log/slog.
+package logfs
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// Redacted replaces path components matched by Options.RedactPatterns.
+const Redacted = "[REDACTED]"
+
+type Options struct {
+	// Level is the level successful operations are logged at. Failed
+	// operations are always logged at slog.LevelError.
+	Level slog.Level
+
+	// RedactPatterns are path.Match patterns matched against every
+	// component of a logged path. Matching components are replaced with
+	// Redacted.
+	RedactPatterns []string
+
+	// Redact, if set, is called with every path after RedactPatterns have
+	// been applied and returns the path that will be logged.
+	Redact func(path string) string
+}
+
+// New returns fs with every operation logged to logger.
+func New(fs absfs.FileSystem, logger *slog.Logger, opts *Options) absfs.FileSystem {
+	return absfs.Chain(fs, Middleware(logger, opts))
+}
+
+// Middleware returns an absfs.Middleware logging to logger, for use with
+// absfs.Chain alongside other middleware. If opts is nil, successful
+// operations are logged at slog.LevelInfo and no paths are redacted.
+func Middleware(logger *slog.Logger, opts *Options) absfs.Middleware {
+	if opts == nil {
+		opts = new(Options)
+	}
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &middleware{logger: logger, opts: *opts}
+}
+
+type middleware struct {
+	logger *slog.Logger
+	opts   Options
+}
+
+func (l *middleware) Before(c *absfs.Call) error {
+	return nil
+}
+
+func (l *middleware) After(c *absfs.Call, err error) error {
+	level := l.opts.Level
+	if err != nil && err != io.EOF {
+		level = slog.LevelError
+	}
+
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, level) {
+		return err
+	}
+
+	attrs := []slog.Attr{
+		slog.String("op", string(c.Op)),
+		slog.String("path", l.redact(c.Path)),
+	}
+	if c.NewPath != "" {
+		attrs = append(attrs, slog.String("new_path", l.redact(c.NewPath)))
+	}
+	switch c.Op {
+	case absfs.OpOpen:
+		attrs = append(attrs, slog.String("flags", absfs.Flags(c.Flag).String()))
+		if c.Flag&absfs.O_CREATE != 0 {
+			attrs = append(attrs, slog.String("perm", c.Perm.String()))
+		}
+	case absfs.OpMkdir, absfs.OpMkdirAll, absfs.OpChmod:
+		attrs = append(attrs, slog.String("perm", c.Perm.String()))
+	case absfs.OpRead, absfs.OpWrite:
+		attrs = append(attrs, slog.Int64("size", c.Size), slog.Int64("n", c.N))
+		if c.Offset >= 0 {
+			attrs = append(attrs, slog.Int64("offset", c.Offset))
+		}
+	case absfs.OpTruncate, absfs.OpFtruncate:
+		attrs = append(attrs, slog.Int64("size", c.Size))
+	case absfs.OpGetxattr, absfs.OpRemovexattr:
+		attrs = append(attrs, slog.String("attr", c.Attr))
+	case absfs.OpSetxattr:
+		attrs = append(attrs, slog.String("attr", c.Attr), slog.Int64("size", c.Size))
+	}
+	attrs = append(attrs, slog.Duration("duration", time.Since(c.Start)))
+	if err != nil && err != io.EOF {
+		attrs = append(attrs, slog.String("error", l.redactError(c, err)))
+	}
+
+	l.logger.LogAttrs(ctx, level, string(c.Op), attrs...)
+
+	return err
+}
+
+func (l *middleware) redact(name string) string {
+	if name == "" {
+		return name
+	}
+
+	if len(l.opts.RedactPatterns) > 0 {
+		var b strings.Builder
+		for len(name) > 0 {
+			i := strings.IndexAny(name, `/\`)
+			if i == -1 {
+				i = len(name)
+			}
+			b.WriteString(l.redactComponent(name[:i]))
+			if i < len(name) {
+				b.WriteByte(name[i])
+				i++
+			}
+			name = name[i:]
+		}
+		name = b.String()
+	}
+	if l.opts.Redact != nil {
+		name = l.opts.Redact(name)
+	}
+
+	return name
+}
+
+// redactError keeps paths embedded in error messages from bypassing
+// redaction.
+func (l *middleware) redactError(c *absfs.Call, err error) string {
+	switch e := err.(type) {
+	case *os.PathError:
+		return e.Op + " " + l.redact(e.Path) + ": " + e.Err.Error()
+	case *os.LinkError:
+		return e.Op + " " + l.redact(e.Old) + " " + l.redact(e.New) + ": " + e.Err.Error()
+	}
+
+	msg := err.Error()
+	if c.NewPath != "" {
+		msg = strings.Replace(msg, c.NewPath, l.redact(c.NewPath), -1)
+	}
+	if c.Path != "" {
+		msg = strings.Replace(msg, c.Path, l.redact(c.Path), -1)
+	}
+
+	return msg
+}
+
+func (l *middleware) redactComponent(name string) string {
+	if name == "" {
+		return name
+	}
+	for _, pattern := range l.opts.RedactPatterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return Redacted
+		}
+	}
+
+	return name
+}
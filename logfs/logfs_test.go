@@ -0,0 +1,74 @@
+package logfs
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+func decode(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		record := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	fs := New(vfs.NewFS(), logger, nil)
+
+	f, err := fs.OpenFile("/file", os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("data"))
+	f.Close()
+	fs.Remove("/no-such-file")
+
+	records := decode(t, &buf)
+	if len(records) != 4 {
+		t.Fatalf("wrong record count: %d, expected %d", len(records), 4)
+	}
+
+	if records[0]["op"] != "open" || records[0]["flags"] != "O_WRONLY|O_CREATE" {
+		t.Errorf("bad open record: %v", records[0])
+	}
+	if records[1]["op"] != "write" || records[1]["n"] != float64(4) {
+		t.Errorf("bad write record: %v", records[1])
+	}
+	if _, ok := records[1]["duration"]; !ok {
+		t.Errorf("write record missing duration: %v", records[1])
+	}
+	if records[3]["level"] != "ERROR" || records[3]["error"] == nil {
+		t.Errorf("bad remove record: %v", records[3])
+	}
+}
+
+func TestRedact(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	fs := New(vfs.NewFS(), logger, &Options{RedactPatterns: []string{"alice", "*.key"}})
+
+	fs.Stat("/home/alice/tls/server.key")
+
+	records := decode(t, &buf)
+	expected := "/home/" + Redacted + "/tls/" + Redacted
+	if records[0]["path"] != expected {
+		t.Errorf("path not redacted: %q, expected %q", records[0]["path"], expected)
+	}
+	if msg, _ := records[0]["error"].(string); strings.Contains(msg, "alice") {
+		t.Errorf("error not redacted: %q", msg)
+	}
+}
@@ -0,0 +1,101 @@
+package pandorasbox
+
+import (
+	"encoding/hex"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// manifestVersion is the version of the format of Manifests.
+const manifestVersion = 1
+
+// A Manifest lists the files of a tree without their contents, for
+// inventories and compliance reports. It's meant to be encoded with
+// encoding/json, which encodes the same Manifest to the same bytes.
+type Manifest struct {
+	Version int             `json:"version"`
+	Created time.Time       `json:"created"`
+	Root    string          `json:"root"`
+	Entries []ManifestEntry `json:"entries"`
+}
+
+// A ManifestEntry describes a file in a Manifest. Path is relative to the
+// root of the Manifest and uses forward slashes. SHA256 is the hex SHA-256
+// hash of the contents of regular files, and Target the target of
+// symbolic links.
+type ManifestEntry struct {
+	Path    string            `json:"path"`
+	Size    int64             `json:"size"`
+	Mode    os.FileMode       `json:"mode"`
+	ModTime time.Time         `json:"mod_time"`
+	SHA256  string            `json:"sha256,omitempty"`
+	Target  string            `json:"target,omitempty"`
+	Tags    map[string]string `json:"tags,omitempty"`
+}
+
+// Manifest returns a Manifest of the default VFS.
+func (b *Box) Manifest() (*Manifest, error) {
+	return b.ManifestPath(VFSPrefix)
+}
+
+// ManifestPath returns a Manifest of the tree rooted at the directory
+// root, which may be a VFS or a host path. Entries are sorted by path, and
+// symbolic links aren't followed. Files are read to hash them, but their
+// contents aren't kept.
+func (b *Box) ManifestPath(root string) (*Manifest, error) {
+	t, err := b.diffTree(root)
+	if err != nil {
+		return nil, err
+	}
+	m := &Manifest{
+		Version: manifestVersion,
+		Created: time.Now().UTC(),
+		Root:    root,
+		Entries: make([]ManifestEntry, 0, len(t.files)),
+	}
+
+	rels := make([]string, 0, len(t.files))
+	for rel := range t.files {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+
+	tagger, _ := t.fs.(absfs.Tagger)
+	for _, rel := range rels {
+		info := t.files[rel]
+		e := ManifestEntry{
+			Path:    rel,
+			Size:    info.Size(),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime().UTC(),
+		}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			if e.Target, err = t.fs.Readlink(t.path(rel)); err != nil {
+				return nil, t.mapErr(err)
+			}
+		case info.Mode().IsRegular():
+			sum, err := t.hash(rel)
+			if err != nil {
+				return nil, err
+			}
+			e.SHA256 = hex.EncodeToString(sum)
+		}
+		// Tags follows symbolic links
+		if tagger != nil && info.Mode()&os.ModeSymlink == 0 {
+			tags, err := tagger.Tags(t.path(rel))
+			if err != nil {
+				return nil, t.mapErr(err)
+			}
+			if len(tags) != 0 {
+				e.Tags = tags
+			}
+		}
+		m.Entries = append(m.Entries, e)
+	}
+
+	return m, nil
+}
@@ -0,0 +1,63 @@
+package pandorasbox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	b := NewBox()
+	if err := b.MkdirAll("vfs://dir", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteFile("vfs://dir/secret", []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.vfs.Symlink("/dir/secret", "/link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Tag("vfs://dir/secret", "env", "prod"); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := b.Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, e := range m.Entries {
+		paths = append(paths, e.Path)
+	}
+	if len(paths) != 3 || paths[0] != "dir" || paths[1] != "dir/secret" || paths[2] != "link" {
+		t.Fatalf("entries %v, want [dir dir/secret link]", paths)
+	}
+	sum := sha256.Sum256([]byte("hunter2"))
+	secret := m.Entries[1]
+	if secret.SHA256 != hex.EncodeToString(sum[:]) || secret.Size != 7 || secret.Mode.Perm() != 0600 {
+		t.Errorf("wrong entry: %+v", secret)
+	}
+	if secret.Tags["env"] != "prod" {
+		t.Errorf("tags %v, want env=prod", secret.Tags)
+	}
+	if link := m.Entries[2]; link.Target != "/dir/secret" || link.SHA256 != "" {
+		t.Errorf("wrong link entry: %+v", link)
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("hunter2")) {
+		t.Error("manifest holds file contents")
+	}
+	again, err := json.Marshal(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Error("manifest encodes differently each time")
+	}
+}
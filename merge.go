@@ -0,0 +1,162 @@
+package pandorasbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+// ErrMergeConflict is returned by Merge with MergeError for files that
+// exist in both boxes.
+var ErrMergeConflict = errors.New("file exists in both boxes")
+
+// MergeStrategy decides what Merge does with files that exist in both
+// boxes.
+type MergeStrategy int
+
+const (
+	// MergeNewerWins keeps whichever file was modified last.
+	MergeNewerWins MergeStrategy = iota
+
+	// MergeRename keeps the existing file, and merges the other one next
+	// to it as name.1, or name.2 if that exists too, and so on.
+	MergeRename
+
+	// MergeError fails without changing anything if any file exists in
+	// both boxes.
+	MergeError
+)
+
+// Merge copies the tree of the default VFS of other into the default VFS
+// of b, decrypting and sealing every file again under b's keys. Regular
+// files are copied with their modes, times and extended attributes, and
+// symbolic links as links. Files that exist in both boxes are resolved by
+// strategy; directories in both are merged. A file in one box where the
+// other has a directory is merged under a new name, like with
+// MergeRename, unless strategy is MergeError.
+func (b *Box) Merge(other *Box, strategy MergeStrategy) error {
+	if other == b {
+		return nil
+	}
+	m := &merger{dst: b.vfs, src: other.vfs, strategy: strategy}
+
+	if strategy == MergeError {
+		m.dryRun = true
+		if err := m.walk(); err != nil {
+			return err
+		}
+		m.dryRun = false
+	}
+
+	return m.walk()
+}
+
+// merger holds the state of a Merge.
+type merger struct {
+	dst, src *vfs.FileSystem
+	strategy MergeStrategy
+
+	// dryRun only looks for conflicts
+	dryRun bool
+}
+
+func (m *merger) walk() error {
+	// directories merged under other names, by their names in the source
+	renamed := make(map[string]string)
+
+	return ioutil.Walk(m.src, "/", func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == "/" {
+			return nil
+		}
+		if dir, ok := renamed[vfs.Dir(p)]; ok {
+			name := vfs.Join(dir, vfs.Base(p))
+			if isDir(info) {
+				renamed[p] = name
+			}
+			return m.add(p, name, info)
+		}
+
+		existing, err := m.dst.Lstat(p)
+		if err != nil {
+			return m.add(p, p, info)
+		}
+		if isDir(info) && isDir(existing) {
+			return nil
+		}
+
+		name := p
+		switch {
+		case m.strategy == MergeError:
+			return &os.PathError{Op: "merge", Path: p, Err: ErrMergeConflict}
+		case m.strategy == MergeNewerWins && isDir(info) == isDir(existing):
+			if !info.ModTime().After(existing.ModTime()) {
+				return nil
+			}
+			if !m.dryRun {
+				if err := m.dst.RemoveAll(p); err != nil {
+					return err
+				}
+			}
+		default:
+			name = m.freeName(p)
+			if isDir(info) {
+				renamed[p] = name
+			}
+		}
+
+		return m.add(p, name, info)
+	})
+}
+
+// isDir reports whether info describes a directory, and not a link to one.
+func isDir(info os.FileInfo) bool {
+	return info.IsDir() && info.Mode()&os.ModeSymlink == 0
+}
+
+// freeName returns the first of name.1, name.2 and so on that doesn't exist
+// in the destination.
+func (m *merger) freeName(name string) string {
+	for i := 1; ; i++ {
+		candidate := fmt.Sprintf("%s.%d", name, i)
+		if _, err := m.dst.Lstat(candidate); err != nil {
+			return candidate
+		}
+	}
+}
+
+// add copies the file p of the source to name in the destination.
+func (m *merger) add(p, name string, info os.FileInfo) error {
+	if m.dryRun {
+		return nil
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := m.src.Readlink(p)
+		if err != nil {
+			return err
+		}
+		return m.dst.Symlink(target, name)
+	case info.IsDir():
+		if err := m.dst.Mkdir(name, info.Mode().Perm()); err != nil {
+			return err
+		}
+		return m.dst.Chmod(name, info.Mode())
+	case info.Mode().IsRegular():
+		if err := ioutil.CopyFile(m.dst, name, m.src, p); err != nil {
+			return err
+		}
+		if err := m.dst.Chmod(name, info.Mode()); err != nil {
+			return err
+		}
+		return m.dst.Chtimes(name, atime(info), info.ModTime())
+	}
+
+	return nil
+}
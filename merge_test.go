@@ -0,0 +1,81 @@
+package pandorasbox
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	older := time.Unix(1000, 0)
+	newer := time.Unix(2000, 0)
+	setup := func(t *testing.T) (*Box, *Box) {
+		dst, src := NewBox(), NewBox()
+		for _, f := range []struct {
+			b     *Box
+			name  string
+			data  string
+			mtime time.Time
+		}{
+			{dst, "vfs://shared", "dst", older},
+			{dst, "vfs://only-dst", "dst", older},
+			{src, "vfs://shared", "src", newer},
+			{src, "vfs://only-src", "src", older},
+		} {
+			if err := f.b.WriteFile(f.name, []byte(f.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := f.b.Chtimes(f.name, f.mtime, f.mtime); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dst, src
+	}
+	read := func(t *testing.T, b *Box, name string) string {
+		data, err := b.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+
+	t.Run("NewerWins", func(t *testing.T) {
+		dst, src := setup(t)
+		if err := dst.Merge(src, MergeNewerWins); err != nil {
+			t.Fatal(err)
+		}
+		if got := read(t, dst, "vfs://shared"); got != "src" {
+			t.Errorf("shared = %q, want the newer %q", got, "src")
+		}
+		if got := read(t, dst, "vfs://only-src"); got != "src" {
+			t.Errorf("only-src = %q", got)
+		}
+		if got := read(t, dst, "vfs://only-dst"); got != "dst" {
+			t.Errorf("only-dst = %q", got)
+		}
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		dst, src := setup(t)
+		if err := dst.Merge(src, MergeRename); err != nil {
+			t.Fatal(err)
+		}
+		if got := read(t, dst, "vfs://shared"); got != "dst" {
+			t.Errorf("shared = %q, want the existing %q", got, "dst")
+		}
+		if got := read(t, dst, "vfs://shared.1"); got != "src" {
+			t.Errorf("shared.1 = %q, want %q", got, "src")
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		dst, src := setup(t)
+		if err := dst.Merge(src, MergeError); !errors.Is(err, ErrMergeConflict) {
+			t.Fatalf("got %v, want %v", err, ErrMergeConflict)
+		}
+		// nothing is merged if there is a conflict
+		if _, err := dst.Stat("vfs://only-src"); err == nil {
+			t.Error("merged files despite a conflict")
+		}
+	})
+}
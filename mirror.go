@@ -0,0 +1,269 @@
+package pandorasbox
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/internal/errno"
+	"github.com/capnspacehook/pandorasbox/ioutil"
+)
+
+// MirrorOptions control how Mirror keeps two trees in sync.
+type MirrorOptions struct {
+	// Debounce is how long Mirror waits after a change for more changes
+	// before copying them. It defaults to 100ms.
+	Debounce time.Duration
+
+	// Logger receives conflicts at slog.LevelWarn, and errors copying
+	// changes at slog.LevelError. It defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// Mirror keeps the trees rooted at the directories src and dst in sync
+// until ctx is done, like a directory on the host's filesystem and the VFS
+// directory holding its encrypted working copy. dst is first made a copy
+// of src by Sync, removing the files that aren't under src. After that,
+// changes to either tree are watched and copied to the other once no more
+// changes were made for opts.Debounce. A file changed in both trees at
+// once is a conflict, which is logged, and the one modified last wins.
+// Errors copying changes are logged too, and don't stop Mirror. opts may
+// be nil.
+//
+// Mirror returns the context's error once ctx is done, or the error that
+// kept it from starting.
+func (b *Box) Mirror(ctx context.Context, src, dst string, opts *MirrorOptions) error {
+	if opts == nil {
+		opts = new(MirrorOptions)
+	}
+	srcTree, err := b.newDiffTree(src)
+	if err != nil {
+		return err
+	}
+	dstTree, err := b.newDiffTree(dst)
+	if err != nil {
+		return err
+	}
+	m := &mirror{
+		trees:    [2]*diffTree{srcTree, dstTree},
+		debounce: opts.Debounce,
+		log:      opts.Logger,
+	}
+	if m.debounce <= 0 {
+		m.debounce = 100 * time.Millisecond
+	}
+	if m.log == nil {
+		m.log = slog.Default()
+	}
+	if srcTree.fs == dstTree.fs {
+		rel, err := filepath.Rel(srcTree.root, dstTree.root)
+		if err == nil && rel == "." || within(srcTree.root, dstTree.root) || within(dstTree.root, srcTree.root) {
+			return &os.LinkError{Op: "mirror", Old: src, New: dst, Err: absfs.ErrInvalid}
+		}
+	}
+
+	s := &syncer{src: m.trees[0], dst: m.trees[1]}
+	if _, err := s.run(&SyncOptions{Delete: true}); err != nil {
+		return err
+	}
+	w, err := b.Watch()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	m.w = w
+	for side := range m.trees {
+		if err := m.watch(side, ""); err != nil {
+			return err
+		}
+	}
+
+	return m.run(ctx)
+}
+
+// mirror holds the state of a Mirror.
+type mirror struct {
+	// trees are the source and destination
+	trees    [2]*diffTree
+	w        *Watcher
+	debounce time.Duration
+	log      *slog.Logger
+}
+
+// run copies changes until ctx is done.
+func (m *mirror) run(ctx context.Context) error {
+	timer := time.NewTimer(m.debounce)
+	timer.Stop()
+	defer timer.Stop()
+
+	// the trees each changed path changed in, as a bit per tree
+	pending := make(map[string]int)
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case e := <-m.w.Events:
+			for side, t := range m.trees {
+				if rel, ok := t.rel(e.Name); ok && rel != "" {
+					pending[rel] |= 1 << side
+				}
+			}
+			if !timer.Stop() {
+				select {
+				case <-timer.C:
+				default:
+				}
+			}
+			timer.Reset(m.debounce)
+		case err := <-m.w.Errors:
+			m.log.Error("mirror: watching for changes failed", "err", err)
+		case <-timer.C:
+			m.apply(pending)
+			pending = make(map[string]int)
+		}
+	}
+}
+
+// apply copies the changes to the paths in pending. Parents are copied
+// before their children.
+func (m *mirror) apply(pending map[string]int) {
+	rels := make([]string, 0, len(pending))
+	for rel := range pending {
+		rels = append(rels, rel)
+	}
+	sort.Strings(rels)
+
+	for _, rel := range rels {
+		if err := m.copy(rel, pending[rel]); err != nil {
+			m.log.Error("mirror: copying change failed", "path", rel, "err", err)
+		}
+		for side, t := range m.trees {
+			if info, err := t.fs.Lstat(t.path(rel)); err == nil && isDir(info) {
+				if err := m.watch(side, rel); err != nil {
+					m.log.Error("mirror: watching for changes failed", "path", t.boxPath(rel), "err", err)
+				}
+			}
+		}
+	}
+}
+
+// copy copies the file rel from the tree it changed in to the other one.
+// sides holds the trees it changed in, as a bit per tree.
+func (m *mirror) copy(rel string, sides int) error {
+	var infos [2]os.FileInfo
+	for side, t := range m.trees {
+		info, err := t.fs.Lstat(t.path(rel))
+		if err != nil && !os.IsNotExist(err) {
+			return errno.Map(err)
+		}
+		infos[side] = info
+	}
+
+	from := 0
+	if sides == 1<<1 {
+		from = 1
+	}
+	if infos[0] != nil && infos[1] != nil {
+		// either our own change to the other tree, or a change that
+		// made both alike
+		changed, err := differ(m.trees[1-from], m.trees[from], rel, infos[1-from], infos[from], new(DiffOptions))
+		if err != nil || !changed {
+			return err
+		}
+	}
+	if sides == 1|1<<1 {
+		m.log.Warn("mirror: file changed in both trees", "path", rel)
+		switch {
+		case infos[0] == nil:
+			from = 1
+		case infos[1] != nil && infos[1].ModTime().After(infos[0].ModTime()):
+			from = 1
+		}
+	}
+
+	s := &syncer{src: m.trees[from], dst: m.trees[1-from]}
+	info, old := infos[from], infos[1-from]
+	switch {
+	case info == nil && old == nil:
+		return nil
+	case info == nil:
+		return errno.Map(s.dst.fs.RemoveAll(s.dst.path(rel)))
+	case isDir(info) && (old == nil || !isDir(old)):
+		if old != nil {
+			if err := s.dst.fs.RemoveAll(s.dst.path(rel)); err != nil {
+				return errno.Map(err)
+			}
+		}
+		sub := &syncer{src: s.src.sub(rel), dst: s.dst.sub(rel)}
+		_, err := sub.run(new(SyncOptions))
+		return err
+	case old == nil:
+		return s.copy(rel, info)
+	}
+
+	return s.replace(rel, info, old)
+}
+
+// watch watches every directory under the directory rel of the tree side.
+func (m *mirror) watch(side int, rel string) error {
+	t := m.trees[side]
+	root := t.path(rel)
+
+	return ioutil.Walk(t.fs, root, func(p string, info os.FileInfo, err error) error {
+		if err != nil || !isDir(info) {
+			return err
+		}
+		r, err := filepath.Rel(t.root, p)
+		if err != nil {
+			return err
+		}
+		if r == "." {
+			r = ""
+		}
+		return m.w.Add(t.boxPath(filepath.ToSlash(r)))
+	})
+}
+
+// sub returns the tree rooted at the directory rel of t.
+func (t *diffTree) sub(rel string) *diffTree {
+	return &diffTree{fs: t.fs, root: t.path(rel), host: t.host, name: t.boxPath(rel)}
+}
+
+// boxPath returns the Box path of the file rel in t.
+func (t *diffTree) boxPath(rel string) string {
+	if t.host {
+		return filepath.Join(t.name, filepath.FromSlash(rel))
+	}
+	if rel == "" {
+		return t.name
+	}
+	return strings.TrimSuffix(t.name, "/") + "/" + rel
+}
+
+// rel returns the path of the Box path name relative to the root of t,
+// and whether it's under it.
+func (t *diffTree) rel(name string) (string, bool) {
+	if t.host {
+		rel, err := filepath.Rel(t.name, name)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", false
+		}
+		if rel == "." {
+			return "", true
+		}
+		return filepath.ToSlash(rel), true
+	}
+
+	root := strings.TrimSuffix(t.name, "/")
+	if name == root || name == t.name {
+		return "", true
+	}
+	rel := strings.TrimPrefix(name, root+"/")
+
+	return rel, rel != name
+}
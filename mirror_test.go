@@ -0,0 +1,84 @@
+package pandorasbox
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMirror(t *testing.T) {
+	b := NewBox()
+	host := t.TempDir()
+	if err := os.WriteFile(filepath.Join(host, "a"), []byte("a"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.MkdirAll("vfs://copy", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteFile("vfs://copy/stale", []byte("stale"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- b.Mirror(ctx, host, "vfs://copy", &MirrorOptions{
+			Debounce: 10 * time.Millisecond,
+			Logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+		})
+	}()
+	defer func() {
+		cancel()
+		if err := <-done; !errors.Is(err, context.Canceled) {
+			t.Errorf("Mirror returned %v, want %v", err, context.Canceled)
+		}
+	}()
+
+	// eventually waits for read to return want
+	eventually := func(what string, read func() ([]byte, error), want string) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			data, err := read()
+			if err == nil && string(data) == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: got %q, %v, want %q", what, data, err, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	readVFS := func(name string) func() ([]byte, error) {
+		return func() ([]byte, error) { return b.ReadFile("vfs://copy/" + name) }
+	}
+	readHost := func(name string) func() ([]byte, error) {
+		return func() ([]byte, error) { return os.ReadFile(filepath.Join(host, name)) }
+	}
+
+	eventually("initial copy", readVFS("a"), "a")
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if _, err := b.Stat("vfs://copy/stale"); os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("file only in the destination wasn't removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := os.WriteFile(filepath.Join(host, "b"), []byte("from host"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	eventually("change on the host", readVFS("b"), "from host")
+
+	if err := b.WriteFile("vfs://copy/c", []byte("from vfs"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	eventually("change in the VFS", readHost("c"), "from vfs")
+}
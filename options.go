@@ -0,0 +1,228 @@
+package pandorasbox
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+var ErrBadPathOption = errors.New("invalid path option")
+
+// PathOptions are per-file options that can be given in the query string
+// of a VFS path passed to a Box's Open, OpenFile, Create, ReadFile and
+// WriteFile methods, like vfs://app/token?ttl=5m&mode=0600.
+type PathOptions struct {
+	// TTL is how long after the file is opened or written it is removed.
+	// Opening it again with a TTL restarts the countdown, and creating or
+	// truncating it without one cancels it. The TTL follows the file when
+	// it's renamed. Files are removed by a timer, or by the next Open,
+	// Stat, Lstat or ReadDir of the Box once the clock of their VFS says
+	// their TTL passed, whichever is first.
+	TTL time.Duration
+
+	// Mode is the permission bits the file is created with, overriding
+	// the perm argument. Files opened for writing are changed to Mode if
+	// they already exist. It is only used if HasMode is true.
+	Mode    os.FileMode
+	HasMode bool
+}
+
+// ParsePathOptions splits the options from a VFS path. Host paths are
+// returned unchanged, as ? is a valid character in their names.
+func ParsePathOptions(path string) (string, PathOptions, error) {
+	var opts PathOptions
+
+	i := strings.IndexByte(path, '?')
+	if _, _, ok := splitScheme(path); !ok || i < 0 {
+		return path, opts, nil
+	}
+
+	query, err := url.ParseQuery(path[i+1:])
+	if err != nil {
+		return path, opts, fmt.Errorf("%w: %v", ErrBadPathOption, err)
+	}
+	for key, values := range query {
+		value := values[len(values)-1]
+		switch key {
+		case "ttl":
+			opts.TTL, err = time.ParseDuration(value)
+			if err == nil && opts.TTL <= 0 {
+				err = errors.New("ttl must be positive")
+			}
+		case "mode":
+			var mode uint64
+			mode, err = strconv.ParseUint(value, 8, 32)
+			opts.Mode, opts.HasMode = os.FileMode(mode).Perm(), true
+		default:
+			err = fmt.Errorf("unknown option %q", key)
+		}
+		if err != nil {
+			return path, opts, fmt.Errorf("%w: %v", ErrBadPathOption, err)
+		}
+	}
+
+	return path[:i], opts, nil
+}
+
+// applyOptions applies opts to the file name, which is vfsName in fs if
+// fs isn't nil, after it was opened with flag. Files opened to be created
+// or truncated without a TTL lose the one they had.
+func (b *Box) applyOptions(name string, fs *vfs.FileSystem, vfsName string, flag int, opts PathOptions) error {
+	if opts.HasMode && flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		if err := b.Chmod(name, opts.Mode); err != nil {
+			return err
+		}
+	}
+	switch {
+	case fs == nil:
+		// files on the host's filesystem can't expire
+	case opts.TTL > 0:
+		b.expireVFS(fs, vfsName, opts.TTL)
+	case flag&(os.O_CREATE|os.O_TRUNC) != 0:
+		b.cancelVFSExpiry(fs, vfsName)
+	}
+
+	return nil
+}
+
+// expire removes the file name after ttl, replacing any earlier expiry of
+// it. Only VFS files can expire.
+func (b *Box) expire(name string, ttl time.Duration) {
+	if fs, vfsName, ok, _ := b.resolveVFS(name); ok {
+		b.expireVFS(fs, vfsName, ttl)
+	}
+}
+
+// cancelExpiry stops the file name from being removed after a TTL.
+func (b *Box) cancelExpiry(name string) {
+	if fs, vfsName, ok, _ := b.resolveVFS(name); ok {
+		b.cancelVFSExpiry(fs, vfsName)
+	}
+}
+
+// expiryKey identifies a file given a TTL.
+type expiryKey struct {
+	fs   *vfs.FileSystem
+	name string
+}
+
+// expiry is the pending removal of a file given a TTL. Its key changes if
+// the file is renamed.
+type expiry struct {
+	key      expiryKey
+	deadline time.Time
+	timer    *time.Timer
+}
+
+// expireVFS removes name in fs after ttl, replacing any earlier expiry of it.
+// The deadline is also kept by the clock of fs, see reapExpired.
+func (b *Box) expireVFS(fs *vfs.FileSystem, name string, ttl time.Duration) {
+	e := &expiry{
+		key:      expiryKey{fs: fs, name: vfs.Clean(name)},
+		deadline: fs.Now().Add(ttl),
+	}
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.expiry == nil {
+		b.expiry = make(map[expiryKey]*expiry)
+	}
+	if old, ok := b.expiry[e.key]; ok {
+		old.timer.Stop()
+	}
+	b.expiry[e.key] = e
+	e.timer = time.AfterFunc(ttl, func() {
+		b.removeExpired(e)
+	})
+}
+
+// removeExpired removes the file of e, unless its expiry was cancelled or
+// replaced.
+func (b *Box) removeExpired(e *expiry) {
+	b.mtx.Lock()
+	key := e.key
+	current := b.expiry[key] == e
+	if current {
+		e.timer.Stop()
+		delete(b.expiry, key)
+	}
+	b.mtx.Unlock()
+
+	if current {
+		key.fs.Remove(key.name)
+	}
+}
+
+// reapExpired removes the files whose deadline passed by the clock of
+// their VFS, even if their timer hasn't fired yet.
+func (b *Box) reapExpired() {
+	b.mtx.RLock()
+	var due []*expiry
+	for _, e := range b.expiry {
+		if !e.key.fs.Now().Before(e.deadline) {
+			due = append(due, e)
+		}
+	}
+	b.mtx.RUnlock()
+
+	for _, e := range due {
+		b.removeExpired(e)
+	}
+}
+
+// cancelVFSExpiry stops name in fs, and the files under it if it's a
+// directory, from being removed after a TTL.
+func (b *Box) cancelVFSExpiry(fs *vfs.FileSystem, name string) {
+	name = vfs.Clean(name)
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	for key, e := range b.expiry {
+		if key.fs == fs && under(key.name, name) {
+			e.timer.Stop()
+			delete(b.expiry, key)
+		}
+	}
+}
+
+// moveExpiry makes the TTLs of oldname in fs, and of the files under it if
+// it's a directory, apply to them under newname once they're renamed.
+// The TTLs of the files they replace are cancelled.
+func (b *Box) moveExpiry(fs *vfs.FileSystem, oldname, newname string) {
+	oldname, newname = vfs.Clean(oldname), vfs.Clean(newname)
+
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	var moved []*expiry
+	for key, e := range b.expiry {
+		if key.fs != fs {
+			continue
+		}
+		if under(key.name, oldname) {
+			moved = append(moved, e)
+			delete(b.expiry, key)
+		} else if under(key.name, newname) {
+			e.timer.Stop()
+			delete(b.expiry, key)
+		}
+	}
+	for _, e := range moved {
+		e.key.name = path.Join(newname, strings.TrimPrefix(e.key.name, oldname))
+		b.expiry[e.key] = e
+	}
+}
+
+// under reports whether the VFS path name is dir or a file under it.
+func under(name, dir string) bool {
+	return name == dir || dir == "/" || strings.HasPrefix(name, dir+"/")
+}
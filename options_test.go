@@ -0,0 +1,267 @@
+package pandorasbox
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+func TestParsePathOptions(t *testing.T) {
+	tests := []struct {
+		path string
+		name string
+		opts PathOptions
+	}{
+		{"vfs://token", "vfs://token", PathOptions{}},
+		{"vfs://token?ttl=5m", "vfs://token", PathOptions{TTL: 5 * time.Minute}},
+		{"vfs://app/token?ttl=1h30m&mode=0600", "vfs://app/token", PathOptions{TTL: 90 * time.Minute, Mode: 0600, HasMode: true}},
+		{"vfs://token?mode=640", "vfs://token", PathOptions{Mode: 0640, HasMode: true}},
+		{"vfs://token?mode=1777", "vfs://token", PathOptions{Mode: 0777, HasMode: true}},
+		{"vfs://token?mode=0600&mode=0644", "vfs://token", PathOptions{Mode: 0644, HasMode: true}},
+		{"vfs://token?", "vfs://token", PathOptions{}},
+		// ? is part of the names of host files
+		{"/tmp/what?ttl=5m", "/tmp/what?ttl=5m", PathOptions{}},
+	}
+	for _, tt := range tests {
+		name, opts, err := ParsePathOptions(tt.path)
+		if err != nil {
+			t.Errorf("ParsePathOptions(%q): %v", tt.path, err)
+			continue
+		}
+		if name != tt.name || opts != tt.opts {
+			t.Errorf("ParsePathOptions(%q) = %q, %+v, want %q, %+v", tt.path, name, opts, tt.name, tt.opts)
+		}
+	}
+
+	for _, path := range []string{
+		"vfs://token?ttl=5",
+		"vfs://token?ttl=0s",
+		"vfs://token?ttl=-1m",
+		"vfs://token?mode=0800",
+		"vfs://token?mode=rw",
+		"vfs://token?owner=root",
+		"vfs://token?ttl=%zz",
+	} {
+		if _, _, err := ParsePathOptions(path); !errors.Is(err, ErrBadPathOption) {
+			t.Errorf("ParsePathOptions(%q): error %v, want %v", path, err, ErrBadPathOption)
+		}
+	}
+}
+
+func TestPathOptionsMode(t *testing.T) {
+	b := NewBox()
+	if err := b.WriteFile("vfs://token?mode=0600", []byte("hunter2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checkMode := func(want os.FileMode) {
+		t.Helper()
+		info, err := b.Stat("vfs://token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("mode %v, want %v", info.Mode().Perm(), want)
+		}
+	}
+	checkMode(0600)
+
+	// files opened for reading keep their mode
+	f, err := b.OpenFile("vfs://token?mode=0640", os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	checkMode(0600)
+
+	f, err = b.OpenFile("vfs://token?mode=0640", os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	checkMode(0640)
+
+	if _, err := b.Open("vfs://token?mode=x"); !errors.Is(err, ErrBadPathOption) {
+		t.Errorf("Open: error %v, want %v", err, ErrBadPathOption)
+	}
+}
+
+// testClock is a clock that only moves when it's told to.
+type testClock struct {
+	mtx sync.Mutex
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	return c.now
+}
+
+func (c *testClock) Add(d time.Duration) {
+	c.mtx.Lock()
+	c.now = c.now.Add(d)
+	c.mtx.Unlock()
+}
+
+// newClockBox returns a Box with a VFS registered as vfs://t that uses the
+// returned clock, so TTLs of files in it pass without waiting.
+func newClockBox(t *testing.T) (*Box, *testClock) {
+	t.Helper()
+	clock := &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	b := NewBox()
+	if err := b.Register("t", vfs.NewFS(vfs.WithClock(clock.Now))); err != nil {
+		t.Fatal(err)
+	}
+
+	return b, clock
+}
+
+func TestExpiry(t *testing.T) {
+	exists := func(t *testing.T, b *Box, name string) bool {
+		t.Helper()
+		_, err := b.Stat(name)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		return err == nil
+	}
+	write := func(t *testing.T, b *Box, name string) {
+		t.Helper()
+		if err := b.WriteFile(name, []byte("hunter2"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("ttl", func(t *testing.T) {
+		b, clock := newClockBox(t)
+		write(t, b, "vfs://t/token?ttl=1h")
+		clock.Add(59 * time.Minute)
+		if !exists(t, b, "vfs://t/token") {
+			t.Fatal("file removed before its TTL passed")
+		}
+		clock.Add(time.Minute)
+		if exists(t, b, "vfs://t/token") {
+			t.Error("file not removed after its TTL passed")
+		}
+	})
+
+	t.Run("restart", func(t *testing.T) {
+		b, clock := newClockBox(t)
+		write(t, b, "vfs://t/token?ttl=1h")
+		clock.Add(30 * time.Minute)
+		write(t, b, "vfs://t/token?ttl=1h")
+		clock.Add(45 * time.Minute)
+		if !exists(t, b, "vfs://t/token") {
+			t.Error("reopening with a TTL didn't restart it")
+		}
+	})
+
+	t.Run("read", func(t *testing.T) {
+		b, clock := newClockBox(t)
+		write(t, b, "vfs://t/token?ttl=1h")
+		if _, err := b.ReadFile("vfs://t/token"); err != nil {
+			t.Fatal(err)
+		}
+		clock.Add(time.Hour)
+		if exists(t, b, "vfs://t/token") {
+			t.Error("reading the file cancelled its TTL")
+		}
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		b, clock := newClockBox(t)
+		write(t, b, "vfs://t/token?ttl=1h")
+		write(t, b, "vfs://t/token")
+		clock.Add(time.Hour)
+		if !exists(t, b, "vfs://t/token") {
+			t.Error("rewriting the file without a TTL didn't cancel it")
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		b, clock := newClockBox(t)
+		write(t, b, "vfs://t/token?ttl=1h")
+		if err := b.Remove("vfs://t/token"); err != nil {
+			t.Fatal(err)
+		}
+		// a file created without the Box isn't removed by the old TTL
+		fs, _ := b.Named("t")
+		f, err := fs.Create("/token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		clock.Add(time.Hour)
+		if !exists(t, b, "vfs://t/token") {
+			t.Error("TTL of a removed file applied to a new one")
+		}
+	})
+
+	t.Run("remove all", func(t *testing.T) {
+		b, clock := newClockBox(t)
+		if err := b.MkdirAll("vfs://t/dir/sub", 0700); err != nil {
+			t.Fatal(err)
+		}
+		write(t, b, "vfs://t/dir/sub/token?ttl=1h")
+		if err := b.RemoveAll("vfs://t/dir"); err != nil {
+			t.Fatal(err)
+		}
+		if len(b.expiry) != 0 {
+			t.Errorf("%d expiries left after RemoveAll", len(b.expiry))
+		}
+		fs, _ := b.Named("t")
+		if err := fs.MkdirAll("/dir/sub", 0700); err != nil {
+			t.Fatal(err)
+		}
+		f, err := fs.Create("/dir/sub/token")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		clock.Add(time.Hour)
+		if !exists(t, b, "vfs://t/dir/sub/token") {
+			t.Error("TTL of a removed file applied to a new one")
+		}
+	})
+
+	t.Run("rename", func(t *testing.T) {
+		b, clock := newClockBox(t)
+		write(t, b, "vfs://t/old?ttl=1h")
+		write(t, b, "vfs://t/replaced?ttl=2h")
+		if err := b.Rename("vfs://t/old", "vfs://t/new"); err != nil {
+			t.Fatal(err)
+		}
+		if err := b.Rename("vfs://t/new", "vfs://t/replaced"); err != nil {
+			t.Fatal(err)
+		}
+		clock.Add(time.Hour)
+		if exists(t, b, "vfs://t/replaced") {
+			t.Error("TTL didn't follow the renamed file")
+		}
+		write(t, b, "vfs://t/old")
+		clock.Add(2 * time.Hour)
+		if !exists(t, b, "vfs://t/old") {
+			t.Error("TTL stayed with the old name")
+		}
+	})
+
+	t.Run("rename dir", func(t *testing.T) {
+		b, clock := newClockBox(t)
+		if err := b.Mkdir("vfs://t/dir", 0700); err != nil {
+			t.Fatal(err)
+		}
+		write(t, b, "vfs://t/dir/token?ttl=1h")
+		if err := b.Rename("vfs://t/dir", "vfs://t/moved"); err != nil {
+			t.Fatal(err)
+		}
+		clock.Add(time.Hour)
+		if exists(t, b, "vfs://t/moved/token") {
+			t.Error("TTL didn't follow the renamed directory")
+		}
+	})
+}
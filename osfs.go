@@ -80,6 +80,10 @@ func OSSymlink(oldname, newname string) error {
 	return box.osfs.Symlink(oldname, newname)
 }
 
+func OSLink(oldname, newname string) error {
+	return box.osfs.Link(oldname, newname)
+}
+
 // io/ioutil methods
 
 func OSReadFile(filename string) ([]byte, error) {
@@ -0,0 +1,100 @@
+package osfs
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// WriteFileAtomic writes data to the named file, creating it with
+// permissions perm if necessary, so that after a crash the file holds
+// either its old contents or data, never a mix. The data is written to a
+// temporary file in the same directory, which is synced and renamed over
+// name, and then the directory itself is synced so the rename is durable.
+func WriteFileAtomic(name string, data []byte, perm os.FileMode) error {
+	return writeFileAtomic(name, perm, func(f *os.File) error {
+		_, err := f.Write(data)
+		return err
+	})
+}
+
+// WriteFileAtomic is like the package level WriteFileAtomic, but files
+// under directories encrypted at rest are written encrypted.
+func (fs *FileSystem) WriteFileAtomic(name string, data []byte, perm os.FileMode) error {
+	enc := fs.encrypted(name)
+	if enc == nil {
+		return WriteFileAtomic(name, data, perm)
+	}
+
+	return writeFileAtomic(name, perm, func(f *os.File) error {
+		ef, err := enc.OpenFile(f.Name(), os.O_WRONLY, 0)
+		if err != nil {
+			return err
+		}
+		_, err = ef.Write(data)
+		if err1 := ef.Close(); err == nil {
+			err = err1
+		}
+		return err
+	})
+}
+
+// writeFileAtomic is WriteFileAtomic, with the temporary file written by
+// write.
+func writeFileAtomic(name string, perm os.FileMode, write func(f *os.File) error) error {
+	dir, base := filepath.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+
+	f, err := os.CreateTemp(dir, "."+base+".tmp*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	// remove the temporary file if anything goes wrong; after the rename
+	// this fails harmlessly
+	defer os.Remove(tmp)
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return err
+	}
+
+	return SyncDir(dir)
+}
+
+// SyncDir commits the entries of the directory dir to stable storage, so
+// files created, renamed or removed in it stay that way after a crash.
+// Directories can't be synced on Windows, where this is done by the
+// filesystem itself, so SyncDir does nothing there.
+func SyncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if err1 := d.Close(); err == nil {
+		err = err1
+	}
+
+	return err
+}
@@ -0,0 +1,97 @@
+package osfs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/awnumar/memguard"
+
+	"github.com/capnspacehook/pandorasbox/encfs"
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+// ErrCrossEncryption is returned for renames and links between a directory
+// encrypted at rest and one that isn't.
+var ErrCrossEncryption = errors.New("can't move files in or out of a directory encrypted at rest")
+
+// atRest is the configuration of SetAtRest.
+type atRest struct {
+	fs   *encfs.FileSystem
+	dirs []string
+}
+
+// SetAtRest encrypts the contents of the files under dirs on disk with
+// encfs, under key, the master key. Each file is encrypted under its own
+// random key, which is kept in the file wrapped under key. Writes are
+// sealed in chunks and written to disk as they happen, and sizes reported
+// for the files are the sizes of their contents. Files under dirs that
+// aren't encrypted under key fail to open with encfs.ErrCorrupt, and files
+// can't be renamed or linked between dirs and directories that aren't
+// encrypted. Files already open keep being encrypted or not as they were
+// when they were opened. A nil key encrypts no files.
+func (fs *FileSystem) SetAtRest(key *memguard.Enclave, dirs ...string) error {
+	if key == nil {
+		fs.atRest.Store(nil)
+		return nil
+	}
+	k, err := key.Open()
+	if err != nil {
+		return err
+	}
+	size := k.Size()
+	k.Destroy()
+	if size != seal.KeySize {
+		return seal.ErrInvalidKey
+	}
+
+	ar := &atRest{fs: encfs.Wrap(NewFS(), key)}
+	for _, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		ar.dirs = append(ar.dirs, abs)
+	}
+	fs.atRest.Store(ar)
+
+	return nil
+}
+
+// encrypted returns the encfs FileSystem the file name is encrypted at rest
+// with, or nil if it isn't.
+func (fs *FileSystem) encrypted(name string) *encfs.FileSystem {
+	ar := fs.atRest.Load()
+	if ar == nil {
+		return nil
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return nil
+	}
+	for _, dir := range ar.dirs {
+		if abs == dir || strings.HasPrefix(abs, dir+string(filepath.Separator)) {
+			return ar.fs
+		}
+	}
+
+	return nil
+}
+
+// openEncrypted opens the file name that is encrypted at rest with enc.
+func (fs *FileSystem) openEncrypted(enc *encfs.FileSystem, name string, flag int, perm os.FileMode) (*File, error) {
+	f, err := enc.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	ef := f.(*encfs.File)
+
+	return &File{fs, ef.Backend().(*File).f, ef}, nil
+}
+
+// crossEncryption reports whether oldpath and newpath aren't both encrypted
+// at rest, or both not.
+func (fs *FileSystem) crossEncryption(oldpath, newpath string) bool {
+	return fs.encrypted(oldpath) != fs.encrypted(newpath)
+}
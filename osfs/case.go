@@ -0,0 +1,38 @@
+package osfs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CaseSensitive reports whether the filesystem holding the directory dir
+// tells apart names that only differ in case. It finds out by creating a
+// temporary file in dir and looking it up under a different case.
+func CaseSensitive(dir string) (bool, error) {
+	f, err := os.CreateTemp(dir, ".pandorasbox-case-")
+	if err != nil {
+		return false, err
+	}
+	name := f.Name()
+	defer os.Remove(name)
+	info, err := f.Stat()
+	f.Close()
+	if err != nil {
+		return false, err
+	}
+
+	upper := filepath.Join(dir, strings.ToUpper(filepath.Base(name)))
+	upperInfo, err := os.Stat(upper)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return !os.SameFile(info, upperInfo), nil
+}
+
+func (fs *FileSystem) CaseSensitive(dir string) (bool, error) {
+	return CaseSensitive(dir)
+}
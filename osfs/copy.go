@@ -0,0 +1,122 @@
+package osfs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+var errSameFile = errors.New("source and destination are the same file")
+
+const copyBufSize = 128 * 1024
+
+// CopyFile copies the regular file src to dst, which is created with the
+// permissions of src if it doesn't exist. On filesystems that support it,
+// like btrfs, XFS and APFS, dst is a reflink sharing the blocks of src,
+// which is instant. Elsewhere the contents are streamed, and blocks of
+// zeros are skipped so that sparse files stay sparse.
+func CopyFile(src, dst string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return &os.PathError{Op: "copy", Path: src, Err: errNotRegular}
+	}
+	// truncating dst would destroy src
+	if dstInfo, err := os.Stat(dst); err == nil && os.SameFile(info, dstInfo) {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: errSameFile}
+	}
+	if err := reflink(src, dst, info.Mode().Perm()); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	err = sparseCopy(out, in)
+	if err1 := out.Close(); err == nil {
+		err = err1
+	}
+
+	return err
+}
+
+// CopyFile is like the package level CopyFile, but files under
+// directories encrypted at rest are decrypted when they're copied out of
+// them, and encrypted when they're copied into them.
+func (fs *FileSystem) CopyFile(src, dst string) error {
+	if fs.encrypted(src) == nil && fs.encrypted(dst) == nil {
+		return CopyFile(longPath(src), longPath(dst))
+	}
+
+	info, err := fs.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return &os.PathError{Op: "copy", Path: src, Err: errNotRegular}
+	}
+	// truncating dst would destroy src
+	srcInfo, err := os.Stat(longPath(src))
+	if err != nil {
+		return err
+	}
+	if dstInfo, err := os.Stat(longPath(dst)); err == nil && os.SameFile(srcInfo, dstInfo) {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: errSameFile}
+	}
+
+	in, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := fs.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err1 := out.Close(); err == nil {
+		err = err1
+	}
+
+	return err
+}
+
+// sparseCopy copies in to out, seeking over blocks of zeros instead of
+// writing them.
+func sparseCopy(out, in *os.File) error {
+	buf := make([]byte, copyBufSize)
+	zeros := make([]byte, copyBufSize)
+
+	var size int64
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			if bytes.Equal(buf[:n], zeros[:n]) {
+				if _, err := out.Seek(int64(n), io.SeekCurrent); err != nil {
+					return err
+				}
+			} else if _, err := out.Write(buf[:n]); err != nil {
+				return err
+			}
+			size += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// a trailing hole isn't written, so set the size explicitly
+	return out.Truncate(size)
+}
@@ -0,0 +1,31 @@
+package osfs
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// OpenDirect opens the named file like OpenFile, but bypasses the page
+// cache so large transfers of secrets don't leave plaintext copies of them
+// in the kernel's memory. On macOS this sets F_NOCACHE, which has no
+// alignment requirements. Files encrypted at rest are opened with
+// OpenFile, as only their ciphertext ever reaches the page cache.
+func (fs *FileSystem) OpenDirect(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	if fs.encrypted(name) != nil {
+		return fs.OpenFile(name, flag, perm)
+	}
+
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return &absfs.InvalidFile{Path: name}, err
+	}
+	if _, err := unix.FcntlInt(f.Fd(), unix.F_NOCACHE, 1); err != nil {
+		f.Close()
+		return &absfs.InvalidFile{Path: name}, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &File{fs, f, nil}, nil
+}
@@ -0,0 +1,318 @@
+package osfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// DirectAlignment is the alignment of the buffers, offsets and sizes of
+// direct I/O requests.
+const DirectAlignment = 4096
+
+const directBufSize = 256 * DirectAlignment
+
+// DirectFile is a file opened with OpenDirect. Reads and writes go through
+// an aligned window of the file, which is transferred with direct I/O
+// whenever it is full or the file is synced, sought or closed. The part of
+// a window that doesn't fill a whole block is written through the page
+// cache, which is then dropped.
+type DirectFile struct {
+	*File
+
+	buf    []byte
+	base   int64 // offset of buf in the file
+	pos    int   // offset of the next read or write in buf
+	n      int   // number of valid bytes in buf
+	loaded bool  // buf[:n] holds all of the file's contents in the window
+	dirty  bool  // buf[:n] has unwritten changes
+}
+
+// OpenDirect opens the named file like OpenFile, but bypasses the page
+// cache so large transfers of secrets don't leave plaintext copies of them
+// in the kernel's memory. It fails if the filesystem the file is on
+// doesn't support direct I/O. Files encrypted at rest are opened with
+// OpenFile, as only their ciphertext ever reaches the page cache.
+func (fs *FileSystem) OpenDirect(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	if fs.encrypted(name) != nil {
+		return fs.OpenFile(name, flag, perm)
+	}
+
+	f, err := os.OpenFile(name, flag|unix.O_DIRECT, perm)
+	if err != nil {
+		return &absfs.InvalidFile{Path: name}, err
+	}
+
+	return &DirectFile{File: &File{fs, f, nil}, buf: alignedBuffer(directBufSize)}, nil
+}
+
+// alignedBuffer returns a buffer of size bytes starting at an address
+// aligned to DirectAlignment.
+func alignedBuffer(size int) []byte {
+	buf := make([]byte, size+DirectAlignment)
+	off := 0
+	if rem := int(uintptr(unsafe.Pointer(&buf[0])) & (DirectAlignment - 1)); rem != 0 {
+		off = DirectAlignment - rem
+	}
+	return buf[off : off+size : off+size]
+}
+
+func alignDown(off int64) int64 {
+	return off &^ (DirectAlignment - 1)
+}
+
+// buffered runs fn with direct I/O disabled, for transfers that aren't
+// aligned, and then drops what fn left in the page cache.
+func (f *DirectFile) buffered(off, size int64, fn func() error) error {
+	fd := int(f.f.Fd())
+	flags, err := unix.FcntlInt(uintptr(fd), unix.F_GETFL, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := unix.FcntlInt(uintptr(fd), unix.F_SETFL, flags&^unix.O_DIRECT); err != nil {
+		return err
+	}
+	err = fn()
+	if err == nil {
+		err = unix.Fdatasync(fd)
+	}
+	if err == nil {
+		err = unix.Fadvise(fd, off, size, unix.FADV_DONTNEED)
+	}
+	if _, err1 := unix.FcntlInt(uintptr(fd), unix.F_SETFL, flags); err == nil {
+		err = err1
+	}
+
+	return err
+}
+
+// load reads the window at base.
+func (f *DirectFile) load() error {
+	n, err := f.f.ReadAt(f.buf, f.base)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	f.n = n
+	f.loaded = true
+
+	return nil
+}
+
+// flush writes the changes in the window back to the file.
+func (f *DirectFile) flush() error {
+	if !f.dirty {
+		return nil
+	}
+
+	aligned := f.n &^ (DirectAlignment - 1)
+	if aligned > 0 {
+		if _, err := f.f.WriteAt(f.buf[:aligned], f.base); err != nil {
+			return err
+		}
+	}
+	if tail := f.buf[aligned:f.n]; len(tail) > 0 {
+		off := f.base + int64(aligned)
+		err := f.buffered(off, int64(len(tail)), func() error {
+			_, err := f.f.WriteAt(tail, off)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	f.dirty = false
+
+	return nil
+}
+
+// move moves the window so it contains off.
+func (f *DirectFile) move(off int64) error {
+	if f.loaded && off >= f.base && off < f.base+int64(len(f.buf)) {
+		f.pos = int(off - f.base)
+		return nil
+	}
+	if err := f.flush(); err != nil {
+		return err
+	}
+	f.base = alignDown(off)
+	f.pos = int(off - f.base)
+	f.n = 0
+	f.loaded = false
+
+	return nil
+}
+
+// invalidate writes back and forgets the window, so the file can be
+// accessed without it.
+func (f *DirectFile) invalidate() error {
+	if err := f.flush(); err != nil {
+		return err
+	}
+	f.loaded = false
+	f.n = 0
+
+	return nil
+}
+
+func (f *DirectFile) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if f.pos == len(f.buf) {
+		if err := f.move(f.base + int64(f.pos)); err != nil {
+			return 0, &os.PathError{Op: "read", Path: f.Name(), Err: err}
+		}
+	}
+	if !f.loaded {
+		if err := f.flush(); err != nil {
+			return 0, &os.PathError{Op: "read", Path: f.Name(), Err: err}
+		}
+		if err := f.load(); err != nil {
+			return 0, &os.PathError{Op: "read", Path: f.Name(), Err: err}
+		}
+	}
+	if f.pos >= f.n {
+		return 0, io.EOF
+	}
+
+	n := copy(p, f.buf[f.pos:f.n])
+	f.pos += n
+
+	return n, nil
+}
+
+func (f *DirectFile) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		if f.pos == len(f.buf) {
+			if err := f.move(f.base + int64(f.pos)); err != nil {
+				return written, &os.PathError{Op: "write", Path: f.Name(), Err: err}
+			}
+		}
+		// the window is written from its start, so a gap before the
+		// write must be filled with the file's contents first
+		if !f.loaded && f.pos > f.n {
+			if err := f.flush(); err != nil {
+				return written, &os.PathError{Op: "write", Path: f.Name(), Err: err}
+			}
+			if err := f.load(); err != nil {
+				return written, &os.PathError{Op: "write", Path: f.Name(), Err: err}
+			}
+		}
+		if f.pos > f.n {
+			clear(f.buf[f.n:f.pos])
+		}
+
+		n := copy(f.buf[f.pos:], p)
+		f.pos += n
+		f.n = max(f.n, f.pos)
+		f.dirty = true
+		written += n
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+func (f *DirectFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *DirectFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.base + int64(f.pos)
+	case io.SeekEnd:
+		info, err := f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		offset += info.Size()
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.Name(), Err: syscall.EINVAL}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.Name(), Err: syscall.EINVAL}
+	}
+	if err := f.move(offset); err != nil {
+		return 0, &os.PathError{Op: "seek", Path: f.Name(), Err: err}
+	}
+
+	return offset, nil
+}
+
+func (f *DirectFile) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.Name(), Err: errors.New("negative offset")}
+	}
+	if err := f.flush(); err != nil {
+		return 0, &os.PathError{Op: "readat", Path: f.Name(), Err: err}
+	}
+
+	base := alignDown(off)
+	end := alignDown(off + int64(len(b)) + DirectAlignment - 1)
+	buf := alignedBuffer(int(end - base))
+	n, err := f.f.ReadAt(buf, base)
+	n = copy(b, buf[min(int(off-base), n):n])
+	if err == nil && n < len(b) {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+func (f *DirectFile) WriteAt(b []byte, off int64) (int, error) {
+	if err := f.invalidate(); err != nil {
+		return 0, &os.PathError{Op: "writeat", Path: f.Name(), Err: err}
+	}
+
+	var n int
+	err := f.buffered(off, int64(len(b)), func() error {
+		var err error
+		n, err = f.f.WriteAt(b, off)
+		return err
+	})
+	if _, ok := err.(*os.PathError); err != nil && !ok {
+		err = &os.PathError{Op: "writeat", Path: f.Name(), Err: err}
+	}
+
+	return n, err
+}
+
+func (f *DirectFile) Truncate(size int64) error {
+	if err := f.invalidate(); err != nil {
+		return &os.PathError{Op: "truncate", Path: f.Name(), Err: err}
+	}
+	return f.f.Truncate(size)
+}
+
+func (f *DirectFile) Stat() (os.FileInfo, error) {
+	if err := f.flush(); err != nil {
+		return nil, &os.PathError{Op: "stat", Path: f.Name(), Err: err}
+	}
+	return f.f.Stat()
+}
+
+func (f *DirectFile) Sync() error {
+	if err := f.flush(); err != nil {
+		return &os.PathError{Op: "sync", Path: f.Name(), Err: err}
+	}
+	return f.f.Sync()
+}
+
+func (f *DirectFile) Close() error {
+	err := f.flush()
+	if err1 := f.f.Close(); err == nil {
+		err = err1
+	}
+	f.buf = nil
+
+	return err
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package osfs
+
+import (
+	"os"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// OpenDirect is only supported on Linux and macOS.
+func (fs *FileSystem) OpenDirect(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	return &absfs.InvalidFile{Path: name}, &os.PathError{Op: "open", Path: name, Err: absfs.ErrNotImplemented}
+}
@@ -0,0 +1,119 @@
+package osfs
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/awnumar/memguard"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+var (
+	errFileChanged = errors.New("file changed while it was read")
+	errEmptyFile   = errors.New("empty files can't be sealed in an enclave")
+)
+
+// ReadFileLocked reads the named file straight into a LockedBuffer, so its
+// contents are never held in ordinary memory. The buffer must be destroyed
+// by the caller.
+func ReadFileLocked(name string) (*memguard.LockedBuffer, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readLocked(name, f)
+}
+
+// ReadFileLocked is like the package level ReadFileLocked, but files under
+// directories encrypted at rest are decrypted.
+func (fs *FileSystem) ReadFileLocked(name string) (*memguard.LockedBuffer, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return readLocked(name, f)
+}
+
+// readLocked reads the open file name into a LockedBuffer.
+func readLocked(name string, f absfs.File) (*memguard.LockedBuffer, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, &os.PathError{Op: "read", Path: name, Err: errNotRegular}
+	}
+
+	// a LockedBuffer can't grow, so the file is read in one go and must
+	// not change size in the meantime
+	buf := memguard.NewBuffer(int(info.Size()))
+	if buf.Size() != 0 {
+		if _, err := io.ReadFull(f, buf.Bytes()); err != nil {
+			buf.Destroy()
+			if err == io.ErrUnexpectedEOF {
+				err = errFileChanged
+			}
+			return nil, &os.PathError{Op: "read", Path: name, Err: err}
+		}
+	}
+	var extra [1]byte
+	if n, _ := f.Read(extra[:]); n != 0 {
+		extra[0] = 0
+		buf.Destroy()
+		return nil, &os.PathError{Op: "read", Path: name, Err: errFileChanged}
+	}
+
+	return buf, nil
+}
+
+// ReadFileEnclave reads the named file straight into an Enclave, like
+// ReadFileLocked.
+func ReadFileEnclave(name string) (*memguard.Enclave, error) {
+	buf, err := ReadFileLocked(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return sealLocked(name, buf)
+}
+
+// ReadFileEnclave is like the package level ReadFileEnclave, but files
+// under directories encrypted at rest are decrypted.
+func (fs *FileSystem) ReadFileEnclave(name string) (*memguard.Enclave, error) {
+	buf, err := fs.ReadFileLocked(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return sealLocked(name, buf)
+}
+
+// sealLocked seals buf, the contents of the file name, in an Enclave.
+func sealLocked(name string, buf *memguard.LockedBuffer) (*memguard.Enclave, error) {
+	if buf.Size() == 0 {
+		return nil, &os.PathError{Op: "read", Path: name, Err: errEmptyFile}
+	}
+
+	return buf.Seal(), nil
+}
+
+// ShredFileEnclave reads the named file into an Enclave like
+// ReadFileEnclave, and then removes it with SecureRemove, so the secret
+// only remains in the Enclave.
+func (fs *FileSystem) ShredFileEnclave(name string) (*memguard.Enclave, error) {
+	e, err := fs.ReadFileEnclave(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := fs.SecureRemove(name); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
@@ -0,0 +1,29 @@
+//go:build !(linux || darwin || freebsd || openbsd || netbsd || dragonfly || windows)
+
+package osfs
+
+import (
+	"os"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+func (f *File) Lock() error {
+	return &os.PathError{Op: "lock", Path: f.Name(), Err: absfs.ErrNotImplemented}
+}
+
+func (f *File) RLock() error {
+	return &os.PathError{Op: "rlock", Path: f.Name(), Err: absfs.ErrNotImplemented}
+}
+
+func (f *File) TryLock() (bool, error) {
+	return false, &os.PathError{Op: "lock", Path: f.Name(), Err: absfs.ErrNotImplemented}
+}
+
+func (f *File) TryRLock() (bool, error) {
+	return false, &os.PathError{Op: "rlock", Path: f.Name(), Err: absfs.ErrNotImplemented}
+}
+
+func (f *File) Unlock() error {
+	return &os.PathError{Op: "unlock", Path: f.Name(), Err: absfs.ErrNotImplemented}
+}
@@ -0,0 +1,65 @@
+//go:build linux || darwin || freebsd || openbsd || netbsd || dragonfly
+
+package osfs
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+func (f *File) flock(how int) error {
+	for {
+		err := unix.Flock(int(f.f.Fd()), how)
+		if err != unix.EINTR {
+			return err
+		}
+	}
+}
+
+func (f *File) lock(op string, how int) error {
+	err := f.flock(how)
+	if err == unix.EWOULDBLOCK {
+		err = absfs.ErrLocked
+	}
+	if err != nil {
+		return &os.PathError{Op: op, Path: f.Name(), Err: err}
+	}
+	return nil
+}
+
+func (f *File) tryLock(op string, how int) (bool, error) {
+	err := f.lock(op, how|unix.LOCK_NB)
+	if errors.Is(err, absfs.ErrLocked) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Lock places an exclusive advisory lock on the file with flock(2).
+func (f *File) Lock() error {
+	return f.lock("lock", unix.LOCK_EX)
+}
+
+// RLock places a shared advisory lock on the file with flock(2).
+func (f *File) RLock() error {
+	return f.lock("rlock", unix.LOCK_SH)
+}
+
+func (f *File) TryLock() (bool, error) {
+	return f.tryLock("lock", unix.LOCK_EX)
+}
+
+func (f *File) TryRLock() (bool, error) {
+	return f.tryLock("rlock", unix.LOCK_SH)
+}
+
+func (f *File) Unlock() error {
+	return f.lock("unlock", unix.LOCK_UN)
+}
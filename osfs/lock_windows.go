@@ -0,0 +1,65 @@
+//go:build windows
+
+package osfs
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// the whole file is locked by locking the largest possible range
+const allBytes = ^uint32(0)
+
+func (f *File) lockFileEx(op string, flags uint32) error {
+	ol := new(windows.Overlapped)
+	err := windows.LockFileEx(windows.Handle(f.f.Fd()), flags, 0, allBytes, allBytes, ol)
+	if err == windows.ERROR_LOCK_VIOLATION {
+		err = absfs.ErrLocked
+	}
+	if err != nil {
+		return &os.PathError{Op: op, Path: f.Name(), Err: err}
+	}
+	return nil
+}
+
+func (f *File) tryLock(op string, flags uint32) (bool, error) {
+	err := f.lockFileEx(op, flags|windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if errors.Is(err, absfs.ErrLocked) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Lock places an exclusive advisory lock on the file with LockFileEx.
+func (f *File) Lock() error {
+	return f.lockFileEx("lock", windows.LOCKFILE_EXCLUSIVE_LOCK)
+}
+
+// RLock places a shared advisory lock on the file with LockFileEx.
+func (f *File) RLock() error {
+	return f.lockFileEx("rlock", 0)
+}
+
+func (f *File) TryLock() (bool, error) {
+	return f.tryLock("lock", windows.LOCKFILE_EXCLUSIVE_LOCK)
+}
+
+func (f *File) TryRLock() (bool, error) {
+	return f.tryLock("rlock", 0)
+}
+
+func (f *File) Unlock() error {
+	ol := new(windows.Overlapped)
+	err := windows.UnlockFileEx(windows.Handle(f.f.Fd()), 0, allBytes, allBytes, ol)
+	if err != nil {
+		return &os.PathError{Op: "unlock", Path: f.Name(), Err: err}
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package osfs
+
+// longPath returns name, as only Windows limits the length of paths.
+func longPath(name string) string {
+	return name
+}
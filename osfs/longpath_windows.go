@@ -0,0 +1,29 @@
+package osfs
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxPath is the length from which paths need the \\?\ prefix. Directory
+// names are limited to MAX_PATH minus room for an 8.3 file name.
+const maxPath = 248
+
+// longPath returns name with the \\?\ prefix if it is too long for the
+// Win32 API to accept as is. The os package already does this for long
+// absolute paths, but not for relative ones, which deep trees exported
+// into the working directory run into.
+func longPath(name string) string {
+	if len(name) < maxPath || strings.HasPrefix(name, `\\?\`) || strings.HasPrefix(name, `\\.\`) {
+		return name
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return name
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+
+	return `\\?\` + abs
+}
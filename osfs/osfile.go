@@ -2,11 +2,18 @@ package osfs
 
 import (
 	"os"
+	"time"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
 )
 
 type File struct {
 	filer *FileSystem
 	f     *os.File
+
+	// enc is the encfs file reads and writes of files encrypted at rest
+	// go through.
+	enc absfs.File
 }
 
 func (f *File) Name() string {
@@ -14,38 +21,65 @@ func (f *File) Name() string {
 }
 
 func (f *File) Read(p []byte) (int, error) {
+	if f.enc != nil {
+		return f.enc.Read(p)
+	}
 	return f.f.Read(p)
 }
 
 func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
+	if f.enc != nil {
+		return f.enc.ReadAt(b, off)
+	}
 	return f.f.ReadAt(b, off)
 }
 
 func (f *File) Write(p []byte) (int, error) {
+	if f.enc != nil {
+		return f.enc.Write(p)
+	}
 	return f.f.Write(p)
 }
 
 func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
+	if f.enc != nil {
+		return f.enc.WriteAt(b, off)
+	}
 	return f.f.WriteAt(b, off)
 }
 
 func (f *File) Close() error {
+	if f.enc != nil {
+		return f.enc.Close()
+	}
 	return f.f.Close()
 }
 
 func (f *File) Seek(offset int64, whence int) (ret int64, err error) {
+	if f.enc != nil {
+		return f.enc.Seek(offset, whence)
+	}
 	return f.f.Seek(offset, whence)
 }
 
 func (f *File) Stat() (os.FileInfo, error) {
+	if f.enc != nil {
+		return f.enc.Stat()
+	}
 	return f.f.Stat()
 }
 
 func (f *File) Sync() error {
+	if f.enc != nil {
+		return f.enc.Sync()
+	}
 	return f.f.Sync()
 }
 
 func (f *File) Readdir(n int) ([]os.FileInfo, error) {
+	if f.enc != nil {
+		return f.enc.Readdir(n)
+	}
 	return f.f.Readdir(n)
 }
 
@@ -54,9 +88,45 @@ func (f *File) Readdirnames(n int) ([]string, error) {
 }
 
 func (f *File) Truncate(size int64) error {
+	if f.enc != nil {
+		return f.enc.Truncate(size)
+	}
 	return f.f.Truncate(size)
 }
 
 func (f *File) WriteString(s string) (n int, err error) {
+	if f.enc != nil {
+		return f.enc.WriteString(s)
+	}
 	return f.f.WriteString(s)
 }
+
+func (f *File) SetDeadline(t time.Time) error {
+	return f.f.SetDeadline(t)
+}
+
+func (f *File) SetReadDeadline(t time.Time) error {
+	return f.f.SetReadDeadline(t)
+}
+
+func (f *File) SetWriteDeadline(t time.Time) error {
+	return f.f.SetWriteDeadline(t)
+}
+
+func (f *File) Fd() uintptr {
+	return f.f.Fd()
+}
+
+func (f *File) Chmod(mode os.FileMode) error {
+	return f.f.Chmod(mode)
+}
+
+func (f *File) Chown(uid, gid int) error {
+	return f.f.Chown(uid, gid)
+}
+
+// Chtimes changes the access and modification times of the file by name,
+// as os.File has no futimes.
+func (f *File) Chtimes(atime, mtime time.Time) error {
+	return os.Chtimes(f.f.Name(), atime, mtime)
+}
@@ -3,6 +3,7 @@ package osfs
 import (
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 
 	"github.com/capnspacehook/pandorasbox/absfs"
@@ -13,6 +14,20 @@ func IsPathSeparator(c uint8) bool {
 }
 
 type FileSystem struct {
+	// ShredPasses is the number of times SecureRemove overwrites a file.
+	// If it is not positive, DefaultShredPasses is used.
+	ShredPasses int
+
+	// Durable makes operations that add, rename or remove directory
+	// entries sync the directories they change before returning, so the
+	// changes survive a crash. It must be set before the FileSystem is
+	// used.
+	Durable bool
+
+	// Symlinks decides whether Walk follows symbolic links.
+	Symlinks absfs.SymlinkPolicy
+
+	atRest atomic.Pointer[atRest]
 }
 
 func NewFS() *FileSystem {
@@ -32,7 +47,7 @@ func (fs *FileSystem) Abs(path string) (string, error) {
 }
 
 func (fs *FileSystem) Chdir(name string) error {
-	return os.Chdir(name)
+	return os.Chdir(longPath(name))
 }
 
 func (fs *FileSystem) Getwd() (dir string, err error) {
@@ -44,90 +59,198 @@ func (fs *FileSystem) TempDir() string {
 }
 
 func (fs *FileSystem) Open(name string) (absfs.File, error) {
-	f, err := os.Open(name)
+	if fs.encrypted(name) != nil {
+		return fs.OpenFile(name, os.O_RDONLY, 0)
+	}
+
+	f, err := os.Open(longPath(name))
 	if err != nil {
 		return nil, err
 	}
 
-	return &File{fs, f}, nil
+	return &File{fs, f, nil}, nil
 }
 
 func (fs *FileSystem) Create(name string) (absfs.File, error) {
-	f, err := os.Create(name)
+	if fs.encrypted(name) != nil {
+		return fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	}
+
+	f, err := os.Create(longPath(name))
 	if err != nil {
 		return nil, err
 	}
+	if err := fs.syncParent(name); err != nil {
+		f.Close()
+		return nil, err
+	}
 
-	return &File{fs, f}, nil
+	return &File{fs, f, nil}, nil
 }
 
 func (fs *FileSystem) Truncate(name string, size int64) error {
-	return os.Truncate(name, size)
+	if enc := fs.encrypted(name); enc != nil {
+		return enc.Truncate(name, size)
+	}
+	return os.Truncate(longPath(name), size)
 }
 
 func (fs *FileSystem) Mkdir(name string, perm os.FileMode) error {
-	return os.Mkdir(name, perm)
+	if err := os.Mkdir(longPath(name), perm); err != nil {
+		return err
+	}
+	return fs.syncParent(name)
 }
 
 func (fs *FileSystem) MkdirAll(name string, perm os.FileMode) error {
-	return os.MkdirAll(name, perm)
+	if !fs.Durable {
+		return os.MkdirAll(longPath(name), perm)
+	}
+
+	// find the directories MkdirAll will create, so their parents can be
+	// synced afterwards
+	var created []string
+	for dir := filepath.Clean(name); ; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(longPath(dir)); err == nil {
+			break
+		}
+		created = append(created, dir)
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	if err := os.MkdirAll(longPath(name), perm); err != nil {
+		return err
+	}
+	for _, dir := range created {
+		if err := fs.syncParent(dir); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
 func (fs *FileSystem) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
-	f, err := os.OpenFile(name, flag, perm)
+	var f *File
+	var err error
+	if enc := fs.encrypted(name); enc != nil {
+		f, err = fs.openEncrypted(enc, name, flag, perm)
+	} else {
+		var osf *os.File
+		osf, err = os.OpenFile(longPath(name), flag, perm)
+		f = &File{fs, osf, nil}
+	}
 	if err != nil {
 		return nil, err
 	}
+	if flag&os.O_CREATE != 0 {
+		if err := fs.syncParent(name); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
 
-	return &File{fs, f}, err
+	return f, nil
 }
 
 func (fs *FileSystem) Remove(name string) error {
-	return os.Remove(name)
+	if err := os.Remove(longPath(name)); err != nil {
+		return err
+	}
+	return fs.syncParent(name)
 }
 
 func (fs *FileSystem) Rename(oldpath, newpath string) error {
-	return os.Rename(oldpath, newpath)
+	if fs.crossEncryption(oldpath, newpath) {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: ErrCrossEncryption}
+	}
+	if err := os.Rename(longPath(oldpath), longPath(newpath)); err != nil {
+		return err
+	}
+	if err := fs.syncParent(newpath); err != nil {
+		return err
+	}
+	if filepath.Dir(oldpath) == filepath.Dir(newpath) {
+		return nil
+	}
+	return fs.syncParent(oldpath)
 }
 
 func (fs *FileSystem) RemoveAll(name string) error {
-	return os.RemoveAll(name)
+	if err := os.RemoveAll(longPath(name)); err != nil {
+		return err
+	}
+	return fs.syncParent(name)
 }
 
 func (fs *FileSystem) Stat(name string) (os.FileInfo, error) {
-	return os.Stat(name)
+	if enc := fs.encrypted(name); enc != nil {
+		return enc.Stat(name)
+	}
+	return os.Stat(longPath(name))
 }
 
 func (fs *FileSystem) Chmod(name string, mode os.FileMode) error {
-	return os.Chmod(name, mode)
+	return os.Chmod(longPath(name), mode)
 }
 
 func (fs *FileSystem) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	return os.Chtimes(name, atime, mtime)
+	return os.Chtimes(longPath(name), atime, mtime)
 }
 
 func (fs *FileSystem) Chown(name string, uid, gid int) error {
-	return os.Chown(name, uid, gid)
+	return os.Chown(longPath(name), uid, gid)
 }
 
 func (fs *FileSystem) Lstat(name string) (os.FileInfo, error) {
-	return os.Lstat(name)
+	if enc := fs.encrypted(name); enc != nil {
+		return enc.Lstat(name)
+	}
+	return os.Lstat(longPath(name))
 }
 
 // ess
 
 func (fs *FileSystem) Lchown(name string, uid, gid int) error {
-	return os.Lchown(name, uid, gid)
+	return os.Lchown(longPath(name), uid, gid)
 }
 
 func (fs *FileSystem) Readlink(name string) (string, error) {
-	return os.Readlink(name)
+	return os.Readlink(longPath(name))
 }
 
 func (fs *FileSystem) Symlink(oldname, newname string) error {
-	return os.Symlink(oldname, newname)
+	if err := os.Symlink(oldname, longPath(newname)); err != nil {
+		return err
+	}
+	return fs.syncParent(newname)
+}
+
+func (fs *FileSystem) Link(oldname, newname string) error {
+	if fs.crossEncryption(oldname, newname) {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: ErrCrossEncryption}
+	}
+	if err := os.Link(longPath(oldname), longPath(newname)); err != nil {
+		return err
+	}
+	return fs.syncParent(newname)
 }
 
-func (fs *FileSystem) Walk(path string, fn func(string, os.FileInfo, error) error) error {
-	return filepath.Walk(path, fn)
+// syncParent syncs the directory containing name if fs is Durable.
+func (fs *FileSystem) syncParent(name string) error {
+	if !fs.Durable {
+		return nil
+	}
+	return SyncDir(longPath(filepath.Dir(name)))
+}
+
+// Capabilities reports extended attribute support only on platforms that
+// have them.
+func (fs *FileSystem) Capabilities() absfs.Capability {
+	c := absfs.CapLink | absfs.CapSymlink | absfs.CapTruncate
+	if xattrSupported {
+		c |= absfs.CapXattr
+	}
+	return c
 }
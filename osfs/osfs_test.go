@@ -1,14 +1,21 @@
 package osfs
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
+	"syscall"
 	"testing"
 
 	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/encfs"
 	"github.com/capnspacehook/pandorasbox/fstesting"
+	"github.com/capnspacehook/pandorasbox/seal"
 )
 
 func TestOSWalk(t *testing.T) {
@@ -181,3 +188,654 @@ func TestOSFS(t *testing.T) {
 	}
 
 }
+
+func TestSecureRemove(t *testing.T) {
+	fs := NewFS()
+	dir := t.TempDir()
+	name := filepath.Join(dir, "secret")
+	if err := os.WriteFile(name, []byte("plaintext secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// a hard link shares the contents, so it shows what was left behind
+	link := filepath.Join(dir, "link")
+	if err := os.Link(name, link); err != nil {
+		t.Skip("hard links not supported:", err)
+	}
+
+	if err := fs.SecureRemove(name); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(name); !os.IsNotExist(err) {
+		t.Errorf("file still exists: %v", err)
+	}
+	data, err := os.ReadFile(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 0 {
+		t.Errorf("contents not shredded: %q", data)
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 {
+		t.Errorf("unexpected files left behind: %v", names)
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "config")
+
+	for _, data := range []string{"first", "second"} {
+		if err := WriteFileAtomic(name, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != data {
+			t.Errorf("got %q, want %q", got, data)
+		}
+	}
+
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Errorf("wrong permissions: %v", info.Mode().Perm())
+	}
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 {
+		t.Errorf("temporary files left behind: %v", names)
+	}
+}
+
+func TestXattr(t *testing.T) {
+	fs := NewFS()
+	if !absfs.Capabilities(fs).Has(absfs.CapXattr) {
+		t.Skip("extended attributes not supported on", runtime.GOOS)
+	}
+	name := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(name, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := fs.Setxattr(name, "user.pandorasbox", []byte("value"))
+	if errors.Is(err, syscall.ENOTSUP) {
+		t.Skip("extended attributes not supported by the temporary directory")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := fs.Getxattr(name, "user.pandorasbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value) != "value" {
+		t.Errorf("got %q, want %q", value, "value")
+	}
+	attrs, err := fs.Listxattr(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attrs) != 1 || attrs[0] != "user.pandorasbox" {
+		t.Errorf("wrong attributes listed: %q", attrs)
+	}
+	if err := fs.Removexattr(name, "user.pandorasbox"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Getxattr(name, "user.pandorasbox"); err == nil {
+		t.Error("attribute not removed")
+	}
+}
+
+func TestLock(t *testing.T) {
+	fs := NewFS()
+	name := filepath.Join(t.TempDir(), "lock")
+	open := func() absfs.LockFile {
+		f, err := fs.OpenFile(name, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { f.Close() })
+		return f.(absfs.LockFile)
+	}
+	a, b := open(), open()
+
+	if err := a.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.TryRLock(); ok || err != nil {
+		t.Errorf("TryRLock on exclusively locked file: %v, %v", ok, err)
+	}
+	if err := a.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.RLock(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := b.TryRLock(); !ok || err != nil {
+		t.Errorf("TryRLock on shared locked file: %v, %v", ok, err)
+	}
+	if ok, err := b.TryLock(); ok || err != nil {
+		t.Errorf("TryLock on shared locked file: %v, %v", ok, err)
+	}
+}
+
+func TestOpenDirect(t *testing.T) {
+	fs := NewFS()
+	name := filepath.Join(t.TempDir(), "direct")
+	f, err := fs.OpenDirect(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if errors.Is(err, absfs.ErrNotImplemented) || errors.Is(err, syscall.EINVAL) {
+		t.Skip("direct I/O not supported:", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// odd sized writes, so they are never aligned
+	data := make([]byte, 3*1024*1024+123)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	for rest := data; len(rest) > 0; {
+		n := min(len(rest), 12345)
+		if _, err := f.Write(rest[:n]); err != nil {
+			t.Fatal(err)
+		}
+		rest = rest[n:]
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("written data doesn't match")
+	}
+
+	f, err = fs.OpenDirect(name, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	got, err = io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("read data doesn't match")
+	}
+
+	if _, err := f.Seek(5000, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("overwritten")); err != nil {
+		t.Fatal(err)
+	}
+	copy(data[5000:], "overwritten")
+	buf := make([]byte, 100)
+	if _, err := f.ReadAt(buf, 4950); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, data[4950:5050]) {
+		t.Errorf("ReadAt: got %q, want %q", buf, data[4950:5050])
+	}
+}
+
+func TestShredFileEnclave(t *testing.T) {
+	fs := NewFS()
+	dir := t.TempDir()
+	name := filepath.Join(dir, "key")
+	if err := os.WriteFile(name, []byte("secret key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := fs.ShredFileEnclave(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(name); !os.IsNotExist(err) {
+		t.Errorf("file not removed: %v", err)
+	}
+	buf, err := e.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Destroy()
+	if buf.String() != "secret key" {
+		t.Errorf("got %q", buf.String())
+	}
+
+	empty := filepath.Join(dir, "empty")
+	if err := os.WriteFile(empty, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFileEnclave(empty); err == nil {
+		t.Error("expected error sealing an empty file")
+	}
+}
+
+func TestCopyFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	// zeros in the middle and at the end, which may be copied as holes
+	data := make([]byte, 3*copyBufSize)
+	copy(data, "start")
+	copy(data[copyBufSize+10:], "middle")
+	if err := os.WriteFile(src, data, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "dst")
+	if err := CopyFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("copied data doesn't match")
+	}
+
+	// the fallback used where reflinks aren't supported
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	out, err := os.Create(filepath.Join(dir, "sparse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	if err := sparseCopy(out, in); err != nil {
+		t.Fatal(err)
+	}
+	got, err = os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Error("sparse copied data doesn't match")
+	}
+
+	if err := CopyFile(src, src); err == nil {
+		t.Error("expected copying a file onto itself to fail")
+	}
+}
+
+func TestDurable(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewFS()
+	fs.Durable = true
+
+	sub := filepath.Join(dir, "a", "b")
+	if err := fs.MkdirAll(sub, 0700); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(sub, "file")
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	moved := filepath.Join(dir, "moved")
+	if err := fs.Rename(name, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink(moved, name); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RemoveAll(filepath.Join(dir, "a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(moved); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.Mkdir(sub, 0700); err == nil {
+		t.Error("expected Mkdir without parents to fail")
+	}
+}
+
+func TestCaseSensitive(t *testing.T) {
+	dir := t.TempDir()
+	sensitive, err := CaseSensitive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// check the probe against the filesystem directly
+	if err := os.WriteFile(filepath.Join(dir, "probe"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = os.Stat(filepath.Join(dir, "PROBE"))
+	if want := os.IsNotExist(err); sensitive != want {
+		t.Errorf("CaseSensitive = %v, want %v", sensitive, want)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("probe file left behind: %v", entries)
+	}
+
+	if _, err := CaseSensitive(filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected probing a missing directory to fail")
+	}
+}
+
+func TestWalkSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "d"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "d", "f"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "d"), filepath.Join(dir, "d", "loop")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("d", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	walk := func(policy absfs.SymlinkPolicy) []string {
+		fs := NewFS()
+		fs.Symlinks = policy
+		var got []string
+		err := fs.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			rel, _ := filepath.Rel(dir, path)
+			if errors.Is(err, absfs.ErrSymlinkCycle) {
+				rel += " cycle"
+			} else if err != nil {
+				return err
+			}
+			got = append(got, rel)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	tests := []struct {
+		policy absfs.SymlinkPolicy
+		want   string
+	}{
+		{absfs.ReportSymlinks, ". d d/f d/loop link"},
+		{absfs.FollowSymlinks, ". d d/f d/loop cycle link link/f link/loop cycle"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(walk(tt.policy), " "); got != tt.want {
+			t.Errorf("policy %d: walked %q, want %q", tt.policy, got, tt.want)
+		}
+	}
+}
+
+func TestLongPath(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	// a relative path longer than MAX_PATH
+	name := strings.Repeat("d", 100)
+	for i := 0; i < 3; i++ {
+		name = filepath.Join(name, strings.Repeat("d", 100))
+	}
+	fs := NewFS()
+	if err := fs.MkdirAll(name, 0700); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(name, "file")
+	f, err := fs.Create(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, err := fs.Stat(file); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RemoveAll(strings.Repeat("d", 100)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAtRest(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	plain := filepath.Join(dir, "plain")
+	if err := os.Mkdir(secret, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(plain, 0700); err != nil {
+		t.Fatal(err)
+	}
+	fs := NewFS()
+	key := seal.NewKey()
+	if err := fs.SetAtRest(key, secret); err != nil {
+		t.Fatal(err)
+	}
+
+	name := filepath.Join(secret, "file")
+	data := []byte("attack at dawn")
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, data) {
+		t.Fatal("contents are written to disk in plaintext")
+	}
+	info, err := fs.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(data)) {
+		t.Fatalf("size is %d, want %d", info.Size(), len(data))
+	}
+
+	f, err = fs.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("!")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Truncate(name, 6); err != nil {
+		t.Fatal(err)
+	}
+	f, err = fs.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "attack" {
+		t.Fatalf("read %q, want %q", got, "attack")
+	}
+
+	atomic := filepath.Join(secret, "atomic")
+	if err := fs.WriteFileAtomic(atomic, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	f, err = fs.Open(atomic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("read %q, want %q", got, data)
+	}
+
+	other := NewFS()
+	if err := other.SetAtRest(seal.NewKey(), secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Open(name); !errors.Is(err, encfs.ErrCorrupt) {
+		t.Fatalf("opening under another key: got %v, want %v", err, encfs.ErrCorrupt)
+	}
+	if err := os.WriteFile(filepath.Join(secret, "unencrypted"), data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Open(filepath.Join(secret, "unencrypted")); !errors.Is(err, encfs.ErrCorrupt) {
+		t.Fatalf("opening unencrypted file: got %v, want %v", err, encfs.ErrCorrupt)
+	}
+
+	if err := fs.Rename(name, filepath.Join(plain, "file")); !errors.Is(err, ErrCrossEncryption) {
+		t.Fatalf("renaming out: got %v, want %v", err, ErrCrossEncryption)
+	}
+	if err := fs.Rename(name, filepath.Join(secret, "moved")); err != nil {
+		t.Fatal(err)
+	}
+
+	// files are stored as encfs stores them
+	f, err = encfs.Wrap(NewFS(), key).Open(atomic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("read %q with encfs, want %q", got, data)
+	}
+}
+
+func TestAtRestBypass(t *testing.T) {
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "secret")
+	if err := os.Mkdir(secret, 0700); err != nil {
+		t.Fatal(err)
+	}
+	fs := NewFS()
+	if err := fs.SetAtRest(seal.NewKey(), secret); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte("attack at dawn")
+
+	readPlain := func(name string) []byte {
+		t.Helper()
+		f, err := fs.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		got, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	checkEncrypted := func(name string) {
+		t.Helper()
+		raw, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(raw, data) {
+			t.Errorf("%s is written to disk in plaintext", name)
+		}
+		if got := readPlain(name); !bytes.Equal(got, data) {
+			t.Errorf("%s = %q, want %q", name, got, data)
+		}
+	}
+
+	// copies into and out of encrypted directories are encrypted and
+	// decrypted
+	plain := filepath.Join(dir, "plain")
+	if err := os.WriteFile(plain, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	copied := filepath.Join(secret, "copied")
+	if err := fs.CopyFile(plain, copied); err != nil {
+		t.Fatal(err)
+	}
+	checkEncrypted(copied)
+	out := filepath.Join(dir, "out")
+	if err := fs.CopyFile(copied, out); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(out); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("copied out %q, %v, want %q", got, err, data)
+	}
+	if err := fs.CopyFile(copied, copied); err == nil {
+		t.Error("copying a file onto itself succeeded")
+	}
+
+	direct := filepath.Join(secret, "direct")
+	f, err := fs.OpenDirect(direct, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err == nil {
+		_, err = f.Write(data)
+		if err1 := f.Close(); err == nil {
+			err = err1
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEncrypted(direct)
+
+	e, err := fs.ShredFileEnclave(copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := e.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.Bytes(), data) {
+		t.Errorf("shredded %q into the enclave, want %q", b.Bytes(), data)
+	}
+	b.Destroy()
+	if _, err := os.Stat(copied); !os.IsNotExist(err) {
+		t.Errorf("shredded file wasn't removed: %v", err)
+	}
+}
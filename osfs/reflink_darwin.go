@@ -0,0 +1,27 @@
+package osfs
+
+import (
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink clones src to dst with clonefile(2). clonefile won't overwrite
+// files, so the clone is made next to dst and renamed over it.
+func reflink(src, dst string, perm os.FileMode) error {
+	tmp := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".clone")
+	if err := unix.Clonefile(src, tmp, unix.CLONE_NOFOLLOW); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp, perm); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
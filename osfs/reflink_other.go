@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package osfs
+
+import (
+	"os"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+func reflink(src, dst string, perm os.FileMode) error {
+	return absfs.ErrNotImplemented
+}
@@ -0,0 +1,107 @@
+package osfs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// DefaultShredPasses is the number of times SecureRemove overwrites a file
+// if FileSystem.ShredPasses is not set.
+const DefaultShredPasses = 3
+
+const shredBufSize = 32 * 1024
+
+var errNotRegular = errors.New("not a regular file")
+
+// Shred overwrites the contents of the named file with random data passes
+// times, syncing after every pass, and then truncates it. Shredding is
+// best effort: copy-on-write and journaling filesystems, SSD wear leveling
+// and backups may all keep copies of the original contents.
+func Shred(name string, passes int) error {
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return &os.PathError{Op: "shred", Path: name, Err: errNotRegular}
+	}
+
+	buf := make([]byte, shredBufSize)
+	for i := 0; i < passes; i++ {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		for left := info.Size(); left > 0; {
+			n := int64(len(buf))
+			if left < n {
+				n = left
+			}
+			if _, err := rand.Read(buf[:n]); err != nil {
+				return &os.PathError{Op: "shred", Path: name, Err: err}
+			}
+			if _, err := f.Write(buf[:n]); err != nil {
+				return err
+			}
+			left -= n
+		}
+		if err := f.Sync(); err != nil {
+			return err
+		}
+	}
+
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+
+	return f.Close()
+}
+
+// SecureRemove shreds the named file and then removes it, after renaming
+// it to a random name so the original name doesn't linger in the
+// directory either. Anything other than a regular file is removed like
+// Remove, without following symbolic links.
+func (fs *FileSystem) SecureRemove(name string) error {
+	name = longPath(name)
+	info, err := os.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return os.Remove(name)
+	}
+
+	passes := fs.ShredPasses
+	if passes <= 0 {
+		passes = DefaultShredPasses
+	}
+	if err := Shred(name, passes); err != nil {
+		return err
+	}
+
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	tmp := filepath.Join(filepath.Dir(name), "."+hex.EncodeToString(random))
+	if err := os.Rename(name, tmp); err != nil {
+		return err
+	}
+	if err := os.Remove(tmp); err != nil {
+		return err
+	}
+
+	return fs.syncParent(name)
+}
@@ -0,0 +1,86 @@
+package osfs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// Walk walks the file tree rooted at path like filepath.Walk, treating
+// symbolic links according to fs.Symlinks.
+func (fs *FileSystem) Walk(path string, fn filepath.WalkFunc) error {
+	if fs.Symlinks != absfs.FollowSymlinks {
+		return filepath.Walk(path, fn)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		err = fn(path, nil, err)
+	} else {
+		err = walkFollow(path, info, nil, fn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+// walkFollow walks path, following symbolic links. parents holds the
+// directories being walked, to detect cycles.
+func walkFollow(path string, info os.FileInfo, parents []os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+	for _, parent := range parents {
+		if os.SameFile(parent, info) {
+			return fn(path, info, &os.PathError{Op: "walk", Path: path, Err: absfs.ErrSymlinkCycle})
+		}
+	}
+
+	names, err := readDirNames(path)
+	err1 := fn(path, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+
+	parents = append(parents, info)
+	for _, name := range names {
+		filename := filepath.Join(path, name)
+		fileInfo, err := os.Stat(filename)
+		if err != nil {
+			// report links that point nowhere as they are
+			if linkInfo, lerr := os.Lstat(filename); lerr == nil && linkInfo.Mode()&os.ModeSymlink != 0 {
+				fileInfo, err = linkInfo, nil
+			}
+		}
+		if err != nil {
+			if err := fn(filename, fileInfo, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+		} else {
+			err = walkFollow(filename, fileInfo, parents, fn)
+			if err != nil {
+				if !fileInfo.IsDir() || err != filepath.SkipDir {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func readDirNames(dirname string) ([]string, error) {
+	f, err := os.Open(dirname)
+	if err != nil {
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+	return names, nil
+}
@@ -0,0 +1,27 @@
+//go:build !(linux || darwin || freebsd)
+
+package osfs
+
+import (
+	"os"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+const xattrSupported = false
+
+func (fs *FileSystem) Getxattr(name, attr string) ([]byte, error) {
+	return nil, &os.PathError{Op: "getxattr", Path: name, Err: absfs.ErrNotImplemented}
+}
+
+func (fs *FileSystem) Setxattr(name, attr string, value []byte) error {
+	return &os.PathError{Op: "setxattr", Path: name, Err: absfs.ErrNotImplemented}
+}
+
+func (fs *FileSystem) Listxattr(name string) ([]string, error) {
+	return nil, &os.PathError{Op: "listxattr", Path: name, Err: absfs.ErrNotImplemented}
+}
+
+func (fs *FileSystem) Removexattr(name, attr string) error {
+	return &os.PathError{Op: "removexattr", Path: name, Err: absfs.ErrNotImplemented}
+}
@@ -0,0 +1,80 @@
+//go:build linux || darwin || freebsd
+
+package osfs
+
+import (
+	"bytes"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const xattrSupported = true
+
+// Getxattr returns the value of the extended attribute attr of the named
+// file. On Linux, attributes set by unprivileged processes must be in the
+// "user." namespace.
+func (fs *FileSystem) Getxattr(name, attr string) ([]byte, error) {
+	for {
+		size, err := unix.Getxattr(name, attr, nil)
+		if err != nil {
+			return nil, &os.PathError{Op: "getxattr", Path: name, Err: err}
+		}
+		value := make([]byte, size)
+		n, err := unix.Getxattr(name, attr, value)
+		// the attribute may have grown in between the calls
+		if err == unix.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "getxattr", Path: name, Err: err}
+		}
+		return value[:n], nil
+	}
+}
+
+// Setxattr sets the extended attribute attr of the named file.
+func (fs *FileSystem) Setxattr(name, attr string, value []byte) error {
+	if err := unix.Setxattr(name, attr, value, 0); err != nil {
+		return &os.PathError{Op: "setxattr", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Listxattr returns the names of the extended attributes of the named
+// file.
+func (fs *FileSystem) Listxattr(name string) ([]string, error) {
+	for {
+		size, err := unix.Listxattr(name, nil)
+		if err != nil {
+			return nil, &os.PathError{Op: "listxattr", Path: name, Err: err}
+		}
+		if size == 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size)
+		n, err := unix.Listxattr(name, buf)
+		if err == unix.ERANGE {
+			continue
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "listxattr", Path: name, Err: err}
+		}
+
+		var attrs []string
+		for _, attr := range bytes.Split(buf[:n], []byte{0}) {
+			if len(attr) != 0 {
+				attrs = append(attrs, string(attr))
+			}
+		}
+		return attrs, nil
+	}
+}
+
+// Removexattr removes the extended attribute attr of the named file.
+func (fs *FileSystem) Removexattr(name, attr string) error {
+	if err := unix.Removexattr(name, attr); err != nil {
+		return &os.PathError{Op: "removexattr", Path: name, Err: err}
+	}
+	return nil
+}
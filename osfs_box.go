@@ -80,6 +80,10 @@ func (b *Box) OSSymlink(oldname, newname string) error {
 	return b.osfs.Symlink(oldname, newname)
 }
 
+func (b *Box) OSLink(oldname, newname string) error {
+	return b.osfs.Link(oldname, newname)
+}
+
 // io/ioutil methods
 
 func (b *Box) OSReadFile(filename string) ([]byte, error) {
@@ -1,12 +1,19 @@
 package pandorasbox
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"text/template"
 	"time"
 
+	"github.com/awnumar/memguard"
 	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/vfs"
 )
 
 var box *Box
@@ -15,6 +22,130 @@ func InitGlobalBox() {
 	box = NewBox()
 }
 
+func Register(name string, fs *vfs.FileSystem) error {
+	return box.Register(name, fs)
+}
+
+func Unregister(name string) error {
+	return box.Unregister(name)
+}
+
+func HandleScheme(scheme string, fs *vfs.FileSystem) error {
+	return box.HandleScheme(scheme, fs)
+}
+
+func SetResolver(r Resolver) {
+	box.SetResolver(r)
+}
+
+func SetDurable(durable bool) {
+	box.SetDurable(durable)
+}
+
+func SetAtRestEncryption(key *memguard.Enclave, dirs ...string) error {
+	return box.SetAtRestEncryption(key, dirs...)
+}
+
+func SetSymlinkPolicy(policy absfs.SymlinkPolicy) {
+	box.SetSymlinkPolicy(policy)
+}
+
+func SetMemoryBudget(budget int64, dir string) error {
+	return box.SetMemoryBudget(budget, dir)
+}
+
+func SetTiering(threshold int64, dir string) error {
+	return box.SetTiering(threshold, dir)
+}
+
+func SetPolicy(fn vfs.Policy) {
+	box.SetPolicy(fn)
+}
+
+func SetMemoryPressure(p *vfs.MemoryPressure) error {
+	return box.SetMemoryPressure(p)
+}
+
+func SetWriteThrough(wt *vfs.WriteThrough) error {
+	return box.SetWriteThrough(wt)
+}
+
+func SetDedup(blockSize int) error {
+	return box.SetDedup(blockSize)
+}
+
+func Stats() vfs.Stats {
+	return box.Stats()
+}
+
+func Pin(name string) error {
+	return box.Pin(name)
+}
+
+func Unpin(name string) error {
+	return box.Unpin(name)
+}
+
+func Clone(src, dst string) error {
+	return box.Clone(src, dst)
+}
+
+func CopyAll(src, dst string) error {
+	return box.CopyAll(src, dst)
+}
+
+func Merge(other *Box, strategy MergeStrategy) error {
+	return box.Merge(other, strategy)
+}
+
+func Diff(from, to string, opts *DiffOptions) (*Changes, error) {
+	return box.Diff(from, to, opts)
+}
+
+func Sync(src, dst string, opts *SyncOptions) (*Changes, error) {
+	return box.Sync(src, dst, opts)
+}
+
+func Mirror(ctx context.Context, src, dst string, opts *MirrorOptions) error {
+	return box.Mirror(ctx, src, dst, opts)
+}
+
+func Backup(dir string, key *memguard.Enclave) (string, error) {
+	return box.Backup(dir, key)
+}
+
+func RunBackups(ctx context.Context, opts *BackupOptions) error {
+	return box.RunBackups(ctx, opts)
+}
+
+func Attest(root string, key ed25519.PrivateKey) (*SignedManifest, error) {
+	return box.Attest(root, key)
+}
+
+func VerifyAttestation(root string, sm *SignedManifest, key ed25519.PublicKey) error {
+	return box.VerifyAttestation(root, sm, key)
+}
+
+func LoadX509KeyPair(certFile, keyFile string) (tls.Certificate, error) {
+	return box.LoadX509KeyPair(certFile, keyFile)
+}
+
+func GetCertificate(certFile, keyFile string) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return box.GetCertificate(certFile, keyFile)
+}
+
+func TemplateFuncs() template.FuncMap {
+	return box.TemplateFuncs()
+}
+
+func RenderTemplate(dst, src string, data interface{}, perm os.FileMode) error {
+	return box.RenderTemplate(dst, src, data, perm)
+}
+
+func RenderHTMLTemplate(dst, src string, data interface{}, perm os.FileMode) error {
+	return box.RenderHTMLTemplate(dst, src, data, perm)
+}
+
 func Abs(path string) (string, error) {
 	return box.Abs(path)
 }
@@ -39,6 +170,10 @@ func Stat(name string) (os.FileInfo, error) {
 	return box.Stat(name)
 }
 
+func StatMany(paths []string) []vfs.StatResult {
+	return box.StatMany(paths)
+}
+
 func Chmod(name string, mode os.FileMode) error {
 	return box.Chmod(name, mode)
 }
@@ -51,6 +186,18 @@ func Chown(name string, uid, gid int) error {
 	return box.Chown(name, uid, gid)
 }
 
+func ChmodAll(root string, mode os.FileMode) error {
+	return box.ChmodAll(root, mode)
+}
+
+func ChownAll(root string, uid, gid int) error {
+	return box.ChownAll(root, uid, gid)
+}
+
+func ChtimesAll(root string, atime, mtime time.Time) error {
+	return box.ChtimesAll(root, atime, mtime)
+}
+
 func Separator(vfs bool) uint8 {
 	return box.Separator(vfs)
 }
@@ -108,13 +255,101 @@ func Readlink(name string) (string, error) {
 }
 
 func Symlink(oldname, newname string) error {
-	return box.Rename(oldname, newname)
+	return box.Symlink(oldname, newname)
+}
+
+func Copy(src, dst string) error {
+	return box.Copy(src, dst)
+}
+
+func CopyWithProgress(src, dst string, fn func(copied, total int64)) error {
+	return box.CopyWithProgress(src, dst, fn)
+}
+
+func CopyWithProgressContext(ctx context.Context, src, dst string, fn func(copied, total int64)) error {
+	return box.CopyWithProgressContext(ctx, src, dst, fn)
+}
+
+func SecureRemove(name string) error {
+	return box.SecureRemove(name)
+}
+
+func Ingest(osPath, vfsPath string) error {
+	return box.Ingest(osPath, vfsPath)
+}
+
+func Export(vfsPath, osPath string) error {
+	return box.Export(vfsPath, osPath)
+}
+
+func ExportAtomic(vfsPath, osPath string) error {
+	return box.ExportAtomic(vfsPath, osPath)
+}
+
+func Link(oldname, newname string) error {
+	return box.Link(oldname, newname)
+}
+
+func Getxattr(name, attr string) ([]byte, error) {
+	return box.Getxattr(name, attr)
+}
+
+func Setxattr(name, attr string, value []byte) error {
+	return box.Setxattr(name, attr, value)
+}
+
+func Listxattr(name string) ([]string, error) {
+	return box.Listxattr(name)
+}
+
+func Removexattr(name, attr string) error {
+	return box.Removexattr(name, attr)
+}
+
+func FS(root string) fs.FS {
+	return box.FS(root)
 }
 
 func Walk(root string, walkFn filepath.WalkFunc) error {
 	return box.Walk(root, walkFn)
 }
 
+func WalkParallel(root string, workers int, fn filepath.WalkFunc) error {
+	return box.WalkParallel(root, workers, fn)
+}
+
+func WalkDir(root string, fn fs.WalkDirFunc) error {
+	return box.WalkDir(root, fn)
+}
+
+func Watch(names ...string) (*Watcher, error) {
+	return box.Watch(names...)
+}
+
+func Glob(pattern string) ([]string, error) {
+	return box.Glob(pattern)
+}
+
+func OpenContext(ctx context.Context, name string) (absfs.File, error) {
+	return box.OpenContext(ctx, name)
+}
+
+func OpenFileContext(ctx context.Context, name string, flag int, perm os.FileMode) (absfs.File, error) {
+	return box.OpenFileContext(ctx, name, flag, perm)
+}
+
+func ReadFileContext(ctx context.Context, filename string) ([]byte, error) {
+	return box.ReadFileContext(ctx, filename)
+}
+
+func RemoveAllContext(ctx context.Context, path string, progress func(removed int)) error {
+	return box.RemoveAllContext(ctx, path, progress)
+}
+
+func WalkContext(ctx context.Context, root string, walkFn filepath.WalkFunc) error {
+	return box.WalkContext(ctx, root, walkFn)
+}
+
 // io/ioutil methods
 
 func ReadAll(r io.Reader) ([]byte, error) {
@@ -129,10 +364,42 @@ func WriteFile(filename string, data []byte, perm os.FileMode) error {
 	return box.WriteFile(filename, data, perm)
 }
 
+func WriteFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	return box.WriteFileAtomic(filename, data, perm)
+}
+
+func NewSectionReader(name string, off, n int64) (io.ReadSeekCloser, error) {
+	return box.NewSectionReader(name, off, n)
+}
+
 func ReadDir(dirname string) ([]os.FileInfo, error) {
 	return box.ReadDir(dirname)
 }
 
+func ReadDirMatch(dirname, pattern string) ([]os.FileInfo, error) {
+	return box.ReadDirMatch(dirname, pattern)
+}
+
+func ReadDirPlus(dirname string) ([]os.FileInfo, error) {
+	return box.ReadDirPlus(dirname)
+}
+
+func Tag(name, key, value string) error {
+	return box.Tag(name, key, value)
+}
+
+func Untag(name, key string) error {
+	return box.Untag(name, key)
+}
+
+func Tags(name string) (map[string]string, error) {
+	return box.Tags(name)
+}
+
+func FindByTag(key, value string) []string {
+	return box.FindByTag(key, value)
+}
+
 func TempFile(dir, prefix string) (absfs.File, error) {
 	return box.TempFile(dir, prefix)
 }
@@ -0,0 +1,50 @@
+package pandorasbox
+
+import (
+	"io"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+// ExportPGP writes the contents of the VFS to w as a tar archive encrypted
+// to recipients' OpenPGP public keys. The output is a binary OpenPGP
+// message that gpg --decrypt can read; wrap w with armor.Encode for ASCII
+// output.
+func (b *Box) ExportPGP(w io.Writer, recipients openpgp.EntityList) error {
+	return b.ExportPGPPaths(w, []string{VFSPrefix}, recipients)
+}
+
+// ExportPGPPaths is like ExportPGP, but only exports the files and
+// directories under paths, which may be VFS or OS paths.
+func (b *Box) ExportPGPPaths(w io.Writer, paths []string, recipients openpgp.EntityList) error {
+	hints := &openpgp.FileHints{IsBinary: true}
+	pw, err := openpgp.Encrypt(w, recipients, nil, hints, nil)
+	if err != nil {
+		return err
+	}
+	if err := b.writeTar(pw, paths); err != nil {
+		return err
+	}
+
+	return pw.Close()
+}
+
+// ImportPGP decrypts an OpenPGP encrypted tar archive, such as one written
+// by ExportPGP, with a private key from keyring and extracts it into dir.
+// If the message is signed, the signature is checked against keyring once
+// the archive has been read in full.
+func (b *Box) ImportPGP(r io.Reader, dir string, keyring openpgp.KeyRing) error {
+	md, err := openpgp.ReadMessage(r, keyring, nil, nil)
+	if err != nil {
+		return err
+	}
+	if err := b.readTar(md.UnverifiedBody, dir); err != nil {
+		return err
+	}
+	// drain the body so the MDC and any signature are checked
+	if _, err := io.Copy(io.Discard, md.UnverifiedBody); err != nil {
+		return err
+	}
+
+	return md.SignatureError
+}
@@ -0,0 +1,58 @@
+package pandorasbox
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+)
+
+func TestPGPRoundTrip(t *testing.T) {
+	b := NewBox()
+	if err := b.MkdirAll("vfs://secrets", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteFile("vfs://secrets/a", []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	entity, err := openpgp.NewEntity("box", "", "box@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := b.ExportPGP(&buf, openpgp.EntityList{entity}); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("hunter2")) {
+		t.Fatal("export holds the plaintext")
+	}
+	archive := buf.Bytes()
+
+	other, err := openpgp.NewEntity("other", "", "other@example.com", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewBox().ImportPGP(bytes.NewReader(archive), "vfs://restored", openpgp.EntityList{other}); err == nil {
+		t.Error("imported with the wrong key")
+	}
+
+	restored := NewBox()
+	if err := restored.ImportPGP(bytes.NewReader(archive), "vfs://restored", openpgp.EntityList{entity}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := restored.ReadFile("vfs://restored/secrets/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hunter2" {
+		t.Errorf("restored %q, want %q", data, "hunter2")
+	}
+
+	// flipping a byte of the ciphertext is caught by the MDC
+	tampered := append([]byte(nil), archive...)
+	tampered[len(tampered)-30] ^= 0xff
+	if err := NewBox().ImportPGP(bytes.NewReader(tampered), "vfs://restored", openpgp.EntityList{entity}); err == nil {
+		t.Error("imported a tampered archive")
+	}
+}
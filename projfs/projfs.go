@@ -0,0 +1,104 @@
+// Package projfs projects an absfs.FileSystem into a directory on Windows
+// with the Projected File System (ProjFS), so Explorer and native programs
+// can list and read the files of a box without a filesystem driver.
+//
+// The projection is read-only. Directories are listed and files are read
+// from the FileSystem on demand, when a program first touches them. ProjFS
+// keeps the contents of a file it has read on the host's disk, as a
+// hydrated placeholder, so the Provider deletes every file it hydrated
+// once the last handle to it is closed, and the file goes back to being
+// projected. Decrypted contents are still written to the disk while a
+// file is open, so don't project secrets that must never touch it. Files
+// programs create or change under the root are ordinary files on the
+// host, and aren't written to the FileSystem.
+//
+// ProjFS is an optional Windows feature, which is enabled with
+// Enable-WindowsOptionalFeature -Online -FeatureName Client-ProjFS. On
+// other platforms Start returns absfs.ErrNotImplemented.
+package projfs
+
+import (
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// Provider projects a FileSystem into a directory on the host.
+type Provider struct {
+	fs   absfs.FileSystem
+	root string
+
+	mtx sync.Mutex
+	// enums holds the directory enumerations in progress, by the ID
+	// ProjFS gave them
+	enums map[[16]byte]*enumeration
+
+	sys providerSys
+}
+
+// enumeration is a directory listing ProjFS is reading.
+type enumeration struct {
+	dir     string
+	pattern string
+	entries []os.FileInfo
+	next    int
+}
+
+// resolve returns the path in p.fs of name, a path relative to the
+// projection root with \ separators, along with its FileInfo. Windows
+// names are case-insensitive, so every element of name is matched against
+// the directory entries regardless of case if it doesn't match exactly.
+func (p *Provider) resolve(name string) (string, os.FileInfo, error) {
+	name = "/" + strings.ReplaceAll(name, `\`, "/")
+	if fi, err := p.fs.Stat(name); err == nil {
+		return path.Clean(name), fi, nil
+	}
+
+	resolved := "/"
+	fi, err := p.fs.Stat(resolved)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, elem := range strings.Split(strings.Trim(path.Clean(name), "/"), "/") {
+		entries, err := p.list(resolved)
+		if err != nil {
+			return "", nil, err
+		}
+		var found bool
+		for _, entry := range entries {
+			if strings.EqualFold(entry.Name(), elem) {
+				resolved, fi, found = path.Join(resolved, entry.Name()), entry, true
+				break
+			}
+		}
+		if !found {
+			return "", nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+		}
+	}
+
+	return resolved, fi, nil
+}
+
+// list returns the entries of dir in the order ProjFS expects them in,
+// which compares names regardless of case.
+func (p *Provider) list(dir string) ([]os.FileInfo, error) {
+	f, err := p.fs.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries, err := f.Readdir(-1)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return strings.ToUpper(entries[i].Name()) < strings.ToUpper(entries[j].Name())
+	})
+
+	return entries, nil
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package projfs
+
+import (
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+type providerSys struct{}
+
+// Start is only supported on Windows.
+func Start(root string, fs absfs.FileSystem) (*Provider, error) {
+	return nil, absfs.ErrNotImplemented
+}
+
+// Stop is only supported on Windows.
+func (p *Provider) Stop() error {
+	return absfs.ErrNotImplemented
+}
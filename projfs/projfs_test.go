@@ -0,0 +1,61 @@
+package projfs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+func TestResolve(t *testing.T) {
+	fs := vfs.NewFS()
+	if err := fs.MkdirAll("/Docs/Notes", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/Docs/Notes/b.txt", "/Docs/Notes/A.txt", "/Docs/Notes/c.txt"} {
+		if err := ioutil.WriteFile(fs, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p := &Provider{fs: fs}
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"", "/"},
+		{`Docs`, "/Docs"},
+		{`docs\NOTES`, "/Docs/Notes"},
+		{`DOCS\notes\a.TXT`, "/Docs/Notes/A.txt"},
+		{`Docs\Notes\b.txt`, "/Docs/Notes/b.txt"},
+	}
+	for _, tt := range tests {
+		got, fi, err := p.resolve(tt.name)
+		if err != nil {
+			t.Errorf("resolve(%q): %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("resolve(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+		if fi == nil {
+			t.Errorf("resolve(%q) returned no FileInfo", tt.name)
+		}
+	}
+	if _, _, err := p.resolve(`Docs\missing`); !os.IsNotExist(err) {
+		t.Errorf("resolving a missing file: got %v, want %v", err, os.ErrNotExist)
+	}
+
+	entries, err := p.list("/Docs/Notes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range entries {
+		names = append(names, fi.Name())
+	}
+	if len(names) != 3 || names[0] != "A.txt" || names[1] != "b.txt" || names[2] != "c.txt" {
+		t.Errorf("list = %v, want [A.txt b.txt c.txt]", names)
+	}
+}
@@ -0,0 +1,498 @@
+//go:build windows
+
+package projfs
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+var (
+	modProjFS = windows.NewLazySystemDLL("ProjectedFSLib.dll")
+
+	procPrjMarkDirectoryAsPlaceholder = modProjFS.NewProc("PrjMarkDirectoryAsPlaceholder")
+	procPrjStartVirtualizing          = modProjFS.NewProc("PrjStartVirtualizing")
+	procPrjStopVirtualizing           = modProjFS.NewProc("PrjStopVirtualizing")
+	procPrjFillDirEntryBuffer         = modProjFS.NewProc("PrjFillDirEntryBuffer")
+	procPrjWritePlaceholderInfo       = modProjFS.NewProc("PrjWritePlaceholderInfo")
+	procPrjAllocateAlignedBuffer      = modProjFS.NewProc("PrjAllocateAlignedBuffer")
+	procPrjFreeAlignedBuffer          = modProjFS.NewProc("PrjFreeAlignedBuffer")
+	procPrjWriteFileData              = modProjFS.NewProc("PrjWriteFileData")
+	procPrjFileNameMatch              = modProjFS.NewProc("PrjFileNameMatch")
+	procPrjDeleteFile                 = modProjFS.NewProc("PrjDeleteFile")
+)
+
+// flags of prjCallbackData
+const (
+	prjCbDataFlagEnumRestartScan       = 0x1
+	prjCbDataFlagEnumReturnSingleEntry = 0x2
+)
+
+// notifications, which double as the bits of the notification mask
+const (
+	prjNotifyPreDelete                      = 0x10
+	prjNotifyPreRename                      = 0x20
+	prjNotifyPreSetHardlink                 = 0x40
+	prjNotifyFileHandleClosedNoModification = 0x200
+	prjNotifyFilePreConvertToFull           = 0x1000
+)
+
+// flags of PrjDeleteFile
+const (
+	prjUpdateAllowDirtyMetadata = 0x1
+	prjUpdateAllowReadOnly      = 0x20
+)
+
+// maxChunk is the most file data written to ProjFS at once.
+const maxChunk = 1 << 20
+
+// prjCallbackData is PRJ_CALLBACK_DATA.
+type prjCallbackData struct {
+	Size                           uint32
+	Flags                          uint32
+	NamespaceVirtualizationContext uintptr
+	CommandID                      int32
+	FileID                         windows.GUID
+	DataStreamID                   windows.GUID
+	FilePathName                   *uint16
+	VersionInfo                    uintptr
+	TriggeringProcessID            uint32
+	TriggeringProcessImageFileName *uint16
+	InstanceContext                uintptr
+}
+
+// prjFileBasicInfo is PRJ_FILE_BASIC_INFO.
+type prjFileBasicInfo struct {
+	IsDirectory    uint8
+	FileSize       int64
+	CreationTime   int64
+	LastAccessTime int64
+	LastWriteTime  int64
+	ChangeTime     int64
+	FileAttributes uint32
+}
+
+// prjPlaceholderInfo is PRJ_PLACEHOLDER_INFO, without extended
+// attributes, a security descriptor or alternate data streams.
+type prjPlaceholderInfo struct {
+	FileBasicInfo prjFileBasicInfo
+	EaInformation struct {
+		EaBufferSize    uint32
+		OffsetToFirstEa uint32
+	}
+	SecurityInformation struct {
+		SecurityBufferSize         uint32
+		OffsetToSecurityDescriptor uint32
+	}
+	StreamsInformation struct {
+		StreamsInfoBufferSize   uint32
+		OffsetToFirstStreamInfo uint32
+	}
+	VersionInfo struct {
+		ProviderID [128]byte
+		ContentID  [128]byte
+	}
+	VariableData [1]byte
+}
+
+// prjCallbacks is PRJ_CALLBACKS.
+type prjCallbacks struct {
+	StartDirectoryEnumeration uintptr
+	EndDirectoryEnumeration   uintptr
+	GetDirectoryEnumeration   uintptr
+	GetPlaceholderInfo        uintptr
+	GetFileData               uintptr
+	QueryFileName             uintptr
+	Notification              uintptr
+	CancelCommand             uintptr
+}
+
+// prjNotificationMapping is PRJ_NOTIFICATION_MAPPING.
+type prjNotificationMapping struct {
+	NotificationBitMask uint32
+	NotificationRoot    *uint16
+}
+
+// prjStartVirtualizingOptions is PRJ_STARTVIRTUALIZING_OPTIONS.
+type prjStartVirtualizingOptions struct {
+	Flags                     uint32
+	PoolThreadCount           uint32
+	ConcurrentThreadCount     uint32
+	NotificationMappings      *prjNotificationMapping
+	NotificationMappingsCount uint32
+}
+
+// callbacks can't be freed, so they are created once and shared by every
+// Provider, which they find by the instance context ProjFS passes them.
+var callbacks = prjCallbacks{
+	StartDirectoryEnumeration: windows.NewCallback(startDirectoryEnumeration),
+	EndDirectoryEnumeration:   windows.NewCallback(endDirectoryEnumeration),
+	GetDirectoryEnumeration:   windows.NewCallback(getDirectoryEnumeration),
+	GetPlaceholderInfo:        windows.NewCallback(getPlaceholderInfo),
+	GetFileData:               windows.NewCallback(getFileData),
+	Notification:              windows.NewCallback(notification),
+}
+
+var (
+	providers  sync.Map
+	providerID uintptr
+)
+
+type providerSys struct {
+	id      uintptr
+	ctx     uintptr
+	mapping prjNotificationMapping
+}
+
+// Start projects fs into root, and returns the Provider serving it. If
+// root doesn't exist it's created and marked as a virtualization root;
+// otherwise it must be the root of an earlier projection.
+func Start(root string, fs absfs.FileSystem) (*Provider, error) {
+	if err := modProjFS.Load(); err != nil {
+		return nil, &os.PathError{Op: "projfs", Path: root, Err: err}
+	}
+	rootp, err := windows.UTF16PtrFromString(root)
+	if err != nil {
+		return nil, &os.PathError{Op: "projfs", Path: root, Err: err}
+	}
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		if err := os.MkdirAll(root, 0700); err != nil {
+			return nil, err
+		}
+		id, err := windows.GenerateGUID()
+		if err == nil {
+			r, _, _ := procPrjMarkDirectoryAsPlaceholder.Call(uintptr(unsafe.Pointer(rootp)), 0, 0, uintptr(unsafe.Pointer(&id)))
+			err = hresultError(r)
+		}
+		if err != nil {
+			os.Remove(root)
+			return nil, &os.PathError{Op: "projfs", Path: root, Err: err}
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	p := &Provider{
+		fs:    fs,
+		root:  root,
+		enums: make(map[[16]byte]*enumeration),
+	}
+	p.sys.id = atomic.AddUintptr(&providerID, 1)
+	p.sys.mapping = prjNotificationMapping{
+		NotificationBitMask: prjNotifyPreDelete | prjNotifyPreRename | prjNotifyPreSetHardlink |
+			prjNotifyFileHandleClosedNoModification | prjNotifyFilePreConvertToFull,
+		NotificationRoot: new(uint16),
+	}
+	opts := prjStartVirtualizingOptions{
+		NotificationMappings:      &p.sys.mapping,
+		NotificationMappingsCount: 1,
+	}
+	providers.Store(p.sys.id, p)
+	r, _, _ := procPrjStartVirtualizing.Call(
+		uintptr(unsafe.Pointer(rootp)),
+		uintptr(unsafe.Pointer(&callbacks)),
+		p.sys.id,
+		uintptr(unsafe.Pointer(&opts)),
+		uintptr(unsafe.Pointer(&p.sys.ctx)),
+	)
+	if err := hresultError(r); err != nil {
+		providers.Delete(p.sys.id)
+		return nil, &os.PathError{Op: "projfs", Path: root, Err: err}
+	}
+
+	return p, nil
+}
+
+// Stop stops projecting. Files that are still open aren't deleted once
+// they are closed, so their contents stay on the disk. root is left as a
+// virtualization root that Start can project into again.
+func (p *Provider) Stop() error {
+	procPrjStopVirtualizing.Call(p.sys.ctx)
+	providers.Delete(p.sys.id)
+
+	return nil
+}
+
+func lookupProvider(data *prjCallbackData) *Provider {
+	p, ok := providers.Load(data.InstanceContext)
+	if !ok {
+		return nil
+	}
+	return p.(*Provider)
+}
+
+func enumKey(id *windows.GUID) [16]byte {
+	return *(*[16]byte)(unsafe.Pointer(id))
+}
+
+func startDirectoryEnumeration(data *prjCallbackData, id *windows.GUID) uintptr {
+	p := lookupProvider(data)
+	if p == nil {
+		return uintptr(windows.E_INVALIDARG)
+	}
+	dir, _, err := p.resolve(windows.UTF16PtrToString(data.FilePathName))
+	if err != nil {
+		return hresultFromError(err)
+	}
+	entries, err := p.list(dir)
+	if err != nil {
+		return hresultFromError(err)
+	}
+
+	p.mtx.Lock()
+	p.enums[enumKey(id)] = &enumeration{dir: dir, entries: entries, next: -1}
+	p.mtx.Unlock()
+
+	return uintptr(windows.S_OK)
+}
+
+func endDirectoryEnumeration(data *prjCallbackData, id *windows.GUID) uintptr {
+	p := lookupProvider(data)
+	if p == nil {
+		return uintptr(windows.E_INVALIDARG)
+	}
+
+	p.mtx.Lock()
+	delete(p.enums, enumKey(id))
+	p.mtx.Unlock()
+
+	return uintptr(windows.S_OK)
+}
+
+func getDirectoryEnumeration(data *prjCallbackData, id *windows.GUID, pattern *uint16, buffer uintptr) uintptr {
+	p := lookupProvider(data)
+	if p == nil {
+		return uintptr(windows.E_INVALIDARG)
+	}
+	p.mtx.Lock()
+	e := p.enums[enumKey(id)]
+	p.mtx.Unlock()
+	if e == nil {
+		return uintptr(windows.E_INVALIDARG)
+	}
+
+	// the search expression is set by the first call, and can only be
+	// changed by restarting the enumeration
+	if e.next < 0 || data.Flags&prjCbDataFlagEnumRestartScan != 0 {
+		e.next = 0
+		e.pattern = ""
+		if pattern != nil {
+			e.pattern = windows.UTF16PtrToString(pattern)
+		}
+	}
+	var patternp *uint16
+	if e.pattern != "" {
+		patternp, _ = windows.UTF16PtrFromString(e.pattern)
+	}
+
+	var filled int
+	for ; e.next < len(e.entries); e.next++ {
+		fi := e.entries[e.next]
+		name, err := windows.UTF16PtrFromString(fi.Name())
+		if err != nil {
+			continue
+		}
+		if patternp != nil {
+			if match, _, _ := procPrjFileNameMatch.Call(uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(patternp))); match&0xff == 0 {
+				continue
+			}
+		}
+		info := basicInfo(fi)
+		r, _, _ := procPrjFillDirEntryBuffer.Call(uintptr(unsafe.Pointer(name)), uintptr(unsafe.Pointer(&info)), buffer)
+		if int32(r) < 0 {
+			// the buffer is full, the rest are returned by the next call
+			if filled == 0 {
+				return r
+			}
+			break
+		}
+		filled++
+		if data.Flags&prjCbDataFlagEnumReturnSingleEntry != 0 {
+			e.next++
+			break
+		}
+	}
+
+	return uintptr(windows.S_OK)
+}
+
+func getPlaceholderInfo(data *prjCallbackData) uintptr {
+	p := lookupProvider(data)
+	if p == nil {
+		return uintptr(windows.E_INVALIDARG)
+	}
+	name, fi, err := p.resolve(windows.UTF16PtrToString(data.FilePathName))
+	if err != nil {
+		return hresultFromError(err)
+	}
+	// ProjFS records names as the provider spells them
+	dest, err := windows.UTF16PtrFromString(strings.ReplaceAll(strings.TrimPrefix(name, "/"), "/", `\`))
+	if err != nil {
+		return uintptr(windows.E_INVALIDARG)
+	}
+
+	var info prjPlaceholderInfo
+	info.FileBasicInfo = basicInfo(fi)
+	r, _, _ := procPrjWritePlaceholderInfo.Call(
+		data.NamespaceVirtualizationContext,
+		uintptr(unsafe.Pointer(dest)),
+		uintptr(unsafe.Pointer(&info)),
+		unsafe.Sizeof(info),
+	)
+
+	return r
+}
+
+func getFileData(data *prjCallbackData, offset uint64, length uint32) uintptr {
+	p := lookupProvider(data)
+	if p == nil {
+		return uintptr(windows.E_INVALIDARG)
+	}
+	name, _, err := p.resolve(windows.UTF16PtrToString(data.FilePathName))
+	if err != nil {
+		return hresultFromError(err)
+	}
+	f, err := p.fs.Open(name)
+	if err != nil {
+		return hresultFromError(err)
+	}
+	defer f.Close()
+
+	size := length
+	if size > maxChunk {
+		size = maxChunk
+	}
+	r, _, _ := procPrjAllocateAlignedBuffer.Call(data.NamespaceVirtualizationContext, uintptr(size))
+	if r == 0 {
+		return uintptr(windows.E_OUTOFMEMORY)
+	}
+	buf := *(*unsafe.Pointer)(unsafe.Pointer(&r))
+	defer procPrjFreeAlignedBuffer.Call(r)
+	chunk := unsafe.Slice((*byte)(buf), size)
+	defer seal.Wipe(chunk)
+
+	for length > 0 {
+		n := size
+		if length < n {
+			n = length
+		}
+		if _, err := f.ReadAt(chunk[:n], int64(offset)); err != nil && err != io.EOF {
+			return hresultFromError(err)
+		}
+		r, _, _ := procPrjWriteFileData.Call(
+			data.NamespaceVirtualizationContext,
+			uintptr(unsafe.Pointer(&data.DataStreamID)),
+			uintptr(buf),
+			uintptr(offset),
+			uintptr(n),
+		)
+		if int32(r) < 0 {
+			return r
+		}
+		offset += uint64(n)
+		length -= n
+	}
+
+	return uintptr(windows.S_OK)
+}
+
+func notification(data *prjCallbackData, isDirectory uintptr, n uintptr, dest *uint16, params uintptr) uintptr {
+	p := lookupProvider(data)
+	if p == nil {
+		return uintptr(windows.E_INVALIDARG)
+	}
+
+	switch uint32(n) {
+	case prjNotifyPreDelete, prjNotifyPreRename, prjNotifyPreSetHardlink, prjNotifyFilePreConvertToFull:
+		// the projection is read-only
+		return hresultFromWin32(windows.ERROR_ACCESS_DENIED)
+	case prjNotifyFileHandleClosedNoModification:
+		if uint8(isDirectory) == 0 {
+			// calling back into ProjFS while it waits on a callback
+			// can deadlock
+			go p.dehydrate(windows.UTF16PtrToString(data.FilePathName))
+		}
+	}
+
+	return uintptr(windows.S_OK)
+}
+
+// dehydrate deletes the contents ProjFS cached on the disk for name, so
+// it's projected again. It fails if name is still open, and is tried
+// again when the next handle to it is closed.
+func (p *Provider) dehydrate(name string) {
+	namep, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return
+	}
+	var reason uint32
+	procPrjDeleteFile.Call(
+		p.sys.ctx,
+		uintptr(unsafe.Pointer(namep)),
+		prjUpdateAllowDirtyMetadata|prjUpdateAllowReadOnly,
+		uintptr(unsafe.Pointer(&reason)),
+	)
+}
+
+// basicInfo returns the PRJ_FILE_BASIC_INFO of fi. Projected files are
+// read-only.
+func basicInfo(fi os.FileInfo) prjFileBasicInfo {
+	mtime := filetime(fi.ModTime())
+	info := prjFileBasicInfo{
+		FileSize:       fi.Size(),
+		CreationTime:   mtime,
+		LastAccessTime: mtime,
+		LastWriteTime:  mtime,
+		ChangeTime:     mtime,
+		FileAttributes: windows.FILE_ATTRIBUTE_READONLY,
+	}
+	if fi.IsDir() {
+		info.IsDirectory = 1
+		info.FileSize = 0
+		info.FileAttributes = windows.FILE_ATTRIBUTE_DIRECTORY
+	}
+
+	return info
+}
+
+func filetime(t time.Time) int64 {
+	ft := windows.NsecToFiletime(t.UnixNano())
+	return int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime)
+}
+
+func hresultFromWin32(errno syscall.Errno) uintptr {
+	return uintptr(0x80070000 | uint32(errno)&0xffff)
+}
+
+// hresultFromError returns the HRESULT a callback fails with for err.
+func hresultFromError(err error) uintptr {
+	switch {
+	case os.IsNotExist(err):
+		return hresultFromWin32(windows.ERROR_FILE_NOT_FOUND)
+	case os.IsPermission(err):
+		return hresultFromWin32(windows.ERROR_ACCESS_DENIED)
+	}
+	return uintptr(windows.E_INVALIDARG)
+}
+
+// hresultError returns the error a ProjFS function failed with, or nil.
+func hresultError(r uintptr) error {
+	if int32(r) >= 0 {
+		return nil
+	}
+	if r&0xffff0000 == 0x80070000 {
+		return syscall.Errno(r & 0xffff)
+	}
+	return syscall.Errno(r)
+}
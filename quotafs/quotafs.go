@@ -0,0 +1,319 @@
+// Package quotafs provides an absfs middleware enforcing byte, inode and
+// operation rate budgets on any absfs.FileSystem.
+package quotafs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"golang.org/x/time/rate"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// Limits are the budgets enforced by a Quota. A zero value disables the
+// corresponding limit.
+type Limits struct {
+	// Bytes is the maximum total size of regular files.
+	Bytes int64
+
+	// Inodes is the maximum number of files, directories and symlinks.
+	Inodes int64
+
+	// OpsPerSecond is the sustained rate of operations allowed, and Burst
+	// the number of operations that may be performed at once. Operations
+	// over the rate fail with syscall.EAGAIN.
+	OpsPerSecond float64
+	Burst        int
+
+	// Err is the error returned when the Bytes or Inodes budget would be
+	// exceeded. It defaults to absfs.ErrQuotaExceeded, which matches
+	// syscall.EDQUOT; set it to syscall.ENOSPC to make the FileSystem look
+	// like a full device instead.
+	Err error
+}
+
+// Usage is the amount of each budget consumed.
+type Usage struct {
+	Bytes  int64
+	Inodes int64
+}
+
+// Quota is an absfs.Middleware enforcing Limits on a FileSystem. Usage is
+// only tracked for operations that pass through the middleware, call Scan
+// to account for data that already exists on the FileSystem.
+type Quota struct {
+	mtx sync.Mutex
+
+	fs      absfs.FileSystem
+	limits  Limits
+	usage   Usage
+	limiter *rate.Limiter
+
+	// growth approved in Before for operations that haven't completed
+	// yet, so concurrent operations can't each use the same headroom
+	reserved Usage
+
+	// changes recorded in Before and applied in After once the operation
+	// succeeds
+	pending map[*absfs.Call]*change
+}
+
+type change struct {
+	delta Usage
+
+	// growth reserved until the operation completes
+	reserved Usage
+
+	// size of the file before a write or truncate, the growth is measured
+	// once the operation is done
+	size int64
+}
+
+// FileSystem is an absfs.FileSystem with a Quota applied to it.
+type FileSystem struct {
+	absfs.FileSystem
+	*Quota
+}
+
+// New returns fs with limits enforced on it.
+func New(fs absfs.FileSystem, limits Limits) *FileSystem {
+	q := NewQuota(fs, limits)
+	return &FileSystem{FileSystem: absfs.Chain(fs, q), Quota: q}
+}
+
+// NewQuota returns a Quota enforcing limits on fs, for use with
+// absfs.Chain(fs, ...).
+func NewQuota(fs absfs.FileSystem, limits Limits) *Quota {
+	if limits.Err == nil {
+		limits.Err = absfs.ErrQuotaExceeded
+	}
+	q := &Quota{fs: fs, limits: limits, pending: make(map[*absfs.Call]*change)}
+	if limits.OpsPerSecond > 0 {
+		burst := limits.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		q.limiter = rate.NewLimiter(rate.Limit(limits.OpsPerSecond), burst)
+	}
+
+	return q
+}
+
+// Usage returns the amount of each budget currently consumed.
+func (q *Quota) Usage() Usage {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	return q.usage
+}
+
+// Scan adds the usage of everything under root to the Quota.
+func (q *Quota) Scan(root string) error {
+	u, err := diskUsage(q.fs, root)
+	if err != nil {
+		return err
+	}
+
+	q.mtx.Lock()
+	q.usage.Bytes += u.Bytes
+	q.usage.Inodes += u.Inodes
+	q.mtx.Unlock()
+
+	return nil
+}
+
+func (q *Quota) Before(c *absfs.Call) error {
+	if q.limiter != nil && !q.limiter.Allow() {
+		return &os.PathError{Op: string(c.Op), Path: c.Path, Err: syscall.EAGAIN}
+	}
+
+	var (
+		growth  Usage
+		release Usage
+		ch      = &change{size: -1}
+	)
+	switch c.Op {
+	case absfs.OpOpen:
+		if c.Flag&absfs.O_CREATE == 0 && c.Flag&absfs.O_TRUNC == 0 {
+			return nil
+		}
+		info, err := q.fs.Lstat(c.Path)
+		if err != nil {
+			if c.Flag&absfs.O_CREATE == 0 {
+				return nil
+			}
+			growth.Inodes = 1
+		} else if c.Flag&absfs.O_TRUNC != 0 && info.Mode().IsRegular() {
+			release.Bytes = info.Size()
+		}
+	case absfs.OpMkdir, absfs.OpSymlink:
+		growth.Inodes = 1
+	case absfs.OpMkdirAll:
+		growth.Inodes = q.missingDirs(c.Path)
+	case absfs.OpRemove, absfs.OpRemoveAll:
+		release, _ = diskUsage(q.fs, c.Path)
+	case absfs.OpRename:
+		if info, err := q.fs.Lstat(c.NewPath); err == nil && !info.IsDir() {
+			release = usageOf(info)
+		}
+	case absfs.OpTruncate:
+		info, err := q.fs.Stat(c.Path)
+		if err != nil {
+			return nil
+		}
+		ch.size = info.Size()
+		growth.Bytes = c.Size - ch.size
+	case absfs.OpWrite, absfs.OpFtruncate:
+		info, err := c.File.Stat()
+		if err != nil {
+			return nil
+		}
+		ch.size = info.Size()
+		end := c.Size
+		if c.Op == absfs.OpWrite {
+			off := c.Offset
+			if off < 0 {
+				if off, err = c.File.Seek(0, io.SeekCurrent); err != nil {
+					return nil
+				}
+			}
+			end += off
+		}
+		growth.Bytes = end - ch.size
+	default:
+		return nil
+	}
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	used := Usage{Bytes: q.usage.Bytes + q.reserved.Bytes, Inodes: q.usage.Inodes + q.reserved.Inodes}
+	if growth.Bytes > 0 && q.limits.Bytes > 0 && used.Bytes+growth.Bytes-release.Bytes > q.limits.Bytes ||
+		growth.Inodes > 0 && q.limits.Inodes > 0 && used.Inodes+growth.Inodes-release.Inodes > q.limits.Inodes {
+		return &os.PathError{Op: string(c.Op), Path: c.Path, Err: q.limits.Err}
+	}
+
+	ch.delta = Usage{Inodes: growth.Inodes - release.Inodes, Bytes: -release.Bytes}
+	ch.reserved = Usage{Bytes: max(growth.Bytes, 0), Inodes: max(growth.Inodes, 0)}
+	q.reserved.Bytes += ch.reserved.Bytes
+	q.reserved.Inodes += ch.reserved.Inodes
+	q.pending[c] = ch
+
+	return nil
+}
+
+func (q *Quota) After(c *absfs.Call, err error) error {
+	q.mtx.Lock()
+	ch, ok := q.pending[c]
+	delete(q.pending, c)
+	q.mtx.Unlock()
+
+	if !ok {
+		return err
+	}
+	if err != nil && err != io.EOF {
+		q.mtx.Lock()
+		q.reserved.Bytes -= ch.reserved.Bytes
+		q.reserved.Inodes -= ch.reserved.Inodes
+		q.mtx.Unlock()
+		return err
+	}
+
+	if ch.size >= 0 {
+		var (
+			info    os.FileInfo
+			statErr error
+		)
+		if c.File != nil {
+			info, statErr = c.File.Stat()
+		} else {
+			info, statErr = q.fs.Stat(c.Path)
+		}
+		if statErr == nil {
+			ch.delta.Bytes += info.Size() - ch.size
+		}
+	}
+
+	// the reservation is swapped for the actual change at once, so the
+	// growth is accounted for all along
+	q.mtx.Lock()
+	q.reserved.Bytes -= ch.reserved.Bytes
+	q.reserved.Inodes -= ch.reserved.Inodes
+	q.usage.Bytes += ch.delta.Bytes
+	q.usage.Inodes += ch.delta.Inodes
+	if q.usage.Bytes < 0 {
+		q.usage.Bytes = 0
+	}
+	if q.usage.Inodes < 0 {
+		q.usage.Inodes = 0
+	}
+	q.mtx.Unlock()
+
+	return err
+}
+
+// missingDirs returns the number of directories MkdirAll would create.
+func (q *Quota) missingDirs(name string) int64 {
+	var n int64
+	for {
+		if _, err := q.fs.Stat(name); err == nil {
+			return n
+		}
+		n++
+
+		parent := filepath.Dir(name)
+		if parent == name {
+			return n
+		}
+		name = parent
+	}
+}
+
+func usageOf(info os.FileInfo) Usage {
+	u := Usage{Inodes: 1}
+	if info.Mode().IsRegular() {
+		u.Bytes = info.Size()
+	}
+
+	return u
+}
+
+// diskUsage returns the usage of name and, if it is a directory,
+// everything under it.
+func diskUsage(fs absfs.FileSystem, name string) (Usage, error) {
+	info, err := fs.Lstat(name)
+	if err != nil {
+		return Usage{}, err
+	}
+	u := usageOf(info)
+	if !info.IsDir() {
+		return u, nil
+	}
+
+	f, err := fs.Open(name)
+	if err != nil {
+		return u, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return u, err
+	}
+	for _, child := range names {
+		if child == "." || child == ".." {
+			continue
+		}
+		cu, err := diskUsage(fs, filepath.Join(name, child))
+		if err != nil {
+			return u, err
+		}
+		u.Bytes += cu.Bytes
+		u.Inodes += cu.Inodes
+	}
+
+	return u, nil
+}
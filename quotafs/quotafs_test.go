@@ -0,0 +1,141 @@
+package quotafs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+func isErrno(err error, errno syscall.Errno) bool {
+	perr, ok := err.(*os.PathError)
+	return ok && errors.Is(perr.Err, errno)
+}
+
+func TestBytes(t *testing.T) {
+	fs := New(vfs.NewFS(), Limits{Bytes: 10})
+
+	f, err := fs.Create("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("12345678")); err != nil {
+		t.Fatal(err)
+	}
+	if u := fs.Usage(); u.Bytes != 8 || u.Inodes != 1 {
+		t.Errorf("wrong usage: %+v", u)
+	}
+
+	// overwriting existing data doesn't use more space
+	if _, err := f.WriteAt([]byte("abcd"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("xyz")); !isErrno(err, syscall.EDQUOT) {
+		t.Fatalf("expected EDQUOT, got %v", err)
+	}
+	f.Close()
+
+	if err := fs.Remove("/file"); err != nil {
+		t.Fatal(err)
+	}
+	if u := fs.Usage(); u.Bytes != 0 || u.Inodes != 0 {
+		t.Errorf("wrong usage after remove: %+v", u)
+	}
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	// writes block in the backend until release is closed, so the quota
+	// has approved them but hasn't seen them complete
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	backend := absfs.Chain(vfs.NewFS(), absfs.Hooks{
+		BeforeFunc: func(c *absfs.Call) error {
+			select {
+			case <-release:
+				return nil
+			default:
+			}
+			if c.Op == absfs.OpWrite {
+				entered <- struct{}{}
+				<-release
+			}
+			return nil
+		},
+	})
+	fs := New(backend, Limits{Bytes: 10})
+
+	var files []absfs.File
+	for _, name := range []string{"/a", "/b", "/c"} {
+		f, err := fs.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		files = append(files, f)
+	}
+
+	var wg sync.WaitGroup
+	for _, f := range files[:2] {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := f.Write([]byte("1234")); err != nil {
+				t.Error(err)
+			}
+		}()
+		<-entered
+	}
+	if _, err := files[2].Write([]byte("1234")); !isErrno(err, syscall.EDQUOT) {
+		t.Errorf("writing past the reserved growth: got %v, want EDQUOT", err)
+	}
+	close(release)
+	wg.Wait()
+
+	if u := fs.Usage(); u.Bytes != 8 || u.Inodes != 3 {
+		t.Errorf("wrong usage: %+v", u)
+	}
+	if _, err := files[2].Write([]byte("12")); err != nil {
+		t.Errorf("writing into the released headroom: %v", err)
+	}
+}
+
+func TestInodes(t *testing.T) {
+	fs := New(vfs.NewFS(), Limits{Inodes: 2, Err: syscall.ENOSPC})
+
+	if err := fs.Mkdir("/a", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir("/b", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Create("/a/file"); !isErrno(err, syscall.ENOSPC) {
+		t.Fatalf("expected ENOSPC, got %v", err)
+	}
+	if err := fs.RemoveAll("/b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Create("/a/file"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpsPerSecond(t *testing.T) {
+	fs := New(vfs.NewFS(), Limits{OpsPerSecond: 1, Burst: 2})
+
+	for i := 0; i < 2; i++ {
+		if _, err := fs.Stat("/"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := fs.Stat("/"); !isErrno(err, syscall.EAGAIN) {
+		t.Fatalf("expected EAGAIN, got %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+// Package rbac grants access to a VFS by role.
+//
+// Roles are granted permissions on paths matching glob patterns, and
+// callers are given roles either in their vfs.Credentials or by being
+// assigned them by name. The Policy of an RBAC is set on a VFS with
+// SetPolicy, and callers are handed views of it made with As, or with View
+// for the credentials attached to a context.
+package rbac
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+// Perm is a set of permissions granted on paths.
+type Perm uint8
+
+const (
+	// Read allows opening files for reading, reading them, listing
+	// directories, reading metadata and symbolic links, and changing the
+	// working directory of a view, which only that view uses.
+	Read Perm = 1 << iota
+
+	// Write allows opening files for writing, writing, creating,
+	// truncating, removing, renaming and linking files, and changing their
+	// metadata.
+	Write
+
+	All = Read | Write
+)
+
+var (
+	ErrBadPattern = errors.New("invalid path pattern")
+	ErrBadRole    = errors.New("role names must not be empty")
+	ErrNoRole     = errors.New("role not defined")
+)
+
+// An RBAC holds roles, the permissions granted to them, and the roles
+// assigned to callers by name. It's safe for concurrent use, and changes
+// apply to the views of its Policy immediately.
+type RBAC struct {
+	mtx     sync.RWMutex
+	roles   map[string][]grant
+	members map[string]map[string]struct{}
+}
+
+type grant struct {
+	pattern string
+	perm    Perm
+}
+
+// New returns an RBAC with no roles.
+func New() *RBAC {
+	return &RBAC{
+		roles:   make(map[string][]grant),
+		members: make(map[string]map[string]struct{}),
+	}
+}
+
+// Define defines role with no permissions, if it isn't already.
+func (r *RBAC) Define(role string) error {
+	if role == "" {
+		return ErrBadRole
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if _, ok := r.roles[role]; !ok {
+		r.roles[role] = nil
+	}
+	return nil
+}
+
+// Delete deletes role, its permissions and its assignments.
+func (r *RBAC) Delete(role string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	delete(r.roles, role)
+	for name, roles := range r.members {
+		delete(roles, role)
+		if len(roles) == 0 {
+			delete(r.members, name)
+		}
+	}
+}
+
+// Roles returns the names of the defined roles, sorted.
+func (r *RBAC) Roles() []string {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	roles := make([]string, 0, len(r.roles))
+	for role := range r.roles {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+
+	return roles
+}
+
+// Grant grants perm to role on the absolute paths matching pattern, adding
+// to what it was granted on them before, and defines role if necessary.
+// Patterns are matched with path.Match, and a pattern ending in "/**" also
+// matches the directory before it and everything under it.
+func (r *RBAC) Grant(role, pattern string, perm Perm) error {
+	if role == "" {
+		return ErrBadRole
+	}
+	if err := checkPattern(pattern); err != nil {
+		return err
+	}
+
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	grants := r.roles[role]
+	for i := range grants {
+		if grants[i].pattern == pattern {
+			grants[i].perm |= perm
+			return nil
+		}
+	}
+	r.roles[role] = append(grants, grant{pattern: pattern, perm: perm})
+
+	return nil
+}
+
+// Revoke revokes perm from role on pattern.
+func (r *RBAC) Revoke(role, pattern string, perm Perm) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	grants, ok := r.roles[role]
+	if !ok {
+		return ErrNoRole
+	}
+	for i := range grants {
+		if grants[i].pattern != pattern {
+			continue
+		}
+		grants[i].perm &^= perm
+		if grants[i].perm == 0 {
+			r.roles[role] = append(grants[:i], grants[i+1:]...)
+		}
+		break
+	}
+
+	return nil
+}
+
+// Assign assigns roles to callers whose credentials have the name name.
+func (r *RBAC) Assign(name string, roles ...string) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	for _, role := range roles {
+		if _, ok := r.roles[role]; !ok {
+			return ErrNoRole
+		}
+	}
+	assigned := r.members[name]
+	if assigned == nil {
+		assigned = make(map[string]struct{})
+		r.members[name] = assigned
+	}
+	for _, role := range roles {
+		assigned[role] = struct{}{}
+	}
+
+	return nil
+}
+
+// Unassign removes roles from the callers named name.
+func (r *RBAC) Unassign(name string, roles ...string) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	assigned := r.members[name]
+	for _, role := range roles {
+		delete(assigned, role)
+	}
+	if len(assigned) == 0 {
+		delete(r.members, name)
+	}
+}
+
+// Allowed reports whether cred has every permission in perm on the
+// absolute path name, through the roles in cred or assigned to its name.
+func (r *RBAC) Allowed(cred vfs.Credentials, name string, perm Perm) bool {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+
+	var granted Perm
+	check := func(role string) {
+		for _, g := range r.roles[role] {
+			if match(g.pattern, name) {
+				granted |= g.perm
+			}
+		}
+	}
+	for _, role := range cred.Roles {
+		check(role)
+	}
+	for role := range r.members[cred.Name] {
+		check(role)
+	}
+
+	return granted&perm == perm
+}
+
+// Policy returns a vfs.Policy that allows operations the caller's roles
+// grant the permission for, and denies others with os.ErrPermission.
+func (r *RBAC) Policy() vfs.Policy {
+	return func(op absfs.Op, name string, cred vfs.Credentials) error {
+		if !r.Allowed(cred, name, opPerm(op)) {
+			return os.ErrPermission
+		}
+		return nil
+	}
+}
+
+// View returns a view of fs for the credentials attached to ctx with
+// NewContext, or the zero Credentials if there are none. fs must have the
+// Policy of an RBAC set for it to be enforced.
+func View(ctx context.Context, fs *vfs.FileSystem) absfs.FileSystem {
+	cred, _ := FromContext(ctx)
+	return fs.As(cred)
+}
+
+type credKey struct{}
+
+// NewContext returns a copy of ctx carrying cred.
+func NewContext(ctx context.Context, cred vfs.Credentials) context.Context {
+	return context.WithValue(ctx, credKey{}, cred)
+}
+
+// FromContext returns the credentials attached to ctx with NewContext.
+func FromContext(ctx context.Context) (vfs.Credentials, bool) {
+	cred, ok := ctx.Value(credKey{}).(vfs.Credentials)
+	return cred, ok
+}
+
+// opPerm returns the permission op requires.
+func opPerm(op absfs.Op) Perm {
+	switch op {
+	case absfs.OpOpen, absfs.OpStat, absfs.OpLstat, absfs.OpChdir, absfs.OpReadlink,
+		absfs.OpRead, absfs.OpSeek, absfs.OpClose, absfs.OpFstat, absfs.OpReaddir,
+		absfs.OpReaddirnames, absfs.OpGetxattr, absfs.OpListxattr:
+		return Read
+	}
+	return Write
+}
+
+func checkPattern(pattern string) error {
+	if !path.IsAbs(pattern) {
+		return ErrBadPattern
+	}
+	if _, err := path.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil {
+		return ErrBadPattern
+	}
+	return nil
+}
+
+// match reports whether name matches pattern, see Grant.
+func match(pattern, name string) bool {
+	if dir, ok := strings.CutSuffix(pattern, "/**"); ok {
+		if dir == "" {
+			return true
+		}
+		for ; name != "/"; name = path.Dir(name) {
+			if ok, _ := path.Match(dir, name); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
@@ -0,0 +1,115 @@
+package rbac
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+func TestRBAC(t *testing.T) {
+	fs := vfs.NewFS()
+	if err := fs.MkdirAll("/keys/tls", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/keys/tls/key.pem", []byte("key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir("/public", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	r := New()
+	if err := r.Grant("tls", "/keys/tls/**", Read); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Grant("web", "/public/**", All); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Grant("web", "/", Read); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Grant("web", "keys", Read); !errors.Is(err, ErrBadPattern) {
+		t.Errorf("granting on a relative pattern: got %v, want %v", err, ErrBadPattern)
+	}
+	if err := r.Assign("server", "tls", "web"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Assign("server", "admin"); !errors.Is(err, ErrNoRole) {
+		t.Errorf("assigning an undefined role: got %v, want %v", err, ErrNoRole)
+	}
+	fs.SetPolicy(r.Policy())
+
+	tls := fs.As(vfs.Credentials{Roles: []string{"tls"}})
+	if data, err := ioutil.ReadFile(tls, "/keys/tls/key.pem"); err != nil || string(data) != "key" {
+		t.Fatalf("reading as tls: %q, %v", data, err)
+	}
+	if err := ioutil.WriteFile(tls, "/keys/tls/key.pem", []byte("evil"), 0600); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("writing as tls: got %v, want %v", err, os.ErrPermission)
+	}
+
+	web := View(NewContext(context.Background(), vfs.Credentials{Roles: []string{"web"}}), fs)
+	if err := ioutil.WriteFile(web, "/public/index.html", []byte("hi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := web.Stat("/"); err != nil {
+		t.Error(err)
+	}
+	if _, err := web.Stat("/keys/tls/key.pem"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("stat as web: got %v, want %v", err, os.ErrPermission)
+	}
+	if err := web.Rename("/public/index.html", "/index.html"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("renaming out of /public as web: got %v, want %v", err, os.ErrPermission)
+	}
+	if err := web.Chdir("/keys/tls"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("changing into /keys/tls as web: got %v, want %v", err, os.ErrPermission)
+	}
+	if err := fs.Symlink("/keys/tls", "/public/tls"); err != nil {
+		t.Fatal(err)
+	}
+	if err := web.Chdir("/public/tls"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("changing into a link to /keys/tls as web: got %v, want %v", err, os.ErrPermission)
+	}
+	if _, err := web.Open("/public/tls/key.pem"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("opening through a link to /keys/tls as web: got %v, want %v", err, os.ErrPermission)
+	}
+	if err := web.Chdir("/public"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := web.Stat("index.html"); err != nil {
+		t.Error(err)
+	}
+	if wd, _ := fs.Getwd(); wd != "/" {
+		t.Errorf("owner's working directory = %q after web's Chdir, want /", wd)
+	}
+
+	server := fs.As(vfs.Credentials{Name: "server"})
+	if _, err := ioutil.ReadFile(server, "/keys/tls/key.pem"); err != nil {
+		t.Errorf("reading as server: %v", err)
+	}
+	if _, err := ioutil.ReadFile(server, "/public/index.html"); err != nil {
+		t.Errorf("reading as server: %v", err)
+	}
+
+	if err := r.Revoke("tls", "/keys/tls/**", Read); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadFile(tls, "/keys/tls/key.pem"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("reading after revoking: got %v, want %v", err, os.ErrPermission)
+	}
+	r.Delete("web")
+	if _, err := ioutil.ReadFile(server, "/public/index.html"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("reading after deleting role: got %v, want %v", err, os.ErrPermission)
+	}
+	if roles := r.Roles(); len(roles) != 1 || roles[0] != "tls" {
+		t.Errorf("roles are %q, want [tls]", roles)
+	}
+
+	anon := View(context.Background(), fs)
+	if _, err := anon.Stat("/"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("stat without credentials: got %v, want %v", err, os.ErrPermission)
+	}
+}
@@ -0,0 +1,230 @@
+package pandorasbox
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+var (
+	ErrBoxExists    = errors.New("a box with that name is already registered")
+	ErrNoSuchBox    = errors.New("no box with that name is registered")
+	ErrBadBoxName   = errors.New("box names must be non-empty and can't contain a path separator")
+	ErrBadScheme    = errors.New("invalid scheme")
+	ErrNoSuchScheme = errors.New("no VFS handles that scheme")
+	ErrSchemeExists = errors.New("scheme is already handled")
+	errCrossBoxOp   = errors.New("oldpath and newpath must be in the same box")
+)
+
+// A Resolver decides which VFS a path passed to a Box addresses.
+type Resolver interface {
+	// Resolve returns the VFS path addresses and the path within it. ok
+	// is false if path is a path on the host's filesystem. An error is
+	// returned if path addresses neither.
+	Resolve(path string) (fs *vfs.FileSystem, vfsPath string, ok bool, err error)
+}
+
+// Schemes is the Resolver used by a Box by default. It maps URL-style
+// scheme prefixes, such as vfs:// or mem://, to VFSs. Under the vfs://
+// scheme, a first path element that is a name registered with Register
+// selects the VFS registered under that name instead.
+type Schemes struct {
+	mtx     sync.RWMutex
+	schemes map[string]*vfs.FileSystem
+	named   map[string]*vfs.FileSystem
+}
+
+// NewSchemes returns Schemes that map the vfs:// scheme to fs.
+func NewSchemes(fs *vfs.FileSystem) *Schemes {
+	return &Schemes{
+		schemes: map[string]*vfs.FileSystem{VFSPrefix: fs},
+		named:   make(map[string]*vfs.FileSystem),
+	}
+}
+
+// Handle maps paths starting with scheme:// to fs. scheme may be given with
+// or without the trailing ://.
+func (s *Schemes) Handle(scheme string, fs *vfs.FileSystem) error {
+	prefix := strings.TrimSuffix(scheme, "://") + "://"
+	if !validScheme(prefix) {
+		return ErrBadScheme
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.schemes[prefix]; ok {
+		return ErrSchemeExists
+	}
+	s.schemes[prefix] = fs
+
+	return nil
+}
+
+// Register maps paths starting with vfs://name/ to fs.
+func (s *Schemes) Register(name string, fs *vfs.FileSystem) error {
+	if name == "" || strings.ContainsAny(name, "/\\") {
+		return ErrBadBoxName
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.named[name]; ok {
+		return ErrBoxExists
+	}
+	s.named[name] = fs
+
+	return nil
+}
+
+// Unregister removes the VFS registered under name.
+func (s *Schemes) Unregister(name string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.named[name]; !ok {
+		return ErrNoSuchBox
+	}
+	delete(s.named, name)
+
+	return nil
+}
+
+// Named returns the VFS registered under name.
+func (s *Schemes) Named(name string) (*vfs.FileSystem, bool) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	fs, ok := s.named[name]
+	return fs, ok
+}
+
+// Names returns the names VFSs are registered under, in sorted order.
+func (s *Schemes) Names() []string {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	names := make([]string, 0, len(s.named))
+	for name := range s.named {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Resolve returns the VFS a path starting with vfs:// or a scheme passed to
+// Handle addresses. Paths without a scheme are host paths, and paths with
+// any other scheme are rejected with ErrNoSuchScheme.
+func (s *Schemes) Resolve(path string) (*vfs.FileSystem, string, bool, error) {
+	prefix, vfsPath, ok := splitScheme(path)
+	if !ok {
+		return nil, path, false, nil
+	}
+
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	fs, ok := s.schemes[prefix]
+	if !ok {
+		return nil, path, false, ErrNoSuchScheme
+	}
+	if prefix != VFSPrefix {
+		return fs, vfsPath, true, nil
+	}
+
+	first := strings.TrimPrefix(vfsPath, "/")
+	rest := "/"
+	if i := strings.IndexByte(first, '/'); i >= 0 {
+		first, rest = first[:i], first[i:]
+	}
+	if named, found := s.named[first]; found {
+		return named, rest, true, nil
+	}
+
+	return fs, vfsPath, true, nil
+}
+
+// validScheme reports whether prefix is a URL scheme followed by ://.
+func validScheme(prefix string) bool {
+	scheme := strings.TrimSuffix(prefix, "://")
+	if scheme == "" || len(scheme) == len(prefix) {
+		return false
+	}
+	for i, c := range scheme {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case i > 0 && ('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// splitScheme splits a path like mem://dir/file into its scheme prefix
+// mem:// and the absolute VFS path /dir/file. It only checks the syntax of
+// the scheme; Resolve decides whether a VFS handles it.
+func splitScheme(path string) (prefix, vfsPath string, ok bool) {
+	i := strings.Index(path, "://")
+	if i < 0 || !validScheme(path[:i+3]) {
+		return "", path, false
+	}
+
+	return path[:i+3], "/" + path[i+3:], true
+}
+
+// makeSchemePath is MakeVFSPath for any scheme prefix.
+func makeSchemePath(prefix, path string) string {
+	schemePath := strings.Replace(path, "/", prefix, 1)
+	if schemePath == path {
+		schemePath = prefix + path
+	}
+
+	return schemePath
+}
+
+// SetResolver makes the Box use r to decide which VFS paths address.
+// Register and HandleScheme configure the default resolver, and have no
+// effect once another one is set.
+func (b *Box) SetResolver(r Resolver) {
+	b.mtx.Lock()
+	b.resolver = r
+	b.mtx.Unlock()
+}
+
+// Register adds fs to the Box under name, so paths starting with
+// vfs://name/ address files in fs. VFS paths whose first element isn't a
+// registered name keep addressing the Box's default VFS.
+func (b *Box) Register(name string, fs *vfs.FileSystem) error {
+	return b.schemes.Register(name, fs)
+}
+
+// Unregister removes the VFS registered under name.
+func (b *Box) Unregister(name string) error {
+	return b.schemes.Unregister(name)
+}
+
+// Named returns the VFS registered under name.
+func (b *Box) Named(name string) (*vfs.FileSystem, bool) {
+	return b.schemes.Named(name)
+}
+
+// HandleScheme makes paths starting with scheme:// address files in fs.
+func (b *Box) HandleScheme(scheme string, fs *vfs.FileSystem) error {
+	return b.schemes.Handle(scheme, fs)
+}
+
+// resolveVFS returns the VFS path addresses and the path within it.
+func (b *Box) resolveVFS(path string) (*vfs.FileSystem, string, bool, error) {
+	b.mtx.RLock()
+	r := b.resolver
+	b.mtx.RUnlock()
+
+	return r.Resolve(path)
+}
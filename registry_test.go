@@ -0,0 +1,161 @@
+package pandorasbox
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+func TestSchemesResolve(t *testing.T) {
+	def, secrets := vfs.NewFS(), vfs.NewFS()
+	s := NewSchemes(def)
+	if err := s.Register("secrets", secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path    string
+		fs      *vfs.FileSystem
+		vfsPath string
+		err     error
+	}{
+		{"vfs://", def, "/", nil},
+		{"vfs://db/password", def, "/db/password", nil},
+		{"vfs://secrets", secrets, "/", nil},
+		{"vfs://secrets/", secrets, "/", nil},
+		{"vfs://secrets/db/password", secrets, "/db/password", nil},
+		{"vfs://secretsdb/password", def, "/secretsdb/password", nil},
+		{"/etc/passwd", nil, "/etc/passwd", nil},
+		{"relative/path", nil, "relative/path", nil},
+		{"https://host/file", nil, "https://host/file", ErrNoSuchScheme},
+		{"vfss://file", nil, "vfss://file", ErrNoSuchScheme},
+	}
+	for _, tt := range tests {
+		fs, vfsPath, ok, err := s.Resolve(tt.path)
+		if err != tt.err {
+			t.Errorf("Resolve(%q): error %v, want %v", tt.path, err, tt.err)
+			continue
+		}
+		if fs != tt.fs || vfsPath != tt.vfsPath || ok != (tt.fs != nil) {
+			t.Errorf("Resolve(%q) = %p, %q, %v, want %p, %q", tt.path, fs, vfsPath, ok, tt.fs, tt.vfsPath)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	b := NewBox()
+	secrets := vfs.NewFS()
+	for _, name := range []string{"", "a/b", `a\b`} {
+		if err := b.Register(name, secrets); err != ErrBadBoxName {
+			t.Errorf("Register(%q): error %v, want %v", name, err, ErrBadBoxName)
+		}
+	}
+	if err := b.Register("secrets", secrets); err != nil {
+		t.Fatal(err)
+	}
+	if fs, ok := b.Named("secrets"); !ok || fs != secrets {
+		t.Errorf("Named returned %p, %v, want %p", fs, ok, secrets)
+	}
+	if _, ok := b.Named("missing"); ok {
+		t.Error("Named found a box that isn't registered")
+	}
+
+	// files in a registered VFS aren't in the default one
+	if err := b.WriteFile("vfs://secrets/key", []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := secrets.Stat("/key"); err != nil {
+		t.Errorf("file isn't in the registered VFS: %v", err)
+	}
+	if _, err := b.Stat("vfs://key"); err == nil {
+		t.Error("file is in the default VFS")
+	}
+
+	if err := b.Unregister("secrets"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Unregister("secrets"); err != ErrNoSuchBox {
+		t.Errorf("Unregister: error %v, want %v", err, ErrNoSuchBox)
+	}
+	if _, err := b.Stat("vfs://secrets/key"); err == nil {
+		t.Error("file is still reachable after Unregister")
+	}
+}
+
+func TestUnknownScheme(t *testing.T) {
+	b := NewBox()
+	if err := b.WriteFile("https://host/file", []byte("data"), 0600); !errors.Is(err, ErrNoSuchScheme) {
+		t.Errorf("WriteFile: error %v, want %v", err, ErrNoSuchScheme)
+	}
+	if _, err := b.Stat("https://host/file"); !errors.Is(err, ErrNoSuchScheme) {
+		t.Errorf("Stat: error %v, want %v", err, ErrNoSuchScheme)
+	}
+	if err := b.Rename("vfs://file", "https://host/file"); !errors.Is(err, ErrNoSuchScheme) {
+		t.Errorf("Rename: error %v, want %v", err, ErrNoSuchScheme)
+	}
+}
+
+func TestHandleScheme(t *testing.T) {
+	b := NewBox()
+	mem := vfs.NewPlainFS()
+	for _, scheme := range []string{"", "://", "1mem", "me m", "mem/x"} {
+		if err := b.HandleScheme(scheme, mem); err != ErrBadScheme {
+			t.Errorf("HandleScheme(%q): error %v, want %v", scheme, err, ErrBadScheme)
+		}
+	}
+	if err := b.HandleScheme("mem://", mem); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.MkdirAll("mem://cache/dir", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteFile("mem://cache/dir/file", []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.Stat("/cache/dir/file"); err != nil {
+		t.Errorf("file isn't in the scheme's VFS: %v", err)
+	}
+	if _, err := b.Stat("vfs://cache/dir/file"); err == nil {
+		t.Error("file is in the default VFS")
+	}
+	data, err := b.ReadFile("mem://cache/dir/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "data" {
+		t.Errorf("read %q, want %q", data, "data")
+	}
+
+	// a scheme can't move files to another VFS
+	if err := b.Rename("mem://cache/dir/file", "vfs://file"); !errors.Is(err, errCrossBoxOp) {
+		t.Errorf("Rename across schemes: error %v, want %v", err, errCrossBoxOp)
+	}
+	if _, err := b.Stat("file:///etc/passwd"); !errors.Is(err, ErrNoSuchScheme) {
+		t.Errorf("Stat with an unknown scheme: error %v, want %v", err, ErrNoSuchScheme)
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	b := NewBox()
+	first := vfs.NewFS()
+	if err := b.Register("secrets", first); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Register("secrets", vfs.NewFS()); err != ErrBoxExists {
+		t.Errorf("Register: error %v, want %v", err, ErrBoxExists)
+	}
+	if fs, _ := b.Named("secrets"); fs != first {
+		t.Error("registering a name twice replaced the first VFS")
+	}
+
+	if err := b.HandleScheme("mem", vfs.NewPlainFS()); err != nil {
+		t.Fatal(err)
+	}
+	for _, scheme := range []string{"mem", "mem://", "vfs"} {
+		if err := b.HandleScheme(scheme, vfs.NewPlainFS()); err != ErrSchemeExists {
+			t.Errorf("HandleScheme(%q): error %v, want %v", scheme, err, ErrSchemeExists)
+		}
+	}
+}
@@ -0,0 +1,412 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// Client is an absfs.FileSystem backed by a FileSystem served by a Server.
+type Client struct {
+	conn grpc.ClientConnInterface
+	ctx  context.Context
+
+	sep     uint8
+	listSep uint8
+}
+
+// NewClient returns a Client using conn, which must be connected to a
+// Server.
+func NewClient(conn grpc.ClientConnInterface) (*Client, error) {
+	c := &Client{conn: conn, ctx: context.Background()}
+
+	resp, err := c.call(&request{Op: opSeparators})
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.String) != 2 {
+		return nil, errors.New("remote: bad separators from server")
+	}
+	c.sep, c.listSep = resp.String[0], resp.String[1]
+
+	return c, nil
+}
+
+// WithContext returns a shallow copy of c whose calls, and the calls of
+// files opened with it, use ctx. Cancelling ctx aborts calls in flight, and
+// its deadline and metadata are sent to the server.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	if ctx == nil {
+		panic("remote: nil context")
+	}
+	c2 := *c
+	c2.ctx = ctx
+
+	return &c2
+}
+
+func method(name string) string {
+	return "/" + serviceName + "/" + name
+}
+
+// call performs req on the server, and returns the error the FileSystem
+// returned, or the transport error wrapped in a *os.PathError.
+func (c *Client) call(req *request) (*response, error) {
+	resp := new(response)
+	err := c.conn.Invoke(c.ctx, method("Call"), req, resp, grpc.CallContentSubtype(codecName))
+	if err != nil {
+		return resp, &os.PathError{Op: string(req.Op), Path: req.Path, Err: err}
+	}
+
+	return resp, resp.Err.error()
+}
+
+func (c *Client) Separator() uint8 {
+	return c.sep
+}
+
+func (c *Client) ListSeparator() uint8 {
+	return c.listSep
+}
+
+func (c *Client) Chdir(dir string) error {
+	_, err := c.call(&request{Op: absfs.OpChdir, Path: dir})
+	return err
+}
+
+func (c *Client) Getwd() (string, error) {
+	resp, err := c.call(&request{Op: opGetwd})
+	return resp.String, err
+}
+
+func (c *Client) TempDir() string {
+	resp, _ := c.call(&request{Op: opTempDir})
+	return resp.String
+}
+
+func (c *Client) Open(name string) (absfs.File, error) {
+	return c.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (c *Client) Create(name string) (absfs.File, error) {
+	return c.OpenFile(name, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0666)
+}
+
+func (c *Client) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	resp, err := c.call(&request{Op: absfs.OpOpen, Path: name, Flag: flag, Perm: perm})
+	if err != nil {
+		return &absfs.InvalidFile{Path: name}, err
+	}
+
+	return &File{c: c, name: name, handle: resp.Handle}, nil
+}
+
+func (c *Client) Mkdir(name string, perm os.FileMode) error {
+	_, err := c.call(&request{Op: absfs.OpMkdir, Path: name, Perm: perm})
+	return err
+}
+
+func (c *Client) MkdirAll(name string, perm os.FileMode) error {
+	_, err := c.call(&request{Op: absfs.OpMkdirAll, Path: name, Perm: perm})
+	return err
+}
+
+func (c *Client) Remove(name string) error {
+	_, err := c.call(&request{Op: absfs.OpRemove, Path: name})
+	return err
+}
+
+func (c *Client) RemoveAll(name string) error {
+	_, err := c.call(&request{Op: absfs.OpRemoveAll, Path: name})
+	return err
+}
+
+func (c *Client) Rename(oldpath, newpath string) error {
+	_, err := c.call(&request{Op: absfs.OpRename, Path: oldpath, NewPath: newpath})
+	return err
+}
+
+func (c *Client) Stat(name string) (os.FileInfo, error) {
+	resp, err := c.call(&request{Op: absfs.OpStat, Path: name})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Info, nil
+}
+
+func (c *Client) Lstat(name string) (os.FileInfo, error) {
+	resp, err := c.call(&request{Op: absfs.OpLstat, Path: name})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Info, nil
+}
+
+func (c *Client) Chmod(name string, mode os.FileMode) error {
+	_, err := c.call(&request{Op: absfs.OpChmod, Path: name, Perm: mode})
+	return err
+}
+
+func (c *Client) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	_, err := c.call(&request{Op: absfs.OpChtimes, Path: name, Atime: atime, Mtime: mtime})
+	return err
+}
+
+func (c *Client) Chown(name string, uid, gid int) error {
+	_, err := c.call(&request{Op: absfs.OpChown, Path: name, Uid: uid, Gid: gid})
+	return err
+}
+
+func (c *Client) Lchown(name string, uid, gid int) error {
+	_, err := c.call(&request{Op: absfs.OpLchown, Path: name, Uid: uid, Gid: gid})
+	return err
+}
+
+func (c *Client) Truncate(name string, size int64) error {
+	_, err := c.call(&request{Op: absfs.OpTruncate, Path: name, Size: size})
+	return err
+}
+
+func (c *Client) Readlink(name string) (string, error) {
+	resp, err := c.call(&request{Op: absfs.OpReadlink, Path: name})
+	return resp.String, err
+}
+
+func (c *Client) Symlink(oldname, newname string) error {
+	_, err := c.call(&request{Op: absfs.OpSymlink, Path: oldname, NewPath: newname})
+	return err
+}
+
+// File is a file opened on a remote FileSystem.
+type File struct {
+	c      *Client
+	name   string
+	handle uint64
+
+	mtx           sync.Mutex
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+func (f *File) call(req *request) (*response, error) {
+	req.Path = f.name
+	req.Handle = f.handle
+
+	return f.c.call(req)
+}
+
+func (f *File) Name() string {
+	return f.name
+}
+
+// SetDeadline sets the read and write deadlines of f, like
+// os.File.SetDeadline. Reads and writes still in flight when the deadline
+// passes fail with an error wrapping os.ErrDeadlineExceeded.
+func (f *File) SetDeadline(t time.Time) error {
+	f.mtx.Lock()
+	f.readDeadline = t
+	f.writeDeadline = t
+	f.mtx.Unlock()
+
+	return nil
+}
+
+// SetReadDeadline sets the deadline for future and in flight Read and
+// ReadAt calls.
+func (f *File) SetReadDeadline(t time.Time) error {
+	f.mtx.Lock()
+	f.readDeadline = t
+	f.mtx.Unlock()
+
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for future and in flight Write and
+// WriteAt calls.
+func (f *File) SetWriteDeadline(t time.Time) error {
+	f.mtx.Lock()
+	f.writeDeadline = t
+	f.mtx.Unlock()
+
+	return nil
+}
+
+// streamContext returns the context of a stream that must finish by
+// deadline, if deadline is set.
+func (f *File) streamContext(deadline *time.Time) (context.Context, context.CancelFunc) {
+	f.mtx.Lock()
+	d := *deadline
+	f.mtx.Unlock()
+
+	if d.IsZero() {
+		return context.WithCancel(f.c.ctx)
+	}
+	return context.WithDeadline(f.c.ctx, d)
+}
+
+// streamError returns the error of a failed stream, which is
+// os.ErrDeadlineExceeded if the stream's deadline passed.
+func streamError(ctx context.Context, err error) error {
+	if ctx.Err() == context.DeadlineExceeded && status.Code(err) == codes.DeadlineExceeded {
+		return os.ErrDeadlineExceeded
+	}
+	return err
+}
+
+// read streams len(p) bytes from the server into p, at off or at the
+// current offset if off is -1.
+func (f *File) read(p []byte, off int64) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	ctx, cancel := f.streamContext(&f.readDeadline)
+	defer cancel()
+
+	desc := &serviceDesc.Streams[0]
+	stream, err := f.c.conn.NewStream(ctx, desc, method(desc.StreamName), grpc.CallContentSubtype(codecName))
+	if err != nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: streamError(ctx, err)}
+	}
+	req := &chunk{Handle: f.handle, Offset: off, Size: int64(len(p))}
+	if err := stream.SendMsg(req); err != nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: streamError(ctx, err)}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: streamError(ctx, err)}
+	}
+
+	var n int
+	for {
+		c := new(chunk)
+		err := stream.RecvMsg(c)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, &os.PathError{Op: "read", Path: f.name, Err: streamError(ctx, err)}
+		}
+		if !c.valid() {
+			return n, &os.PathError{Op: "read", Path: f.name, Err: ErrChecksum}
+		}
+		n += copy(p[n:], c.Data)
+		if c.Err != nil {
+			return n, c.Err.error()
+		}
+	}
+}
+
+func (f *File) Read(p []byte) (int, error) {
+	return f.read(p, -1)
+}
+
+func (f *File) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	return f.read(b, off)
+}
+
+// write streams p to the server in chunks, at off or at the current offset
+// if off is -1.
+func (f *File) write(p []byte, off int64) (int, error) {
+	ctx, cancel := f.streamContext(&f.writeDeadline)
+	defer cancel()
+
+	desc := &serviceDesc.Streams[1]
+	stream, err := f.c.conn.NewStream(ctx, desc, method(desc.StreamName), grpc.CallContentSubtype(codecName))
+	if err != nil {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: streamError(ctx, err)}
+	}
+
+	for sent := 0; sent == 0 || sent < len(p); {
+		end := min(sent+ChunkSize, len(p))
+		c := &chunk{Handle: f.handle, Offset: off, Data: p[sent:end], Sum: sum(p[sent:end])}
+		// the server stops receiving after an error, which is returned
+		// by RecvMsg below
+		if err := stream.SendMsg(c); err != nil {
+			break
+		}
+		sent = end
+		if sent == len(p) {
+			break
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: streamError(ctx, err)}
+	}
+
+	var resp writeResponse
+	if err := stream.RecvMsg(&resp); err != nil {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: streamError(ctx, err)}
+	}
+
+	return int(resp.N), resp.Err.error()
+}
+
+func (f *File) Write(p []byte) (int, error) {
+	return f.write(p, -1)
+}
+
+func (f *File) WriteAt(b []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "writeat", Path: f.name, Err: errors.New("negative offset")}
+	}
+
+	return f.write(b, off)
+}
+
+func (f *File) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+func (f *File) Close() error {
+	_, err := f.call(&request{Op: absfs.OpClose})
+	return err
+}
+
+func (f *File) Sync() error {
+	_, err := f.call(&request{Op: absfs.OpSync})
+	return err
+}
+
+func (f *File) Stat() (os.FileInfo, error) {
+	resp, err := f.call(&request{Op: absfs.OpFstat})
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Info, nil
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	resp, err := f.call(&request{Op: absfs.OpSeek, Offset: offset, Whence: whence})
+	return resp.Offset, err
+}
+
+func (f *File) Truncate(size int64) error {
+	_, err := f.call(&request{Op: absfs.OpFtruncate, Size: size})
+	return err
+}
+
+func (f *File) Readdir(n int) ([]os.FileInfo, error) {
+	resp, err := f.call(&request{Op: absfs.OpReaddir, N: n})
+	return fileInfos(resp.Infos), err
+}
+
+func (f *File) Readdirnames(n int) ([]string, error) {
+	resp, err := f.call(&request{Op: absfs.OpReaddirnames, N: n})
+	return resp.Names, err
+}
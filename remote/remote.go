@@ -0,0 +1,308 @@
+// Package remote exposes an absfs.FileSystem over gRPC, so that one
+// hardened process can hold a box while other processes access it, usually
+// over localhost or a unix socket.
+//
+// Server serves a FileSystem on a *grpc.Server, and Client implements
+// absfs.FileSystem on top of a connection to it. File contents are
+// streamed in chunks of up to ChunkSize bytes, each sent with its SHA-256
+// hash, and Client.Upload and Client.Download resume transfers of large
+// files that were cut off. Messages are encoded with encoding/gob, so no
+// generated protobuf code is needed on either side.
+package remote
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// ChunkSize is the largest amount of file data sent in a single message.
+const ChunkSize = 32 * 1024
+
+const serviceName = "pandorasbox.remote.FileSystem"
+
+// Operations that aren't absfs operations.
+const (
+	opGetwd      absfs.Op = "getwd"
+	opTempDir    absfs.Op = "tempdir"
+	opSeparators absfs.Op = "separators"
+	opHash       absfs.Op = "hash"
+)
+
+// request is sent by Call for every operation other than reads and writes.
+type request struct {
+	Op      absfs.Op
+	Path    string
+	NewPath string
+	Flag    int
+	Perm    os.FileMode
+	Uid     int
+	Gid     int
+	Atime   time.Time
+	Mtime   time.Time
+	Size    int64
+	Offset  int64
+	Whence  int
+	N       int
+
+	// Handle identifies the open file for file operations.
+	Handle uint64
+}
+
+type response struct {
+	Handle uint64
+	Info   *fileInfo
+	Infos  []*fileInfo
+	Names  []string
+	String string
+	Offset int64
+	Err    *wireError
+
+	// Sum is the SHA-256 hash of the start of a file, see opHash.
+	Sum []byte
+}
+
+// chunk carries file data. The first chunk of a read or write names the
+// handle and offset, -1 meaning the current offset of the file.
+type chunk struct {
+	Handle uint64
+	Offset int64
+	Size   int64
+	Data   []byte
+	Err    *wireError
+
+	// Sum is the SHA-256 hash of Data. Receivers check it if it's set, so
+	// peers that don't send it still work.
+	Sum []byte
+}
+
+// ErrChecksum is returned for chunks whose data doesn't match their hash.
+var ErrChecksum = errors.New("remote: chunk checksum mismatch")
+
+// sum returns the SHA-256 hash of data.
+func sum(data []byte) []byte {
+	h := sha256.Sum256(data)
+	return h[:]
+}
+
+// valid reports whether the data of c matches its hash, if it has one.
+func (c *chunk) valid() bool {
+	return len(c.Sum) == 0 || bytes.Equal(c.Sum, sum(c.Data))
+}
+
+type writeResponse struct {
+	N   int64
+	Err *wireError
+}
+
+type fileInfo struct {
+	FName    string
+	FSize    int64
+	FMode    os.FileMode
+	FModTime time.Time
+}
+
+func newFileInfo(info os.FileInfo) *fileInfo {
+	if info == nil {
+		return nil
+	}
+
+	return &fileInfo{
+		FName:    info.Name(),
+		FSize:    info.Size(),
+		FMode:    info.Mode(),
+		FModTime: info.ModTime(),
+	}
+}
+
+func (i *fileInfo) Name() string       { return i.FName }
+func (i *fileInfo) Size() int64        { return i.FSize }
+func (i *fileInfo) Mode() os.FileMode  { return i.FMode }
+func (i *fileInfo) ModTime() time.Time { return i.FModTime }
+func (i *fileInfo) IsDir() bool        { return i.FMode.IsDir() }
+func (i *fileInfo) Sys() interface{}   { return nil }
+
+func fileInfos(infos []*fileInfo) []os.FileInfo {
+	if infos == nil {
+		return nil
+	}
+	list := make([]os.FileInfo, len(infos))
+	for i := range infos {
+		list[i] = infos[i]
+	}
+
+	return list
+}
+
+// wireError is the encoded form of an error returned by the FileSystem.
+// *os.PathError, *os.LinkError, syscall.Errno and the common sentinel
+// errors survive the round trip, so os.IsNotExist and friends keep working
+// on the client.
+type wireError struct {
+	Kind  string
+	Op    string
+	Path  string
+	New   string
+	Errno uintptr
+	Msg   string
+}
+
+var sentinels = []error{
+	io.EOF,
+	io.ErrUnexpectedEOF,
+	os.ErrInvalid,
+	os.ErrPermission,
+	os.ErrExist,
+	os.ErrNotExist,
+	os.ErrClosed,
+	absfs.ErrNotImplemented,
+	absfs.ErrNotDir,
+	absfs.ErrIsDir,
+	absfs.ErrNotEmpty,
+	absfs.ErrReadOnly,
+	absfs.ErrQuotaExceeded,
+	absfs.ErrLocked,
+	absfs.ErrBadFile,
+	absfs.ErrInvalid,
+	ErrChecksum,
+}
+
+func encodeError(err error) *wireError {
+	if err == nil {
+		return nil
+	}
+
+	switch e := err.(type) {
+	case *os.PathError:
+		we := encodeError(e.Err)
+		we.Kind, we.Op, we.Path = "path", e.Op, e.Path
+		return we
+	case *os.LinkError:
+		we := encodeError(e.Err)
+		we.Kind, we.Op, we.Path, we.New = "link", e.Op, e.Old, e.New
+		return we
+	case syscall.Errno:
+		return &wireError{Errno: uintptr(e)}
+	}
+
+	return &wireError{Msg: err.Error()}
+}
+
+func (we *wireError) error() error {
+	if we == nil {
+		return nil
+	}
+
+	var err error
+	if we.Errno != 0 {
+		err = syscall.Errno(we.Errno)
+	} else {
+		for _, s := range sentinels {
+			if s.Error() == we.Msg {
+				err = s
+				break
+			}
+		}
+		if err == nil {
+			err = errors.New(we.Msg)
+		}
+	}
+
+	switch we.Kind {
+	case "path":
+		return &os.PathError{Op: we.Op, Path: we.Path, Err: err}
+	case "link":
+		return &os.LinkError{Op: we.Op, Old: we.Path, New: we.New, Err: err}
+	}
+
+	return err
+}
+
+// codec encodes messages with encoding/gob.
+type codec struct{}
+
+const codecName = "gob"
+
+func (codec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (codec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func (codec) Name() string {
+	return codecName
+}
+
+func init() {
+	encoding.RegisterCodec(codec{})
+}
+
+// fileSystemServer is implemented by Server. It is the handler type of
+// serviceDesc.
+type fileSystemServer interface {
+	call(context.Context, *request) *response
+	read(*chunk, grpc.ServerStream) error
+	write(grpc.ServerStream) error
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*fileSystemServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Call",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := new(request)
+				if err := dec(req); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(fileSystemServer).call(ctx, req), nil
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/Call"}
+				handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(fileSystemServer).call(ctx, req.(*request)), nil
+				}
+				return interceptor(ctx, req, info, handler)
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Read",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				req := new(chunk)
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return srv.(fileSystemServer).read(req, stream)
+			},
+		},
+		{
+			StreamName:    "Write",
+			ClientStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(fileSystemServer).write(stream)
+			},
+		},
+	},
+}
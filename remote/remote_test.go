@@ -0,0 +1,216 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+func newClient(t *testing.T) *Client {
+	lis := bufconn.Listen(1024 * 1024)
+	gs := grpc.NewServer()
+	srv := NewServer(vfs.NewFS())
+	srv.Register(gs)
+	go gs.Serve(lis)
+	t.Cleanup(func() {
+		gs.Stop()
+		srv.Close()
+	})
+
+	dial := func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}
+	conn, err := grpc.Dial("bufnet", grpc.WithContextDialer(dial), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	c, err := NewClient(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+func TestClient(t *testing.T) {
+	c := newClient(t)
+
+	if c.Separator() != '/' {
+		t.Errorf("wrong separator: %q", c.Separator())
+	}
+	if err := c.MkdirAll("/a/b", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// larger than a chunk, so reads and writes are streamed
+	data := bytes.Repeat([]byte("0123456789abcdef"), ChunkSize/8)
+	if err := ioutil.WriteFile(c, "/a/b/file", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile(c, "/a/b/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("wrong contents: got %d bytes, expected %d", len(got), len(data))
+	}
+
+	f, err := c.Open("/a/b/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 6)
+	if _, err := f.ReadAt(buf, 10); err != nil {
+		t.Fatal(err)
+	}
+	if string(buf) != "abcdef" {
+		t.Errorf("wrong ReadAt contents: %q", buf)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := ioutil.ReadDir(c, "/a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0].Name() != "file" || names[0].Size() != int64(len(data)) {
+		t.Errorf("wrong directory listing: %v", names)
+	}
+
+	if _, err := c.Stat("/nope"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+	if _, err := f.Stat(); err == nil {
+		t.Error("expected error using closed file")
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	c := newClient(t)
+	if err := ioutil.WriteFile(c, "/file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cc := c.WithContext(ctx)
+	f, err := cc.Open("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+
+	if _, err := cc.Stat("/file"); status.Code(errors.Unwrap(err)) != codes.Canceled {
+		t.Errorf("Stat: expected Canceled, got %v", err)
+	}
+	if _, err := f.Read(make([]byte, 4)); status.Code(errors.Unwrap(err)) != codes.Canceled {
+		t.Errorf("Read: expected Canceled, got %v", err)
+	}
+	if _, err := c.Stat("/file"); err != nil {
+		t.Errorf("Stat on original client: %v", err)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	c := newClient(t)
+	if err := ioutil.WriteFile(c, "/file", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := c.OpenFile("/file", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	df := f.(absfs.DeadlineFile)
+	df.SetReadDeadline(time.Now().Add(-time.Second))
+	if _, err := f.Read(make([]byte, 4)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read: expected os.ErrDeadlineExceeded, got %v", err)
+	}
+	if _, err := f.WriteAt([]byte("DATA"), 0); err != nil {
+		t.Errorf("WriteAt: %v", err)
+	}
+
+	df.SetDeadline(time.Time{})
+	if _, err := f.ReadAt(make([]byte, 4), 0); err != nil {
+		t.Errorf("ReadAt after clearing deadline: %v", err)
+	}
+}
+
+func TestUploadDownload(t *testing.T) {
+	c := newClient(t)
+	data := bytes.Repeat([]byte("0123456789abcdef"), transferSize/8+100)
+
+	// an upload cut off after part of the file is resumed
+	if err := ioutil.WriteFile(c, "/file", data[:transferSize+5], 0600); err != nil {
+		t.Fatal(err)
+	}
+	n, err := c.Upload("/file", bytes.NewReader(data), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) {
+		t.Errorf("wrong size: %d, expected %d", n, len(data))
+	}
+	got, err := ioutil.ReadFile(c, "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("wrong contents after resumed upload")
+	}
+
+	// a file holding something else than the start of the upload is
+	// replaced
+	stale := append([]byte("stale"), data[5:transferSize+5]...)
+	if err := ioutil.WriteFile(c, "/file", stale, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Upload("/file", bytes.NewReader(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(c, "/file"); !bytes.Equal(got, data) {
+		t.Fatal("wrong contents after upload over a different file")
+	}
+
+	// a file larger than the upload is replaced
+	if _, err := c.Upload("/file", bytes.NewReader(data[:10]), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(c, "/file"); !bytes.Equal(got, data[:10]) {
+		t.Errorf("wrong contents after smaller upload: %q", got)
+	}
+
+	if _, err := c.Upload("/file", bytes.NewReader(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	buf.Write(data[:1000])
+	n, err = c.Download("/file", &buf, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)-1000) || !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("wrong resumed download: %d bytes", n)
+	}
+
+	if (&chunk{Data: []byte("data"), Sum: sum([]byte("date"))}).valid() {
+		t.Error("chunk with wrong hash is valid")
+	}
+}
@@ -0,0 +1,292 @@
+package remote
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"google.golang.org/grpc"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// Server serves an absfs.FileSystem to Clients. Files opened by clients
+// stay open on the server until they are closed by the client or Close is
+// called.
+type Server struct {
+	fs       absfs.FileSystem
+	sessions SessionFunc
+
+	mtx   sync.Mutex
+	files map[uint64]openFile
+	next  uint64
+}
+
+// A SessionFunc returns the FileSystem served for a call made with ctx,
+// and the ID of the session the call belongs to, or an error if the call
+// isn't allowed. It's called for every call, so sessions can expire or be
+// revoked at any time.
+type SessionFunc func(ctx context.Context) (fs absfs.FileSystem, id string, err error)
+
+// openFile is a file opened by a client, and the session it was opened in.
+type openFile struct {
+	f       absfs.File
+	session string
+}
+
+// NewServer returns a Server serving fs.
+func NewServer(fs absfs.FileSystem) *Server {
+	return &Server{
+		fs:    fs,
+		files: make(map[uint64]openFile),
+	}
+}
+
+// NewSessionServer returns a Server serving each call the FileSystem fn
+// returns for it. Files can only be used in the session they were opened
+// in.
+func NewSessionServer(fn SessionFunc) *Server {
+	return &Server{
+		sessions: fn,
+		files:    make(map[uint64]openFile),
+	}
+}
+
+// session returns the FileSystem and session of a call made with ctx.
+func (s *Server) session(ctx context.Context) (absfs.FileSystem, string, error) {
+	if s.sessions == nil {
+		return s.fs, "", nil
+	}
+	return s.sessions(ctx)
+}
+
+// Register registers the FileSystem service on gs. Authentication and
+// transport security are left to gs's options and interceptors.
+func (s *Server) Register(gs *grpc.Server) {
+	gs.RegisterService(&serviceDesc, s)
+}
+
+// Close closes all files that are still open.
+func (s *Server) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var err error
+	for h, f := range s.files {
+		if err1 := f.f.Close(); err == nil {
+			err = err1
+		}
+		delete(s.files, h)
+	}
+
+	return err
+}
+
+// file returns the file open as handle in session.
+func (s *Server) file(handle uint64, session string) (absfs.File, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	f, ok := s.files[handle]
+	if !ok || f.session != session {
+		return nil, syscall.EBADF
+	}
+
+	return f.f, nil
+}
+
+func (s *Server) call(ctx context.Context, req *request) *response {
+	var (
+		resp = new(response)
+		err  error
+	)
+
+	fs, session, err := s.session(ctx)
+	if err != nil {
+		resp.Err = encodeError(&os.PathError{Op: string(req.Op), Path: req.Path, Err: err})
+		return resp
+	}
+
+	switch req.Op {
+	case absfs.OpOpen:
+		var f absfs.File
+		f, err = fs.OpenFile(req.Path, req.Flag, req.Perm)
+		if err == nil {
+			s.mtx.Lock()
+			s.next++
+			resp.Handle = s.next
+			s.files[s.next] = openFile{f: f, session: session}
+			s.mtx.Unlock()
+		}
+	case absfs.OpMkdir:
+		err = fs.Mkdir(req.Path, req.Perm)
+	case absfs.OpMkdirAll:
+		err = fs.MkdirAll(req.Path, req.Perm)
+	case absfs.OpRemove:
+		err = fs.Remove(req.Path)
+	case absfs.OpRemoveAll:
+		err = fs.RemoveAll(req.Path)
+	case absfs.OpRename:
+		err = fs.Rename(req.Path, req.NewPath)
+	case absfs.OpStat:
+		var info os.FileInfo
+		info, err = fs.Stat(req.Path)
+		resp.Info = newFileInfo(info)
+	case absfs.OpLstat:
+		var info os.FileInfo
+		info, err = fs.Lstat(req.Path)
+		resp.Info = newFileInfo(info)
+	case absfs.OpChmod:
+		err = fs.Chmod(req.Path, req.Perm)
+	case absfs.OpChtimes:
+		err = fs.Chtimes(req.Path, req.Atime, req.Mtime)
+	case absfs.OpChown:
+		err = fs.Chown(req.Path, req.Uid, req.Gid)
+	case absfs.OpLchown:
+		err = fs.Lchown(req.Path, req.Uid, req.Gid)
+	case absfs.OpChdir:
+		err = fs.Chdir(req.Path)
+	case absfs.OpTruncate:
+		err = fs.Truncate(req.Path, req.Size)
+	case absfs.OpReadlink:
+		resp.String, err = fs.Readlink(req.Path)
+	case absfs.OpSymlink:
+		err = fs.Symlink(req.Path, req.NewPath)
+	case opGetwd:
+		resp.String, err = fs.Getwd()
+	case opTempDir:
+		resp.String = fs.TempDir()
+	case opSeparators:
+		resp.String = string([]byte{fs.Separator(), fs.ListSeparator()})
+	case opHash:
+		resp.Sum, err = hashPrefix(fs, req.Path, req.Size)
+	default:
+		err = s.fileCall(req, resp, session)
+	}
+	resp.Err = encodeError(err)
+
+	return resp
+}
+
+func (s *Server) fileCall(req *request, resp *response, session string) error {
+	f, err := s.file(req.Handle, session)
+	if err != nil {
+		return &os.PathError{Op: string(req.Op), Path: req.Path, Err: err}
+	}
+
+	switch req.Op {
+	case absfs.OpSeek:
+		resp.Offset, err = f.Seek(req.Offset, req.Whence)
+	case absfs.OpSync:
+		err = f.Sync()
+	case absfs.OpClose:
+		s.mtx.Lock()
+		delete(s.files, req.Handle)
+		s.mtx.Unlock()
+		err = f.Close()
+	case absfs.OpFstat:
+		var info os.FileInfo
+		info, err = f.Stat()
+		resp.Info = newFileInfo(info)
+	case absfs.OpFtruncate:
+		err = f.Truncate(req.Size)
+	case absfs.OpReaddir:
+		var infos []os.FileInfo
+		infos, err = f.Readdir(req.N)
+		for _, info := range infos {
+			resp.Infos = append(resp.Infos, newFileInfo(info))
+		}
+	case absfs.OpReaddirnames:
+		resp.Names, err = f.Readdirnames(req.N)
+	default:
+		err = &os.PathError{Op: string(req.Op), Path: req.Path, Err: absfs.ErrNotImplemented}
+	}
+
+	return err
+}
+
+// read streams up to req.Size bytes of a file to the client. Reads at the
+// current offset stop at the first short read, like a single call to Read.
+func (s *Server) read(req *chunk, stream grpc.ServerStream) error {
+	_, session, err := s.session(stream.Context())
+	if err != nil {
+		return stream.SendMsg(&chunk{Err: encodeError(&os.PathError{Op: "read", Err: err})})
+	}
+	f, err := s.file(req.Handle, session)
+	if err != nil {
+		return stream.SendMsg(&chunk{Err: encodeError(&os.PathError{Op: "read", Err: err})})
+	}
+
+	for read := int64(0); read < req.Size; {
+		buf := make([]byte, min(req.Size-read, ChunkSize))
+
+		var n int
+		if req.Offset < 0 {
+			n, err = f.Read(buf)
+		} else {
+			n, err = f.ReadAt(buf, req.Offset+read)
+		}
+		read += int64(n)
+
+		if err := stream.SendMsg(&chunk{Data: buf[:n], Err: encodeError(err), Sum: sum(buf[:n])}); err != nil {
+			return err
+		}
+		if err != nil || n == 0 || req.Offset < 0 && n < len(buf) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// write writes the chunks streamed by the client to a file.
+func (s *Server) write(stream grpc.ServerStream) error {
+	var (
+		resp   writeResponse
+		f      absfs.File
+		offset int64
+	)
+
+	for {
+		c := new(chunk)
+		err := stream.RecvMsg(c)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if f == nil {
+			_, session, err := s.session(stream.Context())
+			if err == nil {
+				f, err = s.file(c.Handle, session)
+			}
+			if err != nil {
+				resp.Err = encodeError(&os.PathError{Op: "write", Err: err})
+				break
+			}
+			offset = c.Offset
+		}
+		if !c.valid() {
+			resp.Err = encodeError(&os.PathError{Op: "write", Err: ErrChecksum})
+			break
+		}
+
+		var n int
+		if offset < 0 {
+			n, err = f.Write(c.Data)
+		} else {
+			n, err = f.WriteAt(c.Data, offset+resp.N)
+		}
+		resp.N += int64(n)
+		if err != nil {
+			resp.Err = encodeError(err)
+			break
+		}
+	}
+
+	return stream.SendMsg(&resp)
+}
@@ -0,0 +1,141 @@
+package remote
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// transferSize is how much file data Upload and Download move per stream.
+const transferSize = 16 * ChunkSize
+
+// Upload copies the contents of r to the file name on the server, creating
+// it with perm if needed, and returns the size of the file once it's done.
+// If the file already holds the start of r, because an earlier Upload was
+// cut off, only the rest of r is sent, so calling Upload again after a
+// failure resumes it. The SHA-256 hashes of the start of r and of the file
+// are compared first, and a file that doesn't hold the start of r, or is
+// larger than r, is uploaded again from the start. Every chunk is sent
+// with its SHA-256 hash, which the server checks before writing it.
+func (c *Client) Upload(name string, r io.ReadSeeker, perm os.FileMode) (int64, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	var offset int64
+	info, err := c.Stat(name)
+	switch {
+	case err == nil && info.Size() <= size:
+		offset = info.Size()
+	case err != nil && !os.IsNotExist(err):
+		return 0, err
+	}
+	if offset != 0 {
+		same, err := c.samePrefix(name, r, offset)
+		if err != nil {
+			return 0, err
+		}
+		if !same {
+			offset = 0
+		}
+	}
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	flag := os.O_WRONLY | os.O_CREATE
+	if offset == 0 {
+		flag |= os.O_TRUNC
+	}
+	f, err := c.OpenFile(name, flag, perm)
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, transferSize)
+	for offset < size {
+		n, err := io.ReadFull(r, buf[:min(int64(len(buf)), size-offset)])
+		if err != nil {
+			f.Close()
+			return offset, err
+		}
+		n, err = f.WriteAt(buf[:n], offset)
+		offset += int64(n)
+		if err != nil {
+			f.Close()
+			return offset, err
+		}
+	}
+
+	return offset, f.Close()
+}
+
+// samePrefix reports whether the first n bytes of r and of the file name
+// on the server are the same, by their SHA-256 hashes. Servers that can't
+// hash files never match, so uploads to them start over.
+func (c *Client) samePrefix(name string, r io.ReadSeeker, n int64) (bool, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	h := sha256.New()
+	if _, err := io.CopyN(h, r, n); err != nil {
+		return false, err
+	}
+	resp, err := c.call(&request{Op: opHash, Path: name, Size: n})
+	if err != nil {
+		return false, nil
+	}
+
+	return bytes.Equal(resp.Sum, h.Sum(nil)), nil
+}
+
+// hashPrefix returns the SHA-256 hash of the first n bytes of the file
+// name on fs.
+func hashPrefix(fs absfs.FileSystem, name string, n int64) ([]byte, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, n); err != nil {
+		return nil, &os.PathError{Op: string(opHash), Path: name, Err: err}
+	}
+
+	return h.Sum(nil), nil
+}
+
+// Download copies the file name on the server to w, starting at offset,
+// and returns the number of bytes copied. If it's cut off, calling
+// Download again with offset advanced by the bytes copied resumes it.
+// Every chunk is checked against the SHA-256 hash the server sends with
+// it, and fails with ErrChecksum if it doesn't match.
+func (c *Client) Download(name string, w io.Writer, offset int64) (int64, error) {
+	f, err := c.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var copied int64
+	buf := make([]byte, transferSize)
+	for {
+		n, err := f.ReadAt(buf, offset+copied)
+		if n > 0 {
+			n, werr := w.Write(buf[:n])
+			copied += int64(n)
+			if werr != nil {
+				return copied, werr
+			}
+		}
+		if err == io.EOF {
+			return copied, nil
+		}
+		if err != nil {
+			return copied, err
+		}
+	}
+}
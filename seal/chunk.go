@@ -0,0 +1,81 @@
+package seal
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/awnumar/fastrand"
+	"github.com/awnumar/memguard"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// ChunkOverhead is the number of bytes a sealed chunk is longer than its
+// plaintext.
+const ChunkOverhead = chacha20poly1305.NonceSizeX + chacha20poly1305.Overhead
+
+var ErrChunk = errors.New("seal: chunk authentication failed")
+
+// ChunkAD returns the additional data a chunk is bound to: the inode it
+// belongs to, the generation of that inode and the chunk's index within
+// the file. A chunk sealed with one ChunkAD will not open with another, so
+// chunks can't be moved between files, between reuses of the same inode
+// number, or reordered within a file without detection.
+func ChunkAD(ino, gen, index uint64) []byte {
+	ad := make([]byte, 24)
+	binary.BigEndian.PutUint64(ad[0:], ino)
+	binary.BigEndian.PutUint64(ad[8:], gen)
+	binary.BigEndian.PutUint64(ad[16:], index)
+
+	return ad
+}
+
+// SealChunk encrypts plaintext under key with XChaCha20-Poly1305,
+// authenticating ad along with it. A random nonce is prepended to the
+// returned ciphertext.
+func SealChunk(plaintext []byte, key *memguard.Enclave, ad []byte) ([]byte, error) {
+	k, err := key.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer k.Destroy()
+	if k.Size() != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	aead, err := chacha20poly1305.NewX(k.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	nonce := fastrand.Bytes(aead.NonceSize())
+
+	return aead.Seal(nonce, nonce, plaintext, ad), nil
+}
+
+// OpenChunk decrypts a chunk sealed by SealChunk, appending the plaintext to
+// dst. It fails with ErrChunk if the ciphertext or ad don't match.
+func OpenChunk(dst, ciphertext []byte, key *memguard.Enclave, ad []byte) ([]byte, error) {
+	if len(ciphertext) < ChunkOverhead {
+		return nil, ErrChunk
+	}
+
+	k, err := key.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer k.Destroy()
+	if k.Size() != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	aead, err := chacha20poly1305.NewX(k.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	nonce := ciphertext[:aead.NonceSize()]
+	plaintext, err := aead.Open(dst, nonce, ciphertext[aead.NonceSize():], ad)
+	if err != nil {
+		return nil, ErrChunk
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,123 @@
+package seal
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestChunkAD(t *testing.T) {
+	key := NewKey()
+	chunk := []byte("The quick brown fox jumped over the lazy dog.")
+
+	sealed, err := SealChunk(chunk, key, ChunkAD(1, 0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sealed) != len(chunk)+ChunkOverhead {
+		t.Errorf("wrong sealed size: %d, expected %d", len(sealed), len(chunk)+ChunkOverhead)
+	}
+
+	plaintext, err := OpenChunk(nil, sealed, key, ChunkAD(1, 0, 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != string(chunk) {
+		t.Errorf("wrong plaintext: %q", plaintext)
+	}
+
+	// moving the chunk to another file, generation or index is detected
+	for _, ad := range [][]byte{ChunkAD(2, 0, 3), ChunkAD(1, 1, 3), ChunkAD(1, 0, 4)} {
+		if _, err := OpenChunk(nil, sealed, key, ad); err != ErrChunk {
+			t.Errorf("expected ErrChunk, got %v", err)
+		}
+	}
+}
+
+func TestStream(t *testing.T) {
+	key := NewKey()
+
+	// sizes around chunk boundaries, including an empty stream and one
+	// ending on a full chunk
+	for _, size := range []int{0, 1, StreamChunkSize, StreamChunkSize + 1, 3*StreamChunkSize - 7} {
+		plaintext := bytes.Repeat([]byte("pandora"), size/7+1)[:size]
+
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// odd sized writes cross chunk boundaries
+		for p := plaintext; len(p) != 0; {
+			n := min(len(p), 1000)
+			if _, err := w.Write(p[:n]); err != nil {
+				t.Fatal(err)
+			}
+			p = p[n:]
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		sealed := buf.Bytes()
+
+		r, err := NewReader(bytes.NewReader(sealed), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("size %d: wrong plaintext", size)
+		}
+
+		// cutting the stream off after a chunk is detected
+		header := len(streamMagic) + streamIDSize
+		if size > StreamChunkSize {
+			cut := sealed[:header+StreamChunkSize+ChunkOverhead]
+			r, _ := NewReader(bytes.NewReader(cut), key)
+			if _, err := io.ReadAll(r); err != ErrStream {
+				t.Errorf("size %d: expected ErrStream for truncated stream, got %v", size, err)
+			}
+		}
+		// and so is tampering
+		tampered := append([]byte(nil), sealed...)
+		tampered[len(tampered)-1] ^= 1
+		r, _ = NewReader(bytes.NewReader(tampered), key)
+		if _, err := io.ReadAll(r); err != ErrStream {
+			t.Errorf("size %d: expected ErrStream for tampered stream, got %v", size, err)
+		}
+	}
+}
+
+func TestSealers(t *testing.T) {
+	for name, s := range map[string]Sealer{"xsalsa20poly1305": XSalsa20Poly1305, "aesgcm": AESGCM} {
+		key := NewKey()
+		plaintext := []byte("attack at dawn")
+		ciphertext, err := s.Seal(plaintext, key)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(ciphertext) != len(plaintext)+s.Overhead() {
+			t.Errorf("%s: %d byte ciphertext, want %d", name, len(ciphertext), len(plaintext)+s.Overhead())
+		}
+		got := make([]byte, len(plaintext))
+		if err := s.Open(ciphertext, key, got); err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("%s: Open: %q, %v", name, got, err)
+		}
+
+		ciphertext[len(ciphertext)-1] ^= 1
+		if err := s.Open(ciphertext, key, got); err == nil {
+			t.Errorf("%s: tampered ciphertext opened", name)
+		}
+		if err := s.Open(ciphertext[:s.Overhead()-1], key, got); err == nil {
+			t.Errorf("%s: short ciphertext opened", name)
+		}
+
+		s.Wipe(got)
+		if !bytes.Equal(got, make([]byte, len(got))) {
+			t.Errorf("%s: Wipe left %q", name, got)
+		}
+	}
+}
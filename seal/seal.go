@@ -0,0 +1,86 @@
+// Package seal implements the encryption used to keep file contents sealed
+// while they are not in use.
+//
+// Data is encrypted with XSalsa20-Poly1305 as implemented by memguard, under
+// keys that are themselves kept in memguard Enclaves. Other ciphers can be
+// plugged in as Sealers.
+package seal
+
+import (
+	"errors"
+
+	"github.com/awnumar/fastrand"
+	"github.com/awnumar/memguard"
+	"github.com/awnumar/memguard/core"
+)
+
+// KeySize is the size of the keys used to seal data.
+const KeySize = 32
+
+// Overhead is the number of bytes a ciphertext is longer than its
+// plaintext.
+const Overhead = core.Overhead
+
+var ErrInvalidKey = errors.New("seal: invalid key")
+
+// NewKey returns a new random key.
+func NewKey() *memguard.Enclave {
+	return memguard.NewBufferFromBytes(fastrand.Bytes(KeySize)).Seal()
+}
+
+// Seal encrypts plaintext under a freshly generated key, and returns the
+// ciphertext and key.
+func Seal(plaintext []byte) ([]byte, *memguard.Enclave, error) {
+	key := memguard.NewBufferFromBytes(fastrand.Bytes(KeySize))
+	ciphertext, err := core.Encrypt(plaintext, key.Bytes())
+	if err != nil {
+		key.Destroy()
+		return nil, nil, err
+	}
+
+	return ciphertext, key.Seal(), nil
+}
+
+// Encrypt encrypts plaintext under key.
+func Encrypt(plaintext []byte, key *memguard.Enclave) ([]byte, error) {
+	k, err := key.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer k.Destroy()
+	if k.Size() != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	return core.Encrypt(plaintext, k.Bytes())
+}
+
+// Decrypt decrypts ciphertext sealed under key into plaintext, which must
+// be at least Size(ciphertext) bytes long.
+func Decrypt(ciphertext []byte, key *memguard.Enclave, plaintext []byte) error {
+	k, err := key.Open()
+	if err != nil {
+		return err
+	}
+	defer k.Destroy()
+	if k.Size() != KeySize {
+		return ErrInvalidKey
+	}
+
+	_, err = core.Decrypt(ciphertext, k.Bytes(), plaintext)
+	return err
+}
+
+// Size returns the size of the plaintext sealed in ciphertext.
+func Size(ciphertext []byte) int64 {
+	if len(ciphertext) < Overhead {
+		return 0
+	}
+
+	return int64(len(ciphertext) - Overhead)
+}
+
+// Wipe overwrites b with zeros.
+func Wipe(b []byte) {
+	core.Wipe(b)
+}
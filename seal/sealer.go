@@ -0,0 +1,111 @@
+package seal
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+
+	"github.com/awnumar/fastrand"
+	"github.com/awnumar/memguard"
+	"github.com/awnumar/memguard/core"
+)
+
+// A Sealer encrypts data under keys held in Enclaves, so data can be kept
+// sealed by something other than memguard, like a library or HSM that
+// compliance requires.
+type Sealer interface {
+	// Seal encrypts plaintext under key.
+	Seal(plaintext []byte, key *memguard.Enclave) ([]byte, error)
+
+	// Open decrypts ciphertext sealed under key into plaintext, which is
+	// at least len(ciphertext) - Overhead() bytes long.
+	Open(ciphertext []byte, key *memguard.Enclave, plaintext []byte) error
+
+	// Wipe overwrites b, which held plaintext or ciphertext.
+	Wipe(b []byte)
+
+	// Overhead is the number of bytes a ciphertext is longer than its
+	// plaintext.
+	Overhead() int
+}
+
+var (
+	// XSalsa20Poly1305 is the Sealer Encrypt and Decrypt use, which
+	// encrypts with memguard's XSalsa20-Poly1305.
+	XSalsa20Poly1305 Sealer = coreSealer{}
+
+	// AESGCM is a Sealer that encrypts with AES-256-GCM from the standard
+	// library, with a random nonce prepended to every ciphertext.
+	AESGCM Sealer = aesSealer{}
+)
+
+type coreSealer struct{}
+
+func (coreSealer) Seal(plaintext []byte, key *memguard.Enclave) ([]byte, error) {
+	return Encrypt(plaintext, key)
+}
+
+func (coreSealer) Open(ciphertext []byte, key *memguard.Enclave, plaintext []byte) error {
+	return Decrypt(ciphertext, key, plaintext)
+}
+
+func (coreSealer) Wipe(b []byte) {
+	core.Wipe(b)
+}
+
+func (coreSealer) Overhead() int {
+	return Overhead
+}
+
+type aesSealer struct{}
+
+// gcm returns AES-GCM under key.
+func (aesSealer) gcm(key *memguard.Enclave) (cipher.AEAD, error) {
+	k, err := key.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer k.Destroy()
+	if k.Size() != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(k.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s aesSealer) Seal(plaintext []byte, key *memguard.Enclave) ([]byte, error) {
+	gcm, err := s.gcm(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := fastrand.Bytes(gcm.NonceSize())
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func (s aesSealer) Open(ciphertext []byte, key *memguard.Enclave, plaintext []byte) error {
+	if len(ciphertext) < s.Overhead() {
+		return core.ErrDecryptionFailed
+	}
+	gcm, err := s.gcm(key)
+	if err != nil {
+		return err
+	}
+	nonce := ciphertext[:gcm.NonceSize()]
+	if _, err := gcm.Open(plaintext[:0], nonce, ciphertext[gcm.NonceSize():], nil); err != nil {
+		return core.ErrDecryptionFailed
+	}
+
+	return nil
+}
+
+func (aesSealer) Wipe(b []byte) {
+	clear(b)
+}
+
+func (aesSealer) Overhead() int {
+	return 12 + 16
+}
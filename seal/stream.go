@@ -0,0 +1,196 @@
+package seal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/awnumar/fastrand"
+	"github.com/awnumar/memguard"
+)
+
+// StreamChunkSize is the size of the chunks of plaintext a stream is
+// sealed in.
+const StreamChunkSize = 64 << 10
+
+// streamMagic starts every stream, followed by its random ID.
+var streamMagic = []byte("PBXSTREAM1")
+
+const streamIDSize = 16
+
+var (
+	ErrStream = errors.New("seal: stream is corrupt or truncated")
+
+	errClosed = errors.New("seal: write to closed stream")
+)
+
+// streamAD returns the additional data a chunk of a stream is bound to: the
+// ID of the stream, the chunk's index within it, and whether it's the last
+// chunk. Like the STREAM construction, this detects chunks that were
+// reordered, dropped, moved between streams, or a stream that was cut off
+// after any chunk but the last.
+func streamAD(id []byte, index uint64, last bool) []byte {
+	ad := make([]byte, len(id)+9)
+	copy(ad, id)
+	binary.BigEndian.PutUint64(ad[len(id):], index)
+	if last {
+		ad[len(ad)-1] = 1
+	}
+
+	return ad
+}
+
+// A Writer seals what is written to it in chunks of StreamChunkSize bytes,
+// each sealed with SealChunk, so no more than one chunk of plaintext is
+// held at a time. Close must be called to write the last chunk.
+type Writer struct {
+	w     io.Writer
+	key   *memguard.Enclave
+	id    []byte
+	buf   []byte
+	index uint64
+	err   error
+}
+
+// NewWriter returns a Writer writing the stream sealed under key to w.
+func NewWriter(w io.Writer, key *memguard.Enclave) (*Writer, error) {
+	id := fastrand.Bytes(streamIDSize)
+	if _, err := w.Write(append(append([]byte(nil), streamMagic...), id...)); err != nil {
+		return nil, err
+	}
+
+	return &Writer{w: w, key: key, id: id, buf: make([]byte, 0, StreamChunkSize)}, nil
+}
+
+func (sw *Writer) Write(p []byte) (int, error) {
+	var n int
+	for len(p) != 0 {
+		if sw.err != nil {
+			return n, sw.err
+		}
+		// a full chunk is only sealed once more data follows, as the
+		// last chunk must be marked as such
+		if len(sw.buf) == StreamChunkSize {
+			sw.err = sw.flush(false)
+			continue
+		}
+		copied := copy(sw.buf[len(sw.buf):StreamChunkSize], p)
+		sw.buf = sw.buf[:len(sw.buf)+copied]
+		p = p[copied:]
+		n += copied
+	}
+
+	return n, sw.err
+}
+
+// Close seals and writes the last chunk, and wipes the plaintext held. It
+// doesn't close the underlying writer.
+func (sw *Writer) Close() error {
+	if sw.err == errClosed {
+		return nil
+	}
+	err := sw.err
+	if err == nil {
+		err = sw.flush(true)
+	}
+	Wipe(sw.buf[:cap(sw.buf)])
+	sw.err = errClosed
+
+	return err
+}
+
+func (sw *Writer) flush(last bool) error {
+	ciphertext, err := SealChunk(sw.buf, sw.key, streamAD(sw.id, sw.index, last))
+	Wipe(sw.buf)
+	sw.buf = sw.buf[:0]
+	if err != nil {
+		return err
+	}
+	sw.index++
+	_, err = sw.w.Write(ciphertext)
+
+	return err
+}
+
+// A Reader opens a stream written by a Writer, one chunk at a time. It
+// fails with ErrStream if the stream was tampered with or cut off.
+type Reader struct {
+	r     *bufio.Reader
+	key   *memguard.Enclave
+	id    []byte
+	buf   []byte
+	plain []byte
+	pos   int
+	index uint64
+	last  bool
+	err   error
+}
+
+// NewReader returns a Reader opening the stream read from r under key.
+func NewReader(r io.Reader, key *memguard.Enclave) (*Reader, error) {
+	header := make([]byte, len(streamMagic)+streamIDSize)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header[:len(streamMagic)], streamMagic) {
+		return nil, ErrStream
+	}
+
+	return &Reader{
+		r:     bufio.NewReaderSize(r, StreamChunkSize+ChunkOverhead),
+		key:   key,
+		id:    header[len(streamMagic):],
+		buf:   make([]byte, StreamChunkSize+ChunkOverhead),
+		plain: make([]byte, 0, StreamChunkSize),
+	}, nil
+}
+
+func (sr *Reader) Read(p []byte) (int, error) {
+	for sr.pos == len(sr.plain) {
+		if sr.err != nil {
+			return 0, sr.err
+		}
+		if sr.last {
+			Wipe(sr.plain[:cap(sr.plain)])
+			sr.err = io.EOF
+			continue
+		}
+		sr.err = sr.next()
+	}
+	n := copy(p, sr.plain[sr.pos:])
+	sr.pos += n
+
+	return n, nil
+}
+
+// next reads and opens the next chunk.
+func (sr *Reader) next() error {
+	n, err := io.ReadFull(sr.r, sr.buf)
+	switch err {
+	case nil:
+		_, err = sr.r.Peek(1)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		sr.last = err == io.EOF
+	case io.ErrUnexpectedEOF:
+		sr.last = true
+	case io.EOF:
+		// the last chunk is missing
+		return ErrStream
+	default:
+		return err
+	}
+
+	Wipe(sr.plain)
+	sr.plain, err = OpenChunk(sr.plain[:0], sr.buf[:n], sr.key, streamAD(sr.id, sr.index, sr.last))
+	sr.pos = 0
+	if err == ErrChunk {
+		return ErrStream
+	}
+	if err != nil {
+		return err
+	}
+	sr.index++
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package pandorasbox
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/awnumar/memguard"
+)
+
+// SecretsDir is the directory of the default VFS secrets are kept in.
+const SecretsDir = "/.secrets"
+
+// ErrBadSecretName is returned for secret names that are empty, contain a
+// slash or a question mark, or are . or ..
+var ErrBadSecretName = errors.New("invalid secret name")
+
+// Secrets is a key/value store of secrets, kept as files in SecretsDir of
+// the default VFS of a Box, sealed like any other file. Values are handed
+// in and out in Enclaves, so callers never need to open files or handle
+// plaintext themselves.
+type Secrets struct {
+	b *Box
+}
+
+// Secrets returns the secrets kept in b.
+func (b *Box) Secrets() *Secrets {
+	return &Secrets{b: b}
+}
+
+// path returns the Box path of the secret name.
+func (s *Secrets) path(op, name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/?") {
+		return "", &os.PathError{Op: op, Path: name, Err: ErrBadSecretName}
+	}
+
+	return MakeVFSPath(SecretsDir + "/" + name), nil
+}
+
+// err reports err for the secret name rather than the file it's kept in.
+func (s *Secrets) err(op, name string, err error) error {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+
+	return &os.PathError{Op: op, Path: name, Err: err}
+}
+
+// Put stores value as the secret name, replacing the secret already stored
+// under name along with its TTL.
+func (s *Secrets) Put(name string, value *memguard.Enclave) error {
+	return s.PutTTL(name, value, 0)
+}
+
+// PutTTL is Put, but the secret is deleted after ttl if it's positive.
+func (s *Secrets) PutTTL(name string, value *memguard.Enclave, ttl time.Duration) error {
+	p, err := s.path("put", name)
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return &os.PathError{Op: "put", Path: name, Err: os.ErrInvalid}
+	}
+	if err := s.b.MkdirAll(MakeVFSPath(SecretsDir), 0700); err != nil {
+		return err
+	}
+
+	v, err := value.Open()
+	if err != nil {
+		return s.err("put", name, err)
+	}
+	defer v.Destroy()
+	s.b.cancelExpiry(p)
+	if err := s.b.WriteFile(p, v.Bytes(), 0600); err != nil {
+		return s.err("put", name, err)
+	}
+	if ttl > 0 {
+		s.b.expire(p, ttl)
+	}
+
+	return nil
+}
+
+// Get returns the secret name, or nil if it's empty. It fails with an
+// error wrapping os.ErrNotExist if there is no such secret.
+func (s *Secrets) Get(name string) (*memguard.Enclave, error) {
+	p, err := s.path("get", name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := s.b.Open(p)
+	if err != nil {
+		return nil, s.err("get", name, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, s.err("get", name, err)
+	}
+	if info.Size() == 0 {
+		// Enclaves can't be empty
+		return nil, nil
+	}
+	buf := memguard.NewBuffer(int(info.Size()))
+	if _, err := io.ReadFull(f, buf.Bytes()); err != nil {
+		buf.Destroy()
+		return nil, s.err("get", name, err)
+	}
+
+	return buf.Seal(), nil
+}
+
+// Delete removes the secret name. It fails with an error wrapping
+// os.ErrNotExist if there is no such secret.
+func (s *Secrets) Delete(name string) error {
+	p, err := s.path("delete", name)
+	if err != nil {
+		return err
+	}
+
+	s.b.cancelExpiry(p)
+	if err := s.b.Remove(p); err != nil {
+		return s.err("delete", name, err)
+	}
+
+	return nil
+}
+
+// List returns the names of the secrets stored, sorted.
+func (s *Secrets) List() ([]string, error) {
+	infos, err := s.b.ReadDir(MakeVFSPath(SecretsDir))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.Mode().IsRegular() {
+			names = append(names, info.Name())
+		}
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
@@ -0,0 +1,104 @@
+package pandorasbox
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/awnumar/memguard"
+)
+
+func TestSecrets(t *testing.T) {
+	s := NewBox().Secrets()
+
+	if err := s.Put("api", memguard.NewEnclave([]byte("token"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put("db", memguard.NewEnclave([]byte("hunter2"))); err != nil {
+		t.Fatal(err)
+	}
+	v, err := s.Get("api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := openKey(t, v); string(got) != "token" {
+		t.Errorf("Get = %q, want %q", got, "token")
+	}
+	names, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"api", "db"}) {
+		t.Errorf("List = %v, want [api db]", names)
+	}
+
+	if err := s.Delete("api"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("api"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("getting a deleted secret: got %v, want %v", err, os.ErrNotExist)
+	}
+	if err := s.Delete("api"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("deleting a deleted secret: got %v, want %v", err, os.ErrNotExist)
+	}
+
+	for _, name := range []string{"", ".", "..", "a/b", "a?"} {
+		if err := s.Put(name, memguard.NewEnclave([]byte("x"))); !errors.Is(err, ErrBadSecretName) {
+			t.Errorf("Put(%q): got %v, want %v", name, err, ErrBadSecretName)
+		}
+	}
+}
+
+func TestSecretsTTL(t *testing.T) {
+	b := NewBox()
+	s := b.Secrets()
+
+	if err := s.PutTTL("short", memguard.NewEnclave([]byte("gone soon")), 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutTTL("kept", memguard.NewEnclave([]byte("replaced")), 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	// storing a secret again without a TTL cancels the old one
+	if err := s.Put("kept", memguard.NewEnclave([]byte("forever"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get("short"); err != nil {
+		t.Fatalf("getting a secret before it expired: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := s.Get("short")
+		if errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("secret didn't expire")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if _, err := b.vfs.Stat(SecretsDir + "/short"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the expired secret's file still exists: %v", err)
+	}
+
+	v, err := s.Get("kept")
+	if err != nil {
+		t.Fatalf("getting a secret whose TTL was cancelled: %v", err)
+	}
+	if got := openKey(t, v); string(got) != "forever" {
+		t.Errorf("Get = %q, want %q", got, "forever")
+	}
+	names, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names, []string{"kept"}) {
+		t.Errorf("List = %v, want [kept]", names)
+	}
+}
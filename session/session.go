@@ -0,0 +1,448 @@
+// Package session delegates remote access to a box with tokens.
+//
+// The box owner issues tokens with an Issuer. A token names its subject,
+// carries a Scope limiting it to reading, to a subtree, or both, and
+// expires. Tokens are authenticated with HMAC-SHA256 under the Issuer's
+// key, so the servers only need the Issuer to check them, and they can be
+// revoked before they expire. Handler serves a FileSystem over HTTP with
+// httpfs, and RemoteServer over gRPC with remote, to the holders of tokens,
+// each restricted to the scope of their token.
+package session
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awnumar/memguard"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/httpfs"
+	"github.com/capnspacehook/pandorasbox/remote"
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+var (
+	ErrBadToken = errors.New("session: invalid token")
+	ErrExpired  = errors.New("session: token expired")
+	ErrRevoked  = errors.New("session: token revoked")
+	ErrNoToken  = errors.New("session: no token")
+)
+
+// A Scope limits what the holder of a token may do.
+type Scope struct {
+	// ReadOnly tokens can't change anything.
+	ReadOnly bool `json:"read_only,omitempty"`
+
+	// Root is the absolute path of the only subtree the token may access.
+	// An empty Root allows every path.
+	Root string `json:"root,omitempty"`
+}
+
+// Claims are what a token was issued for.
+type Claims struct {
+	ID      string    `json:"id"`
+	Subject string    `json:"sub"`
+	Scope   Scope     `json:"scope"`
+	Expiry  time.Time `json:"exp"`
+}
+
+// An Issuer issues and verifies tokens. It's safe for concurrent use.
+type Issuer struct {
+	key *memguard.Enclave
+
+	mtx     sync.Mutex
+	revoked map[string]time.Time
+}
+
+// NewIssuer returns an Issuer authenticating tokens under key, which must
+// be seal.KeySize bytes long. Tokens issued under a key are valid for every
+// Issuer using it until they expire.
+func NewIssuer(key *memguard.Enclave) (*Issuer, error) {
+	k, err := key.Open()
+	if err != nil {
+		return nil, err
+	}
+	size := k.Size()
+	k.Destroy()
+	if size != seal.KeySize {
+		return nil, seal.ErrInvalidKey
+	}
+
+	return &Issuer{key: key, revoked: make(map[string]time.Time)}, nil
+}
+
+// Issue issues a token for subject limited to scope, which expires after
+// ttl.
+func (i *Issuer) Issue(subject string, scope Scope, ttl time.Duration) (string, *Claims, error) {
+	if scope.Root != "" {
+		if !path.IsAbs(scope.Root) {
+			return "", nil, &os.PathError{Op: "issue", Path: scope.Root, Err: errors.New("root must be absolute")}
+		}
+		scope.Root = path.Clean(scope.Root)
+	}
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, err
+	}
+	claims := &Claims{
+		ID:      hex.EncodeToString(id),
+		Subject: subject,
+		Scope:   scope,
+		Expiry:  time.Now().Add(ttl).Truncate(time.Second),
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	mac, err := i.mac(payload)
+	if err != nil {
+		return "", nil, err
+	}
+	enc := base64.RawURLEncoding
+	token := enc.EncodeToString(payload) + "." + enc.EncodeToString(mac)
+
+	return token, claims, nil
+}
+
+// Verify returns the claims of token if it was issued under the Issuer's
+// key, and hasn't expired or been revoked.
+func (i *Issuer) Verify(token string) (*Claims, error) {
+	enc := base64.RawURLEncoding
+	p, m, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrBadToken
+	}
+	payload, err := enc.DecodeString(p)
+	if err != nil {
+		return nil, ErrBadToken
+	}
+	got, err := enc.DecodeString(m)
+	if err != nil {
+		return nil, ErrBadToken
+	}
+	want, err := i.mac(payload)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(got, want) {
+		return nil, ErrBadToken
+	}
+
+	claims := new(Claims)
+	if err := json.Unmarshal(payload, claims); err != nil {
+		return nil, ErrBadToken
+	}
+	if !time.Now().Before(claims.Expiry) {
+		return nil, ErrExpired
+	}
+	i.mtx.Lock()
+	_, revoked := i.revoked[claims.ID]
+	i.mtx.Unlock()
+	if revoked {
+		return nil, ErrRevoked
+	}
+
+	return claims, nil
+}
+
+// Revoke revokes the token with the claims c before it expires.
+func (i *Issuer) Revoke(c *Claims) {
+	i.mtx.Lock()
+	defer i.mtx.Unlock()
+
+	// forget revoked tokens once they would have expired anyway
+	now := time.Now()
+	for id, expiry := range i.revoked {
+		if !now.Before(expiry) {
+			delete(i.revoked, id)
+		}
+	}
+	i.revoked[c.ID] = c.Expiry
+}
+
+func (i *Issuer) mac(payload []byte) ([]byte, error) {
+	k, err := i.key.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer k.Destroy()
+
+	mac := hmac.New(sha256.New, k.Bytes())
+	mac.Write(payload)
+
+	return mac.Sum(nil), nil
+}
+
+// Restrict returns a view of fs limited to scope. Operations outside of
+// scope.Root fail with os.ErrPermission, and changes through ReadOnly views
+// with absfs.ErrReadOnly. Paths are checked with the symbolic links in them
+// followed, so links can neither be created nor followed out of
+// scope.Root. Chdir fails with os.ErrPermission, as the working directory
+// of fs is shared by every view of it.
+func Restrict(fs absfs.FileSystem, scope Scope) absfs.FileSystem {
+	return absfs.Chain(fs, &restrictHooks{fs: fs, scope: scope})
+}
+
+type restrictHooks struct {
+	fs    absfs.FileSystem
+	scope Scope
+}
+
+// openWriteFlags are the flags that open a file for changing it.
+const openWriteFlags = absfs.O_WRONLY | absfs.O_RDWR | absfs.O_APPEND | absfs.O_CREATE | absfs.O_TRUNC
+
+func (h *restrictHooks) Before(c *absfs.Call) error {
+	if c.Op == absfs.OpChdir {
+		return &os.PathError{Op: string(c.Op), Path: c.Path, Err: os.ErrPermission}
+	}
+	if h.scope.ReadOnly && writes(c) {
+		return &os.PathError{Op: string(c.Op), Path: c.Path, Err: absfs.ErrReadOnly}
+	}
+	if h.scope.Root == "" {
+		return nil
+	}
+
+	names := []string{c.Path}
+	switch c.Op {
+	case absfs.OpRename, absfs.OpLink:
+		names = append(names, c.NewPath)
+	case absfs.OpSymlink:
+		// the target is relative to the directory of the link
+		target := c.Path
+		if !path.IsAbs(target) {
+			target = path.Join(path.Dir(h.abs(c.NewPath)), target)
+		}
+		names = []string{target, c.NewPath}
+	}
+	for i, name := range names {
+		name = h.abs(name)
+		err := os.ErrPermission
+		if within(name, h.scope.Root) {
+			// files that are already open were checked when they were
+			// opened
+			if c.File != nil {
+				continue
+			}
+			var resolved string
+			if resolved, err = h.resolve(name, followsLast(c.Op, i)); err == nil {
+				if within(resolved, h.scope.Root) {
+					continue
+				}
+				err = os.ErrPermission
+			}
+		}
+		if c.NewPath != "" {
+			return &os.LinkError{Op: string(c.Op), Old: c.Path, New: c.NewPath, Err: err}
+		}
+		return &os.PathError{Op: string(c.Op), Path: c.Path, Err: err}
+	}
+
+	return nil
+}
+
+// maxSymlinkHops is the number of symbolic links resolve follows before
+// giving up, as they must form a cycle.
+const maxSymlinkHops = 40
+
+// resolve returns the absolute path name with the symbolic links among its
+// components followed, except the last one unless last is true. Components
+// that don't exist are kept as they are.
+func (h *restrictHooks) resolve(name string, last bool) (string, error) {
+	resolved := "/"
+	parts := strings.Split(name, "/")
+	for hops := 0; len(parts) > 0; {
+		p := parts[0]
+		parts = parts[1:]
+		if p == "" || p == "." {
+			continue
+		}
+		next := path.Join(resolved, p)
+		if len(parts) == 0 && !last {
+			return next, nil
+		}
+		fi, err := h.fs.Lstat(next)
+		if err != nil {
+			return path.Join(next, strings.Join(parts, "/")), nil
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+
+		if hops++; hops > maxSymlinkHops {
+			return "", absfs.ErrSymlinkCycle
+		}
+		target, err := h.fs.Readlink(next)
+		if err != nil {
+			return "", err
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		parts = append(strings.Split(target, "/"), parts...)
+	}
+
+	return resolved, nil
+}
+
+// followsLast reports whether the i-th path of an operation op has its last
+// component followed if it's a symbolic link.
+func followsLast(op absfs.Op, i int) bool {
+	switch op {
+	case absfs.OpLstat, absfs.OpLchown, absfs.OpReadlink, absfs.OpRemove,
+		absfs.OpRemoveAll, absfs.OpRename, absfs.OpLink:
+		return false
+	case absfs.OpSymlink:
+		// the target is followed by everyone using the link
+		return i == 0
+	}
+	return true
+}
+
+func (h *restrictHooks) After(c *absfs.Call, err error) error {
+	return err
+}
+
+// abs returns name as an absolute path.
+func (h *restrictHooks) abs(name string) string {
+	if path.IsAbs(name) {
+		return path.Clean(name)
+	}
+	wd, err := h.fs.Getwd()
+	if err != nil {
+		wd = "/"
+	}
+
+	return path.Join(wd, name)
+}
+
+// within reports whether name is root or under it.
+func within(name, root string) bool {
+	return root == "/" || name == root || strings.HasPrefix(name, root+"/")
+}
+
+// writes reports whether the call c changes anything.
+func writes(c *absfs.Call) bool {
+	switch c.Op {
+	case absfs.OpOpen:
+		return c.Flag&openWriteFlags != 0
+	case absfs.OpStat, absfs.OpLstat, absfs.OpReadlink, absfs.OpRead,
+		absfs.OpSeek, absfs.OpSync, absfs.OpClose, absfs.OpFstat, absfs.OpReaddir,
+		absfs.OpReaddirnames, absfs.OpGetxattr, absfs.OpListxattr:
+		return false
+	}
+	return true
+}
+
+type claimsKey struct{}
+
+// NewContext returns a copy of ctx carrying c.
+func NewContext(ctx context.Context, c *Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, c)
+}
+
+// FromContext returns the claims attached to ctx with NewContext.
+func FromContext(ctx context.Context) (*Claims, bool) {
+	c, ok := ctx.Value(claimsKey{}).(*Claims)
+	return c, ok
+}
+
+// bearer returns the token of an "Authorization: Bearer <token>" header.
+func bearer(auth string) (string, error) {
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	if !ok || token == "" {
+		return "", ErrNoToken
+	}
+	return token, nil
+}
+
+// Handler returns an http.Handler serving fs with httpfs to requests with
+// an "Authorization: Bearer <token>" header holding a valid token, each
+// restricted to the scope of its token. The claims of the token are
+// attached to the request's context for middleware, which is applied as
+// with httpfs.NewHandler.
+func (i *Issuer) Handler(fs absfs.FileSystem, middleware ...httpfs.Middleware) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := bearer(r.Header.Get("Authorization"))
+		var claims *Claims
+		if err == nil {
+			claims, err = i.Verify(token)
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+
+		r = r.WithContext(NewContext(r.Context(), claims))
+		httpfs.NewHandler(Restrict(fs, claims.Scope), middleware...).ServeHTTP(w, r)
+	})
+}
+
+// RemoteServer returns a remote.Server serving fs to calls whose metadata
+// holds a valid token, as sent with Credentials, each restricted to the
+// scope of its token. Files opened with one token can't be used with
+// another.
+func (i *Issuer) RemoteServer(fs absfs.FileSystem) *remote.Server {
+	return remote.NewSessionServer(func(ctx context.Context) (absfs.FileSystem, string, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		auth := md.Get("authorization")
+		if len(auth) == 0 {
+			return nil, "", ErrNoToken
+		}
+		token, err := bearer(auth[0])
+		if err != nil {
+			return nil, "", err
+		}
+		claims, err := i.Verify(token)
+		if err != nil {
+			return nil, "", err
+		}
+
+		return Restrict(fs, claims.Scope), claims.ID, nil
+	})
+}
+
+// Credentials returns gRPC credentials sending token with every call, for
+// grpc.WithPerRPCCredentials. They require transport security, so tokens
+// are never sent in the clear.
+func Credentials(token string) credentials.PerRPCCredentials {
+	return tokenCredentials{token: token, secure: true}
+}
+
+// InsecureCredentials returns gRPC credentials like Credentials that also
+// send token without transport security. They're only meant for
+// connections that can't be intercepted, like unix sockets.
+func InsecureCredentials(token string) credentials.PerRPCCredentials {
+	return tokenCredentials{token: token}
+}
+
+type tokenCredentials struct {
+	token  string
+	secure bool
+}
+
+func (t tokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + t.token}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return t.secure
+}
@@ -0,0 +1,196 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/remote"
+	"github.com/capnspacehook/pandorasbox/seal"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+func TestSession(t *testing.T) {
+	fs := vfs.NewFS()
+	if err := fs.MkdirAll("/shared/docs", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/shared/docs/a", []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/secret", []byte("s"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	key := seal.NewKey()
+	iss, err := NewIssuer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, claims, err := iss.Issue("alice", Scope{ReadOnly: true, Root: "/shared"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := iss.Verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Subject != "alice" || got.Scope != claims.Scope {
+		t.Errorf("verified claims %+v, want %+v", got, claims)
+	}
+	other, err := NewIssuer(seal.NewKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Verify(token); !errors.Is(err, ErrBadToken) {
+		t.Errorf("verifying under another key: got %v, want %v", err, ErrBadToken)
+	}
+	if _, err := iss.Verify(token[:len(token)-2] + "AA"); !errors.Is(err, ErrBadToken) {
+		t.Errorf("verifying a forged token: got %v, want %v", err, ErrBadToken)
+	}
+	expired, _, err := iss.Issue("bob", Scope{}, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := iss.Verify(expired); !errors.Is(err, ErrExpired) {
+		t.Errorf("verifying an expired token: got %v, want %v", err, ErrExpired)
+	}
+
+	t.Run("HTTP", func(t *testing.T) {
+		srv := httptest.NewServer(iss.Handler(fs))
+		defer srv.Close()
+
+		do := func(method, path, token string) int {
+			req, err := http.NewRequest(method, srv.URL+path, strings.NewReader("x"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+		if code := do(http.MethodGet, "/shared/docs/a", ""); code != http.StatusUnauthorized {
+			t.Errorf("GET without a token: %d", code)
+		}
+		if code := do(http.MethodGet, "/shared/docs/a", token); code != http.StatusOK {
+			t.Errorf("GET in scope: %d", code)
+		}
+		if code := do(http.MethodGet, "/secret", token); code == http.StatusOK {
+			t.Errorf("GET out of scope: %d", code)
+		}
+		if code := do(http.MethodPut, "/shared/docs/b", token); code == http.StatusOK || code == http.StatusCreated {
+			t.Errorf("PUT with a read-only token: %d", code)
+		}
+	})
+
+	t.Run("gRPC", func(t *testing.T) {
+		rw, rwClaims, err := iss.Issue("carol", Scope{Root: "/shared"}, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		lis := bufconn.Listen(1024 * 1024)
+		gs := grpc.NewServer()
+		srv := iss.RemoteServer(fs)
+		srv.Register(gs)
+		go gs.Serve(lis)
+		defer func() {
+			gs.Stop()
+			srv.Close()
+		}()
+		dial := func(creds credentials.PerRPCCredentials) (*remote.Client, error) {
+			conn, err := grpc.Dial("bufnet",
+				grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+				grpc.WithPerRPCCredentials(creds))
+			if err != nil {
+				return nil, err
+			}
+			t.Cleanup(func() { conn.Close() })
+			return remote.NewClient(conn)
+		}
+
+		if _, err := dial(Credentials(rw)); err == nil {
+			t.Error("sending a token without transport security succeeded")
+		}
+		c, err := dial(InsecureCredentials(rw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(c, "/shared/docs/b", []byte("b"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(c, "/b", []byte("b"), 0644); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("writing out of scope: got %v, want %v", err, os.ErrPermission)
+		}
+		if err := c.Symlink("/secret", "/shared/link"); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("linking out of scope: got %v, want %v", err, os.ErrPermission)
+		}
+		if err := c.Rename("/shared/docs/b", "/b"); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("renaming out of scope: got %v, want %v", err, os.ErrPermission)
+		}
+
+		if err := c.Symlink("../secret", "/shared/link"); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("linking out of scope relatively: got %v, want %v", err, os.ErrPermission)
+		}
+		if err := fs.Symlink("/secret", "/shared/planted"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadFile(c, "/shared/planted"); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("following a link out of scope: got %v, want %v", err, os.ErrPermission)
+		}
+		if err := fs.Symlink("/", "/shared/up"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Stat("/shared/up/secret"); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("stat through a linked directory out of scope: got %v, want %v", err, os.ErrPermission)
+		}
+		if err := c.Chdir("/shared/docs"); !errors.Is(err, os.ErrPermission) {
+			t.Errorf("changing the working directory: got %v, want %v", err, os.ErrPermission)
+		}
+
+		ro, err := dial(InsecureCredentials(token))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadFile(ro, "/shared/docs/b"); err != nil {
+			t.Fatal(err)
+		}
+		if err := ro.Remove("/shared/docs/b"); !errors.Is(err, absfs.ErrReadOnly) {
+			t.Errorf("removing with a read-only token: got %v, want %v", err, absfs.ErrReadOnly)
+		}
+
+		f, err := c.Open("/shared/docs/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		iss.Revoke(rwClaims)
+		if _, err := f.Read(make([]byte, 1)); err == nil {
+			t.Error("reading with a revoked token succeeded")
+		}
+		if _, err := c.Stat("/shared"); err == nil || !strings.Contains(err.Error(), ErrRevoked.Error()) {
+			t.Errorf("stat with a revoked token: got %v, want %v", err, ErrRevoked)
+		}
+	})
+}
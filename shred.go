@@ -0,0 +1,118 @@
+package pandorasbox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+// ErrChecksum is returned when a file moved into a VFS doesn't read back
+// the same as the original.
+var ErrChecksum = errors.New("checksum of the copy doesn't match the original")
+
+var errMoveNotRegular = errors.New("only regular files can be moved into a VFS")
+
+// SecureRemove removes the named file. Files on the host's filesystem are
+// overwritten before they are removed, see osfs.FileSystem.SecureRemove.
+// VFS files are encrypted, and are simply removed.
+func (b *Box) SecureRemove(name string) error {
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	if ok {
+		if err := fs.Remove(vfsName); err != nil {
+			return err
+		}
+		b.cancelVFSExpiry(fs, vfsName)
+		return nil
+	}
+
+	return b.osfs.SecureRemove(name)
+}
+
+// Ingest moves the host file osPath into the VFS at vfsPath. The file is
+// streamed into the VFS, read back to check it arrived intact, and only
+// then is osPath securely removed, see osfs.FileSystem.SecureRemove. If
+// anything fails before that, osPath is left as it was.
+func (b *Box) Ingest(osPath, vfsPath string) error {
+	fs, vfsName, ok, err := b.resolveVFS(vfsPath)
+	if err != nil {
+		return &os.LinkError{Op: "ingest", Old: osPath, New: vfsPath, Err: err}
+	}
+	if !ok {
+		return &os.LinkError{Op: "ingest", Old: osPath, New: vfsPath, Err: errors.New("vfsPath must be a VFS path")}
+	}
+	if _, _, ok, err := b.resolveVFS(osPath); ok || err != nil {
+		return &os.LinkError{Op: "ingest", Old: osPath, New: vfsPath, Err: errors.New("osPath must be a path on the host's filesystem")}
+	}
+
+	return b.moveIntoVFS("ingest", osPath, fs, vfsName, vfsPath)
+}
+
+// moveIntoVFS copies the host file oldpath to vfsNewPath in fs, checks the
+// copy against a checksum of what was read, and then securely removes
+// oldpath so no plaintext copy is left behind. Errors are reported as
+// *os.LinkErrors with op.
+func (b *Box) moveIntoVFS(op, oldpath string, fs *vfs.FileSystem, vfsNewPath, newpath string) error {
+	info, err := b.osfs.Lstat(oldpath)
+	if err != nil {
+		return &os.LinkError{Op: op, Old: oldpath, New: newpath, Err: err}
+	}
+	if !info.Mode().IsRegular() {
+		return &os.LinkError{Op: op, Old: oldpath, New: newpath, Err: errMoveNotRegular}
+	}
+
+	in, err := b.osfs.Open(oldpath)
+	if err != nil {
+		return &os.LinkError{Op: op, Old: oldpath, New: newpath, Err: err}
+	}
+	defer in.Close()
+	out, err := fs.OpenFile(vfsNewPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return &os.LinkError{Op: op, Old: oldpath, New: newpath, Err: err}
+	}
+	h := sha256.New()
+	_, err = io.Copy(out, io.TeeReader(in, h))
+	if err1 := out.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = checkSum(fs, vfsNewPath, h.Sum(nil))
+	}
+	if err == nil {
+		err = ioutil.CopyXattrs(fs, vfsNewPath, b.osfs, oldpath)
+	}
+	if err != nil {
+		fs.Remove(vfsNewPath)
+		return &os.LinkError{Op: op, Old: oldpath, New: newpath, Err: err}
+	}
+	in.Close()
+
+	return b.osfs.SecureRemove(oldpath)
+}
+
+// checkSum returns ErrChecksum if the SHA-256 digest of name on fs isn't
+// sum.
+func checkSum(fs *vfs.FileSystem, name string, sum []byte) error {
+	f, err := fs.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return ErrChecksum
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package pandorasbox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIngest(t *testing.T) {
+	b := NewBox()
+	src := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(src, []byte("hunter2"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Ingest(src, filepath.Join(t.TempDir(), "copy")); err == nil {
+		t.Error("ingested into the host's filesystem")
+	}
+	if _, err := os.Stat(src); err != nil {
+		t.Fatalf("source removed by a failed ingest: %v", err)
+	}
+
+	if err := b.Ingest(src, "vfs://secret.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Errorf("source left behind: %v", err)
+	}
+	data, err := b.ReadFile("vfs://secret.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hunter2" {
+		t.Errorf("ingested %q, want %q", data, "hunter2")
+	}
+	info, err := b.Stat("vfs://secret.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("ingested with mode %v, want %v", info.Mode().Perm(), os.FileMode(0640))
+	}
+
+	if err := b.Ingest(src, "vfs://again.txt"); err == nil {
+		t.Error("ingested a missing file")
+	}
+	if _, err := b.Stat("vfs://again.txt"); !os.IsNotExist(err) {
+		t.Errorf("failed ingest left a file in the VFS: %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package pandorasbox
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+var (
+	// ErrBadSignature is returned for signed manifests and images whose
+	// signature doesn't match.
+	ErrBadSignature = vfs.ErrBadSignature
+
+	// ErrManifestMismatch is returned by VerifyAttestation for files that
+	// changed since their manifest was signed.
+	ErrManifestMismatch = errors.New("file doesn't match signed manifest")
+)
+
+// A SignedManifest is a Manifest encoded with encoding/json together with
+// an Ed25519 signature of the encoding.
+type SignedManifest struct {
+	Manifest  json.RawMessage `json:"manifest"`
+	Signature []byte          `json:"signature"`
+}
+
+// SignManifest signs m with key.
+func SignManifest(m *Manifest, key ed25519.PrivateKey) (*SignedManifest, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SignedManifest{Manifest: data, Signature: ed25519.Sign(key, data)}, nil
+}
+
+// Verify checks the signature of sm against key, and returns the Manifest
+// it signs. It fails with ErrBadSignature if the signature doesn't match.
+func (sm *SignedManifest) Verify(key ed25519.PublicKey) (*Manifest, error) {
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, sm.Manifest, sm.Signature) {
+		return nil, ErrBadSignature
+	}
+	m := new(Manifest)
+	if err := json.Unmarshal(sm.Manifest, m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// Attest returns a Manifest of the tree rooted at root, see ManifestPath,
+// signed with key, that VerifyAttestation proves the tree against.
+func (b *Box) Attest(root string, key ed25519.PrivateKey) (*SignedManifest, error) {
+	m, err := b.ManifestPath(root)
+	if err != nil {
+		return nil, err
+	}
+
+	return SignManifest(m, key)
+}
+
+// VerifyAttestation checks that sm was signed by key, and that the tree
+// rooted at root holds exactly the files it lists, with the same types,
+// permissions, sizes, contents, link targets and tags, proving the tree
+// wasn't altered since it was attested, however it was copied, exported or
+// imported since. Modification times aren't compared, as not every archive
+// format keeps them exactly. The first file that differs is reported with
+// ErrManifestMismatch.
+func (b *Box) VerifyAttestation(root string, sm *SignedManifest, key ed25519.PublicKey) error {
+	want, err := sm.Verify(key)
+	if err != nil {
+		return err
+	}
+	got, err := b.ManifestPath(root)
+	if err != nil {
+		return err
+	}
+
+	wantEntries := make(map[string]ManifestEntry, len(want.Entries))
+	for _, e := range want.Entries {
+		wantEntries[e.Path] = e
+	}
+	for _, e := range got.Entries {
+		w, ok := wantEntries[e.Path]
+		if !ok || !sameEntry(w, e) {
+			return &os.PathError{Op: "verify", Path: e.Path, Err: ErrManifestMismatch}
+		}
+		delete(wantEntries, e.Path)
+	}
+	for p := range wantEntries {
+		return &os.PathError{Op: "verify", Path: p, Err: ErrManifestMismatch}
+	}
+
+	return nil
+}
+
+// sameEntry reports whether a and b describe the same file, apart from
+// their modification times.
+func sameEntry(a, b ManifestEntry) bool {
+	if a.Size != b.Size || a.SHA256 != b.SHA256 || a.Target != b.Target || len(a.Tags) != len(b.Tags) {
+		return false
+	}
+	// links to directories in a VFS are also directories
+	typ := func(m os.FileMode) os.FileMode {
+		if m&os.ModeSymlink != 0 {
+			return os.ModeSymlink
+		}
+		return m.Type()
+	}
+	if typ(a.Mode) != typ(b.Mode) || typ(a.Mode) != os.ModeSymlink && a.Mode.Perm() != b.Mode.Perm() {
+		return false
+	}
+	for k, v := range a.Tags {
+		if bv, ok := b.Tags[k]; !ok || bv != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ImportSigned extracts the archive read from r in format into dir like
+// Import, then checks dir against sm with VerifyAttestation, so archives
+// can be proven to hold what was attested. As archives name entries after
+// the directories they were exported from, sm attests the parent of those.
+// The signature of sm is checked before anything is extracted. dir must not
+// exist yet, and is removed again if the archive doesn't match sm.
+func (b *Box) ImportSigned(r io.Reader, dir string, format ArchiveFormat, sm *SignedManifest, key ed25519.PublicKey) error {
+	if _, err := sm.Verify(key); err != nil {
+		return err
+	}
+	if _, err := b.Lstat(dir); err == nil {
+		return &os.PathError{Op: "import", Path: dir, Err: os.ErrExist}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	err := b.Import(r, dir, format)
+	if err == nil {
+		err = b.VerifyAttestation(dir, sm, key)
+	}
+	if err != nil {
+		b.RemoveAll(dir)
+	}
+
+	return err
+}
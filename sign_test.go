@@ -0,0 +1,59 @@
+package pandorasbox
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"testing"
+)
+
+func TestAttestation(t *testing.T) {
+	b := NewBox()
+	if err := b.MkdirAll("vfs://tree", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteFile("vfs://tree/secret", []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sm, err := b.Attest("vfs://tree", priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.VerifyAttestation("vfs://tree", sm, pub); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.VerifyAttestation("vfs://tree", sm, otherPub); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("verifying with another key: got %v, want %v", err, ErrBadSignature)
+	}
+
+	forged := &SignedManifest{Manifest: append([]byte(nil), sm.Manifest...), Signature: sm.Signature}
+	forged.Manifest[len(forged.Manifest)-2] ^= 1
+	if err := b.VerifyAttestation("vfs://tree", forged, pub); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("verifying a forged manifest: got %v, want %v", err, ErrBadSignature)
+	}
+
+	// the same size, but different contents
+	if err := b.WriteFile("vfs://tree/secret", []byte("hunter3"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.VerifyAttestation("vfs://tree", sm, pub); !errors.Is(err, ErrManifestMismatch) {
+		t.Errorf("verifying a changed file: got %v, want %v", err, ErrManifestMismatch)
+	}
+	if err := b.WriteFile("vfs://tree/secret", []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteFile("vfs://tree/added", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.VerifyAttestation("vfs://tree", sm, pub); !errors.Is(err, ErrManifestMismatch) {
+		t.Errorf("verifying with an added file: got %v, want %v", err, ErrManifestMismatch)
+	}
+}
@@ -0,0 +1,720 @@
+package smbfs
+
+import (
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/ioutil"
+)
+
+// Access rights.
+const (
+	fileReadData        uint32 = 0x00000001
+	fileWriteData       uint32 = 0x00000002
+	fileAppendData      uint32 = 0x00000004
+	fileReadEA          uint32 = 0x00000008
+	fileWriteEA         uint32 = 0x00000010
+	fileReadAttributes  uint32 = 0x00000080
+	fileWriteAttributes uint32 = 0x00000100
+	accessDelete        uint32 = 0x00010000
+	readControl         uint32 = 0x00020000
+	synchronize         uint32 = 0x00100000
+	maximumAllowed      uint32 = 0x02000000
+	genericAll          uint32 = 0x10000000
+	genericExecute      uint32 = 0x20000000
+	genericWrite        uint32 = 0x40000000
+	genericRead         uint32 = 0x80000000
+
+	fileAllAccess uint32 = 0x001f01ff
+	writeAccess          = fileWriteData | fileAppendData
+)
+
+// Create dispositions.
+const (
+	fileSupersede uint32 = iota
+	fileOpen
+	fileCreate
+	fileOpenIf
+	fileOverwrite
+	fileOverwriteIf
+)
+
+// Create actions.
+const (
+	fileSuperseded uint32 = iota
+	fileOpened
+	fileCreated
+	fileOverwritten
+)
+
+// Create options.
+const (
+	optDirectory     uint32 = 0x00000001
+	optNonDirectory  uint32 = 0x00000040
+	optDeleteOnClose uint32 = 0x00001000
+)
+
+// File attributes.
+const (
+	attrReadOnly  uint32 = 0x01
+	attrDirectory uint32 = 0x10
+	attrArchive   uint32 = 0x20
+)
+
+// openFile is a file or directory opened by a client.
+type openFile struct {
+	id   uint64
+	sess *session
+	tree *tree
+	fs   absfs.FileSystem
+	// name is the path of the file on fs
+	name string
+	// f is nil for directories, and files opened without access to their
+	// contents
+	f             absfs.File
+	dir           bool
+	access        uint32
+	deleteOnClose bool
+
+	// listing is the directory listing a client is going through with
+	// QUERY_DIRECTORY requests
+	listing []dirEntry
+	listed  bool
+}
+
+// dirEntry is an entry of a directory listing.
+type dirEntry struct {
+	name string
+	path string
+	info os.FileInfo
+}
+
+func (f *openFile) stat() (os.FileInfo, error) {
+	if f.f != nil {
+		return f.f.Stat()
+	}
+
+	return f.fs.Stat(f.name)
+}
+
+// fsPath returns the path on a share's FileSystem of name, a path relative
+// to the root of the share separated by backslashes.
+func fsPath(name string) (string, bool) {
+	name = strings.TrimPrefix(name, `\`)
+	if name == "" {
+		return "/", true
+	}
+
+	parts := strings.Split(name, `\`)
+	for _, p := range parts {
+		if p == "" || p == "." || p == ".." || strings.ContainsAny(p, "/:*?\"<>|\x00") {
+			return "", false
+		}
+	}
+
+	return "/" + strings.Join(parts, "/"), true
+}
+
+// fileID returns the ID a file is known to clients by, which is stable as
+// long as its path is.
+func fileID(name string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+
+	return h.Sum64()
+}
+
+// grant returns the access rights granted for desired access, with generic
+// rights mapped to specific ones.
+func grant(access uint32) uint32 {
+	if access&(genericAll|maximumAllowed) != 0 {
+		access |= fileAllAccess
+	}
+	if access&genericRead != 0 {
+		access |= fileReadData | fileReadAttributes | fileReadEA | readControl | synchronize
+	}
+	if access&genericWrite != 0 {
+		access |= writeAccess | fileWriteAttributes | fileWriteEA | synchronize
+	}
+	if access&genericExecute != 0 {
+		access |= fileReadAttributes | synchronize
+	}
+
+	return access & fileAllAccess
+}
+
+// file returns the file a request is for, whose FileId is at off in the
+// request's body.
+func (c *conn) file(r *request, sess *session, t *tree, off int) (*openFile, uint32) {
+	// the persistent and volatile parts of FileIds are the same
+	id := le.Uint64(r.body[off:])
+	if r.h.flags&flagRelated != 0 && id == ^uint64(0) {
+		id = r.related.fileID
+	}
+	f := c.files[id]
+	if f == nil || f.sess != sess || f.tree != t {
+		return nil, statusFileClosed
+	}
+	r.related.fileID = id
+
+	return f, statusSuccess
+}
+
+func (c *conn) create(r *request, sess *session, t *tree) (uint32, []byte) {
+	b := r.body
+	if len(b) < 56 {
+		return statusInvalidParameter, nil
+	}
+	access, disposition, options := grant(le.Uint32(b[24:])), le.Uint32(b[36:]), le.Uint32(b[40:])
+	raw, ok := field(r.raw, int(le.Uint16(b[44:])), int(le.Uint16(b[46:])))
+	if !ok || disposition > fileOverwriteIf {
+		return statusInvalidParameter, nil
+	}
+	name, ok := fsPath(decodeString(raw))
+	if !ok {
+		return statusObjectNameInvalid, nil
+	}
+	fs := t.share.fs
+
+	info, err := fs.Stat(name)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return statusOf(err), nil
+	}
+	switch {
+	case exists && disposition == fileCreate:
+		return statusObjectNameCollision, nil
+	case !exists && (disposition == fileOpen || disposition == fileOverwrite):
+		if _, err := fs.Stat(path.Dir(name)); err != nil {
+			return statusObjectPathNotFound, nil
+		}
+		return statusObjectNameNotFound, nil
+	case exists && info.IsDir() && options&optNonDirectory != 0:
+		return statusFileIsADirectory, nil
+	case exists && !info.IsDir() && options&optDirectory != 0:
+		return statusNotADirectory, nil
+	}
+	overwrite := disposition == fileSupersede || disposition == fileOverwrite || disposition == fileOverwriteIf
+	if exists && info.IsDir() && overwrite {
+		return statusFileIsADirectory, nil
+	}
+
+	f := &openFile{
+		sess:          sess,
+		tree:          t,
+		fs:            fs,
+		name:          name,
+		dir:           exists && info.IsDir() || !exists && options&optDirectory != 0,
+		access:        access,
+		deleteOnClose: options&optDeleteOnClose != 0,
+	}
+	if f.deleteOnClose && access&accessDelete == 0 {
+		return statusAccessDenied, nil
+	}
+	action := fileOpened
+	switch {
+	case !exists && f.dir:
+		err = fs.Mkdir(name, 0700)
+		action = fileCreated
+	case !exists:
+		f.f, err = fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		action = fileCreated
+	case f.dir:
+	case overwrite:
+		f.f, err = fs.OpenFile(name, os.O_RDWR|os.O_TRUNC, 0)
+		action = fileOverwritten
+		if disposition == fileSupersede {
+			action = fileSuperseded
+		}
+	case access&writeAccess != 0:
+		f.f, err = fs.OpenFile(name, os.O_RDWR, 0)
+		if os.IsPermission(err) && le.Uint32(b[24:])&maximumAllowed != 0 {
+			// the client asked for as much access as it can get, which
+			// may be less than it would like
+			f.access &^= writeAccess
+			f.f, err = fs.OpenFile(name, os.O_RDONLY, 0)
+		}
+	case access&fileReadData != 0:
+		f.f, err = fs.OpenFile(name, os.O_RDONLY, 0)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return statusObjectPathNotFound, nil
+		}
+		return statusOf(err), nil
+	}
+	if info, err = f.stat(); err != nil {
+		if f.f != nil {
+			f.f.Close()
+		}
+		return statusOf(err), nil
+	}
+
+	f.id = c.nextID()
+	c.files[f.id] = f
+	r.related.fileID = f.id
+
+	resp := make([]byte, 88)
+	le.PutUint16(resp, 89)
+	le.PutUint32(resp[4:], action)
+	putTimes(resp[8:], info)
+	le.PutUint64(resp[40:], allocationSize(info))
+	le.PutUint64(resp[48:], uint64(info.Size()))
+	le.PutUint32(resp[56:], attributes(info))
+	le.PutUint64(resp[64:], f.id)
+	le.PutUint64(resp[72:], f.id)
+
+	return statusSuccess, resp
+}
+
+func (c *conn) close(r *request, sess *session, t *tree) (uint32, []byte) {
+	if len(r.body) < 24 {
+		return statusInvalidParameter, nil
+	}
+	f, status := c.file(r, sess, t, 8)
+	if f == nil {
+		return status, nil
+	}
+
+	resp := make([]byte, 60)
+	le.PutUint16(resp, 60)
+	// the attributes of the file after closing it were asked for
+	if le.Uint16(r.body[2:])&0x1 != 0 && !f.deleteOnClose {
+		if info, err := f.stat(); err == nil {
+			le.PutUint16(resp[2:], 0x1)
+			putTimes(resp[8:], info)
+			le.PutUint64(resp[40:], allocationSize(info))
+			le.PutUint64(resp[48:], uint64(info.Size()))
+			le.PutUint32(resp[56:], attributes(info))
+		}
+	}
+	if status := c.closeFile(f); status != statusSuccess {
+		return status, nil
+	}
+
+	return statusSuccess, resp
+}
+
+// closeFile closes a file, and removes it if it was to be deleted on
+// close.
+func (c *conn) closeFile(f *openFile) uint32 {
+	delete(c.files, f.id)
+
+	var err error
+	if f.f != nil {
+		err = f.f.Close()
+	}
+	if f.deleteOnClose {
+		if err1 := f.fs.Remove(f.name); err == nil {
+			err = err1
+		}
+	}
+
+	return statusOf(err)
+}
+
+func (c *conn) flush(r *request, sess *session, t *tree) (uint32, []byte) {
+	if len(r.body) < 24 {
+		return statusInvalidParameter, nil
+	}
+	f, status := c.file(r, sess, t, 8)
+	if f == nil {
+		return status, nil
+	}
+	if f.f != nil {
+		if err := f.f.Sync(); err != nil {
+			return statusOf(err), nil
+		}
+	}
+
+	return statusSuccess, []byte{4, 0, 0, 0}
+}
+
+func (c *conn) read(r *request, sess *session, t *tree) (uint32, []byte) {
+	b := r.body
+	if len(b) < 48 {
+		return statusInvalidParameter, nil
+	}
+	size, off, minCount := le.Uint32(b[4:]), le.Uint64(b[8:]), le.Uint32(b[32:])
+	if size > maxIOSize || off > math.MaxInt64 {
+		return statusInvalidParameter, nil
+	}
+	f, status := c.file(r, sess, t, 16)
+	if f == nil {
+		return status, nil
+	}
+	if f.dir {
+		return statusInvalidParameter, nil
+	}
+	if f.f == nil || f.access&fileReadData == 0 {
+		return statusAccessDenied, nil
+	}
+
+	resp := make([]byte, 16+size)
+	n, err := f.f.ReadAt(resp[16:], int64(off))
+	if err != nil && err != io.EOF {
+		return statusOf(err), nil
+	}
+	if n == 0 && size > 0 || uint32(n) < minCount {
+		return statusEndOfFile, nil
+	}
+	le.PutUint16(resp, 17)
+	resp[2] = headerSize + 16
+	le.PutUint32(resp[4:], uint32(n))
+
+	return statusSuccess, resp[:16+n]
+}
+
+func (c *conn) write(r *request, sess *session, t *tree) (uint32, []byte) {
+	b := r.body
+	if len(b) < 48 {
+		return statusInvalidParameter, nil
+	}
+	data, ok := field(r.raw, int(le.Uint16(b[2:])), int(le.Uint32(b[4:])))
+	off := le.Uint64(b[8:])
+	if !ok || off > math.MaxInt64 && off != ^uint64(0) {
+		return statusInvalidParameter, nil
+	}
+	f, status := c.file(r, sess, t, 16)
+	if f == nil {
+		return status, nil
+	}
+	if f.dir {
+		return statusInvalidParameter, nil
+	}
+	if f.f == nil || f.access&writeAccess == 0 {
+		return statusAccessDenied, nil
+	}
+
+	// an offset of all ones appends
+	if off == ^uint64(0) {
+		info, err := f.f.Stat()
+		if err != nil {
+			return statusOf(err), nil
+		}
+		off = uint64(info.Size())
+	}
+	n, err := f.f.WriteAt(data, int64(off))
+	if err != nil {
+		return statusOf(err), nil
+	}
+
+	resp := make([]byte, 16)
+	le.PutUint16(resp, 17)
+	le.PutUint32(resp[4:], uint32(n))
+
+	return statusSuccess, resp
+}
+
+// Query directory flags.
+const (
+	restartScans      = 0x01
+	returnSingleEntry = 0x02
+	reopen            = 0x10
+)
+
+func (c *conn) queryDirectory(r *request, sess *session, t *tree) (uint32, []byte) {
+	b := r.body
+	if len(b) < 32 {
+		return statusInvalidParameter, nil
+	}
+	class, flags, outLen := b[2], b[3], min(le.Uint32(b[28:]), maxIOSize)
+	raw, ok := field(r.raw, int(le.Uint16(b[24:])), int(le.Uint16(b[26:])))
+	if !ok {
+		return statusInvalidParameter, nil
+	}
+	if _, ok := dirEntrySizes[class]; !ok {
+		return statusInvalidInfoClass, nil
+	}
+	f, status := c.file(r, sess, t, 8)
+	if f == nil {
+		return status, nil
+	}
+	if !f.dir {
+		return statusInvalidParameter, nil
+	}
+
+	fresh := !f.listed || flags&(restartScans|reopen) != 0
+	if fresh {
+		pattern := decodeString(raw)
+		if pattern == "" {
+			pattern = "*"
+		}
+		if err := f.list(pattern); err != nil {
+			return statusOf(err), nil
+		}
+	}
+	if len(f.listing) == 0 {
+		if fresh {
+			return statusNoSuchFile, nil
+		}
+		return statusNoMoreFiles, nil
+	}
+
+	var out []byte
+	last := -1
+	for len(f.listing) > 0 {
+		e := encodeDirEntry(class, f.listing[0])
+		start := (len(out) + 7) &^ 7
+		if start+len(e) > int(outLen) {
+			break
+		}
+		if last >= 0 {
+			le.PutUint32(out[last:], uint32(start-last))
+		}
+		out = append(out, make([]byte, start-len(out))...)
+		out = append(out, e...)
+		last = start
+		f.listing = f.listing[1:]
+		if flags&returnSingleEntry != 0 {
+			break
+		}
+	}
+	if out == nil {
+		return statusInfoLengthMismatch, nil
+	}
+
+	resp := make([]byte, 8, 8+len(out))
+	le.PutUint16(resp, 9)
+	le.PutUint16(resp[2:], headerSize+8)
+	le.PutUint32(resp[4:], uint32(len(out)))
+
+	return statusSuccess, append(resp, out...)
+}
+
+// list lists the entries of a directory matching pattern, including . and
+// .., which clients expect.
+func (f *openFile) list(pattern string) error {
+	infos, err := ioutil.ReadDir(f.fs, f.name)
+	if err != nil {
+		return err
+	}
+	self, err := f.fs.Stat(f.name)
+	if err != nil {
+		return err
+	}
+	parent, err := f.fs.Stat(path.Dir(f.name))
+	if err != nil {
+		return err
+	}
+
+	f.listed, f.listing = true, nil
+	entries := []dirEntry{{".", f.name, self}, {"..", path.Dir(f.name), parent}}
+	for _, info := range infos {
+		entries = append(entries, dirEntry{info.Name(), path.Join(f.name, info.Name()), info})
+	}
+	for _, e := range entries {
+		if match(pattern, e.name) {
+			f.listing = append(f.listing, e)
+		}
+	}
+
+	return nil
+}
+
+// match reports whether name matches pattern, in which * matches any
+// sequence of characters and ? any single one. The DOS wildcards <, > and
+// " are treated as *, ? and . respectively.
+func match(pattern, name string) bool {
+	p, n := []rune(pattern), []rune(name)
+	// star is the position of the last * in p, and next the position in
+	// n it's matched up to, to backtrack to after a mismatch
+	star, next := -1, 0
+	i, j := 0, 0
+	for j < len(n) {
+		switch {
+		case i < len(p) && (p[i] == '*' || p[i] == '<'):
+			star, next = i, j
+			i++
+		case i < len(p) && (p[i] == n[j] || p[i] == '?' || p[i] == '>' || p[i] == '"' && n[j] == '.'):
+			i++
+			j++
+		case star >= 0:
+			next++
+			i, j = star+1, next
+		default:
+			return false
+		}
+	}
+	for i < len(p) && (p[i] == '*' || p[i] == '<') {
+		i++
+	}
+
+	return i == len(p)
+}
+
+func (c *conn) queryInfo(r *request, sess *session, t *tree) (uint32, []byte) {
+	b := r.body
+	if len(b) < 40 {
+		return statusInvalidParameter, nil
+	}
+	infoType, class, outLen, extra := b[2], b[3], le.Uint32(b[4:]), le.Uint32(b[16:])
+	f, status := c.file(r, sess, t, 24)
+	if f == nil {
+		return status, nil
+	}
+
+	var (
+		data  []byte
+		fixed int
+	)
+	switch infoType {
+	case infoFile:
+		data, fixed, status = f.fileInfo(class)
+	case infoFileSystem:
+		data, fixed, status = fsInfo(class, t.share.name)
+	case infoSecurity:
+		data = securityDescriptor(extra)
+		if int(outLen) < len(data) {
+			resp := make([]byte, 12)
+			le.PutUint16(resp, 9)
+			le.PutUint32(resp[4:], 4)
+			le.PutUint32(resp[8:], uint32(len(data)))
+			return statusBufferTooSmall, resp
+		}
+	default:
+		status = statusInvalidParameter
+	}
+	if status != statusSuccess {
+		return status, nil
+	}
+	if int(outLen) < fixed {
+		return statusInfoLengthMismatch, nil
+	}
+	if int(outLen) < len(data) {
+		data, status = data[:outLen], statusBufferOverflow
+	}
+
+	resp := make([]byte, 8, 8+len(data))
+	le.PutUint16(resp, 9)
+	le.PutUint16(resp[2:], headerSize+8)
+	le.PutUint32(resp[4:], uint32(len(data)))
+
+	return status, append(resp, data...)
+}
+
+func (c *conn) setInfo(r *request, sess *session, t *tree) (uint32, []byte) {
+	b := r.body
+	if len(b) < 32 {
+		return statusInvalidParameter, nil
+	}
+	infoType, class := b[2], b[3]
+	buf, ok := field(r.raw, int(le.Uint16(b[8:])), int(le.Uint32(b[4:])))
+	if !ok {
+		return statusInvalidParameter, nil
+	}
+	f, status := c.file(r, sess, t, 16)
+	if f == nil {
+		return status, nil
+	}
+	if infoType != infoFile {
+		return statusNotSupported, nil
+	}
+
+	if status := f.setFileInfo(class, buf); status != statusSuccess {
+		return status, nil
+	}
+
+	return statusSuccess, []byte{2, 0}
+}
+
+// setFileInfo changes the information of class of a file to buf.
+func (f *openFile) setFileInfo(class byte, buf []byte) uint32 {
+	switch class {
+	case fileBasicInformation:
+		if len(buf) < 36 {
+			return statusInfoLengthMismatch
+		}
+		if f.access&fileWriteAttributes == 0 {
+			return statusAccessDenied
+		}
+		atime, mtime := le.Uint64(buf[8:]), le.Uint64(buf[16:])
+		if !setsTime(atime) && !setsTime(mtime) {
+			return statusSuccess
+		}
+		info, err := f.stat()
+		if err != nil {
+			return statusOf(err)
+		}
+		at, mt := info.ModTime(), info.ModTime()
+		if setsTime(atime) {
+			at = fromFiletime(atime)
+		}
+		if setsTime(mtime) {
+			mt = fromFiletime(mtime)
+		}
+		return statusOf(f.fs.Chtimes(f.name, at, mt))
+	case fileRenameInformation:
+		if len(buf) < 20 {
+			return statusInfoLengthMismatch
+		}
+		if f.access&accessDelete == 0 {
+			return statusAccessDenied
+		}
+		raw, ok := field(buf, 20, int(le.Uint32(buf[16:])))
+		if !ok {
+			return statusInvalidParameter
+		}
+		name, ok := fsPath(decodeString(raw))
+		if !ok || name == "/" {
+			return statusObjectNameInvalid
+		}
+		if _, err := f.fs.Stat(name); err == nil && buf[0] == 0 {
+			return statusObjectNameCollision
+		}
+		if err := f.fs.Rename(f.name, name); err != nil {
+			return statusOf(err)
+		}
+		f.name = name
+	case fileDispositionInformation:
+		if len(buf) < 1 {
+			return statusInfoLengthMismatch
+		}
+		if f.access&accessDelete == 0 {
+			return statusAccessDenied
+		}
+		if buf[0] != 0 && f.dir {
+			if infos, err := ioutil.ReadDir(f.fs, f.name); err != nil {
+				return statusOf(err)
+			} else if len(infos) > 0 {
+				return statusDirectoryNotEmpty
+			}
+		}
+		f.deleteOnClose = buf[0] != 0
+	case fileAllocationInformation:
+		// allocation isn't under the client's control
+		if len(buf) < 8 {
+			return statusInfoLengthMismatch
+		}
+	case fileEndOfFileInformation:
+		if len(buf) < 8 {
+			return statusInfoLengthMismatch
+		}
+		size := le.Uint64(buf)
+		if f.dir || size > math.MaxInt64 {
+			return statusInvalidParameter
+		}
+		if f.access&fileWriteData == 0 {
+			return statusAccessDenied
+		}
+		if f.f != nil {
+			return statusOf(f.f.Truncate(int64(size)))
+		}
+		return statusOf(f.fs.Truncate(f.name, int64(size)))
+	default:
+		return statusInvalidInfoClass
+	}
+
+	return statusSuccess
+}
+
+// setsTime reports whether a time in FileBasicInformation changes a time,
+// which zero and all ones leave unchanged.
+func setsTime(ft uint64) bool {
+	return ft != 0 && ft != ^uint64(0)
+}
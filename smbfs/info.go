@@ -0,0 +1,305 @@
+package smbfs
+
+import (
+	"os"
+	"strings"
+)
+
+// Info types of QUERY_INFO and SET_INFO.
+const (
+	infoFile       = 1
+	infoFileSystem = 2
+	infoSecurity   = 3
+)
+
+// File information classes.
+const (
+	fileDirectoryInformation       = 1
+	fileFullDirectoryInformation   = 2
+	fileBothDirectoryInformation   = 3
+	fileBasicInformation           = 4
+	fileStandardInformation        = 5
+	fileInternalInformation        = 6
+	fileEaInformation              = 7
+	fileAccessInformation          = 8
+	fileRenameInformation          = 10
+	fileNamesInformation           = 12
+	fileDispositionInformation     = 13
+	filePositionInformation        = 14
+	fileModeInformation            = 16
+	fileAlignmentInformation       = 17
+	fileAllInformation             = 18
+	fileAllocationInformation      = 19
+	fileEndOfFileInformation       = 20
+	fileStreamInformation          = 22
+	fileNetworkOpenInformation     = 34
+	fileAttributeTagInformation    = 35
+	fileIDBothDirectoryInformation = 37
+	fileIDFullDirectoryInformation = 38
+)
+
+// File system information classes.
+const (
+	fileFsVolumeInformation     = 1
+	fileFsSizeInformation       = 3
+	fileFsDeviceInformation     = 4
+	fileFsAttributeInformation  = 5
+	fileFsFullSizeInformation   = 7
+	fileFsSectorSizeInformation = 11
+)
+
+// The geometry file systems are reported to have. FileSystems can't tell
+// how much space they have, so they claim a terabyte, all of it free.
+const (
+	bytesPerSector  = 512
+	sectorsPerUnit  = 8
+	allocationUnits = 1 << 40 / (bytesPerSector * sectorsPerUnit)
+)
+
+// dirEntrySizes are the sizes of the fixed parts of the directory entries
+// of each supported information class.
+var dirEntrySizes = map[byte]int{
+	fileDirectoryInformation:       64,
+	fileFullDirectoryInformation:   68,
+	fileBothDirectoryInformation:   94,
+	fileNamesInformation:           12,
+	fileIDBothDirectoryInformation: 104,
+	fileIDFullDirectoryInformation: 80,
+}
+
+// encodeDirEntry encodes a directory entry of class, without the offset of
+// the next one.
+func encodeDirEntry(class byte, e dirEntry) []byte {
+	name := encodeString(e.name)
+	size := dirEntrySizes[class]
+	b := make([]byte, size+len(name))
+	copy(b[size:], name)
+
+	if class == fileNamesInformation {
+		le.PutUint32(b[8:], uint32(len(name)))
+		return b
+	}
+	putTimes(b[8:], e.info)
+	le.PutUint64(b[40:], uint64(e.info.Size()))
+	le.PutUint64(b[48:], allocationSize(e.info))
+	le.PutUint32(b[56:], attributes(e.info))
+	le.PutUint32(b[60:], uint32(len(name)))
+	switch class {
+	case fileIDFullDirectoryInformation:
+		le.PutUint64(b[72:], fileID(e.path))
+	case fileIDBothDirectoryInformation:
+		le.PutUint64(b[96:], fileID(e.path))
+	}
+
+	return b
+}
+
+// putTimes puts the creation, last access, last write and change times of
+// a file, in that order. FileSystems only know modification times, so
+// they're used for all four.
+func putTimes(b []byte, info os.FileInfo) {
+	t := filetime(info.ModTime())
+	for i := 0; i < 4; i++ {
+		le.PutUint64(b[8*i:], t)
+	}
+}
+
+func allocationSize(info os.FileInfo) uint64 {
+	const unit = bytesPerSector * sectorsPerUnit
+	return (uint64(info.Size()) + unit - 1) / unit * unit
+}
+
+func attributes(info os.FileInfo) uint32 {
+	if info.IsDir() {
+		return attrDirectory
+	}
+	if info.Mode().Perm()&0200 == 0 {
+		return attrArchive | attrReadOnly
+	}
+
+	return attrArchive
+}
+
+// fileInfo returns the information of class about a file, and the size of
+// its fixed part.
+func (f *openFile) fileInfo(class byte) ([]byte, int, uint32) {
+	info, err := f.stat()
+	if err != nil {
+		return nil, 0, statusOf(err)
+	}
+
+	var b []byte
+	switch class {
+	case fileBasicInformation:
+		b = basicInfo(info)
+	case fileStandardInformation:
+		b = f.standardInfo(info)
+	case fileInternalInformation:
+		b = make([]byte, 8)
+		le.PutUint64(b, fileID(f.name))
+	case fileEaInformation, filePositionInformation, fileModeInformation, fileAlignmentInformation:
+		b = make([]byte, 4)
+		if class == filePositionInformation {
+			b = make([]byte, 8)
+		}
+	case fileAccessInformation:
+		b = make([]byte, 4)
+		le.PutUint32(b, f.access)
+	case fileAllInformation:
+		// basic, standard, internal, EA, access, position, mode and
+		// alignment information, followed by the file's name
+		name := encodeString(strings.ReplaceAll(f.name, "/", `\`))
+		b = append(basicInfo(info), f.standardInfo(info)...)
+		b = append(b, make([]byte, 36)...)
+		le.PutUint64(b[64:], fileID(f.name))
+		le.PutUint32(b[76:], f.access)
+		le.PutUint32(b[96:], uint32(len(name)))
+		return append(b, name...), 100, statusSuccess
+	case fileStreamInformation:
+		// directories have no streams, files only their unnamed data
+		// stream
+		if info.IsDir() {
+			return nil, 0, statusSuccess
+		}
+		name := encodeString("::$DATA")
+		b = make([]byte, 24, 24+len(name))
+		le.PutUint32(b[4:], uint32(len(name)))
+		le.PutUint64(b[8:], uint64(info.Size()))
+		le.PutUint64(b[16:], allocationSize(info))
+		return append(b, name...), 24, statusSuccess
+	case fileNetworkOpenInformation:
+		b = make([]byte, 56)
+		putTimes(b, info)
+		le.PutUint64(b[32:], allocationSize(info))
+		le.PutUint64(b[40:], uint64(info.Size()))
+		le.PutUint32(b[48:], attributes(info))
+	case fileAttributeTagInformation:
+		b = make([]byte, 8)
+		le.PutUint32(b, attributes(info))
+	default:
+		return nil, 0, statusInvalidInfoClass
+	}
+
+	return b, len(b), statusSuccess
+}
+
+func basicInfo(info os.FileInfo) []byte {
+	b := make([]byte, 40)
+	putTimes(b, info)
+	le.PutUint32(b[32:], attributes(info))
+
+	return b
+}
+
+func (f *openFile) standardInfo(info os.FileInfo) []byte {
+	b := make([]byte, 24)
+	le.PutUint64(b, allocationSize(info))
+	le.PutUint64(b[8:], uint64(info.Size()))
+	le.PutUint32(b[16:], 1)
+	if f.deleteOnClose {
+		b[20] = 1
+	}
+	if info.IsDir() {
+		b[21] = 1
+	}
+
+	return b
+}
+
+// fsInfo returns the information of class about the file system of the
+// share label, and the size of its fixed part.
+func fsInfo(class byte, label string) ([]byte, int, uint32) {
+	var b []byte
+	switch class {
+	case fileFsVolumeInformation:
+		name := encodeString(label)
+		b = make([]byte, 18, 18+len(name))
+		le.PutUint32(b[8:], uint32(fileID(label)))
+		le.PutUint32(b[12:], uint32(len(name)))
+		return append(b, name...), 18, statusSuccess
+	case fileFsSizeInformation:
+		b = make([]byte, 24)
+		le.PutUint64(b, allocationUnits)
+		le.PutUint64(b[8:], allocationUnits)
+		le.PutUint32(b[16:], sectorsPerUnit)
+		le.PutUint32(b[20:], bytesPerSector)
+	case fileFsFullSizeInformation:
+		b = make([]byte, 32)
+		le.PutUint64(b, allocationUnits)
+		le.PutUint64(b[8:], allocationUnits)
+		le.PutUint64(b[16:], allocationUnits)
+		le.PutUint32(b[24:], sectorsPerUnit)
+		le.PutUint32(b[28:], bytesPerSector)
+	case fileFsDeviceInformation:
+		// FILE_DEVICE_DISK
+		b = make([]byte, 8)
+		le.PutUint32(b, 7)
+	case fileFsAttributeInformation:
+		// case sensitive search, case preserved names and Unicode names.
+		// Clients only enable features they rely on for some file system
+		// names, so none of theirs is claimed.
+		name := encodeString("PANDORASBOX")
+		b = make([]byte, 12, 12+len(name))
+		le.PutUint32(b, 0x7)
+		le.PutUint32(b[4:], 255)
+		le.PutUint32(b[8:], uint32(len(name)))
+		return append(b, name...), 12, statusSuccess
+	case fileFsSectorSizeInformation:
+		b = make([]byte, 28)
+		for i := 0; i < 4; i++ {
+			le.PutUint32(b[4*i:], bytesPerSector)
+		}
+	default:
+		return nil, 0, statusInvalidInfoClass
+	}
+
+	return b, len(b), statusSuccess
+}
+
+// everyone is the SID of the Everyone group, S-1-1-0.
+var everyone = []byte{1, 1, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0}
+
+// Security information asked for in QUERY_INFO.
+const (
+	ownerSecurityInformation = 0x1
+	groupSecurityInformation = 0x2
+	daclSecurityInformation  = 0x4
+)
+
+// securityDescriptor returns a self-relative security descriptor with the
+// parts asked for in extra. Files are owned by and fully accessible to
+// Everyone, as access is controlled by share passwords and the FileSystem.
+func securityDescriptor(extra uint32) []byte {
+	b := make([]byte, 20)
+	b[0] = 1
+	control := uint16(0x8000)
+	if extra&ownerSecurityInformation != 0 {
+		le.PutUint32(b[4:], uint32(len(b)))
+		b = append(b, everyone...)
+	}
+	if extra&groupSecurityInformation != 0 {
+		le.PutUint32(b[8:], uint32(len(b)))
+		b = append(b, everyone...)
+	}
+	if extra&daclSecurityInformation != 0 {
+		control |= 0x4
+		le.PutUint32(b[16:], uint32(len(b)))
+
+		// one ACE allowing everything to Everyone, inherited by files and
+		// directories
+		ace := make([]byte, 8, 8+len(everyone))
+		ace[1] = 0x3
+		le.PutUint16(ace[2:], uint16(8+len(everyone)))
+		le.PutUint32(ace[4:], fileAllAccess)
+		ace = append(ace, everyone...)
+
+		acl := make([]byte, 8, 8+len(ace))
+		acl[0] = 2
+		le.PutUint16(acl[2:], uint16(8+len(ace)))
+		le.PutUint16(acl[4:], 1)
+		b = append(b, append(acl, ace...)...)
+	}
+	le.PutUint16(b[2:], control)
+
+	return b
+}
@@ -0,0 +1,407 @@
+package smbfs
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/md4"
+)
+
+// Clients authenticate with NTLMv2, wrapped in SPNEGO. NTLMv1 and anonymous
+// logons are refused.
+
+var (
+	errLogonFailure = errors.New("smbfs: logon failure")
+	errBadToken     = errors.New("smbfs: malformed security token")
+)
+
+var (
+	oidSPNEGO = []byte{0x06, 0x06, 0x2b, 0x06, 0x01, 0x05, 0x05, 0x02}
+	oidNTLM   = []byte{0x06, 0x0a, 0x2b, 0x06, 0x01, 0x04, 0x01, 0x82, 0x37, 0x02, 0x02, 0x0a}
+
+	ntlmSignature = []byte("NTLMSSP\x00")
+)
+
+// NTLM negotiate flags.
+const (
+	ntlmUnicode          uint32 = 0x00000001
+	ntlmRequestTarget    uint32 = 0x00000004
+	ntlmSign             uint32 = 0x00000010
+	ntlmSeal             uint32 = 0x00000020
+	ntlmNTLM             uint32 = 0x00000200
+	ntlmAlwaysSign       uint32 = 0x00008000
+	ntlmTargetTypeServer uint32 = 0x00020000
+	ntlmExtendedSecurity uint32 = 0x00080000
+	ntlmTargetInfo       uint32 = 0x00800000
+	ntlmVersion          uint32 = 0x02000000
+	ntlm128              uint32 = 0x20000000
+	ntlmKeyExchange      uint32 = 0x40000000
+	ntlm56               uint32 = 0x80000000
+
+	// ntlmChallengeFlags are always set in challenges, and
+	// ntlmOptionalFlags if the client asked for them.
+	ntlmChallengeFlags = ntlmUnicode | ntlmRequestTarget | ntlmNTLM | ntlmAlwaysSign |
+		ntlmTargetTypeServer | ntlmExtendedSecurity | ntlmTargetInfo | ntlmVersion
+	ntlmOptionalFlags = ntlmSign | ntlmSeal | ntlmKeyExchange | ntlm128 | ntlm56
+)
+
+const (
+	ntlmChallengeHeaderSize    = 56
+	ntlmAuthenticateHeaderSize = 88
+	ntlmMICOffset              = 72
+)
+
+// AV pair IDs of target info and NTLMv2 blobs.
+const (
+	avEOL             uint16 = 0
+	avNbComputerName  uint16 = 1
+	avNbDomainName    uint16 = 2
+	avDNSComputerName uint16 = 3
+	avDNSDomainName   uint16 = 4
+	avFlags           uint16 = 6
+	avTimestamp       uint16 = 7
+
+	avFlagMIC = 0x2
+)
+
+// der encodes a DER value with tag and the concatenation of content.
+func der(tag byte, content ...[]byte) []byte {
+	c := bytes.Join(content, nil)
+	b := []byte{tag}
+	switch n := len(c); {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	default:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	}
+
+	return append(b, c...)
+}
+
+// parseDER splits the first DER value off b.
+func parseDER(b []byte) (tag byte, content, rest []byte, err error) {
+	if len(b) < 2 {
+		return 0, nil, nil, errBadToken
+	}
+	tag, n, b := b[0], int(b[1]), b[2:]
+	if n&0x80 != 0 {
+		size := n & 0x7f
+		if size == 0 || size > 3 || len(b) < size {
+			return 0, nil, nil, errBadToken
+		}
+		n = 0
+		for _, c := range b[:size] {
+			n = n<<8 | int(c)
+		}
+		b = b[size:]
+	}
+	if n > len(b) {
+		return 0, nil, nil, errBadToken
+	}
+
+	return tag, b[:n], b[n:], nil
+}
+
+// derFields returns the context-specific fields of a DER sequence by
+// number.
+func derFields(seq []byte) (map[byte][]byte, error) {
+	fields := make(map[byte][]byte)
+	for len(seq) > 0 {
+		tag, content, rest, err := parseDER(seq)
+		if err != nil {
+			return nil, err
+		}
+		fields[tag&^0xa0] = content
+		seq = rest
+	}
+
+	return fields, nil
+}
+
+// negTokenInit returns the SPNEGO hint sent in negotiate responses, which
+// offers NTLM only.
+func negTokenInit() []byte {
+	return der(0x60, oidSPNEGO, der(0xa0, der(0x30, der(0xa0, der(0x30, oidNTLM)))))
+}
+
+// SPNEGO negotiation states.
+const (
+	negAcceptCompleted  = 0
+	negAcceptIncomplete = 1
+)
+
+// negTokenResp returns a SPNEGO response with state, and token if it isn't
+// nil. The first response names NTLM as the selected mechanism.
+func negTokenResp(state byte, first bool, token []byte) []byte {
+	fields := [][]byte{der(0xa0, der(0x0a, []byte{state}))}
+	if first {
+		fields = append(fields, der(0xa1, oidNTLM))
+	}
+	if token != nil {
+		fields = append(fields, der(0xa2, der(0x04, token)))
+	}
+
+	return der(0xa1, der(0x30, fields...))
+}
+
+// unwrapToken returns the NTLM message in a security buffer, which is
+// either a SPNEGO token or a raw NTLM message. ok is false if the client
+// offered NTLM but optimistically sent a token for another mechanism, or
+// none at all.
+func unwrapToken(b []byte) (msg []byte, spnego, ok bool, err error) {
+	if bytes.HasPrefix(b, ntlmSignature) {
+		return b, false, true, nil
+	}
+
+	tag, content, _, err := parseDER(b)
+	if err != nil {
+		return nil, true, false, err
+	}
+	switch tag {
+	case 0x60:
+		if !bytes.HasPrefix(content, oidSPNEGO) {
+			return nil, true, false, errBadToken
+		}
+		tag, content, _, err = parseDER(content[len(oidSPNEGO):])
+		if err != nil || tag != 0xa0 {
+			return nil, true, false, errBadToken
+		}
+		fields, err := innerFields(content)
+		if err != nil {
+			return nil, true, false, err
+		}
+		_, mechs, _, err := parseDER(fields[0])
+		if err != nil {
+			return nil, true, false, errBadToken
+		}
+		if !bytes.Contains(mechs, oidNTLM) {
+			return nil, true, false, errLogonFailure
+		}
+		token, err := octetString(fields[2])
+		if err != nil || !bytes.HasPrefix(mechs, oidNTLM) || !bytes.HasPrefix(token, ntlmSignature) {
+			return nil, true, false, nil
+		}
+		return token, true, true, nil
+	case 0xa1:
+		fields, err := innerFields(content)
+		if err != nil {
+			return nil, true, false, err
+		}
+		token, err := octetString(fields[2])
+		if err != nil || !bytes.HasPrefix(token, ntlmSignature) {
+			return nil, true, false, errBadToken
+		}
+		return token, true, true, nil
+	}
+
+	return nil, true, false, errBadToken
+}
+
+// innerFields returns the fields of the sequence in a SPNEGO token.
+func innerFields(b []byte) (map[byte][]byte, error) {
+	tag, content, _, err := parseDER(b)
+	if err != nil || tag != 0x30 {
+		return nil, errBadToken
+	}
+
+	return derFields(content)
+}
+
+func octetString(b []byte) ([]byte, error) {
+	tag, content, _, err := parseDER(b)
+	if err != nil || tag != 0x04 {
+		return nil, errBadToken
+	}
+
+	return content, nil
+}
+
+// ntlmServer is the server side of an NTLM authentication.
+type ntlmServer struct {
+	name      string
+	negotiate []byte
+	challenge []byte
+	nonce     [8]byte
+	flags     uint32
+}
+
+// challengeMessage returns the CHALLENGE_MESSAGE answering negotiate.
+func (n *ntlmServer) challengeMessage(negotiate []byte) ([]byte, error) {
+	if len(negotiate) < 16 || le.Uint32(negotiate[8:]) != 1 {
+		return nil, errBadToken
+	}
+	clientFlags := le.Uint32(negotiate[12:])
+	if clientFlags&ntlmUnicode == 0 {
+		return nil, errLogonFailure
+	}
+	if _, err := rand.Read(n.nonce[:]); err != nil {
+		return nil, err
+	}
+	n.flags = ntlmChallengeFlags | clientFlags&ntlmOptionalFlags
+
+	name := encodeString(strings.ToUpper(n.name))
+	var info []byte
+	for _, av := range []uint16{avNbDomainName, avNbComputerName, avDNSDomainName, avDNSComputerName} {
+		info = appendAV(info, av, name)
+	}
+	var ts [8]byte
+	le.PutUint64(ts[:], filetime(time.Now()))
+	info = appendAV(info, avTimestamp, ts[:])
+	info = appendAV(info, avEOL, nil)
+
+	msg := make([]byte, ntlmChallengeHeaderSize, ntlmChallengeHeaderSize+len(name)+len(info))
+	copy(msg, ntlmSignature)
+	le.PutUint32(msg[8:], 2)
+	putFields(msg[12:], len(name), ntlmChallengeHeaderSize)
+	le.PutUint32(msg[20:], n.flags)
+	copy(msg[24:], n.nonce[:])
+	putFields(msg[40:], len(info), ntlmChallengeHeaderSize+len(name))
+	// version 6.1.7601, NTLM revision 15
+	copy(msg[48:], []byte{6, 1, 0xb1, 0x1d, 0, 0, 0, 15})
+	msg = append(append(msg, name...), info...)
+
+	n.negotiate, n.challenge = negotiate, msg
+	return msg, nil
+}
+
+func appendAV(b []byte, id uint16, value []byte) []byte {
+	var hdr [4]byte
+	le.PutUint16(hdr[:], id)
+	le.PutUint16(hdr[2:], uint16(len(value)))
+
+	return append(append(b, hdr[:]...), value...)
+}
+
+func putFields(b []byte, size, off int) {
+	le.PutUint16(b, uint16(size))
+	le.PutUint16(b[2:], uint16(size))
+	le.PutUint32(b[4:], uint32(off))
+}
+
+// payload returns the payload described by the fields at off in msg.
+func payload(msg []byte, off int) ([]byte, error) {
+	if len(msg) < off+8 {
+		return nil, errBadToken
+	}
+	b, ok := field(msg, int(le.Uint32(msg[off+4:])), int(le.Uint16(msg[off:])))
+	if !ok {
+		return nil, errBadToken
+	}
+
+	return b, nil
+}
+
+// authenticated is the result of a successful authentication.
+type authenticated struct {
+	user       string
+	sessionKey []byte
+	// matched are the indexes of the NT hashes of the passwords the user
+	// proved they know
+	matched []int
+}
+
+// authenticate checks the NTLMv2 response in an AUTHENTICATE_MESSAGE
+// against each of hashes, the NT hashes of the passwords the user may
+// know.
+func (n *ntlmServer) authenticate(msg []byte, hashes [][]byte) (*authenticated, error) {
+	if n.challenge == nil || len(msg) < ntlmAuthenticateHeaderSize || !bytes.HasPrefix(msg, ntlmSignature) || le.Uint32(msg[8:]) != 3 {
+		return nil, errBadToken
+	}
+	var fields [6][]byte
+	for i := range fields {
+		b, err := payload(msg, 12+8*i)
+		if err != nil {
+			return nil, err
+		}
+		fields[i] = b
+	}
+	ntResponse, domain, user, encryptedKey := fields[1], decodeString(fields[2]), decodeString(fields[3]), fields[5]
+	// NTLMv1 responses are 24 bytes, NTLMv2 ones hold a proof and a blob
+	// of at least 28 bytes
+	if user == "" || len(ntResponse) < 16+28 {
+		return nil, errLogonFailure
+	}
+	proof, blob := ntResponse[:16], ntResponse[16:]
+
+	auth := &authenticated{user: user}
+	var baseKey []byte
+	for i, hash := range hashes {
+		key := hmacMD5(hash, encodeString(strings.ToUpper(user)+domain))
+		if subtle.ConstantTimeCompare(hmacMD5(key, n.nonce[:], blob), proof) == 1 {
+			baseKey = hmacMD5(key, proof)
+			auth.matched = append(auth.matched, i)
+		}
+	}
+	if baseKey == nil {
+		return nil, errLogonFailure
+	}
+
+	auth.sessionKey = baseKey
+	if n.flags&ntlmKeyExchange != 0 {
+		if len(encryptedKey) != 16 {
+			return nil, errBadToken
+		}
+		c, err := rc4.NewCipher(baseKey)
+		if err != nil {
+			return nil, err
+		}
+		auth.sessionKey = make([]byte, 16)
+		c.XORKeyStream(auth.sessionKey, encryptedKey)
+	}
+
+	if micPresent(blob) {
+		zeroed := bytes.Clone(msg)
+		copy(zeroed[ntlmMICOffset:], make([]byte, 16))
+		mic := msg[ntlmMICOffset : ntlmMICOffset+16]
+		if !hmac.Equal(hmacMD5(auth.sessionKey, n.negotiate, n.challenge, zeroed), mic) {
+			return nil, errLogonFailure
+		}
+	}
+
+	return auth, nil
+}
+
+// micPresent reports whether the AV pairs in an NTLMv2 blob say the
+// AUTHENTICATE_MESSAGE has a MIC.
+func micPresent(blob []byte) bool {
+	avs := blob[28:]
+	for len(avs) >= 4 {
+		id, size := le.Uint16(avs), int(le.Uint16(avs[2:]))
+		if id == avEOL || len(avs) < 4+size {
+			break
+		}
+		if id == avFlags && size == 4 {
+			return le.Uint32(avs[4:])&avFlagMIC != 0
+		}
+		avs = avs[4+size:]
+	}
+
+	return false
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+
+	return mac.Sum(nil)
+}
+
+// ntHash returns the NT hash of password, the MD4 hash of its UTF-16LE
+// encoding.
+func ntHash(password string) []byte {
+	h := md4.New()
+	h.Write(encodeString(password))
+
+	return h.Sum(nil)
+}
@@ -0,0 +1,274 @@
+package smbfs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"time"
+	"unicode/utf16"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+var le = binary.LittleEndian
+
+const (
+	headerSize = 64
+
+	// maxMessageSize bounds the messages read from clients. It leaves room
+	// for a compound of a write of maxIOSize bytes and a few small
+	// requests.
+	maxMessageSize = maxIOSize + 64<<10
+
+	// maxIOSize is the largest read or write a client may ask for. Without
+	// SMB2_GLOBAL_CAP_LARGE_MTU, clients never ask for more.
+	maxIOSize = 64 << 10
+)
+
+// Dialects.
+const (
+	dialect202      uint16 = 0x0202
+	dialect210      uint16 = 0x0210
+	dialectWildcard uint16 = 0x02ff
+)
+
+// Commands.
+const (
+	cmdNegotiate uint16 = iota
+	cmdSessionSetup
+	cmdLogoff
+	cmdTreeConnect
+	cmdTreeDisconnect
+	cmdCreate
+	cmdClose
+	cmdFlush
+	cmdRead
+	cmdWrite
+	cmdLock
+	cmdIoctl
+	cmdCancel
+	cmdEcho
+	cmdQueryDirectory
+	cmdChangeNotify
+	cmdQueryInfo
+	cmdSetInfo
+)
+
+// Header flags.
+const (
+	flagResponse uint32 = 0x1
+	flagRelated  uint32 = 0x4
+	flagSigned   uint32 = 0x8
+)
+
+// NTSTATUS codes sent to clients.
+const (
+	statusSuccess                uint32 = 0x00000000
+	statusBufferOverflow         uint32 = 0x80000005
+	statusNoMoreFiles            uint32 = 0x80000006
+	statusInvalidInfoClass       uint32 = 0xc0000003
+	statusInfoLengthMismatch     uint32 = 0xc0000004
+	statusInvalidParameter       uint32 = 0xc000000d
+	statusNoSuchFile             uint32 = 0xc000000f
+	statusEndOfFile              uint32 = 0xc0000011
+	statusMoreProcessingRequired uint32 = 0xc0000016
+	statusAccessDenied           uint32 = 0xc0000022
+	statusBufferTooSmall         uint32 = 0xc0000023
+	statusObjectNameInvalid      uint32 = 0xc0000033
+	statusObjectNameNotFound     uint32 = 0xc0000034
+	statusObjectNameCollision    uint32 = 0xc0000035
+	statusObjectPathNotFound     uint32 = 0xc000003a
+	statusQuotaExceeded          uint32 = 0xc0000044
+	statusLogonFailure           uint32 = 0xc000006d
+	statusDiskFull               uint32 = 0xc000007f
+	statusMediaWriteProtected    uint32 = 0xc00000a2
+	statusFileIsADirectory       uint32 = 0xc00000ba
+	statusNotSupported           uint32 = 0xc00000bb
+	statusNetworkNameDeleted     uint32 = 0xc00000c9
+	statusBadNetworkName         uint32 = 0xc00000cc
+	statusRequestNotAccepted     uint32 = 0xc00000d0
+	statusNotSameDevice          uint32 = 0xc00000d4
+	statusUnexpectedIOError      uint32 = 0xc00000e9
+	statusDirectoryNotEmpty      uint32 = 0xc0000101
+	statusNotADirectory          uint32 = 0xc0000103
+	statusFileClosed             uint32 = 0xc0000128
+	statusUserSessionDeleted     uint32 = 0xc0000203
+)
+
+// header is the header of an SMB2 message.
+type header struct {
+	creditCharge uint16
+	status       uint32
+	command      uint16
+	credits      uint16
+	flags        uint32
+	next         uint32
+	messageID    uint64
+	treeID       uint32
+	sessionID    uint64
+	signature    [16]byte
+}
+
+var protocolID = []byte{0xfe, 'S', 'M', 'B'}
+
+func parseHeader(b []byte) (*header, error) {
+	if len(b) < headerSize || string(b[:4]) != string(protocolID) || le.Uint16(b[4:]) != headerSize {
+		return nil, errors.New("smbfs: malformed header")
+	}
+
+	h := &header{
+		creditCharge: le.Uint16(b[6:]),
+		status:       le.Uint32(b[8:]),
+		command:      le.Uint16(b[12:]),
+		credits:      le.Uint16(b[14:]),
+		flags:        le.Uint32(b[16:]),
+		next:         le.Uint32(b[20:]),
+		messageID:    le.Uint64(b[24:]),
+		treeID:       le.Uint32(b[36:]),
+		sessionID:    le.Uint64(b[40:]),
+	}
+	copy(h.signature[:], b[48:64])
+
+	return h, nil
+}
+
+func (h *header) encode(b []byte) {
+	copy(b, protocolID)
+	le.PutUint16(b[4:], headerSize)
+	le.PutUint16(b[6:], h.creditCharge)
+	le.PutUint32(b[8:], h.status)
+	le.PutUint16(b[12:], h.command)
+	le.PutUint16(b[14:], h.credits)
+	le.PutUint32(b[16:], h.flags)
+	le.PutUint32(b[20:], h.next)
+	le.PutUint64(b[24:], h.messageID)
+	le.PutUint32(b[36:], h.treeID)
+	le.PutUint64(b[40:], h.sessionID)
+	copy(b[48:64], h.signature[:])
+}
+
+// sign returns the signature of msg, a whole message from its header to
+// the end of its padding, under key.
+func sign(key, msg []byte) [16]byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(msg[:48])
+	mac.Write(make([]byte, 16))
+	mac.Write(msg[64:])
+
+	var sig [16]byte
+	copy(sig[:], mac.Sum(nil))
+
+	return sig
+}
+
+// readMessage reads a message framed with the 4 byte Direct TCP transport
+// header.
+func readMessage(r io.Reader) ([]byte, error) {
+	var frame [4]byte
+	if _, err := io.ReadFull(r, frame[:]); err != nil {
+		return nil, err
+	}
+	size := int(frame[1])<<16 | int(frame[2])<<8 | int(frame[3])
+	if frame[0] != 0 || size > maxMessageSize {
+		return nil, errors.New("smbfs: malformed message")
+	}
+
+	msg := make([]byte, size)
+	_, err := io.ReadFull(r, msg)
+
+	return msg, err
+}
+
+func writeMessage(w io.Writer, msg []byte) error {
+	frame := []byte{0, byte(len(msg) >> 16), byte(len(msg) >> 8), byte(len(msg))}
+	_, err := w.Write(append(frame, msg...))
+
+	return err
+}
+
+// errorBody is the body of an error response.
+var errorBody = []byte{9, 0, 0, 0, 0, 0, 0, 0, 0}
+
+// statusOf returns the NTSTATUS code an error from a FileSystem is reported
+// to clients as.
+func statusOf(err error) uint32 {
+	switch {
+	case err == nil:
+		return statusSuccess
+	case errors.Is(err, absfs.ErrNotImplemented):
+		return statusNotSupported
+	case errors.Is(err, syscall.ENOTDIR):
+		return statusObjectPathNotFound
+	case errors.Is(err, syscall.EISDIR):
+		return statusFileIsADirectory
+	case errors.Is(err, syscall.ENOTEMPTY):
+		return statusDirectoryNotEmpty
+	case errors.Is(err, syscall.EDQUOT):
+		return statusQuotaExceeded
+	case errors.Is(err, syscall.ENOSPC):
+		return statusDiskFull
+	case errors.Is(err, syscall.EROFS):
+		return statusMediaWriteProtected
+	case errors.Is(err, syscall.EXDEV):
+		return statusNotSameDevice
+	case os.IsNotExist(err):
+		return statusObjectNameNotFound
+	case os.IsExist(err):
+		return statusObjectNameCollision
+	case os.IsPermission(err):
+		return statusAccessDenied
+	}
+
+	return statusUnexpectedIOError
+}
+
+// encodeString encodes s as UTF-16LE, as strings are on the wire.
+func encodeString(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	b := make([]byte, 2*len(u))
+	for i, c := range u {
+		le.PutUint16(b[2*i:], c)
+	}
+
+	return b
+}
+
+func decodeString(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	for i := range u {
+		u[i] = le.Uint16(b[2*i:])
+	}
+
+	return string(utf16.Decode(u))
+}
+
+// filetimeEpoch is the start of Windows FILETIMEs, 1601-01-01, relative to
+// the Unix epoch in 100ns intervals.
+const filetimeEpoch = 116444736000000000
+
+func filetime(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+
+	return uint64(t.UnixNano()/100 + filetimeEpoch)
+}
+
+func fromFiletime(ft uint64) time.Time {
+	return time.Unix(0, (int64(ft)-filetimeEpoch)*100)
+}
+
+// field returns the bytes at off and of length size of a message, where off
+// is relative to the start of the header, or false if they're out of its
+// bounds.
+func field(msg []byte, off, size int) ([]byte, bool) {
+	if off < 0 || size < 0 || off > len(msg) || size > len(msg)-off {
+		return nil, false
+	}
+
+	return msg[off : off+size], true
+}
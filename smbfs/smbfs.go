@@ -0,0 +1,729 @@
+// Package smbfs serves absfs.FileSystems as SMB shares, so a box can be
+// mounted as a network drive by the SMB clients built into Windows and
+// macOS.
+//
+// The server speaks the SMB 2.0.2 and 2.1 dialects over Direct TCP.
+// Authentication is share-level: each share has a password, any user name
+// is accepted, and a client may connect to the shares whose password it
+// proved it knows with NTLMv2. Anonymous, guest and NTLMv1 logons are
+// refused. Every message after a logon is signed with HMAC-SHA256, but
+// these dialects don't encrypt messages, so ListenAndServe only listens on
+// loopback addresses, and listeners passed to Serve should not be
+// reachable from other hosts either.
+//
+// Files are served as they are on the FileSystem: oplocks, leases, byte
+// range locks, named streams, change notifications and setting security
+// descriptors aren't supported, and clients are told not to cache files
+// for offline use.
+package smbfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awnumar/memguard"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// ErrServerClosed is returned by Serve and ListenAndServe after Close.
+var ErrServerClosed = errors.New("smbfs: server closed")
+
+// Server serves FileSystems as SMB shares.
+type Server struct {
+	// Name is the NetBIOS name the server gives clients. It's
+	// PANDORASBOX if empty.
+	Name string
+
+	guid  [16]byte
+	start time.Time
+
+	mtx       sync.Mutex
+	shares    map[string]*share
+	listeners map[net.Listener]struct{}
+	conns     map[*conn]struct{}
+	closed    bool
+}
+
+// share is a FileSystem shared under a name.
+type share struct {
+	name string
+	fs   absfs.FileSystem
+	// hash is the NT hash of the share's password
+	hash *memguard.Enclave
+}
+
+// NewServer returns a Server without shares.
+func NewServer() *Server {
+	s := &Server{
+		start:     time.Now(),
+		shares:    make(map[string]*share),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*conn]struct{}),
+	}
+	rand.Read(s.guid[:])
+
+	return s
+}
+
+// Share shares fs under name to clients that know password. Share names
+// are case insensitive, and can't be shared twice.
+func (s *Server) Share(name string, fs absfs.FileSystem, password string) error {
+	if name == "" || strings.ContainsAny(name, `\/:*?"<>|`) || strings.HasSuffix(name, "$") {
+		return fmt.Errorf("smbfs: invalid share name %q", name)
+	}
+	if password == "" {
+		return fmt.Errorf("smbfs: share %s needs a password", name)
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	key := strings.ToLower(name)
+	if _, ok := s.shares[key]; ok {
+		return fmt.Errorf("smbfs: share %s already exists", name)
+	}
+	s.shares[key] = &share{name: name, fs: fs, hash: memguard.NewEnclave(ntHash(password))}
+
+	return nil
+}
+
+func (s *Server) share(name string) *share {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.shares[strings.ToLower(name)]
+}
+
+// allowed returns the shares whose password a user proved they know to
+// auth.
+func (s *Server) allowed(auth func(hashes [][]byte) (*authenticated, error)) (map[*share]bool, *authenticated, error) {
+	s.mtx.Lock()
+	shares := make([]*share, 0, len(s.shares))
+	for _, sh := range s.shares {
+		shares = append(shares, sh)
+	}
+	s.mtx.Unlock()
+
+	hashes := make([][]byte, len(shares))
+	for i, sh := range shares {
+		b, err := sh.hash.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		defer b.Destroy()
+		hashes[i] = b.Bytes()
+	}
+
+	a, err := auth(hashes)
+	if err != nil {
+		return nil, nil, err
+	}
+	allowed := make(map[*share]bool)
+	for _, i := range a.matched {
+		allowed[shares[i]] = true
+	}
+
+	return allowed, a, nil
+}
+
+func (s *Server) name() string {
+	if s.Name == "" {
+		return "PANDORASBOX"
+	}
+
+	return s.Name
+}
+
+// ListenAndServe listens on the TCP address addr and serves connections
+// from it. The host of addr must be a loopback address or localhost, as
+// messages aren't encrypted.
+func (s *Server) ListenAndServe(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("smbfs: %s is not a loopback address", host)
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	return s.Serve(l)
+}
+
+// Serve serves connections accepted from l until Close is called or
+// accepting fails. l is closed when Serve returns.
+func (s *Server) Serve(l net.Listener) error {
+	s.mtx.Lock()
+	if s.closed {
+		s.mtx.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mtx.Unlock()
+
+	defer func() {
+		s.mtx.Lock()
+		delete(s.listeners, l)
+		s.mtx.Unlock()
+		l.Close()
+	}()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mtx.Lock()
+			closed := s.closed
+			s.mtx.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		c := newConn(s, nc)
+		s.mtx.Lock()
+		if s.closed {
+			s.mtx.Unlock()
+			nc.Close()
+			return ErrServerClosed
+		}
+		s.conns[c] = struct{}{}
+		s.mtx.Unlock()
+
+		go c.serve()
+	}
+}
+
+// Close stops all listeners and closes all connections, and with them the
+// files clients have open.
+func (s *Server) Close() error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if err1 := l.Close(); err == nil {
+			err = err1
+		}
+	}
+	for c := range s.conns {
+		c.nc.Close()
+	}
+
+	return err
+}
+
+// conn is a connection from a client.
+type conn struct {
+	srv *Server
+	nc  net.Conn
+
+	dialect  uint16
+	sessions map[uint64]*session
+	files    map[uint64]*openFile
+	lastID   uint64
+}
+
+// session is an authenticated user, or one that's authenticating.
+type session struct {
+	id     uint64
+	ntlm   *ntlmServer
+	spnego bool
+
+	valid  bool
+	user   string
+	key    []byte
+	shares map[*share]bool
+	trees  map[uint32]*tree
+}
+
+// tree is a connection to a share.
+type tree struct {
+	id    uint32
+	share *share
+}
+
+// request is a request in a message.
+type request struct {
+	h *header
+	// raw is the whole request from its header to the end of its
+	// padding, which offsets in the request are relative to
+	raw  []byte
+	body []byte
+
+	// related is the state shared by the operations of a compound
+	// request
+	related *related
+}
+
+// related is the state the related operations of a compound request
+// inherit from the ones before them.
+type related struct {
+	sessionID uint64
+	treeID    uint32
+	fileID    uint64
+	status    uint32
+	key       []byte
+}
+
+// response is a response to a request. It's signed with key if key isn't
+// nil.
+type response struct {
+	h    header
+	body []byte
+	key  []byte
+}
+
+func newConn(s *Server, nc net.Conn) *conn {
+	return &conn{
+		srv:      s,
+		nc:       nc,
+		sessions: make(map[uint64]*session),
+		files:    make(map[uint64]*openFile),
+	}
+}
+
+func (c *conn) nextID() uint64 {
+	c.lastID++
+	return c.lastID
+}
+
+func (c *conn) serve() {
+	defer func() {
+		for _, f := range c.files {
+			c.closeFile(f)
+		}
+		c.nc.Close()
+
+		c.srv.mtx.Lock()
+		delete(c.srv.conns, c)
+		c.srv.mtx.Unlock()
+	}()
+
+	for {
+		msg, err := readMessage(c.nc)
+		if err != nil {
+			return
+		}
+
+		var resp []byte
+		if bytes.HasPrefix(msg, smb1ProtocolID) {
+			resp, err = c.negotiateSMB1(msg)
+		} else {
+			resp, err = c.handle(msg)
+		}
+		if err != nil {
+			return
+		}
+		if resp != nil {
+			if err := writeMessage(c.nc, resp); err != nil {
+				return
+			}
+		}
+	}
+}
+
+var errMalformed = errors.New("smbfs: malformed request")
+
+// handle handles the requests in msg, and returns the message holding their
+// responses. Connections are dropped after errors.
+func (c *conn) handle(msg []byte) ([]byte, error) {
+	var (
+		resps []*response
+		rel   = new(related)
+	)
+	for first := true; len(msg) > 0; first = false {
+		h, err := parseHeader(msg)
+		if err != nil {
+			return nil, err
+		}
+		raw := msg
+		if h.next != 0 {
+			if h.next%8 != 0 || h.next < headerSize || int(h.next) > len(msg) {
+				return nil, errMalformed
+			}
+			raw = msg[:h.next]
+		}
+		msg = msg[len(raw):]
+		if h.flags&flagResponse != 0 {
+			return nil, errMalformed
+		}
+
+		r := &request{h: h, raw: raw, body: raw[headerSize:], related: rel}
+		if h.flags&flagRelated == 0 {
+			*rel = related{sessionID: h.sessionID, treeID: h.treeID, fileID: ^uint64(0)}
+		} else if first {
+			return nil, errMalformed
+		} else {
+			h.sessionID, h.treeID = rel.sessionID, rel.treeID
+		}
+
+		resp, err := c.process(r)
+		if err != nil {
+			return nil, err
+		}
+		if resp == nil {
+			continue
+		}
+		rel.sessionID, rel.treeID, rel.key = resp.h.sessionID, resp.h.treeID, resp.key
+		if resp.h.status != statusSuccess && rel.status == statusSuccess {
+			rel.status = resp.h.status
+		}
+		resps = append(resps, resp)
+	}
+
+	var out []byte
+	for i, resp := range resps {
+		b := make([]byte, headerSize+len(resp.body))
+		copy(b[headerSize:], resp.body)
+		if i < len(resps)-1 {
+			b = append(b, make([]byte, (8-len(b)%8)%8)...)
+			resp.h.next = uint32(len(b))
+		}
+		if resp.key != nil {
+			resp.h.flags |= flagSigned
+		}
+		resp.h.encode(b)
+		if resp.key != nil {
+			sig := sign(resp.key, b)
+			copy(b[48:], sig[:])
+		}
+		out = append(out, b...)
+	}
+
+	return out, nil
+}
+
+// process handles a request. It returns a nil response for requests that
+// aren't answered.
+func (c *conn) process(r *request) (*response, error) {
+	resp := &response{h: header{
+		command:   r.h.command,
+		credits:   max(1, min(r.h.credits, 512)),
+		flags:     flagResponse | r.h.flags&flagRelated,
+		messageID: r.h.messageID,
+		treeID:    r.h.treeID,
+		sessionID: r.h.sessionID,
+	}}
+
+	var (
+		status uint32
+		err    error
+	)
+	if r.h.flags&flagRelated != 0 && r.related.status != statusSuccess {
+		status, resp.key = r.related.status, r.related.key
+	} else {
+		status, resp.body, err = c.dispatch(r, resp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.h.command == cmdCancel {
+		return nil, nil
+	}
+
+	resp.h.status = status
+	if resp.body == nil {
+		resp.body = errorBody
+	}
+
+	return resp, nil
+}
+
+// dispatch handles a request by its command, and returns its status and
+// the body of its response.
+func (c *conn) dispatch(r *request, resp *response) (uint32, []byte, error) {
+	switch r.h.command {
+	case cmdNegotiate:
+		return c.negotiate(r)
+	case cmdEcho:
+		return statusSuccess, []byte{4, 0, 0, 0}, nil
+	case cmdCancel:
+		// requests are handled synchronously, so there's never anything to
+		// cancel
+		return statusSuccess, nil, nil
+	}
+	if c.dialect == 0 || c.dialect == dialectWildcard {
+		return 0, nil, errMalformed
+	}
+	if r.h.command == cmdSessionSetup {
+		status, body := c.sessionSetup(r, resp)
+		return status, body, nil
+	}
+
+	sess := c.sessions[r.h.sessionID]
+	if sess == nil || !sess.valid {
+		return statusUserSessionDeleted, nil, nil
+	}
+	if r.h.flags&flagSigned == 0 || sign(sess.key, r.raw) != r.h.signature {
+		return statusAccessDenied, nil, nil
+	}
+	resp.key = sess.key
+
+	switch r.h.command {
+	case cmdLogoff:
+		c.logoff(sess)
+		return statusSuccess, []byte{4, 0, 0, 0}, nil
+	case cmdTreeConnect:
+		status, body := c.treeConnect(r, sess, resp)
+		return status, body, nil
+	}
+
+	t := sess.trees[r.h.treeID]
+	if t == nil {
+		return statusNetworkNameDeleted, nil, nil
+	}
+
+	var (
+		status uint32
+		body   []byte
+	)
+	switch r.h.command {
+	case cmdTreeDisconnect:
+		c.treeDisconnect(sess, t)
+		status, body = statusSuccess, []byte{4, 0, 0, 0}
+	case cmdCreate:
+		status, body = c.create(r, sess, t)
+	case cmdClose:
+		status, body = c.close(r, sess, t)
+	case cmdFlush:
+		status, body = c.flush(r, sess, t)
+	case cmdRead:
+		status, body = c.read(r, sess, t)
+	case cmdWrite:
+		status, body = c.write(r, sess, t)
+	case cmdQueryDirectory:
+		status, body = c.queryDirectory(r, sess, t)
+	case cmdQueryInfo:
+		status, body = c.queryInfo(r, sess, t)
+	case cmdSetInfo:
+		status, body = c.setInfo(r, sess, t)
+	default:
+		status = statusNotSupported
+	}
+
+	return status, body, nil
+}
+
+// negotiate picks the newest dialect both the client and the server speak.
+func (c *conn) negotiate(r *request) (uint32, []byte, error) {
+	if c.dialect != 0 && c.dialect != dialectWildcard || len(r.body) < 36 {
+		return 0, nil, errMalformed
+	}
+	dialects, ok := field(r.body, 36, 2*int(le.Uint16(r.body[2:])))
+	if !ok {
+		return 0, nil, errMalformed
+	}
+
+	var dialect uint16
+	for i := 0; i < len(dialects); i += 2 {
+		if d := le.Uint16(dialects[i:]); (d == dialect202 || d == dialect210) && d > dialect {
+			dialect = d
+		}
+	}
+	if dialect == 0 {
+		return statusNotSupported, nil, nil
+	}
+	c.dialect = dialect
+
+	return statusSuccess, c.negotiateResponse(c.dialect), nil
+}
+
+func (c *conn) negotiateResponse(dialect uint16) []byte {
+	token := negTokenInit()
+	b := make([]byte, 64, 64+len(token))
+	le.PutUint16(b, 65)
+	// signing is enabled and required
+	le.PutUint16(b[2:], 0x3)
+	le.PutUint16(b[4:], dialect)
+	copy(b[8:], c.srv.guid[:])
+	le.PutUint32(b[28:], maxIOSize)
+	le.PutUint32(b[32:], maxIOSize)
+	le.PutUint32(b[36:], maxIOSize)
+	le.PutUint64(b[40:], filetime(time.Now()))
+	le.PutUint64(b[48:], filetime(c.srv.start))
+	le.PutUint16(b[56:], headerSize+64)
+	le.PutUint16(b[58:], uint16(len(token)))
+
+	return append(b, token...)
+}
+
+var smb1ProtocolID = []byte{0xff, 'S', 'M', 'B'}
+
+// negotiateSMB1 answers the SMB1 negotiate older clients start with, which
+// upgrades to SMB2 if the client offers it.
+func (c *conn) negotiateSMB1(msg []byte) ([]byte, error) {
+	if c.dialect != 0 || len(msg) < 35 || msg[4] != 0x72 {
+		return nil, errMalformed
+	}
+	var dialect uint16
+	for _, name := range bytes.Split(msg[35:], []byte{0}) {
+		switch string(bytes.TrimPrefix(name, []byte{2})) {
+		case "SMB 2.???":
+			dialect = dialectWildcard
+		case "SMB 2.002":
+			dialect = max(dialect, dialect202)
+		}
+	}
+	if dialect == 0 {
+		return nil, errMalformed
+	}
+	c.dialect = dialect
+
+	body := c.negotiateResponse(dialect)
+	b := make([]byte, headerSize+len(body))
+	h := header{command: cmdNegotiate, credits: 1, flags: flagResponse}
+	h.encode(b)
+	copy(b[headerSize:], body)
+
+	return b, nil
+}
+
+// sessionSetup takes a client through an NTLM authentication.
+func (c *conn) sessionSetup(r *request, resp *response) (uint32, []byte) {
+	if len(r.body) < 24 {
+		return statusInvalidParameter, nil
+	}
+	token, ok := field(r.raw, int(le.Uint16(r.body[12:])), int(le.Uint16(r.body[14:])))
+	if !ok {
+		return statusInvalidParameter, nil
+	}
+
+	sess := c.sessions[r.h.sessionID]
+	switch {
+	case r.h.sessionID == 0:
+		sess = &session{
+			id:    c.nextID(),
+			ntlm:  &ntlmServer{name: c.srv.name()},
+			trees: make(map[uint32]*tree),
+		}
+		c.sessions[sess.id] = sess
+		resp.h.sessionID = sess.id
+	case sess == nil:
+		return statusUserSessionDeleted, nil
+	case sess.valid:
+		// sessions can't be reauthenticated
+		return statusRequestNotAccepted, nil
+	}
+
+	msg, spnego, ok, err := unwrapToken(token)
+	if err == nil && ok && len(msg) < 12 {
+		err = errBadToken
+	}
+	if err != nil {
+		delete(c.sessions, sess.id)
+		return statusLogonFailure, nil
+	}
+	if !ok {
+		return statusMoreProcessingRequired, sessionSetupBody(negTokenResp(negAcceptIncomplete, true, nil))
+	}
+	first := sess.ntlm.challenge == nil
+	sess.spnego = sess.spnego || spnego
+
+	switch le.Uint32(msg[8:]) {
+	case 1:
+		challenge, err := sess.ntlm.challengeMessage(msg)
+		if err != nil {
+			delete(c.sessions, sess.id)
+			return statusLogonFailure, nil
+		}
+		if spnego {
+			challenge = negTokenResp(negAcceptIncomplete, first, challenge)
+		}
+		return statusMoreProcessingRequired, sessionSetupBody(challenge)
+	case 3:
+		allowed, auth, err := c.srv.allowed(func(hashes [][]byte) (*authenticated, error) {
+			return sess.ntlm.authenticate(msg, hashes)
+		})
+		if err != nil {
+			delete(c.sessions, sess.id)
+			return statusLogonFailure, nil
+		}
+		sess.ntlm = nil
+		sess.valid, sess.user, sess.key, sess.shares = true, auth.user, auth.sessionKey, allowed
+		resp.key = sess.key
+
+		var token []byte
+		if spnego {
+			token = negTokenResp(negAcceptCompleted, false, nil)
+		}
+		return statusSuccess, sessionSetupBody(token)
+	}
+
+	delete(c.sessions, sess.id)
+	return statusLogonFailure, nil
+}
+
+func sessionSetupBody(token []byte) []byte {
+	b := make([]byte, 8, 8+len(token))
+	le.PutUint16(b, 9)
+	if len(token) > 0 {
+		le.PutUint16(b[4:], headerSize+8)
+		le.PutUint16(b[6:], uint16(len(token)))
+	}
+
+	return append(b, token...)
+}
+
+func (c *conn) logoff(sess *session) {
+	for _, t := range sess.trees {
+		c.treeDisconnect(sess, t)
+	}
+	delete(c.sessions, sess.id)
+}
+
+// treeConnect connects to a share whose password the user knows.
+func (c *conn) treeConnect(r *request, sess *session, resp *response) (uint32, []byte) {
+	if len(r.body) < 8 {
+		return statusInvalidParameter, nil
+	}
+	p, ok := field(r.raw, int(le.Uint16(r.body[4:])), int(le.Uint16(r.body[6:])))
+	if !ok {
+		return statusInvalidParameter, nil
+	}
+	name := decodeString(p)
+	name = name[strings.LastIndexByte(name, '\\')+1:]
+
+	sh := c.srv.share(name)
+	if sh == nil {
+		return statusBadNetworkName, nil
+	}
+	if !sess.shares[sh] {
+		return statusAccessDenied, nil
+	}
+
+	t := &tree{id: uint32(c.nextID()), share: sh}
+	sess.trees[t.id] = t
+	resp.h.treeID = t.id
+
+	b := make([]byte, 16)
+	le.PutUint16(b, 16)
+	// a disk share whose files mustn't be cached offline
+	b[2] = 1
+	le.PutUint32(b[4:], 0x30)
+	le.PutUint32(b[12:], fileAllAccess)
+
+	return statusSuccess, b
+}
+
+func (c *conn) treeDisconnect(sess *session, t *tree) {
+	for _, f := range c.files {
+		if f.tree == t {
+			c.closeFile(f)
+		}
+	}
+	delete(sess.trees, t.id)
+}
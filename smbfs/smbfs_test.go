@@ -0,0 +1,755 @@
+package smbfs
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rc4"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+// client is a minimal SMB2 client, speaking just enough of the protocol to
+// test the server.
+type client struct {
+	t         *testing.T
+	nc        net.Conn
+	messageID uint64
+	sessionID uint64
+	treeID    uint32
+	key       []byte
+}
+
+func serve(t *testing.T, s *Server) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+
+	return l.Addr().String()
+}
+
+func dial(t *testing.T, addr string) *client {
+	t.Helper()
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nc.SetDeadline(time.Now().Add(10 * time.Second))
+	t.Cleanup(func() { nc.Close() })
+
+	return &client{t: t, nc: nc}
+}
+
+// request returns a request with body, signed if the client has a key.
+func (c *client) request(cmd uint16, body []byte, flags uint32) []byte {
+	msg := make([]byte, headerSize+len(body))
+	h := header{
+		command:   cmd,
+		credits:   1,
+		flags:     flags,
+		messageID: c.messageID,
+		treeID:    c.treeID,
+		sessionID: c.sessionID,
+	}
+	c.messageID++
+	if c.key != nil {
+		h.flags |= flagSigned
+	}
+	h.encode(msg)
+	copy(msg[headerSize:], body)
+	if c.key != nil {
+		sig := sign(c.key, msg)
+		copy(msg[48:], sig[:])
+	}
+
+	return msg
+}
+
+// roundTrip sends msg and returns the responses in the reply, each from its
+// header on.
+func (c *client) roundTrip(msg []byte) [][]byte {
+	c.t.Helper()
+	if err := writeMessage(c.nc, msg); err != nil {
+		c.t.Fatal(err)
+	}
+	reply, err := readMessage(c.nc)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	var resps [][]byte
+	for len(reply) > 0 {
+		h, err := parseHeader(reply)
+		if err != nil {
+			c.t.Fatal(err)
+		}
+		resp := reply
+		if h.next != 0 {
+			resp = reply[:h.next]
+		}
+		reply = reply[len(resp):]
+		if c.key != nil && h.status != statusUserSessionDeleted {
+			if h.flags&flagSigned == 0 || sign(c.key, resp) != h.signature {
+				c.t.Fatalf("response to command %d isn't signed", h.command)
+			}
+		}
+		resps = append(resps, resp)
+	}
+
+	return resps
+}
+
+// call sends a request, and returns the status of its response and the
+// response from its header on.
+func (c *client) call(cmd uint16, body []byte) (uint32, []byte) {
+	c.t.Helper()
+	resp := c.roundTrip(c.request(cmd, body, 0))[0]
+	h, _ := parseHeader(resp)
+
+	return h.status, resp
+}
+
+func (c *client) negotiate() []byte {
+	c.t.Helper()
+	body := make([]byte, 36, 42)
+	le.PutUint16(body, 36)
+	le.PutUint16(body[2:], 3)
+	le.PutUint16(body[4:], 1)
+	body = le.AppendUint16(body, 0x0202)
+	body = le.AppendUint16(body, 0x0210)
+	body = le.AppendUint16(body, 0x0300)
+
+	status, resp := c.call(cmdNegotiate, body)
+	if status != statusSuccess {
+		c.t.Fatalf("negotiate: status %#x", status)
+	}
+
+	return resp
+}
+
+// login negotiates and authenticates as user with password, and returns the
+// status of the authentication.
+func (c *client) login(user, password string) uint32 {
+	c.t.Helper()
+	c.negotiate()
+
+	negotiate := make([]byte, 40)
+	copy(negotiate, ntlmSignature)
+	le.PutUint32(negotiate[8:], 1)
+	le.PutUint32(negotiate[12:], ntlmUnicode|ntlmRequestTarget|ntlmSign|ntlmNTLM|ntlmAlwaysSign|
+		ntlmExtendedSecurity|ntlmVersion|ntlm128|ntlmKeyExchange|ntlm56)
+	token := der(0x60, oidSPNEGO, der(0xa0, der(0x30,
+		der(0xa0, der(0x30, oidNTLM)),
+		der(0xa2, der(0x04, negotiate)),
+	)))
+	status, resp := c.call(cmdSessionSetup, sessionSetupRequest(token))
+	if status != statusMoreProcessingRequired {
+		c.t.Fatalf("session setup: status %#x", status)
+	}
+	h, _ := parseHeader(resp)
+	c.sessionID = h.sessionID
+
+	challenge := c.responseToken(resp)
+	flags, nonce := le.Uint32(challenge[20:]), challenge[24:32]
+	info, err := payload(challenge, 40)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	// the server's target info, with the MIC flag added
+	blob := []byte{1, 1, 0, 0, 0, 0, 0, 0}
+	blob = le.AppendUint64(blob, filetime(time.Now()))
+	clientNonce := make([]byte, 8)
+	rand.Read(clientNonce)
+	blob = append(append(blob, clientNonce...), 0, 0, 0, 0)
+	blob = append(blob, info[:len(info)-4]...)
+	blob = appendAV(blob, avFlags, le.AppendUint32(nil, avFlagMIC))
+	blob = append(appendAV(blob, avEOL, nil), 0, 0, 0, 0)
+
+	domain := "WORKGROUP"
+	key := hmacMD5(ntHash(password), encodeString(strings.ToUpper(user)+domain))
+	proof := hmacMD5(key, nonce, blob)
+	baseKey := hmacMD5(key, proof)
+	sessionKey := make([]byte, 16)
+	rand.Read(sessionKey)
+	encryptedKey := make([]byte, 16)
+	rc, _ := rc4.NewCipher(baseKey)
+	rc.XORKeyStream(encryptedKey, sessionKey)
+
+	auth := make([]byte, ntlmAuthenticateHeaderSize)
+	copy(auth, ntlmSignature)
+	le.PutUint32(auth[8:], 3)
+	for i, p := range [][]byte{make([]byte, 24), append(proof, blob...), encodeString(domain), encodeString(user), encodeString("CLIENT"), encryptedKey} {
+		putFields(auth[12+8*i:], len(p), len(auth))
+		auth = append(auth, p...)
+	}
+	le.PutUint32(auth[60:], flags)
+	copy(auth[ntlmMICOffset:], hmacMD5(sessionKey, negotiate, challenge, auth))
+
+	status, resp = c.call(cmdSessionSetup, sessionSetupRequest(der(0xa1, der(0x30, der(0xa2, der(0x04, auth))))))
+	if status == statusSuccess {
+		c.key = sessionKey
+		if h, _ := parseHeader(resp); h.flags&flagSigned == 0 || sign(c.key, resp) != h.signature {
+			c.t.Fatal("final session setup response isn't signed")
+		}
+	}
+
+	return status
+}
+
+func sessionSetupRequest(token []byte) []byte {
+	b := make([]byte, 24, 24+len(token))
+	le.PutUint16(b, 25)
+	b[3] = 1
+	le.PutUint16(b[12:], headerSize+24)
+	le.PutUint16(b[14:], uint16(len(token)))
+
+	return append(b, token...)
+}
+
+// responseToken returns the NTLM message in a session setup response.
+func (c *client) responseToken(resp []byte) []byte {
+	c.t.Helper()
+	body := resp[headerSize:]
+	token, ok := field(resp, int(le.Uint16(body[4:])), int(le.Uint16(body[6:])))
+	if !ok {
+		c.t.Fatal("session setup response token out of bounds")
+	}
+	_, content, _, err := parseDER(token)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	fields, err := innerFields(content)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	msg, err := octetString(fields[2])
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	return msg
+}
+
+func (c *client) treeConnect(share string) uint32 {
+	c.t.Helper()
+	p := encodeString(`\\127.0.0.1\` + share)
+	body := make([]byte, 8, 8+len(p))
+	le.PutUint16(body, 9)
+	le.PutUint16(body[4:], headerSize+8)
+	le.PutUint16(body[6:], uint16(len(p)))
+
+	status, resp := c.call(cmdTreeConnect, append(body, p...))
+	if status == statusSuccess {
+		h, _ := parseHeader(resp)
+		c.treeID = h.treeID
+	}
+
+	return status
+}
+
+func createRequest(name string, access, disposition, options uint32) []byte {
+	p := encodeString(name)
+	body := make([]byte, 56, 57+len(p))
+	le.PutUint16(body, 57)
+	le.PutUint32(body[4:], 2)
+	le.PutUint32(body[24:], access)
+	le.PutUint32(body[32:], 7)
+	le.PutUint32(body[36:], disposition)
+	le.PutUint32(body[40:], options)
+	le.PutUint16(body[44:], headerSize+56)
+	le.PutUint16(body[46:], uint16(len(p)))
+	body = append(body, p...)
+	if len(p) == 0 {
+		body = append(body, 0)
+	}
+
+	return body
+}
+
+func (c *client) create(name string, access, disposition, options uint32) (uint32, uint64) {
+	c.t.Helper()
+	status, resp := c.call(cmdCreate, createRequest(name, access, disposition, options))
+	if status != statusSuccess {
+		return status, 0
+	}
+
+	return status, le.Uint64(resp[headerSize+64:])
+}
+
+// withFileID returns body with id as the FileId at off.
+func withFileID(body []byte, off int, id uint64) []byte {
+	le.PutUint64(body[off:], id)
+	le.PutUint64(body[off+8:], id)
+
+	return body
+}
+
+func closeRequest(id uint64) []byte {
+	body := make([]byte, 24)
+	le.PutUint16(body, 24)
+
+	return withFileID(body, 8, id)
+}
+
+func (c *client) close(id uint64) uint32 {
+	c.t.Helper()
+	status, _ := c.call(cmdClose, closeRequest(id))
+
+	return status
+}
+
+func (c *client) write(id uint64, off uint64, data []byte) uint32 {
+	c.t.Helper()
+	body := make([]byte, 48, 48+len(data))
+	le.PutUint16(body, 49)
+	le.PutUint16(body[2:], headerSize+48)
+	le.PutUint32(body[4:], uint32(len(data)))
+	le.PutUint64(body[8:], off)
+
+	status, resp := c.call(cmdWrite, append(withFileID(body, 16, id), data...))
+	if status == statusSuccess && le.Uint32(resp[headerSize+4:]) != uint32(len(data)) {
+		c.t.Errorf("short write: %d bytes", le.Uint32(resp[headerSize+4:]))
+	}
+
+	return status
+}
+
+func (c *client) read(id uint64, off uint64, size uint32) (uint32, []byte) {
+	c.t.Helper()
+	body := make([]byte, 49)
+	le.PutUint16(body, 49)
+	le.PutUint32(body[4:], size)
+	le.PutUint64(body[8:], off)
+
+	status, resp := c.call(cmdRead, withFileID(body, 16, id))
+	if status != statusSuccess {
+		return status, nil
+	}
+	data, _ := field(resp, int(resp[headerSize+2]), int(le.Uint32(resp[headerSize+4:])))
+
+	return status, data
+}
+
+// list returns the names listed by a QUERY_DIRECTORY with
+// FileIdBothDirectoryInformation.
+func (c *client) list(id uint64, pattern string, flags byte) (uint32, []string) {
+	c.t.Helper()
+	p := encodeString(pattern)
+	body := make([]byte, 32, 32+len(p))
+	le.PutUint16(body, 33)
+	body[2] = fileIDBothDirectoryInformation
+	body[3] = flags
+	le.PutUint16(body[24:], headerSize+32)
+	le.PutUint16(body[26:], uint16(len(p)))
+	le.PutUint32(body[28:], 64<<10)
+
+	status, resp := c.call(cmdQueryDirectory, append(withFileID(body, 8, id), p...))
+	if status != statusSuccess {
+		return status, nil
+	}
+	out, _ := field(resp, int(le.Uint16(resp[headerSize+2:])), int(le.Uint32(resp[headerSize+4:])))
+	var names []string
+	for {
+		names = append(names, decodeString(out[104:104+le.Uint32(out[60:])]))
+		next := le.Uint32(out)
+		if next == 0 {
+			return status, names
+		}
+		out = out[next:]
+	}
+}
+
+func setInfoRequest(class byte, buf []byte) []byte {
+	body := make([]byte, 32, 32+len(buf))
+	le.PutUint16(body, 33)
+	body[2] = infoFile
+	body[3] = class
+	le.PutUint32(body[4:], uint32(len(buf)))
+	le.PutUint16(body[8:], headerSize+32)
+
+	return append(body, buf...)
+}
+
+func (c *client) setInfo(id uint64, class byte, buf []byte) uint32 {
+	c.t.Helper()
+	status, _ := c.call(cmdSetInfo, withFileID(setInfoRequest(class, buf), 16, id))
+
+	return status
+}
+
+func queryInfoRequest(infoType, class byte, outLen uint32) []byte {
+	body := make([]byte, 41)
+	le.PutUint16(body, 41)
+	body[2] = infoType
+	body[3] = class
+	le.PutUint32(body[4:], outLen)
+
+	return body
+}
+
+func newServer(t *testing.T) (*Server, *vfs.FileSystem, string) {
+	t.Helper()
+	fs := vfs.NewFS()
+	s := NewServer()
+	if err := s.Share("box", fs, "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	return s, fs, serve(t, s)
+}
+
+func TestNegotiate(t *testing.T) {
+	_, _, addr := newServer(t)
+
+	c := dial(t, addr)
+	resp := c.negotiate()[headerSize:]
+	if d := le.Uint16(resp[4:]); d != dialect210 {
+		t.Errorf("wrong dialect: %#x", d)
+	}
+	if mode := le.Uint16(resp[2:]); mode != 0x3 {
+		t.Errorf("signing isn't required: security mode %#x", mode)
+	}
+
+	// clients starting with an SMB1 negotiate are upgraded to SMB2
+	c = dial(t, addr)
+	smb1 := make([]byte, 35)
+	copy(smb1, smb1ProtocolID)
+	smb1[4] = 0x72
+	smb1 = append(smb1, "\x02NT LM 0.12\x00\x02SMB 2.002\x00\x02SMB 2.???\x00"...)
+	le.PutUint16(smb1[33:], uint16(len(smb1)-35))
+	resps := c.roundTrip(smb1)
+	if d := le.Uint16(resps[0][headerSize+4:]); d != dialectWildcard {
+		t.Errorf("wrong dialect for SMB1 negotiate: %#x", d)
+	}
+	c.negotiate()
+
+	// requests before a negotiate drop the connection
+	c = dial(t, addr)
+	if err := writeMessage(c.nc, c.request(cmdSessionSetup, sessionSetupRequest(nil), 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readMessage(c.nc); err == nil {
+		t.Error("expected the connection to be dropped")
+	}
+}
+
+func TestServer(t *testing.T) {
+	_, fs, addr := newServer(t)
+
+	c := dial(t, addr)
+	if status := c.login("alice", "hunter2"); status != statusSuccess {
+		t.Fatalf("login: status %#x", status)
+	}
+	if status := c.treeConnect("BOX"); status != statusSuccess {
+		t.Fatalf("tree connect: status %#x", status)
+	}
+
+	status, dir := c.create(`secrets`, genericRead, fileCreate, optDirectory)
+	if status != statusSuccess {
+		t.Fatalf("mkdir: status %#x", status)
+	}
+	status, id := c.create(`secrets\db password.txt`, genericRead|genericWrite|accessDelete, fileCreate, optNonDirectory)
+	if status != statusSuccess {
+		t.Fatalf("create: status %#x", status)
+	}
+	if status := c.write(id, 0, []byte("correct horse")); status != statusSuccess {
+		t.Fatalf("write: status %#x", status)
+	}
+	if status := c.write(id, 8, []byte("battery staple")); status != statusSuccess {
+		t.Fatalf("write: status %#x", status)
+	}
+	status, data := c.read(id, 0, 1024)
+	if status != statusSuccess {
+		t.Fatalf("read: status %#x", status)
+	}
+	if string(data) != "correct battery staple" {
+		t.Errorf("wrong contents: %q", data)
+	}
+	if status, _ := c.read(id, 1024, 10); status != statusEndOfFile {
+		t.Errorf("read past the end: status %#x", status)
+	}
+	if status := c.close(id); status != statusSuccess {
+		t.Fatalf("close: status %#x", status)
+	}
+
+	got, err := ioutil.ReadFile(fs, "/secrets/db password.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "correct battery staple" {
+		t.Errorf("wrong contents on the FileSystem: %q", got)
+	}
+
+	status, names := c.list(dir, "*", 0)
+	if status != statusSuccess {
+		t.Fatalf("list: status %#x", status)
+	}
+	if want := []string{".", "..", "db password.txt"}; strings.Join(names, "|") != strings.Join(want, "|") {
+		t.Errorf("wrong listing: %q", names)
+	}
+	if status, _ := c.list(dir, "*", 0); status != statusNoMoreFiles {
+		t.Errorf("listing again: status %#x", status)
+	}
+	if status, names := c.list(dir, "*.txt", restartScans); status != statusSuccess || len(names) != 1 {
+		t.Errorf("listing *.txt: status %#x, names %q", status, names)
+	}
+	if status, _ := c.list(dir, "*.go", restartScans); status != statusNoSuchFile {
+		t.Errorf("listing *.go: status %#x", status)
+	}
+	c.close(dir)
+
+	// rename, then delete on close
+	status, id = c.create(`secrets\db password.txt`, accessDelete|fileReadAttributes, fileOpen, 0)
+	if status != statusSuccess {
+		t.Fatalf("open: status %#x", status)
+	}
+	target := encodeString(`\secrets\prod.txt`)
+	rename := make([]byte, 20, 20+len(target))
+	le.PutUint32(rename[16:], uint32(len(target)))
+	if status := c.setInfo(id, fileRenameInformation, append(rename, target...)); status != statusSuccess {
+		t.Fatalf("rename: status %#x", status)
+	}
+	if _, err := fs.Stat("/secrets/prod.txt"); err != nil {
+		t.Errorf("file wasn't renamed: %v", err)
+	}
+	if status := c.setInfo(id, fileDispositionInformation, []byte{1}); status != statusSuccess {
+		t.Fatalf("delete: status %#x", status)
+	}
+	if _, err := fs.Stat("/secrets/prod.txt"); err != nil {
+		t.Errorf("file was deleted before it was closed: %v", err)
+	}
+	c.close(id)
+	if _, err := fs.Stat("/secrets/prod.txt"); err == nil {
+		t.Error("file wasn't deleted on close")
+	}
+
+	if status, _ := c.create(`secrets\missing`, genericRead, fileOpen, 0); status != statusObjectNameNotFound {
+		t.Errorf("opening a missing file: status %#x", status)
+	}
+	if status, _ := c.create(`missing\file`, genericRead, fileOpen, 0); status != statusObjectPathNotFound {
+		t.Errorf("opening a file in a missing directory: status %#x", status)
+	}
+	if status, _ := c.create(`secrets`, genericRead, fileCreate, optDirectory); status != statusObjectNameCollision {
+		t.Errorf("creating an existing directory: status %#x", status)
+	}
+	if status, _ := c.create(`..\etc\passwd`, genericRead, fileOpen, 0); status != statusObjectNameInvalid {
+		t.Errorf("opening a path out of the share: status %#x", status)
+	}
+
+	// files opened without write access can't be written to
+	status, id = c.create(`readonly.txt`, genericWrite, fileCreate, 0)
+	if status != statusSuccess {
+		t.Fatalf("create: status %#x", status)
+	}
+	c.close(id)
+	_, id = c.create(`readonly.txt`, genericRead, fileOpen, 0)
+	if status := c.write(id, 0, []byte("x")); status != statusAccessDenied {
+		t.Errorf("writing to a file opened for reading: status %#x", status)
+	}
+}
+
+func TestCompound(t *testing.T) {
+	_, fs, addr := newServer(t)
+	if err := ioutil.WriteFile(fs, "/token", []byte("s3cr3t"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c := dial(t, addr)
+	if status := c.login("bob", "hunter2"); status != statusSuccess {
+		t.Fatalf("login: status %#x", status)
+	}
+	c.treeConnect("box")
+
+	// create, query and close in one message, the latter two applying to
+	// the file the first opens
+	var msg []byte
+	for i, req := range [][]byte{
+		c.request(cmdCreate, createRequest("token", genericRead, fileOpen, 0), 0),
+		c.request(cmdQueryInfo, withFileID(queryInfoRequest(infoFile, fileStandardInformation, 24), 24, ^uint64(0)), flagRelated),
+		c.request(cmdClose, closeRequest(^uint64(0)), flagRelated),
+	} {
+		if i < 2 {
+			req = append(req, make([]byte, (8-len(req)%8)%8)...)
+			le.PutUint32(req[20:], uint32(len(req)))
+		}
+		if c.key != nil {
+			sig := sign(c.key, req)
+			copy(req[48:], sig[:])
+		}
+		msg = append(msg, req...)
+	}
+
+	resps := c.roundTrip(msg)
+	if len(resps) != 3 {
+		t.Fatalf("got %d responses, want 3", len(resps))
+	}
+	for _, resp := range resps {
+		if h, _ := parseHeader(resp); h.status != statusSuccess {
+			t.Fatalf("command %d: status %#x", h.command, h.status)
+		}
+	}
+	info, _ := field(resps[1], int(le.Uint16(resps[1][headerSize+2:])), int(le.Uint32(resps[1][headerSize+4:])))
+	if size := le.Uint64(info[8:]); size != 6 {
+		t.Errorf("wrong size: %d", size)
+	}
+
+	// related requests after a failed one fail the same way
+	msg = nil
+	for i, req := range [][]byte{
+		c.request(cmdCreate, createRequest("missing", genericRead, fileOpen, 0), 0),
+		c.request(cmdClose, closeRequest(^uint64(0)), flagRelated),
+	} {
+		if i == 0 {
+			req = append(req, make([]byte, (8-len(req)%8)%8)...)
+			le.PutUint32(req[20:], uint32(len(req)))
+		}
+		sig := sign(c.key, req)
+		copy(req[48:], sig[:])
+		msg = append(msg, req...)
+	}
+	for _, resp := range c.roundTrip(msg) {
+		if h, _ := parseHeader(resp); h.status != statusObjectNameNotFound {
+			t.Errorf("command %d: status %#x", h.command, h.status)
+		}
+	}
+}
+
+func TestShareLevelAuth(t *testing.T) {
+	s := NewServer()
+	for _, name := range []string{"db", "tls", "backup"} {
+		pass := name + "-password"
+		if name == "backup" {
+			pass = "db-password"
+		}
+		if err := s.Share(name, vfs.NewFS(), pass); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addr := serve(t, s)
+
+	c := dial(t, addr)
+	if status := c.login("alice", "wrong"); status != statusLogonFailure {
+		t.Errorf("login with a wrong password: status %#x", status)
+	}
+	c = dial(t, addr)
+	if status := c.login("", "db-password"); status != statusLogonFailure {
+		t.Errorf("anonymous login: status %#x", status)
+	}
+
+	// the password of a share opens the shares that have it
+	c = dial(t, addr)
+	if status := c.login("alice", "db-password"); status != statusSuccess {
+		t.Fatalf("login: status %#x", status)
+	}
+	for share, want := range map[string]uint32{
+		"db":      statusSuccess,
+		"backup":  statusSuccess,
+		"tls":     statusAccessDenied,
+		"IPC$":    statusBadNetworkName,
+		"missing": statusBadNetworkName,
+	} {
+		if status := c.treeConnect(share); status != want {
+			t.Errorf("connecting to %s: status %#x, want %#x", share, status, want)
+		}
+	}
+}
+
+func TestSigning(t *testing.T) {
+	_, _, addr := newServer(t)
+
+	c := dial(t, addr)
+	if status := c.login("alice", "hunter2"); status != statusSuccess {
+		t.Fatalf("login: status %#x", status)
+	}
+
+	// responses to requests that fail verification aren't signed
+	unsigned := c.request(cmdTreeConnect, make([]byte, 9), 0)
+	tampered := c.request(cmdTreeConnect, make([]byte, 9), 0)
+	tampered[len(tampered)-1] ^= 1
+	key := c.key
+	c.key = nil
+	le.PutUint32(unsigned[16:], 0)
+	if h, _ := parseHeader(c.roundTrip(unsigned)[0]); h.status != statusAccessDenied {
+		t.Errorf("unsigned request: status %#x", h.status)
+	}
+	if h, _ := parseHeader(c.roundTrip(tampered)[0]); h.status != statusAccessDenied {
+		t.Errorf("tampered request: status %#x", h.status)
+	}
+	c.key = key
+
+	if status := c.treeConnect("box"); status != statusSuccess {
+		t.Errorf("signed request: status %#x", status)
+	}
+}
+
+func TestShare(t *testing.T) {
+	s := NewServer()
+	if err := s.Share("Box", vfs.NewFS(), "pass"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"box", "", "a/b", "IPC$"} {
+		if err := s.Share(name, vfs.NewFS(), "pass"); err == nil {
+			t.Errorf("sharing %q: expected error", name)
+		}
+	}
+	if err := s.Share("other", vfs.NewFS(), ""); err == nil {
+		t.Error("sharing without a password: expected error")
+	}
+
+	if err := s.ListenAndServe("0.0.0.0:0"); err == nil {
+		t.Error("listening on all addresses: expected error")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		match         bool
+	}{
+		{"*", "anything", true},
+		{"*.txt", "a.txt", true},
+		{"*.txt", "a.txt.go", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"*b*", "abc", true},
+		{"*b*b", "abcb", true},
+		{"*b*b", "abc", false},
+		{"exact", "exact", true},
+		{"exact", "Exact", false},
+		{`<.txt`, "a.txt", true},
+		{`a"txt`, "a.txt", true},
+		{`a>c`, "abc", true},
+		{"", "a", false},
+	}
+	for _, tt := range tests {
+		if got := match(tt.pattern, tt.name); got != tt.match {
+			t.Errorf("match(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.match)
+		}
+	}
+}
+
+func TestSecurityDescriptor(t *testing.T) {
+	sd := securityDescriptor(ownerSecurityInformation | daclSecurityInformation)
+	if owner := le.Uint32(sd[4:]); !bytes.Equal(sd[owner:owner+12], everyone) {
+		t.Error("owner isn't Everyone")
+	}
+	if group := le.Uint32(sd[8:]); group != 0 {
+		t.Error("group wasn't asked for")
+	}
+	dacl := le.Uint32(sd[16:])
+	if dacl == 0 || le.Uint16(sd[2:])&0x4 == 0 {
+		t.Fatal("no DACL")
+	}
+	if size := le.Uint16(sd[dacl+2:]); int(dacl)+int(size) != len(sd) {
+		t.Errorf("wrong DACL size %d", size)
+	}
+}
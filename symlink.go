@@ -0,0 +1,90 @@
+package pandorasbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+// hostLinkPrefix marks the targets of VFS symbolic links that point at the
+// host's filesystem, as they would otherwise look like VFS paths.
+const hostLinkPrefix = "file://"
+
+// maxLinkHops is the number of links followLinks follows before giving
+// up, as they must form a cycle.
+const maxLinkHops = 40
+
+// followLinks returns name with the symbolic links in it that point from a
+// VFS to another VFS or the host's filesystem replaced by their targets.
+// Links within a VFS are followed to find such links behind them, but are
+// otherwise left for the VFS to follow.
+func (b *Box) followLinks(name string) (string, error) {
+	for hops := 0; ; hops++ {
+		// paths with a scheme no VFS handles are rejected by the caller
+		fs, vfsName, ok, err := b.resolveVFS(name)
+		if err != nil || !ok {
+			return name, nil
+		}
+		target, rest, found, err := crossLink(fs, vfsName)
+		if err != nil {
+			return "", &os.PathError{Op: "stat", Path: name, Err: err}
+		}
+		if !found {
+			return name, nil
+		}
+		if hops == maxLinkHops {
+			return "", &os.PathError{Op: "stat", Path: name, Err: absfs.ErrSymlinkCycle}
+		}
+
+		if host := strings.TrimPrefix(target, hostLinkPrefix); host != target {
+			name = filepath.Join(host, filepath.FromSlash(rest))
+		} else {
+			name = Join(target, rest)
+		}
+	}
+}
+
+// crossLink resolves the elements of name in fs one at a time, and returns
+// the target of the first symbolic link to another backend and the rest of
+// name after it. Links within fs are followed on the way, so a link to
+// another backend is found behind them too.
+func crossLink(fs *vfs.FileSystem, name string) (target, rest string, found bool, err error) {
+	elems := strings.Split(strings.Trim(vfs.Clean(name), "/"), "/")
+	dir := "/"
+	for i, hops := 0, 0; i < len(elems); i++ {
+		if elems[i] == "" {
+			continue
+		}
+		p := vfs.Join(dir, elems[i])
+		info, err := fs.Lstat(p)
+		if err != nil {
+			break
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			dir = p
+			continue
+		}
+		target, err := fs.Readlink(p)
+		if err != nil {
+			break
+		}
+		if _, _, ok := splitScheme(target); ok {
+			return target, strings.Join(elems[i+1:], "/"), true, nil
+		}
+
+		// continue from the target of a link within fs
+		if hops++; hops > maxLinkHops {
+			return "", "", false, absfs.ErrSymlinkCycle
+		}
+		if !vfs.IsAbs(target) {
+			target = vfs.Join(dir, target)
+		}
+		elems = append(strings.Split(strings.Trim(vfs.Clean(target), "/"), "/"), elems[i+1:]...)
+		dir, i = "/", -1
+	}
+
+	return "", "", false, nil
+}
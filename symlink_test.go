@@ -0,0 +1,148 @@
+package pandorasbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+// checkRead checks that name can be read through b and holds want.
+func checkRead(t *testing.T, b *Box, name, want string) {
+	t.Helper()
+	data, err := b.ReadFile(name)
+	if err != nil {
+		t.Errorf("reading %s: %v", name, err)
+		return
+	}
+	if string(data) != want {
+		t.Errorf("read %q from %s, want %q", data, name, want)
+	}
+	if _, err := b.Stat(name); err != nil {
+		t.Errorf("stat %s: %v", name, err)
+	}
+}
+
+func TestSymlinkToHost(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ca.pem"), []byte("cert"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewBox()
+	if err := b.Symlink(dir, "vfs://certs"); err != nil {
+		t.Fatal(err)
+	}
+	checkRead(t, b, "vfs://certs/ca.pem", "cert")
+	target, err := b.Readlink("vfs://certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != dir {
+		t.Errorf("Readlink returned %q, want %q", target, dir)
+	}
+
+	if err := b.Symlink("vfs://certs", filepath.Join(dir, "link")); err == nil {
+		t.Error("linked from the host's filesystem into a VFS")
+	}
+}
+
+func TestSymlinkToVFS(t *testing.T) {
+	b := NewBox()
+	if err := b.Register("other", vfs.NewFS()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.MkdirAll("vfs://other/db", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteFile("vfs://other/db/password", []byte("hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := b.Symlink("vfs://other/db", "vfs://db"); err != nil {
+		t.Fatal(err)
+	}
+	checkRead(t, b, "vfs://db/password", "hunter2")
+	if err := b.Symlink("vfs://other/db/password", "vfs://password"); err != nil {
+		t.Fatal(err)
+	}
+	checkRead(t, b, "vfs://password", "hunter2")
+}
+
+func TestSymlinkChained(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "ca.pem"), []byte("cert"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// /a -> /b within the VFS, and /b/x -> the host directory
+	b := NewBox()
+	if err := b.Mkdir("vfs://b", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Symlink(dir, "vfs://b/x"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Symlink("vfs://b", "vfs://a"); err != nil {
+		t.Fatal(err)
+	}
+	checkRead(t, b, "vfs://a/x/ca.pem", "cert")
+
+	// relative links and links to links
+	def, _, _, _ := b.resolveVFS(VFSPrefix)
+	if err := b.Mkdir("vfs://rel", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := def.Symlink("../a", "/rel/up"); err != nil {
+		t.Fatal(err)
+	}
+	if err := def.Symlink("/rel/up", "/c"); err != nil {
+		t.Fatal(err)
+	}
+	checkRead(t, b, "vfs://rel/up/x/ca.pem", "cert")
+	checkRead(t, b, "vfs://c/x/ca.pem", "cert")
+
+	// from another VFS through a link within this one
+	if err := b.Register("other", vfs.NewFS()); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Symlink("vfs://a", "vfs://other/link"); err != nil {
+		t.Fatal(err)
+	}
+	checkRead(t, b, "vfs://other/link/x/ca.pem", "cert")
+}
+
+func TestSymlinkCycle(t *testing.T) {
+	b := NewBox()
+	if err := b.Register("other", vfs.NewFS()); err != nil {
+		t.Fatal(err)
+	}
+
+	// a cycle between two VFSs
+	if err := b.Symlink("vfs://other/loop", "vfs://loop"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Symlink("vfs://loop", "vfs://other/loop"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Stat("vfs://loop/file"); !errors.Is(err, absfs.ErrSymlinkCycle) {
+		t.Errorf("Stat: error %v, want %v", err, absfs.ErrSymlinkCycle)
+	}
+	if _, err := b.Open("vfs://loop"); !errors.Is(err, absfs.ErrSymlinkCycle) {
+		t.Errorf("Open: error %v, want %v", err, absfs.ErrSymlinkCycle)
+	}
+
+	// a cycle within a VFS, found while looking for links out of it
+	if err := b.Symlink("vfs://c2", "vfs://c1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Symlink("vfs://c1", "vfs://c2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Stat("vfs://c1/file"); !errors.Is(err, absfs.ErrSymlinkCycle) {
+		t.Errorf("Stat: error %v, want %v", err, absfs.ErrSymlinkCycle)
+	}
+}
@@ -0,0 +1,153 @@
+package pandorasbox
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/internal/errno"
+	"github.com/capnspacehook/pandorasbox/ioutil"
+)
+
+// SyncOptions control what Sync copies and removes.
+type SyncOptions struct {
+	// Hash compares regular files of the same size by the SHA-256 hashes
+	// of their contents, instead of by modification time.
+	Hash bool
+
+	// Delete removes the files under dst that aren't under src.
+	Delete bool
+}
+
+// Sync makes the tree rooted at the directory dst a copy of the one rooted
+// at the directory src, either of which may be a VFS or a host path, and
+// creates dst if it doesn't exist. Only the files Diff finds differ are
+// copied. Regular files are copied with their permissions and modification
+// times, so the next Sync skips them, directories with their permissions,
+// and symbolic links as links. A file that replaces a directory, or the
+// other way around, replaces everything under it. Files only under dst are
+// left alone unless opts.Delete is set. opts may be nil.
+//
+// Sync returns the changes it made to dst.
+func (b *Box) Sync(src, dst string, opts *SyncOptions) (*Changes, error) {
+	if opts == nil {
+		opts = new(SyncOptions)
+	}
+	srcTree, err := b.newDiffTree(src)
+	if err != nil {
+		return nil, err
+	}
+	dstTree, err := b.newDiffTree(dst)
+	if err != nil {
+		return nil, err
+	}
+	s := &syncer{src: srcTree, dst: dstTree}
+	if s.src.fs == s.dst.fs && within(s.src.root, s.dst.root) {
+		return nil, &os.LinkError{Op: "sync", Old: src, New: dst, Err: absfs.ErrInvalid}
+	}
+
+	return s.run(opts)
+}
+
+// syncer holds the state of a Sync.
+type syncer struct {
+	src, dst *diffTree
+}
+
+// run makes the destination a copy of the source.
+func (s *syncer) run(opts *SyncOptions) (*Changes, error) {
+	if err := s.src.list(); err != nil {
+		return nil, err
+	}
+	if _, err := s.dst.fs.Lstat(s.dst.root); os.IsNotExist(err) {
+		info, err := s.src.fs.Stat(s.src.root)
+		if err != nil {
+			return nil, errno.Map(err)
+		}
+		if err := s.dst.fs.MkdirAll(s.dst.root, info.Mode().Perm()); err != nil {
+			return nil, errno.Map(err)
+		}
+	}
+	if err := s.dst.list(); err != nil {
+		return nil, err
+	}
+
+	c, err := diff(s.dst, s.src, &DiffOptions{Hash: opts.Hash})
+	if err != nil {
+		return nil, err
+	}
+	if opts.Delete {
+		for _, rel := range c.Removed {
+			if err := s.dst.fs.RemoveAll(s.dst.path(rel)); err != nil {
+				return nil, errno.Map(err)
+			}
+		}
+	} else {
+		c.Removed = nil
+	}
+	for _, rel := range c.Changed {
+		if err := s.replace(rel, s.src.files[rel], s.dst.files[rel]); err != nil {
+			return nil, err
+		}
+	}
+	for _, rel := range c.Added {
+		if err := s.copy(rel, s.src.files[rel]); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// within reports whether the path dir is under root, without being root.
+func within(root, dir string) bool {
+	rel, err := filepath.Rel(root, dir)
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// replace replaces the file rel in the destination, described by old, with
+// the one in the source, described by info.
+func (s *syncer) replace(rel string, info, old os.FileInfo) error {
+	if fileType(info) == os.ModeDir && fileType(old) == os.ModeDir {
+		return errno.Map(s.dst.fs.Chmod(s.dst.path(rel), info.Mode()))
+	}
+	if !info.Mode().IsRegular() || !old.Mode().IsRegular() {
+		if err := s.dst.fs.RemoveAll(s.dst.path(rel)); err != nil {
+			return errno.Map(err)
+		}
+	}
+
+	return s.copy(rel, info)
+}
+
+// copy copies the file rel, described by info, from the source to the
+// destination.
+func (s *syncer) copy(rel string, info os.FileInfo) error {
+	src, dst := s.src.path(rel), s.dst.path(rel)
+
+	var err error
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		var target string
+		target, err = s.src.fs.Readlink(src)
+		if err == nil {
+			err = s.dst.fs.Symlink(target, dst)
+		}
+	case info.IsDir():
+		err = s.dst.fs.Mkdir(dst, info.Mode().Perm())
+		if err == nil {
+			err = s.dst.fs.Chmod(dst, info.Mode())
+		}
+	case info.Mode().IsRegular():
+		err = ioutil.CopyFile(s.dst.fs, dst, s.src.fs, src)
+		if err == nil {
+			err = s.dst.fs.Chmod(dst, info.Mode())
+		}
+		if err == nil {
+			err = s.dst.fs.Chtimes(dst, atime(info), info.ModTime())
+		}
+	}
+
+	return errno.Map(err)
+}
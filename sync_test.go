@@ -0,0 +1,93 @@
+package pandorasbox
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+func TestSync(t *testing.T) {
+	b := NewBox()
+	if err := b.MkdirAll("vfs://src/sub", 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a", "sub/b"} {
+		if err := b.WriteFile("vfs://src/"+name, []byte(name), 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dst := filepath.Join(t.TempDir(), "dst")
+
+	c, err := b.Sync("vfs://src", dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a", "sub", "sub/b"}; !reflect.DeepEqual(c.Added, want) {
+		t.Errorf("first Sync added %v, want %v", c.Added, want)
+	}
+	data, err := os.ReadFile(filepath.Join(dst, "sub", "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "sub/b" {
+		t.Errorf("synced %q, want %q", data, "sub/b")
+	}
+	info, err := os.Stat(filepath.Join(dst, "a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("synced with mode %v, want %v", info.Mode().Perm(), os.FileMode(0640))
+	}
+
+	c, err = b.Sync("vfs://src", dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Empty() {
+		t.Errorf("unchanged tree synced again: %+v", c)
+	}
+
+	if err := b.WriteFile("vfs://src/a", []byte("changed"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dst, "extra"), []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err = b.Sync("vfs://src", dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.Changed, []string{"a"}) || len(c.Removed) != 0 {
+		t.Errorf("Sync = %+v, want only a changed", c)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "extra")); err != nil {
+		t.Errorf("Sync without Delete removed a file: %v", err)
+	}
+	c, err = b.Sync("vfs://src", dst, &SyncOptions{Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(c.Removed, []string{"extra"}) {
+		t.Errorf("Sync with Delete removed %v, want [extra]", c.Removed)
+	}
+	if _, err := os.Stat(filepath.Join(dst, "extra")); !os.IsNotExist(err) {
+		t.Errorf("extra file left behind: %v", err)
+	}
+
+	// and back into the VFS
+	if _, err := b.Sync(dst, "vfs://copy", nil); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := b.ReadFile("vfs://copy/a"); err != nil || string(data) != "changed" {
+		t.Errorf("synced back %q, %v", data, err)
+	}
+
+	if _, err := b.Sync("vfs://src", "vfs://src/sub/inner", nil); !errors.Is(err, absfs.ErrInvalid) {
+		t.Errorf("syncing into itself: got %v, want %v", err, absfs.ErrInvalid)
+	}
+}
@@ -0,0 +1,62 @@
+package pandorasbox
+
+import (
+	"os"
+	"syscall"
+)
+
+// Tag sets the tag key of the named file to value. Only files in a VFS can
+// be tagged.
+func (b *Box) Tag(name, key, value string) error {
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return &os.PathError{Op: "tag", Path: name, Err: err}
+	}
+	if ok {
+		return fs.Tag(vfsName, key, value)
+	}
+
+	return &os.PathError{Op: "tag", Path: name, Err: syscall.ENOTSUP}
+}
+
+// Untag removes the tag key of the named file.
+func (b *Box) Untag(name, key string) error {
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return &os.PathError{Op: "untag", Path: name, Err: err}
+	}
+	if ok {
+		return fs.Untag(vfsName, key)
+	}
+
+	return &os.PathError{Op: "untag", Path: name, Err: syscall.ENOTSUP}
+}
+
+// Tags returns the tags of the named file.
+func (b *Box) Tags(name string) (map[string]string, error) {
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "tags", Path: name, Err: err}
+	}
+	if ok {
+		return fs.Tags(vfsName)
+	}
+
+	return nil, &os.PathError{Op: "tags", Path: name, Err: syscall.ENOTSUP}
+}
+
+// FindByTag returns the sorted paths of the files in the default VFS whose
+// tag key is value, or that have the tag at all if value is empty.
+func (b *Box) FindByTag(key, value string) []string {
+	fs, vfsRoot, ok, err := b.resolveVFS(VFSPrefix)
+	if err != nil || !ok {
+		return nil
+	}
+
+	paths := fs.FindByTag(key, value)
+	for i, p := range paths {
+		paths[i] = boxPath(VFSPrefix, vfsRoot, p)
+	}
+
+	return paths
+}
@@ -0,0 +1,95 @@
+package pandorasbox
+
+import (
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+// TemplateFuncs returns the functions RenderTemplate adds to templates:
+//
+//	secret "path"
+//		the contents of the file at path, which may be a VFS or a host
+//		path
+func (b *Box) TemplateFuncs() template.FuncMap {
+	return template.FuncMap{
+		"secret": func(name string) (string, error) {
+			data, err := b.ReadFile(name)
+			defer seal.Wipe(data)
+			if err != nil {
+				return "", err
+			}
+			return string(data), nil
+		},
+	}
+}
+
+// RenderTemplate executes the text/template in the file src with data,
+// and writes the output to dst with perm, atomically if dst is a host
+// path. Templates can pull values from the Box with the functions of
+// TemplateFuncs, like {{ secret "vfs://db/password" }}. The output is
+// only held in memory until it's written, and wiped once it is, but the
+// values templates pull can't be.
+func (b *Box) RenderTemplate(dst, src string, data interface{}, perm os.FileMode) error {
+	text, err := b.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	tmpl, err := template.New(filepath.Base(src)).Funcs(b.TemplateFuncs()).Parse(string(text))
+	if err != nil {
+		return err
+	}
+
+	return b.renderTemplate(dst, tmpl, data, perm)
+}
+
+// RenderHTMLTemplate is like RenderTemplate, but executes an html/template,
+// so values are escaped as the context they're used in requires.
+func (b *Box) RenderHTMLTemplate(dst, src string, data interface{}, perm os.FileMode) error {
+	text, err := b.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	funcs := htmltemplate.FuncMap(b.TemplateFuncs())
+	tmpl, err := htmltemplate.New(filepath.Base(src)).Funcs(funcs).Parse(string(text))
+	if err != nil {
+		return err
+	}
+
+	return b.renderTemplate(dst, tmpl, data, perm)
+}
+
+// renderTemplate executes tmpl and writes the output to dst.
+func (b *Box) renderTemplate(dst string, tmpl interface {
+	Execute(io.Writer, interface{}) error
+}, data interface{}, perm os.FileMode) error {
+	var buf wipeBuffer
+	defer func() { seal.Wipe(buf.b) }()
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return err
+	}
+
+	return b.WriteFileAtomic(dst, buf.b, perm)
+}
+
+// wipeBuffer is a buffer that wipes its contents when it grows, so no
+// copies of them are left behind.
+type wipeBuffer struct {
+	b []byte
+}
+
+func (w *wipeBuffer) Write(p []byte) (int, error) {
+	if len(w.b)+len(p) > cap(w.b) {
+		grown := make([]byte, len(w.b), 2*cap(w.b)+len(p))
+		copy(grown, w.b)
+		seal.Wipe(w.b)
+		w.b = grown
+	}
+	w.b = append(w.b, p...)
+
+	return len(p), nil
+}
@@ -0,0 +1,107 @@
+package pandorasbox
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTemplateBox returns a Box holding the password vfs://db/password and
+// the template vfs://tmpl/name with text.
+func newTemplateBox(t *testing.T, name, text string) *Box {
+	t.Helper()
+	b := NewBox()
+	for _, dir := range []string{"vfs://db", "vfs://tmpl"} {
+		if err := b.Mkdir(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.WriteFile("vfs://db/password", []byte(`hunter2<&>"`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteFile("vfs://tmpl/"+name, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestRenderTemplate(t *testing.T) {
+	b := newTemplateBox(t, "config", `user={{ .User }} password={{ secret "vfs://db/password" }}`)
+	const want = `user=app password=hunter2<&>"`
+	data := struct{ User string }{"app"}
+
+	if err := b.RenderTemplate("vfs://config", "vfs://tmpl/config", data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	checkRead(t, b, "vfs://config", want)
+
+	dst := filepath.Join(t.TempDir(), "config")
+	if err := b.RenderTemplate(dst, "vfs://tmpl/config", data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	checkRead(t, b, dst, want)
+	info, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("rendered file has mode %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+
+	// secrets can come from the host's filesystem too
+	if err := b.WriteFile("vfs://tmpl/host", []byte(`{{ secret "`+dst+`" }}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.RenderTemplate("vfs://host", "vfs://tmpl/host", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	checkRead(t, b, "vfs://host", want)
+}
+
+func TestRenderTemplateMissingSecret(t *testing.T) {
+	b := newTemplateBox(t, "config", `password={{ secret "vfs://db/missing" }}`)
+	for _, render := range []func(dst, src string, data interface{}, perm os.FileMode) error{
+		b.RenderTemplate,
+		b.RenderHTMLTemplate,
+	} {
+		err := render("vfs://config", "vfs://tmpl/config", nil, 0600)
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("error %v, want %v", err, fs.ErrNotExist)
+		}
+		if _, err := b.Stat("vfs://config"); err == nil {
+			t.Error("output written for a template that failed")
+		}
+	}
+}
+
+func TestRenderHTMLTemplate(t *testing.T) {
+	b := newTemplateBox(t, "page.html", `<p title="{{ .Title }}">{{ secret "vfs://db/password" }}</p>`)
+	data := struct{ Title string }{`a "title"`}
+	if err := b.RenderHTMLTemplate("vfs://page.html", "vfs://tmpl/page.html", data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	checkRead(t, b, "vfs://page.html", `<p title="a &#34;title&#34;">hunter2&lt;&amp;&gt;&#34;</p>`)
+
+	// text templates don't escape anything
+	if err := b.RenderTemplate("vfs://page.txt", "vfs://tmpl/page.html", data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	checkRead(t, b, "vfs://page.txt", `<p title="a "title"">hunter2<&>"</p>`)
+}
+
+func TestWipeBuffer(t *testing.T) {
+	var w wipeBuffer
+	var want strings.Builder
+	for i := 0; i < 100; i++ {
+		s := strings.Repeat("x", i)
+		w.Write([]byte(s))
+		want.WriteString(s)
+	}
+	if string(w.b) != want.String() {
+		t.Errorf("buffer holds %d bytes, want %d", len(w.b), want.Len())
+	}
+}
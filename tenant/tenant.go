@@ -0,0 +1,183 @@
+// Package tenant partitions one process's secrets between tenants.
+//
+// Every Tenant has its own VFS, so paths of one tenant can never address
+// files of another, and its own master key, derived from the Manager's
+// root key with HKDF. File keys are wrapped under the tenant's master key,
+// so learning one tenant's keys reveals nothing about any other tenant's
+// files.
+package tenant
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/awnumar/memguard"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/encfs"
+	"github.com/capnspacehook/pandorasbox/seal"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+var (
+	ErrExists   = errors.New("tenant already exists")
+	ErrNotFound = errors.New("tenant not found")
+	ErrBadID    = errors.New("tenant IDs must not be empty")
+)
+
+const (
+	tenantInfo = "pandorasbox tenant "
+	keyInfo    = "pandorasbox tenant key "
+)
+
+// Manager creates and tracks the tenants of a process.
+type Manager struct {
+	root *memguard.Enclave
+
+	mtx     sync.Mutex
+	tenants map[string]*Tenant
+}
+
+// A Tenant is an isolated namespace with its own key hierarchy.
+type Tenant struct {
+	id  string
+	key *memguard.Enclave
+	fs  *encfs.FileSystem
+}
+
+// NewManager returns a Manager deriving tenant keys from root, which must
+// be seal.KeySize bytes long. Use seal.NewKey for a fresh root key.
+func NewManager(root *memguard.Enclave) *Manager {
+	return &Manager{
+		root:    root,
+		tenants: make(map[string]*Tenant),
+	}
+}
+
+// Create creates the tenant id.
+func (m *Manager) Create(id string) (*Tenant, error) {
+	if id == "" {
+		return nil, ErrBadID
+	}
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if _, ok := m.tenants[id]; ok {
+		return nil, ErrExists
+	}
+	key, err := derive(m.root, tenantInfo+id)
+	if err != nil {
+		return nil, err
+	}
+	t := &Tenant{
+		id:  id,
+		key: key,
+		fs:  encfs.Wrap(vfs.NewFS(), key),
+	}
+	m.tenants[id] = t
+
+	return t, nil
+}
+
+// Get returns the tenant id.
+func (m *Manager) Get(id string) (*Tenant, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	t, ok := m.tenants[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return t, nil
+}
+
+// Remove removes the tenant id and all of its files.
+func (m *Manager) Remove(id string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	t, ok := m.tenants[id]
+	if !ok {
+		return ErrNotFound
+	}
+	delete(m.tenants, id)
+
+	return t.wipe()
+}
+
+// IDs returns the IDs of all tenants.
+func (m *Manager) IDs() []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	ids := make([]string, 0, len(m.tenants))
+	for id := range m.tenants {
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// ID returns the ID of the tenant.
+func (t *Tenant) ID() string {
+	return t.id
+}
+
+// FileSystem returns the tenant's files. They are encrypted under the
+// tenant's master key on top of the encryption of the VFS holding them.
+func (t *Tenant) FileSystem() absfs.FileSystem {
+	return t.fs
+}
+
+// DeriveKey derives a key for purpose from the tenant's master key, for
+// use by the application. The same tenant and purpose always derive the
+// same key, while different tenants never share keys.
+func (t *Tenant) DeriveKey(purpose string) (*memguard.Enclave, error) {
+	return derive(t.key, keyInfo+purpose)
+}
+
+// wipe removes every file of the tenant.
+func (t *Tenant) wipe() error {
+	backend := t.fs.Backend()
+	root, err := backend.Open("/")
+	if err != nil {
+		return err
+	}
+	names, err := root.Readdirnames(-1)
+	root.Close()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := backend.RemoveAll("/" + name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// derive derives a seal.KeySize byte key from parent with HKDF-SHA256.
+func derive(parent *memguard.Enclave, info string) (*memguard.Enclave, error) {
+	p, err := parent.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer p.Destroy()
+	if p.Size() != seal.KeySize {
+		return nil, seal.ErrInvalidKey
+	}
+
+	key := memguard.NewBuffer(seal.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, p.Bytes(), nil, []byte(info)), key.Bytes()); err != nil {
+		key.Destroy()
+		return nil, err
+	}
+
+	return key.Seal(), nil
+}
@@ -0,0 +1,58 @@
+package tenant
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+func TestIsolation(t *testing.T) {
+	m := NewManager(seal.NewKey())
+	a, err := m.Create("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := m.Create("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Create("a"); err != ErrExists {
+		t.Errorf("expected ErrExists, got %v", err)
+	}
+
+	if err := ioutil.WriteFile(a.FileSystem(), "/secret", []byte("a's secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.FileSystem().Stat("/secret"); !os.IsNotExist(err) {
+		t.Errorf("tenant b can see tenant a's file: %v", err)
+	}
+	if _, err := b.FileSystem().Stat("/../a/secret"); !os.IsNotExist(err) {
+		t.Errorf("tenant b can escape its namespace: %v", err)
+	}
+
+	ka, err := a.DeriveKey("signing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb, err := b.DeriveKey("signing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bufA, _ := ka.Open()
+	bufB, _ := kb.Open()
+	defer bufA.Destroy()
+	defer bufB.Destroy()
+	if bytes.Equal(bufA.Bytes(), bufB.Bytes()) {
+		t.Error("tenants derived the same key")
+	}
+
+	if err := m.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Get("a"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
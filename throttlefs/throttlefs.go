@@ -0,0 +1,164 @@
+// Package throttlefs provides an absfs middleware limiting the bytes and
+// operations per second each caller of a FileSystem may use.
+package throttlefs
+
+import (
+	"context"
+	"os"
+	"sync"
+
+	"golang.org/x/time/rate"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// Limits are the rates a caller is held to. A zero rate disables the
+// corresponding limit.
+type Limits struct {
+	// BytesPerSecond is the sustained rate of bytes read and written, and
+	// ByteBurst the number of bytes that may be transferred at once. It
+	// defaults to one second's worth.
+	BytesPerSecond float64
+	ByteBurst      int
+
+	// OpsPerSecond is the sustained rate of operations, and OpBurst the
+	// number of operations that may be performed at once. It defaults to
+	// one.
+	OpsPerSecond float64
+	OpBurst      int
+}
+
+type callerKey struct{}
+
+// WithCaller returns a copy of ctx identifying the caller of FileSystems
+// obtained with it. Callers are throttled independently of each other.
+func WithCaller(ctx context.Context, caller string) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// Caller returns the caller ctx identifies, or "" if it doesn't identify
+// one. Operations without a caller share the limits of "".
+func Caller(ctx context.Context) string {
+	caller, _ := ctx.Value(callerKey{}).(string)
+	return caller
+}
+
+// Throttle holds the rate limiters of every caller of a FileSystem.
+type Throttle struct {
+	mtx sync.Mutex
+
+	fs        absfs.FileSystem
+	limits    Limits
+	overrides map[string]Limits
+	callers   map[string]*limiters
+}
+
+type limiters struct {
+	bytes *rate.Limiter
+	ops   *rate.Limiter
+}
+
+// New returns a Throttle holding every caller of fs to limits.
+func New(fs absfs.FileSystem, limits Limits) *Throttle {
+	return &Throttle{
+		fs:        fs,
+		limits:    limits,
+		overrides: make(map[string]Limits),
+		callers:   make(map[string]*limiters),
+	}
+}
+
+// SetLimits holds caller to limits instead of the ones the Throttle was
+// created with.
+func (t *Throttle) SetLimits(caller string, limits Limits) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.overrides[caller] = limits
+	delete(t.callers, caller)
+}
+
+// FileSystem returns the FileSystem as used by the caller ctx identifies.
+// Operations wait until the caller's limits allow them, and fail with the
+// context's error if ctx is done first.
+func (t *Throttle) FileSystem(ctx context.Context) absfs.FileSystem {
+	return absfs.Chain(t.fs, t.Middleware(ctx))
+}
+
+// Middleware returns an absfs.Middleware throttling the caller ctx
+// identifies, for use with absfs.Chain alongside other middleware.
+func (t *Throttle) Middleware(ctx context.Context) absfs.Middleware {
+	return &middleware{ctx: ctx, lim: t.limiters(Caller(ctx))}
+}
+
+func (t *Throttle) limiters(caller string) *limiters {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if lim, ok := t.callers[caller]; ok {
+		return lim
+	}
+
+	limits, ok := t.overrides[caller]
+	if !ok {
+		limits = t.limits
+	}
+	lim := new(limiters)
+	if limits.BytesPerSecond > 0 {
+		burst := limits.ByteBurst
+		if burst < 1 {
+			burst = int(limits.BytesPerSecond)
+		}
+		if burst < 1 {
+			burst = 1
+		}
+		lim.bytes = rate.NewLimiter(rate.Limit(limits.BytesPerSecond), burst)
+	}
+	if limits.OpsPerSecond > 0 {
+		burst := limits.OpBurst
+		if burst < 1 {
+			burst = 1
+		}
+		lim.ops = rate.NewLimiter(rate.Limit(limits.OpsPerSecond), burst)
+	}
+	t.callers[caller] = lim
+
+	return lim
+}
+
+type middleware struct {
+	ctx context.Context
+	lim *limiters
+}
+
+func (m *middleware) Before(c *absfs.Call) error {
+	if err := m.ctx.Err(); err != nil {
+		return &os.PathError{Op: string(c.Op), Path: c.Path, Err: err}
+	}
+	if m.lim.ops != nil {
+		if err := m.lim.ops.Wait(m.ctx); err != nil {
+			return &os.PathError{Op: string(c.Op), Path: c.Path, Err: err}
+		}
+	}
+	if m.lim.bytes == nil || c.Op != absfs.OpRead && c.Op != absfs.OpWrite {
+		return nil
+	}
+
+	// transfers larger than the burst are waited for a burst at a time
+	for n := c.Size; n > 0; {
+		chunk := n
+		if burst := int64(m.lim.bytes.Burst()); chunk > burst {
+			chunk = burst
+		}
+		if err := m.lim.bytes.WaitN(m.ctx, int(chunk)); err != nil {
+			return &os.PathError{Op: string(c.Op), Path: c.Path, Err: err}
+		}
+		n -= chunk
+	}
+
+	return nil
+}
+
+func (m *middleware) After(c *absfs.Call, err error) error {
+	return err
+}
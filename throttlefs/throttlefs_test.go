@@ -0,0 +1,72 @@
+package throttlefs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+func TestOps(t *testing.T) {
+	th := New(vfs.NewFS(), Limits{OpsPerSecond: 20})
+	bulk := th.FileSystem(WithCaller(context.Background(), "bulk"))
+	other := th.FileSystem(WithCaller(context.Background(), "other"))
+
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		if _, err := bulk.Stat("/"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("5 operations at 20/s took %v", d)
+	}
+
+	// one caller using up its rate doesn't slow down another
+	start = time.Now()
+	if _, err := other.Stat("/"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 40*time.Millisecond {
+		t.Errorf("another caller was throttled for %v", d)
+	}
+}
+
+func TestBytes(t *testing.T) {
+	th := New(vfs.NewFS(), Limits{BytesPerSecond: 100000, ByteBurst: 10000})
+	th.SetLimits("trusted", Limits{})
+	fs := th.FileSystem(context.Background())
+
+	start := time.Now()
+	if err := ioutil.WriteFile(fs, "/f", make([]byte, 30000), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Errorf("writing 30000 bytes at 100000/s took %v", d)
+	}
+
+	trusted := th.FileSystem(WithCaller(context.Background(), "trusted"))
+	start = time.Now()
+	if _, err := ioutil.ReadFile(trusted, "/f"); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d > 100*time.Millisecond {
+		t.Errorf("unlimited caller was throttled for %v", d)
+	}
+}
+
+func TestCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	fs := New(vfs.NewFS(), Limits{OpsPerSecond: 1}).FileSystem(ctx)
+	if _, err := fs.Stat("/"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := fs.Stat("/"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package pandorasbox
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/awnumar/memguard"
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+var (
+	// ErrNoCertificate is returned for certificate files without a PEM
+	// encoded certificate.
+	ErrNoCertificate = errors.New("no certificate found")
+
+	// ErrNoPrivateKey is returned for key files without a PEM encoded
+	// private key.
+	ErrNoPrivateKey = errors.New("no private key found")
+
+	// ErrKeyMismatch is returned for private keys that don't match the
+	// public key of their certificate.
+	ErrKeyMismatch = errors.New("private key does not match certificate")
+)
+
+// LoadX509KeyPair is like tls.LoadX509KeyPair, but reads the PEM encoded
+// files from the Box, and keeps the private key sealed in an Enclave. The
+// PrivateKey of the certificate returned is a crypto.Signer, and a
+// crypto.Decrypter for RSA keys, that only opens the key for as long as it
+// takes to sign or decrypt during a handshake.
+func (b *Box) LoadX509KeyPair(certFile, keyFile string) (tls.Certificate, error) {
+	var cert tls.Certificate
+	certPEM, err := b.ReadFile(certFile)
+	if err != nil {
+		return cert, err
+	}
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type == "CERTIFICATE" {
+			cert.Certificate = append(cert.Certificate, block.Bytes)
+		}
+	}
+	if len(cert.Certificate) == 0 {
+		return cert, &os.PathError{Op: "load", Path: certFile, Err: ErrNoCertificate}
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return cert, &os.PathError{Op: "load", Path: certFile, Err: err}
+	}
+
+	keyPEM, err := b.ReadFile(keyFile)
+	defer seal.Wipe(keyPEM)
+	if err != nil {
+		return cert, err
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return cert, &os.PathError{Op: "load", Path: keyFile, Err: err}
+	}
+	defer wipePrivateKey(key)
+
+	pub, ok := cert.Leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(key.(crypto.Signer).Public()) {
+		return cert, &os.PathError{Op: "load", Path: keyFile, Err: ErrKeyMismatch}
+	}
+	if cert.PrivateKey, err = sealPrivateKey(key.(crypto.Signer)); err != nil {
+		return cert, &os.PathError{Op: "load", Path: keyFile, Err: err}
+	}
+
+	return cert, nil
+}
+
+// GetCertificate returns a function for tls.Config.GetCertificate that
+// serves the key pair loaded from certFile and keyFile by LoadX509KeyPair.
+// The key pair is loaded on the first handshake, and loaded again whenever
+// the modification time of either file changes, so certificates can be
+// rotated without restarting.
+func (b *Box) GetCertificate(certFile, keyFile string) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	var (
+		mu              sync.Mutex
+		cert            *tls.Certificate
+		certMod, keyMod time.Time
+	)
+
+	return func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		certInfo, err := b.Stat(certFile)
+		if err != nil {
+			return nil, err
+		}
+		keyInfo, err := b.Stat(keyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if cert != nil && certInfo.ModTime().Equal(certMod) && keyInfo.ModTime().Equal(keyMod) {
+			return cert, nil
+		}
+		c, err := b.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cert, certMod, keyMod = &c, certInfo.ModTime(), keyInfo.ModTime()
+
+		return cert, nil
+	}
+}
+
+// parsePrivateKey parses the first PEM encoded PKCS #1, PKCS #8 or SEC 1
+// private key in data.
+func parsePrivateKey(data []byte) (crypto.PrivateKey, error) {
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		var key crypto.PrivateKey
+		var err error
+		switch block.Type {
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		default:
+			continue
+		}
+		seal.Wipe(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := key.(crypto.Signer); !ok {
+			wipePrivateKey(key)
+			return nil, ErrNoPrivateKey
+		}
+		return key, nil
+	}
+
+	return nil, ErrNoPrivateKey
+}
+
+// wipePrivateKey overwrites the secret values of key. Copies the crypto
+// packages make of them internally can't be reached, so this is only a
+// best effort.
+func wipePrivateKey(key crypto.PrivateKey) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		clear(k.D.Bits())
+		for _, p := range k.Primes {
+			clear(p.Bits())
+		}
+		for _, v := range []*big.Int{k.Precomputed.Dp, k.Precomputed.Dq, k.Precomputed.Qinv} {
+			if v != nil {
+				clear(v.Bits())
+			}
+		}
+	case *ecdsa.PrivateKey:
+		clear(k.D.Bits())
+	case ed25519.PrivateKey:
+		clear(k)
+	}
+}
+
+// sealedKey is a private key kept sealed as PKCS #8 DER.
+type sealedKey struct {
+	der *memguard.Enclave
+	pub crypto.PublicKey
+}
+
+// open returns the private key, which must be wiped once used.
+func (k *sealedKey) open() (crypto.PrivateKey, error) {
+	der, err := k.der.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer der.Destroy()
+
+	return x509.ParsePKCS8PrivateKey(der.Bytes())
+}
+
+func (k *sealedKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+func (k *sealedKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	key, err := k.open()
+	if err != nil {
+		return nil, err
+	}
+	defer wipePrivateKey(key)
+
+	return key.(crypto.Signer).Sign(rand, digest, opts)
+}
+
+func (k *sealedKey) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	if _, ok := k.pub.(*rsa.PublicKey); !ok {
+		return nil, errors.New("only RSA keys can decrypt")
+	}
+	key, err := k.open()
+	if err != nil {
+		return nil, err
+	}
+	defer wipePrivateKey(key)
+
+	return key.(*rsa.PrivateKey).Decrypt(rand, msg, opts)
+}
@@ -0,0 +1,133 @@
+package pandorasbox
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// writeKeyPair writes a new self-signed certificate for name and its key to
+// certFile and keyFile in b.
+func writeKeyPair(t *testing.T, b *Box, name, certFile, keyFile string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert
+}
+
+func TestLoadX509KeyPair(t *testing.T) {
+	b := NewBox()
+	leaf := writeKeyPair(t, b, "a.test", "vfs://a.crt", "vfs://a.key")
+	writeKeyPair(t, b, "b.test", "vfs://b.crt", "vfs://b.key")
+
+	cert, err := b.LoadX509KeyPair("vfs://a.crt", "vfs://a.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cert.Leaf.Equal(leaf) {
+		t.Error("loaded the wrong certificate")
+	}
+	if _, ok := cert.PrivateKey.(*ecdsa.PrivateKey); ok {
+		t.Error("the private key isn't sealed")
+	}
+	digest := sha256.Sum256([]byte("message"))
+	sig, err := cert.PrivateKey.(crypto.Signer).Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(leaf.PublicKey.(*ecdsa.PublicKey), digest[:], sig) {
+		t.Error("the signature of the sealed key doesn't verify")
+	}
+
+	if _, err := b.LoadX509KeyPair("vfs://a.crt", "vfs://b.key"); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("loading a mismatched key: got %v, want %v", err, ErrKeyMismatch)
+	}
+	if _, err := b.LoadX509KeyPair("vfs://a.key", "vfs://a.key"); !errors.Is(err, ErrNoCertificate) {
+		t.Errorf("loading a key as a certificate: got %v, want %v", err, ErrNoCertificate)
+	}
+	if _, err := b.LoadX509KeyPair("vfs://a.crt", "vfs://a.crt"); !errors.Is(err, ErrNoPrivateKey) {
+		t.Errorf("loading a certificate as a key: got %v, want %v", err, ErrNoPrivateKey)
+	}
+}
+
+func TestGetCertificate(t *testing.T) {
+	b := NewBox()
+	writeKeyPair(t, b, "a.test", "vfs://srv.crt", "vfs://srv.key")
+	getCert := b.GetCertificate("vfs://srv.crt", "vfs://srv.key")
+
+	// handshake returns the certificate the server presented
+	handshake := func() *x509.Certificate {
+		t.Helper()
+		c, s := net.Pipe()
+		defer c.Close()
+		defer s.Close()
+		srv := tls.Server(s, &tls.Config{GetCertificate: getCert})
+		go srv.Handshake()
+		cli := tls.Client(c, &tls.Config{InsecureSkipVerify: true})
+		if err := cli.Handshake(); err != nil {
+			t.Fatal(err)
+		}
+		return cli.ConnectionState().PeerCertificates[0]
+	}
+
+	first := handshake()
+	if first.Subject.CommonName != "a.test" {
+		t.Fatalf("served %q, want a.test", first.Subject.CommonName)
+	}
+	if again := handshake(); !again.Equal(first) {
+		t.Error("served a different certificate without a rotation")
+	}
+
+	writeKeyPair(t, b, "b.test", "vfs://srv.crt", "vfs://srv.key")
+	later := time.Now().Add(time.Minute)
+	if err := b.Chtimes("vfs://srv.crt", later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Chtimes("vfs://srv.key", later, later); err != nil {
+		t.Fatal(err)
+	}
+	if rotated := handshake(); rotated.Subject.CommonName != "b.test" {
+		t.Errorf("served %q after a rotation, want b.test", rotated.Subject.CommonName)
+	}
+}
@@ -1,6 +1,7 @@
 package pandorasbox
 
 import (
+	"errors"
 	"os"
 	"strings"
 
@@ -45,16 +46,19 @@ func IsVFS(fi os.FileInfo) bool {
 	return fivfs
 }
 
+// IsNotExist, IsExist and IsPermission are like their counterparts in os,
+// but also unwrap err with errors.Is, so they report the same for the
+// errors of a VFS and the host's filesystem.
 func IsNotExist(err error) bool {
-	return os.IsNotExist(err)
+	return errors.Is(err, os.ErrNotExist)
 }
 
 func IsExist(err error) bool {
-	return os.IsExist(err)
+	return errors.Is(err, os.ErrExist)
 }
 
 func IsPermission(err error) bool {
-	return os.IsPermission(err)
+	return errors.Is(err, os.ErrPermission)
 }
 
 func SameFile(fi1, fi2 os.FileInfo) bool {
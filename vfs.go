@@ -6,6 +6,7 @@ import (
 
 	"github.com/capnspacehook/pandorasbox/absfs"
 	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/vfs"
 )
 
 func VFSAbs(path string) (string, error) {
@@ -80,6 +81,10 @@ func VFSSymlink(oldname, newname string) error {
 	return box.vfs.Symlink(oldname, newname)
 }
 
+func VFSLink(oldname, newname string) error {
+	return box.vfs.Link(oldname, newname)
+}
+
 // io/ioutil methods
 
 func VFSReadFile(filename string) ([]byte, error) {
@@ -101,3 +106,11 @@ func VFSTempFile(dir, prefix string) (absfs.File, error) {
 func VFSTempDir(dir, prefix string) (string, error) {
 	return ioutil.TempDir(box.vfs, dir, prefix)
 }
+
+func VFSListOpenFiles() []vfs.OpenHandle {
+	return box.VFSListOpenFiles()
+}
+
+func VFSAs(cred vfs.Credentials) absfs.FileSystem {
+	return box.VFSAs(cred)
+}
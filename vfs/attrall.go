@@ -0,0 +1,101 @@
+package vfs
+
+import (
+	"os"
+	"strings"
+	"time"
+
+	"github.com/capnspacehook/pandorasbox/inode"
+)
+
+// chmodBits are the bits of a mode that ChmodAll and File.Chmod change.
+const chmodBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// ChmodAll changes the permission bits of root and every file and
+// directory under it to those of mode, keeping their types. Symbolic links
+// aren't followed or changed. The tree is changed in one pass that other
+// calls to the *All methods and Snapshot can't interleave with.
+func (fs *FileSystem) ChmodAll(root string, mode os.FileMode) error {
+	return fs.applyAll("chmod", root, func(node *inode.Inode) {
+		if node.Mode&os.ModeSymlink == 0 {
+			node.Mode = node.Mode&^chmodBits | mode&chmodBits
+		}
+	})
+}
+
+// ChownAll changes the owner and group of root and everything under it,
+// like ChmodAll. Symbolic links themselves are changed.
+func (fs *FileSystem) ChownAll(root string, uid, gid int) error {
+	return fs.applyAll("chown", root, func(node *inode.Inode) {
+		node.Uid = uint32(uid)
+		node.Gid = uint32(gid)
+	})
+}
+
+// ChtimesAll changes the access and modification times of root and
+// everything under it, like ChmodAll. Symbolic links themselves are
+// changed.
+func (fs *FileSystem) ChtimesAll(root string, atime, mtime time.Time) error {
+	return fs.applyAll("chtimes", root, func(node *inode.Inode) {
+		node.Atime = atime
+		node.Mtime = mtime
+	})
+}
+
+// applyAll calls fn with the lock of every inode in the tree rooted at
+// root held, visiting inodes with several links once.
+func (fs *FileSystem) applyAll(op, root string, fn func(*inode.Inode)) error {
+	var err error
+	node := fs.root
+
+	name := inode.Abs(fs.cwd, root)
+	if name != "/" {
+		node, err = fs.root.Resolve(strings.TrimLeft(name, "/"))
+		if err != nil {
+			return &os.PathError{Op: op, Path: root, Err: err}
+		}
+	}
+
+	fs.mtx.Lock()
+	var (
+		changed []*inode.Inode
+		paths   []string
+		seen    = make(map[*inode.Inode]bool)
+	)
+	var apply func(node *inode.Inode, name string)
+	apply = func(node *inode.Inode, name string) {
+		if seen[node] {
+			return
+		}
+		seen[node] = true
+
+		node.Lock()
+		fn(node)
+		entries := make(inode.Directory, len(node.Dir))
+		copy(entries, node.Dir)
+		node.Unlock()
+		changed = append(changed, node)
+		paths = append(paths, name)
+
+		if !node.IsDir() || node.Mode&os.ModeSymlink != 0 {
+			return
+		}
+		for _, entry := range entries {
+			if entry.Name == "." || entry.Name == ".." {
+				continue
+			}
+			apply(entry.Inode, Join(name, entry.Name))
+		}
+	}
+	apply(node, name)
+	fs.mtx.Unlock()
+
+	if err := fs.authenticate(changed...); err != nil {
+		return &os.PathError{Op: op, Path: root, Err: err}
+	}
+	for _, p := range paths {
+		fs.notify(p, Chmod)
+	}
+
+	return nil
+}
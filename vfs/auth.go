@@ -0,0 +1,172 @@
+package vfs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"os"
+	"strings"
+
+	"github.com/capnspacehook/pandorasbox/inode"
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+// ErrTampered is returned when the metadata of a file doesn't match the tag
+// it was authenticated with.
+var ErrTampered = errors.New("metadata authentication failed")
+
+// metaTag returns a MAC over the metadata of node that is covered by
+// authentication: its inode number, mode, owner, size, modification time
+// and, for directories, the hashed names and inode numbers of its entries.
+func (fs *FileSystem) metaTag(node *inode.Inode) ([]byte, error) {
+	k, err := fs.metaKey.Open()
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, k.Bytes())
+	k.Destroy()
+
+	node.RLock()
+	defer node.RUnlock()
+
+	writeUint64(mac, node.Ino)
+	writeUint64(mac, uint64(node.Mode))
+	writeUint64(mac, uint64(node.Uid)<<32|uint64(node.Gid))
+	writeUint64(mac, uint64(node.Size))
+	writeUint64(mac, uint64(node.Mtime.UnixNano()))
+	for _, e := range node.Dir {
+		name := sha256.Sum256([]byte(e.Name))
+		mac.Write(name[:])
+		writeUint64(mac, e.Inode.Ino)
+	}
+
+	return mac.Sum(nil), nil
+}
+
+func writeUint64(h hash.Hash, v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	h.Write(b[:])
+}
+
+// authenticate records new tags for nodes after their metadata has changed.
+func (fs *FileSystem) authenticate(nodes ...*inode.Inode) error {
+	for _, node := range nodes {
+		if node == nil {
+			continue
+		}
+		tag, err := fs.metaTag(node)
+		if err != nil {
+			return err
+		}
+
+		fs.tagMtx.Lock()
+		fs.tags[node.Ino] = tag
+		fs.tagMtx.Unlock()
+	}
+
+	return nil
+}
+
+// authenticatePaths authenticates the nodes at paths that still exist.
+func (fs *FileSystem) authenticatePaths(paths ...string) error {
+	for _, path := range paths {
+		node := fs.root
+		if path != "/" {
+			var err error
+			node, err = fs.root.Resolve(strings.TrimLeft(path, "/"))
+			if err != nil {
+				continue
+			}
+		}
+		if err := fs.authenticate(node); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// VerifyAll checks the metadata of every file and directory reachable from
+// the root against the tags recorded when it was last changed through the
+// FileSystem, and that the contents of every file can be decrypted. It
+// returns an error wrapping ErrTampered for the first file whose metadata
+// was modified behind the FileSystem's back.
+func (fs *FileSystem) VerifyAll() error {
+	seen := make(map[uint64]bool)
+
+	var verify func(path string, node *inode.Inode) error
+	verify = func(path string, node *inode.Inode) error {
+		if seen[node.Ino] {
+			return nil
+		}
+		seen[node.Ino] = true
+
+		fs.tagMtx.Lock()
+		tag := fs.tags[node.Ino]
+		fs.tagMtx.Unlock()
+		want, err := fs.metaTag(node)
+		if err != nil {
+			return &os.PathError{Op: "verify", Path: path, Err: err}
+		}
+		if !hmac.Equal(tag, want) {
+			return &os.PathError{Op: "verify", Path: path, Err: ErrTampered}
+		}
+
+		if err := fs.verifyData(node); err != nil {
+			return &os.PathError{Op: "verify", Path: path, Err: err}
+		}
+
+		node.RLock()
+		entries := make(inode.Directory, len(node.Dir))
+		copy(entries, node.Dir)
+		node.RUnlock()
+
+		for _, e := range entries {
+			if e.Name == "." || e.Name == ".." {
+				continue
+			}
+			if err := verify(Join(path, e.Name), e.Inode); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return verify("/", fs.root)
+}
+
+// verifyData checks that the sealed contents of node decrypt and match its
+// size.
+func (fs *FileSystem) verifyData(node *inode.Inode) error {
+	if !node.Mode.IsRegular() {
+		return nil
+	}
+
+	fs.mtx.RLock()
+	var sf *sealedFile
+	if int(node.Ino) < len(fs.data) {
+		sf = fs.data[node.Ino]
+	}
+	fs.mtx.RUnlock()
+	if sf == nil || !fs.stored(sf) {
+		if node.Size != 0 {
+			return ErrTampered
+		}
+		return nil
+	}
+	if fs.sealedSize(sf) != node.Size {
+		return ErrTampered
+	}
+	if fs.plain {
+		return nil
+	}
+
+	plaintext := make([]byte, node.Size)
+	defer seal.Wipe(plaintext)
+
+	return fs.unseal(sf, plaintext)
+}
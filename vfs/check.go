@@ -0,0 +1,225 @@
+package vfs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/capnspacehook/pandorasbox/inode"
+)
+
+// Errors describing the inconsistencies found by Check and Repair.
+var (
+	ErrLinkCount   = errors.New("link count doesn't match the directory entries")
+	ErrParentLink  = errors.New(".. doesn't link to the parent directory")
+	ErrOrphanData  = errors.New("contents belong to a file that no longer exists")
+	ErrOrphanLink  = errors.New("symbolic link target recorded for an inode that isn't a link")
+	ErrMissingLink = errors.New("symbolic link has no target")
+)
+
+// LostFound is the directory Repair moves files it can't fix into.
+const LostFound = "/lost+found"
+
+// Check validates the inode graph of fs: that the link count of every
+// inode matches the directory entries linking to it, that every .. entry
+// links to the parent directory, that no contents are kept for files that
+// no longer exist and aren't open, and that the table of symbolic link
+// targets matches the links in the tree. It returns an *os.PathError for
+// every inconsistency found, whose Err is one of the errors above. Files
+// that are no longer linked are named by their inode number, as #ino.
+func (fs *FileSystem) Check() []error {
+	return fs.check(false)
+}
+
+// Repair is like Check, but also fixes the inconsistencies it finds. Link
+// counts and .. entries are corrected, stray contents are wiped and stray
+// link targets are forgotten. Links without a target can't be fixed, and
+// are moved into LostFound as links to nothing. It returns the
+// inconsistencies it found.
+func (fs *FileSystem) Repair() []error {
+	return fs.check(true)
+}
+
+// checker holds the state of a pass of Check or Repair.
+type checker struct {
+	fs     *FileSystem
+	repair bool
+	errs   []error
+
+	counts map[*inode.Inode]uint64
+	paths  map[*inode.Inode]string
+	live   map[uint64]*inode.Inode
+
+	// lost holds the links to move into LostFound
+	lost map[uint64]string
+}
+
+func (c *checker) problem(path string, err error) {
+	c.errs = append(c.errs, &os.PathError{Op: "check", Path: path, Err: err})
+}
+
+func (fs *FileSystem) check(repair bool) []error {
+	c := &checker{
+		fs:     fs,
+		repair: repair,
+		counts: make(map[*inode.Inode]uint64),
+		paths:  make(map[*inode.Inode]string),
+		live:   make(map[uint64]*inode.Inode),
+		lost:   make(map[uint64]string),
+	}
+
+	// files that are open keep their contents after they are removed
+	open := make(map[uint64]bool)
+	fs.fdMtx.Lock()
+	for _, f := range fs.fds {
+		if node := f.node; node != nil {
+			open[node.Ino] = true
+		}
+	}
+	fs.fdMtx.Unlock()
+
+	if repair {
+		fs.mtx.Lock()
+	} else {
+		fs.mtx.RLock()
+	}
+	c.paths[fs.root] = "/"
+	c.live[fs.root.Ino] = fs.root
+	c.walk(fs.root, "/", fs.root)
+
+	for node, count := range c.counts {
+		node.RLock()
+		nlink := node.Nlink
+		node.RUnlock()
+		if nlink == count {
+			continue
+		}
+		c.problem(c.paths[node], ErrLinkCount)
+		if repair {
+			node.Lock()
+			node.Nlink = count
+			node.Unlock()
+		}
+	}
+
+	for ino := range fs.symlinks {
+		if node := c.live[ino]; node == nil || node.Mode&os.ModeSymlink == 0 {
+			c.problem(fmt.Sprintf("#%d", ino), ErrOrphanLink)
+			if repair {
+				delete(fs.symlinks, ino)
+			}
+		}
+	}
+	for ino, node := range c.live {
+		if _, ok := fs.symlinks[ino]; ok || node.Mode&os.ModeSymlink == 0 {
+			continue
+		}
+		c.problem(c.paths[node], ErrMissingLink)
+		if repair {
+			target, err := fs.sealTarget(ino, "")
+			if err != nil {
+				c.problem(c.paths[node], err)
+				continue
+			}
+			fs.symlinks[ino] = target
+			c.lost[ino] = c.paths[node]
+		}
+	}
+
+	var orphans []*sealedFile
+	for i, sf := range fs.data {
+		if sf == nil || c.live[uint64(i)] != nil || open[uint64(i)] || !fs.stored(sf) {
+			continue
+		}
+		c.problem(fmt.Sprintf("#%d", i), ErrOrphanData)
+		if repair {
+			orphans = append(orphans, sf)
+			fs.data[i] = nil
+		}
+	}
+
+	if repair {
+		if err := fs.authenticate(c.changed()...); err != nil {
+			c.problem("/", err)
+		}
+		fs.mtx.Unlock()
+	} else {
+		fs.mtx.RUnlock()
+	}
+
+	for _, sf := range orphans {
+		fs.wipe(sf)
+	}
+	c.quarantine()
+
+	sort.SliceStable(c.errs, func(i, j int) bool {
+		return c.errs[i].(*os.PathError).Path < c.errs[j].(*os.PathError).Path
+	})
+
+	return c.errs
+}
+
+// walk counts the links to the inodes under dir, and checks that the ..
+// entry of dir links to parent.
+func (c *checker) walk(dir *inode.Inode, name string, parent *inode.Inode) {
+	dir.RLock()
+	entries := make(inode.Directory, len(dir.Dir))
+	copy(entries, dir.Dir)
+	dir.RUnlock()
+
+	for _, entry := range entries {
+		if entry.Name == ".." && entry.Inode != parent {
+			c.problem(strings.TrimSuffix(name, "/")+"/..", ErrParentLink)
+			if c.repair {
+				dir.Lock()
+				entry.Inode = parent
+				dir.Unlock()
+			}
+		}
+		node := entry.Inode
+		c.counts[node]++
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+
+		p := Join(name, entry.Name)
+		if _, ok := c.paths[node]; ok {
+			continue
+		}
+		c.paths[node] = p
+		c.live[node.Ino] = node
+		if node.IsDir() && node.Mode&os.ModeSymlink == 0 {
+			c.walk(node, p, dir)
+		}
+	}
+}
+
+// changed returns every inode that was checked, to authenticate them
+// after repairing.
+func (c *checker) changed() []*inode.Inode {
+	nodes := make([]*inode.Inode, 0, len(c.paths))
+	for node := range c.paths {
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
+
+// quarantine moves the links that couldn't be repaired into LostFound,
+// named by their inode numbers.
+func (c *checker) quarantine() {
+	if len(c.lost) == 0 {
+		return
+	}
+	if err := c.fs.MkdirAll(LostFound, 0700); err != nil {
+		c.problem(LostFound, err)
+		return
+	}
+	for ino, p := range c.lost {
+		if err := c.fs.Rename(p, Join(LostFound, fmt.Sprintf("#%d", ino))); err != nil {
+			c.problem(p, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package vfs
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/inode"
+)
+
+// Clone creates dst as a copy of the regular file src, following symbolic
+// links. Like a reflink, the copy shares the sealed contents of src, in
+// memory or on disk, until either file is written, so cloning is cheap
+// however large src is. dst gets the mode of src, and must not exist.
+func (fs *FileSystem) Clone(src, dst string) error {
+	node, err := fs.fileStat(fs.cwd, src)
+	if err != nil {
+		return &os.LinkError{Op: "clone", Old: src, New: dst, Err: errors.Unwrap(err)}
+	}
+	if node.IsDir() {
+		return &os.LinkError{Op: "clone", Old: src, New: dst, Err: absfs.ErrIsDir}
+	}
+
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	dstAbs := inode.Abs(fs.cwd, dst)
+	if dstAbs == "/" {
+		return &os.LinkError{Op: "clone", Old: src, New: dst, Err: syscall.EEXIST}
+	}
+	if _, err := fs.root.Resolve(strings.TrimLeft(dstAbs, "/")); err == nil {
+		return &os.LinkError{Op: "clone", Old: src, New: dst, Err: syscall.EEXIST}
+	}
+	parent := fs.root
+	dir, filename := Split(dstAbs)
+	if dir = Clean(dir); dir != "/" {
+		parent, err = fs.root.Resolve(strings.TrimLeft(dir, "/"))
+		if err != nil {
+			return &os.LinkError{Op: "clone", Old: src, New: dst, Err: err}
+		}
+	}
+
+	node.RLock()
+	mode := node.Mode
+	node.RUnlock()
+	clone := fs.ino.NewWithOptions(mode, &fs.inodeOpts)
+	if err := parent.Link(filename, clone); err != nil {
+		fs.ino.SubIno()
+		return &os.LinkError{Op: "clone", Old: src, New: dst, Err: err}
+	}
+	fs.data = append(fs.data, fs.cloneData(fs.data[node.Ino], clone.Ino))
+	atomic.StoreInt64(&clone.Size, atomic.LoadInt64(&node.Size))
+	fs.indexClone(node.Ino, clone.Ino)
+
+	if err := fs.authenticate(clone, parent); err != nil {
+		return &os.LinkError{Op: "clone", Old: src, New: dst, Err: err}
+	}
+	fs.notify(dstAbs, Create)
+
+	return nil
+}
+
+// cloneData returns a copy of sf for the inode ino that shares its
+// contents. Files on disk are kept until both are done with them.
+func (fs *FileSystem) cloneData(sf *sealedFile, ino uint64) *sealedFile {
+	if sf == nil {
+		return &sealedFile{ino: ino}
+	}
+
+	fs.spillMtx.Lock()
+	defer fs.spillMtx.Unlock()
+
+	clone := &sealedFile{
+		ino:        ino,
+		ciphertext: sf.ciphertext,
+		key:        sf.key,
+		spilled:    sf.spilled,
+		size:       sf.size,
+		tiered:     sf.tiered,
+		gen:        sf.gen,
+		tierIno:    sf.tierIno,
+	}
+	if sf.ciphertext != nil {
+		sf.shared = true
+		clone.shared = true
+	}
+	for _, name := range []string{sf.spilled, sf.tiered} {
+		if name == "" {
+			continue
+		}
+		if fs.diskRefs == nil {
+			fs.diskRefs = make(map[string]int)
+		}
+		fs.diskRefs[name]++
+		clone.borrowed = true
+	}
+
+	return clone
+}
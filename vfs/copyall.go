@@ -0,0 +1,104 @@
+package vfs
+
+import (
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/inode"
+)
+
+// CopyAll copies the tree rooted at src to dst, which must not exist.
+// Directories and files are copied with their modes and times, symbolic
+// links are copied as links without being followed, and files that are
+// hard linked within the tree stay linked in the copy. The contents of
+// files are cloned, see Clone, so nothing is decrypted or copied until the
+// copies are written. dst can't be inside src.
+func (fs *FileSystem) CopyAll(src, dst string) error {
+	srcAbs, dstAbs := inode.Abs(fs.cwd, src), inode.Abs(fs.cwd, dst)
+	if dstAbs == srcAbs || strings.HasPrefix(dstAbs, strings.TrimSuffix(srcAbs, "/")+"/") {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: absfs.ErrInvalid}
+	}
+	node := fs.root
+	if srcAbs != "/" {
+		var err error
+		node, err = fs.root.Resolve(strings.TrimLeft(srcAbs, "/"))
+		if err != nil {
+			return &os.LinkError{Op: "copy", Old: src, New: dst, Err: err}
+		}
+	}
+
+	if _, err := fs.root.Resolve(strings.TrimLeft(dstAbs, "/")); err == nil || dstAbs == "/" {
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: syscall.EEXIST}
+	}
+
+	c := &copier{fs: fs, links: make(map[uint64]string)}
+	if err := c.copy(node, srcAbs, dstAbs); err != nil {
+		switch e := err.(type) {
+		case *os.PathError:
+			err = e.Err
+		case *os.LinkError:
+			err = e.Err
+		}
+		return &os.LinkError{Op: "copy", Old: src, New: dst, Err: err}
+	}
+
+	return nil
+}
+
+// copier holds the state of a CopyAll.
+type copier struct {
+	fs *FileSystem
+
+	// links holds the copies of the files linked more than once in the
+	// tree, by the inode numbers of the originals
+	links map[uint64]string
+}
+
+// copy copies node, named src, to dst.
+func (c *copier) copy(node *inode.Inode, src, dst string) error {
+	node.RLock()
+	mode, nlink, atime, mtime := node.Mode, node.Nlink, node.Atime, node.Mtime
+	entries := make(inode.Directory, len(node.Dir))
+	copy(entries, node.Dir)
+	node.RUnlock()
+
+	switch {
+	case mode&os.ModeSymlink != 0:
+		target, err := c.fs.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := c.fs.Symlink(target, dst); err != nil {
+			return err
+		}
+	case mode.IsDir():
+		if err := c.fs.Mkdir(dst, mode.Perm()); err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.Name == "." || entry.Name == ".." {
+				continue
+			}
+			if err := c.copy(entry.Inode, Join(src, entry.Name), Join(dst, entry.Name)); err != nil {
+				return err
+			}
+		}
+		if err := c.fs.Chmod(dst, mode); err != nil {
+			return err
+		}
+	default:
+		if first, ok := c.links[node.Ino]; ok {
+			return c.fs.Link(first, dst)
+		}
+		if err := c.fs.Clone(src, dst); err != nil {
+			return err
+		}
+		if nlink > 1 {
+			c.links[node.Ino] = dst
+		}
+	}
+
+	return c.fs.Chtimes(dst, atime, mtime)
+}
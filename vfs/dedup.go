@@ -0,0 +1,206 @@
+package vfs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/awnumar/memguard"
+
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+// dedupBlock is a block of the contents of deduplicated files, stored once
+// in the file at path.
+type dedupBlock struct {
+	id   string
+	path string
+	size int64
+	refs int
+}
+
+// manifest lists the blocks of a deduplicated file, in order.
+type manifest struct {
+	blockSize int
+	blocks    []*dedupBlock
+}
+
+// SetDedup stores the contents of tiered files as blocks of blockSize
+// bytes, each of which is kept once however many files contain it. Blocks
+// are named by a MAC of their contents under a key held in an Enclave, and
+// are encrypted bound to that name. They are reference counted, and removed
+// once no file or snapshot refers to them. Files are deduplicated when they
+// are next written and are large enough to be tiered, see SetTiering. A
+// blockSize that isn't positive stops deduplicating the files written
+// afterwards. Stats reports the space saved.
+func (fs *FileSystem) SetDedup(blockSize int) error {
+	if fs.plain {
+		return ErrPlainSpill
+	}
+
+	fs.spillMtx.Lock()
+	defer fs.spillMtx.Unlock()
+
+	if blockSize > 0 && fs.dedupKey == nil {
+		fs.dedupKey = seal.NewKey()
+		fs.dedupMAC = seal.NewKey()
+		fs.blocks = make(map[string]*dedupBlock)
+		fs.manifests = make(map[string]*manifest)
+	}
+	fs.dedupSize = blockSize
+
+	return nil
+}
+
+// sealDedup stores plaintext as deduplicated blocks in dir and makes it the
+// contents of sf.
+func (fs *FileSystem) sealDedup(sf *sealedFile, plaintext []byte, dir string, blockSize int, key, macKey *memguard.Enclave) error {
+	var b [16]byte
+	if _, err := io.ReadFull(fs.rand, b[:]); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, "dedup-"+hex.EncodeToString(b[:]))
+
+	k, err := macKey.Open()
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, k.Bytes())
+	k.Destroy()
+
+	m := &manifest{blockSize: blockSize}
+	for off := 0; off < len(plaintext); off += blockSize {
+		block := plaintext[off:]
+		if len(block) > blockSize {
+			block = block[:blockSize]
+		}
+		mac.Reset()
+		mac.Write(block)
+		id := hex.EncodeToString(mac.Sum(nil))
+
+		blk, err := fs.storeBlock(dir, id, block, key)
+		if err != nil {
+			fs.spillMtx.Lock()
+			fs.releaseBlocks(m.blocks)
+			fs.spillMtx.Unlock()
+			return err
+		}
+		m.blocks = append(m.blocks, blk)
+	}
+
+	fs.spillMtx.Lock()
+	defer fs.spillMtx.Unlock()
+
+	fs.drop(sf)
+	fs.manifests[name] = m
+	sf.ciphertext = nil
+	sf.key = key
+	sf.tiered = name
+	sf.gen = 0
+	sf.tierIno = sf.ino
+	sf.size = int64(len(plaintext))
+
+	return nil
+}
+
+// storeBlock returns the stored block with the given id, adding a reference
+// to it, and stores it in dir first if it isn't stored yet.
+func (fs *FileSystem) storeBlock(dir, id string, block []byte, key *memguard.Enclave) (*dedupBlock, error) {
+	fs.spillMtx.Lock()
+	blk := fs.blocks[id]
+	if blk != nil {
+		blk.refs++
+	}
+	fs.spillMtx.Unlock()
+	if blk != nil {
+		return blk, nil
+	}
+
+	ciphertext, err := seal.SealChunk(block, key, []byte(id))
+	if err != nil {
+		return nil, err
+	}
+	// blocks are written under a temporary name, so they are never read
+	// half written
+	f, err := os.CreateTemp(dir, "block-")
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.Write(ciphertext)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	path := filepath.Join(dir, id)
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+
+	fs.spillMtx.Lock()
+	defer fs.spillMtx.Unlock()
+
+	// the same block may have been stored meanwhile
+	if blk = fs.blocks[id]; blk != nil {
+		blk.refs++
+		return blk, nil
+	}
+	blk = &dedupBlock{id: id, path: path, size: int64(len(block)), refs: 1}
+	fs.blocks[id] = blk
+
+	return blk, nil
+}
+
+// releaseBlocks drops a reference to each of blocks, removing the ones no
+// longer referred to. fs.spillMtx must be held.
+func (fs *FileSystem) releaseBlocks(blocks []*dedupBlock) {
+	for _, blk := range blocks {
+		blk.refs--
+		if blk.refs > 0 {
+			continue
+		}
+		delete(fs.blocks, blk.id)
+		os.Remove(blk.path)
+	}
+}
+
+// removeFile removes the named cache or tier file, releasing its blocks if
+// it's deduplicated. fs.spillMtx must be held.
+func (fs *FileSystem) removeFile(name string) {
+	if m, ok := fs.manifests[name]; ok {
+		delete(fs.manifests, name)
+		fs.releaseBlocks(m.blocks)
+		return
+	}
+	os.Remove(name)
+}
+
+// readDedup decrypts the contents of the deduplicated file m at off into p.
+func readDedup(m *manifest, key *memguard.Enclave, p []byte, off int64) (int, error) {
+	var (
+		n         int
+		blockSize = int64(m.blockSize)
+		block     = make([]byte, 0, m.blockSize)
+	)
+	defer seal.Wipe(block[:cap(block)])
+	for n < len(p) {
+		i := (off + int64(n)) / blockSize
+		blk := m.blocks[i]
+		ciphertext, err := os.ReadFile(blk.path)
+		if err != nil {
+			return n, err
+		}
+		block, err = seal.OpenChunk(block[:0], ciphertext, key, []byte(blk.id))
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], block[off+int64(n)-i*blockSize:])
+	}
+
+	return n, nil
+}
@@ -0,0 +1,128 @@
+package vfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/capnspacehook/pandorasbox/inode"
+)
+
+// DumpOptions control how DumpTree renders a tree.
+type DumpOptions struct {
+	// Root is the directory to render, the root of the FileSystem if
+	// empty.
+	Root string
+
+	// JSON renders the tree as a JSON document instead of text.
+	JSON bool
+
+	// Depth limits how many levels below Root are rendered, if positive.
+	Depth int
+}
+
+// A DumpNode is a file or directory rendered by DumpTree as JSON.
+type DumpNode struct {
+	Name     string      `json:"name"`
+	Ino      uint64      `json:"ino"`
+	Mode     string      `json:"mode"`
+	Nlink    uint64      `json:"nlink"`
+	Size     int64       `json:"size"`
+	Target   string      `json:"target,omitempty"`
+	Children []*DumpNode `json:"children,omitempty"`
+}
+
+// DumpTree writes a rendering of the names, sizes, modes, inode numbers
+// and link counts of the files under opts.Root to w, for debugging. File
+// contents aren't decrypted. Symbolic links are rendered with their
+// targets, but aren't followed.
+func (fs *FileSystem) DumpTree(w io.Writer, opts DumpOptions) error {
+	root := opts.Root
+	if root == "" {
+		root = "/"
+	}
+	node, err := fs.fileStat(fs.cwd, root)
+	if err != nil {
+		return err
+	}
+
+	depth := opts.Depth
+	if depth <= 0 {
+		depth = -1
+	}
+	tree := fs.dumpNode(node, root, depth, make(map[*inode.Inode]bool))
+	if opts.JSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(tree)
+	}
+
+	var b strings.Builder
+	writeDumpLine(&b, "", tree)
+	writeDumpChildren(&b, "", tree.Children)
+	_, err = io.WriteString(w, b.String())
+
+	return err
+}
+
+// dumpNode returns the rendering of node and the nodes under it, up to
+// depth levels down, or all of them if depth is negative. parents holds
+// the directories being rendered, so directories linked into themselves
+// aren't rendered forever.
+func (fs *FileSystem) dumpNode(node *inode.Inode, name string, depth int, parents map[*inode.Inode]bool) *DumpNode {
+	node.RLock()
+	d := &DumpNode{
+		Name:  name,
+		Ino:   node.Ino,
+		Mode:  node.Mode.String(),
+		Nlink: node.Nlink,
+		Size:  node.Size,
+	}
+	entries := make(inode.Directory, len(node.Dir))
+	copy(entries, node.Dir)
+	node.RUnlock()
+
+	if node.Mode&os.ModeSymlink != 0 {
+		fs.mtx.RLock()
+		d.Target = fs.linkTarget(node.Ino)
+		fs.mtx.RUnlock()
+		return d
+	}
+	if !node.IsDir() || depth == 0 || parents[node] {
+		return d
+	}
+
+	parents[node] = true
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+		d.Children = append(d.Children, fs.dumpNode(entry.Inode, entry.Name, depth-1, parents))
+	}
+	delete(parents, node)
+
+	return d
+}
+
+func writeDumpChildren(b *strings.Builder, indent string, children []*DumpNode) {
+	for i, child := range children {
+		branch, next := "├── ", "│   "
+		if i == len(children)-1 {
+			branch, next = "└── ", "    "
+		}
+		writeDumpLine(b, indent+branch, child)
+		writeDumpChildren(b, indent+next, child.Children)
+	}
+}
+
+func writeDumpLine(b *strings.Builder, prefix string, d *DumpNode) {
+	b.WriteString(prefix)
+	b.WriteString(d.Name)
+	if d.Target != "" {
+		b.WriteString(" -> ")
+		b.WriteString(d.Target)
+	}
+	fmt.Fprintf(b, " [ino %d, %s, nlink %d, size %d]\n", d.Ino, d.Mode, d.Nlink, d.Size)
+}
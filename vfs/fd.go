@@ -0,0 +1,90 @@
+package vfs
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/capnspacehook/pandorasbox/inode"
+)
+
+// fdBase is the first descriptor handed out, after those of the standard
+// streams.
+const fdBase = 3
+
+// An OpenHandle describes a file that is open.
+type OpenHandle struct {
+	Fd     uintptr
+	Path   string
+	Flags  int
+	Offset int64
+	Opened time.Time
+}
+
+// open gives f a descriptor and adds it to the table of open files.
+func (fs *FileSystem) open(f *File) *File {
+	f.path = inode.Abs(fs.cwd, f.name)
+	f.opened = fs.now()
+
+	fs.fdMtx.Lock()
+	defer fs.fdMtx.Unlock()
+
+	if fs.fds == nil {
+		fs.fds = make(map[uintptr]*File)
+		fs.nextFd = fdBase
+	}
+	f.fd = fs.nextFd
+	fs.nextFd++
+	fs.fds[f.fd] = f
+
+	return f
+}
+
+// release removes f from the table of open files.
+func (fs *FileSystem) release(f *File) {
+	fs.fdMtx.Lock()
+	defer fs.fdMtx.Unlock()
+
+	if fs.fds[f.fd] == f {
+		delete(fs.fds, f.fd)
+	}
+}
+
+// Fd returns the synthetic descriptor of the file. Descriptors are unique
+// within a FileSystem and never reused, so a stale descriptor can't refer
+// to a file opened later. They aren't OS file descriptors and can only be
+// used with LookupFd.
+func (f *File) Fd() uintptr {
+	return f.fd
+}
+
+// LookupFd returns the open file with the descriptor fd.
+func (fs *FileSystem) LookupFd(fd uintptr) (*File, bool) {
+	fs.fdMtx.Lock()
+	defer fs.fdMtx.Unlock()
+
+	f, ok := fs.fds[fd]
+	return f, ok
+}
+
+// ListOpenFiles returns the files that are open, ordered by descriptor.
+// Paths are those the files were opened with, made absolute; files that
+// were renamed or removed since are still listed under them.
+func (fs *FileSystem) ListOpenFiles() []OpenHandle {
+	fs.fdMtx.Lock()
+	handles := make([]OpenHandle, 0, len(fs.fds))
+	for _, f := range fs.fds {
+		handles = append(handles, OpenHandle{
+			Fd:     f.fd,
+			Path:   f.path,
+			Flags:  f.flags,
+			Offset: atomic.LoadInt64(&f.offset),
+			Opened: f.opened,
+		})
+	}
+	fs.fdMtx.Unlock()
+
+	sort.Slice(handles, func(i, j int) bool { return handles[i].Fd < handles[j].Fd })
+
+	return handles
+}
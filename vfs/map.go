@@ -0,0 +1,60 @@
+package vfs
+
+import (
+	"os"
+
+	"github.com/awnumar/memguard"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// A Mapping is a read-only view of the decrypted contents of a file, held
+// in locked memory. It doesn't change when the file is written to.
+type Mapping struct {
+	buf *memguard.LockedBuffer
+}
+
+// Map decrypts the contents of f into locked, read-only memory. The
+// Mapping must be released when it's no longer needed.
+func (f *File) Map() (*Mapping, error) {
+	if f.node == nil {
+		return nil, &os.PathError{Op: "map", Path: f.name, Err: os.ErrClosed}
+	}
+	if f.flags&absfs.O_ACCESS == os.O_WRONLY {
+		return nil, &os.PathError{Op: "map", Path: f.name, Err: absfs.ErrBadFile}
+	}
+	if f.node.IsDir() {
+		return nil, &os.PathError{Op: "map", Path: f.name, Err: absfs.ErrIsDir}
+	}
+
+	f.data.count(accessRead)
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+
+	buf := memguard.NewBuffer(int(f.node.Size))
+	if buf.Size() != 0 {
+		if err := f.fs.unseal(f.data, buf.Bytes()); err != nil {
+			buf.Destroy()
+			return nil, &os.PathError{Op: "map", Path: f.name, Err: err}
+		}
+	}
+	buf.Freeze()
+
+	return &Mapping{buf: buf}, nil
+}
+
+// Bytes returns the contents of the mapping. Writing to it faults, and it
+// must not be used after the mapping is released.
+func (m *Mapping) Bytes() []byte {
+	return m.buf.Bytes()
+}
+
+// Len returns the size of the mapping in bytes.
+func (m *Mapping) Len() int {
+	return m.buf.Size()
+}
+
+// Release wipes and unlocks the memory of the mapping.
+func (m *Mapping) Release() {
+	m.buf.Destroy()
+}
@@ -0,0 +1,179 @@
+package vfs
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+// An Option configures a FileSystem created by NewFS.
+type Option func(*FileSystem)
+
+// A Cipher is how a FileSystem seals the contents of files.
+type Cipher int
+
+const (
+	// XSalsa20Poly1305 seals file contents with XSalsa20-Poly1305 under a
+	// fresh key per write, see package seal. It's the default.
+	XSalsa20Poly1305 Cipher = iota
+
+	// NoCipher stores file contents unencrypted, like NewPlainFS.
+	NoCipher
+
+	// AES256GCM seals file contents with AES-256-GCM from the standard
+	// library, see seal.AESGCM.
+	AES256GCM
+)
+
+// WithUmask sets the mask applied to the permissions of new files and
+// directories. It defaults to 0755.
+func WithUmask(umask os.FileMode) Option {
+	return func(fs *FileSystem) {
+		fs.Umask = umask
+	}
+}
+
+// WithTempDir sets the directory TempDir returns. It defaults to /tmp.
+func WithTempDir(dir string) Option {
+	return func(fs *FileSystem) {
+		fs.Tempdir = dir
+	}
+}
+
+// WithCipher sets how file contents are sealed.
+func WithCipher(c Cipher) Option {
+	return func(fs *FileSystem) {
+		fs.plain = c == NoCipher
+		fs.sealer = seal.XSalsa20Poly1305
+		if c == AES256GCM {
+			fs.sealer = seal.AESGCM
+		}
+	}
+}
+
+// WithSealer seals file contents held in memory or spilled to disk with s.
+// Tiered and deduplicated files, symbolic link targets and saved images
+// are always sealed by package seal.
+func WithSealer(s seal.Sealer) Option {
+	return func(fs *FileSystem) {
+		fs.plain = false
+		fs.sealer = s
+	}
+}
+
+// WithMemoryBudget limits the sealed file contents kept in memory to
+// budget bytes, like SetMemoryBudget, creating the cache directory in dir
+// once files first need to be spilled. It has no effect with NoCipher.
+func WithMemoryBudget(budget int64, dir string) Option {
+	return func(fs *FileSystem) {
+		fs.budget = budget
+		fs.budgetDir = dir
+	}
+}
+
+// WithClock sets the function that returns the time files are stamped
+// with when they're created, accessed or modified, and descriptors when
+// they're opened. It defaults to time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(fs *FileSystem) {
+		fs.inodeOpts.Clock = now
+	}
+}
+
+// WithRand sets the source of randomness the names of the files spilled,
+// tiered or deduplicated to disk are drawn from, which must not repeat
+// itself. It defaults to crypto/rand.Reader. Keys and nonces are always
+// drawn from the operating system.
+func WithRand(r io.Reader) Option {
+	return func(fs *FileSystem) {
+		fs.rand = r
+	}
+}
+
+// WithConcurrency sets how many files are decrypted and sealed again at
+// once by operations on every file, like EnableIndex and disabling
+// tiering. A value that isn't positive uses GOMAXPROCS goroutines. It
+// defaults to 1.
+func WithConcurrency(n int) Option {
+	return func(fs *FileSystem) {
+		if n <= 0 {
+			n = runtime.GOMAXPROCS(0)
+		}
+		fs.workers = n
+	}
+}
+
+// WithCaseInsensitive makes names compare case insensitively, so Secret
+// and secret name the same file. Files keep the case they were created
+// with, and renaming a file to a name that only differs in case changes
+// its case.
+func WithCaseInsensitive() Option {
+	return func(fs *FileSystem) {
+		fs.inodeOpts.FoldCase = true
+	}
+}
+
+// now returns the time according to the clock of fs.
+func (fs *FileSystem) now() time.Time {
+	if fs.inodeOpts.Clock != nil {
+		return fs.inodeOpts.Clock()
+	}
+	return time.Now()
+}
+
+// Now returns the current time according to the clock of fs. See
+// WithClock.
+func (fs *FileSystem) Now() time.Time {
+	return fs.now()
+}
+
+// eachFile calls fn with the contents of every file, from as many
+// goroutines as the concurrency of fs allows, and returns the first error
+// fn returns. Files that aren't stored anywhere are skipped.
+func (fs *FileSystem) eachFile(fn func(sf *sealedFile) error) error {
+	fs.mtx.RLock()
+	data := fs.data
+	fs.mtx.RUnlock()
+
+	files := make(chan *sealedFile)
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+		done     = make(chan struct{})
+	)
+	for i := 0; i < max(fs.workers, 1); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sf := range files {
+				if err := fn(sf); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						close(done)
+					})
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, sf := range data {
+		if sf == nil || !fs.stored(sf) {
+			continue
+		}
+		select {
+		case files <- sf:
+		case <-done:
+			break feed
+		}
+	}
+	close(files)
+	wg.Wait()
+
+	return firstErr
+}
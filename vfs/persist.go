@@ -0,0 +1,320 @@
+package vfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/awnumar/memguard"
+
+	"github.com/capnspacehook/pandorasbox/inode"
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+// imageVersion is the version of the format Save writes. Load reads every
+// version up to it.
+const imageVersion = 1
+
+// imageMagic starts every image written by Save.
+var imageMagic = []byte("PBXVFS")
+
+var (
+	// ErrBadImage is returned by Load for data that wasn't written by
+	// Save, or was saved under another key.
+	ErrBadImage = errors.New("not a saved filesystem")
+
+	// ErrImageVersion is returned by Load for images written by a newer
+	// version of Save.
+	ErrImageVersion = errors.New("unsupported filesystem image version")
+)
+
+// image is everything Save records about a FileSystem.
+type image struct {
+	Version int
+	Plain   bool
+	Root    uint64
+	Nodes   []imageNode
+}
+
+// imageNode is an inode in an image, along with everything keyed by its
+// inode number.
+type imageNode struct {
+	Ino   uint64
+	Mode  os.FileMode
+	Nlink uint64
+	Size  int64
+	Ctime time.Time
+	Atime time.Time
+	Mtime time.Time
+	Uid   uint32
+	Gid   uint32
+
+	// Entries are the entries of a directory, including . and .., in
+	// order.
+	Entries []imageEntry
+
+	Target string
+	Xattrs map[string][]byte
+	Tags   map[string]string
+	Data   []byte
+}
+
+type imageEntry struct {
+	Name string
+	Ino  uint64
+}
+
+// Save writes an image of fs to w, encrypted under key, which Load restores
+// it from. The image holds the whole tree: the metadata and contents of
+// every file, symbolic link targets, hard links, extended attributes and
+// tags. Files that were removed but are still open, snapshots, and
+// settings like memory budgets and tiering aren't saved. The image is
+// versioned, so images of older versions can still be loaded.
+func (fs *FileSystem) Save(w io.Writer, key *memguard.Enclave) error {
+	img, err := fs.image()
+	defer img.wipe()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	defer func() { seal.Wipe(buf.Bytes()[:buf.Cap()]) }()
+	if err := gob.NewEncoder(&buf).Encode(img); err != nil {
+		return err
+	}
+	ciphertext, err := seal.Encrypt(buf.Bytes(), key)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, len(imageMagic)+4)
+	copy(header, imageMagic)
+	binary.BigEndian.PutUint32(header[len(imageMagic):], imageVersion)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err = w.Write(ciphertext)
+
+	return err
+}
+
+// image returns an image of fs, holding the decrypted contents of its
+// files. It must be wiped once it's no longer needed, even on error.
+func (fs *FileSystem) image() (*image, error) {
+	img := &image{Version: imageVersion, Plain: fs.plain, Root: fs.root.Ino}
+
+	fs.mtx.RLock()
+	seen := make(map[*inode.Inode]bool)
+	var datas []*sealedFile
+	var walk func(node *inode.Inode)
+	walk = func(node *inode.Inode) {
+		if seen[node] {
+			return
+		}
+		seen[node] = true
+
+		node.RLock()
+		n := imageNode{
+			Ino:   node.Ino,
+			Mode:  node.Mode,
+			Nlink: node.Nlink,
+			Size:  node.Size,
+			Ctime: node.Ctime,
+			Atime: node.Atime,
+			Mtime: node.Mtime,
+			Uid:   node.Uid,
+			Gid:   node.Gid,
+		}
+		entries := make(inode.Directory, len(node.Dir))
+		copy(entries, node.Dir)
+		node.RUnlock()
+
+		for _, entry := range entries {
+			n.Entries = append(n.Entries, imageEntry{entry.Name, entry.Inode.Ino})
+		}
+		if node.Mode&os.ModeSymlink != 0 {
+			n.Target = fs.linkTarget(node.Ino)
+		}
+		for attr, value := range fs.xattrs[node.Ino] {
+			if n.Xattrs == nil {
+				n.Xattrs = make(map[string][]byte)
+			}
+			n.Xattrs[attr] = value
+		}
+		for k, v := range fs.fileTags[node.Ino] {
+			if n.Tags == nil {
+				n.Tags = make(map[string]string)
+			}
+			n.Tags[k] = v
+		}
+		var sf *sealedFile
+		if node.Mode.IsRegular() && int(node.Ino) < len(fs.data) {
+			sf = fs.data[node.Ino]
+		}
+		img.Nodes = append(img.Nodes, n)
+		datas = append(datas, sf)
+
+		for _, entry := range entries {
+			walk(entry.Inode)
+		}
+	}
+	walk(fs.root)
+	fs.mtx.RUnlock()
+
+	for i, sf := range datas {
+		if sf == nil || !fs.stored(sf) {
+			continue
+		}
+		plaintext := make([]byte, fs.sealedSize(sf))
+		img.Nodes[i].Data = plaintext
+		if err := fs.unseal(sf, plaintext); err != nil {
+			return img, err
+		}
+	}
+
+	return img, nil
+}
+
+// wipe overwrites the file contents held in img.
+func (img *image) wipe() {
+	for _, n := range img.Nodes {
+		seal.Wipe(n.Data)
+	}
+}
+
+// Load returns the FileSystem saved to r by Save under key.
+func Load(r io.Reader, key *memguard.Enclave) (*FileSystem, error) {
+	header := make([]byte, len(imageMagic)+4)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header[:len(imageMagic)], imageMagic) {
+		return nil, ErrBadImage
+	}
+	if binary.BigEndian.Uint32(header[len(imageMagic):]) > imageVersion {
+		return nil, ErrImageVersion
+	}
+	ciphertext, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < seal.Overhead {
+		return nil, ErrBadImage
+	}
+	plaintext := make([]byte, seal.Size(ciphertext))
+	defer seal.Wipe(plaintext)
+	if err := seal.Decrypt(ciphertext, key, plaintext); err != nil {
+		return nil, ErrBadImage
+	}
+
+	var img image
+	if err := gob.NewDecoder(bytes.NewReader(plaintext)).Decode(&img); err != nil {
+		return nil, ErrBadImage
+	}
+	defer img.wipe()
+	if img.Version > imageVersion {
+		return nil, ErrImageVersion
+	}
+
+	return loadImage(&img)
+}
+
+// CloneFS returns an independent copy of fs. Every file is decrypted and
+// sealed again under fresh keys, and the metadata is authenticated under a
+// fresh key too, so the copy shares no keys or mutable state with fs and
+// can be forked off per job or request. It holds what Save would, and has
+// the Options, Umask and Tempdir of fs.
+func (fs *FileSystem) CloneFS() (*FileSystem, error) {
+	img, err := fs.image()
+	defer img.wipe()
+	if err != nil {
+		return nil, err
+	}
+
+	clone, err := loadImage(img, fs.opts...)
+	if err != nil {
+		return nil, err
+	}
+	clone.Umask = fs.Umask
+	clone.Tempdir = fs.Tempdir
+
+	return clone, nil
+}
+
+// loadImage returns a FileSystem configured by opts holding the tree
+// recorded in img.
+func loadImage(img *image, opts ...Option) (*FileSystem, error) {
+	fs := NewFS(opts...)
+	fs.plain = img.Plain
+
+	nodes := make(map[uint64]*inode.Inode, len(img.Nodes))
+	var maxIno uint64
+	for _, n := range img.Nodes {
+		if _, ok := nodes[n.Ino]; ok {
+			return nil, ErrBadImage
+		}
+		nodes[n.Ino] = &inode.Inode{
+			Ino:   n.Ino,
+			Mode:  n.Mode,
+			Nlink: n.Nlink,
+			Size:  n.Size,
+			Ctime: n.Ctime,
+			Atime: n.Atime,
+			Mtime: n.Mtime,
+			Uid:   n.Uid,
+			Gid:   n.Gid,
+		}
+		if n.Ino > maxIno {
+			maxIno = n.Ino
+		}
+	}
+	root := nodes[img.Root]
+	if root == nil || !root.IsDir() {
+		return nil, ErrBadImage
+	}
+
+	fs.root, fs.dir = root, root
+	*fs.ino = inode.Ino(maxIno)
+	fs.data = make([]*sealedFile, maxIno+1)
+	fs.tags = make(map[uint64][]byte)
+	all := make([]*inode.Inode, 0, len(nodes))
+	for _, n := range img.Nodes {
+		node := nodes[n.Ino]
+		for _, e := range n.Entries {
+			child := nodes[e.Ino]
+			if child == nil {
+				return nil, ErrBadImage
+			}
+			node.Dir = append(node.Dir, &inode.DirEntry{Name: e.Name, Inode: child})
+		}
+		fs.inodeOpts.Adopt(node)
+		if node.Mode&os.ModeSymlink != 0 {
+			target, err := fs.sealTarget(n.Ino, n.Target)
+			if err != nil {
+				return nil, err
+			}
+			fs.symlinks[n.Ino] = target
+		}
+		if len(n.Xattrs) != 0 {
+			fs.xattrs[n.Ino] = n.Xattrs
+		}
+		for k, v := range n.Tags {
+			fs.setTag(n.Ino, k, v)
+		}
+
+		sf := &sealedFile{ino: n.Ino}
+		fs.data[n.Ino] = sf
+		if len(n.Data) != 0 {
+			if err := fs.seal(sf, n.Data); err != nil {
+				return nil, err
+			}
+		}
+		all = append(all, node)
+	}
+	if err := fs.authenticate(all...); err != nil {
+		return nil, err
+	}
+
+	return fs, nil
+}
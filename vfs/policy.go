@@ -0,0 +1,319 @@
+package vfs
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/inode"
+)
+
+// Credentials identify who an operation is performed for.
+type Credentials struct {
+	Uid, Gid int
+
+	// Name identifies the caller to policies, for example the module or
+	// remote user the FileSystem was handed to.
+	Name string
+
+	// Roles are the roles the caller has, for policies that grant access
+	// by role.
+	Roles []string
+}
+
+// A Policy decides whether op may be performed on the absolute path for
+// cred. Returning a non-nil error denies it, and the error is returned
+// wrapped in an *os.PathError or, for renames and links, *os.LinkError.
+// Opening a file for writing, creating or truncating it is evaluated as
+// absfs.OpOpen and then as absfs.OpWrite. Operations that follow symbolic
+// links are evaluated on both the path they're given and the path it
+// resolves to, and creating a symbolic link is evaluated on both the link
+// and the path its target resolves to.
+type Policy func(op absfs.Op, path string, cred Credentials) error
+
+// SetPolicy sets the policy evaluated before every operation performed
+// through FileSystems returned by As, including reads, writes and the other
+// operations on the Files they open. A nil fn allows everything. The policy
+// doesn't apply to fs itself, which stays fully accessible to its owner.
+func (fs *FileSystem) SetPolicy(fn Policy) {
+	if fn == nil {
+		fs.policy.Store(nil)
+		return
+	}
+	fs.policy.Store(&fn)
+}
+
+// As returns a view of fs whose operations are performed for cred, and are
+// allowed or denied by the policy set with SetPolicy. Each view has its own
+// working directory, which starts at "/" and is changed by its Chdir
+// without affecting fs or other views.
+func (fs *FileSystem) As(cred Credentials) absfs.FileSystem {
+	h := &policyHooks{fs: fs, cred: cred}
+	return &view{fs: fs, hooks: h, chain: absfs.Chain(fs, h), cwd: "/"}
+}
+
+// openWriteFlags are the flags that open a file for changing it.
+const openWriteFlags = absfs.O_WRONLY | absfs.O_RDWR | absfs.O_APPEND | absfs.O_CREATE | absfs.O_TRUNC
+
+// policyHooks evaluates the policy of fs for cred before each operation.
+type policyHooks struct {
+	fs   *FileSystem
+	cred Credentials
+}
+
+func (h *policyHooks) Before(c *absfs.Call) error {
+	p := h.fs.policy.Load()
+	if p == nil {
+		return nil
+	}
+
+	path, err := h.fs.Abs(c.Path)
+	if err != nil {
+		return &os.PathError{Op: string(c.Op), Path: c.Path, Err: err}
+	}
+	switch c.Op {
+	case absfs.OpRename, absfs.OpLink:
+		newPath, err := h.fs.Abs(c.NewPath)
+		if err == nil {
+			if err = (*p)(c.Op, path, h.cred); err == nil {
+				err = (*p)(c.Op, newPath, h.cred)
+			}
+		}
+		if err != nil {
+			return &os.LinkError{Op: string(c.Op), Old: c.Path, New: c.NewPath, Err: err}
+		}
+		return nil
+	case absfs.OpSymlink:
+		// a link to a path that is denied would hand it out to everyone
+		// who may access the link
+		newPath, err := h.fs.Abs(c.NewPath)
+		if err == nil {
+			if err = (*p)(c.Op, newPath, h.cred); err == nil {
+				var target string
+				if target, err = h.fs.resolvePath(inode.Abs(Dir(newPath), c.Path)); err == nil {
+					err = (*p)(c.Op, target, h.cred)
+				}
+			}
+		}
+		if err != nil {
+			return &os.LinkError{Op: string(c.Op), Old: c.Path, New: c.NewPath, Err: err}
+		}
+		return nil
+	}
+	err = (*p)(c.Op, path, h.cred)
+	if err == nil && followsLinks[c.Op] {
+		err = h.checkResolved(*p, c.Op, path)
+	}
+	if err == nil && c.Op == absfs.OpOpen && c.Flag&openWriteFlags != 0 {
+		err = (*p)(absfs.OpWrite, path, h.cred)
+		if err == nil {
+			err = h.checkResolved(*p, absfs.OpWrite, path)
+		}
+	}
+	if err != nil {
+		return &os.PathError{Op: string(c.Op), Path: c.Path, Err: err}
+	}
+
+	return nil
+}
+
+func (h *policyHooks) After(c *absfs.Call, err error) error {
+	return err
+}
+
+// followsLinks are the operations that follow symbolic links.
+var followsLinks = map[absfs.Op]bool{
+	absfs.OpOpen:     true,
+	absfs.OpMkdirAll: true,
+	absfs.OpStat:     true,
+	absfs.OpChmod:    true,
+	absfs.OpChtimes:  true,
+	absfs.OpChown:    true,
+	absfs.OpChdir:    true,
+	absfs.OpTruncate: true,
+
+	absfs.OpGetxattr:    true,
+	absfs.OpSetxattr:    true,
+	absfs.OpListxattr:   true,
+	absfs.OpRemovexattr: true,
+}
+
+// checkResolved evaluates p for op on the path the absolute path resolves
+// to, if it goes through symbolic links.
+func (h *policyHooks) checkResolved(p Policy, op absfs.Op, path string) error {
+	resolved, err := h.fs.resolvePath(path)
+	if err != nil {
+		return err
+	}
+	if resolved == Clean(path) {
+		return nil
+	}
+
+	return p(op, resolved, h.cred)
+}
+
+// view is a FileSystem returned by As. It makes the paths it's given
+// absolute against its own working directory before passing them through
+// the policy, so views can't change what relative paths of fs or other
+// views refer to.
+type view struct {
+	fs    *FileSystem
+	hooks *policyHooks
+	chain absfs.FileSystem
+
+	mtx sync.RWMutex
+	cwd string
+}
+
+func (v *view) abs(name string) string {
+	if IsAbs(name) {
+		return Clean(name)
+	}
+	v.mtx.RLock()
+	defer v.mtx.RUnlock()
+
+	return Join(v.cwd, name)
+}
+
+func (v *view) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
+	return v.chain.OpenFile(v.abs(name), flag, perm)
+}
+
+func (v *view) Open(name string) (absfs.File, error) {
+	return v.chain.Open(v.abs(name))
+}
+
+func (v *view) Create(name string) (absfs.File, error) {
+	return v.chain.Create(v.abs(name))
+}
+
+func (v *view) Mkdir(name string, perm os.FileMode) error {
+	return v.chain.Mkdir(v.abs(name), perm)
+}
+
+func (v *view) MkdirAll(name string, perm os.FileMode) error {
+	return v.chain.MkdirAll(v.abs(name), perm)
+}
+
+func (v *view) Remove(name string) error {
+	return v.chain.Remove(v.abs(name))
+}
+
+func (v *view) RemoveAll(name string) error {
+	return v.chain.RemoveAll(v.abs(name))
+}
+
+func (v *view) Rename(oldpath, newpath string) error {
+	return v.chain.Rename(v.abs(oldpath), v.abs(newpath))
+}
+
+func (v *view) Stat(name string) (os.FileInfo, error) {
+	return v.chain.Stat(v.abs(name))
+}
+
+func (v *view) Lstat(name string) (os.FileInfo, error) {
+	return v.chain.Lstat(v.abs(name))
+}
+
+func (v *view) Chmod(name string, mode os.FileMode) error {
+	return v.chain.Chmod(v.abs(name), mode)
+}
+
+func (v *view) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return v.chain.Chtimes(v.abs(name), atime, mtime)
+}
+
+func (v *view) Chown(name string, uid, gid int) error {
+	return v.chain.Chown(v.abs(name), uid, gid)
+}
+
+func (v *view) Lchown(name string, uid, gid int) error {
+	return v.chain.Lchown(v.abs(name), uid, gid)
+}
+
+func (v *view) Separator() uint8 {
+	return v.chain.Separator()
+}
+
+func (v *view) ListSeparator() uint8 {
+	return v.chain.ListSeparator()
+}
+
+// Chdir changes the working directory of v, after evaluating the policy
+// for absfs.OpChdir on dir.
+func (v *view) Chdir(dir string) error {
+	abs := v.abs(dir)
+	if err := v.hooks.Before(&absfs.Call{Op: absfs.OpChdir, Path: abs, Offset: -1}); err != nil {
+		return err
+	}
+	fi, err := v.fs.Stat(abs)
+	if err != nil {
+		if pe, ok := err.(*os.PathError); ok {
+			err = pe.Err
+		}
+		return &os.PathError{Op: "chdir", Path: dir, Err: err}
+	}
+	if !fi.IsDir() {
+		return &os.PathError{Op: "chdir", Path: dir, Err: absfs.ErrNotDir}
+	}
+
+	v.mtx.Lock()
+	defer v.mtx.Unlock()
+
+	v.cwd = abs
+	return nil
+}
+
+func (v *view) Getwd() (string, error) {
+	v.mtx.RLock()
+	defer v.mtx.RUnlock()
+
+	return v.cwd, nil
+}
+
+func (v *view) TempDir() string {
+	return v.chain.TempDir()
+}
+
+func (v *view) Truncate(name string, size int64) error {
+	return v.chain.Truncate(v.abs(name), size)
+}
+
+func (v *view) Readlink(name string) (string, error) {
+	return v.chain.Readlink(v.abs(name))
+}
+
+// Symlink creates newname as a symbolic link to oldname, which, if it's
+// relative, stays relative to the directory of the link.
+func (v *view) Symlink(oldname, newname string) error {
+	return v.chain.Symlink(oldname, v.abs(newname))
+}
+
+func (v *view) Link(oldname, newname string) error {
+	return v.chain.(absfs.Linker).Link(v.abs(oldname), v.abs(newname))
+}
+
+func (v *view) Sync() error {
+	return v.chain.(absfs.Syncer).Sync()
+}
+
+func (v *view) Getxattr(name, attr string) ([]byte, error) {
+	return v.chain.(absfs.Xattrer).Getxattr(v.abs(name), attr)
+}
+
+func (v *view) Setxattr(name, attr string, value []byte) error {
+	return v.chain.(absfs.Xattrer).Setxattr(v.abs(name), attr, value)
+}
+
+func (v *view) Listxattr(name string) ([]string, error) {
+	return v.chain.(absfs.Xattrer).Listxattr(v.abs(name))
+}
+
+func (v *view) Removexattr(name, attr string) error {
+	return v.chain.(absfs.Xattrer).Removexattr(v.abs(name), attr)
+}
+
+func (v *view) Capabilities() absfs.Capability {
+	return absfs.Capabilities(v.chain)
+}
@@ -0,0 +1,213 @@
+package vfs
+
+import (
+	"errors"
+	"math"
+	"os"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoMemoryLimit is returned by SetMemoryPressure when no limit was given
+// and the process has none.
+var ErrNoMemoryLimit = errors.New("no memory limit to monitor")
+
+// MemoryPressure configures how a FileSystem reacts to the memory the
+// process uses, see SetMemoryPressure.
+type MemoryPressure struct {
+	// Limit is how many bytes of memory the process may use. If it isn't
+	// positive, the memory limit of the cgroup the process runs in is
+	// used, or failing that the limit set with debug.SetMemoryLimit.
+	Limit int64
+
+	// Threshold is the fraction of Limit above which the process is under
+	// pressure. It defaults to 0.8.
+	Threshold float64
+
+	// Interval is how often the memory used is checked. It defaults to a
+	// second.
+	Interval time.Duration
+
+	// Dir is where the cache directory spilled files are moved to is
+	// created, like the dir of SetMemoryBudget.
+	Dir string
+
+	// OnPressure is called after every check that found the process under
+	// pressure, once cold files were spilled.
+	OnPressure func(PressureEvent)
+}
+
+// A PressureEvent describes a check that found the process under memory
+// pressure.
+type PressureEvent struct {
+	// InUse is how many bytes of memory the process used, and Limit the
+	// limit it was checked against.
+	InUse int64
+	Limit int64
+
+	// Spilled is how many bytes of sealed file contents were moved out of
+	// memory, and Resident how many are still kept in memory.
+	Spilled  int64
+	Resident int64
+}
+
+// pressureMonitor is a running SetMemoryPressure monitor.
+type pressureMonitor struct {
+	stop chan struct{}
+	done chan struct{}
+}
+
+// SetMemoryPressure checks how much memory the process uses every
+// p.Interval, and when it's above p.Threshold of p.Limit, spills the least
+// recently used sealed file contents to a cache directory until enough
+// memory is released to get below it again, before the process runs out of
+// memory. Spilled files are paged back in when they're next accessed, as
+// with SetMemoryBudget, and pinned files stay in memory. p.OnPressure is
+// called after spilling.
+//
+// Plain FileSystems never spill, so they need p.OnPressure. A nil p stops
+// monitoring the memory used.
+func (fs *FileSystem) SetMemoryPressure(p *MemoryPressure) error {
+	fs.spillMtx.Lock()
+	mon := fs.pressure
+	fs.pressure = nil
+	fs.spillMtx.Unlock()
+	if mon != nil {
+		close(mon.stop)
+		<-mon.done
+	}
+	if p == nil {
+		return nil
+	}
+
+	if fs.plain && p.OnPressure == nil {
+		return &os.PathError{Op: "spill", Path: p.Dir, Err: ErrPlainSpill}
+	}
+	opts := *p
+	if opts.Limit <= 0 {
+		opts.Limit = memoryLimit()
+		if opts.Limit <= 0 {
+			return ErrNoMemoryLimit
+		}
+	}
+	if opts.Threshold <= 0 || opts.Threshold > 1 {
+		opts.Threshold = 0.8
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+
+	mon = &pressureMonitor{stop: make(chan struct{}), done: make(chan struct{})}
+	fs.spillMtx.Lock()
+	fs.pressure = mon
+	fs.spillMtx.Unlock()
+	go fs.monitorMemory(mon, &opts)
+
+	return nil
+}
+
+func (fs *FileSystem) monitorMemory(mon *pressureMonitor, p *MemoryPressure) {
+	defer close(mon.done)
+
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	high := int64(float64(p.Limit) * p.Threshold)
+	for {
+		select {
+		case <-mon.stop:
+			return
+		case <-ticker.C:
+		}
+
+		inUse := memoryInUse()
+		if inUse < high {
+			continue
+		}
+		var spilled int64
+		if !fs.plain {
+			spilled = fs.relieve(inUse-high, p.Dir)
+			if spilled != 0 {
+				debug.FreeOSMemory()
+			}
+		}
+		if p.OnPressure != nil {
+			p.OnPressure(PressureEvent{
+				InUse:    inUse,
+				Limit:    p.Limit,
+				Spilled:  spilled,
+				Resident: fs.MemoryUsage(),
+			})
+		}
+	}
+}
+
+// relieve spills the least recently used files that aren't pinned until n
+// bytes were spilled, creating the cache directory in dir if there is none,
+// and returns how many bytes were spilled.
+func (fs *FileSystem) relieve(n int64, dir string) int64 {
+	fs.spillMtx.Lock()
+	defer fs.spillMtx.Unlock()
+
+	if fs.lru == nil || fs.lru.Len() == 0 {
+		return 0
+	}
+	if err := fs.makeSpillDir(dir); err != nil {
+		return 0
+	}
+
+	var spilled int64
+	e := fs.lru.Back()
+	for spilled < n && e != nil {
+		prev := e.Prev()
+		sf := e.Value.(*sealedFile)
+		if !sf.pinned {
+			size := int64(len(sf.ciphertext))
+			if err := fs.spill(sf); err != nil {
+				break
+			}
+			spilled += size
+		}
+		e = prev
+	}
+
+	return spilled
+}
+
+// memoryInUse returns how many bytes of memory the Go runtime holds from
+// the operating system.
+func memoryInUse() int64 {
+	samples := []metrics.Sample{
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	metrics.Read(samples)
+
+	return int64(samples[0].Value.Uint64() - samples[1].Value.Uint64())
+}
+
+// memoryLimit returns the memory limit of the cgroup the process runs in,
+// or the limit set with debug.SetMemoryLimit, or 0 if there is neither.
+func memoryLimit() int64 {
+	for _, name := range []string{
+		"/sys/fs/cgroup/memory.max",
+		"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+	} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		// cgroup v1 reports no limit as a huge number, v2 as max
+		limit, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err == nil && limit > 0 && limit < 1<<60 {
+			return limit
+		}
+	}
+	if limit := debug.SetMemoryLimit(-1); limit != math.MaxInt64 {
+		return limit
+	}
+
+	return 0
+}
@@ -0,0 +1,79 @@
+package vfs
+
+import (
+	"os"
+	"path"
+	"sort"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/inode"
+)
+
+// ReadDirMatch returns the entries of the directory dirname whose names
+// match pattern, using the syntax of path.Match. Entries are filtered while
+// the directory is locked, so only the matches are ever copied out of it.
+func (fs *FileSystem) ReadDirMatch(dirname, pattern string) ([]os.FileInfo, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, &os.PathError{Op: "readdir", Path: dirname, Err: err}
+	}
+
+	node := fs.root
+	if name := path.Clean(inode.Abs(fs.cwd, dirname)); name != "/" {
+		var err error
+		node, err = fs.fileStat("/", name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !node.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: dirname, Err: absfs.ErrNotDir}
+	}
+
+	node.RLock()
+	defer node.RUnlock()
+
+	var infos []os.FileInfo
+	for _, entry := range node.Dir {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+		// the pattern was checked above, so matching can't fail
+		if ok, _ := path.Match(pattern, entry.Name); ok {
+			infos = append(infos, &FileInfo{entry.Name, entry.Inode})
+		}
+	}
+
+	return infos, nil
+}
+
+// ReadDirPlus returns the entries of the directory dirname sorted by name,
+// like ioutil.ReadDir, but reads them in one pass while the directory is
+// locked instead of opening it. Each entry is described as Lstat would
+// describe it, so callers walking a tree don't need to stat every entry.
+func (fs *FileSystem) ReadDirPlus(dirname string) ([]os.FileInfo, error) {
+	node := fs.root
+	if name := path.Clean(inode.Abs(fs.cwd, dirname)); name != "/" {
+		var err error
+		node, err = fs.fileStat("/", name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !node.IsDir() {
+		return nil, &os.PathError{Op: "readdir", Path: dirname, Err: absfs.ErrNotDir}
+	}
+
+	node.RLock()
+	infos := make([]os.FileInfo, 0, len(node.Dir))
+	for _, entry := range node.Dir {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+		infos = append(infos, &FileInfo{entry.Name, entry.Inode})
+	}
+	node.RUnlock()
+
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	return infos, nil
+}
@@ -0,0 +1,129 @@
+package vfs
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync/atomic"
+
+	"github.com/capnspacehook/pandorasbox/inode"
+)
+
+// RemoveAllContext is like RemoveAll, but removes the tree one entry at a
+// time, deepest first, and wipes the contents of files from memory as
+// their last link is removed. Keys are dropped too, so contents that were
+// spilled or tiered to disk can't be decrypted. Files that are still open
+// read as empty afterwards. progress, if not nil, is called with the number
+// of entries removed so far after each one. Once ctx is done, removing
+// stops and the context's error is returned; entries that weren't removed
+// yet are left in place.
+func (fs *FileSystem) RemoveAllContext(ctx context.Context, name string, progress func(removed int)) error {
+	wd := fs.root
+	abs := name
+	if !IsAbs(abs) {
+		abs = Join(fs.cwd, abs)
+		wd = fs.dir
+	}
+	child, err := wd.Resolve(name)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+
+	parent := fs.root
+	dir, filename := Split(abs)
+	dir = Clean(dir)
+	if dir != "/" {
+		parent, err = fs.root.Resolve(strings.TrimLeft(dir, "/"))
+		if err != nil {
+			return &os.PathError{Op: "remove", Path: dir, Err: err}
+		}
+	}
+
+	r := &remover{ctx: ctx, fs: fs, progress: progress}
+	if err := r.removeTree(child, abs); err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	if err := r.remove(parent, filename, child, abs); err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+
+	return nil
+}
+
+type remover struct {
+	ctx      context.Context
+	fs       *FileSystem
+	progress func(removed int)
+	removed  int
+}
+
+// removeTree removes the entries of the directory dir, deepest first.
+func (r *remover) removeTree(dir *inode.Inode, name string) error {
+	if !dir.IsDir() || dir.Mode&os.ModeSymlink != 0 {
+		return nil
+	}
+
+	dir.RLock()
+	entries := make(inode.Directory, len(dir.Dir))
+	copy(entries, dir.Dir)
+	dir.RUnlock()
+
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+		p := Join(name, entry.Name)
+		if err := r.removeTree(entry.Inode, p); err != nil {
+			return err
+		}
+		if err := r.remove(dir, entry.Name, entry.Inode, p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remove unlinks the entry filename of parent, which must be empty if it's
+// a directory, and wipes the contents of node if that was its last link.
+func (r *remover) remove(parent *inode.Inode, filename string, node *inode.Inode, name string) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+
+	if node.IsDir() && node.Mode&os.ModeSymlink == 0 {
+		node.UnlinkAll()
+	}
+	err := parent.Unlink(filename)
+	if err1 := r.fs.authenticate(parent); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	r.fs.notify(name, Remove)
+
+	node.RLock()
+	last := node.Nlink == 0 && node.Mode.IsRegular()
+	node.RUnlock()
+	if last {
+		r.fs.mtx.Lock()
+		var sf *sealedFile
+		if int(node.Ino) < len(r.fs.data) {
+			sf = r.fs.data[node.Ino]
+			r.fs.data[node.Ino] = nil
+		}
+		r.fs.mtx.Unlock()
+		atomic.StoreInt64(&node.Size, 0)
+		if sf != nil {
+			r.fs.wipe(sf)
+		}
+	}
+
+	r.removed++
+	if r.progress != nil {
+		r.progress(r.removed)
+	}
+
+	return nil
+}
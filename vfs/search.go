@@ -0,0 +1,198 @@
+package vfs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/capnspacehook/pandorasbox/inode"
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+// EnableIndex builds an index of the words in the contents of every file,
+// which Search uses to find files without decrypting them. The index is
+// kept up to date as files are written. Words are stored as MACs under a
+// key held in an Enclave, so the index doesn't reveal the contents of files
+// when that key isn't available.
+func (fs *FileSystem) EnableIndex() error {
+	fs.idxMtx.Lock()
+	if fs.idxKey != nil {
+		fs.idxMtx.Unlock()
+		return nil
+	}
+	fs.idxKey = seal.NewKey()
+	fs.index = make(map[string]map[uint64]struct{})
+	fs.indexed = make(map[uint64][]string)
+	fs.idxMtx.Unlock()
+
+	return fs.eachFile(func(sf *sealedFile) error {
+		plaintext := make([]byte, fs.sealedSize(sf))
+		err := fs.unseal(sf, plaintext)
+		if err == nil {
+			err = fs.indexFile(sf.ino, plaintext)
+		}
+		seal.Wipe(plaintext)
+		return err
+	})
+}
+
+// Search returns the paths of the files that contain every word in query,
+// sorted. Words are compared case insensitively. EnableIndex must be called
+// first, otherwise nothing is found.
+func (fs *FileSystem) Search(query string) ([]string, error) {
+	words := indexWords([]byte(query))
+	if len(words) == 0 {
+		return nil, nil
+	}
+
+	fs.idxMtx.RLock()
+	if fs.idxKey == nil {
+		fs.idxMtx.RUnlock()
+		return nil, nil
+	}
+	terms, err := fs.indexTerms(words)
+	if err != nil {
+		fs.idxMtx.RUnlock()
+		return nil, err
+	}
+	var matches map[uint64]struct{}
+	for _, term := range terms {
+		files := fs.index[term]
+		if matches == nil {
+			matches = make(map[uint64]struct{}, len(files))
+			for ino := range files {
+				matches[ino] = struct{}{}
+			}
+			continue
+		}
+		for ino := range matches {
+			if _, ok := files[ino]; !ok {
+				delete(matches, ino)
+			}
+		}
+	}
+	fs.idxMtx.RUnlock()
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	var paths []string
+	fs.findPaths(fs.root, "/", matches, &paths)
+	sort.Strings(paths)
+
+	return paths, nil
+}
+
+// findPaths appends the paths of the files and directories under dir whose
+// inode numbers are in inos to paths. Symbolic links aren't followed.
+func (fs *FileSystem) findPaths(dir *inode.Inode, name string, inos map[uint64]struct{}, paths *[]string) {
+	dir.RLock()
+	entries := make(inode.Directory, len(dir.Dir))
+	copy(entries, dir.Dir)
+	dir.RUnlock()
+
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+		if entry.Inode.Mode&os.ModeSymlink != 0 {
+			continue
+		}
+		p := Join(name, entry.Name)
+		if _, ok := inos[entry.Inode.Ino]; ok {
+			*paths = append(*paths, p)
+		}
+		if entry.Inode.IsDir() {
+			fs.findPaths(entry.Inode, p, inos, paths)
+		}
+	}
+}
+
+// indexFile replaces the words indexed for the file with inode number ino
+// with the words in plaintext.
+func (fs *FileSystem) indexFile(ino uint64, plaintext []byte) error {
+	fs.idxMtx.Lock()
+	defer fs.idxMtx.Unlock()
+
+	if fs.idxKey == nil {
+		return nil
+	}
+	for _, term := range fs.indexed[ino] {
+		delete(fs.index[term], ino)
+		if len(fs.index[term]) == 0 {
+			delete(fs.index, term)
+		}
+	}
+	delete(fs.indexed, ino)
+
+	words := indexWords(plaintext)
+	if len(words) == 0 {
+		return nil
+	}
+	terms, err := fs.indexTerms(words)
+	if err != nil {
+		return err
+	}
+	for _, term := range terms {
+		files := fs.index[term]
+		if files == nil {
+			files = make(map[uint64]struct{})
+			fs.index[term] = files
+		}
+		files[ino] = struct{}{}
+	}
+	fs.indexed[ino] = terms
+
+	return nil
+}
+
+// indexClone indexes the file with inode number dst under the words of the
+// file with inode number src, which it's a clone of.
+func (fs *FileSystem) indexClone(src, dst uint64) {
+	fs.idxMtx.Lock()
+	defer fs.idxMtx.Unlock()
+
+	if fs.idxKey == nil || len(fs.indexed[src]) == 0 {
+		return
+	}
+	for _, term := range fs.indexed[src] {
+		fs.index[term][dst] = struct{}{}
+	}
+	fs.indexed[dst] = append([]string(nil), fs.indexed[src]...)
+}
+
+// indexTerms returns the MACs words are indexed under. fs.idxMtx must be
+// held.
+func (fs *FileSystem) indexTerms(words map[string]struct{}) ([]string, error) {
+	k, err := fs.idxKey.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer k.Destroy()
+
+	mac := hmac.New(sha256.New, k.Bytes())
+	terms := make([]string, 0, len(words))
+	for word := range words {
+		mac.Reset()
+		mac.Write([]byte(word))
+		terms = append(terms, string(mac.Sum(nil)))
+	}
+
+	return terms, nil
+}
+
+// indexWords returns the distinct lower case words in b.
+func indexWords(b []byte) map[string]struct{} {
+	words := make(map[string]struct{})
+	fields := strings.FieldsFunc(string(b), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range fields {
+		words[strings.ToLower(word)] = struct{}{}
+	}
+
+	return words
+}
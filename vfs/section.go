@@ -0,0 +1,167 @@
+package vfs
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/awnumar/memguard"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// sectionReader reads a section of a file. Files sealed in blocks are only
+// decrypted a block at a time as the section is read; other files are
+// decrypted in full on the first read, and only the section is kept, in
+// locked memory, until the reader is closed.
+type sectionReader struct {
+	f *File
+
+	base, limit int64
+	off         int64
+
+	mtx sync.Mutex
+	buf *memguard.LockedBuffer
+}
+
+// NewSectionReader opens the file name for reading the n bytes starting at
+// off. The reader also implements io.ReaderAt, with offsets relative to
+// off, and must be closed to wipe what it has decrypted.
+func (fs *FileSystem) NewSectionReader(name string, off, n int64) (io.ReadSeekCloser, error) {
+	if off < 0 || n < 0 {
+		return nil, &os.PathError{Op: "section", Path: name, Err: os.ErrInvalid}
+	}
+	file, err := fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	f := file.(*File)
+	if f.node.IsDir() {
+		f.Close()
+		return nil, &os.PathError{Op: "section", Path: name, Err: absfs.ErrIsDir}
+	}
+
+	limit := off + n
+	if limit < off {
+		// off + n overflowed
+		limit = 1<<63 - 1
+	}
+
+	return &sectionReader{f: f, base: off, limit: limit}, nil
+}
+
+func (s *sectionReader) Read(p []byte) (int, error) {
+	off := atomic.LoadInt64(&s.off)
+	n, err := s.ReadAt(p, off)
+	atomic.AddInt64(&s.off, int64(n))
+
+	return n, err
+}
+
+func (s *sectionReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: s.f.name, Err: errors.New("negative offset")}
+	}
+	if s.f.node == nil {
+		return 0, &os.PathError{Op: "read", Path: s.f.name, Err: os.ErrClosed}
+	}
+	off += s.base
+	if off >= s.limit {
+		return 0, io.EOF
+	}
+	short := false
+	if left := s.limit - off; int64(len(p)) > left {
+		p, short = p[:left], true
+	}
+
+	n, err := s.readAt(p, off)
+	if err == nil && short {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// readAt reads the contents of the file at off into p.
+func (s *sectionReader) readAt(p []byte, off int64) (int, error) {
+	f := s.f
+	f.data.count(accessRead)
+	f.mtx.RLock()
+	defer f.mtx.RUnlock()
+
+	size := atomic.LoadInt64(&f.node.Size)
+	if off >= size {
+		return 0, io.EOF
+	}
+	if f.fs.plain {
+		n := copy(p, f.data.ciphertext[off:])
+		if n < len(p) {
+			return n, io.EOF
+		}
+		return n, nil
+	}
+	if n, tiered, err := f.fs.readTier(f.data, p, off); tiered {
+		if err == nil && n < len(p) {
+			err = io.EOF
+		}
+		return n, err
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.buf == nil {
+		plaintext := memguard.NewBuffer(int(size))
+		if err := f.fs.unseal(f.data, plaintext.Bytes()); err != nil {
+			plaintext.Destroy()
+			return 0, err
+		}
+		end := min(s.limit, size)
+		s.buf = memguard.NewBufferFromBytes(plaintext.Bytes()[s.base:end])
+		plaintext.Destroy()
+	}
+	n := 0
+	if rel := off - s.base; rel < int64(s.buf.Size()) {
+		n = copy(p, s.buf.Bytes()[rel:])
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
+
+func (s *sectionReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += atomic.LoadInt64(&s.off)
+	case io.SeekEnd:
+		// the end of the section, or of the file if it's shorter
+		if s.f.node == nil {
+			return 0, &os.PathError{Op: "seek", Path: s.f.name, Err: os.ErrClosed}
+		}
+		offset += max(min(s.limit, atomic.LoadInt64(&s.f.node.Size))-s.base, 0)
+	default:
+		return 0, &os.PathError{Op: "seek", Path: s.f.name, Err: os.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: s.f.name, Err: errors.New("negative offset")}
+	}
+	atomic.StoreInt64(&s.off, offset)
+
+	return offset, nil
+}
+
+// Close wipes what has been decrypted and closes the file.
+func (s *sectionReader) Close() error {
+	s.mtx.Lock()
+	if s.buf != nil {
+		s.buf.Destroy()
+		s.buf = nil
+	}
+	s.mtx.Unlock()
+
+	return s.f.Close()
+}
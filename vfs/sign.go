@@ -0,0 +1,56 @@
+package vfs
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"io"
+
+	"github.com/awnumar/memguard"
+)
+
+// signedMagic starts every image written by SaveSigned, followed by the
+// signature of the image that follows it.
+var signedMagic = []byte("PBXSIG")
+
+// ErrBadSignature is returned by LoadSigned for images that weren't signed
+// by the expected key, or were altered since they were signed.
+var ErrBadSignature = errors.New("signature verification failed")
+
+// SaveSigned writes an image of fs to w like Save, signed with signKey, so
+// LoadSigned can prove it wasn't altered since. Load can't read signed
+// images.
+func (fs *FileSystem) SaveSigned(w io.Writer, key *memguard.Enclave, signKey ed25519.PrivateKey) error {
+	var buf bytes.Buffer
+	if err := fs.Save(&buf, key); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(signedMagic); err != nil {
+		return err
+	}
+	if _, err := w.Write(ed25519.Sign(signKey, buf.Bytes())); err != nil {
+		return err
+	}
+	_, err := buf.WriteTo(w)
+
+	return err
+}
+
+// LoadSigned returns the FileSystem saved to r by SaveSigned under key. The
+// signature of the image is checked against pub before it's decrypted.
+func LoadSigned(r io.Reader, key *memguard.Enclave, pub ed25519.PublicKey) (*FileSystem, error) {
+	header := make([]byte, len(signedMagic)+ed25519.SignatureSize)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header[:len(signedMagic)], signedMagic) {
+		return nil, ErrBadImage
+	}
+	img, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(pub) != ed25519.PublicKeySize || !ed25519.Verify(pub, img, header[len(signedMagic):]) {
+		return nil, ErrBadSignature
+	}
+
+	return Load(bytes.NewReader(img), key)
+}
@@ -0,0 +1,280 @@
+package vfs
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/awnumar/memguard"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/inode"
+)
+
+// ErrNoSnapshot is returned for snapshot IDs that don't exist.
+var ErrNoSnapshot = errors.New("no such snapshot")
+
+// A snapshot is a copy of the tree of a FileSystem. File contents are
+// shared with the live tree, as writes replace the sealed contents of a
+// file instead of changing them.
+type snapshot struct {
+	root     *inode.Inode
+	symlinks map[uint64][]byte
+	linkKey  *memguard.Enclave
+	tags     map[uint64]map[string]string
+	xattrs   map[uint64]map[string][]byte
+	data     map[uint64]*sealedFile
+}
+
+// Snapshot records the current state of fs and returns an ID that OpenAt
+// can read it with. Only the metadata of the tree is copied; file contents
+// are shared until the live files are written to. Files that are written
+// while the snapshot is taken may be recorded before or after the write.
+func (fs *FileSystem) Snapshot() (uint64, error) {
+	fs.mtx.RLock()
+	copies := make(map[*inode.Inode]*inode.Inode)
+	snap := &snapshot{
+		root:     copyTree(fs.root, copies),
+		symlinks: make(map[uint64][]byte),
+		linkKey:  fs.linkKey,
+		tags:     make(map[uint64]map[string]string),
+		xattrs:   make(map[uint64]map[string][]byte),
+		data:     make(map[uint64]*sealedFile),
+	}
+	for _, node := range copies {
+		fs.inodeOpts.Adopt(node)
+		switch {
+		case node.Mode&os.ModeSymlink != 0:
+			snap.symlinks[node.Ino] = fs.symlinks[node.Ino]
+		case node.Mode.IsRegular() && int(node.Ino) < len(fs.data) && fs.data[node.Ino] != nil:
+			snap.data[node.Ino] = fs.freeze(fs.data[node.Ino])
+		}
+		if attrs := fs.xattrs[node.Ino]; len(attrs) != 0 {
+			snap.xattrs[node.Ino] = make(map[string][]byte, len(attrs))
+			for attr, value := range attrs {
+				snap.xattrs[node.Ino][attr] = value
+			}
+		}
+		if tags := fs.fileTags[node.Ino]; len(tags) != 0 {
+			snap.tags[node.Ino] = make(map[string]string, len(tags))
+			for key, value := range tags {
+				snap.tags[node.Ino][key] = value
+			}
+		}
+	}
+	fs.mtx.RUnlock()
+
+	fs.snapMtx.Lock()
+	defer fs.snapMtx.Unlock()
+
+	if fs.snaps == nil {
+		fs.snaps = make(map[uint64]*snapshot)
+	}
+	fs.snapID++
+	fs.snaps[fs.snapID] = snap
+
+	return fs.snapID, nil
+}
+
+// copyTree returns a copy of the metadata of the tree rooted at n. Inodes
+// that are linked more than once are copied once, using copies.
+func copyTree(n *inode.Inode, copies map[*inode.Inode]*inode.Inode) *inode.Inode {
+	if c, ok := copies[n]; ok {
+		return c
+	}
+
+	n.RLock()
+	c := &inode.Inode{
+		Ino:   n.Ino,
+		Mode:  n.Mode,
+		Nlink: n.Nlink,
+		Size:  n.Size,
+		Ctime: n.Ctime,
+		Atime: n.Atime,
+		Mtime: n.Mtime,
+		Uid:   n.Uid,
+		Gid:   n.Gid,
+	}
+	entries := make(inode.Directory, len(n.Dir))
+	copy(entries, n.Dir)
+	n.RUnlock()
+
+	copies[n] = c
+	if len(entries) != 0 {
+		c.Dir = make(inode.Directory, len(entries))
+		for i, entry := range entries {
+			c.Dir[i] = &inode.DirEntry{Name: entry.Name, Inode: copyTree(entry.Inode, copies)}
+		}
+	}
+
+	return c
+}
+
+// freeze returns a read-only copy of sf that shares its contents. Files
+// on disk that the copy refers to are kept until it's released.
+func (fs *FileSystem) freeze(sf *sealedFile) *sealedFile {
+	fs.spillMtx.Lock()
+	defer fs.spillMtx.Unlock()
+
+	frozen := &sealedFile{
+		ino:        sf.ino,
+		ciphertext: sf.ciphertext,
+		key:        sf.key,
+		spilled:    sf.spilled,
+		size:       sf.size,
+		tiered:     sf.tiered,
+		gen:        sf.gen,
+		tierIno:    sf.tierIno,
+		frozen:     true,
+	}
+	sf.shared = sf.ciphertext != nil
+	for _, name := range []string{sf.spilled, sf.tiered} {
+		if name == "" {
+			continue
+		}
+		if fs.diskRefs == nil {
+			fs.diskRefs = make(map[string]int)
+		}
+		fs.diskRefs[name]++
+	}
+
+	return frozen
+}
+
+// OpenAt opens the named file as it was when the snapshot with the given ID
+// was taken. The file is read-only.
+func (fs *FileSystem) OpenAt(id uint64, name string) (absfs.File, error) {
+	snap, err := fs.snapshot("open", id, name)
+	if err != nil {
+		return nil, err
+	}
+	node, err := snap.resolve(fs.cwd, name)
+	if err != nil {
+		return nil, err
+	}
+
+	data := snap.data[node.Ino]
+	if data == nil {
+		data = &sealedFile{ino: node.Ino, frozen: true}
+	}
+
+	return fs.open(&File{fs: fs, name: name, flags: os.O_RDONLY, node: node, data: data}), nil
+}
+
+// TagsAt returns the tags the named file had when the snapshot with the
+// given ID was taken.
+func (fs *FileSystem) TagsAt(id uint64, name string) (map[string]string, error) {
+	snap, err := fs.snapshot("tags", id, name)
+	if err != nil {
+		return nil, err
+	}
+	node, err := snap.resolve(fs.cwd, name)
+	if err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string]string, len(snap.tags[node.Ino]))
+	for key, value := range snap.tags[node.Ino] {
+		tags[key] = value
+	}
+
+	return tags, nil
+}
+
+// XattrsAt returns the extended attributes the named file had when the
+// snapshot with the given ID was taken.
+func (fs *FileSystem) XattrsAt(id uint64, name string) (map[string][]byte, error) {
+	snap, err := fs.snapshot("getxattr", id, name)
+	if err != nil {
+		return nil, err
+	}
+	node, err := snap.resolve(fs.cwd, name)
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := make(map[string][]byte, len(snap.xattrs[node.Ino]))
+	for attr, value := range snap.xattrs[node.Ino] {
+		attrs[attr] = append([]byte(nil), value...)
+	}
+
+	return attrs, nil
+}
+
+// Snapshots returns the IDs of the snapshots of fs, oldest first.
+func (fs *FileSystem) Snapshots() []uint64 {
+	fs.snapMtx.Lock()
+	defer fs.snapMtx.Unlock()
+
+	ids := make([]uint64, 0, len(fs.snaps))
+	for id := range fs.snaps {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids
+}
+
+// DeleteSnapshot deletes the snapshot with the given ID, releasing the file
+// contents only it refers to. Files opened from it may fail to read
+// afterwards.
+func (fs *FileSystem) DeleteSnapshot(id uint64) error {
+	fs.snapMtx.Lock()
+	snap, ok := fs.snaps[id]
+	delete(fs.snaps, id)
+	fs.snapMtx.Unlock()
+	if !ok {
+		return ErrNoSnapshot
+	}
+
+	fs.spillMtx.Lock()
+	defer fs.spillMtx.Unlock()
+
+	for _, sf := range snap.data {
+		for _, name := range []string{sf.spilled, sf.tiered} {
+			if name != "" {
+				fs.releaseDisk(name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// snapshot returns the snapshot with the given ID.
+func (fs *FileSystem) snapshot(op string, id uint64, name string) (*snapshot, error) {
+	fs.snapMtx.Lock()
+	defer fs.snapMtx.Unlock()
+
+	snap, ok := fs.snaps[id]
+	if !ok {
+		return nil, &os.PathError{Op: op, Path: name, Err: ErrNoSnapshot}
+	}
+
+	return snap, nil
+}
+
+// resolve returns the inode of the named file in the snapshot, following
+// symbolic links like fileStat.
+func (snap *snapshot) resolve(cwd, name string) (*inode.Inode, error) {
+	for hops := 0; ; hops++ {
+		name = inode.Abs(cwd, name)
+		if name == "/" {
+			return snap.root, nil
+		}
+		name = strings.TrimLeft(name, "/")
+		node, err := snap.root.Resolve(name)
+		if err != nil {
+			return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+		}
+
+		if node.Mode&os.ModeSymlink == 0 {
+			return node, nil
+		}
+		if hops == maxSymlinkHops {
+			return nil, &os.PathError{Op: "stat", Path: name, Err: absfs.ErrSymlinkCycle}
+		}
+		cwd, name = Dir(name), openTarget(snap.linkKey, node.Ino, snap.symlinks[node.Ino])
+	}
+}
@@ -0,0 +1,345 @@
+package vfs
+
+import (
+	"container/list"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+// ErrPlainSpill is returned when a memory budget or tiering is set on a
+// plain FileSystem, whose contents would be written to disk unencrypted.
+var ErrPlainSpill = errors.New("plain filesystems cannot spill to disk")
+
+// SetMemoryBudget limits the sealed file contents fs keeps in memory to
+// budget bytes. When the limit is exceeded, the least recently used files
+// are moved to a cache directory created in dir, or the default directory
+// for temporary files if dir is empty, and are paged back in when they're
+// next accessed. Only ciphertext is written to the cache; the keys stay in
+// memory. A budget that isn't positive pages every file back in and removes
+// the cache, apart from the files snapshots still refer to.
+func (fs *FileSystem) SetMemoryBudget(budget int64, dir string) error {
+	if fs.plain {
+		return &os.PathError{Op: "spill", Path: dir, Err: ErrPlainSpill}
+	}
+
+	fs.mtx.RLock()
+	defer fs.mtx.RUnlock()
+	fs.spillMtx.Lock()
+	defer fs.spillMtx.Unlock()
+
+	if budget <= 0 {
+		for _, sf := range fs.data {
+			if sf == nil {
+				continue
+			}
+			if err := fs.pageIn(sf); err != nil {
+				return err
+			}
+		}
+		fs.budget = 0
+		if fs.spillDir == "" {
+			return nil
+		}
+		dir := fs.spillDir
+		fs.spillDir = ""
+		return fs.removeDiskDir(dir)
+	}
+
+	if err := fs.makeSpillDir(dir); err != nil {
+		return err
+	}
+	fs.budget = budget
+	fs.evict(nil)
+
+	return nil
+}
+
+// MemoryUsage returns the number of bytes of sealed file contents fs
+// currently keeps in memory.
+func (fs *FileSystem) MemoryUsage() int64 {
+	fs.spillMtx.Lock()
+	defer fs.spillMtx.Unlock()
+
+	return fs.resident
+}
+
+// stored reports whether sf holds any contents, in memory or on disk.
+func (fs *FileSystem) stored(sf *sealedFile) bool {
+	fs.spillMtx.Lock()
+	defer fs.spillMtx.Unlock()
+
+	return len(sf.ciphertext) != 0 || sf.spilled != "" || sf.tiered != ""
+}
+
+// discard drops the contents of sf.
+func (fs *FileSystem) discard(sf *sealedFile) {
+	defer fs.invalidateView(sf)
+	// unindexing needs no key, so it can't fail
+	_ = fs.indexFile(sf.ino, nil)
+
+	fs.spillMtx.Lock()
+	defer fs.spillMtx.Unlock()
+
+	fs.drop(sf)
+	sf.ciphertext = nil
+	sf.key = nil
+}
+
+// wipe is discard, but also overwrites the ciphertext of sf in memory,
+// unless a snapshot shares it.
+func (fs *FileSystem) wipe(sf *sealedFile) {
+	defer fs.invalidateView(sf)
+	_ = fs.indexFile(sf.ino, nil)
+
+	fs.spillMtx.Lock()
+	defer fs.spillMtx.Unlock()
+
+	if !sf.shared {
+		fs.sealer.Wipe(sf.ciphertext)
+	}
+	fs.drop(sf)
+	sf.ciphertext = nil
+	sf.key = nil
+}
+
+// drop removes sf from the spill accounting and deletes its cache and tier
+// files. fs.spillMtx must be held.
+func (fs *FileSystem) drop(sf *sealedFile) {
+	if sf.elem != nil {
+		fs.lru.Remove(sf.elem)
+		fs.resident -= int64(len(sf.ciphertext))
+		sf.elem = nil
+	}
+	if sf.spilled != "" {
+		fs.forget(sf, sf.spilled)
+		sf.spilled = ""
+	}
+	if sf.tiered != "" {
+		fs.forget(sf, sf.tiered)
+		sf.tiered = ""
+	}
+	sf.borrowed = false
+}
+
+// forget removes the named cache or tier file of sf, or releases it if sf
+// borrowed it. fs.spillMtx must be held.
+func (fs *FileSystem) forget(sf *sealedFile, name string) {
+	if sf.borrowed {
+		fs.releaseDisk(name)
+		return
+	}
+	fs.removeDisk(name)
+}
+
+// removeDisk removes the named cache or tier file, or marks it for removal
+// once the snapshots that refer to it are deleted. fs.spillMtx must be
+// held.
+func (fs *FileSystem) removeDisk(name string) {
+	if fs.diskRefs[name] > 0 {
+		if fs.deadDisk == nil {
+			fs.deadDisk = make(map[string]bool)
+		}
+		fs.deadDisk[name] = true
+		return
+	}
+	fs.removeFile(name)
+}
+
+// releaseDisk drops a snapshot's or clone's reference to the named cache
+// or tier file, removing it if it was only kept for them. fs.spillMtx must
+// be held.
+func (fs *FileSystem) releaseDisk(name string) {
+	fs.diskRefs[name]--
+	if fs.diskRefs[name] > 0 {
+		return
+	}
+	delete(fs.diskRefs, name)
+	if !fs.deadDisk[name] {
+		return
+	}
+	delete(fs.deadDisk, name)
+	fs.removeFile(name)
+	// the directory is removed once it's empty if it's no longer in use
+	if dir := filepath.Dir(name); dir != fs.spillDir && dir != fs.tierDir {
+		os.Remove(dir)
+	}
+}
+
+// removeDiskDir removes the cache or tier directory dir, unless snapshots
+// still refer to files in it. fs.spillMtx must be held.
+func (fs *FileSystem) removeDiskDir(dir string) error {
+	for name := range fs.diskRefs {
+		if filepath.Dir(name) == dir {
+			return nil
+		}
+	}
+
+	return os.RemoveAll(dir)
+}
+
+// touch marks sf as the most recently used file. fs.spillMtx must be held.
+func (fs *FileSystem) touch(sf *sealedFile) {
+	if len(sf.ciphertext) == 0 {
+		return
+	}
+	if fs.lru == nil {
+		fs.lru = list.New()
+	}
+	if sf.elem != nil {
+		fs.lru.MoveToFront(sf.elem)
+		return
+	}
+	sf.elem = fs.lru.PushFront(sf)
+	fs.resident += int64(len(sf.ciphertext))
+}
+
+// evict spills the least recently used files other than keep until the
+// memory budget is met. Pinned files and files that can't be written to the
+// cache stay in memory. fs.spillMtx must be held.
+func (fs *FileSystem) evict(keep *sealedFile) {
+	if fs.budget <= 0 || fs.lru == nil {
+		return
+	}
+
+	e := fs.lru.Back()
+	for fs.resident > fs.budget && e != nil {
+		prev := e.Prev()
+		sf := e.Value.(*sealedFile)
+		if sf != keep && !sf.pinned {
+			if err := fs.spill(sf); err != nil {
+				return
+			}
+		}
+		e = prev
+	}
+}
+
+// makeSpillDir creates the cache directory in dir, or the default
+// directory for temporary files if dir is empty, unless there is one.
+// fs.spillMtx must be held.
+func (fs *FileSystem) makeSpillDir(dir string) error {
+	if fs.spillDir != "" {
+		return nil
+	}
+	d, err := os.MkdirTemp(dir, "pandorasbox-spill-")
+	if err != nil {
+		return err
+	}
+	fs.spillDir = d
+
+	return nil
+}
+
+// spill writes the ciphertext of sf to the cache and releases it from
+// memory. fs.spillMtx must be held.
+func (fs *FileSystem) spill(sf *sealedFile) error {
+	if err := fs.makeSpillDir(fs.budgetDir); err != nil {
+		return err
+	}
+	var b [16]byte
+	if _, err := io.ReadFull(fs.rand, b[:]); err != nil {
+		return err
+	}
+	name := filepath.Join(fs.spillDir, hex.EncodeToString(b[:]))
+	if err := os.WriteFile(name, sf.ciphertext, 0600); err != nil {
+		os.Remove(name)
+		return err
+	}
+
+	sf.size = fs.plainSize(sf.ciphertext)
+	fs.lru.Remove(sf.elem)
+	fs.resident -= int64(len(sf.ciphertext))
+	sf.elem = nil
+	sf.ciphertext = nil
+	sf.shared = false
+	sf.spilled = name
+
+	return nil
+}
+
+// pageIn reads the ciphertext of sf back from the cache if it was spilled.
+// fs.spillMtx must be held.
+func (fs *FileSystem) pageIn(sf *sealedFile) error {
+	if sf.spilled == "" {
+		return nil
+	}
+
+	ciphertext, err := os.ReadFile(sf.spilled)
+	if err != nil {
+		return err
+	}
+	fs.forget(sf, sf.spilled)
+	sf.spilled = ""
+	sf.borrowed = false
+	sf.ciphertext = ciphertext
+	sf.shared = false
+	fs.touch(sf)
+
+	return nil
+}
+
+// Pin keeps the contents of the named file in memory: it's paged back in if
+// it was spilled or tiered, and is never spilled or tiered while pinned.
+func (fs *FileSystem) Pin(name string) error {
+	sf, err := fs.pinFile("pin", name)
+	if err != nil {
+		return err
+	}
+
+	fs.spillMtx.Lock()
+	sf.pinned = true
+	err = fs.pageIn(sf)
+	fs.touch(sf)
+	tiered, size := sf.tiered != "", sf.size
+	fs.spillMtx.Unlock()
+	if err != nil || !tiered {
+		return err
+	}
+
+	// sealing a pinned file always keeps it in memory
+	plaintext := make([]byte, size)
+	defer seal.Wipe(plaintext)
+	if err := fs.unseal(sf, plaintext); err != nil {
+		return err
+	}
+	return fs.seal(sf, plaintext)
+}
+
+// Unpin allows the contents of the named file to be spilled or tiered
+// again.
+func (fs *FileSystem) Unpin(name string) error {
+	sf, err := fs.pinFile("unpin", name)
+	if err != nil {
+		return err
+	}
+
+	fs.spillMtx.Lock()
+	defer fs.spillMtx.Unlock()
+
+	sf.pinned = false
+	fs.evict(nil)
+
+	return nil
+}
+
+// pinFile returns the sealed contents of the named regular file.
+func (fs *FileSystem) pinFile(op, name string) (*sealedFile, error) {
+	node, err := fs.xattrNode(op, name)
+	if err != nil {
+		return nil, err
+	}
+	if !node.Mode.IsRegular() {
+		return nil, &os.PathError{Op: op, Path: name, Err: absfs.ErrInvalid}
+	}
+
+	fs.mtx.RLock()
+	defer fs.mtx.RUnlock()
+
+	return fs.data[node.Ino], nil
+}
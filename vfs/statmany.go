@@ -0,0 +1,36 @@
+package vfs
+
+import "os"
+
+// A StatResult is the result of Stat for one of the paths passed to
+// StatMany.
+type StatResult struct {
+	Info os.FileInfo
+	Err  error
+}
+
+// StatMany is like calling Stat for each of paths, but resolves them all
+// while holding the lock of fs once, so verifying many files at a time
+// doesn't contend for it per file. The results are in the same order as
+// paths.
+func (fs *FileSystem) StatMany(paths []string) []StatResult {
+	results := make([]StatResult, len(paths))
+
+	fs.mtx.RLock()
+	defer fs.mtx.RUnlock()
+
+	for i, name := range paths {
+		if name == "/" {
+			results[i].Info = &FileInfo{"/", fs.root}
+			continue
+		}
+		node, err := fs.fileStat(fs.cwd, name)
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Info = &FileInfo{Base(name), node}
+	}
+
+	return results
+}
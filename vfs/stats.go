@@ -0,0 +1,134 @@
+package vfs
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// AccessStat counts how a file has been used since it was created.
+type AccessStat struct {
+	Opens  uint64
+	Reads  uint64
+	Writes uint64
+
+	// LastAccess is when the file was last opened, read or written, and
+	// LastWrite when it was last written.
+	LastAccess time.Time
+	LastWrite  time.Time
+}
+
+// accessStats are the counters behind an AccessStat, updated atomically.
+type accessStats struct {
+	opens, reads, writes  uint64
+	lastAccess, lastWrite int64
+}
+
+// The kinds of access counted by count.
+const (
+	accessOpen = iota
+	accessRead
+	accessWrite
+)
+
+// count records an access of kind to sf. Directories have no sealedFile,
+// so sf may be nil.
+func (sf *sealedFile) count(kind int) {
+	if sf == nil {
+		return
+	}
+	now := time.Now().UnixNano()
+	switch kind {
+	case accessOpen:
+		atomic.AddUint64(&sf.stats.opens, 1)
+	case accessRead:
+		atomic.AddUint64(&sf.stats.reads, 1)
+	case accessWrite:
+		atomic.AddUint64(&sf.stats.writes, 1)
+		atomic.StoreInt64(&sf.stats.lastWrite, now)
+	}
+	atomic.StoreInt64(&sf.stats.lastAccess, now)
+}
+
+// AccessStats returns how every file and directory below the root has been
+// used, by path. Hard linked files have the same statistics under each of their
+// paths. Files that haven't been used since they were created are left
+// out.
+func (fs *FileSystem) AccessStats() map[string]AccessStat {
+	byIno := make(map[uint64]AccessStat)
+	fs.mtx.RLock()
+	for ino, sf := range fs.data {
+		if sf == nil {
+			continue
+		}
+		stat := AccessStat{
+			Opens:  atomic.LoadUint64(&sf.stats.opens),
+			Reads:  atomic.LoadUint64(&sf.stats.reads),
+			Writes: atomic.LoadUint64(&sf.stats.writes),
+		}
+		if stat.Opens+stat.Reads+stat.Writes == 0 {
+			continue
+		}
+		stat.LastAccess = time.Unix(0, atomic.LoadInt64(&sf.stats.lastAccess))
+		if last := atomic.LoadInt64(&sf.stats.lastWrite); last != 0 {
+			stat.LastWrite = time.Unix(0, last)
+		}
+		byIno[uint64(ino)] = stat
+	}
+	fs.mtx.RUnlock()
+
+	inos := make(map[uint64]struct{}, len(byIno))
+	for ino := range byIno {
+		inos[ino] = struct{}{}
+	}
+	var paths []string
+	fs.findPaths(fs.root, "/", inos, &paths)
+
+	stats := make(map[string]AccessStat, len(paths))
+	for _, p := range paths {
+		node, err := fs.root.Resolve(strings.TrimLeft(p, "/"))
+		if err != nil {
+			continue
+		}
+		stats[p] = byIno[node.Ino]
+	}
+
+	return stats
+}
+
+// Stats summarizes how the contents of a FileSystem are stored.
+type Stats struct {
+	// Resident is the number of bytes of sealed contents kept in memory.
+	Resident int64
+
+	// Blocks is the number of deduplicated blocks stored, and BlockRefs
+	// the number of times files and snapshots refer to them.
+	Blocks    int
+	BlockRefs int
+
+	// LogicalBytes is the size of the deduplicated contents of files and
+	// snapshots, and StoredBytes the size of the blocks actually stored
+	// for them, before encryption.
+	LogicalBytes int64
+	StoredBytes  int64
+}
+
+// Saved returns the number of bytes deduplication saved.
+func (s Stats) Saved() int64 {
+	return s.LogicalBytes - s.StoredBytes
+}
+
+// Stats returns how the contents of fs are currently stored.
+func (fs *FileSystem) Stats() Stats {
+	fs.spillMtx.Lock()
+	defer fs.spillMtx.Unlock()
+
+	stats := Stats{Resident: fs.resident, Blocks: len(fs.blocks)}
+	for _, blk := range fs.blocks {
+		stats.BlockRefs += blk.refs
+		stats.LogicalBytes += blk.size * int64(blk.refs)
+		stats.StoredBytes += blk.size
+	}
+
+	return stats
+}
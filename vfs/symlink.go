@@ -0,0 +1,69 @@
+package vfs
+
+import (
+	"os"
+	"strings"
+
+	"github.com/awnumar/memguard"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/inode"
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+// sealTarget encrypts target, the target of the symbolic link with inode
+// number ino, under fs.linkKey and binds it to the link. Targets often name
+// users, hosts and projects, so they aren't kept in memory in the clear.
+func (fs *FileSystem) sealTarget(ino uint64, target string) ([]byte, error) {
+	return seal.SealChunk([]byte(target), fs.linkKey, seal.ChunkAD(ino, 0, 0))
+}
+
+// linkTarget returns the target of the symbolic link with inode number ino,
+// or "" if it has none.
+func (fs *FileSystem) linkTarget(ino uint64) string {
+	return openTarget(fs.linkKey, ino, fs.symlinks[ino])
+}
+
+// openTarget decrypts the sealed target of the symbolic link with inode
+// number ino. Targets that fail to decrypt are treated as missing.
+func openTarget(key *memguard.Enclave, ino uint64, ciphertext []byte) string {
+	if ciphertext == nil {
+		return ""
+	}
+	target, err := seal.OpenChunk(nil, ciphertext, key, seal.ChunkAD(ino, 0, 0))
+	if err != nil {
+		return ""
+	}
+	defer seal.Wipe(target)
+
+	return string(target)
+}
+
+// resolvePath returns the absolute path name with the symbolic links among
+// its components followed, the way MkdirAll follows them. Components that
+// don't exist are kept as they are.
+func (fs *FileSystem) resolvePath(name string) (string, error) {
+	path := "/"
+	for _, p := range strings.Split(name, string(PathSeparator)) {
+		if p == "" {
+			continue
+		}
+		path = Join(path, p)
+
+		for hops := 0; ; hops++ {
+			node, err := fs.root.Resolve(strings.TrimLeft(path, "/"))
+			if err != nil || node.Mode&os.ModeSymlink == 0 {
+				break
+			}
+			if hops == maxSymlinkHops {
+				return "", absfs.ErrSymlinkCycle
+			}
+			fs.mtx.RLock()
+			target := fs.linkTarget(node.Ino)
+			fs.mtx.RUnlock()
+			path = inode.Abs(Dir(path), target)
+		}
+	}
+
+	return path, nil
+}
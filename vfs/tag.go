@@ -0,0 +1,142 @@
+package vfs
+
+import (
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+)
+
+// ErrNoTag is returned for tags a file doesn't have.
+var ErrNoTag = errors.New("no such tag")
+
+// Tag sets the tag key of the named file to value. Tags are kept apart
+// from extended attributes and are indexed, so FindByTag doesn't have to
+// visit every file.
+func (fs *FileSystem) Tag(name, key, value string) error {
+	if key == "" {
+		return &os.PathError{Op: "tag", Path: name, Err: absfs.ErrInvalid}
+	}
+	node, err := fs.xattrNode("tag", name)
+	if err != nil {
+		return err
+	}
+
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	fs.setTag(node.Ino, key, value)
+	fs.notify(name, Chmod)
+
+	return nil
+}
+
+// setTag sets the tag key of the file with inode number ino to value and
+// indexes it. fs.mtx must be held.
+func (fs *FileSystem) setTag(ino uint64, key, value string) {
+	if fs.fileTags == nil {
+		fs.fileTags = make(map[uint64]map[string]string)
+		fs.tagIndex = make(map[string]map[string]map[uint64]struct{})
+	}
+	tags := fs.fileTags[ino]
+	if tags == nil {
+		tags = make(map[string]string)
+		fs.fileTags[ino] = tags
+	}
+	if old, ok := tags[key]; ok {
+		fs.unindexTag(ino, key, old)
+	}
+	tags[key] = value
+
+	values := fs.tagIndex[key]
+	if values == nil {
+		values = make(map[string]map[uint64]struct{})
+		fs.tagIndex[key] = values
+	}
+	inos := values[value]
+	if inos == nil {
+		inos = make(map[uint64]struct{})
+		values[value] = inos
+	}
+	inos[ino] = struct{}{}
+}
+
+// Untag removes the tag key of the named file.
+func (fs *FileSystem) Untag(name, key string) error {
+	node, err := fs.xattrNode("untag", name)
+	if err != nil {
+		return err
+	}
+
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	value, ok := fs.fileTags[node.Ino][key]
+	if !ok {
+		return &os.PathError{Op: "untag", Path: name, Err: ErrNoTag}
+	}
+	delete(fs.fileTags[node.Ino], key)
+	if len(fs.fileTags[node.Ino]) == 0 {
+		delete(fs.fileTags, node.Ino)
+	}
+	fs.unindexTag(node.Ino, key, value)
+	fs.notify(name, Chmod)
+
+	return nil
+}
+
+// unindexTag removes the file with inode number ino from the index of the
+// tag key with value. fs.mtx must be held.
+func (fs *FileSystem) unindexTag(ino uint64, key, value string) {
+	delete(fs.tagIndex[key][value], ino)
+	if len(fs.tagIndex[key][value]) == 0 {
+		delete(fs.tagIndex[key], value)
+	}
+	if len(fs.tagIndex[key]) == 0 {
+		delete(fs.tagIndex, key)
+	}
+}
+
+// Tags returns the tags of the named file.
+func (fs *FileSystem) Tags(name string) (map[string]string, error) {
+	node, err := fs.xattrNode("tags", name)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.mtx.RLock()
+	defer fs.mtx.RUnlock()
+
+	tags := make(map[string]string, len(fs.fileTags[node.Ino]))
+	for key, value := range fs.fileTags[node.Ino] {
+		tags[key] = value
+	}
+
+	return tags, nil
+}
+
+// FindByTag returns the sorted paths of the files whose tag key is value.
+// If value is empty, files with any value for the tag are returned.
+func (fs *FileSystem) FindByTag(key, value string) []string {
+	fs.mtx.RLock()
+	inos := make(map[uint64]struct{})
+	for v, files := range fs.tagIndex[key] {
+		if value != "" && v != value {
+			continue
+		}
+		for ino := range files {
+			inos[ino] = struct{}{}
+		}
+	}
+	fs.mtx.RUnlock()
+	if len(inos) == 0 {
+		return nil
+	}
+
+	var paths []string
+	fs.findPaths(fs.root, "/", inos, &paths)
+	sort.Strings(paths)
+
+	return paths
+}
@@ -0,0 +1,198 @@
+package vfs
+
+import (
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+// TierBlockSize is the size of the blocks the contents of tiered files are
+// encrypted in.
+const TierBlockSize = 64 * 1024
+
+// SetTiering stores the contents of files larger than threshold bytes in a
+// directory created in dir, or the default directory for temporary files
+// if dir is empty, instead of in memory. The contents are encrypted in
+// TierBlockSize blocks, each bound to its file, write and position, so
+// reads only decrypt the blocks they need. Metadata and keys stay in
+// memory. Files are moved between tiers when they are next written, or all
+// at once when tiering is disabled with a threshold that isn't positive.
+func (fs *FileSystem) SetTiering(threshold int64, dir string) error {
+	if fs.plain {
+		return &os.PathError{Op: "tier", Path: dir, Err: ErrPlainSpill}
+	}
+
+	fs.spillMtx.Lock()
+	if threshold > 0 {
+		defer fs.spillMtx.Unlock()
+		if fs.tierDir == "" {
+			d, err := os.MkdirTemp(dir, "pandorasbox-tier-")
+			if err != nil {
+				return err
+			}
+			fs.tierDir = d
+		}
+		fs.tierThreshold = threshold
+		return nil
+	}
+	fs.tierThreshold = 0
+	tierDir := fs.tierDir
+	fs.spillMtx.Unlock()
+	if tierDir == "" {
+		return nil
+	}
+
+	// move every tiered file back into memory
+	err := fs.eachFile(func(sf *sealedFile) error {
+		fs.spillMtx.Lock()
+		tiered, size := sf.tiered != "", sf.size
+		fs.spillMtx.Unlock()
+		if !tiered {
+			return nil
+		}
+
+		plaintext := make([]byte, size)
+		err := fs.unseal(sf, plaintext)
+		if err == nil {
+			err = fs.seal(sf, plaintext)
+		}
+		seal.Wipe(plaintext)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fs.spillMtx.Lock()
+	defer fs.spillMtx.Unlock()
+	if fs.tierThreshold > 0 {
+		// tiering was enabled again while files were being moved
+		return nil
+	}
+	fs.tierDir = ""
+
+	return fs.removeDiskDir(tierDir)
+}
+
+// sealTier writes plaintext to a new file in the tier directory and makes
+// it the contents of sf.
+func (fs *FileSystem) sealTier(sf *sealedFile, plaintext []byte, dir string) error {
+	fs.spillMtx.Lock()
+	blockSize, dedupKey, dedupMAC := fs.dedupSize, fs.dedupKey, fs.dedupMAC
+	fs.spillMtx.Unlock()
+	if blockSize > 0 {
+		return fs.sealDedup(sf, plaintext, dir, blockSize, dedupKey, dedupMAC)
+	}
+
+	var b [16]byte
+	if _, err := io.ReadFull(fs.rand, b[:]); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, hex.EncodeToString(b[:]))
+
+	fs.spillMtx.Lock()
+	fs.tierGen++
+	gen := fs.tierGen
+	fs.spillMtx.Unlock()
+
+	key := seal.NewKey()
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	for i := 0; i*TierBlockSize < len(plaintext); i++ {
+		block := plaintext[i*TierBlockSize:]
+		if len(block) > TierBlockSize {
+			block = block[:TierBlockSize]
+		}
+		var ciphertext []byte
+		ciphertext, err = seal.SealChunk(block, key, seal.ChunkAD(sf.ino, gen, uint64(i)))
+		if err != nil {
+			break
+		}
+		if _, err = f.Write(ciphertext); err != nil {
+			break
+		}
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(name)
+		return err
+	}
+
+	fs.spillMtx.Lock()
+	defer fs.spillMtx.Unlock()
+
+	fs.drop(sf)
+	sf.ciphertext = nil
+	sf.key = key
+	sf.tiered = name
+	sf.gen = gen
+	sf.tierIno = sf.ino
+	sf.size = int64(len(plaintext))
+
+	return nil
+}
+
+// readTier decrypts the contents of the tiered file sf at off into p. It
+// returns false if sf isn't tiered.
+func (fs *FileSystem) readTier(sf *sealedFile, p []byte, off int64) (int, bool, error) {
+	fs.spillMtx.Lock()
+	name, key, gen, ino, size := sf.tiered, sf.key, sf.gen, sf.tierIno, sf.size
+	m := fs.manifests[name]
+	fs.spillMtx.Unlock()
+	if name == "" {
+		return 0, false, nil
+	}
+	atomic.AddUint64(&fs.unseals, 1)
+	if off >= size {
+		return 0, true, io.EOF
+	}
+	if int64(len(p)) > size-off {
+		p = p[:size-off]
+	}
+	if m != nil {
+		n, err := readDedup(m, key, p, off)
+		return n, true, err
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, true, err
+	}
+	defer f.Close()
+
+	var (
+		n          int
+		ciphertext = make([]byte, TierBlockSize+seal.ChunkOverhead)
+		block      = make([]byte, 0, TierBlockSize)
+	)
+	defer seal.Wipe(block[:cap(block)])
+	for n < len(p) {
+		i := (off + int64(n)) / TierBlockSize
+		blockLen := size - i*TierBlockSize
+		if blockLen > TierBlockSize {
+			blockLen = TierBlockSize
+		}
+		ct := ciphertext[:blockLen+seal.ChunkOverhead]
+		if _, err := f.ReadAt(ct, i*(TierBlockSize+seal.ChunkOverhead)); err != nil {
+			if err == io.EOF {
+				err = ErrTampered
+			}
+			return n, true, err
+		}
+		block, err = seal.OpenChunk(block[:0], ct, key, seal.ChunkAD(ino, gen, uint64(i)))
+		if err != nil {
+			return n, true, err
+		}
+		n += copy(p[n:], block[off+int64(n)-i*TierBlockSize:])
+	}
+
+	return n, true, nil
+}
@@ -1,21 +1,25 @@
 package vfs
 
 import (
+	"container/list"
+	"crypto/rand"
 	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/awnumar/fastrand"
 	"github.com/awnumar/memguard"
 	"github.com/awnumar/memguard/core"
 
 	"github.com/capnspacehook/pandorasbox/absfs"
 	"github.com/capnspacehook/pandorasbox/inode"
+	"github.com/capnspacehook/pandorasbox/seal"
 )
 
 const (
@@ -30,33 +34,126 @@ func IsPathSeparator(c uint8) bool {
 type FileSystem struct {
 	mtx sync.RWMutex
 
-	Umask   os.FileMode
+	// Deprecated: set with WithUmask.
+	Umask os.FileMode
+	// Deprecated: set with WithTempDir.
 	Tempdir string
 
+	// opts are the Options the FileSystem was created with
+	opts      []Option
+	inodeOpts inode.Options
+	rand      io.Reader
+	workers   int
+	sealer    seal.Sealer
+
+	// plain FileSystems store file contents unencrypted
+	plain bool
+
 	root *inode.Inode
 	cwd  string
 	dir  *inode.Inode
 	ino  *inode.Ino
 
-	symlinks map[uint64]string
+	symlinks map[uint64][]byte
+	xattrs   map[uint64]map[string][]byte
 	data     []*sealedFile
+
+	// unseals counts how many times file contents were decrypted
+	unseals uint64
+
+	metaKey *memguard.Enclave
+	linkKey *memguard.Enclave
+	tagMtx  sync.Mutex
+	tags    map[uint64][]byte
+
+	watchMtx sync.Mutex
+	watchers map[*Watcher]struct{}
+
+	spillMtx sync.Mutex
+	budget   int64
+	resident int64
+	spillDir string
+	// budgetDir is where the cache directory is created when it's
+	// first needed
+	budgetDir string
+	lru       *list.List
+	pressure  *pressureMonitor
+
+	tierThreshold int64
+	tierDir       string
+	tierGen       uint64
+
+	dedupSize int
+	dedupKey  *memguard.Enclave
+	dedupMAC  *memguard.Enclave
+	blocks    map[string]*dedupBlock
+	manifests map[string]*manifest
+
+	idxMtx  sync.RWMutex
+	idxKey  *memguard.Enclave
+	index   map[string]map[uint64]struct{}
+	indexed map[uint64][]string
+
+	fileTags map[uint64]map[string]string
+	tagIndex map[string]map[string]map[uint64]struct{}
+
+	snapMtx  sync.Mutex
+	snapID   uint64
+	snaps    map[uint64]*snapshot
+	diskRefs map[string]int
+	deadDisk map[string]bool
+
+	fdMtx  sync.Mutex
+	nextFd uintptr
+	fds    map[uintptr]*File
+
+	viewMtx sync.Mutex
+
+	writeThrough atomic.Pointer[writeThrough]
+
+	policy atomic.Pointer[Policy]
 }
 
-func NewFS() *FileSystem {
+// NewFS returns an empty FileSystem configured by opts.
+func NewFS(opts ...Option) *FileSystem {
 	fs := new(FileSystem)
 	fs.ino = new(inode.Ino)
 	fs.Tempdir = "/tmp"
-
 	fs.Umask = 0755
-	fs.root = fs.ino.NewDir(fs.Umask)
+	fs.rand = rand.Reader
+	fs.workers = 1
+	fs.sealer = seal.XSalsa20Poly1305
+	fs.opts = opts
+	for _, opt := range opts {
+		opt(fs)
+	}
+	if fs.plain {
+		fs.budget = 0
+	}
+
+	fs.root = fs.ino.NewDirWithOptions(fs.Umask, &fs.inodeOpts)
 	fs.cwd = "/"
 	fs.dir = fs.root
 	fs.data = make([]*sealedFile, 2)
-	fs.symlinks = make(map[uint64]string)
+	fs.symlinks = make(map[uint64][]byte)
+	fs.xattrs = make(map[uint64]map[string][]byte)
+	fs.metaKey = seal.NewKey()
+	fs.linkKey = seal.NewKey()
+	fs.tags = make(map[uint64][]byte)
+	// the key was just created, so this can't fail
+	_ = fs.authenticate(fs.root)
 
 	return fs
 }
 
+// NewPlainFS returns a FileSystem that stores file contents unencrypted.
+// It has the same API as one returned by NewFS, but reads and writes are
+// plain memory copies, which makes it suited to scratch data that isn't
+// sensitive. It's NewFS with the NoCipher Cipher.
+func NewPlainFS(opts ...Option) *FileSystem {
+	return NewFS(append(opts[:len(opts):len(opts)], WithCipher(NoCipher))...)
+}
+
 func (fs *FileSystem) Separator() uint8 {
 	return PathSeparator
 }
@@ -99,6 +196,12 @@ func (fs *FileSystem) Rename(oldpath, newpath string) error {
 		linkErr.Err = err
 		return linkErr
 	}
+	if err := fs.authenticatePaths(Dir(oldpath), Dir(newpath), newpath); err != nil {
+		linkErr.Err = err
+		return linkErr
+	}
+	fs.notify(oldpath, Rename)
+	fs.notify(newpath, Create)
 	return nil
 }
 
@@ -123,7 +226,7 @@ func (fs *FileSystem) Chdir(name string) (err error) {
 		return &os.PathError{Op: "chdir", Path: name, Err: err}
 	}
 	if !node.IsDir() {
-		return &os.PathError{Op: "chdir", Path: name, Err: errors.New("not a directory")}
+		return &os.PathError{Op: "chdir", Path: name, Err: absfs.ErrNotDir}
 	}
 
 	fs.cwd = cwd
@@ -156,12 +259,12 @@ func (fs *FileSystem) Create(name string) (absfs.File, error) {
 func (fs *FileSystem) OpenFile(name string, flag int, perm os.FileMode) (absfs.File, error) {
 	if name == "/" {
 		data := fs.data[int(fs.root.Ino)]
-		return &File{fs: fs, name: name, flags: flag, node: fs.root, data: data}, nil
+		return fs.open(&File{fs: fs, name: name, flags: flag, node: fs.root, data: data}), nil
 	}
 	appendFile := flag&absfs.O_APPEND != 0
 	if name == "." {
 		data := fs.data[int(fs.dir.Ino)]
-		file := &File{fs: fs, name: name, flags: flag, node: fs.dir, data: data}
+		file := fs.open(&File{fs: fs, name: name, flags: flag, node: fs.dir, data: data})
 		if data != nil {
 			if appendFile {
 				file.offset = fs.dir.Size
@@ -181,6 +284,13 @@ func (fs *FileSystem) OpenFile(name string, flag int, perm os.FileMode) (absfs.F
 	if err == nil {
 		exists = true
 	}
+	if exists && node.Mode&os.ModeSymlink != 0 {
+		// open the file the link points at
+		node, err = fs.fileStat(fs.cwd, name)
+		if err != nil {
+			return &absfs.InvalidFile{Path: name}, err
+		}
+	}
 
 	dir, filename := Split(name)
 	dir = Clean(dir)
@@ -204,15 +314,13 @@ func (fs *FileSystem) OpenFile(name string, flag int, perm os.FileMode) (absfs.F
 		}
 		if node.IsDir() {
 			if access != os.O_RDONLY || truncate {
-				return &absfs.InvalidFile{name}, &os.PathError{Op: "open", Path: name, Err: syscall.EISDIR} // os.ErrNotExist}
+				return &absfs.InvalidFile{name}, &os.PathError{Op: "open", Path: name, Err: absfs.ErrIsDir} // os.ErrNotExist}
 			}
 		}
 
 		// if we must truncate the file
 		if truncate {
-			sfile := fs.data[int(node.Ino)]
-			sfile.ciphertext = nil
-			sfile.key = nil
+			fs.discard(fs.data[int(node.Ino)])
 		}
 	} else { // !exists
 		// error if we cannot create the file
@@ -221,13 +329,13 @@ func (fs *FileSystem) OpenFile(name string, flag int, perm os.FileMode) (absfs.F
 		}
 
 		// Create write-able file
-		node = fs.ino.New(fs.Umask & perm)
+		node = fs.ino.NewWithOptions(fs.Umask&perm, &fs.inodeOpts)
 		err := parent.Link(filename, node)
 		if err != nil {
 			fs.ino.SubIno()
 			return &absfs.InvalidFile{name}, &os.PathError{Op: "open", Path: name, Err: err}
 		}
-		fs.data = append(fs.data, &sealedFile{})
+		fs.data = append(fs.data, &sealedFile{ino: node.Ino})
 	}
 	data := fs.data[int(node.Ino)]
 
@@ -239,7 +347,7 @@ func (fs *FileSystem) OpenFile(name string, flag int, perm os.FileMode) (absfs.F
 		}
 	}
 
-	file := &File{fs: fs, name: name, flags: flag, node: node, data: data}
+	file := fs.open(&File{fs: fs, name: name, flags: flag, node: node, data: data})
 	if data != nil {
 		if truncate {
 			node.Size = 0
@@ -248,12 +356,25 @@ func (fs *FileSystem) OpenFile(name string, flag int, perm os.FileMode) (absfs.F
 			file.offset = node.Size
 		}
 		data.f = file
+		data.count(accessOpen)
+	}
+	if create || truncate {
+		if err := fs.authenticate(node, parent); err != nil {
+			file.Close()
+			return &absfs.InvalidFile{name}, &os.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+	if !exists {
+		fs.notify(name, Create)
+	} else if truncate {
+		fs.notify(name, Write)
 	}
 
 	return file, nil
 }
 
-func (fs *FileSystem) Truncate(name string, size int64) error {
+func (fs *FileSystem) Truncate(name string, size int64) (err error) {
+	defer fs.notified(name, Write, &err)
 	if size < 0 {
 		return &os.PathError{Op: "truncate", Path: name, Err: os.ErrClosed}
 	}
@@ -267,36 +388,34 @@ func (fs *FileSystem) Truncate(name string, size int64) error {
 	file := fs.data[child.Ino]
 	fs.mtx.RUnlock()
 
+	if size == atomic.LoadInt64(&child.Size) {
+		return nil
+	}
+	// the contents only need decrypting if some of them are kept
 	var plaintext []byte
-	if file.f.node.Size != 0 {
+	if child.Size != 0 && size != 0 {
 		file.f.mtx.RLock()
-		key, err := file.key.Open()
-		if err != nil {
-			return err
-		}
-		plaintext = make([]byte, file.f.node.Size)
-		_, err = core.Decrypt(file.ciphertext, key.Bytes(), plaintext)
+		plaintext = make([]byte, child.Size)
+		err = fs.unseal(file, plaintext)
+		file.f.mtx.RUnlock()
 		if err != nil {
 			return err
 		}
-		key.Destroy()
-		file.f.mtx.RUnlock()
-	} else if size == 0 { // data is already nil, no-op
-		return nil
 	}
 
 	// TODO: should this be copied in constant time?
-	if size <= file.f.node.Size {
+	if size <= child.Size {
 		plaintext = plaintext[:int(size)]
-		newKey := memguard.NewBufferFromBytes(fastrand.Bytes(keySize))
 
 		file.f.mtx.Lock()
-		file.ciphertext, err = core.Encrypt(plaintext, newKey.Bytes())
-		file.key = newKey.Seal()
-		file.f.updateSize()
+		err = fs.seal(file, plaintext)
+		atomic.StoreInt64(&child.Size, fs.sealedSize(file))
 		file.f.mtx.Unlock()
+		if err == nil {
+			err = fs.authenticate(child)
+		}
 
-		core.Wipe(plaintext)
+		seal.Wipe(plaintext)
 		if err != nil {
 			return err
 		}
@@ -306,15 +425,15 @@ func (fs *FileSystem) Truncate(name string, size int64) error {
 	data := make([]byte, int(size))
 	core.Move(data, plaintext)
 
-	newKey := memguard.NewBufferFromBytes(fastrand.Bytes(keySize))
-
 	file.f.mtx.Lock()
-	file.ciphertext, err = core.Encrypt(data, newKey.Bytes())
-	file.key = newKey.Seal()
-	file.f.updateSize()
+	err = fs.seal(file, data)
+	atomic.StoreInt64(&child.Size, fs.sealedSize(file))
 	file.f.mtx.Unlock()
+	if err == nil {
+		err = fs.authenticate(child)
+	}
 
-	core.Wipe(data)
+	seal.Wipe(data)
 	if err != nil {
 		return err
 	}
@@ -347,25 +466,68 @@ func (fs *FileSystem) Mkdir(name string, perm os.FileMode) error {
 		}
 	}
 
-	child := fs.ino.NewDir(fs.Umask & perm)
-	parent.Link(filename, child)
+	child := fs.ino.NewDirWithOptions(fs.Umask&perm, &fs.inodeOpts)
+	if err := parent.Link(filename, child); err != nil {
+		fs.ino.SubIno()
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
 	child.Link("..", parent)
-	fs.data = append(fs.data, &sealedFile{})
+	fs.data = append(fs.data, &sealedFile{ino: child.Ino})
+	if err := fs.authenticate(child, parent); err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	fs.notify(abs, Create)
 
 	return nil
 }
 
+// MkdirAll creates the directory name along with any parents that don't
+// exist yet, each with the permission bits of perm masked by Umask, like
+// Mkdir. Directories that already exist are skipped, and symbolic links to
+// directories are followed. If a component of name exists but isn't a
+// directory, an *os.PathError wrapping absfs.ErrNotDir is returned,
+// otherwise the first error creating a directory is.
 func (fs *FileSystem) MkdirAll(name string, perm os.FileMode) error {
 	fs.mtx.RLock()
-	name = inode.Abs(fs.cwd, name)
+	abs := inode.Abs(fs.cwd, name)
 	fs.mtx.RUnlock()
-	path := ""
-	for _, p := range strings.Split(name, string(fs.Separator())) {
+
+	path := "/"
+	for _, p := range strings.Split(abs, string(fs.Separator())) {
 		if p == "" {
-			p = "/"
+			continue
 		}
 		path = Join(path, p)
-		fs.Mkdir(path, perm)
+
+		// create directories in the targets of links, not in the links
+		for hops := 0; ; hops++ {
+			node, err := fs.root.Resolve(strings.TrimLeft(path, "/"))
+			if err != nil || node.Mode&os.ModeSymlink == 0 {
+				break
+			}
+			if hops == maxSymlinkHops {
+				return &os.PathError{Op: "mkdir", Path: path, Err: absfs.ErrSymlinkCycle}
+			}
+			fs.mtx.RLock()
+			target := fs.linkTarget(node.Ino)
+			fs.mtx.RUnlock()
+			path = inode.Abs(Dir(path), target)
+		}
+
+		node, err := fs.root.Resolve(strings.TrimLeft(path, "/"))
+		if err == nil {
+			if !node.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: path, Err: absfs.ErrNotDir}
+			}
+			continue
+		}
+		if err := fs.Mkdir(path, perm.Perm()); err != nil {
+			// the directory may have been created meanwhile
+			if node, serr := fs.root.Resolve(strings.TrimLeft(path, "/")); serr == nil && node.IsDir() {
+				continue
+			}
+			return err
+		}
 	}
 
 	return nil
@@ -385,7 +547,7 @@ func (fs *FileSystem) Remove(name string) (err error) {
 
 	if child.IsDir() {
 		if len(child.Dir) > 2 {
-			return &os.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+			return &os.PathError{Op: "remove", Path: name, Err: absfs.ErrNotEmpty}
 		}
 	}
 
@@ -399,7 +561,14 @@ func (fs *FileSystem) Remove(name string) (err error) {
 		}
 	}
 
-	return parent.Unlink(filename)
+	err = parent.Unlink(filename)
+	if err1 := fs.authenticate(parent); err == nil && err1 != nil {
+		err = &os.PathError{Op: "remove", Path: name, Err: err1}
+	}
+	if err == nil {
+		fs.notify(abs, Remove)
+	}
+	return err
 }
 
 func (fs *FileSystem) RemoveAll(name string) error {
@@ -424,7 +593,14 @@ func (fs *FileSystem) RemoveAll(name string) error {
 		}
 	}
 	child.UnlinkAll()
-	return parent.Unlink(filename)
+	err = parent.Unlink(filename)
+	if err1 := fs.authenticate(parent); err == nil && err1 != nil {
+		err = &os.PathError{Op: "removeall", Path: name, Err: err1}
+	}
+	if err == nil {
+		fs.notify(abs, Remove)
+	}
+	return err
 }
 
 //Chtimes changes the access and modification times of the named file
@@ -442,6 +618,10 @@ func (fs *FileSystem) Chtimes(name string, atime time.Time, mtime time.Time) err
 
 	node.Atime = atime
 	node.Mtime = mtime
+	if err := fs.authenticate(node); err != nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	fs.notify(name, Chmod)
 
 	return nil
 }
@@ -460,6 +640,10 @@ func (fs *FileSystem) Chown(name string, uid, gid int) error {
 	}
 	node.Uid = uint32(uid)
 	node.Gid = uint32(gid)
+	if err := fs.authenticate(node); err != nil {
+		return &os.PathError{Op: "chown", Path: name, Err: err}
+	}
+	fs.notify(name, Chmod)
 
 	return nil
 }
@@ -479,25 +663,37 @@ func (fs *FileSystem) Chmod(name string, mode os.FileMode) error {
 		}
 	}
 	node.Mode = mode
+	if err := fs.authenticate(node); err != nil {
+		return &os.PathError{Op: "chmod", Path: name, Err: err}
+	}
+	fs.notify(name, Chmod)
 
 	return nil
 }
 
-// TODO: Avoid cyclical links
+// maxSymlinkHops is the number of symbolic links fileStat follows before
+// giving up, as they must form a cycle.
+const maxSymlinkHops = 40
+
 func (fs *FileSystem) fileStat(cwd, name string) (*inode.Inode, error) {
-	name = inode.Abs(cwd, name)
-	if name != "/" {
-		name = strings.TrimLeft(name, "/")
-	}
-	node, err := fs.root.Resolve(name)
-	if err != nil {
-		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
-	}
+	for hops := 0; ; hops++ {
+		name = inode.Abs(cwd, name)
+		if name != "/" {
+			name = strings.TrimLeft(name, "/")
+		}
+		node, err := fs.root.Resolve(name)
+		if err != nil {
+			return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+		}
 
-	if node.Mode&os.ModeSymlink == 0 {
-		return node, nil
+		if node.Mode&os.ModeSymlink == 0 {
+			return node, nil
+		}
+		if hops == maxSymlinkHops {
+			return nil, &os.PathError{Op: "stat", Path: name, Err: absfs.ErrSymlinkCycle}
+		}
+		cwd, name = Dir(name), fs.linkTarget(node.Ino)
 	}
-	return fs.fileStat(Dir(name), fs.symlinks[node.Ino])
 }
 
 func (fs *FileSystem) Stat(name string) (os.FileInfo, error) {
@@ -529,6 +725,9 @@ func (fs *FileSystem) Lchown(name string, uid, gid int) error {
 	if name == "/" {
 		fs.root.Uid = uint32(uid)
 		fs.root.Gid = uint32(gid)
+		if err := fs.authenticate(fs.root); err != nil {
+			return &os.PathError{Op: "lchown", Path: name, Err: err}
+		}
 		return nil
 	}
 	name = inode.Abs(fs.cwd, name)
@@ -539,6 +738,10 @@ func (fs *FileSystem) Lchown(name string, uid, gid int) error {
 
 	node.Uid = uint32(uid)
 	node.Gid = uint32(gid)
+	if err := fs.authenticate(node); err != nil {
+		return &os.PathError{Op: "lchown", Path: name, Err: err}
+	}
+	fs.notify(name, Chmod)
 	return nil
 }
 
@@ -555,9 +758,9 @@ func (fs *FileSystem) Readlink(name string) (string, error) {
 	}
 
 	fs.mtx.RLock()
-	defer fs.mtx.Unlock()
+	defer fs.mtx.RUnlock()
 
-	return fs.symlinks[ino], nil
+	return fs.linkTarget(ino), nil
 }
 
 func (fs *FileSystem) Symlink(oldname, newname string) error {
@@ -574,17 +777,27 @@ func (fs *FileSystem) Symlink(oldname, newname string) error {
 	if exists && newNode.Mode&os.ModeSymlink == 0 {
 		return &os.PathError{Op: "symlink", Path: newname, Err: syscall.EEXIST}
 	}
-	oldNode, err := wd.Resolve(oldname)
-	if err != nil {
-		return &os.PathError{Op: "symlink", Path: oldname, Err: syscall.ENOENT}
+	// the target doesn't have to exist, or even be in the VFS, as a Box
+	// follows links to paths on other backends itself
+	mode := os.ModeSymlink | 0777
+	if oldNode, err := wd.Resolve(oldname); err == nil {
+		mode = oldNode.Mode | os.ModeSymlink
 	}
 
 	fs.mtx.Lock()
 	defer fs.mtx.Unlock()
 
 	if exists {
-		newNode.Mode = oldNode.Mode | os.ModeSymlink
-		fs.symlinks[newNode.Ino] = oldname
+		target, err := fs.sealTarget(newNode.Ino, oldname)
+		if err != nil {
+			return &os.PathError{Op: "symlink", Path: newname, Err: err}
+		}
+		newNode.Mode = mode
+		fs.symlinks[newNode.Ino] = target
+		if err := fs.authenticate(newNode); err != nil {
+			return &os.PathError{Op: "symlink", Path: newname, Err: err}
+		}
+		fs.notify(newname, Create)
 		return nil
 	}
 
@@ -595,13 +808,68 @@ func (fs *FileSystem) Symlink(oldname, newname string) error {
 		return err
 	}
 
-	newNode = fs.ino.New(oldNode.Mode | os.ModeSymlink)
+	newNode = fs.ino.NewWithOptions(mode, &fs.inodeOpts)
+	// every inode needs a data entry, so the ones after it line up
+	fs.data = append(fs.data, &sealedFile{ino: newNode.Ino})
 
+	target, err := fs.sealTarget(newNode.Ino, oldname)
+	if err != nil {
+		return &os.PathError{Op: "symlink", Path: newname, Err: err}
+	}
 	err = parent.Link(filename, newNode)
 	if err != nil {
 		return &os.PathError{Op: "symlink", Path: newname, Err: err}
 	}
-	fs.symlinks[newNode.Ino] = oldname
+	fs.symlinks[newNode.Ino] = target
+	if err := fs.authenticate(newNode, parent); err != nil {
+		return &os.PathError{Op: "symlink", Path: newname, Err: err}
+	}
+	fs.notify(newname, Create)
+	return nil
+}
+
+// Link creates newname as a hard link to the oldname file. Both names
+// refer to the same inode, and so to the same contents.
+func (fs *FileSystem) Link(oldname, newname string) error {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	oldAbs := inode.Abs(fs.cwd, oldname)
+	newAbs := inode.Abs(fs.cwd, newname)
+	if oldAbs == "/" {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EPERM}
+	}
+	node, err := fs.root.Resolve(strings.TrimLeft(oldAbs, "/"))
+	if err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	if node.IsDir() {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EPERM}
+	}
+	if newAbs == "/" {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EEXIST}
+	}
+	if _, err := fs.root.Resolve(strings.TrimLeft(newAbs, "/")); err == nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EEXIST}
+	}
+
+	parent := fs.root
+	dir, filename := Split(newAbs)
+	dir = Clean(dir)
+	if dir != "/" {
+		parent, err = fs.root.Resolve(strings.TrimLeft(dir, "/"))
+		if err != nil {
+			return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+		}
+	}
+	if err := parent.Link(filename, node); err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	if err := fs.authenticate(parent, node); err != nil {
+		return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: err}
+	}
+	fs.notify(newAbs, Create)
+
 	return nil
 }
 
@@ -2,18 +2,25 @@ package vfs
 
 import (
 	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/capnspacehook/pandorasbox/absfs"
 	"github.com/capnspacehook/pandorasbox/fstesting"
+	"github.com/capnspacehook/pandorasbox/inode"
 	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/seal"
 )
 
 const (
@@ -1314,3 +1321,2415 @@ func TestModTime(t *testing.T) {
 		t.Error("Open with O_RDONLY should not modify mtime")
 	}
 }
+
+func TestPlainFS(t *testing.T) {
+	fs := NewPlainFS()
+	data := []byte("The quick brown fox jumped over the lazy dog.")
+
+	f, err := fs.Create("/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteAt([]byte("slow"), 4); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	info, err := fs.Stat("/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(data)) {
+		t.Errorf("wrong size: %d, expected %d", info.Size(), len(data))
+	}
+
+	f, err = fs.Open("/plain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "The slowk brown fox jumped over the lazy dog."; string(buf) != expected {
+		t.Errorf("wrong contents: %q, expected %q", buf, expected)
+	}
+}
+
+func TestVerifyAll(t *testing.T) {
+	fs := NewFS()
+
+	if err := fs.MkdirAll("/dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.Create("/dir/sub/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := fs.Chmod("/dir/sub/file", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("/dir/sub/file", "/dir/moved"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.VerifyAll(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// modify the inode directly instead of through the FileSystem
+	info, err := fs.Stat("/dir/moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Sys().(*inode.Inode).Mode = 0777
+
+	err = fs.VerifyAll()
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != ErrTampered || perr.Path != "/dir/moved" {
+		t.Fatalf("expected tampering of /dir/moved to be detected, got %v", err)
+	}
+}
+
+func TestVerifyOwner(t *testing.T) {
+	fs := NewFS()
+	if err := ioutil.WriteFile(fs, "/file", []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Chown("/file", 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Lchown("/", 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.OpenFile("/file", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.(*File).Chown(1001, 1001); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := fs.VerifyAll(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	info, err := fs.Stat("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info.Sys().(*inode.Inode).Uid = 0
+
+	err = fs.VerifyAll()
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != ErrTampered || perr.Path != "/file" {
+		t.Fatalf("expected change of owner of /file to be detected, got %v", err)
+	}
+}
+
+func TestLink(t *testing.T) {
+	fs := NewFS()
+	if err := ioutil.WriteFile(fs, "/a", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Link("/a", "/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Link("/a", "/b"); !os.IsExist(err) {
+		t.Errorf("expected link over existing file to fail, got %v", err)
+	}
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Link("/dir", "/dir2"); err == nil {
+		t.Error("expected link to directory to fail")
+	}
+
+	if err := ioutil.WriteFile(fs, "/b", []byte("new data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/b"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(fs, "/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "new data" {
+		t.Errorf("write through link not visible: %q", data)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	fs := NewFS()
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	w := fs.NewWatcher()
+	defer w.Close()
+	if err := w.Add("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Add("/missing"); err == nil {
+		t.Error("expected watching a missing file to fail")
+	}
+
+	if err := ioutil.WriteFile(fs, "/dir/a", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// changes outside of the watched directory aren't reported
+	if err := ioutil.WriteFile(fs, "/b", []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("/dir/a", "/dir/c"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Chmod("/dir/c", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/dir/c"); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Event{
+		{"/dir/a", Create},
+		{"/dir/a", Write},
+		{"/dir/a", Rename},
+		{"/dir/c", Create},
+		{"/dir/c", Chmod},
+		{"/dir/c", Remove},
+	}
+	for _, e := range want {
+		select {
+		case got := <-w.Events:
+			if got != e {
+				t.Errorf("got event %v %v, want %v %v", got.Name, got.Op, e.Name, e.Op)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for %v %v", e.Name, e.Op)
+		}
+	}
+
+	w.Close()
+	if _, ok := <-w.Events; ok {
+		t.Error("expected Events to be closed")
+	}
+}
+
+func TestMemoryBudget(t *testing.T) {
+	if err := NewPlainFS().SetMemoryBudget(1, t.TempDir()); err == nil {
+		t.Error("expected a plain filesystem to refuse to spill")
+	}
+
+	fs := NewFS()
+	dir := t.TempDir()
+	if err := fs.SetMemoryBudget(10000, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string][]byte)
+	for i := 0; i < 10; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, 4000)
+		name := fmt.Sprintf("/f%d", i)
+		if err := ioutil.WriteFile(fs, name, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		files[name] = data
+	}
+	if n := fs.MemoryUsage(); n > 10000 {
+		t.Errorf("%d bytes in memory, budget is 10000", n)
+	}
+	cache, err := filepath.Glob(filepath.Join(dir, "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache) == 0 {
+		t.Fatal("no files were spilled")
+	}
+	for _, name := range cache {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, bytes.Repeat([]byte{data[0]}, 64)) {
+			t.Errorf("%s is not encrypted", name)
+		}
+	}
+
+	for name, want := range files {
+		info, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != int64(len(want)) {
+			t.Errorf("%s: size %d, want %d", name, info.Size(), len(want))
+		}
+		got, err := ioutil.ReadFile(fs, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: paged in the wrong contents", name)
+		}
+	}
+
+	if err := fs.SetMemoryBudget(0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("cache was not removed: %v", entries)
+	}
+	for name, want := range files {
+		got, err := ioutil.ReadFile(fs, name)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("%s: wrong contents after disabling spilling: %v", name, err)
+		}
+	}
+}
+
+func TestMemoryPressure(t *testing.T) {
+	if err := NewPlainFS().SetMemoryPressure(&MemoryPressure{Limit: 1}); err == nil {
+		t.Error("expected a plain filesystem to refuse to spill")
+	}
+
+	fs := NewFS()
+	dir := t.TempDir()
+	for i := 0; i < 4; i++ {
+		if err := ioutil.WriteFile(fs, fmt.Sprintf("/f%d", i), bytes.Repeat([]byte{byte(i)}, 4000), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Pin("/f0"); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan PressureEvent, 1)
+	// any process is above a limit of a byte
+	err := fs.SetMemoryPressure(&MemoryPressure{
+		Limit:    1,
+		Interval: 10 * time.Millisecond,
+		Dir:      dir,
+		OnPressure: func(e PressureEvent) {
+			select {
+			case events <- e:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-events:
+		if e.Limit != 1 || e.InUse == 0 {
+			t.Errorf("unexpected event %+v", e)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pressure wasn't reported")
+	}
+	if err := fs.SetMemoryPressure(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if cache, _ := filepath.Glob(filepath.Join(dir, "*", "*")); len(cache) != 3 {
+		t.Errorf("%d files were spilled, want the 3 that aren't pinned", len(cache))
+	}
+	if n := fs.MemoryUsage(); n == 0 {
+		t.Error("pinned file was spilled")
+	}
+	for i := 0; i < 4; i++ {
+		got, err := ioutil.ReadFile(fs, fmt.Sprintf("/f%d", i))
+		if err != nil || !bytes.Equal(got, bytes.Repeat([]byte{byte(i)}, 4000)) {
+			t.Errorf("/f%d: wrong contents after spilling: %v", i, err)
+		}
+	}
+	if err := fs.SetMemoryBudget(0, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTiering(t *testing.T) {
+	fs := NewFS()
+	dir := t.TempDir()
+	if err := fs.SetTiering(100000, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	big := make([]byte, 3*TierBlockSize+100)
+	for i := range big {
+		big[i] = byte(i % 251)
+	}
+	if err := ioutil.WriteFile(fs, "/big", big, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/small", []byte("small"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if n := fs.MemoryUsage(); n == 0 || n > 1000 {
+		t.Errorf("%d bytes in memory, expected only the small file", n)
+	}
+	tiered, _ := filepath.Glob(filepath.Join(dir, "*", "*"))
+	if len(tiered) != 1 {
+		t.Fatalf("expected 1 tiered file, got %v", tiered)
+	}
+
+	f, err := fs.Open("/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 1000)
+	off := int64(TierBlockSize - 500)
+	if n, err := f.ReadAt(p, off); err != nil || n != len(p) {
+		t.Fatalf("ReadAt: %d, %v", n, err)
+	}
+	if !bytes.Equal(p, big[off:off+int64(len(p))]) {
+		t.Error("ReadAt across blocks returned the wrong data")
+	}
+	if n, err := f.ReadAt(p, int64(len(big)-10)); n != 10 || (err != nil && err != io.EOF) {
+		t.Errorf("ReadAt at the end: %d, %v", n, err)
+	}
+	f.Close()
+
+	got, err := ioutil.ReadFile(fs, "/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, big) {
+		t.Error("ReadFile returned the wrong data")
+	}
+	if info, err := fs.Stat("/big"); err != nil || info.Size() != int64(len(big)) {
+		t.Errorf("Stat: %v, %v", info, err)
+	}
+
+	// a file shrunk below the threshold moves back into memory
+	if err := fs.Truncate("/big", 10); err != nil {
+		t.Fatal(err)
+	}
+	if tiered, _ := filepath.Glob(filepath.Join(dir, "*", "*")); len(tiered) != 0 {
+		t.Errorf("tier file was not removed: %v", tiered)
+	}
+	if err := ioutil.WriteFile(fs, "/big", big, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fs.SetTiering(0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("tier directory was not removed: %v", entries)
+	}
+	if got, err := ioutil.ReadFile(fs, "/big"); err != nil || !bytes.Equal(got, big) {
+		t.Errorf("wrong contents after disabling tiering: %v", err)
+	}
+}
+
+func TestMap(t *testing.T) {
+	fs := NewFS()
+	data := []byte("random access without copies")
+	if err := ioutil.WriteFile(fs, "/f", data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := fs.Open("/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := f.(*File).Map()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Len() != len(data) || !bytes.Equal(m.Bytes(), data) {
+		t.Errorf("mapped %q, want %q", m.Bytes(), data)
+	}
+	m.Release()
+	if m.Len() != 0 {
+		t.Error("expected a released mapping to be empty")
+	}
+
+	f.Close()
+	if _, err := f.(*File).Map(); err == nil {
+		t.Error("expected mapping a closed file to fail")
+	}
+
+	dir, err := fs.Open("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dir.Close()
+	if _, err := dir.(*File).Map(); err == nil {
+		t.Error("expected mapping a directory to fail")
+	}
+}
+
+func TestReadDirMatch(t *testing.T) {
+	fs := NewFS()
+	if err := fs.Mkdir("/d", 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/d/a.key", "/d/b.txt", "/d/c.key"} {
+		if err := ioutil.WriteFile(fs, name, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	infos, err := fs.ReadDirMatch("/d", "*.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	if strings.Join(names, " ") != "a.key c.key" {
+		t.Errorf("matched %v", names)
+	}
+
+	if infos, err := fs.ReadDirMatch("/d", "*.none"); err != nil || len(infos) != 0 {
+		t.Errorf("expected no matches, got %v, %v", infos, err)
+	}
+	if _, err := fs.ReadDirMatch("/d", "["); err == nil {
+		t.Error("expected a bad pattern to fail")
+	}
+	if _, err := fs.ReadDirMatch("/d/a.key", "*"); err == nil {
+		t.Error("expected listing a file to fail")
+	}
+	if _, err := fs.ReadDirMatch("/missing", "*"); err == nil {
+		t.Error("expected listing a missing directory to fail")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	fs := NewFS()
+	if err := fs.Mkdir("/notes", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/notes/a", []byte("The database password is hunter2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.EnableIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/notes/b", []byte("API token for the database"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"database", []string{"/notes/a", "/notes/b"}},
+		{"DATABASE password", []string{"/notes/a"}},
+		{"token", []string{"/notes/b"}},
+		{"missing", nil},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := fs.Search(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("Search(%q) = %v, want %v", tt.query, got, tt.want)
+		}
+	}
+
+	if err := ioutil.WriteFile(fs, "/notes/a", []byte("rotated"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.Search("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("stale index entries: %v", got)
+	}
+	if err := fs.Remove("/notes/b"); err != nil {
+		t.Fatal(err)
+	}
+	got, err = fs.Search("token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("removed file found: %v", got)
+	}
+	got, err = fs.Search("rotated")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, " ") != "/notes/a" {
+		t.Errorf("Search(rotated) = %v", got)
+	}
+}
+
+func TestTags(t *testing.T) {
+	fs := NewFS()
+	if err := fs.Mkdir("/keys", 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/keys/a", "/keys/b", "/c"} {
+		if err := ioutil.WriteFile(fs, name, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tag := range []struct{ name, key, value string }{
+		{"/keys/a", "env", "prod"},
+		{"/keys/b", "env", "dev"},
+		{"/c", "env", "prod"},
+		{"/keys", "owner", "ops"},
+	} {
+		if err := fs.Tag(tag.name, tag.key, tag.value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Tag("/missing", "env", "prod"); err == nil {
+		t.Error("expected tagging a missing file to fail")
+	}
+
+	tests := []struct {
+		key, value string
+		want       []string
+	}{
+		{"env", "prod", []string{"/c", "/keys/a"}},
+		{"env", "", []string{"/c", "/keys/a", "/keys/b"}},
+		{"owner", "ops", []string{"/keys"}},
+		{"env", "test", nil},
+	}
+	for _, tt := range tests {
+		if got := fs.FindByTag(tt.key, tt.value); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("FindByTag(%q, %q) = %v, want %v", tt.key, tt.value, got, tt.want)
+		}
+	}
+
+	if err := fs.Tag("/keys/a", "env", "dev"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Untag("/c", "env"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Untag("/c", "env"); err == nil {
+		t.Error("expected removing a missing tag to fail")
+	}
+	if got := fs.FindByTag("env", "prod"); len(got) != 0 {
+		t.Errorf("stale tag index entries: %v", got)
+	}
+	tags, err := fs.Tags("/keys/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags["env"] != "dev" {
+		t.Errorf("Tags = %v", tags)
+	}
+	if attrs, _ := fs.Listxattr("/keys/a"); len(attrs) != 0 {
+		t.Errorf("tags leaked into extended attributes: %v", attrs)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	fs := NewFS()
+	dir := t.TempDir()
+	if err := fs.SetTiering(1000, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir("/d", 0700); err != nil {
+		t.Fatal(err)
+	}
+	big := bytes.Repeat([]byte("big"), 1000)
+	if err := ioutil.WriteFile(fs, "/d/big", big, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/d/small", []byte("v1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Tag("/d/small", "version", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := fs.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(fs, "/d/small", []byte("version 2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Tag("/d/small", "version", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/d/big", []byte("shrunk"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/d/new", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	readAt := func(name string) []byte {
+		f, err := fs.OpenAt(id, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		data, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	if got := readAt("/d/small"); string(got) != "v1" {
+		t.Errorf("snapshot of /d/small = %q", got)
+	}
+	if got := readAt("/d/big"); !bytes.Equal(got, big) {
+		t.Error("snapshot of /d/big changed")
+	}
+	if got, _ := ioutil.ReadFile(fs, "/d/small"); string(got) != "version 2" {
+		t.Errorf("live /d/small = %q", got)
+	}
+	if _, err := fs.OpenAt(id, "/d/new"); err == nil {
+		t.Error("expected a file created after the snapshot to be missing")
+	}
+	if tags, err := fs.TagsAt(id, "/d/small"); err != nil || tags["version"] != "1" {
+		t.Errorf("TagsAt = %v, %v", tags, err)
+	}
+
+	f, err := fs.OpenAt(id, "/d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, " ") != "big small" {
+		t.Errorf("snapshot of /d lists %v", names)
+	}
+	f, err = fs.OpenAt(id, "/d/small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Error("expected writing to a snapshot to fail")
+	}
+	f.Close()
+
+	if got := fs.Snapshots(); len(got) != 1 || got[0] != id {
+		t.Errorf("Snapshots = %v", got)
+	}
+	if err := fs.DeleteSnapshot(id); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.OpenAt(id, "/d/small"); err == nil {
+		t.Error("expected opening a deleted snapshot to fail")
+	}
+	if tiered, _ := filepath.Glob(filepath.Join(dir, "*", "*")); len(tiered) != 0 {
+		t.Errorf("tier files of the deleted snapshot remain: %v", tiered)
+	}
+}
+
+func TestPin(t *testing.T) {
+	fs := NewFS()
+	if err := fs.SetMemoryBudget(3000, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SetTiering(5000, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 2000)
+	if err := ioutil.WriteFile(fs, "/key", data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Pin("/key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Pin("/"); err == nil {
+		t.Error("expected pinning a directory to fail")
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := ioutil.WriteFile(fs, fmt.Sprintf("/f%d", i), data, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(fs, "/key", make([]byte, 10000), 0600); err != nil {
+		t.Fatal(err)
+	}
+	node, err := fs.fileStat("/", "/key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sf := fs.data[node.Ino]
+	if sf.spilled != "" || sf.tiered != "" || len(sf.ciphertext) == 0 {
+		t.Error("pinned file left memory")
+	}
+
+	if err := fs.Unpin("/key"); err != nil {
+		t.Fatal(err)
+	}
+	fs.spillMtx.Lock()
+	spilled := sf.spilled != ""
+	fs.spillMtx.Unlock()
+	if !spilled {
+		t.Error("unpinned file over the budget wasn't spilled")
+	}
+}
+
+func TestAccessStats(t *testing.T) {
+	fs := NewFS()
+	start := time.Now()
+	if err := fs.Mkdir("/d", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/d/hot", []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/cold", []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Link("/d/hot", "/link"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := ioutil.ReadFile(fs, "/d/hot"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := fs.AccessStats()
+	hot, ok := stats["/d/hot"]
+	if !ok {
+		t.Fatalf("no statistics for /d/hot: %v", stats)
+	}
+	if hot.Opens != 4 || hot.Reads < 3 || hot.Writes != 1 {
+		t.Errorf("/d/hot: %+v", hot)
+	}
+	if hot.LastAccess.Before(start) || hot.LastWrite.Before(start) || hot.LastAccess.Before(hot.LastWrite) {
+		t.Errorf("/d/hot: bad access times %+v", hot)
+	}
+	if stats["/link"] != hot {
+		t.Errorf("hard link has different statistics: %+v", stats["/link"])
+	}
+	if cold := stats["/cold"]; cold.Opens != 1 || cold.Reads != 0 || cold.Writes != 1 {
+		t.Errorf("/cold: %+v", cold)
+	}
+
+	// directories have no contents to count accesses to
+	root, err := fs.Open("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer root.Close()
+	if _, err := root.Read(make([]byte, 1)); !errors.Is(err, absfs.ErrIsDir) {
+		t.Errorf("reading /: got %v, want %v", err, absfs.ErrIsDir)
+	}
+}
+
+func TestRemoveAllContext(t *testing.T) {
+	fs := NewFS()
+	if err := fs.MkdirAll("/secrets/a/b", 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/secrets/x", "/secrets/a/y", "/secrets/a/b/z"} {
+		if err := ioutil.WriteFile(fs, name, []byte("secret"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	node, err := fs.fileStat("/", "/secrets/a/b/z")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext := fs.data[node.Ino].ciphertext
+
+	// cancel after the first entry is removed
+	ctx, cancel := context.WithCancel(context.Background())
+	var counts []int
+	err = fs.RemoveAllContext(ctx, "/secrets", func(removed int) {
+		counts = append(counts, removed)
+		cancel()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if len(counts) != 1 {
+		t.Fatalf("removed %v after cancel", counts)
+	}
+	if _, err := fs.Stat("/secrets/a/b/z"); !os.IsNotExist(err) {
+		t.Errorf("deepest file wasn't removed first: %v", err)
+	}
+	if _, err := fs.Stat("/secrets/x"); err != nil {
+		t.Errorf("file was removed after cancel: %v", err)
+	}
+	for _, b := range ciphertext {
+		if b != 0 {
+			t.Fatal("ciphertext of removed file wasn't wiped")
+		}
+	}
+
+	// contents shared with snapshots aren't wiped
+	id, err := fs.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts = nil
+	err = fs.RemoveAllContext(context.Background(), "/secrets", func(removed int) {
+		counts = append(counts, removed)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(counts) != 5 || counts[4] != 5 {
+		t.Errorf("wrong progress %v", counts)
+	}
+	if _, err := fs.Stat("/secrets"); !os.IsNotExist(err) {
+		t.Errorf("expected /secrets to be removed, got %v", err)
+	}
+	f, err := fs.OpenAt(id, "/secrets/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if b, err := io.ReadAll(f); err != nil || string(b) != "secret" {
+		t.Errorf("snapshot read %q, %v", b, err)
+	}
+}
+
+func TestAttrAll(t *testing.T) {
+	fs := NewFS()
+	if err := fs.MkdirAll("/tree/dir", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/tree/dir/f", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("/tree/dir/f", "/tree/link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/outside", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	when := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := fs.ChmodAll("/tree", 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.ChownAll("/tree", 1000, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.ChtimesAll("/tree", when, when); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/tree", "/tree/dir", "/tree/dir/f"} {
+		info, err := fs.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0750 {
+			t.Errorf("%s: mode %v, want 0750", name, info.Mode())
+		}
+		if info.IsDir() != (name != "/tree/dir/f") {
+			t.Errorf("%s: type changed to %v", name, info.Mode())
+		}
+		if !info.ModTime().Equal(when) {
+			t.Errorf("%s: mtime %v, want %v", name, info.ModTime(), when)
+		}
+		node, err := fs.fileStat("/", name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node.Uid != 1000 || node.Gid != 1000 {
+			t.Errorf("%s: owner %d:%d, want 1000:1000", name, node.Uid, node.Gid)
+		}
+	}
+	if info, err := fs.Lstat("/tree/link"); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("link changed: %v, %v", info, err)
+	}
+	if info, err := fs.Stat("/outside"); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("file outside the tree changed: %v, %v", info, err)
+	}
+	if err := fs.VerifyAll(); err != nil {
+		t.Error(err)
+	}
+	if err := fs.ChmodAll("/missing", 0700); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
+
+func TestFileChtimes(t *testing.T) {
+	fs := NewFS()
+	f, err := fs.Create("/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2021, 6, 1, 12, 0, 0, 123456789, time.UTC)
+	atime := mtime.Add(time.Nanosecond)
+	if err := f.(*File).Chtimes(atime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.(*File).Chtimes(atime, mtime); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("expected os.ErrClosed, got %v", err)
+	}
+
+	info, err := fs.Stat("/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(mtime) {
+		t.Errorf("wrong mtime %v, want %v", info.ModTime(), mtime)
+	}
+	if got := info.(*FileInfo).AccessTime(); !got.Equal(atime) {
+		t.Errorf("wrong atime %v, want %v", got, atime)
+	}
+	if err := fs.VerifyAll(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFileAttrs(t *testing.T) {
+	fs := NewFS()
+	f1, err := fs.Create("/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f1.Close()
+	f2, err := fs.OpenFile("/f", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f2.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := f2.(absfs.AttrFile).Chmod(0640 | os.ModeSetgid); err != nil {
+		t.Fatal(err)
+	}
+	if err := f2.(absfs.AttrFile).Chown(1000, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := f2.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := f1.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 5 {
+		t.Errorf("stat through other handle: size %d, want 5", info.Size())
+	}
+	if info.Mode() != 0640|os.ModeSetgid {
+		t.Errorf("wrong mode %v", info.Mode())
+	}
+	node := info.Sys().(*inode.Inode)
+	if node.Uid != 1000 || node.Gid != 100 {
+		t.Errorf("owner %d:%d, want 1000:100", node.Uid, node.Gid)
+	}
+	if _, err := f2.Stat(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Stat on closed file: expected os.ErrClosed, got %v", err)
+	}
+	if err := f2.(absfs.AttrFile).Chmod(0600); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Chmod on closed file: expected os.ErrClosed, got %v", err)
+	}
+	if err := fs.VerifyAll(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFd(t *testing.T) {
+	fs := NewFS()
+	f1, err := fs.Create("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f2, err := fs.Open("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd1, fd2 := f1.(*File).Fd(), f2.(*File).Fd()
+	if fd1 < 3 || fd1 == fd2 {
+		t.Fatalf("bad descriptors %d and %d", fd1, fd2)
+	}
+	if f, ok := fs.LookupFd(fd1); !ok || f != f1 {
+		t.Errorf("LookupFd(%d) = %v, %v", fd1, f, ok)
+	}
+
+	if err := f1.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fs.LookupFd(fd1); ok {
+		t.Error("closed file is still in the descriptor table")
+	}
+	f3, err := fs.Open("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f3.Close()
+	if fd := f3.(*File).Fd(); fd == fd1 || fd == fd2 {
+		t.Errorf("descriptor %d was reused", fd)
+	}
+	if f2.(*File).Fd() != fd2 {
+		t.Error("descriptor changed")
+	}
+}
+
+func TestListOpenFiles(t *testing.T) {
+	fs := NewFS()
+	if err := fs.Mkdir("/dir", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Chdir("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	before := time.Now()
+	f1, err := fs.Create("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f1.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	f2, err := fs.Open("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handles := fs.ListOpenFiles()
+	if len(handles) != 2 {
+		t.Fatalf("expected 2 open files, got %v", handles)
+	}
+	h := handles[0]
+	if h.Fd != f1.(*File).Fd() || h.Path != "/dir/secret" || h.Flags&os.O_RDWR == 0 || h.Offset != 3 || h.Opened.Before(before) {
+		t.Errorf("wrong handle %+v", h)
+	}
+	if handles[1].Path != "/dir" {
+		t.Errorf("wrong handle %+v", handles[1])
+	}
+
+	f1.Close()
+	f2.Close()
+	if handles := fs.ListOpenFiles(); len(handles) != 0 {
+		t.Errorf("closed files still listed: %v", handles)
+	}
+}
+
+func TestDumpTree(t *testing.T) {
+	fs := NewFS()
+	if err := fs.MkdirAll("/a/b", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/a/b/f", []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("/a/b/f", "/a/l"); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := fs.DumpTree(&buf, DumpOptions{Root: "/a"}); err != nil {
+		t.Fatal(err)
+	}
+	want := "/a [ino 2, drwx------, nlink 3, size 0]\n" +
+		"├── b [ino 3, drwx------, nlink 2, size 0]\n" +
+		"│   └── f [ino 4, -rw-------, nlink 1, size 6]\n" +
+		"└── l -> /a/b/f [ino 5, Lrw-------, nlink 1, size 0]\n"
+	if buf.String() != want {
+		t.Errorf("wrong tree:\n%s\nwant:\n%s", buf.String(), want)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Error("contents were dumped")
+	}
+
+	buf.Reset()
+	if err := fs.DumpTree(&buf, DumpOptions{JSON: true, Depth: 1}); err != nil {
+		t.Fatal(err)
+	}
+	var root DumpNode
+	if err := json.Unmarshal(buf.Bytes(), &root); err != nil {
+		t.Fatal(err)
+	}
+	if root.Name != "/" || len(root.Children) != 1 || root.Children[0].Name != "a" || len(root.Children[0].Children) != 0 {
+		t.Errorf("wrong JSON tree %+v", root)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	fs := NewFS()
+	if err := fs.MkdirAll("/a/b", 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/a/f", "/removed", "/open"} {
+		if err := ioutil.WriteFile(fs, name, []byte("secret"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.Symlink("/a/f", "/link"); err != nil {
+		t.Fatal(err)
+	}
+	if errs := fs.Check(); len(errs) != 0 {
+		t.Fatalf("fresh tree is inconsistent: %v", errs)
+	}
+
+	f, err := fs.Open("/open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, name := range []string{"/removed", "/open"} {
+		if err := fs.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	node, err := fs.fileStat("/", "/a/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.Nlink = 5
+	if fs.symlinks[node.Ino], err = fs.sealTarget(node.Ino, "/nowhere"); err != nil {
+		t.Fatal(err)
+	}
+	link, err := fs.root.Resolve("link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(fs.symlinks, link.Ino)
+	dir, err := fs.fileStat("/", "/a/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range dir.Dir {
+		if e.Name == ".." {
+			e.Inode = fs.root
+		}
+	}
+
+	errs := fs.Repair()
+	var got []string
+	for _, err := range errs {
+		got = append(got, err.Error())
+	}
+	want := []string{
+		"check #4: symbolic link target recorded for an inode that isn't a link",
+		"check #5: contents belong to a file that no longer exists",
+		"check /a/b/..: .. doesn't link to the parent directory",
+		"check /a/f: link count doesn't match the directory entries",
+		"check /link: symbolic link has no target",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("found:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if errs := fs.Check(); len(errs) != 0 {
+		t.Errorf("repaired tree is inconsistent: %v", errs)
+	}
+	if err := fs.VerifyAll(); err != nil {
+		t.Error(err)
+	}
+	if _, err := fs.Lstat(Join(LostFound, fmt.Sprintf("#%d", link.Ino))); err != nil {
+		t.Errorf("link wasn't quarantined: %v", err)
+	}
+	if b, err := io.ReadAll(f); err != nil || string(b) != "secret" {
+		t.Errorf("open removed file read %q, %v", b, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	fs := NewFS()
+	if err := fs.MkdirAll("/dir/sub", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/file", []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err := fs.Remove("/dir")
+	if !errors.Is(err, absfs.ErrNotEmpty) || !errors.Is(err, syscall.ENOTEMPTY) {
+		t.Errorf("removing non-empty directory: %v", err)
+	}
+	var perr *os.PathError
+	if !errors.As(err, &perr) || perr.Op != "remove" || perr.Path != "/dir" {
+		t.Errorf("removing non-empty directory returned %#v", err)
+	}
+
+	if err := fs.Chdir("/file"); !errors.Is(err, absfs.ErrNotDir) {
+		t.Errorf("chdir to file: %v", err)
+	}
+	if _, err := fs.ReadDirMatch("/file", "*"); !errors.Is(err, absfs.ErrNotDir) {
+		t.Errorf("readdir of file: %v", err)
+	}
+	f, err := fs.Open("/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Readdir(-1); !errors.Is(err, absfs.ErrNotDir) {
+		t.Errorf("Readdir of file: %v", err)
+	}
+	f.Close()
+
+	id, err := fs.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err = fs.OpenAt(id, "/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := f.(*File).Chmod(0644); !errors.Is(err, absfs.ErrReadOnly) || !errors.Is(err, syscall.EROFS) {
+		t.Errorf("chmod of snapshot file: %v", err)
+	}
+	if err := f.Truncate(0); !errors.Is(err, absfs.ErrReadOnly) {
+		t.Errorf("truncate of snapshot file: %v", err)
+	}
+}
+
+func TestStatWithoutDecryption(t *testing.T) {
+	fs := NewFS()
+	if err := fs.SetTiering(2*TierBlockSize, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir("/dir", 0700); err != nil {
+		t.Fatal(err)
+	}
+	sizes := map[string]int64{"/dir/small": 10, "/dir/big": 3*TierBlockSize + 1}
+	for name, size := range sizes {
+		if err := ioutil.WriteFile(fs, name, make([]byte, size), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := fs.OpenFile("/dir/small", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fs.unseals = 0
+	for name, size := range sizes {
+		for _, stat := range []func(string) (os.FileInfo, error){fs.Stat, fs.Lstat} {
+			info, err := stat(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() != size {
+				t.Errorf("%s: size %d, want %d", name, info.Size(), size)
+			}
+		}
+	}
+	if info, err := f.Stat(); err != nil || info.Size() != 10 {
+		t.Errorf("File.Stat: %v, %v", info, err)
+	}
+	if _, err := fs.ReadDirMatch("/dir", "*"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.DumpTree(io.Discard, DumpOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if errs := fs.Check(); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if err := f.Truncate(10); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Truncate("/dir/big", 0); err != nil {
+		t.Fatal(err)
+	}
+	if fs.unseals != 0 {
+		t.Errorf("metadata operations decrypted contents %d times", fs.unseals)
+	}
+
+	for _, name := range []string{"/dir/small", "/dir/big"} {
+		if info, err := fs.Stat(name); err != nil || info.Size() != 0 {
+			t.Errorf("%s after truncating: %v, %v", name, info, err)
+		}
+	}
+	if _, err := f.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.ReadAt(make([]byte, 4), 0); err != nil {
+		t.Fatal(err)
+	}
+	if fs.unseals == 0 {
+		t.Error("reading didn't decrypt contents")
+	}
+	if err := fs.VerifyAll(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClone(t *testing.T) {
+	fs := NewFS()
+	dir := t.TempDir()
+	if err := fs.SetTiering(2*TierBlockSize, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Mkdir("/dir", 0700); err != nil {
+		t.Fatal(err)
+	}
+	small := []byte("small file")
+	big := bytes.Repeat([]byte("0123456789abcdef"), 3*TierBlockSize/16)
+	for name, data := range map[string][]byte{"/small": small, "/big": big} {
+		if err := ioutil.WriteFile(fs, name, data, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs.unseals = 0
+	for _, name := range []string{"small", "big"} {
+		if err := fs.Clone("/"+name, "/dir/"+name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fs.unseals != 0 {
+		t.Errorf("cloning decrypted contents %d times", fs.unseals)
+	}
+	info, err := fs.Stat("/dir/small")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0640&fs.Umask || info.Size() != int64(len(small)) {
+		t.Errorf("clone has mode %v and size %d", info.Mode(), info.Size())
+	}
+
+	// writing either file leaves the other as it was
+	if err := ioutil.WriteFile(fs, "/dir/small", []byte("changed"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(fs, "/small"); err != nil || !bytes.Equal(b, small) {
+		t.Errorf("source of written clone reads %q, %v", b, err)
+	}
+	if err := fs.RemoveAllContext(context.Background(), "/big", nil); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(fs, "/dir/big"); err != nil || !bytes.Equal(b, big) {
+		t.Errorf("clone of removed tiered file reads %d bytes, %v", len(b), err)
+	}
+	if err := ioutil.WriteFile(fs, "/dir/big", []byte("changed"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if tiered, _ := filepath.Glob(filepath.Join(dir, "*", "*")); len(tiered) != 0 {
+		t.Errorf("tier files left behind: %v", tiered)
+	}
+
+	if err := fs.Clone("/small", "/dir/small"); !errors.Is(err, os.ErrExist) {
+		t.Errorf("cloning onto an existing file: %v", err)
+	}
+	if err := fs.Clone("/dir", "/dir2"); !errors.Is(err, absfs.ErrIsDir) {
+		t.Errorf("cloning a directory: %v", err)
+	}
+	if err := fs.Clone("/missing", "/dir2"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("cloning a missing file: %v", err)
+	}
+	if errs := fs.Check(); len(errs) != 0 {
+		t.Error(errs)
+	}
+	if err := fs.VerifyAll(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	fs := NewFS()
+	dir := t.TempDir()
+	if err := fs.SetTiering(1000, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SetDedup(4096); err != nil {
+		t.Fatal(err)
+	}
+
+	// every 4096 byte block of big holds the same bytes
+	big := make([]byte, 4*4096+100)
+	for i := range big {
+		big[i] = byte(i % 4096 % 251)
+	}
+	for _, name := range []string{"/a", "/b"} {
+		if err := ioutil.WriteFile(fs, name, big, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := fs.Stats()
+	if stats.Blocks != 2 || stats.BlockRefs != 10 {
+		t.Errorf("expected 2 blocks referred to 10 times, got %+v", stats)
+	}
+	if stats.LogicalBytes != 2*int64(len(big)) || stats.StoredBytes != 4096+100 {
+		t.Errorf("wrong sizes: %+v", stats)
+	}
+	if saved := stats.Saved(); saved != 2*int64(len(big))-4196 {
+		t.Errorf("saved %d bytes", saved)
+	}
+
+	got, err := ioutil.ReadFile(fs, "/b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, big) {
+		t.Error("ReadFile returned the wrong data")
+	}
+	f, err := fs.Open("/a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 200)
+	if n, err := f.ReadAt(p, 4000); err != nil || n != len(p) {
+		t.Fatalf("ReadAt: %d, %v", n, err)
+	}
+	if !bytes.Equal(p, big[4000:4200]) {
+		t.Error("ReadAt across blocks returned the wrong data")
+	}
+	f.Close()
+
+	// snapshots keep the blocks of removed files
+	id, err := fs.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/a", "/b"} {
+		if err := fs.RemoveAllContext(context.Background(), name, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := fs.Stats(); stats.Blocks != 2 {
+		t.Errorf("blocks were removed while a snapshot refers to them: %+v", stats)
+	}
+	if err := fs.DeleteSnapshot(id); err != nil {
+		t.Fatal(err)
+	}
+	if stats := fs.Stats(); stats.Blocks != 0 || stats.BlockRefs != 0 {
+		t.Errorf("blocks were not removed: %+v", stats)
+	}
+	blocks, _ := filepath.Glob(filepath.Join(dir, "*", "*"))
+	if len(blocks) != 0 {
+		t.Errorf("block files were not removed: %v", blocks)
+	}
+
+	if err := NewPlainFS().SetDedup(4096); err != ErrPlainSpill {
+		t.Errorf("SetDedup on a plain FileSystem: %v", err)
+	}
+}
+
+func TestReadDirPlus(t *testing.T) {
+	fs := NewFS()
+	if err := fs.MkdirAll("/d/sub", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/d/b", []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("/d/b", "/d/a"); err != nil {
+		t.Fatal(err)
+	}
+
+	infos, err := fs.ReadDirPlus("/d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, info := range infos {
+		got = append(got, fmt.Sprintf("%s %d %v", info.Name(), info.Size(), info.IsDir()))
+	}
+	if want := "a 0 false|b 5 false|sub 0 true"; strings.Join(got, "|") != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+	if infos[0].Mode()&os.ModeSymlink == 0 {
+		t.Error("symbolic link was followed")
+	}
+
+	// walking uses ReadDirPlus and sees the same tree
+	var walked []string
+	err = ioutil.Walk(fs, "/d", func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, p)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "/d /d/a /d/b /d/sub"; strings.Join(walked, " ") != want {
+		t.Errorf("walked %v, want %s", walked, want)
+	}
+
+	if _, err := fs.ReadDirPlus("/d/b"); !errors.Is(err, absfs.ErrNotDir) {
+		t.Errorf("listing a file: %v", err)
+	}
+	if _, err := fs.ReadDirPlus("/missing"); !os.IsNotExist(err) {
+		t.Errorf("listing a missing directory: %v", err)
+	}
+}
+
+func TestStatMany(t *testing.T) {
+	fs := NewFS()
+	if err := ioutil.WriteFile(fs, "/a", []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("/a", "/link"); err != nil {
+		t.Fatal(err)
+	}
+
+	results := fs.StatMany([]string{"/a", "/missing", "/link", "/"})
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(results))
+	}
+	if info, err := results[0].Info, results[0].Err; err != nil || info.Name() != "a" || info.Size() != 5 {
+		t.Errorf("/a: %v, %v", info, err)
+	}
+	if !os.IsNotExist(results[1].Err) || results[1].Info != nil {
+		t.Errorf("/missing: %v, %v", results[1].Info, results[1].Err)
+	}
+	if info, err := results[2].Info, results[2].Err; err != nil || info.Size() != 5 || info.Mode()&os.ModeSymlink != 0 {
+		t.Errorf("links weren't followed: %v, %v", info, err)
+	}
+	if info := results[3].Info; info == nil || !info.IsDir() {
+		t.Errorf("/: %v", info)
+	}
+}
+
+func TestCopyAll(t *testing.T) {
+	fs := NewFS()
+	if err := fs.MkdirAll("/src/sub", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Chmod("/src/sub", os.ModeDir|0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/src/sub/a", []byte("secret"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Link("/src/sub/a", "/src/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("sub/a", "/src/link"); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := fs.Chtimes("/src/sub/a", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	before := fs.unseals
+	if err := fs.CopyAll("/src", "/dst"); err != nil {
+		t.Fatal(err)
+	}
+	if fs.unseals != before {
+		t.Error("contents were decrypted while copying")
+	}
+
+	got, err := ioutil.ReadFile(fs, "/dst/link")
+	if err != nil || string(got) != "secret" {
+		t.Errorf("reading through the copied link: %q, %v", got, err)
+	}
+	info, err := fs.Lstat("/dst/link")
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("link wasn't copied as a link: %v, %v", info, err)
+	}
+	if info, err := fs.Stat("/dst/sub"); err != nil || info.Mode() != os.ModeDir|0750 {
+		t.Errorf("directory mode: %v, %v", info, err)
+	}
+	info, err = fs.Stat("/dst/sub/a")
+	if err != nil || info.Mode() != 0640 || !info.ModTime().Equal(mtime) {
+		t.Errorf("file mode and time: %v, %v", info, err)
+	}
+	b, err := fs.Stat("/dst/b")
+	if err != nil || !SameFile(info.(*FileInfo), b.(*FileInfo)) {
+		t.Error("hard links weren't kept")
+	}
+	if src, _ := fs.Stat("/src/b"); SameFile(src.(*FileInfo), b.(*FileInfo)) {
+		t.Error("copy is linked to the original")
+	}
+
+	// the copy is independent of the original
+	if err := ioutil.WriteFile(fs, "/dst/sub/a", []byte("changed"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(fs, "/src/sub/a"); string(got) != "secret" {
+		t.Errorf("writing the copy changed the original to %q", got)
+	}
+	if got, _ := ioutil.ReadFile(fs, "/dst/b"); string(got) != "changed" {
+		t.Errorf("hard link in the copy reads %q", got)
+	}
+
+	if err := fs.CopyAll("/src", "/dst"); !os.IsExist(err) {
+		t.Errorf("copying onto an existing file: %v", err)
+	}
+	if err := fs.CopyAll("/src", "/src/sub/inner"); !errors.Is(err, absfs.ErrInvalid) {
+		t.Errorf("copying into itself: %v", err)
+	}
+	if err := fs.CopyAll("/missing", "/x"); !os.IsNotExist(err) {
+		t.Errorf("copying a missing tree: %v", err)
+	}
+}
+
+func TestMkdirAll(t *testing.T) {
+	fs := NewFS()
+	if err := fs.MkdirAll("/a/b/c", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := fs.Stat("/a/b/c"); err != nil || info.Mode() != os.ModeDir|0700 {
+		t.Errorf("created %v, %v", info, err)
+	}
+	// existing directories are skipped
+	if err := fs.MkdirAll("/a/b/c/d", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.MkdirAll("/a/b", 0700); err != nil {
+		t.Errorf("MkdirAll of an existing directory: %v", err)
+	}
+
+	if err := ioutil.WriteFile(fs, "/a/file", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	err := fs.MkdirAll("/a/file/sub", 0700)
+	var perr *os.PathError
+	if !errors.As(err, &perr) || perr.Path != "/a/file" || !errors.Is(err, absfs.ErrNotDir) {
+		t.Errorf("MkdirAll through a file: %v", err)
+	}
+	if err := fs.MkdirAll("/a/file", 0700); !errors.Is(err, absfs.ErrNotDir) {
+		t.Errorf("MkdirAll of a file: %v", err)
+	}
+	if err := fs.Mkdir("/a/file/sub", 0700); !errors.Is(err, absfs.ErrNotDir) {
+		t.Errorf("Mkdir in a file: %v", err)
+	}
+
+	// directories are created in the targets of links
+	if err := fs.Symlink("/a/b", "/link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.MkdirAll("/link/e/f", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := fs.Stat("/a/b/e/f"); err != nil || !info.IsDir() {
+		t.Errorf("directory wasn't created in the link's target: %v, %v", info, err)
+	}
+}
+
+func TestSealedSymlinks(t *testing.T) {
+	fs := NewFS()
+	target := "/home/alice/projects/secret-project"
+	if err := fs.Symlink(target, "/link"); err != nil {
+		t.Fatal(err)
+	}
+	for _, ciphertext := range fs.symlinks {
+		if bytes.Contains(ciphertext, []byte("alice")) {
+			t.Fatal("symbolic link target is stored in the clear")
+		}
+	}
+	if got, err := fs.Readlink("/link"); err != nil || got != target {
+		t.Errorf("Readlink: %q, %v", got, err)
+	}
+
+	// targets are bound to their links
+	node, err := fs.root.Resolve("link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("/other", "/link2"); err != nil {
+		t.Fatal(err)
+	}
+	other, err := fs.root.Resolve("link2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs.symlinks[other.Ino] = fs.symlinks[node.Ino]
+	if got, _ := fs.Readlink("/link2"); got != "" {
+		t.Errorf("target moved between links read as %q", got)
+	}
+
+	// snapshots keep the targets of links
+	if err := ioutil.WriteFile(fs, "/f", []byte("data"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("/f", "/flink"); err != nil {
+		t.Fatal(err)
+	}
+	id, err := fs.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.OpenAt(id, "/flink")
+	if err != nil {
+		t.Fatalf("opening a link in a snapshot: %v", err)
+	}
+	defer f.Close()
+	if got, err := io.ReadAll(f); err != nil || string(got) != "data" {
+		t.Errorf("reading a link in a snapshot: %q, %v", got, err)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	fs := NewFS()
+	if err := fs.MkdirAll("/etc/app", 0750); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/etc/app/token", []byte("s3cret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Link("/etc/app/token", "/etc/token"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("/etc/app/token", "/app"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Setxattr("/etc/app/token", "user.owner", []byte("ops")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Tag("/etc/app/token", "env", "prod"); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	if err := fs.Chtimes("/etc/app/token", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	key := seal.NewKey()
+	var buf bytes.Buffer
+	if err := fs.Save(&buf, key); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("s3cret")) {
+		t.Fatal("image isn't encrypted")
+	}
+	image := append([]byte(nil), buf.Bytes()...)
+
+	loaded, err := Load(&buf, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(loaded, "/app"); err != nil || string(got) != "s3cret" {
+		t.Errorf("reading through the restored link: %q, %v", got, err)
+	}
+	if target, err := loaded.Readlink("/app"); err != nil || target != "/etc/app/token" {
+		t.Errorf("Readlink: %q, %v", target, err)
+	}
+	info, err := loaded.Stat("/etc/app/token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0600 || info.Size() != 6 || !info.ModTime().Equal(mtime) {
+		t.Errorf("metadata wasn't restored: %v %d %v", info.Mode(), info.Size(), info.ModTime())
+	}
+	if node := info.Sys().(*inode.Inode); node.Nlink != 2 {
+		t.Errorf("link count %d, want 2", node.Nlink)
+	}
+	if other, _ := loaded.Stat("/etc/token"); !SameFile(info.(*FileInfo), other.(*FileInfo)) {
+		t.Error("hard link was restored as a copy")
+	}
+	if owner, err := loaded.Getxattr("/etc/app/token", "user.owner"); err != nil || string(owner) != "ops" {
+		t.Errorf("extended attribute: %q, %v", owner, err)
+	}
+	if got := loaded.FindByTag("env", "prod"); len(got) != 2 {
+		t.Errorf("tags weren't restored: %v", got)
+	}
+	if err := loaded.VerifyAll(); err != nil {
+		t.Errorf("restored metadata doesn't verify: %v", err)
+	}
+	if errs := loaded.Check(); len(errs) != 0 {
+		t.Errorf("restored tree is inconsistent: %v", errs)
+	}
+
+	// new files don't reuse restored inode numbers
+	if err := ioutil.WriteFile(loaded, "/new", []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := ioutil.ReadFile(loaded, "/etc/token"); string(got) != "s3cret" {
+		t.Errorf("creating a file changed a restored one to %q", got)
+	}
+
+	if _, err := Load(bytes.NewReader(image), seal.NewKey()); err != ErrBadImage {
+		t.Errorf("loading with the wrong key: %v", err)
+	}
+	if _, err := Load(strings.NewReader("not an image"), key); err != ErrBadImage {
+		t.Errorf("loading garbage: %v", err)
+	}
+	newer := append([]byte(nil), image...)
+	newer[len(imageMagic)+3]++
+	if _, err := Load(bytes.NewReader(newer), key); err != ErrImageVersion {
+		t.Errorf("loading a newer image: %v", err)
+	}
+}
+
+func TestSaveLoadSigned(t *testing.T) {
+	fs := NewFS()
+	if err := ioutil.WriteFile(fs, "/token", []byte("s3cret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := seal.NewKey()
+	var buf bytes.Buffer
+	if err := fs.SaveSigned(&buf, key, priv); err != nil {
+		t.Fatal(err)
+	}
+	image := buf.Bytes()
+
+	loaded, err := LoadSigned(bytes.NewReader(image), key, pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(loaded, "/token"); err != nil || string(got) != "s3cret" {
+		t.Errorf("ReadFile: %q, %v", got, err)
+	}
+
+	other, _, _ := ed25519.GenerateKey(nil)
+	if _, err := LoadSigned(bytes.NewReader(image), key, other); err != ErrBadSignature {
+		t.Errorf("loading with the wrong public key: %v", err)
+	}
+	tampered := append([]byte(nil), image...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := LoadSigned(bytes.NewReader(tampered), key, pub); err != ErrBadSignature {
+		t.Errorf("loading a tampered image: %v", err)
+	}
+	if _, err := Load(bytes.NewReader(image), key); err != ErrBadImage {
+		t.Errorf("loading a signed image unverified: %v", err)
+	}
+}
+
+func TestSnapshotXattrs(t *testing.T) {
+	fs := NewFS()
+	if err := ioutil.WriteFile(fs, "/f", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Setxattr("/f", "user.a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	id, err := fs.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Setxattr("/f", "user.a", []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	attrs, err := fs.XattrsAt(id, "/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(attrs["user.a"]) != "1" {
+		t.Errorf("snapshot has %q", attrs["user.a"])
+	}
+}
+
+func TestCloneFS(t *testing.T) {
+	fs := NewFS()
+	fs.Umask = 0700
+	if err := fs.MkdirAll("/work", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/work/state", []byte("original"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("/work/state", "/state"); err != nil {
+		t.Fatal(err)
+	}
+
+	clone, err := fs.CloneFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clone.Umask != 0700 {
+		t.Errorf("Umask %v wasn't copied", clone.Umask)
+	}
+	if got, err := ioutil.ReadFile(clone, "/state"); err != nil || string(got) != "original" {
+		t.Errorf("clone reads %q, %v", got, err)
+	}
+
+	// the clone shares neither keys nor contents
+	info, err := fs.Stat("/work/state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ino := info.Sys().(*inode.Inode).Ino
+	orig, cloned := fs.data[ino], clone.data[ino]
+	if orig.key == cloned.key || bytes.Equal(orig.ciphertext, cloned.ciphertext) {
+		t.Error("clone shares sealed contents")
+	}
+	if fs.metaKey == clone.metaKey || fs.linkKey == clone.linkKey {
+		t.Error("clone shares keys")
+	}
+
+	if err := ioutil.WriteFile(clone, "/work/state", []byte("changed"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := clone.Remove("/state"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(fs, "/state"); err != nil || string(got) != "original" {
+		t.Errorf("changing the clone changed the original: %q, %v", got, err)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	now := time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	fs := NewFS(
+		WithUmask(0700),
+		WithTempDir("/scratch"),
+		WithClock(func() time.Time { return now }),
+		WithConcurrency(4),
+	)
+	if fs.TempDir() != "/scratch" {
+		t.Errorf("TempDir %q", fs.TempDir())
+	}
+	if err := ioutil.WriteFile(fs, "/f", []byte("needle in a haystack"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	info, err := fs.Stat("/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode() != 0600 || !info.ModTime().Equal(now) {
+		t.Errorf("mode %v, mtime %v", info.Mode(), info.ModTime())
+	}
+	for i := 0; i < 10; i++ {
+		if err := ioutil.WriteFile(fs, fmt.Sprintf("/hay%d", i), []byte("haystack"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.EnableIndex(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.Search("needle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "/f" {
+		t.Errorf("Search: %v", got)
+	}
+
+	plain := NewFS(WithCipher(NoCipher), WithMemoryBudget(1, t.TempDir()))
+	if !plain.plain || plain.budget != 0 {
+		t.Error("NoCipher filesystem is sealed or spills")
+	}
+
+	dir := t.TempDir()
+	random := make([]byte, 1024)
+	for i := range random {
+		random[i] = byte(i)
+	}
+	fs = NewFS(WithMemoryBudget(4000, dir), WithRand(bytes.NewReader(random)))
+	for i := 0; i < 3; i++ {
+		if err := ioutil.WriteFile(fs, fmt.Sprintf("/f%d", i), bytes.Repeat([]byte{'x'}, 3000), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache, _ := filepath.Glob(filepath.Join(dir, "*", "*"))
+	if len(cache) == 0 {
+		t.Fatal("no files were spilled")
+	}
+	for _, name := range cache {
+		if !strings.Contains(fmt.Sprintf("%x", random), filepath.Base(name)) {
+			t.Errorf("%s wasn't named from the given source", name)
+		}
+	}
+	if err := fs.SetMemoryBudget(0, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	fs := NewFS(WithCaseInsensitive())
+	if err := fs.MkdirAll("/Etc/App", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/etc/app/Token", []byte("s3cret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(fs, "/ETC/APP/TOKEN"); err != nil || string(got) != "s3cret" {
+		t.Errorf("ReadFile: %q, %v", got, err)
+	}
+	if err := ioutil.WriteFile(fs, "/etc/app/token", []byte("new"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	infos, err := fs.ReadDirPlus("/etc/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Name() != "Token" {
+		t.Fatalf("writing through another case didn't keep the file: %v", infos)
+	}
+
+	if err := fs.Rename("/etc/app/Token", "/etc/app/TOKEN"); err != nil {
+		t.Fatal(err)
+	}
+	infos, _ = fs.ReadDirPlus("/etc/app")
+	if len(infos) != 1 || infos[0].Name() != "TOKEN" {
+		t.Fatalf("case wasn't changed by renaming: %v", infos)
+	}
+	if got, _ := ioutil.ReadFile(fs, "/etc/app/token"); string(got) != "new" {
+		t.Errorf("renamed file reads %q", got)
+	}
+
+	clone, err := fs.CloneFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := clone.Stat("/etc/APP/token"); err != nil {
+		t.Errorf("clone isn't case insensitive: %v", err)
+	}
+
+	if _, err := NewFS().Stat("/ETC"); !os.IsNotExist(err) {
+		t.Errorf("default filesystem isn't case sensitive: %v", err)
+	}
+}
+
+func TestSealer(t *testing.T) {
+	dir := t.TempDir()
+	fs := NewFS(WithCipher(AES256GCM), WithMemoryBudget(1000, dir))
+	if fs.sealer != seal.AESGCM {
+		t.Fatal("AES256GCM didn't select AES-GCM")
+	}
+	for i := 0; i < 3; i++ {
+		if err := ioutil.WriteFile(fs, fmt.Sprintf("/f%d", i), bytes.Repeat([]byte{'a' + byte(i)}, 800), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("/f%d", i)
+		info, err := fs.Stat(name)
+		if err != nil || info.Size() != 800 {
+			t.Fatalf("%s: Stat: %v, %v", name, info, err)
+		}
+		got, err := ioutil.ReadFile(fs, name)
+		if err != nil || !bytes.Equal(got, bytes.Repeat([]byte{'a' + byte(i)}, 800)) {
+			t.Errorf("%s: ReadFile: %v", name, err)
+		}
+	}
+	if err := fs.VerifyAll(); err != nil {
+		t.Error(err)
+	}
+	clone, err := fs.CloneFS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clone.sealer != seal.AESGCM {
+		t.Error("clone doesn't use the same sealer")
+	}
+	if err := fs.SetMemoryBudget(0, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if NewFS(WithCipher(NoCipher), WithSealer(seal.AESGCM)).plain {
+		t.Error("WithSealer left the filesystem plain")
+	}
+}
+
+func TestSectionReader(t *testing.T) {
+	data := make([]byte, 3*TierBlockSize+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	tiered := NewFS()
+	if err := tiered.SetTiering(1000, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer tiered.SetTiering(0, "")
+
+	for name, fs := range map[string]*FileSystem{"sealed": NewFS(), "plain": NewPlainFS(), "tiered": tiered} {
+		if err := ioutil.WriteFile(fs, "/f", data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		for _, section := range [][2]int64{{0, 10}, {TierBlockSize - 5, TierBlockSize + 10}, {int64(len(data)) - 50, 1000}, {int64(len(data)) + 1, 10}} {
+			off, n := section[0], section[1]
+			want := data[min(off, int64(len(data))):min(off+n, int64(len(data)))]
+			r, err := fs.NewSectionReader("/f", off, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(r)
+			if err != nil || !bytes.Equal(got, want) {
+				t.Errorf("%s %v: ReadAll got %d bytes, %v", name, section, len(got), err)
+			}
+			if end, err := r.Seek(0, io.SeekEnd); err != nil || end != int64(len(want)) {
+				t.Errorf("%s %v: Seek to end = %d, %v", name, section, end, err)
+			}
+			if len(want) > 3 {
+				p := make([]byte, 3)
+				if n, err := r.(io.ReaderAt).ReadAt(p, 1); n != 3 || err != nil || !bytes.Equal(p, want[1:4]) {
+					t.Errorf("%s %v: ReadAt = %d, %v", name, section, n, err)
+				}
+			}
+			if err := r.Close(); err != nil {
+				t.Error(err)
+			}
+		}
+	}
+
+	fs := NewFS()
+	if _, err := fs.NewSectionReader("/f", -1, 10); !errors.Is(err, os.ErrInvalid) {
+		t.Errorf("negative offset: %v", err)
+	}
+	if _, err := fs.NewSectionReader("/", 0, 10); err == nil {
+		t.Error("opened a section of a directory")
+	}
+}
+
+func TestSharedView(t *testing.T) {
+	fs := NewFS()
+	if err := ioutil.WriteFile(fs, "/f", []byte("hello world"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fs.unseals = 0
+	var files []absfs.File
+	for i := 0; i < 3; i++ {
+		f, err := fs.Open("/f")
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+		for j := 0; j < 2; j++ {
+			p := make([]byte, 5)
+			if _, err := f.ReadAt(p, int64(j*6)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if fs.unseals != 1 {
+		t.Errorf("3 readers decrypted the contents %d times", fs.unseals)
+	}
+
+	if err := ioutil.WriteFile(fs, "/f", []byte("goodbye"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 7)
+	if n, err := files[0].ReadAt(p, 0); err != nil || string(p[:n]) != "goodbye" {
+		t.Errorf("read %q after a write, %v", p[:n], err)
+	}
+
+	sf := files[0].(*File).data
+	for _, f := range files {
+		f.Close()
+	}
+	if sf.view != nil {
+		t.Error("closing the last reader didn't drop the view")
+	}
+}
+
+func TestWriteThrough(t *testing.T) {
+	dir := t.TempDir()
+	key := seal.NewKey()
+	fs := NewFS()
+	if err := fs.MkdirAll("/a/d", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/a/b", []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("/a/b", "/link"); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 10)
+	wt := &WriteThrough{Dir: dir, Key: key, Delay: 10 * time.Millisecond, OnError: func(err error) { errs <- err }}
+	if err := fs.SetWriteThrough(wt); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(want map[string]string) {
+		t.Helper()
+		loaded, err := LoadWriteThrough(dir, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, contents := range want {
+			switch {
+			case contents == "dir":
+				if info, err := loaded.Stat(name); err != nil || !info.IsDir() {
+					t.Errorf("%s: %v, %v", name, info, err)
+				}
+			case contents == "":
+				if _, err := loaded.Lstat(name); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("%s wasn't removed: %v", name, err)
+				}
+			default:
+				got, err := ioutil.ReadFile(loaded, name)
+				if err != nil || string(got) != contents {
+					t.Errorf("%s = %q, %v", name, got, err)
+				}
+			}
+		}
+	}
+	check(map[string]string{"/a/b": "secret", "/a/d": "dir", "/link": "secret"})
+	entries, err := os.ReadDir(dir)
+	if err != nil || len(entries) != 5 {
+		t.Fatalf("%d entries, %v", len(entries), err)
+	}
+	for _, e := range entries {
+		if data, _ := os.ReadFile(filepath.Join(dir, e.Name())); bytes.Contains(data, []byte("secret")) {
+			t.Errorf("entry %s holds plaintext", e.Name())
+		}
+	}
+
+	if err := ioutil.WriteFile(fs, "/a/c", []byte("more"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Remove("/a/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("/a", "/x"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	check(map[string]string{"/a": "", "/a/c": "", "/x/c": "more", "/x/d": "dir"})
+
+	if err := ioutil.WriteFile(fs, "/x/c", []byte("last"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.SetWriteThrough(nil); err != nil {
+		t.Fatal(err)
+	}
+	check(map[string]string{"/x/c": "last"})
+	if err := ioutil.WriteFile(fs, "/x/c", []byte("unpersisted"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	check(map[string]string{"/x/c": "last"})
+
+	if _, err := LoadWriteThrough(dir, seal.NewKey()); !errors.Is(err, ErrBadEntry) {
+		t.Errorf("loading under the wrong key: %v", err)
+	}
+	select {
+	case err := <-errs:
+		t.Errorf("persisting in the background: %v", err)
+	default:
+	}
+
+	// files are persisted whatever their mode is, and the children of a
+	// moved directory that fail to persist are tried again
+	if err := fs.SetWriteThrough(&WriteThrough{Dir: dir, Key: key, Delay: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/x/locked", []byte("locked"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Chmod("/x/locked", 0); err != nil {
+		t.Fatal(err)
+	}
+	blocker := filepath.Join(dir, fs.writeThrough.Load().entryName("/y/c"))
+	if err := os.Mkdir(blocker, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Rename("/x", "/y"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Flush(); err == nil {
+		t.Error("persisting a child over a directory succeeded")
+	}
+	check(map[string]string{"/x/c": "", "/y/c": ""})
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	check(map[string]string{"/x/c": "", "/y/c": "unpersisted"})
+	loaded, err := LoadWriteThrough(dir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := loaded.contents("/y/locked"); err != nil || string(data) != "locked" {
+		t.Errorf("/y/locked = %q, %v", data, err)
+	}
+	if err := fs.SetWriteThrough(nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	fs := NewFS()
+	if err := fs.MkdirAll("/keys", 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fs, "/keys/tls", []byte("key"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var denied []string
+	fs.SetPolicy(func(op absfs.Op, path string, cred Credentials) error {
+		if strings.HasPrefix(path, "/keys/") && cred.Name != "tls" {
+			denied = append(denied, string(op)+" "+path)
+			return os.ErrPermission
+		}
+		return nil
+	})
+
+	tls := fs.As(Credentials{Name: "tls"})
+	if data, err := ioutil.ReadFile(tls, "/keys/tls"); err != nil || string(data) != "key" {
+		t.Fatalf("reading as tls: %q, %v", data, err)
+	}
+	other := fs.As(Credentials{Name: "web"})
+	if _, err := other.Open("/keys/tls"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("opening as web: got %v, want %v", err, os.ErrPermission)
+	}
+	if err := other.Chdir("/keys"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Stat("tls"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("stat of relative path as web: got %v, want %v", err, os.ErrPermission)
+	}
+	if err := other.Rename("/keys/tls", "/stolen"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("renaming as web: got %v, want %v", err, os.ErrPermission)
+	}
+	if err := ioutil.WriteFile(other, "/public", []byte("hi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/keys/tls"); err != nil {
+		t.Errorf("owner was denied: %v", err)
+	}
+	want := []string{"open /keys/tls", "stat /keys/tls", "rename /keys/tls"}
+	if fmt.Sprint(denied) != fmt.Sprint(want) {
+		t.Errorf("denied %q, want %q", denied, want)
+	}
+	if wd, _ := fs.Getwd(); wd != "/" {
+		t.Errorf("owner's working directory = %q after a view's Chdir, want /", wd)
+	}
+	if wd, _ := tls.Getwd(); wd != "/" {
+		t.Errorf("tls's working directory = %q after web's Chdir, want /", wd)
+	}
+
+	if _, err := other.(absfs.Xattrer).Getxattr("/keys/tls", "user.a"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("getting an attribute as web: got %v, want %v", err, os.ErrPermission)
+	}
+
+	// links must not hand out denied paths
+	if err := other.Symlink("/keys/tls", "/stolen"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("linking to a denied path as web: got %v, want %v", err, os.ErrPermission)
+	}
+	if err := other.Symlink("../keys/tls", "/stolen"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("linking to a relative denied path as web: got %v, want %v", err, os.ErrPermission)
+	}
+	if err := fs.Symlink("/keys", "/link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Symlink("/keys/tls", "/tlslink"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Open("/tlslink"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("opening a link to a denied path as web: got %v, want %v", err, os.ErrPermission)
+	}
+	if _, err := other.Stat("/link/tls"); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("stat through a linked directory as web: got %v, want %v", err, os.ErrPermission)
+	}
+	if err := other.MkdirAll("/link/sub", 0755); !errors.Is(err, os.ErrPermission) {
+		t.Errorf("creating a directory through a link as web: got %v, want %v", err, os.ErrPermission)
+	}
+	if data, err := ioutil.ReadFile(tls, "/tlslink"); err != nil || string(data) != "key" {
+		t.Errorf("reading through a link as tls: %q, %v", data, err)
+	}
+
+	fs.SetPolicy(nil)
+	if _, err := other.Stat("/keys/tls"); err != nil {
+		t.Errorf("stat without a policy: %v", err)
+	}
+}
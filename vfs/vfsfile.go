@@ -1,25 +1,23 @@
 package vfs
 
 import (
+	"container/list"
 	"errors"
 	"io"
 	"os"
 	"path/filepath"
 	"sync"
 	"sync/atomic"
-	"syscall"
 	"time"
 
-	"github.com/awnumar/fastrand"
 	"github.com/awnumar/memguard"
 	"github.com/awnumar/memguard/core"
 
 	"github.com/capnspacehook/pandorasbox/absfs"
 	"github.com/capnspacehook/pandorasbox/inode"
+	"github.com/capnspacehook/pandorasbox/seal"
 )
 
-const keySize = 32
-
 type File struct {
 	mtx sync.RWMutex
 
@@ -32,28 +30,161 @@ type File struct {
 
 	offset    int64
 	diroffset int
+	view      *plainView
+
+	fd     uintptr
+	path   string
+	opened time.Time
 }
 
 type sealedFile struct {
-	f *File
+	f   *File
+	ino uint64
 
 	ciphertext []byte
 	key        *memguard.Enclave
+
+	// spilled is the cache file holding the ciphertext when it has been
+	// moved out of memory, and size is the plaintext size it had
+	spilled string
+	size    int64
+	elem    *list.Element
+
+	// tiered is the file holding the block-encrypted contents of a file
+	// above the tiering threshold, sealed in write generation gen
+	tiered  string
+	gen     uint64
+	tierIno uint64
+
+	// frozen copies of sealed files belong to snapshots and are never
+	// paged in or evicted
+	frozen bool
+
+	// pinned files are kept in memory
+	pinned bool
+
+	// shared ciphertext is also held by frozen copies or clones, so it
+	// mustn't be wiped
+	shared bool
+
+	// borrowed cache and tier files belong to the file sf was cloned from,
+	// so they are released instead of removed
+	borrowed bool
+
+	// view is the plaintext shared by the handles reading sf
+	view *plainView
+
+	stats accessStats
 }
 
+// updateSize records the size of the contents of f in its inode, so sizes
+// can be read without decrypting them.
 func (f *File) updateSize() {
-	if len(f.data.ciphertext) == 0 {
-		f.node.Size = 0
-		return
+	atomic.StoreInt64(&f.node.Size, f.fs.sealedSize(f.data))
+}
+
+// seal stores plaintext in sf, encrypted unless the FileSystem is plain.
+func (fs *FileSystem) seal(sf *sealedFile, plaintext []byte) error {
+	defer fs.invalidateView(sf)
+	if err := fs.indexFile(sf.ino, plaintext); err != nil {
+		return err
+	}
+	if fs.plain {
+		sf.ciphertext = make([]byte, len(plaintext))
+		copy(sf.ciphertext, plaintext)
+		sf.key = nil
+		sf.shared = false
+		return nil
+	}
+
+	fs.spillMtx.Lock()
+	threshold, tierDir, pinned := fs.tierThreshold, fs.tierDir, sf.pinned
+	fs.spillMtx.Unlock()
+	if threshold > 0 && int64(len(plaintext)) > threshold && !pinned {
+		return fs.sealTier(sf, plaintext, tierDir)
 	}
 
-	f.node.Size = int64(len(f.data.ciphertext) - core.Overhead)
+	key := seal.NewKey()
+	ciphertext, err := fs.sealer.Seal(plaintext, key)
+	if err != nil {
+		return err
+	}
+
+	fs.spillMtx.Lock()
+	defer fs.spillMtx.Unlock()
+
+	fs.drop(sf)
+	sf.ciphertext, sf.key = ciphertext, key
+	sf.shared = false
+	fs.touch(sf)
+	fs.evict(sf)
+
+	return nil
+}
+
+// unseal copies the contents of sf into plaintext.
+func (fs *FileSystem) unseal(sf *sealedFile, plaintext []byte) error {
+	if fs.plain {
+		copy(plaintext, sf.ciphertext)
+		return nil
+	}
+
+	if _, tiered, err := fs.readTier(sf, plaintext, 0); tiered {
+		return err
+	}
+	atomic.AddUint64(&fs.unseals, 1)
+
+	var err error
+	fs.spillMtx.Lock()
+	if !sf.frozen {
+		err = fs.pageIn(sf)
+		fs.touch(sf)
+		fs.evict(sf)
+	}
+	ciphertext, key, spilled := sf.ciphertext, sf.key, sf.spilled
+	fs.spillMtx.Unlock()
+	if err != nil {
+		return err
+	}
+	if spilled != "" {
+		// frozen copies are read from the cache without paging them in
+		ciphertext, err = os.ReadFile(spilled)
+		if err != nil {
+			return err
+		}
+	}
+
+	return fs.sealer.Open(ciphertext, key, plaintext)
+}
+
+func (fs *FileSystem) sealedSize(sf *sealedFile) int64 {
+	if fs.plain {
+		return int64(len(sf.ciphertext))
+	}
+
+	fs.spillMtx.Lock()
+	defer fs.spillMtx.Unlock()
+
+	if sf.spilled != "" || sf.tiered != "" {
+		return sf.size
+	}
+	return fs.plainSize(sf.ciphertext)
+}
+
+// plainSize returns the size of the plaintext ciphertext holds.
+func (fs *FileSystem) plainSize(ciphertext []byte) int64 {
+	return int64(max(len(ciphertext)-fs.sealer.Overhead(), 0))
 }
 
 func (f *File) Name() string {
 	return f.name
 }
 
+// readOnly reports whether f belongs to a snapshot, so it can't be changed.
+func (f *File) readOnly() bool {
+	return f.data != nil && f.data.frozen
+}
+
 func (f *File) Read(p []byte) (int, error) {
 	if f.node == nil {
 		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrClosed}
@@ -65,50 +196,51 @@ func (f *File) Read(p []byte) (int, error) {
 		return 0, io.EOF
 	}
 	if f.flags&absfs.O_ACCESS == os.O_WRONLY {
-		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EBADF} //os.ErrPermission
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: absfs.ErrBadFile} //os.ErrPermission
 	}
+	f.data.count(accessRead)
 	if f.node.IsDir() && atomic.LoadInt64(&f.node.Size) == 0 {
-		return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR} //os.ErrPermission
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: absfs.ErrIsDir} //os.ErrPermission
 	}
 	if atomic.LoadInt64(&f.offset) >= atomic.LoadInt64(&f.node.Size) {
 		return 0, io.EOF
 	}
 
-	var (
-		err error
-		key *memguard.LockedBuffer
-	)
+	if atomic.LoadInt64(&f.node.Size) == 0 {
+		return 0, io.EOF
+	}
 
-	if atomic.LoadInt64(&f.node.Size) != 0 {
+	if f.fs.plain {
 		f.mtx.RLock()
-		key, err = f.data.key.Open()
+		n := copy(p, f.data.ciphertext[atomic.LoadInt64(&f.offset):])
 		f.mtx.RUnlock()
-		if err != nil {
-			return 0, err
-		}
-	} else {
-		return 0, io.EOF
+		atomic.AddInt64(&f.offset, int64(n))
+		return n, nil
+	}
+
+	// tiered files are decrypted a block at a time
+	f.mtx.RLock()
+	n, tiered, err := f.fs.readTier(f.data, p, atomic.LoadInt64(&f.offset))
+	f.mtx.RUnlock()
+	if tiered {
+		atomic.AddInt64(&f.offset, int64(n))
+		return n, err
 	}
 
-	plaintext := make([]byte, f.node.Size)
+	// handles reading the file at once share its plaintext
 	f.mtx.RLock()
-	_, err = core.Decrypt(f.data.ciphertext, key.Bytes(), plaintext)
-	key.Destroy()
+	view, err := f.acquireView()
 	f.mtx.RUnlock()
 	if err != nil {
 		return 0, err
 	}
 
+	plaintext := view.buf.Bytes()
 	offset := int(atomic.LoadInt64(&f.offset))
-	core.Copy(p, plaintext[offset:])
-	core.Wipe(plaintext)
-
-	var n int
-	if len(p) < len(plaintext[offset:]) {
-		n = len(p)
-	} else {
-		n = len(plaintext[offset:])
+	if offset >= len(plaintext) {
+		return 0, io.EOF
 	}
+	n = copy(p, plaintext[offset:])
 	atomic.AddInt64(&f.offset, int64(n))
 
 	return n, nil
@@ -134,8 +266,9 @@ func (f *File) Write(p []byte) (int, error) {
 		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrClosed}
 	}
 	if f.flags&absfs.O_ACCESS == os.O_RDONLY {
-		return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EBADF}
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: absfs.ErrBadFile}
 	}
+	f.data.count(accessWrite)
 
 	var (
 		err       error
@@ -144,17 +277,11 @@ func (f *File) Write(p []byte) (int, error) {
 
 	if atomic.LoadInt64(&f.node.Size) != 0 {
 		f.mtx.RLock()
-		key, err := f.data.key.Open()
-		if err != nil {
-			return 0, err
-		}
-		_, err = core.Decrypt(f.data.ciphertext, key.Bytes(), plaintext)
+		err = f.fs.unseal(f.data, plaintext)
+		f.mtx.RUnlock()
 		if err != nil {
 			return 0, err
 		}
-		key.Destroy()
-		f.mtx.RUnlock()
-
 	}
 
 	data := plaintext
@@ -166,14 +293,15 @@ func (f *File) Write(p []byte) (int, error) {
 	}
 
 	core.Copy(data[offset:], p)
-	newKey := memguard.NewBufferFromBytes(fastrand.Bytes(keySize))
 
 	f.mtx.Lock()
-	f.data.ciphertext, err = core.Encrypt(data, newKey.Bytes())
-	f.data.key = newKey.Seal()
+	err = f.fs.seal(f.data, data)
 	f.updateSize()
-	core.Wipe(data)
+	seal.Wipe(data)
 	f.mtx.Unlock()
+	if err == nil {
+		err = f.fs.authenticate(f.node)
+	}
 
 	if err != nil {
 		return 0, err
@@ -186,6 +314,7 @@ func (f *File) Write(p []byte) (int, error) {
 		n = len(data[offset:])
 	}
 	atomic.AddInt64(&f.offset, int64(n))
+	f.fs.notify(f.name, Write)
 
 	return n, nil
 }
@@ -208,6 +337,8 @@ func (f *File) Close() error {
 		return err
 	}
 
+	f.releaseView()
+	f.fs.release(f)
 	f.node = nil
 	return nil
 }
@@ -227,7 +358,12 @@ func (f *File) Seek(offset int64, whence int) (ret int64, err error) {
 	return atomic.LoadInt64(&f.offset), nil
 }
 
+// Stat returns a FileInfo describing the file. It reads the inode of the
+// file, so it reflects changes made through other handles and names.
 func (f *File) Stat() (os.FileInfo, error) {
+	if f.node == nil {
+		return nil, &os.PathError{Op: "stat", Path: f.name, Err: os.ErrClosed}
+	}
 	return &FileInfo{filepath.Base(f.name), f.node}, nil
 }
 
@@ -247,25 +383,29 @@ func (f *File) Readdir(n int) ([]os.FileInfo, error) {
 		return nil, os.ErrPermission
 	}
 	if !f.node.IsDir() {
-		return nil, errors.New("not a directory")
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: absfs.ErrNotDir}
 	}
 
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
 
 	dirs := f.node.Dir
-	if f.diroffset >= len(dirs) {
+	// skip the . and .. entries
+	if f.diroffset < 2 {
+		f.diroffset = 2
+	}
+	if f.diroffset >= len(dirs) && n > 0 {
 		return nil, io.EOF
 	}
-	if n <= 0 {
-		n = len(dirs)
-		f.diroffset = 2
+	end := len(dirs)
+	if n > 0 && f.diroffset+n < end {
+		end = f.diroffset + n
 	}
-	infos := make([]os.FileInfo, n-f.diroffset)
-	for i, entry := range dirs[f.diroffset:n] {
-		infos[i] = &FileInfo{entry.Name, entry.Inode}
+	infos := make([]os.FileInfo, 0, end-f.diroffset)
+	for _, entry := range dirs[f.diroffset:end] {
+		infos = append(infos, &FileInfo{entry.Name, entry.Inode})
 	}
-	f.diroffset += n
+	f.diroffset = end
 	return infos, nil
 }
 
@@ -275,25 +415,29 @@ func (f *File) Readdirnames(n int) ([]string, error) {
 		return list, os.ErrPermission
 	}
 	if !f.node.IsDir() {
-		return list, errors.New("not a directory")
+		return list, &os.PathError{Op: "readdirnames", Path: f.name, Err: absfs.ErrNotDir}
 	}
 
 	f.mtx.Lock()
 	defer f.mtx.Unlock()
 
 	dirs := f.node.Dir
-	if f.diroffset >= len(dirs) {
+	// skip the . and .. entries
+	if f.diroffset < 2 {
+		f.diroffset = 2
+	}
+	if f.diroffset >= len(dirs) && n > 0 {
 		return list, io.EOF
 	}
-	if n <= 0 {
-		n = len(dirs)
-		f.diroffset = 2
+	end := len(dirs)
+	if n > 0 && f.diroffset+n < end {
+		end = f.diroffset + n
 	}
-	list = make([]string, n-f.diroffset)
-	for i, entry := range dirs[f.diroffset:n] {
-		list[i] = entry.Name
+	list = make([]string, 0, end-f.diroffset)
+	for _, entry := range dirs[f.diroffset:end] {
+		list = append(list, entry.Name)
 	}
-	f.diroffset += n
+	f.diroffset = end
 	return list, nil
 }
 
@@ -301,6 +445,9 @@ func (f *File) Truncate(size int64) error {
 	if f.node == nil {
 		return &os.PathError{Op: "truncate", Path: f.name, Err: os.ErrClosed}
 	}
+	if f.readOnly() {
+		return &os.PathError{Op: "truncate", Path: f.name, Err: absfs.ErrReadOnly}
+	}
 	if f.flags&absfs.O_ACCESS == os.O_RDONLY {
 		return os.ErrPermission
 	}
@@ -313,46 +460,47 @@ func (f *File) Truncate(size int64) error {
 		plaintext []byte
 	)
 
-	if f.node.Size != 0 {
-		key, err := f.data.key.Open()
-		if err != nil {
-			return err
-		}
+	if size == atomic.LoadInt64(&f.node.Size) {
+		return nil
+	}
+	// the contents only need decrypting if some of them are kept
+	if f.node.Size != 0 && size != 0 {
 		plaintext = make([]byte, f.node.Size)
-		_, err = core.Decrypt(f.data.ciphertext, key.Bytes(), plaintext)
+		err = f.fs.unseal(f.data, plaintext)
 		if err != nil {
 			return err
 		}
-		key.Destroy()
-	} else if size == 0 { // data is already nil, no-op
-		return nil
 	}
 
 	// TODO: should this be copied in constant time?
 	if size <= f.node.Size {
 		plaintext = plaintext[:int(size)]
-		newKey := memguard.NewBufferFromBytes(fastrand.Bytes(keySize))
-		f.data.ciphertext, err = core.Encrypt(plaintext, newKey.Bytes())
-		f.data.key = newKey.Seal()
-		core.Wipe(plaintext)
+		err = f.fs.seal(f.data, plaintext)
+		seal.Wipe(plaintext)
 		f.updateSize()
+		if err == nil {
+			err = f.fs.authenticate(f.node)
+		}
 		if err != nil {
 			return err
 		}
+		f.fs.notify(f.name, Write)
 		return nil
 	}
 
 	data := make([]byte, int(size))
 	core.Move(data, plaintext)
 
-	newKey := memguard.NewBufferFromBytes(fastrand.Bytes(keySize))
-	f.data.ciphertext, err = core.Encrypt(data, newKey.Bytes())
-	f.data.key = newKey.Seal()
-	core.Wipe(data)
+	err = f.fs.seal(f.data, data)
+	seal.Wipe(data)
 	f.updateSize()
+	if err == nil {
+		err = f.fs.authenticate(f.node)
+	}
 	if err != nil {
 		return err
 	}
+	f.fs.notify(f.name, Write)
 
 	return nil
 }
@@ -361,6 +509,83 @@ func (f *File) WriteString(s string) (n int, err error) {
 	return f.Write([]byte(s))
 }
 
+// Chtimes changes the access and modification times of the file. They are
+// kept with nanosecond precision.
+func (f *File) Chtimes(atime, mtime time.Time) error {
+	if f.node == nil {
+		return &os.PathError{Op: "chtimes", Path: f.name, Err: os.ErrClosed}
+	}
+	if f.readOnly() {
+		return &os.PathError{Op: "chtimes", Path: f.name, Err: absfs.ErrReadOnly}
+	}
+
+	f.node.Lock()
+	f.node.Atime = atime
+	f.node.Mtime = mtime
+	f.node.Unlock()
+	if err := f.fs.authenticate(f.node); err != nil {
+		return &os.PathError{Op: "chtimes", Path: f.name, Err: err}
+	}
+	f.fs.notify(f.name, Chmod)
+
+	return nil
+}
+
+// Chmod changes the permission bits of the file to those of mode, like
+// os.File.Chmod.
+func (f *File) Chmod(mode os.FileMode) error {
+	if f.node == nil {
+		return &os.PathError{Op: "chmod", Path: f.name, Err: os.ErrClosed}
+	}
+	if f.readOnly() {
+		return &os.PathError{Op: "chmod", Path: f.name, Err: absfs.ErrReadOnly}
+	}
+
+	f.node.Lock()
+	f.node.Mode = f.node.Mode&^chmodBits | mode&chmodBits
+	f.node.Unlock()
+	if err := f.fs.authenticate(f.node); err != nil {
+		return &os.PathError{Op: "chmod", Path: f.name, Err: err}
+	}
+	f.fs.notify(f.name, Chmod)
+
+	return nil
+}
+
+// Chown changes the owner and group of the file.
+func (f *File) Chown(uid, gid int) error {
+	if f.node == nil {
+		return &os.PathError{Op: "chown", Path: f.name, Err: os.ErrClosed}
+	}
+	if f.readOnly() {
+		return &os.PathError{Op: "chown", Path: f.name, Err: absfs.ErrReadOnly}
+	}
+
+	f.node.Lock()
+	f.node.Uid = uint32(uid)
+	f.node.Gid = uint32(gid)
+	f.node.Unlock()
+	if err := f.fs.authenticate(f.node); err != nil {
+		return &os.PathError{Op: "chown", Path: f.name, Err: err}
+	}
+	f.fs.notify(f.name, Chmod)
+
+	return nil
+}
+
+// SetDeadline returns os.ErrNoDeadline, as VFS files never block.
+func (f *File) SetDeadline(t time.Time) error {
+	return os.ErrNoDeadline
+}
+
+func (f *File) SetReadDeadline(t time.Time) error {
+	return os.ErrNoDeadline
+}
+
+func (f *File) SetWriteDeadline(t time.Time) error {
+	return os.ErrNoDeadline
+}
+
 type FileInfo struct {
 	name string
 	node *inode.Inode
@@ -371,13 +596,18 @@ func (i *FileInfo) Name() string {
 }
 
 func (i *FileInfo) Size() int64 {
-	return i.node.Size
+	return atomic.LoadInt64(&i.node.Size)
 }
 
 func (i *FileInfo) ModTime() time.Time {
 	return i.node.Mtime
 }
 
+// AccessTime returns the time the file was last accessed.
+func (i *FileInfo) AccessTime() time.Time {
+	return i.node.Atime
+}
+
 func (i *FileInfo) Mode() os.FileMode {
 	return i.node.Mode
 }
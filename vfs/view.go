@@ -0,0 +1,78 @@
+package vfs
+
+import (
+	"github.com/awnumar/memguard"
+)
+
+// A plainView is the decrypted contents of a file, held in locked,
+// read-only memory and shared by the handles reading the file, so it's
+// only decrypted once however many of them read it at once.
+type plainView struct {
+	sf   *sealedFile
+	buf  *memguard.LockedBuffer
+	refs int
+}
+
+// acquireView returns the view of the contents of f, decrypting them if no
+// other handle is reading them. f holds the view until it's closed, or the
+// contents change.
+func (f *File) acquireView() (*plainView, error) {
+	fs := f.fs
+	fs.viewMtx.Lock()
+	defer fs.viewMtx.Unlock()
+
+	v := f.data.view
+	if v != nil && f.view == v {
+		return v, nil
+	}
+	f.releaseViewLocked()
+	if v == nil {
+		buf := memguard.NewBuffer(int(f.node.Size))
+		if buf.Size() != 0 {
+			if err := fs.unseal(f.data, buf.Bytes()); err != nil {
+				buf.Destroy()
+				return nil, err
+			}
+		}
+		buf.Freeze()
+		v = &plainView{sf: f.data, buf: buf}
+		f.data.view = v
+	}
+	v.refs++
+	f.view = v
+
+	return v, nil
+}
+
+// releaseView drops the view f holds, wiping it if f was its last reader.
+func (f *File) releaseView() {
+	f.fs.viewMtx.Lock()
+	defer f.fs.viewMtx.Unlock()
+
+	f.releaseViewLocked()
+}
+
+func (f *File) releaseViewLocked() {
+	v := f.view
+	if v == nil {
+		return
+	}
+	f.view = nil
+	v.refs--
+	if v.refs == 0 {
+		if v.sf.view == v {
+			v.sf.view = nil
+		}
+		v.buf.Destroy()
+	}
+}
+
+// invalidateView stops new readers of sf from sharing its current view, as
+// its contents are about to change. Handles holding the view keep it until
+// they read again.
+func (fs *FileSystem) invalidateView(sf *sealedFile) {
+	fs.viewMtx.Lock()
+	defer fs.viewMtx.Unlock()
+
+	sf.view = nil
+}
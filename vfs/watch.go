@@ -0,0 +1,193 @@
+package vfs
+
+import (
+	"os"
+	"sync"
+
+	"github.com/capnspacehook/pandorasbox/inode"
+)
+
+// Op describes a change to a file. The values match those of fsnotify, so
+// events from both can be handled alike.
+type Op uint32
+
+const (
+	Create Op = 1 << iota
+	Write
+	Remove
+	Rename
+	Chmod
+)
+
+func (op Op) String() string {
+	var s string
+	for i, name := range []string{"CREATE", "WRITE", "REMOVE", "RENAME", "CHMOD"} {
+		if op&(1<<i) == 0 {
+			continue
+		}
+		if s != "" {
+			s += "|"
+		}
+		s += name
+	}
+	return s
+}
+
+// An Event is a change to a watched file, or to a file in a watched
+// directory.
+type Event struct {
+	// Name is the absolute path of the file that changed.
+	Name string
+	Op   Op
+}
+
+// A Watcher delivers Events for changes to the files and directories added
+// to it. Events are queued, so a slow reader never holds up changes to the
+// FileSystem.
+type Watcher struct {
+	// Events is closed when the Watcher is closed.
+	Events chan Event
+
+	fs     *FileSystem
+	mtx    sync.Mutex
+	cond   *sync.Cond
+	names  map[string]bool
+	queue  []Event
+	closed bool
+	done   chan struct{}
+}
+
+// NewWatcher returns a Watcher for changes to fs. It watches nothing until
+// files or directories are added to it.
+func (fs *FileSystem) NewWatcher() *Watcher {
+	w := &Watcher{
+		Events: make(chan Event),
+		fs:     fs,
+		names:  make(map[string]bool),
+		done:   make(chan struct{}),
+	}
+	w.cond = sync.NewCond(&w.mtx)
+
+	fs.watchMtx.Lock()
+	if fs.watchers == nil {
+		fs.watchers = make(map[*Watcher]struct{})
+	}
+	fs.watchers[w] = struct{}{}
+	fs.watchMtx.Unlock()
+
+	go w.deliver()
+
+	return w
+}
+
+// Add starts watching name. Like fsnotify, watching a directory reports
+// changes to the files directly in it, but not in its subdirectories.
+func (w *Watcher) Add(name string) error {
+	if _, err := w.fs.Lstat(name); err != nil {
+		return &os.PathError{Op: "watch", Path: name, Err: os.ErrNotExist}
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.closed {
+		return &os.PathError{Op: "watch", Path: name, Err: os.ErrClosed}
+	}
+	w.names[inode.Abs(w.fs.cwd, name)] = true
+
+	return nil
+}
+
+// Remove stops watching name.
+func (w *Watcher) Remove(name string) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	abs := inode.Abs(w.fs.cwd, name)
+	if !w.names[abs] {
+		return &os.PathError{Op: "unwatch", Path: name, Err: os.ErrNotExist}
+	}
+	delete(w.names, abs)
+
+	return nil
+}
+
+// Close stops watching, discards any undelivered events and closes Events.
+func (w *Watcher) Close() error {
+	w.fs.watchMtx.Lock()
+	delete(w.fs.watchers, w)
+	w.fs.watchMtx.Unlock()
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.queue = nil
+	close(w.done)
+	w.cond.Broadcast()
+
+	return nil
+}
+
+func (w *Watcher) push(e Event) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.closed || !w.names[e.Name] && !w.names[Dir(e.Name)] {
+		return
+	}
+	w.queue = append(w.queue, e)
+	w.cond.Signal()
+}
+
+// deliver sends queued events on Events until the Watcher is closed.
+func (w *Watcher) deliver() {
+	defer close(w.Events)
+
+	for {
+		w.mtx.Lock()
+		for len(w.queue) == 0 && !w.closed {
+			w.cond.Wait()
+		}
+		if w.closed {
+			w.mtx.Unlock()
+			return
+		}
+		e := w.queue[0]
+		w.queue = w.queue[1:]
+		w.mtx.Unlock()
+
+		select {
+		case w.Events <- e:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// notify reports an op change of name to the watchers of fs.
+func (fs *FileSystem) notify(name string, op Op) {
+	fs.changed(name)
+
+	fs.watchMtx.Lock()
+	defer fs.watchMtx.Unlock()
+
+	if len(fs.watchers) == 0 {
+		return
+	}
+	e := Event{Name: inode.Abs(fs.cwd, name), Op: op}
+	for w := range fs.watchers {
+		w.push(e)
+	}
+}
+
+// notified is notify for deferring, which only reports the change if *err
+// is nil.
+func (fs *FileSystem) notified(name string, op Op, err *error) {
+	if *err == nil {
+		fs.notify(name, op)
+	}
+}
@@ -0,0 +1,561 @@
+package vfs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/awnumar/memguard"
+
+	"github.com/capnspacehook/pandorasbox/inode"
+	"github.com/capnspacehook/pandorasbox/osfs"
+	"github.com/capnspacehook/pandorasbox/seal"
+)
+
+// entrySuffix is the suffix of the files write-through entries are kept in.
+const entrySuffix = ".pbw"
+
+// ErrBadEntry is returned by LoadWriteThrough for entries that weren't
+// written by SetWriteThrough under the key given.
+var ErrBadEntry = errors.New("invalid write-through entry")
+
+// WriteThrough configures how a FileSystem persists changes, see
+// SetWriteThrough.
+type WriteThrough struct {
+	// Dir is the directory on the host's filesystem changes are persisted
+	// to. It's created if it doesn't exist, and must only hold the files
+	// SetWriteThrough writes.
+	Dir string
+
+	// Key is the key changes are encrypted under. It must be seal.KeySize
+	// bytes long.
+	Key *memguard.Enclave
+
+	// Delay is the longest a change may wait before it's persisted, which
+	// bounds how many changes a crash can lose. Changes made within Delay
+	// of each other are persisted together. It defaults to 100ms.
+	Delay time.Duration
+
+	// OnError is called with the errors persisting changes in the
+	// background. Changes that failed to persist are tried again with the
+	// next ones.
+	OnError func(error)
+}
+
+// writeThrough is the running state of SetWriteThrough.
+type writeThrough struct {
+	opts    WriteThrough
+	nameKey *memguard.Enclave
+
+	mtx   sync.Mutex
+	dirty map[string]bool
+	timer *time.Timer
+
+	// flushMtx serializes flushes, and guards persisted, the paths that
+	// have entries
+	flushMtx  sync.Mutex
+	persisted map[string]bool
+}
+
+// entryHeader describes the file an entry holds.
+type entryHeader struct {
+	Path    string
+	Mode    os.FileMode
+	ModTime time.Time
+	Target  string `json:",omitempty"`
+}
+
+// SetWriteThrough persists every change to fs, still encrypted, to files
+// in wt.Dir on the host's filesystem, at most wt.Delay after it was made,
+// so the contents of fs survive the process with a bounded window of lost
+// changes. Each file, directory and symlink is kept in its own entry,
+// named after an HMAC of its path and written atomically. Every file is
+// persisted when SetWriteThrough is called, and entries of files that no
+// longer exist are removed. Hard links are persisted as separate files,
+// and tags and extended attributes aren't persisted. A FileSystem is
+// restored from wt.Dir with LoadWriteThrough.
+//
+// A nil wt persists the changes waiting to be persisted, and stops
+// persisting changes.
+func (fs *FileSystem) SetWriteThrough(wt *WriteThrough) error {
+	if old := fs.writeThrough.Swap(nil); old != nil {
+		old.mtx.Lock()
+		if old.timer != nil {
+			old.timer.Stop()
+		}
+		old.mtx.Unlock()
+		if err := fs.flush(old); err != nil {
+			return err
+		}
+	}
+	if wt == nil {
+		return nil
+	}
+
+	opts := *wt
+	if opts.Delay <= 0 {
+		opts.Delay = 100 * time.Millisecond
+	}
+	nameKey, err := entryNameKey(opts.Key)
+	if err != nil {
+		return &os.PathError{Op: "writethrough", Path: opts.Dir, Err: err}
+	}
+	if err := os.MkdirAll(opts.Dir, 0700); err != nil {
+		return err
+	}
+	w := &writeThrough{
+		opts:      opts,
+		nameKey:   nameKey,
+		dirty:     make(map[string]bool),
+		persisted: make(map[string]bool),
+	}
+
+	// persist every file, and drop the entries of files that are gone
+	paths, err := fs.paths("/")
+	if err != nil {
+		return err
+	}
+	w.dirty["/"] = true
+	for _, path := range paths {
+		w.dirty[path] = true
+	}
+	fs.writeThrough.Store(w)
+	if err := fs.flush(w); err != nil {
+		fs.writeThrough.CompareAndSwap(w, nil)
+		return err
+	}
+	want := make(map[string]bool)
+	for path := range w.persisted {
+		want[w.entryName(path)] = true
+	}
+	names, err := entryNames(opts.Dir)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !want[name] {
+			if err := os.Remove(filepath.Join(opts.Dir, name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return osfs.SyncDir(opts.Dir)
+}
+
+// Flush persists the changes waiting to be persisted by SetWriteThrough,
+// and returns once they are.
+func (fs *FileSystem) Flush() error {
+	w := fs.writeThrough.Load()
+	if w == nil {
+		return nil
+	}
+
+	return fs.flush(w)
+}
+
+// entryNameKey derives the key entries are named under from key.
+func entryNameKey(key *memguard.Enclave) (*memguard.Enclave, error) {
+	if key == nil {
+		return nil, seal.ErrInvalidKey
+	}
+	k, err := key.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer k.Destroy()
+	if k.Size() != seal.KeySize {
+		return nil, seal.ErrInvalidKey
+	}
+
+	mac := hmac.New(sha256.New, k.Bytes())
+	mac.Write([]byte("pandorasbox write-through names"))
+	return memguard.NewEnclave(mac.Sum(nil)), nil
+}
+
+// entryName returns the name of the entry of path.
+func (w *writeThrough) entryName(path string) string {
+	k, err := w.nameKey.Open()
+	if err != nil {
+		memguard.SafePanic(err)
+	}
+	defer k.Destroy()
+
+	mac := hmac.New(sha256.New, k.Bytes())
+	mac.Write([]byte(path))
+	return hex.EncodeToString(mac.Sum(nil)) + entrySuffix
+}
+
+// entryNames returns the names of the entries in dir.
+func entryNames(dir string) ([]string, error) {
+	dirents, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, d := range dirents {
+		if d.Type().IsRegular() && strings.HasSuffix(d.Name(), entrySuffix) {
+			names = append(names, d.Name())
+		}
+	}
+	return names, nil
+}
+
+// changed marks name to be persisted, if changes to fs are.
+func (fs *FileSystem) changed(name string) {
+	w := fs.writeThrough.Load()
+	if w == nil {
+		return
+	}
+
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	w.dirty[inode.Abs(fs.cwd, name)] = true
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.opts.Delay, func() {
+			if err := fs.flush(w); err != nil && w.opts.OnError != nil {
+				w.opts.OnError(err)
+			}
+		})
+	}
+}
+
+// flush persists the changed paths of w.
+func (fs *FileSystem) flush(w *writeThrough) error {
+	w.flushMtx.Lock()
+	defer w.flushMtx.Unlock()
+
+	w.mtx.Lock()
+	dirty := w.dirty
+	w.dirty = make(map[string]bool)
+	w.timer = nil
+	w.mtx.Unlock()
+
+	paths := make([]string, 0, len(dirty))
+	for path := range dirty {
+		paths = append(paths, path)
+	}
+	// parents are persisted before their children
+	sort.Strings(paths)
+
+	var failed []string
+	var err error
+	for _, path := range paths {
+		if e := fs.persist(w, path); e != nil {
+			failed = append(failed, path)
+			if err == nil {
+				err = e
+			}
+		}
+	}
+	if len(paths) != 0 {
+		if e := osfs.SyncDir(w.opts.Dir); e != nil && err == nil {
+			err = e
+		}
+	}
+	if len(failed) != 0 {
+		// try again with the next changes
+		w.mtx.Lock()
+		for _, path := range failed {
+			w.dirty[path] = true
+		}
+		w.mtx.Unlock()
+	}
+
+	return err
+}
+
+// persist writes the entry of path, or removes it and the entries under it
+// if path no longer exists.
+func (fs *FileSystem) persist(w *writeThrough, path string) error {
+	moved := !w.persisted[path] && path != "/"
+	info, err := fs.persistEntry(w, path)
+	if err != nil || info == nil || !info.IsDir() || !moved {
+		return err
+	}
+
+	// a directory that was moved here brings its children along without
+	// changes to them being reported
+	return fs.persistChildren(w, path)
+}
+
+// persistChildren persists the entries under the directory path that
+// weren't persisted yet. Those that fail to persist are tried again with
+// the next changes.
+func (fs *FileSystem) persistChildren(w *writeThrough, path string) error {
+	paths, err := fs.paths(path)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, p := range paths {
+		if w.persisted[p] {
+			continue
+		}
+		if _, err := fs.persistEntry(w, p); err != nil {
+			errs = append(errs, err)
+			w.mtx.Lock()
+			w.dirty[p] = true
+			w.mtx.Unlock()
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// paths returns the paths under the directory path, without checking
+// their modes or following symlinks.
+func (fs *FileSystem) paths(path string) ([]string, error) {
+	dir := fs.root
+	if path != "/" {
+		var err error
+		if dir, err = fs.root.Resolve(strings.TrimLeft(path, "/")); err != nil {
+			return nil, err
+		}
+	}
+
+	var paths []string
+	fs.mtx.RLock()
+	defer fs.mtx.RUnlock()
+	var walk func(dir string, node *inode.Inode)
+	walk = func(dir string, node *inode.Inode) {
+		node.RLock()
+		entries := make(inode.Directory, len(node.Dir))
+		copy(entries, node.Dir)
+		node.RUnlock()
+
+		for _, entry := range entries {
+			if entry.Name == "." || entry.Name == ".." {
+				continue
+			}
+			p := Join(dir, entry.Name)
+			paths = append(paths, p)
+			if entry.Inode.IsDir() {
+				walk(p, entry.Inode)
+			}
+		}
+	}
+	walk(path, dir)
+
+	return paths, nil
+}
+
+// persistEntry writes the entry of path alone, or removes it and the
+// entries under it if path no longer exists, in which case it returns a
+// nil FileInfo.
+func (fs *FileSystem) persistEntry(w *writeThrough, path string) (os.FileInfo, error) {
+	info, err := fs.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		for p := range w.persisted {
+			if p == path || strings.HasPrefix(p, path+"/") {
+				if err := os.Remove(filepath.Join(w.opts.Dir, w.entryName(p))); err != nil && !os.IsNotExist(err) {
+					return nil, err
+				}
+				delete(w.persisted, p)
+			}
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hdr := entryHeader{Path: path, Mode: info.Mode(), ModTime: info.ModTime()}
+	var contents []byte
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		if hdr.Target, err = fs.Readlink(path); err != nil {
+			return nil, err
+		}
+	case info.Mode().IsRegular():
+		// files are persisted whatever their mode is
+		if contents, err = fs.contents(path); err != nil {
+			return nil, err
+		}
+	}
+	defer seal.Wipe(contents)
+
+	hdrJSON, err := json.Marshal(hdr)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, 4, 4+len(hdrJSON)+len(contents))
+	binary.BigEndian.PutUint32(plaintext, uint32(len(hdrJSON)))
+	plaintext = append(plaintext, hdrJSON...)
+	plaintext = append(plaintext, contents...)
+	defer seal.Wipe(plaintext)
+	ciphertext, err := fs.sealer.Seal(plaintext, w.opts.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Join(w.opts.Dir, w.entryName(path))
+	if err := writeEntry(name, ciphertext); err != nil {
+		return nil, err
+	}
+	w.persisted[path] = true
+
+	return info, nil
+}
+
+// contents returns the decrypted contents of the file path without
+// checking its mode. They must be wiped once they're no longer needed.
+func (fs *FileSystem) contents(path string) ([]byte, error) {
+	node, err := fs.root.Resolve(strings.TrimLeft(path, "/"))
+	if err != nil {
+		return nil, err
+	}
+	var sf *sealedFile
+	fs.mtx.RLock()
+	if int(node.Ino) < len(fs.data) {
+		sf = fs.data[node.Ino]
+	}
+	fs.mtx.RUnlock()
+	if sf == nil || !fs.stored(sf) {
+		return nil, nil
+	}
+
+	plaintext := make([]byte, fs.sealedSize(sf))
+	if err := fs.unseal(sf, plaintext); err != nil {
+		seal.Wipe(plaintext)
+		return nil, err
+	}
+
+	return plaintext, nil
+}
+
+// writeEntry atomically replaces the entry name with data. The directory
+// is synced once the flush is done.
+func writeEntry(name string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(name), ".entry.tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), name)
+}
+
+// LoadWriteThrough returns a new FileSystem configured by opts, holding the
+// files persisted to dir by SetWriteThrough under key. It fails with
+// ErrBadEntry if an entry wasn't written under key. Call SetWriteThrough
+// on it to keep persisting its changes.
+func LoadWriteThrough(dir string, key *memguard.Enclave, opts ...Option) (*FileSystem, error) {
+	if _, err := entryNameKey(key); err != nil {
+		return nil, &os.PathError{Op: "load", Path: dir, Err: err}
+	}
+	names, err := entryNames(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := NewFS(opts...)
+	type entry struct {
+		hdr      entryHeader
+		contents []byte
+	}
+	entries := make([]entry, 0, len(names))
+	defer func() {
+		for _, e := range entries {
+			seal.Wipe(e.contents)
+		}
+	}()
+	for _, name := range names {
+		ciphertext, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		if len(ciphertext) < fs.sealer.Overhead() {
+			return nil, &os.PathError{Op: "load", Path: name, Err: ErrBadEntry}
+		}
+		plaintext := make([]byte, len(ciphertext)-fs.sealer.Overhead())
+		if err := fs.sealer.Open(ciphertext, key, plaintext); err != nil {
+			return nil, &os.PathError{Op: "load", Path: name, Err: ErrBadEntry}
+		}
+		var e entry
+		var n int64
+		if len(plaintext) >= 4 {
+			n = 4 + int64(binary.BigEndian.Uint32(plaintext))
+		}
+		if n == 0 || n > int64(len(plaintext)) || json.Unmarshal(plaintext[4:n], &e.hdr) != nil {
+			seal.Wipe(plaintext)
+			return nil, &os.PathError{Op: "load", Path: name, Err: ErrBadEntry}
+		}
+		e.contents = plaintext[n:]
+		entries = append(entries, e)
+	}
+	// parents are created before their children
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].hdr.Path < entries[j].hdr.Path
+	})
+
+	for _, e := range entries {
+		hdr := e.hdr
+		switch {
+		case hdr.Mode.IsDir():
+			if hdr.Path != "/" {
+				if err := fs.MkdirAll(hdr.Path, hdr.Mode.Perm()); err != nil {
+					return nil, err
+				}
+			}
+		case hdr.Mode&os.ModeSymlink != 0:
+			if err := fs.Symlink(hdr.Target, hdr.Path); err != nil {
+				return nil, err
+			}
+			continue
+		default:
+			if err := fs.MkdirAll(Dir(hdr.Path), 0755); err != nil {
+				return nil, err
+			}
+			f, err := fs.OpenFile(hdr.Path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, hdr.Mode.Perm())
+			if err != nil {
+				return nil, err
+			}
+			_, err = f.Write(e.contents)
+			if err1 := f.Close(); err == nil {
+				err = err1
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		if err := fs.Chmod(hdr.Path, hdr.Mode); err != nil {
+			return nil, err
+		}
+	}
+	// directories are given their times last, as creating their children
+	// changes them
+	for i := len(entries) - 1; i >= 0; i-- {
+		hdr := entries[i].hdr
+		if hdr.Mode&os.ModeSymlink == 0 {
+			if err := fs.Chtimes(hdr.Path, hdr.ModTime, hdr.ModTime); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return fs, nil
+}
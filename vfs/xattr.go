@@ -0,0 +1,111 @@
+package vfs
+
+import (
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/capnspacehook/pandorasbox/absfs"
+	"github.com/capnspacehook/pandorasbox/inode"
+)
+
+// ErrNoAttr is returned for extended attributes a file doesn't have.
+var ErrNoAttr = errors.New("no such attribute")
+
+// xattrNode returns the inode of the named file, following symbolic links.
+func (fs *FileSystem) xattrNode(op, name string) (*inode.Inode, error) {
+	if name == "/" {
+		return fs.root, nil
+	}
+	node, err := fs.fileStat(fs.cwd, name)
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: err}
+	}
+
+	return node, nil
+}
+
+// Getxattr returns the value of the extended attribute attr of the named
+// file.
+func (fs *FileSystem) Getxattr(name, attr string) ([]byte, error) {
+	node, err := fs.xattrNode("getxattr", name)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.mtx.RLock()
+	defer fs.mtx.RUnlock()
+
+	value, ok := fs.xattrs[node.Ino][attr]
+	if !ok {
+		return nil, &os.PathError{Op: "getxattr", Path: name, Err: ErrNoAttr}
+	}
+
+	return append([]byte(nil), value...), nil
+}
+
+// Setxattr sets the extended attribute attr of the named file.
+func (fs *FileSystem) Setxattr(name, attr string, value []byte) error {
+	if attr == "" {
+		return &os.PathError{Op: "setxattr", Path: name, Err: absfs.ErrInvalid}
+	}
+	node, err := fs.xattrNode("setxattr", name)
+	if err != nil {
+		return err
+	}
+
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	attrs := fs.xattrs[node.Ino]
+	if attrs == nil {
+		attrs = make(map[string][]byte)
+		fs.xattrs[node.Ino] = attrs
+	}
+	attrs[attr] = append([]byte(nil), value...)
+	fs.notify(name, Chmod)
+
+	return nil
+}
+
+// Listxattr returns the sorted names of the extended attributes of the
+// named file.
+func (fs *FileSystem) Listxattr(name string) ([]string, error) {
+	node, err := fs.xattrNode("listxattr", name)
+	if err != nil {
+		return nil, err
+	}
+
+	fs.mtx.RLock()
+	defer fs.mtx.RUnlock()
+
+	attrs := make([]string, 0, len(fs.xattrs[node.Ino]))
+	for attr := range fs.xattrs[node.Ino] {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+
+	return attrs, nil
+}
+
+// Removexattr removes the extended attribute attr of the named file.
+func (fs *FileSystem) Removexattr(name, attr string) error {
+	node, err := fs.xattrNode("removexattr", name)
+	if err != nil {
+		return err
+	}
+
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+
+	if _, ok := fs.xattrs[node.Ino][attr]; !ok {
+		return &os.PathError{Op: "removexattr", Path: name, Err: ErrNoAttr}
+	}
+	delete(fs.xattrs[node.Ino], attr)
+	fs.notify(name, Chmod)
+
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 
 	"github.com/capnspacehook/pandorasbox/absfs"
 	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/vfs"
 )
 
 func (b *Box) VFSAbs(path string) (string, error) {
@@ -80,6 +81,10 @@ func (b *Box) VFSSymlink(oldname, newname string) error {
 	return b.vfs.Symlink(oldname, newname)
 }
 
+func (b *Box) VFSLink(oldname, newname string) error {
+	return b.vfs.Link(oldname, newname)
+}
+
 // io/ioutil methods
 
 func (b *Box) VFSReadFile(filename string) ([]byte, error) {
@@ -101,3 +106,16 @@ func (b *Box) VFSTempFile(dir, prefix string) (absfs.File, error) {
 func (b *Box) VFSTempDir(dir, prefix string) (string, error) {
 	return ioutil.TempDir(b.vfs, dir, prefix)
 }
+
+// VFSListOpenFiles returns the files that are open in the Box's VFS, see
+// vfs.FileSystem.ListOpenFiles.
+func (b *Box) VFSListOpenFiles() []vfs.OpenHandle {
+	return b.vfs.ListOpenFiles()
+}
+
+// VFSAs returns a view of the Box's VFS whose operations are performed for
+// cred and checked by the policy set with SetPolicy, see
+// vfs.FileSystem.As.
+func (b *Box) VFSAs(cred vfs.Credentials) absfs.FileSystem {
+	return b.vfs.As(cred)
+}
@@ -0,0 +1,118 @@
+package pandorasbox
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/capnspacehook/pandorasbox/ioutil"
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+// WalkDir walks the file tree rooted at root like filepath.WalkDir, on the
+// host's filesystem or in a VFS. Paths are passed to fn in the same form as
+// root, so paths under vfs:// can be passed back to the Box. Walking
+// vfs:// itself also walks the VFSs added with Register, as if they were
+// mounted at vfs://name. They are walked after the default VFS, in the
+// order of their names.
+func (b *Box) WalkDir(root string, fn fs.WalkDirFunc) error {
+	err := b.walkDir(root, fn)
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+
+	return err
+}
+
+func (b *Box) walkDir(root string, fn fs.WalkDirFunc) error {
+	vfsys, vfsRoot, ok, err := b.resolveVFS(root)
+	if err != nil {
+		return &os.PathError{Op: "walk", Path: root, Err: err}
+	}
+	if !ok {
+		return b.osfs.Walk(root, walkDirFunc(fn, func(p string) string {
+			return p
+		}))
+	}
+
+	walkFn := walkDirFunc(fn, func(p string) string {
+		return boxPath(root, vfsRoot, p)
+	})
+	mounts := b.mounts(root, vfsys, vfsRoot)
+	var skipped bool
+	err = ioutil.WalkSymlinks(vfsys, vfsRoot, b.osfs.Symlinks, func(p string, info os.FileInfo, err error) error {
+		// entries shadowed by a mounted VFS are walked in it instead
+		if mounts != nil && mounts[strings.TrimPrefix(p, "/")] {
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		err = walkFn(p, info, err)
+		// skipping the root skips the VFSs mounted in it too
+		skipped = skipped || p == vfsRoot && err == filepath.SkipDir
+		return err
+	})
+	if err != nil || skipped {
+		return err
+	}
+
+	names := make([]string, 0, len(mounts))
+	for name := range mounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := b.walkDir(VFSPrefix+name, fn)
+		if err != nil && err != filepath.SkipDir {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// boxPath returns the Box path of p, a path in the VFS that root, which
+// is vfsRoot in it, is in.
+func boxPath(root, vfsRoot, p string) string {
+	if p == vfsRoot {
+		return root
+	}
+	rel := strings.TrimPrefix(strings.TrimPrefix(p, vfsRoot), "/")
+	return strings.TrimSuffix(root, "/") + "/" + rel
+}
+
+// mounts returns the names of the VFSs mounted in the VFS rooted at root,
+// which are the ones added with Register if root is vfs://.
+func (b *Box) mounts(root string, vfsys *vfs.FileSystem, vfsRoot string) map[string]bool {
+	b.mtx.RLock()
+	s, ok := b.resolver.(*Schemes)
+	b.mtx.RUnlock()
+	if !ok || vfsRoot != "/" || !strings.HasPrefix(root, VFSPrefix) {
+		return nil
+	}
+	if def, _, _, _ := s.Resolve(VFSPrefix); def != vfsys {
+		return nil
+	}
+
+	mounts := make(map[string]bool)
+	for _, name := range s.Names() {
+		mounts[name] = true
+	}
+
+	return mounts
+}
+
+// walkDirFunc adapts fn to a filepath.WalkFunc, passing it paths converted
+// with boxPath.
+func walkDirFunc(fn fs.WalkDirFunc, boxPath func(string) string) filepath.WalkFunc {
+	return func(p string, info os.FileInfo, err error) error {
+		var d fs.DirEntry
+		if info != nil {
+			d = fs.FileInfoToDirEntry(info)
+		}
+		return fn(boxPath(p), d, err)
+	}
+}
@@ -0,0 +1,193 @@
+package pandorasbox
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/capnspacehook/pandorasbox/vfs"
+)
+
+// walked returns the paths WalkDir passes to fn, which returns the error
+// skip returns for each path.
+func walked(t *testing.T, b *Box, root string, skip func(p string) error) []string {
+	t.Helper()
+	var paths []string
+	err := b.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		paths = append(paths, p)
+		if skip != nil {
+			return skip(p)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return paths
+}
+
+// newWalkBox returns a Box with files in its default VFS and in two
+// registered ones, a and b. The default VFS has a directory a too, which is
+// shadowed by the registered one.
+func newWalkBox(t *testing.T) *Box {
+	t.Helper()
+	b := NewBox()
+	for _, name := range []string{"b", "a"} {
+		if err := b.Register(name, vfs.NewFS()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, dir := range []string{"vfs://dir/sub", "vfs://a/x", "vfs://b/y"} {
+		if err := b.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"vfs://file", "vfs://dir/sub/file", "vfs://a/x/file", "vfs://b/file"} {
+		if err := b.WriteFile(name, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	def, _, _, _ := b.resolveVFS(VFSPrefix)
+	if err := def.MkdirAll("/a/shadowed", 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestWalkDirHost(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "dir", "sub"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"file", filepath.Join("dir", "sub", "file")} {
+		if err := os.WriteFile(filepath.Join(root, name), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := NewBox()
+	got := walked(t, b, root, nil)
+	want := []string{
+		root,
+		filepath.Join(root, "dir"),
+		filepath.Join(root, "dir", "sub"),
+		filepath.Join(root, "dir", "sub", "file"),
+		filepath.Join(root, "file"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("walked %q, want %q", got, want)
+	}
+}
+
+func TestWalkDirVFS(t *testing.T) {
+	b := newWalkBox(t)
+	got := walked(t, b, "vfs://", nil)
+	want := []string{
+		"vfs://",
+		"vfs://dir",
+		"vfs://dir/sub",
+		"vfs://dir/sub/file",
+		"vfs://file",
+		"vfs://a",
+		"vfs://a/x",
+		"vfs://a/x/file",
+		"vfs://b",
+		"vfs://b/file",
+		"vfs://b/y",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("walked %q, want %q", got, want)
+	}
+
+	// walking a registered VFS walks only it
+	got = walked(t, b, "vfs://a", nil)
+	want = []string{"vfs://a", "vfs://a/x", "vfs://a/x/file"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("walked %q, want %q", got, want)
+	}
+	got = walked(t, b, "vfs://dir/", nil)
+	want = []string{"vfs://dir/", "vfs://dir/sub", "vfs://dir/sub/file"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("walked %q, want %q", got, want)
+	}
+
+	// paths are passed in a form the Box accepts
+	for _, p := range walked(t, b, "vfs://", nil) {
+		if _, err := b.Stat(p); err != nil {
+			t.Errorf("walked path %q can't be opened: %v", p, err)
+		}
+	}
+}
+
+func TestWalkDirSkipDir(t *testing.T) {
+	b := newWalkBox(t)
+	got := walked(t, b, "vfs://", func(p string) error {
+		if p == "vfs://dir" || p == "vfs://a" {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	want := []string{
+		"vfs://",
+		"vfs://dir",
+		"vfs://file",
+		"vfs://a",
+		"vfs://b",
+		"vfs://b/file",
+		"vfs://b/y",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("walked %q, want %q", got, want)
+	}
+
+	// skipping the root skips everything, including the registered VFSs
+	got = walked(t, b, "vfs://", func(string) error {
+		return filepath.SkipDir
+	})
+	if want := []string{"vfs://"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("walked %q, want %q", got, want)
+	}
+}
+
+func TestWalkDirSkipAll(t *testing.T) {
+	b := newWalkBox(t)
+	for _, stop := range []string{"vfs://dir/sub", "vfs://a/x"} {
+		var last string
+		got := walked(t, b, "vfs://", func(p string) error {
+			if p == stop {
+				return filepath.SkipAll
+			}
+			last = p
+			return nil
+		})
+		if got[len(got)-1] != stop {
+			t.Errorf("walked %q after SkipAll at %q", last, stop)
+		}
+	}
+}
+
+func TestBoxPath(t *testing.T) {
+	tests := []struct {
+		root, vfsRoot, p, want string
+	}{
+		{"vfs://", "/", "/", "vfs://"},
+		{"vfs://", "/", "/dir/file", "vfs://dir/file"},
+		{"vfs://dir", "/dir", "/dir", "vfs://dir"},
+		{"vfs://dir/", "/dir", "/dir/file", "vfs://dir/file"},
+		{"vfs://a", "/", "/x/file", "vfs://a/x/file"},
+		{"vfs://a/x", "/x", "/x/file", "vfs://a/x/file"},
+		{"mem://", "/", "/file", "mem://file"},
+	}
+	for _, tt := range tests {
+		if got := boxPath(tt.root, tt.vfsRoot, tt.p); got != tt.want {
+			t.Errorf("boxPath(%q, %q, %q) = %q, want %q", tt.root, tt.vfsRoot, tt.p, got, tt.want)
+		}
+	}
+}
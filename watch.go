@@ -0,0 +1,205 @@
+package pandorasbox
+
+import (
+	"os"
+	"sync"
+
+	"github.com/capnspacehook/pandorasbox/vfs"
+	"github.com/fsnotify/fsnotify"
+)
+
+// An Event is a change to a file watched by a Watcher. Name is in the same
+// form as the path that was watched, so changes in a VFS are reported with
+// paths like vfs://dir/file.
+type Event struct {
+	Name string
+	Op   vfs.Op
+}
+
+// A Watcher delivers the changes to files on the host's filesystem and in
+// VFSs as one stream of Events. Changes on the host's filesystem are
+// watched with fsnotify, and changes in VFSs with vfs.Watcher.
+type Watcher struct {
+	// Events and Errors are closed when the Watcher is closed.
+	Events chan Event
+	Errors chan error
+
+	b      *Box
+	mtx    sync.Mutex
+	os     *fsnotify.Watcher
+	vfs    map[*vfs.FileSystem]*vfsWatch
+	closed bool
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// vfsWatch watches one VFS, and remembers the Box paths of the VFS paths
+// watched.
+type vfsWatch struct {
+	w     *vfs.Watcher
+	names map[string]string
+}
+
+// Watch returns a Watcher watching names, which can be paths on the host's
+// filesystem or in a VFS. Watching a directory reports changes to the files
+// directly in it.
+func (b *Box) Watch(names ...string) (*Watcher, error) {
+	w := &Watcher{
+		Events: make(chan Event),
+		Errors: make(chan error),
+		b:      b,
+		vfs:    make(map[*vfs.FileSystem]*vfsWatch),
+		done:   make(chan struct{}),
+	}
+	for _, name := range names {
+		if err := w.Add(name); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+
+	return w, nil
+}
+
+// Add starts watching name.
+func (w *Watcher) Add(name string) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.closed {
+		return &os.PathError{Op: "watch", Path: name, Err: os.ErrClosed}
+	}
+
+	fs, vfsName, ok, err := w.b.resolveVFS(name)
+	if err != nil {
+		return &os.PathError{Op: "watch", Path: name, Err: err}
+	}
+	if !ok {
+		if w.os == nil {
+			ow, err := fsnotify.NewWatcher()
+			if err != nil {
+				return err
+			}
+			w.os = ow
+			w.wg.Add(1)
+			go w.forwardOS(ow)
+		}
+		return w.os.Add(name)
+	}
+
+	vw := w.vfs[fs]
+	if vw == nil {
+		vw = &vfsWatch{w: fs.NewWatcher(), names: make(map[string]string)}
+		w.vfs[fs] = vw
+		w.wg.Add(1)
+		go w.forwardVFS(vw)
+	}
+	if err := vw.w.Add(vfsName); err != nil {
+		return err
+	}
+	vw.names[vfs.Clean(vfsName)] = name
+
+	return nil
+}
+
+// Remove stops watching name.
+func (w *Watcher) Remove(name string) error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	fs, vfsName, ok, err := w.b.resolveVFS(name)
+	if err != nil {
+		return &os.PathError{Op: "unwatch", Path: name, Err: err}
+	}
+	if !ok {
+		if w.os == nil {
+			return &os.PathError{Op: "unwatch", Path: name, Err: os.ErrNotExist}
+		}
+		return w.os.Remove(name)
+	}
+
+	vw := w.vfs[fs]
+	if vw == nil {
+		return &os.PathError{Op: "unwatch", Path: name, Err: os.ErrNotExist}
+	}
+	if err := vw.w.Remove(vfsName); err != nil {
+		return err
+	}
+	delete(vw.names, vfs.Clean(vfsName))
+
+	return nil
+}
+
+// Close stops watching, and closes Events and Errors.
+func (w *Watcher) Close() error {
+	w.mtx.Lock()
+	if w.closed {
+		w.mtx.Unlock()
+		return nil
+	}
+	w.closed = true
+	close(w.done)
+	ow, vws := w.os, w.vfs
+	w.mtx.Unlock()
+
+	var err error
+	if ow != nil {
+		err = ow.Close()
+	}
+	for _, vw := range vws {
+		vw.w.Close()
+	}
+	w.wg.Wait()
+	close(w.Events)
+	close(w.Errors)
+
+	return err
+}
+
+func (w *Watcher) send(e Event) {
+	select {
+	case w.Events <- e:
+	case <-w.done:
+	}
+}
+
+func (w *Watcher) forwardOS(ow *fsnotify.Watcher) {
+	defer w.wg.Done()
+
+	for {
+		select {
+		case e, ok := <-ow.Events:
+			if !ok {
+				return
+			}
+			w.send(Event{Name: e.Name, Op: vfs.Op(e.Op)})
+		case err, ok := <-ow.Errors:
+			if !ok {
+				return
+			}
+			select {
+			case w.Errors <- err:
+			case <-w.done:
+			}
+		}
+	}
+}
+
+func (w *Watcher) forwardVFS(vw *vfsWatch) {
+	defer w.wg.Done()
+
+	for e := range vw.w.Events {
+		// the event is for a watched file, or a file in a watched directory
+		vfsRoot := e.Name
+		w.mtx.Lock()
+		root, ok := vw.names[vfsRoot]
+		if !ok {
+			vfsRoot = vfs.Dir(e.Name)
+			root, ok = vw.names[vfsRoot]
+		}
+		w.mtx.Unlock()
+		if ok {
+			w.send(Event{Name: boxPath(root, vfsRoot, e.Name), Op: e.Op})
+		}
+	}
+}
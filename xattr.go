@@ -0,0 +1,56 @@
+package pandorasbox
+
+import "os"
+
+// Getxattr returns the value of the extended attribute attr of the named
+// file.
+func (b *Box) Getxattr(name, attr string) ([]byte, error) {
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "getxattr", Path: name, Err: err}
+	}
+	if ok {
+		return fs.Getxattr(vfsName, attr)
+	}
+
+	return b.osfs.Getxattr(name, attr)
+}
+
+// Setxattr sets the extended attribute attr of the named file.
+func (b *Box) Setxattr(name, attr string, value []byte) error {
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return &os.PathError{Op: "setxattr", Path: name, Err: err}
+	}
+	if ok {
+		return fs.Setxattr(vfsName, attr, value)
+	}
+
+	return b.osfs.Setxattr(name, attr, value)
+}
+
+// Listxattr returns the names of the extended attributes of the named file.
+func (b *Box) Listxattr(name string) ([]string, error) {
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "listxattr", Path: name, Err: err}
+	}
+	if ok {
+		return fs.Listxattr(vfsName)
+	}
+
+	return b.osfs.Listxattr(name)
+}
+
+// Removexattr removes the extended attribute attr of the named file.
+func (b *Box) Removexattr(name, attr string) error {
+	fs, vfsName, ok, err := b.resolveVFS(name)
+	if err != nil {
+		return &os.PathError{Op: "removexattr", Path: name, Err: err}
+	}
+	if ok {
+		return fs.Removexattr(vfsName, attr)
+	}
+
+	return b.osfs.Removexattr(name, attr)
+}